@@ -38,12 +38,40 @@ type Agent struct {
 	uGen     *unit.UnitStateGenerator
 	Machine  machine.Machine
 	ttl      time.Duration
+	// interval is the time between heartbeats, half the ttl unless set
+	// otherwise
+	interval time.Duration
 
 	cache *agentCache
 }
 
 func New(mgr unit.UnitManager, uGen *unit.UnitStateGenerator, reg registry.Registry, mach machine.Machine, ttl time.Duration) *Agent {
-	return &Agent{reg, mgr, uGen, mach, ttl, &agentCache{}}
+	return &Agent{reg, mgr, uGen, mach, ttl, ttl / 2, &agentCache{}}
+}
+
+// SetTTL sets the TTL with which the heartbeats of the Units the Agent has
+// launched are published. It takes effect when the Agent next starts
+// heartbeating.
+func (a *Agent) SetTTL(ttl time.Duration) {
+	a.ttl = ttl
+}
+
+// SetHeartbeatInterval sets the time between heartbeats of the Units the
+// Agent has launched, which must be less than the Agent's TTL
+func (a *Agent) SetHeartbeatInterval(ival time.Duration) {
+	a.interval = ival
+}
+
+// ValidateHeartbeat returns an error unless heartbeats sent at the given
+// interval keep alive state published with the given TTL
+func ValidateHeartbeat(ttl, ival time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("agent TTL (%v) must be positive", ttl)
+	}
+	if ival <= 0 || ival >= ttl {
+		return fmt.Errorf("agent heartbeat interval (%v) must be positive and less than agent TTL (%v)", ival, ttl)
+	}
+	return nil
 }
 
 func (a *Agent) MarshalJSON() ([]byte, error) {
@@ -62,15 +90,10 @@ func (a *Agent) Heartbeat(stop chan bool) {
 }
 
 func (a *Agent) heartbeatJobs(ttl time.Duration, stop chan bool) {
-	heartbeat := func() {
-		machID := a.Machine.State().ID
-		launched := a.cache.launchedJobs()
-		for _, j := range launched {
-			go a.registry.UnitHeartbeat(j, machID, ttl)
-		}
+	interval := a.interval
+	if interval <= 0 {
+		interval = ttl / 2
 	}
-
-	interval := ttl / 2
 	ticker := time.Tick(interval)
 	for {
 		select {
@@ -79,11 +102,24 @@ func (a *Agent) heartbeatJobs(ttl time.Duration, stop chan bool) {
 			return
 		case <-ticker:
 			log.Debug("HeartbeatJobs tick")
-			heartbeat()
+			a.heartbeatLaunched(ttl)
 		}
 	}
 }
 
+// heartbeatLaunched heartbeats each unit the Agent has launched, other
+// than the instances of scaled templates, which have no Job to heartbeat
+func (a *Agent) heartbeatLaunched(ttl time.Duration) {
+	machID := a.Machine.State().ID
+	launched := a.cache.launchedJobs()
+	for _, j := range launched {
+		if a.cache.isInstance(j) {
+			continue
+		}
+		go a.registry.UnitHeartbeat(j, machID, ttl)
+	}
+}
+
 func (a *Agent) loadUnit(u *job.Unit) error {
 	a.cache.setTargetState(u.Name, job.JobStateLoaded)
 	a.uGen.Subscribe(u.Name)
@@ -104,8 +140,10 @@ func (a *Agent) unloadUnit(unitName string) {
 func (a *Agent) startUnit(unitName string) {
 	a.cache.setTargetState(unitName, job.JobStateLaunched)
 
-	machID := a.Machine.State().ID
-	a.registry.UnitHeartbeat(unitName, machID, a.ttl)
+	if !a.cache.isInstance(unitName) {
+		machID := a.Machine.State().ID
+		a.registry.UnitHeartbeat(unitName, machID, a.ttl)
+	}
 
 	a.um.TriggerStart(unitName)
 }
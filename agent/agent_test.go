@@ -150,3 +150,22 @@ func TestAgentLoadStartStopUnit(t *testing.T) {
 		t.Fatalf("Received unexpected collection of Units: %#v\nExpected: %#v", units, expectUnits)
 	}
 }
+
+func TestValidateHeartbeat(t *testing.T) {
+	for i, tt := range []struct {
+		ttl, ival time.Duration
+		valid     bool
+	}{
+		{30 * time.Second, 15 * time.Second, true},
+		{30 * time.Second, 29 * time.Second, true},
+		{5 * time.Second, time.Second, true},
+		{30 * time.Second, 30 * time.Second, false},
+		{30 * time.Second, time.Minute, false},
+		{30 * time.Second, 0, false},
+		{0, 0, false},
+	} {
+		if err := ValidateHeartbeat(tt.ttl, tt.ival); (err == nil) != tt.valid {
+			t.Errorf("case %d: expected valid=%t, got err=%v", i, tt.valid, err)
+		}
+	}
+}
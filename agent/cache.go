@@ -16,46 +16,84 @@ package agent
 
 import (
 	"encoding/json"
+	"sync"
 
 	"github.com/coreos/fleet/job"
 )
 
-type agentCache map[string]job.JobState
+// agentCache holds the target state of each unit the Agent has loaded. It
+// is safe for use by the concurrently running task chains of the Agent.
+type agentCache struct {
+	mu           sync.RWMutex
+	targetStates map[string]job.JobState
+
+	// instances holds the names of the instances of scaled templates
+	// the Agent expanded itself, which have no Job in the Registry
+	instances map[string]bool
+}
 
 func (ac *agentCache) MarshalJSON() ([]byte, error) {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
 	type ds struct {
 		TargetStates map[string]job.JobState
 	}
 	data := ds{
-		TargetStates: map[string]job.JobState(*ac),
+		TargetStates: ac.targetStates,
 	}
 	return json.Marshal(data)
 }
 
 func (ac *agentCache) setTargetState(jobName string, state job.JobState) {
-	(*ac)[jobName] = state
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if ac.targetStates == nil {
+		ac.targetStates = make(map[string]job.JobState)
+	}
+	ac.targetStates[jobName] = state
 }
 
 func (ac *agentCache) dropTargetState(jobName string) {
-	delete(*ac, jobName)
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	delete(ac.targetStates, jobName)
 }
 
 func (ac *agentCache) launchedJobs() []string {
-	jobs := make([]string, 0)
-	for j, ts := range *ac {
-		if ts == job.JobStateLaunched {
-			jobs = append(jobs, j)
-		}
-	}
-	return jobs
+	return ac.jobsInState(job.JobStateLaunched)
 }
 
 func (ac *agentCache) loadedJobs() []string {
+	return ac.jobsInState(job.JobStateLoaded)
+}
+
+func (ac *agentCache) jobsInState(state job.JobState) []string {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
 	jobs := make([]string, 0)
-	for j, ts := range *ac {
-		if ts == job.JobStateLoaded {
+	for j, ts := range ac.targetStates {
+		if ts == state {
 			jobs = append(jobs, j)
 		}
 	}
 	return jobs
 }
+
+// setInstances records the names of the instances of scaled templates
+// currently assigned to the Agent
+func (ac *agentCache) setInstances(names []string) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.instances = make(map[string]bool, len(names))
+	for _, name := range names {
+		ac.instances[name] = true
+	}
+}
+
+// isInstance reports whether the named unit is an instance of a scaled
+// template expanded by the Agent
+func (ac *agentCache) isInstance(name string) bool {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+	return ac.instances[name]
+}
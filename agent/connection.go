@@ -0,0 +1,148 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/pkg"
+)
+
+// ConnState describes how the Agent is getting along with the Registry
+type ConnState string
+
+const (
+	// ConnStateConnected is the state of an Agent reading its desired
+	// state from the Registry
+	ConnStateConnected = ConnState("connected")
+	// ConnStateDegraded is the state of an Agent unable to reach the
+	// Registry, which manages its loaded units from the desired state
+	// last read from it and tries the Registry again with backoff
+	ConnStateDegraded = ConnState("degraded")
+	// ConnStateResyncing is the state of an Agent that has reached the
+	// Registry again, until it has reconciled all of its units with the
+	// desired state and refreshed its heartbeats and unit states
+	ConnStateResyncing = ConnState("resyncing")
+
+	// connBackoffMin and connBackoffMax bound the time a degraded Agent
+	// waits between attempts to reach the Registry
+	connBackoffMin = reconcileInterval
+	connBackoffMax = time.Minute
+)
+
+var (
+	// agentStats is published through expvar under the "agent" key
+	agentStats = expvar.NewMap("agent")
+
+	statConnState       = new(expvar.String)
+	statConnTransitions = new(expvar.Int)
+)
+
+func init() {
+	statConnState.Set(string(ConnStateConnected))
+	agentStats.Set("connState", statConnState)
+	agentStats.Set("connTransitions", statConnTransitions)
+}
+
+// connection tracks the ConnState of an Agent, and when a degraded Agent
+// should next attempt to reach the Registry
+type connection struct {
+	mu      sync.Mutex
+	state   ConnState
+	since   time.Time
+	backoff time.Duration
+	next    time.Time
+	now     func() time.Time
+}
+
+func newConnection() *connection {
+	return &connection{
+		state: ConnStateConnected,
+		since: time.Now(),
+		now:   time.Now,
+	}
+}
+
+func (c *connection) State() ConnState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// degradedSince returns since when the Agent has been degraded, if it is
+func (c *connection) degradedSince() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.since, c.state == ConnStateDegraded
+}
+
+// shouldAttempt determines whether the Registry should be used, which a
+// degraded Agent only does once its backoff has elapsed
+func (c *connection) shouldAttempt() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state != ConnStateDegraded || !c.now().Before(c.next)
+}
+
+// failed records a failed attempt to use the Registry, doubling the
+// backoff of an Agent that was already degraded
+func (c *connection) failed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == ConnStateDegraded {
+		c.backoff = pkg.ExpBackoff(c.backoff, connBackoffMax)
+	} else {
+		c.backoff = connBackoffMin
+		c.transition(ConnStateDegraded, err)
+	}
+	c.next = c.now().Add(c.backoff)
+}
+
+// succeeded records a successful attempt to use the Registry, returning
+// true if a degraded Agent must now resynchronize
+func (c *connection) succeeded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != ConnStateDegraded {
+		return false
+	}
+	c.transition(ConnStateResyncing, nil)
+	return true
+}
+
+// resynced records that a resynchronizing Agent has reconciled its units
+func (c *connection) resynced() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == ConnStateResyncing {
+		c.transition(ConnStateConnected, nil)
+	}
+}
+
+func (c *connection) transition(to ConnState, err error) {
+	now := c.now()
+	if err != nil {
+		log.Warningf("Agent connection to Registry %s -> %s after %s: %v", c.state, to, now.Sub(c.since), err)
+	} else {
+		log.Infof("Agent connection to Registry %s -> %s after %s", c.state, to, now.Sub(c.since))
+	}
+	c.state = to
+	c.since = now
+	statConnState.Set(string(to))
+	statConnTransitions.Add(1)
+}
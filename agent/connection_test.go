@@ -0,0 +1,101 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConnection(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newConnection()
+	c.now = func() time.Time { return now }
+	unreachable := errors.New("registry unreachable")
+
+	expect := func(state ConnState, attempt bool) {
+		if got := c.State(); got != state {
+			t.Fatalf("at %v: expected state %q, got %q", now, state, got)
+		}
+		if got := c.shouldAttempt(); got != attempt {
+			t.Fatalf("at %v: expected shouldAttempt %t, got %t", now, attempt, got)
+		}
+	}
+
+	expect(ConnStateConnected, true)
+	if c.succeeded() {
+		t.Fatalf("Expected no resync while connected")
+	}
+
+	// the backoff between attempts doubles with each failure
+	c.failed(unreachable)
+	expect(ConnStateDegraded, false)
+	now = now.Add(connBackoffMin)
+	expect(ConnStateDegraded, true)
+
+	c.failed(unreachable)
+	now = now.Add(connBackoffMin)
+	expect(ConnStateDegraded, false)
+	now = now.Add(connBackoffMin)
+	expect(ConnStateDegraded, true)
+
+	// up to a maximum
+	for i := 0; i < 10; i++ {
+		c.failed(unreachable)
+	}
+	if c.backoff != connBackoffMax {
+		t.Fatalf("Expected backoff of %v, got %v", connBackoffMax, c.backoff)
+	}
+	now = now.Add(connBackoffMax)
+
+	// reaching the Registry again requires a resync before the
+	// connection is considered connected
+	if !c.succeeded() {
+		t.Fatalf("Expected resync once Registry is reachable")
+	}
+	expect(ConnStateResyncing, true)
+	c.resynced()
+	expect(ConnStateConnected, true)
+
+	// a fresh outage starts over with the minimum backoff
+	c.failed(unreachable)
+	if c.backoff != connBackoffMin {
+		t.Fatalf("Expected backoff of %v, got %v", connBackoffMin, c.backoff)
+	}
+}
+
+func TestConnectionDegradedSince(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newConnection()
+	c.now = func() time.Time { return now }
+
+	if _, ok := c.degradedSince(); ok {
+		t.Fatalf("Expected connected Agent not to be degraded")
+	}
+
+	now = now.Add(time.Minute)
+	c.failed(errors.New("registry unreachable"))
+	now = now.Add(time.Minute)
+	c.failed(errors.New("registry unreachable"))
+	if since, ok := c.degradedSince(); !ok || !since.Equal(time.Unix(60, 0)) {
+		t.Fatalf("Expected Agent degraded since the first failure, got %v (%t)", since, ok)
+	}
+
+	c.succeeded()
+	if _, ok := c.degradedSince(); ok {
+		t.Fatalf("Expected resyncing Agent not to be degraded")
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	// SubStateUnhealthy is the SubState reported, along with an
+	// ActiveState of failed, for a Unit whose HealthCheck kept failing
+	// after it was restarted as many times as allowed
+	SubStateUnhealthy = "unhealthy"
+
+	// healthCheckTick is the resolution at which HealthChecks are run
+	healthCheckTick = time.Second
+)
+
+// unitRestarter is implemented by UnitManagers able to restart a unit in
+// a single operation
+type unitRestarter interface {
+	TriggerRestart(string)
+}
+
+// HealthMonitor is a UnitManager that runs the HealthChecks declared by
+// the Units it starts. A Unit failing its HealthCheck is restarted and,
+// once it has been restarted too often, stopped and reported failed so
+// that the engine reschedules it elsewhere.
+type HealthMonitor struct {
+	unit.UnitManager
+
+	mu    sync.Mutex
+	units map[string]*unitHealth
+	check func(*job.HealthCheck) error
+	clock clockwork.Clock
+}
+
+// unitHealth tracks the HealthCheck of a single Unit
+type unitHealth struct {
+	hc       *job.HealthCheck
+	started  bool
+	checking bool
+	next     time.Time
+	failures int
+	restarts int
+	failed   bool
+}
+
+func NewHealthMonitor(um unit.UnitManager) *HealthMonitor {
+	return &HealthMonitor{
+		UnitManager: um,
+		units:       make(map[string]*unitHealth),
+		check:       runHealthCheck,
+		clock:       clockwork.NewRealClock(),
+	}
+}
+
+func (hm *HealthMonitor) Load(name string, uf unit.UnitFile) error {
+	err := hm.UnitManager.Load(name, uf)
+
+	j := &job.Job{Name: name, Unit: uf}
+	hc, herr := j.HealthCheck()
+	if herr != nil {
+		log.Errorf("Ignoring invalid health check of Unit(%s): %v", name, herr)
+	}
+
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	delete(hm.units, name)
+	if hc != nil {
+		hm.units[name] = &unitHealth{hc: hc}
+	}
+	return err
+}
+
+func (hm *HealthMonitor) Unload(name string) {
+	hm.mu.Lock()
+	delete(hm.units, name)
+	hm.mu.Unlock()
+
+	hm.UnitManager.Unload(name)
+}
+
+// TriggerStart starts the Unit afresh, forgetting about past failures of
+// its HealthCheck. It is first checked an interval after being started.
+func (hm *HealthMonitor) TriggerStart(name string) {
+	hm.mu.Lock()
+	if h, ok := hm.units[name]; ok {
+		*h = unitHealth{hc: h.hc, started: true, next: hm.clock.Now().Add(h.hc.Interval)}
+	}
+	hm.mu.Unlock()
+
+	hm.UnitManager.TriggerStart(name)
+}
+
+func (hm *HealthMonitor) TriggerStop(name string) {
+	hm.mu.Lock()
+	if h, ok := hm.units[name]; ok {
+		h.started = false
+	}
+	hm.mu.Unlock()
+
+	hm.UnitManager.TriggerStop(name)
+}
+
+func (hm *HealthMonitor) GetUnitState(name string) (*unit.UnitState, error) {
+	us, err := hm.UnitManager.GetUnitState(name)
+	if err != nil || us == nil {
+		return us, err
+	}
+	return hm.overrideState(name, us), nil
+}
+
+func (hm *HealthMonitor) GetUnitStates(filter pkg.Set) (map[string]*unit.UnitState, error) {
+	states, err := hm.UnitManager.GetUnitStates(filter)
+	if err != nil {
+		return nil, err
+	}
+	for name, us := range states {
+		states[name] = hm.overrideState(name, us)
+	}
+	return states, nil
+}
+
+// overrideState reports a Unit given up on as failed, whatever the state
+// of the stopped unit in systemd
+func (hm *HealthMonitor) overrideState(name string, us *unit.UnitState) *unit.UnitState {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	if h, ok := hm.units[name]; !ok || !h.failed {
+		return us
+	}
+	failed := *us
+	failed.ActiveState = "failed"
+	failed.SubState = SubStateUnhealthy
+	return &failed
+}
+
+// Run checks the health of the started Units as their HealthChecks fall
+// due, until the stop channel is closed
+func (hm *HealthMonitor) Run(stop chan bool) {
+	ticker := hm.clock.After(healthCheckTick)
+	for {
+		select {
+		case <-stop:
+			log.Debug("HealthMonitor exiting due to stop signal")
+			return
+		case <-ticker:
+			hm.checkDue()
+			ticker = hm.clock.After(healthCheckTick)
+		}
+	}
+}
+
+// checkDue runs the HealthChecks that have fallen due, each in its own
+// goroutine so that a slow check does not delay the others
+func (hm *HealthMonitor) checkDue() {
+	now := hm.clock.Now()
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	for name, h := range hm.units {
+		if !h.started || h.failed || h.checking || now.Before(h.next) {
+			continue
+		}
+		h.checking = true
+		go hm.checkUnit(name, h)
+	}
+}
+
+func (hm *HealthMonitor) checkUnit(name string, h *unitHealth) {
+	// a Unit not yet, or no longer, active is left to systemd
+	var err error
+	if us, serr := hm.UnitManager.GetUnitState(name); serr == nil && us != nil && us.ActiveState == "active" {
+		err = hm.check(h.hc)
+	}
+	hm.record(name, h, err)
+}
+
+// record accounts for the outcome of a HealthCheck of the named Unit,
+// restarting or giving up on the Unit if it failed too often
+func (hm *HealthMonitor) record(name string, h *unitHealth, err error) {
+	hm.mu.Lock()
+	// the Unit may have been reloaded or unloaded in the meantime
+	if hm.units[name] != h {
+		hm.mu.Unlock()
+		return
+	}
+	h.checking = false
+	h.next = hm.clock.Now().Add(h.hc.Interval)
+	if err == nil {
+		h.failures = 0
+		hm.mu.Unlock()
+		return
+	}
+
+	h.failures++
+	log.Infof("Health check of Unit(%s) failed (%d/%d): %v", name, h.failures, h.hc.Failures, err)
+	if h.failures < h.hc.Failures {
+		hm.mu.Unlock()
+		return
+	}
+	h.failures = 0
+
+	if h.restarts >= h.hc.Restarts {
+		h.failed = true
+		hm.mu.Unlock()
+		log.Errorf("Unit(%s) still unhealthy after %d restart(s), stopping it and reporting it failed", name, h.restarts)
+		hm.UnitManager.TriggerStop(name)
+		return
+	}
+
+	h.restarts++
+	h.next = hm.clock.Now().Add(h.hc.Interval)
+	hm.mu.Unlock()
+	log.Infof("Restarting unhealthy Unit(%s) (%d/%d)", name, h.restarts, h.hc.Restarts)
+	if r, ok := hm.UnitManager.(unitRestarter); ok {
+		r.TriggerRestart(name)
+	} else {
+		hm.UnitManager.TriggerStop(name)
+		hm.UnitManager.TriggerStart(name)
+	}
+}
+
+// runHealthCheck carries out a single HealthCheck, returning an error if
+// the Unit is unhealthy
+func runHealthCheck(hc *job.HealthCheck) error {
+	switch hc.Type {
+	case job.HealthCheckTCP:
+		conn, err := net.DialTimeout("tcp", hc.Target, hc.Timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case job.HealthCheckHTTP:
+		client := http.Client{
+			Timeout: hc.Timeout,
+			// the health of the Unit is checked, not its certificate
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}
+		resp, err := client.Get(hc.Target)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("unexpected response %s", resp.Status)
+		}
+		return nil
+	case job.HealthCheckExec:
+		args := strings.Fields(hc.Target)
+		cmd := exec.Command(args[0], args[1:]...)
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(hc.Timeout):
+			cmd.Process.Kill()
+			<-done
+			return errors.New("timed out")
+		}
+	}
+	return fmt.Errorf("unknown health check type %q", hc.Type)
+}
@@ -0,0 +1,153 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/unit"
+)
+
+// restartingUnitManager records the units started, stopped and restarted
+type restartingUnitManager struct {
+	*unit.FakeUnitManager
+	calls []string
+}
+
+func (rum *restartingUnitManager) TriggerStart(name string) {
+	rum.calls = append(rum.calls, "start "+name)
+}
+
+func (rum *restartingUnitManager) TriggerStop(name string) {
+	rum.calls = append(rum.calls, "stop "+name)
+}
+
+func (rum *restartingUnitManager) TriggerRestart(name string) {
+	rum.calls = append(rum.calls, "restart "+name)
+}
+
+func TestHealthMonitor(t *testing.T) {
+	um := &restartingUnitManager{FakeUnitManager: unit.NewFakeUnitManager()}
+	hm := NewHealthMonitor(um)
+	fclock := clockwork.NewFakeClock()
+	hm.clock = fclock
+
+	uf, err := unit.NewUnitFile("[X-Fleet]\nHealthCheck=tcp://127.0.0.1:8080\nHealthCheckFailures=2\nHealthCheckRestarts=1\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := hm.Load("foo.service", *uf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hm.TriggerStart("foo.service")
+
+	h := hm.units["foo.service"]
+	if !h.started || !h.next.Equal(fclock.Now().Add(job.DefaultHealthCheckInterval)) {
+		t.Fatalf("expected started unit to be checked after an interval, got %#v", h)
+	}
+
+	fail := errors.New("connection refused")
+	for _, err := range []error{fail, nil, fail, fail, fail, fail} {
+		hm.record("foo.service", h, err)
+	}
+
+	// one restart is allowed, after which the unit is stopped
+	want := []string{"start foo.service", "restart foo.service", "stop foo.service"}
+	if !reflect.DeepEqual(want, um.calls) {
+		t.Fatalf("expected calls %v, got %v", want, um.calls)
+	}
+
+	// and reported failed
+	states, err := hm.GetUnitStates(pkg.NewUnsafeSet("foo.service"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if us := states["foo.service"]; us == nil || us.ActiveState != "failed" || us.SubState != SubStateUnhealthy {
+		t.Fatalf("expected unit to be reported unhealthy, got %#v", us)
+	}
+
+	// starting the unit again gives it a fresh start
+	hm.TriggerStart("foo.service")
+	if us, _ := hm.GetUnitState("foo.service"); us == nil || us.ActiveState != "active" {
+		t.Fatalf("expected unit to be reported active, got %#v", us)
+	}
+
+	// results of checks of a unit since unloaded are ignored
+	hm.Unload("foo.service")
+	hm.record("foo.service", h, fail)
+	if _, ok := hm.units["foo.service"]; ok {
+		t.Fatalf("expected unloaded unit to be forgotten")
+	}
+}
+
+func TestHealthMonitorCheckDue(t *testing.T) {
+	um := unit.NewFakeUnitManager()
+	hm := NewHealthMonitor(um)
+	fclock := clockwork.NewFakeClock()
+	hm.clock = fclock
+
+	checked := make(chan *job.HealthCheck)
+	hm.check = func(hc *job.HealthCheck) error {
+		checked <- hc
+		return nil
+	}
+
+	uf, _ := unit.NewUnitFile("[X-Fleet]\nHealthCheck=http://127.0.0.1:8080/healthz\n")
+	hm.Load("foo.service", *uf)
+	hm.Load("bar.service", unit.UnitFile{})
+
+	// a unit not started is not checked
+	fclock.Advance(time.Hour)
+	hm.checkDue()
+	hm.TriggerStart("foo.service")
+	hm.checkDue()
+
+	fclock.Advance(job.DefaultHealthCheckInterval)
+	hm.checkDue()
+	select {
+	case hc := <-checked:
+		if hc.Target != "http://127.0.0.1:8080/healthz" {
+			t.Fatalf("unexpected health check %#v", hc)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected health check to run")
+	}
+}
+
+func TestRunHealthCheckTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	addr := l.Addr().String()
+
+	hc := &job.HealthCheck{Type: job.HealthCheckTCP, Target: addr, Timeout: time.Second}
+	if err := runHealthCheck(hc); err != nil {
+		t.Errorf("expected listening port to be healthy, got %v", err)
+	}
+
+	l.Close()
+	if err := runHealthCheck(hc); err == nil {
+		t.Errorf("expected closed port to be unhealthy")
+	}
+}
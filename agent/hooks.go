@@ -0,0 +1,154 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/unit"
+)
+
+// Hook names a point in the lifecycle of a Unit at which the agent runs
+// the executable of the same name from the configured hooks directory
+type Hook string
+
+const (
+	HookPreLoad    Hook = "pre-load"
+	HookPostStart  Hook = "post-start"
+	HookPreStop    Hook = "pre-stop"
+	HookPostUnload Hook = "post-unload"
+
+	// hookTimeout is how long a hook may run before it is killed
+	hookTimeout = 30 * time.Second
+)
+
+// HookRunner is a UnitManager that runs the executables found in a
+// directory around the lifecycle operations of each Unit. Hooks are given
+// the name of the Unit and the metadata of the local Machine in their
+// environment. A hook failing is logged, but does not stop the operation.
+type HookRunner struct {
+	unit.UnitManager
+
+	dir  string
+	mach machine.Machine
+	run  func(path string, env []string) error
+}
+
+func NewHookRunner(um unit.UnitManager, dir string, mach machine.Machine) *HookRunner {
+	return &HookRunner{
+		UnitManager: um,
+		dir:         dir,
+		mach:        mach,
+		run:         runHook,
+	}
+}
+
+func (hr *HookRunner) Load(name string, uf unit.UnitFile) error {
+	hr.hook(HookPreLoad, name)
+	return hr.UnitManager.Load(name, uf)
+}
+
+func (hr *HookRunner) Unload(name string) {
+	hr.UnitManager.Unload(name)
+	hr.hook(HookPostUnload, name)
+}
+
+// TriggerStart runs the post-start hook once the start of the Unit has
+// been triggered, which does not wait for the Unit to become active
+func (hr *HookRunner) TriggerStart(name string) {
+	hr.UnitManager.TriggerStart(name)
+	hr.hook(HookPostStart, name)
+}
+
+func (hr *HookRunner) TriggerStop(name string) {
+	hr.hook(HookPreStop, name)
+	hr.UnitManager.TriggerStop(name)
+}
+
+// hook runs the executable of the given Hook for the named Unit, if the
+// hooks directory holds one
+func (hr *HookRunner) hook(h Hook, name string) {
+	p := filepath.Join(hr.dir, string(h))
+	if fi, err := os.Stat(p); err != nil || fi.IsDir() || fi.Mode()&0111 == 0 {
+		return
+	}
+
+	log.Debugf("Running %s hook of Unit(%s)", h, name)
+	if err := hr.run(p, hr.env(h, name)); err != nil {
+		log.Errorf("Failed running %s hook of Unit(%s): %v", h, name, err)
+	}
+}
+
+// env returns the environment of a hook run for the named Unit
+func (hr *HookRunner) env(h Hook, name string) []string {
+	ms := hr.mach.State()
+	env := []string{
+		"FLEET_HOOK=" + string(h),
+		"FLEET_UNIT_NAME=" + name,
+		"FLEET_MACHINE_ID=" + ms.ID,
+		"FLEET_PUBLIC_IP=" + ms.PublicIP,
+	}
+
+	keys := make([]string, 0, len(ms.Metadata))
+	for k := range ms.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, "FLEET_METADATA_"+hookEnvKey(k)+"="+ms.Metadata[k])
+	}
+	return env
+}
+
+// hookEnvKey turns a metadata key into the suffix of an environment
+// variable name, upper-cased and with invalid characters replaced by
+// underscores
+func hookEnvKey(k string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, k)
+}
+
+func runHook(path string, env []string) error {
+	cmd := exec.Command(path)
+	cmd.Env = append(os.Environ(), env...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(hookTimeout):
+		cmd.Process.Kill()
+		<-done
+		return errors.New("timed out")
+	}
+}
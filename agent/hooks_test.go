@@ -0,0 +1,89 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestHookRunner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fleet-hooks")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// pre-stop is not executable, and post-unload is missing altogether
+	for name, mode := range map[string]os.FileMode{"pre-load": 0755, "post-start": 0755, "pre-stop": 0644} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	um := &restartingUnitManager{FakeUnitManager: unit.NewFakeUnitManager()}
+	mach := &machine.FakeMachine{MachineState: machine.MachineState{ID: "XXX", PublicIP: "192.0.2.1", Metadata: map[string]string{"region": "us-west", "rack-id": "4"}}}
+	hr := NewHookRunner(um, dir, mach)
+
+	var envs [][]string
+	hr.run = func(p string, env []string) error {
+		um.calls = append(um.calls, "hook "+filepath.Base(p))
+		envs = append(envs, env)
+		return nil
+	}
+
+	uf, _ := unit.NewUnitFile("[Service]\nExecStart=/bin/true\n")
+	hr.Load("foo.service", *uf)
+	hr.TriggerStart("foo.service")
+	hr.TriggerStop("foo.service")
+	hr.Unload("foo.service")
+
+	want := []string{"hook pre-load", "start foo.service", "hook post-start", "stop foo.service"}
+	if !reflect.DeepEqual(want, um.calls) {
+		t.Errorf("expected calls %v, got %v", want, um.calls)
+	}
+
+	wantEnv := "FLEET_HOOK=pre-load FLEET_UNIT_NAME=foo.service FLEET_MACHINE_ID=XXX FLEET_PUBLIC_IP=192.0.2.1 FLEET_METADATA_RACK_ID=4 FLEET_METADATA_REGION=us-west"
+	if len(envs) == 0 || strings.Join(envs[0], " ") != wantEnv {
+		t.Errorf("expected environment %q, got %v", wantEnv, envs)
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fleet-hooks")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "pre-load")
+	if err := ioutil.WriteFile(p, []byte("#!/bin/sh\ntest \"$FLEET_UNIT_NAME\" = foo.service\n"), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := runHook(p, []string{"FLEET_UNIT_NAME=foo.service"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := runHook(p, []string{"FLEET_UNIT_NAME=bar.service"}); err == nil {
+		t.Errorf("expected hook to fail")
+	}
+}
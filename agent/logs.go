@@ -0,0 +1,355 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	// logForwardSyncInterval is how often the LogForwarder looks for
+	// Units loaded or unloaded since it last did
+	logForwardSyncInterval = 5 * time.Second
+
+	// logSinkTimeout bounds how long a LogSink may take to accept an entry
+	logSinkTimeout = 10 * time.Second
+)
+
+// LogEntry is an entry of the journal of a Unit, as forwarded to a LogSink
+type LogEntry struct {
+	Unit      string    `json:"unit"`
+	MachineID string    `json:"machineID"`
+	Time      time.Time `json:"time"`
+	// Priority is the syslog severity of the entry, from 0 (emergency)
+	// to 7 (debug)
+	Priority int    `json:"priority"`
+	Message  string `json:"message"`
+}
+
+// LogSink receives the entries forwarded from the journals of Units
+type LogSink interface {
+	Send(LogEntry) error
+}
+
+// NewLogSink returns the LogSink described by the given URL: a syslog
+// server at syslog+udp://host:port or syslog+tcp://host:port, or an HTTP
+// endpoint at an http:// or https:// URL to which each entry is POSTed
+// as JSON.
+func NewLogSink(rawurl string) (LogSink, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "syslog+udp", "syslog+tcp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("no syslog server given in %q", rawurl)
+		}
+		return &syslogSink{network: u.Scheme[len("syslog+"):], addr: u.Host}, nil
+	case "http", "https":
+		return &httpSink{url: rawurl, hc: &http.Client{Timeout: logSinkTimeout}}, nil
+	}
+	return nil, fmt.Errorf("unsupported log sink %q", rawurl)
+}
+
+// syslogSink sends entries to a syslog server in the format of RFC 5424,
+// with the ID of the Machine as the hostname and the name of the Unit as
+// the app name. The connection is redialled after a failed write.
+type syslogSink struct {
+	network string
+	addr    string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (s *syslogSink) Send(e LogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.addr, logSinkTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(logSinkTimeout))
+	if _, err := io.WriteString(s.conn, formatSyslog(e)); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// syslogFacilityDaemon is the syslog facility entries are sent with
+const syslogFacilityDaemon = 3
+
+func formatSyslog(e LogEntry) string {
+	app := e.Unit
+	if len(app) > 48 {
+		app = app[:48]
+	}
+	host := e.MachineID
+	if host == "" {
+		host = "-"
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s - - - %s\n",
+		syslogFacilityDaemon*8+e.Priority, e.Time.UTC().Format(time.RFC3339Nano), host, app, e.Message)
+}
+
+// httpSink POSTs each entry as JSON to an HTTP endpoint
+type httpSink struct {
+	url string
+	hc  *http.Client
+}
+
+func (s *httpSink) Send(e LogEntry) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := s.hc.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	return nil
+}
+
+// LogForwarder follows the journal of each Unit loaded by the agent,
+// forwarding its entries to a LogSink tagged with the name of the Unit and
+// the ID of the local Machine. Only entries written once a Unit is followed
+// are forwarded. Entries the LogSink fails to accept are dropped.
+type LogForwarder struct {
+	um     unit.UnitManager
+	sink   LogSink
+	machID string
+	follow func(name string) (io.ReadCloser, error)
+
+	mu        sync.Mutex
+	followers map[string]io.ReadCloser
+}
+
+func NewLogForwarder(um unit.UnitManager, sink LogSink, machID string) *LogForwarder {
+	return &LogForwarder{
+		um:        um,
+		sink:      sink,
+		machID:    machID,
+		follow:    followJournal,
+		followers: make(map[string]io.ReadCloser),
+	}
+}
+
+// Run follows the journals of the loaded Units until the stop channel is
+// closed
+func (lf *LogForwarder) Run(stop chan bool) {
+	lf.Sync()
+	ticker := time.NewTicker(logForwardSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			log.Debug("LogForwarder exiting due to stop signal")
+			lf.stopAll()
+			return
+		case <-ticker.C:
+			lf.Sync()
+		}
+	}
+}
+
+// Sync starts following the journals of Units loaded since it was last
+// called, and stops following those of Units since unloaded
+func (lf *LogForwarder) Sync() {
+	names, err := lf.um.Units()
+	if err != nil {
+		log.Errorf("Failed listing units to forward logs of: %v", err)
+		return
+	}
+
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	loaded := make(map[string]bool, len(names))
+	for _, name := range names {
+		loaded[name] = true
+		if _, ok := lf.followers[name]; ok {
+			continue
+		}
+		r, err := lf.follow(name)
+		if err != nil {
+			log.Errorf("Failed following journal of Unit(%s): %v", name, err)
+			continue
+		}
+		lf.followers[name] = r
+		go lf.forward(name, r)
+	}
+
+	for name, r := range lf.followers {
+		if !loaded[name] {
+			r.Close()
+			delete(lf.followers, name)
+		}
+	}
+}
+
+func (lf *LogForwarder) stopAll() {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	for name, r := range lf.followers {
+		r.Close()
+		delete(lf.followers, name)
+	}
+}
+
+// forward sends the entries read from the journal of the named Unit to
+// the LogSink until the reader is exhausted or closed
+func (lf *LogForwarder) forward(name string, r io.ReadCloser) {
+	var failing bool
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		e, err := parseJournalEntry(scanner.Bytes())
+		if err != nil {
+			log.Debugf("Skipping unparseable journal entry of Unit(%s): %v", name, err)
+			continue
+		}
+		e.Unit = name
+		e.MachineID = lf.machID
+
+		// a failing sink is only logged about once until it recovers
+		if err := lf.sink.Send(*e); err != nil {
+			if !failing {
+				log.Errorf("Failed forwarding logs of Unit(%s): %v", name, err)
+			}
+			failing = true
+		} else {
+			failing = false
+		}
+	}
+
+	// a follower ending while its Unit is loaded is started afresh on
+	// the next Sync
+	lf.mu.Lock()
+	if cur, ok := lf.followers[name]; ok && cur == r {
+		delete(lf.followers, name)
+		cur.Close()
+	}
+	lf.mu.Unlock()
+}
+
+// parseJournalEntry parses an entry of the journal in the JSON output
+// format of journalctl
+func parseJournalEntry(b []byte) (*LogEntry, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+
+	e := LogEntry{Priority: 6}
+	switch msg := fields["MESSAGE"].(type) {
+	case string:
+		e.Message = msg
+	case []interface{}:
+		// messages that are not valid UTF-8 are given as arrays of bytes
+		buf := make([]byte, 0, len(msg))
+		for _, v := range msg {
+			if f, ok := v.(float64); ok {
+				buf = append(buf, byte(f))
+			}
+		}
+		e.Message = string(buf)
+	default:
+		return nil, errors.New("entry has no message")
+	}
+
+	if s, ok := fields["PRIORITY"].(string); ok {
+		if p, err := strconv.Atoi(s); err == nil && p >= 0 && p <= 7 {
+			e.Priority = p
+		}
+	}
+	if s, ok := fields["__REALTIME_TIMESTAMP"].(string); ok {
+		if usec, err := strconv.ParseInt(s, 10, 64); err == nil {
+			e.Time = time.Unix(0, usec*int64(time.Microsecond))
+		}
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	return &e, nil
+}
+
+// journalFollower is the output of journalctl following the journal of a
+// Unit, which is killed when closed
+type journalFollower struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (jf *journalFollower) Close() error {
+	jf.cmd.Process.Kill()
+	return jf.cmd.Wait()
+}
+
+// SetUserUnits determines whether the journals followed are those of
+// units of the user instance of systemd
+func (lf *LogForwarder) SetUserUnits(user bool) {
+	if user {
+		lf.follow = followUserJournal
+	} else {
+		lf.follow = followJournal
+	}
+}
+
+func followJournal(name string) (io.ReadCloser, error) {
+	return startJournalFollower("--unit", name)
+}
+
+func followUserJournal(name string) (io.ReadCloser, error) {
+	return startJournalFollower("--user-unit", name)
+}
+
+func startJournalFollower(flag, name string) (io.ReadCloser, error) {
+	cmd := exec.Command("journalctl", flag, name, "--follow", "--lines=0", "--output=json")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &journalFollower{ReadCloser: out, cmd: cmd}, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/unit"
+)
+
+type fakeLogSink struct {
+	mu      sync.Mutex
+	entries []LogEntry
+}
+
+func (s *fakeLogSink) Send(e LogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+func (s *fakeLogSink) Entries() []LogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]LogEntry(nil), s.entries...)
+}
+
+func TestLogForwarder(t *testing.T) {
+	um := unit.NewFakeUnitManager()
+	um.Load("foo.service", unit.UnitFile{})
+
+	sink := &fakeLogSink{}
+	lf := NewLogForwarder(um, sink, "XXX")
+
+	writers := make(map[string]*io.PipeWriter)
+	lf.follow = func(name string) (io.ReadCloser, error) {
+		r, w := io.Pipe()
+		writers[name] = w
+		return r, nil
+	}
+
+	lf.Sync()
+	if len(writers) != 1 || writers["foo.service"] == nil {
+		t.Fatalf("Expected foo.service to be followed, got %v", writers)
+	}
+
+	io.WriteString(writers["foo.service"], `{"MESSAGE":"hello","PRIORITY":"3","__REALTIME_TIMESTAMP":"1000000"}`+"\n")
+	io.WriteString(writers["foo.service"], "not json\n")
+	io.WriteString(writers["foo.service"], `{"MESSAGE":[104,105]}`+"\n")
+
+	// entries are forwarded asynchronously
+	for i := 0; i < 100 && len(sink.Entries()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a Unit unloaded stops being followed
+	um.Unload("foo.service")
+	lf.Sync()
+	if _, err := io.WriteString(writers["foo.service"], "{}\n"); err != io.ErrClosedPipe {
+		t.Errorf("Expected follower of unloaded Unit to be closed, got %v", err)
+	}
+
+	got := sink.Entries()
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 entries, got %#v", got)
+	}
+	want := LogEntry{Unit: "foo.service", MachineID: "XXX", Time: time.Unix(1, 0), Priority: 3, Message: "hello"}
+	if !reflect.DeepEqual(want, got[0]) {
+		t.Errorf("Expected entry %#v, got %#v", want, got[0])
+	}
+	if got[1].Message != "hi" || got[1].Priority != 6 {
+		t.Errorf("Expected binary message with default priority, got %#v", got[1])
+	}
+}
+
+func TestNewLogSink(t *testing.T) {
+	for _, u := range []string{"syslog+udp://10.0.0.1:514", "syslog+tcp://logs:601", "http://logs/ingest", "https://logs/ingest"} {
+		if _, err := NewLogSink(u); err != nil {
+			t.Errorf("%s: unexpected error: %v", u, err)
+		}
+	}
+	for _, u := range []string{"syslog+udp://", "file:///var/log/fleet", "::"} {
+		if _, err := NewLogSink(u); err == nil {
+			t.Errorf("%s: expected error", u)
+		}
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed listening: %v", err)
+	}
+	defer pc.Close()
+
+	sink, err := NewLogSink("syslog+udp://" + pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	e := LogEntry{Unit: "foo.service", MachineID: "XXX", Time: time.Unix(1, 0), Priority: 3, Message: "hello"}
+	if err := sink.Send(e); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed reading: %v", err)
+	}
+	if want := "<27>1 1970-01-01T00:00:01Z XXX foo.service - - - hello\n"; string(buf[:n]) != want {
+		t.Errorf("Expected %q, got %q", want, buf[:n])
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var got LogEntry
+	code := http.StatusNoContent
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		json.NewDecoder(req.Body).Decode(&got)
+		rw.WriteHeader(code)
+	}))
+	defer ts.Close()
+
+	sink, err := NewLogSink(ts.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	e := LogEntry{Unit: "foo.service", MachineID: "XXX", Time: time.Unix(1, 0).UTC(), Priority: 3, Message: "hello"}
+	if err := sink.Send(e); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(e, got) {
+		t.Errorf("Expected entry %#v, got %#v", e, got)
+	}
+
+	code = http.StatusInternalServerError
+	if err := sink.Send(e); err == nil {
+		t.Errorf("Expected error from failing endpoint")
+	}
+}
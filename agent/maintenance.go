@@ -0,0 +1,118 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+)
+
+const (
+	// maintenanceCheckInterval is how often the MaintenanceMonitor checks
+	// whether a maintenance window has opened or closed
+	maintenanceCheckInterval = 30 * time.Second
+)
+
+// MaintenanceMonitor drains the local Machine for the length of each of its
+// maintenance windows, by setting its Schedulability to Maintenance as a
+// window opens and back to Schedulable once it has closed. A Machine
+// cordoned or drained by an operator is left alone as a window opens, and
+// a Machine uncordoned by an operator during a window stays schedulable.
+type MaintenanceMonitor struct {
+	reg     registry.Registry
+	mach    machine.Machine
+	windows []machine.MaintenanceWindow
+	clock   clockwork.Clock
+
+	// open is whether a window was open at the last check
+	open bool
+}
+
+func NewMaintenanceMonitor(reg registry.Registry, mach machine.Machine, windows []machine.MaintenanceWindow) *MaintenanceMonitor {
+	return &MaintenanceMonitor{
+		reg:     reg,
+		mach:    mach,
+		windows: windows,
+		clock:   clockwork.NewRealClock(),
+	}
+}
+
+// Run checks the maintenance windows of the Machine until the stop
+// channel is closed
+func (mm *MaintenanceMonitor) Run(stop chan bool) {
+	mm.Check()
+	for {
+		select {
+		case <-stop:
+			log.Debug("MaintenanceMonitor exiting due to stop signal")
+			return
+		case <-mm.clock.After(maintenanceCheckInterval):
+			mm.Check()
+		}
+	}
+}
+
+// Check sets the Schedulability of the Machine according to whether one of
+// its maintenance windows is open. A Machine left in maintenance, such as
+// by fleetd being stopped during a window, is made schedulable again once
+// no window is open.
+func (mm *MaintenanceMonitor) Check() {
+	open := machine.InMaintenance(mm.windows, mm.clock.Now())
+	opened := open && !mm.open
+
+	machID := mm.mach.State().ID
+	cur, err := mm.schedulability(machID)
+	if err != nil {
+		log.Errorf("Failed fetching schedulability of local Machine: %v", err)
+		return
+	}
+
+	var next machine.Schedulability
+	switch {
+	case opened && cur == machine.Schedulable:
+		next = machine.Maintenance
+		log.Infof("Maintenance window opened, draining local Machine")
+	case !open && cur == machine.Maintenance:
+		next = machine.Schedulable
+		log.Infof("Maintenance window closed, local Machine is schedulable again")
+	default:
+		mm.open = open
+		return
+	}
+
+	if err := mm.reg.SetMachineSchedulability(machID, next); err != nil {
+		log.Errorf("Failed setting schedulability of local Machine to %q: %v", next, err)
+		return
+	}
+	mm.open = open
+}
+
+func (mm *MaintenanceMonitor) schedulability(machID string) (machine.Schedulability, error) {
+	machines, err := mm.reg.Machines()
+	if err != nil {
+		return machine.Schedulable, err
+	}
+	for _, ms := range machines {
+		if ms.ID == machID {
+			return ms.Schedulability, nil
+		}
+	}
+	return machine.Schedulable, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+)
+
+func TestMaintenanceMonitor(t *testing.T) {
+	fc := clockwork.NewFakeClock()
+	now := fc.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	// a daily window opening an hour from now
+	windows := []machine.MaintenanceWindow{{Daily: true, Start: now.Sub(midnight) + time.Hour, Duration: time.Hour}}
+
+	reg := registry.NewFakeRegistry()
+	reg.SetMachines([]machine.MachineState{{ID: "XXX"}})
+	mm := NewMaintenanceMonitor(reg, &machine.FakeMachine{MachineState: machine.MachineState{ID: "XXX"}}, windows)
+	mm.clock = fc
+
+	check := func(desc string, advance time.Duration, want machine.Schedulability) {
+		fc.Advance(advance)
+		mm.Check()
+		machines, _ := reg.Machines()
+		if got := machines[0].Schedulability; got != want {
+			t.Errorf("%s: expected schedulability %q, got %q", desc, want, got)
+		}
+	}
+
+	check("before window", 0, machine.Schedulable)
+	check("window opened", time.Hour, machine.Maintenance)
+	check("window closed", time.Hour, machine.Schedulable)
+
+	// an operator uncordoning the Machine during a window has the last say
+	check("window opened again", 23*time.Hour, machine.Maintenance)
+	reg.SetMachineSchedulability("XXX", machine.Schedulable)
+	check("uncordoned during window", time.Minute, machine.Schedulable)
+	check("window closed again", time.Hour, machine.Schedulable)
+
+	// and a Machine cordoned by an operator stays cordoned
+	reg.SetMachineSchedulability("XXX", machine.Cordoned)
+	check("cordoned window opened", 23*time.Hour, machine.Cordoned)
+	check("cordoned window closed", time.Hour, machine.Cordoned)
+
+	// a Machine left in maintenance after its window is made schedulable
+	reg.SetMachineSchedulability("XXX", machine.Maintenance)
+	check("left in maintenance", time.Minute, machine.Schedulable)
+}
@@ -0,0 +1,219 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/fleet/unit"
+)
+
+// UnitPolicy restricts the directives of the Units the agent loads, as set
+// out by cluster operators in a file of the form of a unit file:
+//
+//	[Policy]
+//	AllowDirective=Service.*
+//	DenyDirective=Service.BindPaths
+//	DenyDirective=Mount.*
+//	RequireDirective=Service.NoNewPrivileges=true
+//	DenyExec=/usr/bin/docker
+//
+// Directives are named by their section and option, each of which may be
+// a shell pattern. Once any directive is allowed, all others but those of
+// the [X-Fleet] section are denied. A required directive applies to the
+// Units of the type matching its section, or to all Units for the [Unit]
+// and [Install] sections. Denied executables are patterns matched against
+// the commands of all Exec options.
+type UnitPolicy struct {
+	allow    []directivePattern
+	deny     []directivePattern
+	require  []requiredDirective
+	denyExec []string
+}
+
+type directivePattern struct {
+	section string
+	name    string
+}
+
+func (dp directivePattern) matches(section, name string) bool {
+	s, _ := filepath.Match(dp.section, section)
+	n, _ := filepath.Match(dp.name, name)
+	return s && n
+}
+
+type requiredDirective struct {
+	section string
+	name    string
+	value   string
+}
+
+// appliesTo reports whether the directive is required of the named Unit
+func (rd requiredDirective) appliesTo(name string) bool {
+	if rd.section == "Unit" || rd.section == "Install" {
+		return true
+	}
+	return "."+strings.ToLower(rd.section) == path.Ext(name)
+}
+
+// NewUnitPolicyFromFile reads the UnitPolicy in the given file
+func NewUnitPolicyFromFile(file string) (*UnitPolicy, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p, err := ParseUnitPolicy(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("invalid unit policy %s: %v", file, err)
+	}
+	return p, nil
+}
+
+// ParseUnitPolicy parses a UnitPolicy
+func ParseUnitPolicy(contents string) (*UnitPolicy, error) {
+	uf, err := unit.NewUnitFile(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	var p UnitPolicy
+	for _, opt := range uf.Options {
+		if opt.Section != "Policy" {
+			return nil, fmt.Errorf("unknown section %q", opt.Section)
+		}
+		switch opt.Name {
+		case "AllowDirective", "DenyDirective":
+			dp, err := parseDirectivePattern(opt.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", opt.Name, err)
+			}
+			if opt.Name == "AllowDirective" {
+				p.allow = append(p.allow, dp)
+			} else {
+				p.deny = append(p.deny, dp)
+			}
+		case "RequireDirective":
+			parts := strings.SplitN(opt.Value, "=", 2)
+			section, name, ok := splitDirective(parts[0])
+			if !ok || len(parts) != 2 || strings.ContainsAny(parts[0], "*?[") {
+				return nil, fmt.Errorf("%s: %q is not of the form Section.Option=value", opt.Name, opt.Value)
+			}
+			p.require = append(p.require, requiredDirective{section: section, name: name, value: parts[1]})
+		case "DenyExec":
+			if !filepath.IsAbs(opt.Value) {
+				return nil, fmt.Errorf("%s: %q is not an absolute path", opt.Name, opt.Value)
+			}
+			if _, err := filepath.Match(opt.Value, ""); err != nil {
+				return nil, fmt.Errorf("%s: %v", opt.Name, err)
+			}
+			p.denyExec = append(p.denyExec, opt.Value)
+		default:
+			return nil, fmt.Errorf("unknown option %q", opt.Name)
+		}
+	}
+	return &p, nil
+}
+
+func parseDirectivePattern(s string) (directivePattern, error) {
+	section, name, ok := splitDirective(s)
+	if !ok {
+		return directivePattern{}, fmt.Errorf("%q is not of the form Section.Option", s)
+	}
+	for _, pat := range []string{section, name} {
+		if _, err := filepath.Match(pat, ""); err != nil {
+			return directivePattern{}, fmt.Errorf("%q: %v", s, err)
+		}
+	}
+	return directivePattern{section: section, name: name}, nil
+}
+
+// splitDirective splits a directive of the form Section.Option, where the
+// section may itself contain dots, as in X-Fleet sections
+func splitDirective(s string) (section, name string, ok bool) {
+	i := strings.LastIndex(s, ".")
+	if i <= 0 || i == len(s)-1 {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+// Check returns an error describing the first violation of the UnitPolicy
+// by the given Unit, if any
+func (p *UnitPolicy) Check(name string, uf unit.UnitFile) error {
+	for _, opt := range uf.Options {
+		for _, dp := range p.deny {
+			if dp.matches(opt.Section, opt.Name) {
+				return fmt.Errorf("unit policy denies directive %s.%s", opt.Section, opt.Name)
+			}
+		}
+		if len(p.allow) > 0 && opt.Section != "X-Fleet" && !p.allowed(opt.Section, opt.Name) {
+			return fmt.Errorf("unit policy does not allow directive %s.%s", opt.Section, opt.Name)
+		}
+		if strings.HasPrefix(opt.Name, "Exec") {
+			exe := commandExecutable(opt.Value)
+			for _, pat := range p.denyExec {
+				if ok, _ := filepath.Match(pat, exe); ok {
+					return fmt.Errorf("unit policy denies executable %s in %s.%s", exe, opt.Section, opt.Name)
+				}
+			}
+		}
+	}
+
+	for _, rd := range p.require {
+		if !rd.appliesTo(name) {
+			continue
+		}
+		values := uf.Contents[rd.section][rd.name]
+		if len(values) == 0 || !sameValue(values[len(values)-1], rd.value) {
+			return fmt.Errorf("unit policy requires directive %s.%s=%s", rd.section, rd.name, rd.value)
+		}
+	}
+	return nil
+}
+
+func (p *UnitPolicy) allowed(section, name string) bool {
+	for _, dp := range p.allow {
+		if dp.matches(section, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameValue reports whether two values of a directive are the same, with
+// the different spellings of booleans systemd accepts considered equal
+func sameValue(a, b string) bool {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	if ba, ok := parseSystemdBool(a); ok {
+		if bb, ok := parseSystemdBool(b); ok {
+			return ba == bb
+		}
+	}
+	return a == b
+}
+
+func parseSystemdBool(s string) (value, ok bool) {
+	switch strings.ToLower(s) {
+	case "1", "yes", "true", "on":
+		return true, true
+	case "0", "no", "false", "off":
+		return false, true
+	}
+	return false, false
+}
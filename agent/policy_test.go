@@ -0,0 +1,85 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"testing"
+)
+
+func TestParseUnitPolicy(t *testing.T) {
+	for i, tt := range []struct {
+		contents string
+		valid    bool
+	}{
+		{"[Policy]\nAllowDirective=Service.*\nDenyDirective=X-Docker.Volume\nRequireDirective=Service.PrivateTmp=yes\nDenyExec=/usr/bin/*\n", true},
+		{"", true},
+
+		{"[Policy]\nDenyDirective=BindPaths\n", false},
+		{"[Policy]\nDenyDirective=Service.\n", false},
+		{"[Policy]\nDenyDirective=Service.[\n", false},
+		{"[Policy]\nRequireDirective=Service.PrivateTmp\n", false},
+		{"[Policy]\nRequireDirective=Service.Private*=yes\n", false},
+		{"[Policy]\nDenyExec=docker\n", false},
+		{"[Policy]\nDenyCommand=/usr/bin/docker\n", false},
+		{"[Service]\nDenyDirective=Service.BindPaths\n", false},
+	} {
+		_, err := ParseUnitPolicy(tt.contents)
+		if (err == nil) != tt.valid {
+			t.Errorf("case %d: expected valid=%t, got error %v", i, tt.valid, err)
+		}
+	}
+}
+
+func TestUnitPolicyCheck(t *testing.T) {
+	p, err := ParseUnitPolicy(`[Policy]
+AllowDirective=Unit.*
+AllowDirective=Service.*
+AllowDirective=X-Docker.*
+DenyDirective=Service.BindPaths
+DenyDirective=X-Docker.Volume
+RequireDirective=Service.NoNewPrivileges=true
+DenyExec=/usr/bin/docker
+DenyExec=/opt/*/bin/*
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i, tt := range []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{"foo.service", "[Unit]\nDescription=foo\n[Service]\nExecStart=/usr/bin/app\nNoNewPrivileges=yes\n[X-Fleet]\nGlobal=true", ""},
+		// requirements only apply to units of the type of their section
+		{"foo.socket", "[Unit]\nDescription=foo\n", ""},
+
+		{"foo.service", "[Service]\nExecStart=/usr/bin/app\nNoNewPrivileges=true\nBindPaths=/etc", "unit policy denies directive Service.BindPaths"},
+		{"foo.service", "[Service]\nNoNewPrivileges=true\n[X-Docker]\nImage=redis\nVolume=/:/host", "unit policy denies directive X-Docker.Volume"},
+		{"foo.mount", "[Mount]\nWhat=/dev/sda1\nWhere=/mnt", "unit policy does not allow directive Mount.What"},
+		{"foo.service", "[Service]\nExecStart=/usr/bin/app", "unit policy requires directive Service.NoNewPrivileges=true"},
+		{"foo.service", "[Service]\nExecStart=/usr/bin/app\nNoNewPrivileges=true\nNoNewPrivileges=false", "unit policy requires directive Service.NoNewPrivileges=true"},
+		{"foo.service", "[Service]\nExecStartPre=-/usr/bin/docker pull redis\nNoNewPrivileges=true", "unit policy denies executable /usr/bin/docker in Service.ExecStartPre"},
+		{"foo.service", "[Service]\nExecStart=/opt/app/bin/app\nNoNewPrivileges=true", "unit policy denies executable /opt/app/bin/app in Service.ExecStart"},
+	} {
+		var got string
+		if err := p.Check(tt.name, newUF(t, tt.contents)); err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("case %d: expected %q, got %q", i, tt.want, got)
+		}
+	}
+}
@@ -16,13 +16,15 @@ package agent
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/coreos/fleet/job"
 	"github.com/coreos/fleet/log"
-	"github.com/coreos/fleet/machine"
 	"github.com/coreos/fleet/pkg"
 	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/sign"
+	"github.com/coreos/fleet/unit"
 )
 
 const (
@@ -34,7 +36,8 @@ func NewReconciler(reg registry.Registry, rStream pkg.EventStream) *AgentReconci
 	return &AgentReconciler{
 		reg:      reg,
 		rStream:  rStream,
-		tManager: newTaskManager(),
+		tManager: newTaskManager(DefaultTaskWorkers),
+		conn:     newConnection(),
 	}
 }
 
@@ -42,15 +45,115 @@ type AgentReconciler struct {
 	reg      registry.Registry
 	rStream  pkg.EventStream
 	tManager *taskManager
+
+	// observeOnly has the tasks needed to reconcile the Agent logged
+	// rather than carried out
+	observeOnly bool
+	// observed holds the task chains last logged while observing only,
+	// indexed by unit name, so that each is logged again only once it
+	// changes
+	observed map[string]string
+	// loadCheck, if set, is applied to the units the Agent would load
+	// while observing only, so that those it would refuse are reported
+	loadCheck func(string, unit.UnitFile) error
+
+	// verifier, if set, is given the Signatures of the units desired of
+	// the Agent, read along with them
+	verifier *SignatureVerifier
+
+	// lastUnits holds the units most recently found to be desired of the
+	// Agent, and lastSignatures their Signatures, used in place of the
+	// Registry while it is unreachable
+	lastUnits      map[string]*job.Unit
+	lastSignatures map[string][]sign.Signature
+	// stateFile, if set, persists lastUnits and lastSignatures across
+	// restarts of fleetd
+	stateFile *stateFile
+	// conn tracks whether the Registry is reachable
+	conn *connection
+	// resyncFuncs are called once the Registry is reachable again
+	resyncFuncs []func()
+
+	// reconciled is when Run last completed a reconciliation, or was
+	// started
+	mu         sync.Mutex
+	reconciled time.Time
+}
+
+// ConnState returns the state of the AgentReconciler's connection to the
+// Registry
+func (ar *AgentReconciler) ConnState() ConnState {
+	return ar.conn.State()
+}
+
+// DegradedSince returns since when the AgentReconciler has been unable to
+// reach the Registry, if it is degraded
+func (ar *AgentReconciler) DegradedSince() (time.Time, bool) {
+	return ar.conn.degradedSince()
+}
+
+// LastReconciled returns when Run last completed a reconciliation or, if
+// it has not yet completed any, when it was started
+func (ar *AgentReconciler) LastReconciled() time.Time {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	return ar.reconciled
+}
+
+func (ar *AgentReconciler) markReconciled(t time.Time) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	ar.reconciled = t
+}
+
+// SetObserveOnly determines whether the AgentReconciler merely logs the
+// tasks it would carry out, leaving the local units untouched
+func (ar *AgentReconciler) SetObserveOnly(observe bool) {
+	ar.observeOnly = observe
+}
+
+// SetLoadCheck has the AgentReconciler, while observing only, pass each
+// unit it would load to the given function, such as UnitValidator.Check,
+// and log why the unit would be refused
+func (ar *AgentReconciler) SetLoadCheck(check func(name string, uf unit.UnitFile) error) {
+	ar.loadCheck = check
+}
+
+// SetSignatureVerifier has the AgentReconciler read the Signatures of the
+// units desired of the Agent along with them, and hand them to the given
+// SignatureVerifier before the units are loaded
+func (ar *AgentReconciler) SetSignatureVerifier(sv *SignatureVerifier) {
+	ar.verifier = sv
+}
+
+// SetTaskWorkers sets the number of task chains the AgentReconciler carries
+// out at once
+func (ar *AgentReconciler) SetTaskWorkers(n int) {
+	ar.tManager.slots = make(chan struct{}, n)
+}
+
+// SetStateFile has the AgentReconciler persist the desired state of the
+// Agent to the given path, from which it is read back should the Registry
+// be unreachable when fleetd starts
+func (ar *AgentReconciler) SetStateFile(path string) {
+	ar.stateFile = newStateFile(path)
+}
+
+// OnResync registers a function to be called whenever the Registry becomes
+// reachable again after an outage, such as to republish unit states
+func (ar *AgentReconciler) OnResync(f func()) {
+	ar.resyncFuncs = append(ar.resyncFuncs, f)
 }
 
 // Run periodically attempts to reconcile the provided Agent until the stop
 // channel is closed. Run will also reconcile in reaction to events on the
 // AgentReconciler's rStream.
 func (ar *AgentReconciler) Run(a *Agent, stop chan bool) {
+	ar.markReconciled(time.Now())
 	reconcile := func() {
 		start := time.Now()
 		ar.Reconcile(a)
+		ar.markReconciled(time.Now())
 		elapsed := time.Now().Sub(start)
 
 		msg := fmt.Sprintf("AgentReconciler completed reconciliation in %s", elapsed)
@@ -65,35 +168,145 @@ func (ar *AgentReconciler) Run(a *Agent, stop chan bool) {
 }
 
 // Reconcile drives the local Agent's state towards the desired state
-// stored in the Registry.
+// stored in the Registry. While the Registry is unreachable, the units
+// already loaded by the Agent are driven towards the desired state last
+// read from it, and no units are loaded or unloaded. Once the Registry is
+// reachable again, all units are reconciled before the Agent is considered
+// connected again. Task chains are carried out concurrently, ordered by the
+// dependencies among the units they act on.
 func (ar *AgentReconciler) Reconcile(a *Agent) {
-	dAgentState, err := desiredAgentState(a, ar.reg)
-	if err != nil {
-		log.Errorf("Unable to determine agent's desired state: %v", err)
+	// the units previously desired are kept, as units no longer desired
+	// are stopped in the order given by their unit files
+	prevUnits := ar.lastUnits
+	dAgentState, offline := ar.desiredState(a)
+	if dAgentState == nil {
 		return
 	}
 
+	if ar.verifier != nil {
+		ar.verifier.setSignatures(dAgentState.Signatures)
+	}
+
 	cAgentState, err := a.units()
 	if err != nil {
 		log.Errorf("Unable to determine agent's current state: %v", err)
 		return
 	}
 
+	var tcs []taskChain
 	for tc := range ar.calculateTaskChainsForUnits(dAgentState, cAgentState) {
+		_, loaded := cAgentState[tc.unit.Name]
+		if offline && (!loaded || dAgentState.Units[tc.unit.Name] == nil) {
+			log.Debugf("AgentReconciler skipping task chain %s while Registry is unreachable", tc)
+			continue
+		}
+		tcs = append(tcs, tc)
+	}
+	for _, tc := range orderTaskChains(tcs, prevUnits) {
 		ar.launchTaskChain(tc, a)
 	}
+	if ar.observeOnly {
+		ar.forgetObserved(tcs)
+	}
+
+	if !offline {
+		ar.conn.resynced()
+	}
+}
+
+// desiredState determines the desired state of the Agent from the Registry,
+// falling back to the desired state last read from it should the Registry
+// be unreachable, in which case the returned bool is true. While degraded,
+// the Registry is only tried again once the connection's backoff elapses.
+// A nil AgentState is returned if no desired state is available.
+func (ar *AgentReconciler) desiredState(a *Agent) (*AgentState, bool) {
+	if !ar.conn.shouldAttempt() {
+		return ar.cachedState(a), true
+	}
+
+	dAgentState, err := desiredAgentState(a, ar.reg)
+	if err == nil && ar.verifier != nil {
+		if dAgentState.Signatures, err = unitSignatures(ar.reg, dAgentState.Units); err != nil {
+			log.Errorf("Failed fetching signatures of Units from Registry: %v", err)
+		}
+	}
+	if err != nil {
+		ar.conn.failed(err)
+		return ar.cachedState(a), true
+	}
+
+	if ar.conn.succeeded() {
+		ar.resync(a)
+	}
+
+	ar.lastUnits = dAgentState.Units
+	ar.lastSignatures = dAgentState.Signatures
+	if ar.stateFile != nil {
+		if err := ar.stateFile.save(dAgentState); err != nil {
+			log.Errorf("Failed persisting agent's desired state to %s: %v", ar.stateFile.path, err)
+		}
+	}
+	return dAgentState, false
 }
 
-// Purge attempts to unload all Units that have been loaded locally
-func (ar *AgentReconciler) Purge(a *Agent) {
+// cachedState returns an AgentState holding the units last found to be
+// desired of the Agent, along with their Signatures, or nil if none are
+// known
+func (ar *AgentReconciler) cachedState(a *Agent) *AgentState {
+	units := ar.cachedUnits()
+	if units == nil {
+		log.Errorf("Unable to determine agent's desired state: no desired state known while Registry is unreachable")
+		return nil
+	}
+	ms := a.Machine.State()
+	return &AgentState{MState: &ms, Units: units, Signatures: ar.lastSignatures}
+}
+
+// cachedUnits returns the units last found to be desired of the Agent,
+// reading them and their Signatures from the state file if none have been
+// found since fleetd started. A nil map is returned if none are known.
+func (ar *AgentReconciler) cachedUnits() map[string]*job.Unit {
+	if ar.lastUnits == nil && ar.stateFile != nil {
+		units, sigs, err := ar.stateFile.load()
+		if err != nil {
+			log.Errorf("Failed reading agent's desired state from %s: %v", ar.stateFile.path, err)
+			return nil
+		}
+		ar.lastUnits = units
+		ar.lastSignatures = sigs
+	}
+	return ar.lastUnits
+}
+
+// resync brings the Registry up to date with the Agent after an outage,
+// heartbeating the launched units at once rather than waiting for the next
+// heartbeat, so that the engine does not reschedule them
+func (ar *AgentReconciler) resync(a *Agent) {
+	a.heartbeatLaunched(a.ttl)
+	for _, f := range ar.resyncFuncs {
+		f()
+	}
+}
+
+// Purge attempts to stop and unload all Units that have been loaded locally,
+// giving up once the given timeout has elapsed if it is non-zero. An error
+// is returned if any Units remain loaded.
+func (ar *AgentReconciler) Purge(a *Agent, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
 	for {
 		cAgentState, err := a.units()
 		if err != nil {
-			log.Errorf("Unable to determine agent's current state: %v", err)
-			return
+			return fmt.Errorf("unable to determine agent's current state: %v", err)
 		}
 		if len(cAgentState) == 0 {
-			return
+			return nil
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return fmt.Errorf("%d unit(s) still loaded after %s", len(cAgentState), timeout)
 		}
 
 		for name, _ := range cAgentState {
@@ -127,7 +340,16 @@ func desiredAgentState(a *Agent, reg registry.Registry) (*AgentState, error) {
 		return nil, err
 	}
 
+	// metadata set on the Machine at runtime decides which global units
+	// it runs as much as that it was configured with
 	ms := a.Machine.State()
+	md, err := reg.MachineMetadata(ms.ID)
+	if err != nil {
+		log.Errorf("Failed fetching metadata of Machine(%s) from Registry: %v", ms.ID, err)
+		return nil, err
+	}
+	ms = ms.WithMetadata(md)
+
 	as := AgentState{
 		MState: &ms,
 		Units:  make(map[string]*job.Unit),
@@ -141,11 +363,18 @@ func desiredAgentState(a *Agent, reg registry.Registry) (*AgentState, error) {
 
 	for _, u := range units {
 		u := u
-		md := u.RequiredTargetMetadata()
-		if u.IsGlobal() && !machine.HasMetadata(&ms, md) {
+		if u.IsGlobal() && !u.MetadataSatisfiedBy(&ms) {
 			log.Debugf("Agent unable to run global unit %s: missing required metadata", u.Name)
 			continue
 		}
+		if taint, ok := u.UntoleratedTaint(&ms); u.IsGlobal() && ok {
+			log.Debugf("Agent unable to run global unit %s: taint %q not tolerated", u.Name, taint)
+			continue
+		}
+		if d, ok := u.UnsupportedDirective(&ms); u.IsGlobal() && ok {
+			log.Debugf("Agent unable to run global unit %s: local systemd does not support %s", u.Name, d)
+			continue
+		}
 		if !u.IsGlobal() {
 			sUnit, ok := sUnitMap[u.Name]
 			if !ok || sUnit.TargetMachineID == "" || sUnit.TargetMachineID != ms.ID {
@@ -155,6 +384,38 @@ func desiredAgentState(a *Agent, reg registry.Registry) (*AgentState, error) {
 		as.Units[u.Name] = &u
 	}
 
+	names := make(map[string]bool, len(units))
+	for _, u := range units {
+		names[u.Name] = true
+	}
+
+	var instances []string
+	for _, u := range units {
+		if _, ok := u.Instances(); !ok {
+			continue
+		}
+		sched, err := reg.UnitInstances(u.Name)
+		if err != nil {
+			log.Errorf("Failed fetching instances of Unit(%s) from Registry: %v", u.Name, err)
+			return nil, err
+		}
+		for _, num := range sched[ms.ID] {
+			name := job.InstanceName(u.Name, num)
+			if names[name] {
+				// an instance submitted as a Unit of its own
+				// is scheduled as such
+				continue
+			}
+			as.Units[name] = &job.Unit{
+				Name:        name,
+				Unit:        u.Unit,
+				TargetState: u.TargetState,
+			}
+			instances = append(instances, name)
+		}
+	}
+	a.cache.setInstances(instances)
+
 	return &as, nil
 }
 
@@ -306,10 +567,21 @@ func (ar *AgentReconciler) calculateTaskChainForUnit(dState *AgentState, cState
 }
 
 func (ar *AgentReconciler) launchTaskChain(tc taskChain, a *Agent) {
+	if ar.observeOnly {
+		if tc.done != nil {
+			close(tc.done)
+		}
+		ar.observe(tc)
+		return
+	}
+
 	log.Debugf("AgentReconciler attempting task chain %s", tc)
 	reschan, err := ar.tManager.Do(tc, a)
 	if err != nil {
 		log.Infof("AgentReconciler task chain failed: chain=%s err=%v", tc, err)
+		if tc.done != nil {
+			close(tc.done)
+		}
 		return
 	}
 
@@ -323,3 +595,40 @@ func (ar *AgentReconciler) launchTaskChain(tc taskChain, a *Agent) {
 		}
 	}()
 }
+
+// observe logs each task of the given task chain in place of carrying it
+// out, unless the same task chain was logged by the previous reconciliation
+func (ar *AgentReconciler) observe(tc taskChain) {
+	desc := tc.String()
+	if ar.observed[tc.unit.Name] == desc {
+		return
+	}
+	if ar.observed == nil {
+		ar.observed = make(map[string]string)
+	}
+	ar.observed[tc.unit.Name] = desc
+
+	for _, t := range tc.tasks {
+		log.Infof("AgentReconciler observing only, would carry out task: type=%s job=%s reason=%q", t.typ, tc.unit.Name, t.reason)
+		if t.typ != taskTypeLoadUnit || ar.loadCheck == nil {
+			continue
+		}
+		if err := ar.loadCheck(tc.unit.Name, tc.unit.Unit); err != nil {
+			log.Warningf("AgentReconciler observing only, would refuse to load invalid Unit(%s): %v", tc.unit.Name, err)
+		}
+	}
+}
+
+// forgetObserved drops the logged task chains of units absent from the
+// given ones, so that they are logged again should they reappear
+func (ar *AgentReconciler) forgetObserved(tcs []taskChain) {
+	current := pkg.NewUnsafeSet()
+	for _, tc := range tcs {
+		current.Add(tc.unit.Name)
+	}
+	for name := range ar.observed {
+		if !current.Contains(name) {
+			delete(ar.observed, name)
+		}
+	}
+}
@@ -15,12 +15,20 @@
 package agent
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
+
+	gossh "github.com/coreos/fleet/Godeps/_workspace/src/golang.org/x/crypto/ssh"
 
 	"github.com/coreos/fleet/job"
 	"github.com/coreos/fleet/machine"
 	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/sign"
 	"github.com/coreos/fleet/unit"
 )
 
@@ -42,6 +50,7 @@ func makeAgentWithMetadata(md map[string]string) *Agent {
 				Metadata: md,
 			},
 		},
+		cache: &agentCache{},
 	}
 }
 
@@ -271,6 +280,200 @@ MachineMetadata=dog=woof`),
 	}
 }
 
+func TestDesiredAgentStateRuntimeMetadata(t *testing.T) {
+	reg := registry.NewFakeRegistry()
+	reg.SetJobs([]job.Job{
+		job.Job{
+			Name: "global.service",
+			Unit: newUF(t, "[X-Fleet]\nGlobal=true\nMachineMetadata=disk=ssd"),
+		},
+	})
+	a := makeAgentWithMetadata(map[string]string{"disk": "hdd"})
+	machID := a.Machine.State().ID
+
+	for i, tt := range []struct {
+		value string
+		want  bool
+	}{
+		{"", false},
+		{"ssd", true},
+		{"", false},
+	} {
+		reg.SetMachineMetadata(machID, "disk", tt.value)
+		as, err := desiredAgentState(a, reg)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if _, ok := as.Units["global.service"]; ok != tt.want {
+			t.Errorf("case %d: expected global unit desired=%t", i, tt.want)
+		}
+	}
+}
+
+type unreachableRegistry struct {
+	registry.Registry
+}
+
+func (unreachableRegistry) Units() ([]job.Unit, error) {
+	return nil, errors.New("registry unreachable")
+}
+
+func TestDesiredStateWithUnreachableRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fleet-agent")
+	if err != nil {
+		t.Fatalf("Failed creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agent-state.json")
+
+	uf := newUF(t, "[Service]\nExecStart=/bin/true")
+	reg := registry.NewFakeRegistry()
+	reg.SetJobs([]job.Job{
+		job.Job{
+			Name:            "foo.service",
+			Unit:            uf,
+			TargetState:     jsLaunched,
+			TargetMachineID: "this_machine",
+		},
+	})
+	mach := &machine.FakeMachine{MachineState: machine.MachineState{ID: "this_machine"}}
+	a := New(unit.NewFakeUnitManager(), nil, reg, mach, time.Second)
+
+	ar := NewReconciler(reg, nil)
+	ar.SetStateFile(path)
+	if as, offline := ar.desiredState(a); as == nil || offline || as.Units["foo.service"] == nil {
+		t.Fatalf("Expected desired state from reachable Registry, got %#v (offline=%t)", as, offline)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Expected desired state to be persisted: %v", err)
+	}
+
+	// a restarted agent with no Registry to talk to reads the state file
+	ar = NewReconciler(unreachableRegistry{reg}, nil)
+	ar.SetStateFile(path)
+	resynced := 0
+	ar.OnResync(func() { resynced++ })
+
+	as, offline := ar.desiredState(a)
+	if as == nil || !offline {
+		t.Fatalf("Expected desired state from state file, got %#v (offline=%t)", as, offline)
+	}
+	u := as.Units["foo.service"]
+	if u == nil || u.TargetState != jsLaunched || u.Unit.Hash() != uf.Hash() {
+		t.Fatalf("Unexpected Unit read from state file: %#v", u)
+	}
+
+	// without any known desired state, there is nothing to reconcile
+	empty := NewReconciler(unreachableRegistry{reg}, nil)
+	if as, _ := empty.desiredState(a); as != nil {
+		t.Errorf("Expected no desired state, got %#v", as)
+	}
+
+	if state := ar.ConnState(); state != ConnStateDegraded {
+		t.Errorf("Expected connection state %q, got %q", ConnStateDegraded, state)
+	}
+
+	// the Registry is not tried again before the backoff elapses
+	ar.reg = reg
+	now := time.Now()
+	ar.conn.now = func() time.Time { return now }
+	if _, offline := ar.desiredState(a); !offline {
+		t.Errorf("Expected desired state from state file during backoff")
+	}
+
+	// resynchronization happens once, when the Registry is reachable again
+	now = now.Add(connBackoffMin)
+	for i := 0; i < 2; i++ {
+		if _, offline := ar.desiredState(a); offline {
+			t.Errorf("Expected desired state from reachable Registry")
+		}
+	}
+	if resynced != 1 {
+		t.Errorf("Expected 1 resync, got %d", resynced)
+	}
+	if state := ar.ConnState(); state != ConnStateResyncing {
+		t.Errorf("Expected connection state %q, got %q", ConnStateResyncing, state)
+	}
+}
+
+func TestSignaturesWithUnreachableRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fleet-agent")
+	if err != nil {
+		t.Fatalf("Failed creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agent-state.json")
+
+	signer := newTestSigner(t)
+	uf := newUF(t, "[Service]\nExecStart=/bin/true")
+	sigs, err := sign.SignUnit([]gossh.Signer{signer}, "foo.service", uf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	reg := registry.NewFakeRegistry()
+	reg.SetJobs([]job.Job{
+		job.Job{
+			Name:            "foo.service",
+			Unit:            uf,
+			TargetState:     jsLaunched,
+			TargetMachineID: "this_machine",
+		},
+	})
+	reg.SetUnitSignatures("foo.service", sigs)
+	mach := &machine.FakeMachine{MachineState: machine.MachineState{ID: "this_machine"}}
+	a := New(unit.NewFakeUnitManager(), nil, reg, mach, time.Second)
+	verifier := sign.NewVerifier([]gossh.PublicKey{signer.PublicKey()})
+
+	ar := NewReconciler(reg, nil)
+	ar.SetStateFile(path)
+	ar.SetSignatureVerifier(NewSignatureVerifier(verifier))
+	if as, offline := ar.desiredState(a); as == nil || offline || !reflect.DeepEqual(sigs, as.Signatures["foo.service"]) {
+		t.Fatalf("Expected signatures read from reachable Registry, got %#v (offline=%t)", as, offline)
+	}
+
+	// a restarted agent with no Registry to talk to verifies units with
+	// the signatures kept in the state file
+	sv := NewSignatureVerifier(verifier)
+	ar = NewReconciler(unreachableRegistry{reg}, nil)
+	ar.SetStateFile(path)
+	ar.SetSignatureVerifier(sv)
+	ar.Reconcile(a)
+	if err := sv.Verify("foo.service", uf); err != nil {
+		t.Fatalf("Expected unit to be verified while Registry is unreachable: %v", err)
+	}
+}
+
+// stuckUnitManager never unloads any units
+type stuckUnitManager struct {
+	*unit.FakeUnitManager
+}
+
+func (stuckUnitManager) Unload(string) {}
+
+func TestAgentReconcilerPurge(t *testing.T) {
+	for i, tt := range []struct {
+		um      unit.UnitManager
+		timeout time.Duration
+		wantErr bool
+	}{
+		{unit.NewFakeUnitManager(), 0, false},
+		{unit.NewFakeUnitManager(), time.Minute, false},
+		{stuckUnitManager{unit.NewFakeUnitManager()}, time.Millisecond, true},
+	} {
+		reg := registry.NewFakeRegistry()
+		mach := &machine.FakeMachine{MachineState: machine.MachineState{ID: "XXX"}}
+		a := New(tt.um, unit.NewUnitStateGenerator(tt.um), reg, mach, time.Second)
+		if err := a.loadUnit(newTestUnitFromUnitContents(t, "foo.service", "")); err != nil {
+			t.Fatalf("case %d: failed loading unit: %v", i, err)
+		}
+
+		err := NewReconciler(reg, nil).Purge(a, tt.timeout)
+		if tt.wantErr != (err != nil) {
+			t.Errorf("case %d: expected error=%t, got %v", i, tt.wantErr, err)
+		}
+	}
+}
+
 func TestAbleToRun(t *testing.T) {
 	tests := []struct {
 		dState *AgentState
@@ -719,3 +922,82 @@ func TestCalculateTasksForJob(t *testing.T) {
 		}
 	}
 }
+
+func TestObserveTaskChains(t *testing.T) {
+	ar := NewReconciler(registry.NewFakeRegistry(), nil)
+	ar.SetObserveOnly(true)
+	var checked []string
+	ar.SetLoadCheck(func(name string, uf unit.UnitFile) error {
+		checked = append(checked, name)
+		return errors.New("refused")
+	})
+
+	load := func(name string) taskChain {
+		return newTaskChain(&job.Unit{Name: name}, task{typ: taskTypeLoadUnit, reason: taskReasonScheduledButUnloaded})
+	}
+	observe := func(tcs ...taskChain) {
+		for _, tc := range orderTaskChains(tcs, nil) {
+			ar.launchTaskChain(tc, nil)
+		}
+		ar.forgetObserved(tcs)
+	}
+
+	// each task chain is only reported again once it changes, or after
+	// it went away
+	observe(load("foo.service"), load("bar.service"))
+	observe(load("foo.service"), load("bar.service"))
+	observe(load("foo.service"))
+	observe(load("foo.service"), load("bar.service"))
+
+	stop := newTaskChain(&job.Unit{Name: "foo.service"}, task{typ: taskTypeStopUnit, reason: taskReasonLaunchedDesiredStateLoaded})
+	observe(stop, load("bar.service"))
+
+	want := []string{"foo.service", "bar.service", "bar.service"}
+	if !reflect.DeepEqual(want, checked) {
+		t.Errorf("expected units %v to be checked, got %v", want, checked)
+	}
+	if got := ar.observed["foo.service"]; got != stop.String() {
+		t.Errorf("expected task chain %s to be observed, got %s", stop, got)
+	}
+}
+
+func TestDesiredAgentStateInstances(t *testing.T) {
+	reg := registry.NewFakeRegistry()
+	reg.SetJobs([]job.Job{
+		job.Job{
+			Name: "foo@.service",
+			Unit: newUF(t, "[X-Fleet]\nInstances=4"),
+		},
+		job.Job{
+			Name:            "foo@2.service",
+			Unit:            newUF(t, "blah"),
+			TargetMachineID: "this_machine",
+		},
+	})
+	reg.ScheduleInstances("foo@.service", job.InstanceSchedule{
+		"this_machine":  []int{1, 2},
+		"other_machine": []int{3, 4},
+	})
+	a := makeAgentWithMetadata(nil)
+
+	as, err := desiredAgentState(a, reg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]*job.Unit{
+		"foo@1.service": &job.Unit{
+			Name: "foo@1.service",
+			Unit: newUF(t, "[X-Fleet]\nInstances=4"),
+		},
+		"foo@2.service": &job.Unit{
+			Name: "foo@2.service",
+			Unit: newUF(t, "blah"),
+		},
+	}
+	if !reflect.DeepEqual(want, as.Units) {
+		t.Errorf("expected units %v, got %v", want, as.Units)
+	}
+	if !a.cache.isInstance("foo@1.service") || a.cache.isInstance("foo@2.service") {
+		t.Errorf("expected only foo@1.service to be recorded as an instance")
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	// SubStateStartFailed is the SubState reported, along with an
+	// ActiveState of failed, for a Unit that kept failing after being
+	// restarted as many times as allowed
+	SubStateStartFailed = "start-failed"
+
+	// DefaultStartRetries is the number of times a failed Unit is
+	// restarted unless configured otherwise
+	DefaultStartRetries = 3
+
+	// DefaultStartRetryBackoff is the time waited before restarting a
+	// failed Unit for the first time, doubling with every restart
+	DefaultStartRetryBackoff = 10 * time.Second
+
+	// startRetryMaxBackoff bounds the time waited between restarts
+	startRetryMaxBackoff = 5 * time.Minute
+
+	// startRetryTick is the resolution at which failed Units are noticed
+	startRetryTick = time.Second
+)
+
+// GaveUp reports whether the given UnitState is that of a Unit the agent
+// gave up on, after its HealthCheck or the Unit itself kept failing, so
+// that the engine may reschedule it to another Machine
+func GaveUp(us *unit.UnitState) bool {
+	return us.ActiveState == "failed" && (us.SubState == SubStateUnhealthy || us.SubState == SubStateStartFailed)
+}
+
+// StartRetrier is a UnitManager that restarts the Units it starts should
+// they fail, waiting an exponentially growing backoff between restarts.
+// Once a Unit has been restarted as many times as allowed and still fails,
+// it is reported failed with a SubState of SubStateStartFailed so that the
+// engine reschedules it elsewhere. Batch Units, whose failure is an
+// outcome of their own, are not restarted.
+type StartRetrier struct {
+	unit.UnitManager
+
+	retries int
+	backoff time.Duration
+
+	mu    sync.Mutex
+	units map[string]*unitRetry
+	batch pkg.Set
+	clock clockwork.Clock
+}
+
+// unitRetry tracks the restarts of a single started Unit
+type unitRetry struct {
+	attempts int
+	// retryAt is when the Unit, found failed, is next restarted
+	retryAt time.Time
+	pending bool
+	gaveUp  bool
+}
+
+func NewStartRetrier(um unit.UnitManager, retries int, backoff time.Duration) *StartRetrier {
+	return &StartRetrier{
+		UnitManager: um,
+		retries:     retries,
+		backoff:     backoff,
+		units:       make(map[string]*unitRetry),
+		batch:       pkg.NewUnsafeSet(),
+		clock:       clockwork.NewRealClock(),
+	}
+}
+
+func (sr *StartRetrier) Load(name string, uf unit.UnitFile) error {
+	sr.mu.Lock()
+	delete(sr.units, name)
+	if isBatch(name, uf) {
+		sr.batch.Add(name)
+	} else {
+		sr.batch.Remove(name)
+	}
+	sr.mu.Unlock()
+
+	return sr.UnitManager.Load(name, uf)
+}
+
+func (sr *StartRetrier) Unload(name string) {
+	sr.mu.Lock()
+	delete(sr.units, name)
+	sr.batch.Remove(name)
+	sr.mu.Unlock()
+
+	sr.UnitManager.Unload(name)
+}
+
+// TriggerStart starts the Unit afresh, forgetting about past failures. Only
+// Units started by the agent are restarted should they fail.
+func (sr *StartRetrier) TriggerStart(name string) {
+	sr.mu.Lock()
+	if !sr.batch.Contains(name) {
+		sr.units[name] = &unitRetry{}
+	}
+	sr.mu.Unlock()
+
+	sr.UnitManager.TriggerStart(name)
+}
+
+func (sr *StartRetrier) TriggerStop(name string) {
+	sr.mu.Lock()
+	delete(sr.units, name)
+	sr.mu.Unlock()
+
+	sr.UnitManager.TriggerStop(name)
+}
+
+// TriggerRestart restarts the Unit without forgetting about its failures,
+// such as when its HealthCheck fails
+func (sr *StartRetrier) TriggerRestart(name string) {
+	if r, ok := sr.UnitManager.(unitRestarter); ok {
+		r.TriggerRestart(name)
+	} else {
+		sr.UnitManager.TriggerStop(name)
+		sr.UnitManager.TriggerStart(name)
+	}
+}
+
+func (sr *StartRetrier) GetUnitState(name string) (*unit.UnitState, error) {
+	us, err := sr.UnitManager.GetUnitState(name)
+	if err != nil || us == nil {
+		return us, err
+	}
+	return sr.overrideState(name, us), nil
+}
+
+func (sr *StartRetrier) GetUnitStates(filter pkg.Set) (map[string]*unit.UnitState, error) {
+	states, err := sr.UnitManager.GetUnitStates(filter)
+	if err != nil {
+		return nil, err
+	}
+	for name, us := range states {
+		states[name] = sr.overrideState(name, us)
+	}
+	return states, nil
+}
+
+// overrideState reports a Unit given up on with a SubState telling so
+func (sr *StartRetrier) overrideState(name string, us *unit.UnitState) *unit.UnitState {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if r, ok := sr.units[name]; !ok || !r.gaveUp || us.ActiveState != "failed" {
+		return us
+	}
+	failed := *us
+	failed.SubState = SubStateStartFailed
+	return &failed
+}
+
+// Run restarts the failed Units as their backoff elapses, until the stop
+// channel is closed
+func (sr *StartRetrier) Run(stop chan bool) {
+	ticker := sr.clock.After(startRetryTick)
+	for {
+		select {
+		case <-stop:
+			log.Debug("StartRetrier exiting due to stop signal")
+			return
+		case <-ticker:
+			sr.Check()
+			ticker = sr.clock.After(startRetryTick)
+		}
+	}
+}
+
+// Check looks for started Units that have failed, restarting those whose
+// backoff has elapsed and giving up on those restarted too often
+func (sr *StartRetrier) Check() {
+	sr.mu.Lock()
+	names := pkg.NewUnsafeSet()
+	for name, r := range sr.units {
+		if !r.gaveUp {
+			names.Add(name)
+		}
+	}
+	sr.mu.Unlock()
+	if names.Length() == 0 {
+		return
+	}
+
+	states, err := sr.UnitManager.GetUnitStates(names)
+	if err != nil {
+		log.Errorf("Failed fetching states of started units: %v", err)
+		return
+	}
+
+	now := sr.clock.Now()
+	var restart []string
+	sr.mu.Lock()
+	for name, us := range states {
+		r, ok := sr.units[name]
+		if !ok || r.gaveUp {
+			continue
+		}
+		switch {
+		case us.ActiveState == "active":
+			// a Unit that came up is given its restarts afresh
+			r.attempts = 0
+			r.pending = false
+		case us.ActiveState == "failed" && !r.pending:
+			if r.attempts >= sr.retries {
+				r.gaveUp = true
+				log.Errorf("Unit(%s) still failing after %d restart(s), reporting it failed", name, r.attempts)
+				continue
+			}
+			r.pending = true
+			r.retryAt = now.Add(sr.backoffFor(r.attempts))
+			log.Infof("Unit(%s) failed, restarting it in %v (%d/%d)", name, r.retryAt.Sub(now), r.attempts+1, sr.retries)
+		case r.pending && !now.Before(r.retryAt):
+			r.pending = false
+			r.attempts++
+			restart = append(restart, name)
+		}
+	}
+	sr.mu.Unlock()
+
+	for _, name := range restart {
+		sr.TriggerRestart(name)
+	}
+}
+
+// backoffFor returns the time waited before restarting a Unit already
+// restarted the given number of times
+func (sr *StartRetrier) backoffFor(attempts int) time.Duration {
+	d := sr.backoff
+	for i := 0; i < attempts && d < startRetryMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > startRetryMaxBackoff {
+		d = startRetryMaxBackoff
+	}
+	return d
+}
+
+// isBatch reports whether the given Unit runs to completion, in which case
+// its failure is not retried
+func isBatch(name string, uf unit.UnitFile) bool {
+	j := &job.Job{Name: name, Unit: uf}
+	return j.IsBatch()
+}
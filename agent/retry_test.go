@@ -0,0 +1,143 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/unit"
+)
+
+// failingUnitManager reports the units listed in failed as failed
+type failingUnitManager struct {
+	*restartingUnitManager
+	failed map[string]bool
+}
+
+func (fum *failingUnitManager) GetUnitStates(filter pkg.Set) (map[string]*unit.UnitState, error) {
+	states, err := fum.restartingUnitManager.GetUnitStates(filter)
+	for name, us := range states {
+		if fum.failed[name] {
+			us.ActiveState = "failed"
+			us.SubState = "failed"
+		}
+	}
+	return states, err
+}
+
+func (fum *failingUnitManager) GetUnitState(name string) (*unit.UnitState, error) {
+	states, err := fum.GetUnitStates(pkg.NewUnsafeSet(name))
+	return states[name], err
+}
+
+func TestStartRetrier(t *testing.T) {
+	um := &failingUnitManager{
+		restartingUnitManager: &restartingUnitManager{FakeUnitManager: unit.NewFakeUnitManager()},
+		failed:                make(map[string]bool),
+	}
+	sr := NewStartRetrier(um, 2, 10*time.Second)
+	fclock := clockwork.NewFakeClock()
+	sr.clock = fclock
+
+	sr.Load("foo.service", unit.UnitFile{})
+	sr.TriggerStart("foo.service")
+	um.failed["foo.service"] = true
+
+	// the failure is noticed, and the unit restarted once the backoff,
+	// doubling with every restart, has elapsed
+	for _, backoff := range []time.Duration{10 * time.Second, 20 * time.Second} {
+		sr.Check()
+		fclock.Advance(backoff - time.Second)
+		sr.Check()
+		fclock.Advance(time.Second)
+		sr.Check()
+	}
+	want := []string{"start foo.service", "restart foo.service", "restart foo.service"}
+	if !reflect.DeepEqual(want, um.calls) {
+		t.Fatalf("expected calls %v, got %v", want, um.calls)
+	}
+
+	// after which the unit is given up on
+	sr.Check()
+	fclock.Advance(time.Hour)
+	sr.Check()
+	if !reflect.DeepEqual(want, um.calls) {
+		t.Fatalf("expected no further calls, got %v", um.calls)
+	}
+	us, err := sr.GetUnitState("foo.service")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !GaveUp(us) || us.SubState != SubStateStartFailed {
+		t.Fatalf("expected unit to be reported start-failed, got %#v", us)
+	}
+
+	// starting the unit again gives it a fresh start
+	sr.TriggerStart("foo.service")
+	if us, _ := sr.GetUnitState("foo.service"); us.SubState == SubStateStartFailed {
+		t.Fatalf("expected unit not to be reported start-failed, got %#v", us)
+	}
+}
+
+func TestStartRetrierRecovers(t *testing.T) {
+	um := &failingUnitManager{
+		restartingUnitManager: &restartingUnitManager{FakeUnitManager: unit.NewFakeUnitManager()},
+		failed:                make(map[string]bool),
+	}
+	sr := NewStartRetrier(um, 1, time.Second)
+	fclock := clockwork.NewFakeClock()
+	sr.clock = fclock
+
+	sr.Load("foo.service", unit.UnitFile{})
+	sr.Load("batch.service", newBatchUnitFile(t))
+	sr.TriggerStart("foo.service")
+	sr.TriggerStart("batch.service")
+	um.failed["foo.service"] = true
+	um.failed["batch.service"] = true
+
+	sr.Check()
+	fclock.Advance(time.Second)
+	sr.Check()
+
+	// a unit that came up again is given its restarts afresh
+	um.failed["foo.service"] = false
+	sr.Check()
+	um.failed["foo.service"] = true
+	sr.Check()
+	fclock.Advance(time.Second)
+	sr.Check()
+
+	// and batch units are left alone
+	want := []string{"start foo.service", "start batch.service", "restart foo.service", "restart foo.service"}
+	if !reflect.DeepEqual(want, um.calls) {
+		t.Fatalf("expected calls %v, got %v", want, um.calls)
+	}
+	if us, _ := sr.GetUnitState("batch.service"); us.SubState == SubStateStartFailed {
+		t.Fatalf("expected batch unit not to be reported start-failed, got %#v", us)
+	}
+}
+
+func newBatchUnitFile(t *testing.T) unit.UnitFile {
+	uf, err := unit.NewUnitFile("[X-Fleet]\nBatch=true\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return *uf
+}
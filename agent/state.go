@@ -17,15 +17,38 @@ package agent
 import (
 	"fmt"
 	"path"
+	"strconv"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-semver/semver"
 
 	"github.com/coreos/fleet/job"
 	"github.com/coreos/fleet/log"
 	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/resource"
+	"github.com/coreos/fleet/sign"
 )
 
+// MaxUnitsMetadataKey is the Machine metadata key through which a Machine
+// may override the cluster-wide maximum number of Units it runs
+const MaxUnitsMetadataKey = "max_units"
+
 type AgentState struct {
 	MState *machine.MachineState
 	Units  map[string]*job.Unit
+
+	// Signatures holds the Signatures of the Units, indexed by name, if
+	// the Agent verifies them before loading them
+	Signatures map[string][]sign.Signature
+
+	// MaxUnits is the cluster-wide maximum number of Units an Agent may
+	// run, unless overridden by the Machine's metadata. A value of zero
+	// means no limit.
+	MaxUnits int
+
+	// MinVersion is the cluster-wide minimum version of fleetd, below
+	// which an Agent accepts no new Units. If nil, there is no minimum.
+	MinVersion *semver.Version
 }
 
 func NewAgentState(ms *machine.MachineState) *AgentState {
@@ -35,10 +58,69 @@ func NewAgentState(ms *machine.MachineState) *AgentState {
 	}
 }
 
+// belowVersion reports whether the fleetd of the Agent is older than the
+// given version. An Agent not publishing a valid version is assumed to be.
+func (as *AgentState) belowVersion(min semver.Version) bool {
+	v, err := semver.NewVersion(as.MState.Version)
+	return err != nil || v.LessThan(min)
+}
+
 func (as *AgentState) unitScheduled(name string) bool {
 	return as.Units[name] != nil
 }
 
+// unitLimit returns the maximum number of Units the Agent may run, taking
+// the Machine's metadata into account. Zero means no limit.
+func (as *AgentState) unitLimit() int {
+	if as.MState == nil {
+		return as.MaxUnits
+	}
+
+	v, ok := as.MState.Metadata[MaxUnitsMetadataKey]
+	if !ok {
+		return as.MaxUnits
+	}
+
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 0 {
+		log.Debugf("Ignoring invalid %s metadata %q of Machine(%s)", MaxUnitsMetadataKey, v, as.MState.ID)
+		return as.MaxUnits
+	}
+
+	return limit
+}
+
+// hasCapacity determines whether the Machine has enough CPU and memory left
+// for the given resources, once those reserved for the host and by the other
+// Units scheduled locally are accounted for. Resources the Machine has not
+// measured are not checked.
+func (as *AgentState) hasCapacity(pUnitName string, res resource.ResourceTuple) (bool, string) {
+	if res.Empty() || as.MState == nil || as.MState.Resources == nil {
+		return true, ""
+	}
+
+	reserved := []resource.ResourceTuple{resource.HostResources}
+	for _, eUnit := range as.Units {
+		if eUnit.Name == pUnitName {
+			continue
+		}
+		j := &job.Job{Name: eUnit.Name, Unit: eUnit.Unit}
+		if r, err := j.Resources(); err == nil {
+			reserved = append(reserved, r)
+		}
+	}
+
+	total := as.MState.Resources.Total
+	left := resource.Sub(total, resource.Sum(reserved...))
+	if total.Cores > 0 && res.Cores > left.Cores {
+		return false, fmt.Sprintf("%g cores requested, %g left unreserved", float64(res.Cores)/100, float64(left.Cores)/100)
+	}
+	if total.Memory > 0 && res.Memory > left.Memory {
+		return false, fmt.Sprintf("%dMB of memory requested, %dMB left unreserved", res.Memory, left.Memory)
+	}
+	return true, ""
+}
+
 // hasConflict determines whether there are any known conflicts with the given Unit
 func (as *AgentState) hasConflict(pUnitName string, pConflicts []string) (found bool, conflict string) {
 	for _, eUnit := range as.Units {
@@ -66,6 +148,42 @@ func (as *AgentState) hasConflict(pUnitName string, pConflicts []string) (found
 	return
 }
 
+// hasLabelConflict determines whether any Unit known by the AgentState carries
+// a label matching one of the given conflicting labels, or conflicts with one
+// of the given labels itself
+func (as *AgentState) hasLabelConflict(pUnitName string, pLabels, pConflicts map[string]pkg.Set) (found bool, conflict string) {
+	for _, eUnit := range as.Units {
+		if pUnitName == eUnit.Name {
+			continue
+		}
+
+		if labelsIntersect(pConflicts, eUnit.Labels()) || labelsIntersect(eUnit.ConflictingLabels(), pLabels) {
+			found = true
+			conflict = eUnit.Name
+			return
+		}
+	}
+
+	return
+}
+
+// labelsIntersect returns true if any key in a has at least one value in
+// common with the same key in b
+func labelsIntersect(a, b map[string]pkg.Set) bool {
+	for key, aValues := range a {
+		bValues, ok := b[key]
+		if !ok {
+			continue
+		}
+		for _, v := range aValues.Values() {
+			if bValues.Contains(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func globMatches(pattern, target string) bool {
 	matched, err := path.Match(pattern, target)
 	if err != nil {
@@ -79,17 +197,46 @@ func globMatches(pattern, target string) bool {
 // case or not is returned. The following criteria is used:
 //   - Agent must meet the Job's machine target requirement (if any)
 //   - Agent must have all of the Job's required metadata (if any)
+//   - Job must tolerate all of the Agent's taints (if any)
+//   - Agent must not be cordoned, unless the Job is already scheduled to it
+//   - Agent must not be draining, unless the Job cannot run anywhere else
 //   - Agent must have all required Peers of the Job scheduled locally (if any)
 //   - Job must not conflict with any other Units scheduled to the agent
+//   - Job must not carry or conflict with labels of other Units scheduled to the agent
+//   - Agent must not already run its maximum number of Units (if any)
 func (as *AgentState) AbleToRun(j *job.Job) (bool, string) {
 	if tgt, ok := j.RequiredTarget(); ok && !as.MState.MatchID(tgt) {
 		return false, fmt.Sprintf("agent ID %q does not match required %q", as.MState.ID, tgt)
 	}
 
-	metadata := j.RequiredTargetMetadata()
-	if len(metadata) != 0 {
-		if !machine.HasMetadata(as.MState, metadata) {
-			return false, "local Machine metadata insufficient"
+	if !j.MetadataSatisfiedBy(as.MState) {
+		return false, "local Machine metadata insufficient"
+	}
+
+	if taint, ok := j.UntoleratedTaint(as.MState); ok {
+		return false, fmt.Sprintf("local Machine taint %q not tolerated", taint)
+	}
+
+	if d, ok := j.UnsupportedDirective(as.MState); ok {
+		return false, fmt.Sprintf("local systemd does not support %s", d)
+	}
+
+	if f, ok := j.UnsupportedFeature(as.MState); ok {
+		return false, fmt.Sprintf("local fleetd %q does not support %s", as.MState.Version, f)
+	}
+
+	if as.MinVersion != nil && !as.unitScheduled(j.Name) && as.belowVersion(*as.MinVersion) {
+		return false, fmt.Sprintf("local fleetd %q is older than the cluster's minimum version %s", as.MState.Version, as.MinVersion)
+	}
+
+	switch as.MState.Schedulability {
+	case machine.Cordoned:
+		if !as.unitScheduled(j.Name) {
+			return false, "local Machine is cordoned"
+		}
+	case machine.Draining, machine.Maintenance:
+		if _, ok := j.RequiredTarget(); !ok && len(j.Peers()) == 0 {
+			return false, fmt.Sprintf("local Machine is %s", as.MState.Schedulability)
 		}
 	}
 
@@ -106,5 +253,19 @@ func (as *AgentState) AbleToRun(j *job.Job) (bool, string) {
 		return false, fmt.Sprintf("found conflict with locally-scheduled Unit(%s)", cJobName)
 	}
 
+	if cExists, cJobName := as.hasLabelConflict(j.Name, j.Labels(), j.ConflictingLabels()); cExists {
+		return false, fmt.Sprintf("found label conflict with locally-scheduled Unit(%s)", cJobName)
+	}
+
+	if limit := as.unitLimit(); limit > 0 && !as.unitScheduled(j.Name) && len(as.Units) >= limit {
+		return false, fmt.Sprintf("agent already runs its maximum of %d units", limit)
+	}
+
+	if res, err := j.Resources(); err == nil {
+		if ok, msg := as.hasCapacity(j.Name, res); !ok {
+			return false, fmt.Sprintf("insufficient resources: %s", msg)
+		}
+	}
+
 	return true, ""
 }
@@ -0,0 +1,136 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/sign"
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	// DefaultStateFile is where the agent persists its desired state by default
+	DefaultStateFile = "/run/fleet/agent-state.json"
+)
+
+// persistedUnit is the form in which the desired state of a single unit is
+// written to the agent's state file
+type persistedUnit struct {
+	Name        string
+	Contents    string
+	TargetState job.JobState
+	Signatures  []sign.Signature `json:",omitempty"`
+}
+
+// stateFile persists the desired state of the units an Agent is expected to
+// run, so that the Agent can keep managing them while the Registry is
+// unreachable, including across restarts of fleetd.
+type stateFile struct {
+	path string
+	// last holds the contents most recently written to or read from path,
+	// so that an unchanged desired state is not rewritten
+	last []byte
+}
+
+func newStateFile(path string) *stateFile {
+	return &stateFile{path: path}
+}
+
+// save writes the units of the given AgentState and their Signatures to the
+// state file, replacing the file atomically so that a partial write is
+// never read back.
+func (sf *stateFile) save(as *AgentState) error {
+	names := make([]string, 0, len(as.Units))
+	for name := range as.Units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	units := make([]persistedUnit, 0, len(names))
+	for _, name := range names {
+		u := as.Units[name]
+		units = append(units, persistedUnit{
+			Name:        u.Name,
+			Contents:    u.Unit.String(),
+			TargetState: u.TargetState,
+			Signatures:  as.Signatures[name],
+		})
+	}
+
+	b, err := json.Marshal(units)
+	if err != nil {
+		return err
+	}
+	if string(b) == string(sf.last) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sf.path), 0755); err != nil {
+		return err
+	}
+	tmp := sf.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, sf.path); err != nil {
+		return err
+	}
+
+	sf.last = b
+	return nil
+}
+
+// load reads back the units last written to the state file, and the
+// Signatures of those that have any. A missing file yields no units and no
+// error.
+func (sf *stateFile) load() (map[string]*job.Unit, map[string][]sign.Signature, error) {
+	b, err := ioutil.ReadFile(sf.path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	var pUnits []persistedUnit
+	if err := json.Unmarshal(b, &pUnits); err != nil {
+		return nil, nil, err
+	}
+
+	units := make(map[string]*job.Unit, len(pUnits))
+	sigs := make(map[string][]sign.Signature)
+	for _, pu := range pUnits {
+		uf, err := unit.NewUnitFile(pu.Contents)
+		if err != nil {
+			return nil, nil, err
+		}
+		units[pu.Name] = &job.Unit{
+			Name:        pu.Name,
+			Unit:        *uf,
+			TargetState: pu.TargetState,
+		}
+		if len(pu.Signatures) > 0 {
+			sigs[pu.Name] = pu.Signatures
+		}
+	}
+
+	sf.last = b
+	return units, sigs, nil
+}
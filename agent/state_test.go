@@ -18,8 +18,11 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-semver/semver"
+
 	"github.com/coreos/fleet/job"
 	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/resource"
 	"github.com/coreos/fleet/unit"
 )
 
@@ -98,6 +101,309 @@ func TestHasConflicts(t *testing.T) {
 	}
 }
 
+func TestHasLabelConflicts(t *testing.T) {
+	tests := []struct {
+		cState   *AgentState
+		job      *job.Job
+		want     bool
+		conflict string
+	}{
+		// empty current state causes no conflicts
+		{
+			cState: NewAgentState(&machine.MachineState{ID: "XXX"}),
+			job:    &job.Job{Name: "foo.service", Unit: fleetUnit(t, "Label=tier=frontend", "ConflictsLabel=tier=frontend")},
+			want:   false,
+		},
+
+		// new Job conflicts with label of existing Job
+		{
+			cState: &AgentState{
+				MState: &machine.MachineState{ID: "XXX"},
+				Units: map[string]*job.Unit{
+					"bar.service": &job.Unit{
+						Name: "bar.service",
+						Unit: fleetUnit(t, "Label=tier=frontend"),
+					},
+				},
+			},
+			job:      &job.Job{Name: "foo.service", Unit: fleetUnit(t, "ConflictsLabel=tier=frontend")},
+			want:     true,
+			conflict: "bar.service",
+		},
+
+		// existing Job conflicts with label of new Job
+		{
+			cState: &AgentState{
+				MState: &machine.MachineState{ID: "XXX"},
+				Units: map[string]*job.Unit{
+					"bar.service": &job.Unit{
+						Name: "bar.service",
+						Unit: fleetUnit(t, "ConflictsLabel=tier=frontend"),
+					},
+				},
+			},
+			job:      &job.Job{Name: "foo.service", Unit: fleetUnit(t, "Label=tier=frontend")},
+			want:     true,
+			conflict: "bar.service",
+		},
+
+		// differing label values do not conflict
+		{
+			cState: &AgentState{
+				MState: &machine.MachineState{ID: "XXX"},
+				Units: map[string]*job.Unit{
+					"bar.service": &job.Unit{
+						Name: "bar.service",
+						Unit: fleetUnit(t, "Label=tier=backend"),
+					},
+				},
+			},
+			job:  &job.Job{Name: "foo.service", Unit: fleetUnit(t, "ConflictsLabel=tier=frontend")},
+			want: false,
+		},
+
+		// a Job never conflicts with itself
+		{
+			cState: &AgentState{
+				MState: &machine.MachineState{ID: "XXX"},
+				Units: map[string]*job.Unit{
+					"foo.service": &job.Unit{
+						Name: "foo.service",
+						Unit: fleetUnit(t, "Label=tier=frontend", "ConflictsLabel=tier=frontend"),
+					},
+				},
+			},
+			job:  &job.Job{Name: "foo.service", Unit: fleetUnit(t, "Label=tier=frontend", "ConflictsLabel=tier=frontend")},
+			want: false,
+		},
+	}
+
+	for i, tt := range tests {
+		got, conflict := tt.cState.hasLabelConflict(tt.job.Name, tt.job.Labels(), tt.job.ConflictingLabels())
+		if got != tt.want {
+			t.Errorf("case %d: hasLabelConflict returned %t, want %t", i, got, tt.want)
+		}
+		if conflict != tt.conflict {
+			t.Errorf("case %d: unexpected conflicting Job: want %q, got %q", i, tt.conflict, conflict)
+		}
+	}
+}
+
+func TestAbleToRunUnitLimit(t *testing.T) {
+	newState := func(maxUnits int, metadata map[string]string, units ...string) *AgentState {
+		as := NewAgentState(&machine.MachineState{ID: "XXX", Metadata: metadata})
+		as.MaxUnits = maxUnits
+		for _, name := range units {
+			as.Units[name] = &job.Unit{Name: name}
+		}
+		return as
+	}
+
+	tests := []struct {
+		cState *AgentState
+		job    string
+		want   bool
+	}{
+		// no limit by default
+		{newState(0, nil, "bar.service", "baz.service"), "foo.service", true},
+
+		// cluster-wide limit reached
+		{newState(2, nil, "bar.service", "baz.service"), "foo.service", false},
+
+		// a Unit already scheduled to the agent does not count twice
+		{newState(2, nil, "bar.service", "foo.service"), "foo.service", true},
+
+		// machine metadata lowers the cluster-wide limit
+		{newState(3, map[string]string{MaxUnitsMetadataKey: "1"}, "bar.service"), "foo.service", false},
+
+		// machine metadata raises the cluster-wide limit
+		{newState(1, map[string]string{MaxUnitsMetadataKey: "2"}, "bar.service"), "foo.service", true},
+
+		// machine metadata removes the cluster-wide limit
+		{newState(1, map[string]string{MaxUnitsMetadataKey: "0"}, "bar.service"), "foo.service", true},
+
+		// invalid machine metadata is ignored
+		{newState(1, map[string]string{MaxUnitsMetadataKey: "lots"}, "bar.service"), "foo.service", false},
+	}
+
+	for i, tt := range tests {
+		got, reason := tt.cState.AbleToRun(&job.Job{Name: tt.job})
+		if got != tt.want {
+			t.Errorf("case %d: expected %t, got %t (%s)", i, tt.want, got, reason)
+		}
+	}
+}
+
+func TestAbleToRunTaints(t *testing.T) {
+	as := NewAgentState(&machine.MachineState{ID: "XXX", Taints: []string{"dedicated"}})
+
+	tests := []struct {
+		unit unit.UnitFile
+		want bool
+	}{
+		{fleetUnit(t), false},
+		{fleetUnit(t, "Tolerations=gpu"), false},
+		{fleetUnit(t, "Tolerations=dedicated"), true},
+	}
+
+	for i, tt := range tests {
+		got, reason := as.AbleToRun(&job.Job{Name: "foo.service", Unit: tt.unit})
+		if got != tt.want {
+			t.Errorf("case %d: expected %t, got %t (%s)", i, tt.want, got, reason)
+		}
+	}
+}
+
+func TestAbleToRunSchedulability(t *testing.T) {
+	newState := func(sched machine.Schedulability) *AgentState {
+		as := NewAgentState(&machine.MachineState{ID: "XXX", Schedulability: sched})
+		as.Units["bar.service"] = &job.Unit{Name: "bar.service"}
+		return as
+	}
+
+	tests := []struct {
+		cState *AgentState
+		job    string
+		unit   unit.UnitFile
+		want   bool
+	}{
+		{newState(machine.Schedulable), "foo.service", fleetUnit(t), true},
+
+		// cordoned Machines keep their Units, but take no new ones
+		{newState(machine.Cordoned), "foo.service", fleetUnit(t), false},
+		{newState(machine.Cordoned), "bar.service", fleetUnit(t), true},
+
+		// draining Machines only keep the Units unable to move elsewhere
+		{newState(machine.Draining), "bar.service", fleetUnit(t), false},
+		{newState(machine.Draining), "bar.service", fleetUnit(t, "MachineID=XXX"), true},
+		{newState(machine.Draining), "bar.service", fleetUnit(t, "MachineOf=baz.service"), false},
+
+		// as do Machines in a maintenance window
+		{newState(machine.Maintenance), "foo.service", fleetUnit(t), false},
+		{newState(machine.Maintenance), "bar.service", fleetUnit(t), false},
+		{newState(machine.Maintenance), "bar.service", fleetUnit(t, "MachineID=XXX"), true},
+	}
+
+	for i, tt := range tests {
+		got, reason := tt.cState.AbleToRun(&job.Job{Name: tt.job, Unit: tt.unit})
+		if got != tt.want {
+			t.Errorf("case %d: expected %t, got %t (%s)", i, tt.want, got, reason)
+		}
+	}
+}
+
+func TestAbleToRunSystemdVersion(t *testing.T) {
+	newState := func(facts map[string]string) *AgentState {
+		return NewAgentState(&machine.MachineState{ID: "XXX", Facts: facts})
+	}
+	uf, err := unit.NewUnitFile("[Service]\nExecStart=/bin/true\nProtectKernelTunables=true")
+	if err != nil {
+		t.Fatalf("Failed creating test unit: %v", err)
+	}
+
+	tests := []struct {
+		cState *AgentState
+		want   bool
+	}{
+		{newState(map[string]string{machine.FactSystemdVersion: "215"}), false},
+		{newState(map[string]string{machine.FactSystemdVersion: "232"}), true},
+
+		// Machines of unknown systemd version are given the benefit of
+		// the doubt
+		{newState(nil), true},
+	}
+
+	for i, tt := range tests {
+		got, reason := tt.cState.AbleToRun(&job.Job{Name: "foo.service", Unit: *uf})
+		if got != tt.want {
+			t.Errorf("case %d: expected %t, got %t (%s)", i, tt.want, got, reason)
+		}
+		if want := "local systemd does not support ProtectKernelTunables, introduced in systemd 232"; !got && reason != want {
+			t.Errorf("case %d: expected reason %q, got %q", i, want, reason)
+		}
+	}
+}
+
+func TestAbleToRunFleetVersion(t *testing.T) {
+	newState := func(version string, min string) *AgentState {
+		as := NewAgentState(&machine.MachineState{ID: "XXX", Version: version})
+		as.Units["bar.service"] = &job.Unit{Name: "bar.service"}
+		if min != "" {
+			v, err := semver.NewVersion(min)
+			if err != nil {
+				t.Fatalf("Failed parsing version %q: %v", min, err)
+			}
+			as.MinVersion = v
+		}
+		return as
+	}
+
+	tests := []struct {
+		cState *AgentState
+		job    string
+		unit   unit.UnitFile
+		want   bool
+	}{
+		{newState("0.9.0", ""), "foo.service", fleetUnit(t), true},
+
+		// older agents would silently ignore the options they do not know
+		{newState("0.9.0", ""), "foo.service", fleetUnit(t, "Batch=true"), false},
+		{newState("0.10.0", ""), "foo.service", fleetUnit(t, "Batch=true"), true},
+
+		// agents of unknown version are given the benefit of the doubt
+		{newState("", ""), "foo.service", fleetUnit(t, "Batch=true"), true},
+
+		// agents below the minimum version keep their Units, but take
+		// no new ones
+		{newState("0.9.0", "0.10.0"), "foo.service", fleetUnit(t), false},
+		{newState("0.9.0", "0.10.0"), "bar.service", fleetUnit(t), true},
+		{newState("", "0.10.0"), "foo.service", fleetUnit(t), false},
+		{newState("0.10.0+git", "0.10.0"), "foo.service", fleetUnit(t), true},
+	}
+
+	for i, tt := range tests {
+		got, reason := tt.cState.AbleToRun(&job.Job{Name: tt.job, Unit: tt.unit})
+		if got != tt.want {
+			t.Errorf("case %d: expected %t, got %t (%s)", i, tt.want, got, reason)
+		}
+	}
+}
+
+func TestAbleToRunResources(t *testing.T) {
+	newState := func(res *machine.Resources) *AgentState {
+		as := NewAgentState(&machine.MachineState{ID: "XXX", Resources: res})
+		as.Units["bar.service"] = &job.Unit{Name: "bar.service", Unit: fleetUnit(t, "Cores=2", "Memory=2G")}
+		return as
+	}
+	// after the host and bar.service, 1 core and 1792MB are left
+	measured := &machine.Resources{Total: resource.ResourceTuple{Cores: 400, Memory: 4096}}
+
+	tests := []struct {
+		cState *AgentState
+		job    string
+		unit   unit.UnitFile
+		want   bool
+	}{
+		{newState(measured), "foo.service", fleetUnit(t), true},
+		{newState(measured), "foo.service", fleetUnit(t, "Cores=1", "Memory=1G"), true},
+		{newState(measured), "foo.service", fleetUnit(t, "Cores=1.5"), false},
+		{newState(measured), "foo.service", fleetUnit(t, "Memory=2G"), false},
+
+		// a Unit does not compete with itself for resources
+		{newState(measured), "bar.service", fleetUnit(t, "Cores=2", "Memory=2G"), true},
+
+		// resources are not checked on Machines that have not measured them
+		{newState(nil), "foo.service", fleetUnit(t, "Cores=16"), true},
+	}
+
+	for i, tt := range tests {
+		got, reason := tt.cState.AbleToRun(&job.Job{Name: tt.job, Unit: tt.unit})
+		if got != tt.want {
+			t.Errorf("case %d: expected %t, got %t (%s)", i, tt.want, got, reason)
+		}
+	}
+}
+
 func TestGlobMatches(t *testing.T) {
 	tests := []struct {
 		pattern  string
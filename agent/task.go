@@ -20,6 +20,7 @@ import (
 	"strings"
 
 	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
 	"github.com/coreos/fleet/pkg"
 )
 
@@ -36,11 +37,22 @@ const (
 	taskReasonLoadedDesiredStateLaunched = "unit currently loaded but desired state is launched"
 	taskReasonLaunchedDesiredStateLoaded = "unit currently launched but desired state is loaded"
 	taskReasonPurgingAgent               = "purging agent"
+
+	// DefaultTaskWorkers is the number of task chains an Agent carries
+	// out at once unless configured otherwise
+	DefaultTaskWorkers = 4
 )
 
 type taskChain struct {
 	unit  *job.Unit
 	tasks []task
+
+	// wait holds the channels of the task chains that must complete
+	// before this one is started
+	wait []chan struct{}
+	// done, if set, is closed once the task chain has completed, or
+	// could not be attempted
+	done chan struct{}
 }
 
 func newTaskChain(u *job.Unit, t ...task) taskChain {
@@ -75,12 +87,16 @@ type taskResult struct {
 type taskManager struct {
 	processing pkg.Set
 	mapper     taskMapperFunc
+
+	// slots bounds the number of task chains carried out at once, if set
+	slots chan struct{}
 }
 
-func newTaskManager() *taskManager {
+func newTaskManager(workers int) *taskManager {
 	return &taskManager{
-		processing: pkg.NewUnsafeSet(),
+		processing: pkg.NewThreadsafeSet(),
 		mapper:     mapTaskToFunc,
+		slots:      make(chan struct{}, workers),
 	}
 }
 
@@ -89,7 +105,9 @@ func newTaskManager() *taskManager {
 // if there exists in-flight any task with the same unit name. The returned
 // error channel will be non-nil only if the task could be attempted. The
 // channel will be closed when the task completes. If the task failed, an
-// error will be sent to the channel. Do is not threadsafe.
+// error will be sent to the channel. The tasks are only carried out once
+// the task chains the given one waits for have completed and a worker is
+// free. Do is not threadsafe.
 func (tm *taskManager) Do(tc taskChain, a *Agent) (chan taskResult, error) {
 	if tc.unit == nil {
 		return nil, errors.New("unable to handle task with nil Job")
@@ -105,6 +123,18 @@ func (tm *taskManager) Do(tc taskChain, a *Agent) (chan taskResult, error) {
 	reschan := make(chan taskResult, len(tc.tasks))
 	go func() {
 		defer tm.processing.Remove(tc.unit.Name)
+		if tc.done != nil {
+			defer close(tc.done)
+		}
+
+		for _, w := range tc.wait {
+			<-w
+		}
+		if tm.slots != nil {
+			tm.slots <- struct{}{}
+			defer func() { <-tm.slots }()
+		}
+
 		for _, t := range tc.tasks {
 			t := t
 			res := taskResult{
@@ -127,6 +157,85 @@ func (tm *taskManager) Do(tc taskChain, a *Agent) (chan taskResult, error) {
 	return reschan, nil
 }
 
+// orderTaskChains has each of the given task chains wait for those of the
+// units it depends on through After= or Requires=, so that a unit is only
+// loaded and started once the units it depends on have been, and those
+// units are only stopped and unloaded once it has been. Units whose task
+// chain does not start or stop them, and dependencies forming a cycle, are
+// not waited for. The unit files of units being unloaded are looked up in
+// the given map, as their task chains carry none.
+func orderTaskChains(tcs []taskChain, unloading map[string]*job.Unit) []taskChain {
+	starting := make(map[string]bool, len(tcs))
+	index := make(map[string]int, len(tcs))
+	for i := range tcs {
+		tcs[i].done = make(chan struct{})
+		index[tcs[i].unit.Name] = i
+		starting[tcs[i].unit.Name] = tcs[i].starts()
+	}
+
+	// after maps each unit to the units whose task chains must complete
+	// before its own
+	after := make(map[string][]string)
+	for _, tc := range tcs {
+		name := tc.unit.Name
+		uf := tc.unit.Unit
+		if u, ok := unloading[name]; ok && len(uf.Options) == 0 {
+			uf = u.Unit
+		}
+		for _, dep := range uf.Dependencies() {
+			if _, ok := index[dep]; !ok || dep == name || starting[dep] != starting[name] {
+				continue
+			}
+			if starting[name] {
+				after[name] = append(after[name], dep)
+			} else {
+				after[dep] = append(after[dep], name)
+			}
+		}
+	}
+
+	for name, deps := range after {
+		tc := &tcs[index[name]]
+		for _, dep := range deps {
+			if dependsOn(after, dep, name, pkg.NewUnsafeSet()) {
+				log.Warningf("Not ordering Unit(%s) after Unit(%s), as they depend on each other", name, dep)
+				continue
+			}
+			tc.wait = append(tc.wait, tcs[index[dep]].done)
+		}
+	}
+	return tcs
+}
+
+// dependsOn reports whether the task chain of unit a waits, directly or
+// not, for that of unit b
+func dependsOn(after map[string][]string, a, b string, seen pkg.Set) bool {
+	if a == b {
+		return true
+	}
+	if seen.Contains(a) {
+		return false
+	}
+	seen.Add(a)
+	for _, dep := range after[a] {
+		if dependsOn(after, dep, b, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// starts reports whether the task chain loads or starts its unit, rather
+// than only stopping or unloading it
+func (tc taskChain) starts() bool {
+	for _, t := range tc.tasks {
+		if t.typ == taskTypeLoadUnit || t.typ == taskTypeStartUnit {
+			return true
+		}
+	}
+	return false
+}
+
 type taskMapperFunc func(t task, u *job.Unit, a *Agent) (func() error, error)
 
 func mapTaskToFunc(t task, u *job.Unit, a *Agent) (fn func() error, err error) {
@@ -15,11 +15,13 @@
 package agent
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/coreos/fleet/job"
 	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/unit"
 )
 
 func TestTaskManagerTwoInFlight(t *testing.T) {
@@ -32,7 +34,7 @@ func TestTaskManagerTwoInFlight(t *testing.T) {
 	}
 
 	tm := taskManager{
-		processing: pkg.NewUnsafeSet(),
+		processing: pkg.NewThreadsafeSet(),
 		mapper:     testMapper,
 	}
 
@@ -74,7 +76,7 @@ func TestTaskManagerUnitSerialization(t *testing.T) {
 	}
 
 	tm := taskManager{
-		processing: pkg.NewUnsafeSet(),
+		processing: pkg.NewThreadsafeSet(),
 		mapper:     testMapper,
 	}
 
@@ -108,3 +110,136 @@ func TestTaskManagerUnitSerialization(t *testing.T) {
 
 	close(result)
 }
+
+func TestTaskManagerWaitsAndWorkers(t *testing.T) {
+	ran := make(chan string, 3)
+	release := make(chan struct{})
+	testMapper := func(_ task, u *job.Unit, _ *Agent) (func() error, error) {
+		return func() error {
+			ran <- u.Name
+			<-release
+			return nil
+		}, nil
+	}
+
+	tm := newTaskManager(1)
+	tm.mapper = testMapper
+
+	first := taskChain{unit: &job.Unit{Name: "first"}, tasks: []task{{typ: "test"}}, done: make(chan struct{})}
+	second := taskChain{unit: &job.Unit{Name: "second"}, tasks: []task{{typ: "test"}}, wait: []chan struct{}{first.done}}
+	if _, err := tm.Do(second, nil); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+	if _, err := tm.Do(first, nil); err != nil {
+		t.Fatalf("unable to start task: %v", err)
+	}
+
+	// the second chain waits for the first, which holds the only worker
+	if name := <-ran; name != "first" {
+		t.Fatalf("expected first chain to run first, got %s", name)
+	}
+	select {
+	case name := <-ran:
+		t.Fatalf("expected %s to wait for first chain", name)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case name := <-ran:
+		if name != "second" {
+			t.Fatalf("expected second chain, got %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected second chain to run once the first completed")
+	}
+}
+
+func TestOrderTaskChains(t *testing.T) {
+	newUnit := func(name string, contents string) *job.Unit {
+		uf, err := unit.NewUnitFile(contents)
+		if err != nil {
+			t.Fatalf("Failed creating unit: %v", err)
+		}
+		return &job.Unit{Name: name, Unit: *uf}
+	}
+	start := []task{{typ: taskTypeLoadUnit}, {typ: taskTypeStartUnit}}
+	stop := []task{{typ: taskTypeStopUnit}}
+	unload := []task{{typ: taskTypeUnloadUnit}}
+
+	// waits returns the names of the chains each chain waits for
+	waits := func(tcs []taskChain) map[string][]string {
+		names := make(map[chan struct{}]string)
+		for _, tc := range tcs {
+			names[tc.done] = tc.unit.Name
+		}
+		got := make(map[string][]string)
+		for _, tc := range tcs {
+			for _, w := range tc.wait {
+				got[tc.unit.Name] = append(got[tc.unit.Name], names[w])
+			}
+		}
+		return got
+	}
+
+	tests := []struct {
+		tcs       []taskChain
+		unloading map[string]*job.Unit
+		want      map[string][]string
+	}{
+		// units are started after their dependencies
+		{
+			tcs: []taskChain{
+				{unit: newUnit("app.service", "[Unit]\nAfter=db.service\nRequires=cache.service other.service"), tasks: start},
+				{unit: newUnit("db.service", ""), tasks: start},
+				{unit: newUnit("cache.service", ""), tasks: start},
+			},
+			want: map[string][]string{"app.service": {"db.service", "cache.service"}},
+		},
+		// and stopped before them
+		{
+			tcs: []taskChain{
+				{unit: newUnit("app.service", "[Unit]\nAfter=db.service"), tasks: stop},
+				{unit: newUnit("db.service", ""), tasks: stop},
+			},
+			want: map[string][]string{"db.service": {"app.service"}},
+		},
+		// units being unloaded are ordered by their previous unit files
+		{
+			tcs: []taskChain{
+				{unit: &job.Unit{Name: "app.service"}, tasks: unload},
+				{unit: &job.Unit{Name: "db.service"}, tasks: unload},
+			},
+			unloading: map[string]*job.Unit{"app.service": newUnit("app.service", "[Unit]\nAfter=db.service")},
+			want:      map[string][]string{"db.service": {"app.service"}},
+		},
+		// a unit started does not wait for a dependency being stopped
+		{
+			tcs: []taskChain{
+				{unit: newUnit("app.service", "[Unit]\nAfter=db.service"), tasks: start},
+				{unit: newUnit("db.service", ""), tasks: stop},
+			},
+			want: map[string][]string{},
+		},
+		// units depending on each other wait for only one another
+		{
+			tcs: []taskChain{
+				{unit: newUnit("a.service", "[Unit]\nAfter=b.service"), tasks: start},
+				{unit: newUnit("b.service", "[Unit]\nAfter=a.service"), tasks: start},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		got := waits(orderTaskChains(tt.tcs, tt.unloading))
+		if tt.want == nil {
+			if len(got) > 1 {
+				t.Errorf("case %d: expected cycle to be broken, got %v", i, got)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("case %d: expected %v, got %v", i, tt.want, got)
+		}
+	}
+}
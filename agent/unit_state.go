@@ -25,6 +25,7 @@ import (
 	"github.com/coreos/fleet/log"
 	"github.com/coreos/fleet/machine"
 	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/resource"
 	"github.com/coreos/fleet/unit"
 )
 
@@ -32,6 +33,7 @@ const numPublishers = 5
 
 func NewUnitStatePublisher(reg registry.Registry, mach machine.Machine, ttl time.Duration) *UnitStatePublisher {
 	return &UnitStatePublisher{
+		reg:             reg,
 		mach:            mach,
 		ttl:             ttl,
 		publisher:       newPublisher(reg, ttl),
@@ -44,11 +46,17 @@ func NewUnitStatePublisher(reg registry.Registry, mach machine.Machine, ttl time
 	}
 }
 
-type publishFunc func(name string, us *unit.UnitState)
+// publishFunc publishes a single UnitState, or removes it from the
+// Registry if nil, returning an error if the Registry reports one
+type publishFunc func(name string, us *unit.UnitState) error
 
 type UnitStatePublisher struct {
+	reg  registry.Registry
 	mach machine.Machine
 	ttl  time.Duration
+	// interval is the time between publishing all cached UnitStates,
+	// half the ttl unless set otherwise
+	interval time.Duration
 
 	cache      map[string]*unit.UnitState
 	cacheMutex sync.RWMutex
@@ -64,25 +72,65 @@ type UnitStatePublisher struct {
 
 	publisher publishFunc
 
+	// usage, if set, provides the sampled usage published along with
+	// each UnitState
+	usage *UsageSampler
+
+	// batchWindow is the time during which changes are collected before
+	// being published together, or zero to publish each change at once
+	batchWindow time.Duration
+	// batch holds the UnitStates changed during the current window,
+	// along with the UnitStates cached before the window opened
+	batch       map[string]*unit.UnitState
+	batchBefore map[string]*unit.UnitState
+
 	clock clockwork.Clock
 }
 
+// SetTTL sets the TTL with which UnitStates are published to the Registry
+func (p *UnitStatePublisher) SetTTL(ttl time.Duration) {
+	p.ttl = ttl
+	p.publisher = newPublisher(p.reg, ttl)
+}
+
+// SetPublishInterval sets the time between publishing all cached
+// UnitStates, which must be less than the TTL they are published with
+func (p *UnitStatePublisher) SetPublishInterval(ival time.Duration) {
+	p.interval = ival
+}
+
+func (p *UnitStatePublisher) publishInterval() time.Duration {
+	if p.interval > 0 {
+		return p.interval
+	}
+	return p.ttl / 2
+}
+
+// SetBatchWindow has the UnitStatePublisher collect the UnitStates changing
+// within the given window and only publish those that differ at the end of
+// it, so that units changing state repeatedly, such as during a systemd
+// daemon-reload, do not cause a write to the Registry for every change
+func (p *UnitStatePublisher) SetBatchWindow(window time.Duration) {
+	p.batchWindow = window
+}
+
+// SetUsageSampler has the UnitStatePublisher publish the usage sampled by
+// the given UsageSampler along with each UnitState
+func (p *UnitStatePublisher) SetUsageSampler(s *UsageSampler) {
+	p.usage = s
+}
+
 // Run caches all of the heartbeat objects from the provided channel, publishing
-// them to the Registry every 5s. Heartbeat objects are also published as they
-// are received on the channel.
+// them to the Registry at the publish interval. Heartbeat objects are also
+// published as they are received on the channel.
 func (p *UnitStatePublisher) Run(beatchan <-chan *unit.UnitStateHeartbeat, stop chan bool) {
 	go func() {
 		for {
 			select {
 			case <-stop:
 				return
-			case <-p.clock.After(p.ttl / 2):
-				p.cacheMutex.Lock()
-				for name, us := range p.cache {
-					go p.queueForPublish(name, us)
-				}
-				p.pruneCache()
-				p.cacheMutex.Unlock()
+			case <-p.clock.After(p.publishInterval()):
+				p.Republish()
 			}
 		}
 	}()
@@ -109,13 +157,18 @@ func (p *UnitStatePublisher) Run(beatchan <-chan *unit.UnitStateHeartbeat, stop
 					}
 					delete(p.toPublishStates, name)
 					p.toPublishMutex.Unlock()
-					p.publisher(name, us)
-
+					if us != nil && p.usage != nil {
+						us = withUsage(us, p.usage.Usage(name))
+					}
+					if err := p.publisher(name, us); err != nil && us == nil {
+						p.retainRemoval(name)
+					}
 				}
 			}
 		}()
 	}
 
+	var flush <-chan time.Time
 	for {
 		select {
 		case <-stop:
@@ -125,13 +178,56 @@ func (p *UnitStatePublisher) Run(beatchan <-chan *unit.UnitStateHeartbeat, stop
 				bt.State.MachineID = machID
 			}
 
+			if p.batchWindow <= 0 {
+				if p.updateCache(bt) {
+					go p.queueForPublish(bt.Name, bt.State)
+				}
+				continue
+			}
+
+			p.cacheMutex.RLock()
+			before, cached := p.cache[bt.Name]
+			p.cacheMutex.RUnlock()
 			if p.updateCache(bt) {
-				go p.queueForPublish(bt.Name, bt.State)
+				if flush == nil {
+					flush = p.clock.After(p.batchWindow)
+				}
+				p.addToBatch(bt.Name, bt.State, before, cached)
 			}
+		case <-flush:
+			flush = nil
+			p.publishBatch()
 		}
 	}
 }
 
+// addToBatch records the given UnitState as changed during the current
+// window, along with the UnitState cached before, unless already recorded
+func (p *UnitStatePublisher) addToBatch(name string, us, before *unit.UnitState, cached bool) {
+	if p.batch == nil {
+		p.batch = make(map[string]*unit.UnitState)
+		p.batchBefore = make(map[string]*unit.UnitState)
+	}
+	if _, ok := p.batch[name]; !ok && cached {
+		p.batchBefore[name] = before
+	}
+	p.batch[name] = us
+}
+
+// publishBatch queues for publishing the UnitStates changed during the
+// window that has just closed, skipping those that ended up as they were
+// before it opened
+func (p *UnitStatePublisher) publishBatch() {
+	for name, us := range p.batch {
+		if before, ok := p.batchBefore[name]; ok && reflect.DeepEqual(before, us) {
+			continue
+		}
+		go p.queueForPublish(name, us)
+	}
+	p.batch = nil
+	p.batchBefore = nil
+}
+
 func (p *UnitStatePublisher) MarshalJSON() ([]byte, error) {
 	p.cacheMutex.Lock()
 	data := struct {
@@ -146,6 +242,39 @@ func (p *UnitStatePublisher) MarshalJSON() ([]byte, error) {
 	return json.Marshal(data)
 }
 
+// withUsage returns a copy of the given UnitState carrying the given usage,
+// leaving the cached UnitState untouched so that a change in usage alone
+// does not cause the UnitState to be published at once
+func withUsage(us *unit.UnitState, usage *resource.ResourceTuple) *unit.UnitState {
+	cp := *us
+	cp.Usage = usage
+	return &cp
+}
+
+// Republish queues every UnitState in the cache for publishing, along with
+// the removal of those UnitStates which have not been removed from the
+// Registry yet. It is called periodically, and can be called to
+// resynchronize the Registry after it has been unreachable.
+func (p *UnitStatePublisher) Republish() {
+	p.cacheMutex.Lock()
+	for name, us := range p.cache {
+		go p.queueForPublish(name, us)
+	}
+	p.pruneCache()
+	p.cacheMutex.Unlock()
+}
+
+// retainRemoval puts back the removal of the UnitState by the given name
+// into the cache after it failed, so it is retried when the cache is next
+// republished, unless a newer UnitState has been cached in the meantime
+func (p *UnitStatePublisher) retainRemoval(name string) {
+	p.cacheMutex.Lock()
+	defer p.cacheMutex.Unlock()
+	if _, ok := p.cache[name]; !ok {
+		p.cache[name] = nil
+	}
+}
+
 func (p *UnitStatePublisher) pruneCache() {
 	for name, us := range p.cache {
 		if us == nil {
@@ -196,13 +325,14 @@ func (p *UnitStatePublisher) Purge() {
 // newPublisher returns a publishFunc that publishes a single UnitState
 // by the given name to the provided Registry, with the given TTL
 func newPublisher(reg registry.Registry, ttl time.Duration) publishFunc {
-	return func(name string, us *unit.UnitState) {
+	return func(name string, us *unit.UnitState) error {
 		if us == nil {
 			log.Debugf("Destroying UnitState(%s) in Registry", name)
 			err := reg.RemoveUnitState(name)
 			if err != nil {
 				log.Errorf("Failed to destroy UnitState(%s) in Registry: %v", name, err)
 			}
+			return err
 		} else {
 			// Sanity check - don't want to publish incomplete UnitStates
 			// TODO(jonboulle): consider teasing apart a separate UnitState-like struct
@@ -219,5 +349,6 @@ func newPublisher(reg registry.Registry, ttl time.Duration) publishFunc {
 				reg.SaveUnitState(name, us, ttl)
 			}
 		}
+		return nil
 	}
 }
@@ -165,6 +165,26 @@ func TestPruneCache(t *testing.T) {
 	}
 }
 
+func TestRetainRemoval(t *testing.T) {
+	usp := &UnitStatePublisher{
+		cache: map[string]*unit.UnitState{
+			"bar.service": &unit.UnitState{UnitName: "bar.service"},
+		},
+	}
+
+	// a failed removal is retried with the next republish
+	usp.retainRemoval("foo.service")
+	if us, ok := usp.cache["foo.service"]; !ok || us != nil {
+		t.Errorf("Expected removal of foo.service to be retained, got %#v", usp.cache)
+	}
+
+	// unless the unit has since reported a new state
+	usp.retainRemoval("bar.service")
+	if us := usp.cache["bar.service"]; us == nil {
+		t.Errorf("Expected state of bar.service to be kept, got %#v", usp.cache)
+	}
+}
+
 func TestPurge(t *testing.T) {
 	cache := map[string]*unit.UnitState{
 		"foo.service": &unit.UnitState{
@@ -289,11 +309,12 @@ func TestUnitStatePublisherRunTiming(t *testing.T) {
 	fclock := clockwork.NewFakeClock()
 	states := make([]*unit.UnitState, 0)
 	published := make(chan struct{})
-	pf := func(name string, us *unit.UnitState) {
+	pf := func(name string, us *unit.UnitState) error {
 		states = append(states, us)
 		go func() {
 			published <- struct{}{}
 		}()
+		return nil
 	}
 	usp := &UnitStatePublisher{
 		mach:            &machine.FakeMachine{},
@@ -423,10 +444,11 @@ func TestUnitStatePublisherRunQueuing(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(numPublishers)
 	block := make(chan struct{})
-	pf := func(name string, us *unit.UnitState) {
+	pf := func(name string, us *unit.UnitState) error {
 		wg.Done()
 		<-block
 		states = append(states, name)
+		return nil
 	}
 	usp := &UnitStatePublisher{
 		mach: &machine.FakeMachine{
@@ -542,12 +564,13 @@ func TestUnitStatePublisherRunWithDelays(t *testing.T) {
 	states := make([]string, 0)
 	fclock := clockwork.NewFakeClock()
 	var wgs, wgf sync.WaitGroup // track starting and stopping of publishers
-	slowpf := func(name string, us *unit.UnitState) {
+	slowpf := func(name string, us *unit.UnitState) error {
 		wgs.Done()
 		// simulate a delay in communication with the registry
 		fclock.Sleep(3 * time.Second)
 		states = append(states, name)
 		wgf.Done()
+		return nil
 	}
 
 	usp := &UnitStatePublisher{
@@ -734,3 +757,59 @@ func TestMarshalJSON(t *testing.T) {
 	}
 
 }
+
+func TestUnitStatePublisherRunBatch(t *testing.T) {
+	fclock := clockwork.NewFakeClock()
+	published := make(chan *unit.UnitState, 10)
+	pf := func(name string, us *unit.UnitState) error {
+		published <- us
+		return nil
+	}
+	usp := NewUnitStatePublisher(nil, &machine.FakeMachine{}, time.Hour)
+	usp.publisher = pf
+	usp.clock = fclock
+	usp.SetBatchWindow(time.Second)
+	usp.cache["foo.service"] = &unit.UnitState{UnitName: "foo.service", ActiveState: "active"}
+
+	bc := make(chan *unit.UnitStateHeartbeat)
+	sc := make(chan bool)
+	defer close(sc)
+	go usp.Run(bc, sc)
+
+	for _, us := range []*unit.UnitState{
+		// a unit flapping back to its former state is not published
+		&unit.UnitState{UnitName: "foo.service", ActiveState: "reloading"},
+		&unit.UnitState{UnitName: "foo.service", ActiveState: "active"},
+		// and one changing repeatedly only in its last state
+		&unit.UnitState{UnitName: "bar.service", ActiveState: "activating"},
+		&unit.UnitState{UnitName: "bar.service", ActiveState: "active"},
+	} {
+		bc <- &unit.UnitStateHeartbeat{Name: us.UnitName, State: us}
+	}
+
+	// nothing is published until the window closes
+	select {
+	case us := <-published:
+		t.Fatalf("unexpected UnitState published before the window closed: %#v", us)
+	default:
+	}
+
+	// the republishing timer and the window
+	fclock.BlockUntil(2)
+	fclock.Advance(time.Second)
+
+	want := &unit.UnitState{UnitName: "bar.service", ActiveState: "active"}
+	select {
+	case us := <-published:
+		if !reflect.DeepEqual(want, us) {
+			t.Fatalf("expected %#v to be published, got %#v", want, us)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected %#v to be published", want)
+	}
+	select {
+	case us := <-published:
+		t.Fatalf("unexpected UnitState published: %#v", us)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
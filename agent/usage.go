@@ -0,0 +1,199 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/resource"
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	// DefaultCgroupRoot is where the cgroup hierarchies are mounted
+	DefaultCgroupRoot = "/sys/fs/cgroup"
+)
+
+// UsageSampler periodically samples the CPU and memory used by each Unit
+// from the cgroup systemd places it in. Units without such a cgroup, such as
+// those running in a container, report no usage.
+type UsageSampler struct {
+	um   unit.UnitManager
+	root string
+	now  func() time.Time
+
+	mu      sync.RWMutex
+	samples map[string]*usageSample
+	// cgroups caches the path of the cgroup of each Unit, relative to the
+	// root of each controller's hierarchy
+	cgroups map[string]string
+}
+
+// usageSample holds the most recent usage of a Unit, along with the
+// cumulative CPU time it is derived from
+type usageSample struct {
+	usage   resource.ResourceTuple
+	cpuTime uint64
+	at      time.Time
+}
+
+func NewUsageSampler(um unit.UnitManager, root string) *UsageSampler {
+	return &UsageSampler{
+		um:      um,
+		root:    root,
+		now:     time.Now,
+		samples: make(map[string]*usageSample),
+		cgroups: make(map[string]string),
+	}
+}
+
+// Run samples the usage of all loaded Units at the given interval until
+// the stop channel is closed
+func (s *UsageSampler) Run(interval time.Duration, stop chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.Sample()
+		}
+	}
+}
+
+// Sample takes a new sample of the usage of all loaded Units. The CPU used
+// by a Unit is only known from its second sample onwards.
+func (s *UsageSampler) Sample() {
+	names, err := s.um.Units()
+	if err != nil {
+		log.Errorf("Failed listing units to sample usage of: %v", err)
+		return
+	}
+
+	loaded := make(map[string]bool, len(names))
+	for _, name := range names {
+		loaded[name] = true
+		s.sample(name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range s.samples {
+		if !loaded[name] {
+			delete(s.samples, name)
+			delete(s.cgroups, name)
+		}
+	}
+}
+
+func (s *UsageSampler) sample(name string) {
+	s.mu.RLock()
+	cg, ok := s.cgroups[name]
+	prev := s.samples[name]
+	s.mu.RUnlock()
+
+	if !ok {
+		if cg = findCgroup(filepath.Join(s.root, "memory"), name); cg == "" {
+			return
+		}
+	}
+
+	memory, err := readCgroupValue(filepath.Join(s.root, "memory", cg, "memory.usage_in_bytes"))
+	if err != nil {
+		// the Unit may have stopped and its cgroup been removed
+		s.forget(name)
+		return
+	}
+	cpuTime, err := readCgroupValue(filepath.Join(s.root, "cpuacct", cg, "cpuacct.usage"))
+	if err != nil {
+		s.forget(name)
+		return
+	}
+
+	cur := &usageSample{cpuTime: cpuTime, at: s.now()}
+	cur.usage.Memory = int(memory >> 20)
+	if prev != nil && cpuTime >= prev.cpuTime {
+		if elapsed := cur.at.Sub(prev.at); elapsed > 0 {
+			cur.usage.Cores = int(float64(cpuTime-prev.cpuTime) / float64(elapsed) * 100)
+		}
+	}
+
+	s.mu.Lock()
+	s.samples[name] = cur
+	s.cgroups[name] = cg
+	s.mu.Unlock()
+}
+
+func (s *UsageSampler) forget(name string) {
+	s.mu.Lock()
+	delete(s.samples, name)
+	delete(s.cgroups, name)
+	s.mu.Unlock()
+}
+
+// Usage returns the most recently sampled usage of the named Unit, or nil
+// if it has not been sampled
+func (s *UsageSampler) Usage(name string) *resource.ResourceTuple {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sample, ok := s.samples[name]
+	if !ok {
+		return nil
+	}
+	usage := sample.usage
+	return &usage
+}
+
+// findCgroup searches the cgroup hierarchy at the given root for the cgroup
+// of the named Unit, returning its path relative to root, or an empty
+// string if there is none. Units are found in slices other than the
+// default system.slice, too.
+func findCgroup(root, name string) (cg string) {
+	if fi, err := os.Stat(filepath.Join(root, "system.slice", name)); err == nil && fi.IsDir() {
+		return filepath.Join("system.slice", name)
+	}
+
+	filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || cg != "" {
+			return filepath.SkipDir
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if fi.Name() == name {
+			cg, _ = filepath.Rel(root, path)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return cg
+}
+
+// readCgroupValue reads a file of a cgroup holding a single integer
+func readCgroupValue(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
@@ -0,0 +1,102 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/resource"
+	"github.com/coreos/fleet/unit"
+)
+
+func writeCgroup(t *testing.T, root, cg string, memory, cpuTime string) {
+	for file, value := range map[string]string{
+		filepath.Join(root, "memory", cg, "memory.usage_in_bytes"): memory,
+		filepath.Join(root, "cpuacct", cg, "cpuacct.usage"):        cpuTime,
+	} {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatalf("Failed creating cgroup: %v", err)
+		}
+		if err := ioutil.WriteFile(file, []byte(value+"\n"), 0644); err != nil {
+			t.Fatalf("Failed writing %s: %v", file, err)
+		}
+	}
+}
+
+func TestUsageSampler(t *testing.T) {
+	root, err := ioutil.TempDir("", "fleet-cgroup")
+	if err != nil {
+		t.Fatalf("Failed creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	um := unit.NewFakeUnitManager()
+	for _, name := range []string{"foo.service", "bar.service", "baz.service"} {
+		um.Load(name, unit.UnitFile{})
+	}
+	writeCgroup(t, root, "system.slice/foo.service", "536870912", "1000000000")
+	// units placed in slices of their own are found too
+	writeCgroup(t, root, "apps.slice/bar.service", "1048576", "0")
+
+	now := time.Unix(0, 0)
+	s := NewUsageSampler(um, root)
+	s.now = func() time.Time { return now }
+
+	// CPU usage is only known from the second sample onwards
+	s.Sample()
+	if want, got := (resource.ResourceTuple{Memory: 512}), s.Usage("foo.service"); got == nil || *got != want {
+		t.Errorf("Expected usage %#v of foo.service, got %#v", want, got)
+	}
+
+	// 1.5s of CPU time over 2s of wall time is 75% of a core
+	now = now.Add(2 * time.Second)
+	writeCgroup(t, root, "system.slice/foo.service", "536870912", "2500000000")
+	s.Sample()
+	if want, got := (resource.ResourceTuple{Cores: 75, Memory: 512}), s.Usage("foo.service"); got == nil || *got != want {
+		t.Errorf("Expected usage %#v of foo.service, got %#v", want, got)
+	}
+	if want, got := (resource.ResourceTuple{Memory: 1}), s.Usage("bar.service"); got == nil || *got != want {
+		t.Errorf("Expected usage %#v of bar.service, got %#v", want, got)
+	}
+
+	// units without a cgroup report no usage
+	if got := s.Usage("baz.service"); got != nil {
+		t.Errorf("Expected no usage of baz.service, got %#v", got)
+	}
+
+	// neither do units once unloaded
+	um.Unload("foo.service")
+	s.Sample()
+	if got := s.Usage("foo.service"); got != nil {
+		t.Errorf("Expected no usage of unloaded foo.service, got %#v", got)
+	}
+}
+
+func TestWithUsage(t *testing.T) {
+	us := &unit.UnitState{UnitName: "foo.service"}
+	usage := &resource.ResourceTuple{Cores: 10}
+
+	got := withUsage(us, usage)
+	if got.Usage != usage || got.UnitName != "foo.service" {
+		t.Errorf("Expected UnitState with usage, got %#v", got)
+	}
+	if us.Usage != nil {
+		t.Errorf("Expected original UnitState to be left untouched, got %#v", us)
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/sign"
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	// SubStateInvalid is the SubState reported, along with an ActiveState
+	// of failed and the reason in FailureReason, for a Unit the agent
+	// refused to load as it failed validation
+	SubStateInvalid = "invalid"
+)
+
+// execOptions are the options of a [Service] section naming a command to run
+var execOptions = []string{"ExecStartPre", "ExecStart", "ExecStartPost", "ExecReload", "ExecStop", "ExecStopPost"}
+
+// UnitValidator is a UnitManager that validates each Unit before loading
+// it. A Unit failing validation is not loaded but reported failed, with the
+// reason it failed, until it is unloaded or replaced by a valid version.
+type UnitValidator struct {
+	unit.UnitManager
+
+	mu      sync.RWMutex
+	invalid map[string]*unit.UnitState
+	// stat is used to check that the commands of a Unit exist
+	stat func(string) (os.FileInfo, error)
+	// verify, if set, must accept a Unit before anything else about it
+	// is validated
+	verify func(string, unit.UnitFile) error
+	// policy, if set, restricts the directives of the Units
+	policy *UnitPolicy
+}
+
+func NewUnitValidator(um unit.UnitManager) *UnitValidator {
+	return &UnitValidator{
+		UnitManager: um,
+		invalid:     make(map[string]*unit.UnitState),
+		stat:        os.Stat,
+	}
+}
+
+// SetVerifier has the UnitValidator refuse to load Units unless the given
+// function, such as SignatureVerifier.Verify, accepts them
+func (v *UnitValidator) SetVerifier(verify func(name string, uf unit.UnitFile) error) {
+	v.verify = verify
+}
+
+// SetPolicy has the UnitValidator refuse to load Units violating the given
+// UnitPolicy
+func (v *UnitValidator) SetPolicy(p *UnitPolicy) {
+	v.policy = p
+}
+
+// SignatureVerifier accepts only Units signed by a key its Verifier trusts.
+// The Signatures are those the AgentReconciler reads from the Registry
+// along with the desired state of the Agent, and keeps with it while the
+// Registry is unreachable, so that Units are verified without reaching it.
+type SignatureVerifier struct {
+	verifier *sign.Verifier
+
+	mu   sync.RWMutex
+	sigs map[string][]sign.Signature
+}
+
+func NewSignatureVerifier(verifier *sign.Verifier) *SignatureVerifier {
+	return &SignatureVerifier{verifier: verifier}
+}
+
+// Verify, for SetVerifier, returns an error unless the named Unit has a
+// trusted Signature. An instance without Signatures of its own, such as
+// one expanded by the Agent from a scaled template, is accepted if signed
+// as its template.
+func (sv *SignatureVerifier) Verify(name string, uf unit.UnitFile) error {
+	sv.mu.RLock()
+	sigs := sv.sigs[name]
+	sv.mu.RUnlock()
+
+	err := sv.verifier.Verify(name, uf, sigs)
+	if uni := unit.NewUnitNameInfo(name); err != nil && uni != nil && uni.IsInstance() {
+		if sv.verifier.Verify(uni.Template, uf, sigs) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// setSignatures replaces the Signatures of the Units, indexed by name
+func (sv *SignatureVerifier) setSignatures(sigs map[string][]sign.Signature) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	sv.sigs = sigs
+}
+
+// unitSignatures fetches the Signatures of each of the given Units from the
+// Registry, those of its template for an instance without any of its own
+func unitSignatures(reg registry.Registry, units map[string]*job.Unit) (map[string][]sign.Signature, error) {
+	all := make(map[string][]sign.Signature, len(units))
+	for name := range units {
+		sigs, err := reg.UnitSignatures(name)
+		if err != nil {
+			return nil, err
+		}
+		if uni := unit.NewUnitNameInfo(name); len(sigs) == 0 && uni != nil && uni.IsInstance() {
+			if sigs, err = reg.UnitSignatures(uni.Template); err != nil {
+				return nil, err
+			}
+		}
+		if len(sigs) > 0 {
+			all[name] = sigs
+		}
+	}
+	return all, nil
+}
+
+func (v *UnitValidator) Load(name string, uf unit.UnitFile) error {
+	if err := v.validate(name, uf); err != nil {
+		log.Errorf("Refusing to load invalid Unit(%s): %v", name, err)
+		v.mu.Lock()
+		v.invalid[name] = &unit.UnitState{
+			LoadState:     "error",
+			ActiveState:   "failed",
+			SubState:      SubStateInvalid,
+			UnitHash:      uf.Hash().String(),
+			UnitName:      name,
+			FailureReason: err.Error(),
+		}
+		v.mu.Unlock()
+		return nil
+	}
+
+	v.mu.Lock()
+	delete(v.invalid, name)
+	v.mu.Unlock()
+	return v.UnitManager.Load(name, uf)
+}
+
+// Check returns why Load would refuse the given Unit, if it would, without
+// loading it
+func (v *UnitValidator) Check(name string, uf unit.UnitFile) error {
+	return v.validate(name, uf)
+}
+
+func (v *UnitValidator) Unload(name string) {
+	if v.forget(name) {
+		return
+	}
+	v.UnitManager.Unload(name)
+}
+
+func (v *UnitValidator) TriggerStart(name string) {
+	if !v.isInvalid(name) {
+		v.UnitManager.TriggerStart(name)
+	}
+}
+
+func (v *UnitValidator) TriggerStop(name string) {
+	if !v.isInvalid(name) {
+		v.UnitManager.TriggerStop(name)
+	}
+}
+
+func (v *UnitValidator) TriggerRestart(name string) {
+	if v.isInvalid(name) {
+		return
+	}
+	if r, ok := v.UnitManager.(unitRestarter); ok {
+		r.TriggerRestart(name)
+	} else {
+		v.UnitManager.TriggerStop(name)
+		v.UnitManager.TriggerStart(name)
+	}
+}
+
+// Units returns the Units loaded by the underlying UnitManager along with
+// those that failed validation
+func (v *UnitValidator) Units() ([]string, error) {
+	units, err := v.UnitManager.Units()
+	if err != nil {
+		return nil, err
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for name := range v.invalid {
+		units = append(units, name)
+	}
+	return units, nil
+}
+
+func (v *UnitValidator) GetUnitState(name string) (*unit.UnitState, error) {
+	v.mu.RLock()
+	us, ok := v.invalid[name]
+	v.mu.RUnlock()
+	if ok {
+		cp := *us
+		return &cp, nil
+	}
+	return v.UnitManager.GetUnitState(name)
+}
+
+func (v *UnitValidator) GetUnitStates(filter pkg.Set) (map[string]*unit.UnitState, error) {
+	v.mu.RLock()
+	invalid := make(map[string]*unit.UnitState)
+	for name, us := range v.invalid {
+		if filter.Contains(name) {
+			cp := *us
+			invalid[name] = &cp
+		}
+	}
+	v.mu.RUnlock()
+
+	valid := filter.Copy()
+	for name := range invalid {
+		valid.Remove(name)
+	}
+	states, err := v.UnitManager.GetUnitStates(valid)
+	if err != nil {
+		return nil, err
+	}
+	for name, us := range invalid {
+		states[name] = us
+	}
+	return states, nil
+}
+
+func (v *UnitValidator) isInvalid(name string) bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	_, ok := v.invalid[name]
+	return ok
+}
+
+// forget drops the named Unit if it failed validation, returning whether
+// it had
+func (v *UnitValidator) forget(name string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	_, ok := v.invalid[name]
+	delete(v.invalid, name)
+	return ok
+}
+
+// validate returns an error describing why the given Unit cannot be run
+// on this machine: a rejection by the verifier, a violation of the policy,
+// an unrecognized or invalid [X-Fleet] option, or a command that does not
+// name an executable file by its absolute path
+func (v *UnitValidator) validate(name string, uf unit.UnitFile) error {
+	if v.verify != nil {
+		if err := v.verify(name, uf); err != nil {
+			return err
+		}
+	}
+
+	if !unit.RecognizedUnitType(name) {
+		return fmt.Errorf("unrecognized unit type")
+	}
+
+	for _, opt := range uf.Options {
+		if opt.Section == "" || opt.Name == "" {
+			return fmt.Errorf("malformed option %q in section %q", opt.Name, opt.Section)
+		}
+	}
+
+	if v.policy != nil {
+		if err := v.policy.Check(name, uf); err != nil {
+			return err
+		}
+	}
+
+	if err := job.NewJob(name, uf).ValidateRequirements(); err != nil {
+		return err
+	}
+
+	for _, key := range execOptions {
+		for _, cmd := range uf.Contents["Service"][key] {
+			if err := v.validateCommand(cmd); err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateCommand checks the executable of a command line of a [Service]
+// section, ignoring the prefixes systemd allows before it. Executables
+// named through specifiers or variables are only known to systemd.
+func (v *UnitValidator) validateCommand(cmd string) error {
+	path := commandExecutable(cmd)
+	if path == "" {
+		return fmt.Errorf("empty command")
+	}
+	if strings.ContainsAny(path, "%$") {
+		return nil
+	}
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("executable %q is not an absolute path", path)
+	}
+
+	fi, err := v.stat(path)
+	if err != nil {
+		return fmt.Errorf("executable %s not found", path)
+	}
+	if fi.IsDir() || fi.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("%s is not executable", path)
+	}
+	return nil
+}
+
+// commandExecutable returns the executable of a command line, without the
+// prefixes systemd allows before it, or an empty string if there is none
+func commandExecutable(cmd string) string {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.TrimLeft(fields[0], "-@+!:")
+}
@@ -0,0 +1,220 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	gossh "github.com/coreos/fleet/Godeps/_workspace/src/golang.org/x/crypto/ssh"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/sign"
+	"github.com/coreos/fleet/unit"
+)
+
+// fakeFileInfo describes a file with the given mode
+type fakeFileInfo struct {
+	os.FileInfo
+	mode os.FileMode
+}
+
+func (fi fakeFileInfo) Mode() os.FileMode { return fi.mode }
+func (fi fakeFileInfo) IsDir() bool       { return fi.mode.IsDir() }
+
+func newTestUnitValidator() (*UnitValidator, *unit.FakeUnitManager) {
+	fum := unit.NewFakeUnitManager()
+	v := NewUnitValidator(fum)
+	files := map[string]os.FileMode{
+		"/usr/bin/app":  0755,
+		"/etc/app.conf": 0644,
+		"/usr/bin":      os.ModeDir | 0755,
+	}
+	v.stat = func(path string) (os.FileInfo, error) {
+		if mode, ok := files[path]; ok {
+			return fakeFileInfo{mode: mode}, nil
+		}
+		return nil, os.ErrNotExist
+	}
+	return v, fum
+}
+
+func TestUnitValidatorValidate(t *testing.T) {
+	v, _ := newTestUnitValidator()
+	for i, tt := range []struct {
+		name     string
+		contents string
+		valid    bool
+	}{
+		{"foo.service", "[Service]\nExecStart=/usr/bin/app --serve", true},
+		{"foo.service", "[Service]\nExecStartPre=-/usr/bin/app --init\nExecStart=@/usr/bin/app app", true},
+		{"foo.service", "[Service]\nExecStart=/usr/bin/%i", true},
+		{"foo.service", "[Service]\nExecStart=${APP} --serve", true},
+		{"foo.service", "[X-Fleet]\nMachineMetadata=region=us-east", true},
+
+		{"foo.service", "[Service]\nExecStart=/usr/bin/missing", false},
+		{"foo.service", "[Service]\nExecStart=app --serve", false},
+		{"foo.service", "[Service]\nExecStop=/etc/app.conf", false},
+		{"foo.service", "[Service]\nExecStart=/usr/bin", false},
+		{"foo.service", "[X-Fleet]\nMachineMetdata=region=us-east", false},
+		{"foo.service", "[X-Fleet]\nCores=lots", false},
+		{"foo.bar", "[Service]\nExecStart=/usr/bin/app", false},
+	} {
+		err := v.validate(tt.name, newUF(t, tt.contents))
+		if (err == nil) != tt.valid {
+			t.Errorf("case %d: expected valid=%t, got err=%v", i, tt.valid, err)
+		}
+	}
+}
+
+func TestUnitValidatorInvalidUnit(t *testing.T) {
+	v, fum := newTestUnitValidator()
+
+	invalid := newUF(t, "[Service]\nExecStart=/usr/bin/missing")
+	if err := v.Load("foo.service", invalid); err != nil {
+		t.Fatalf("Unexpected error loading invalid unit: %v", err)
+	}
+	if err := v.Load("bar.service", newUF(t, "[Service]\nExecStart=/usr/bin/app")); err != nil {
+		t.Fatalf("Unexpected error loading valid unit: %v", err)
+	}
+
+	// the invalid unit never reaches the underlying UnitManager...
+	if units, _ := fum.Units(); len(units) != 1 || units[0] != "bar.service" {
+		t.Errorf("Expected only bar.service to be loaded, got %v", units)
+	}
+	v.TriggerStart("foo.service")
+
+	// ...but is reported loaded, and failed with the reason why
+	if units, _ := v.Units(); len(units) != 2 {
+		t.Errorf("Expected 2 units, got %v", units)
+	}
+	states, err := v.GetUnitStates(pkg.NewUnsafeSet("foo.service", "bar.service"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	us := states["foo.service"]
+	if us == nil || us.ActiveState != "failed" || us.SubState != SubStateInvalid || us.UnitHash != invalid.Hash().String() || us.FailureReason == "" {
+		t.Errorf("Unexpected state of invalid unit: %#v", us)
+	}
+	if states["bar.service"] == nil {
+		t.Errorf("Expected state of valid unit")
+	}
+
+	// replacing it with a valid version loads it
+	if err := v.Load("foo.service", newUF(t, "[Service]\nExecStart=/usr/bin/app")); err != nil {
+		t.Fatalf("Unexpected error loading valid unit: %v", err)
+	}
+	if us, _ := v.GetUnitState("foo.service"); us != nil && us.SubState == SubStateInvalid {
+		t.Errorf("Expected foo.service to no longer be invalid, got %#v", us)
+	}
+
+	// and unloading an invalid unit forgets about it
+	v.Load("baz.service", invalid)
+	v.Unload("baz.service")
+	if units, _ := v.Units(); len(units) != 2 {
+		t.Errorf("Expected 2 units, got %v", units)
+	}
+}
+
+func newTestSigner(t *testing.T) gossh.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return signer
+}
+
+func TestUnitValidatorSignatures(t *testing.T) {
+	signer := newTestSigner(t)
+	reg := registry.NewFakeRegistry()
+	v, fum := newTestUnitValidator()
+	sv := NewSignatureVerifier(sign.NewVerifier([]gossh.PublicKey{signer.PublicKey()}))
+	v.SetVerifier(sv.Verify)
+
+	uf := newUF(t, "[Service]\nExecStart=/usr/bin/app")
+	sigs, err := sign.SignUnit([]gossh.Signer{signer}, "signed.service", uf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reg.SetUnitSignatures("signed.service", sigs)
+	// the same signature does not vouch for a tampered version
+	reg.SetUnitSignatures("tampered.service", sigs)
+	// instances expanded from a signed template are vouched for by it
+	tsigs, err := sign.SignUnit([]gossh.Signer{signer}, "web@.service", uf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reg.SetUnitSignatures("web@.service", tsigs)
+
+	units := make(map[string]*job.Unit)
+	for _, name := range []string{"signed.service", "unsigned.service", "tampered.service", "web@3.service", "web@4.service"} {
+		units[name] = &job.Unit{Name: name}
+	}
+	all, err := unitSignatures(reg, units)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sv.setSignatures(all)
+
+	v.Load("signed.service", uf)
+	v.Load("unsigned.service", uf)
+	v.Load("tampered.service", newUF(t, "[Service]\nExecStart=/usr/bin/app --evil"))
+	v.Load("web@3.service", uf)
+	v.Load("web@4.service", newUF(t, "[Service]\nExecStart=/usr/bin/app --evil"))
+
+	loaded, _ := fum.Units()
+	sort.Strings(loaded)
+	if want := []string{"signed.service", "web@3.service"}; !reflect.DeepEqual(want, loaded) {
+		t.Errorf("expected only the signed units %v to be loaded, got %v", want, loaded)
+	}
+	for _, name := range []string{"unsigned.service", "tampered.service", "web@4.service"} {
+		us, err := v.GetUnitState(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if us.SubState != SubStateInvalid || us.FailureReason == "" {
+			t.Errorf("expected %s to be reported invalid, got %#v", name, us)
+		}
+	}
+}
+
+func TestUnitValidatorPolicy(t *testing.T) {
+	v, fum := newTestUnitValidator()
+	p, err := ParseUnitPolicy("[Policy]\nDenyDirective=Service.BindPaths\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v.SetPolicy(p)
+
+	v.Load("foo.service", newUF(t, "[Service]\nExecStart=/usr/bin/app\nBindPaths=/etc"))
+	if units, _ := fum.Units(); len(units) != 0 {
+		t.Fatalf("expected unit violating the policy not to be loaded, got %v", units)
+	}
+	us, _ := v.GetUnitState("foo.service")
+	if us == nil || us.SubState != SubStateInvalid || us.FailureReason != "unit policy denies directive Service.BindPaths" {
+		t.Fatalf("expected unit to be reported invalid, got %#v", us)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/registry"
+)
+
+func wireUpAuditResource(mux *http.ServeMux, prefix string, audit registry.AuditRegistry) {
+	res := path.Join(prefix, "audit")
+	ar := auditResource{audit}
+	mux.Handle(res, &ar)
+}
+
+// auditResource exposes the log of changes made to Units, optionally
+// only those of the Unit named by the unit query parameter
+type auditResource struct {
+	audit registry.AuditRegistry
+}
+
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Unit      string    `json:"unit"`
+	Detail    string    `json:"detail,omitempty"`
+	MachineID string    `json:"machineID,omitempty"`
+	Identity  string    `json:"identity"`
+}
+
+type auditPage struct {
+	Entries []auditEntry `json:"entries"`
+}
+
+func (ar *auditResource) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		sendError(rw, http.StatusMethodNotAllowed, errors.New("only GET supported against this resource"))
+		return
+	}
+
+	entries, err := ar.audit.AuditLog()
+	if err != nil {
+		log.Errorf("Failed fetching audit log: %v", err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	sendResponse(rw, http.StatusOK, auditPage{Entries: mapAuditEntries(entries, req.URL.Query().Get("unit"))})
+}
+
+func mapAuditEntries(entries []job.AuditEntry, unit string) []auditEntry {
+	mapped := make([]auditEntry, 0, len(entries))
+	for _, e := range entries {
+		if unit != "" && e.JobName != unit {
+			continue
+		}
+		mapped = append(mapped, auditEntry{
+			Time:      e.Time,
+			Operation: string(e.Operation),
+			Unit:      e.JobName,
+			Detail:    e.Detail,
+			MachineID: e.MachineID,
+			Identity:  e.Identity,
+		})
+	}
+	return mapped
+}
+
+func wireUpAuditedUnitsResource(mux *http.ServeMux, prefix string, reg *registry.AuditedRegistry) {
+	base := path.Join(prefix, "units")
+	aur := auditedUnitsResource{reg, base}
+	mux.Handle(base, &aur)
+	mux.Handle(base+"/", &aur)
+}
+
+// auditedUnitsResource attributes the changes made through the units
+// resource to the client making each request
+type auditedUnitsResource struct {
+	reg      *registry.AuditedRegistry
+	basePath string
+}
+
+func (aur *auditedUnitsResource) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	reg := aur.reg.WithIdentity(requestIdentity(req))
+	ur := unitsResource{&client.RegistryClient{Registry: reg}, aur.basePath}
+	ur.ServeHTTP(rw, req)
+}
+
+// requestIdentity describes the client making an API request by the
+// common name of its TLS certificate or its basic auth username, if any,
+// and its address
+func requestIdentity(req *http.Request) string {
+	user := "anonymous"
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		user = req.TLS.PeerCertificates[0].Subject.CommonName
+	} else if name, _, ok := req.BasicAuth(); ok {
+		user = name
+	}
+	return fmt.Sprintf("api:%s@%s", user, req.RemoteAddr)
+}
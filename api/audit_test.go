@@ -0,0 +1,85 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/registry"
+)
+
+func TestAuditList(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	at := time.Date(2014, time.October, 15, 10, 30, 0, 0, time.UTC)
+	fr.RecordAudit(job.AuditEntry{Time: at, Operation: job.AuditOperationCreate, JobName: "foo.service", Detail: "launched", Identity: "fleetctl:alice@laptop"})
+	fr.RecordAudit(job.AuditEntry{Time: at, Operation: job.AuditOperationSchedule, JobName: "bar.service", Detail: "YYY", MachineID: "XXX", Identity: "engine"})
+
+	resource := &auditResource{fr}
+	for _, tt := range []struct {
+		url  string
+		want string
+	}{
+		{
+			"http://example.com/audit",
+			`{"entries":[{"time":"2014-10-15T10:30:00Z","operation":"create","unit":"foo.service","detail":"launched","identity":"fleetctl:alice@laptop"},{"time":"2014-10-15T10:30:00Z","operation":"schedule","unit":"bar.service","detail":"YYY","machineID":"XXX","identity":"engine"}]}`,
+		},
+		{
+			"http://example.com/audit?unit=bar.service",
+			`{"entries":[{"time":"2014-10-15T10:30:00Z","operation":"schedule","unit":"bar.service","detail":"YYY","machineID":"XXX","identity":"engine"}]}`,
+		},
+	} {
+		rw := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", tt.url, nil)
+		if err != nil {
+			t.Fatalf("Failed creating http.Request: %v", err)
+		}
+
+		resource.ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rw.Code)
+		}
+		if got := rw.Body.String(); got != tt.want {
+			t.Errorf("Expected body:\n%s\n\nReceived body:\n%s\n", tt.want, got)
+		}
+	}
+}
+
+func TestAuditedUnitsIdentity(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	hdlr := NewServeMux(registry.NewAuditedRegistry(fr, fr, "XXX", "api"), 0, nil)
+
+	req, err := http.NewRequest("DELETE", "/fleet/v1/units/foo.service", nil)
+	if err != nil {
+		t.Fatalf("Failed creating http.Request: %v", err)
+	}
+	req.SetBasicAuth("alice", "secret")
+	req.RemoteAddr = "10.0.0.1:1234"
+	fr.CreateUnit(&job.Unit{Name: "foo.service"})
+
+	rw := httptest.NewRecorder()
+	hdlr.ServeHTTP(rw, req)
+	if rw.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rw.Code)
+	}
+
+	entries, _ := fr.AuditLog()
+	if len(entries) != 1 || entries[0].Identity != "api:alice@10.0.0.1:1234" {
+		t.Errorf("Expected the destruction to be attributed to alice, got %v", entries)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+)
+
+func wireUpDecisionsResource(mux *http.ServeMux, prefix string, cAPI client.API) {
+	base := path.Join(prefix, "decisions")
+	dr := decisionsResource{cAPI, base}
+	mux.Handle(base+"/", &dr)
+}
+
+// decisionsResource exposes the scheduling decisions the engine recorded
+// for each Unit
+type decisionsResource struct {
+	cAPI     client.API
+	basePath string
+}
+
+type decisionCandidate struct {
+	MachineID string  `json:"machineID"`
+	Able      bool    `json:"able"`
+	Score     float64 `json:"score"`
+	Reason    string  `json:"reason,omitempty"`
+}
+
+type decision struct {
+	Time       time.Time           `json:"time"`
+	Type       string              `json:"type"`
+	MachineID  string              `json:"machineID"`
+	Reason     string              `json:"reason"`
+	Candidates []decisionCandidate `json:"candidates,omitempty"`
+}
+
+type unschedulable struct {
+	Reason   string    `json:"reason"`
+	Failures int       `json:"failures"`
+	RetryAt  time.Time `json:"retryAt"`
+}
+
+type decisionPage struct {
+	Decisions     []decision     `json:"decisions"`
+	Unschedulable *unschedulable `json:"unschedulable,omitempty"`
+}
+
+func (dr *decisionsResource) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	item, ok := isItemPath(dr.basePath, req.URL.Path)
+	if !ok {
+		sendError(rw, http.StatusNotFound, nil)
+		return
+	}
+	if req.Method != "GET" {
+		sendError(rw, http.StatusMethodNotAllowed, errors.New("only GET supported against this resource"))
+		return
+	}
+
+	decs, err := dr.cAPI.Decisions(item)
+	if err != nil {
+		log.Errorf("Failed fetching scheduling decisions of Unit(%s): %v", item, err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	u, err := dr.cAPI.Unschedulable(item)
+	if err != nil {
+		log.Errorf("Failed fetching unschedulable state of Unit(%s): %v", item, err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	page := decisionPage{Decisions: mapDecisions(decs)}
+	if u != nil {
+		page.Unschedulable = &unschedulable{Reason: u.Reason, Failures: u.Failures, RetryAt: u.RetryAt}
+	}
+	sendResponse(rw, http.StatusOK, page)
+}
+
+func mapDecisions(decs []job.Decision) []decision {
+	mapped := make([]decision, 0, len(decs))
+	for _, d := range decs {
+		md := decision{
+			Time:      d.Time,
+			Type:      string(d.Type),
+			MachineID: d.MachineID,
+			Reason:    d.Reason,
+		}
+		if len(d.Candidates) > 0 {
+			md.Candidates = mapCandidates(d.Candidates)
+		}
+		mapped = append(mapped, md)
+	}
+	return mapped
+}
+
+func mapCandidates(cands []job.Candidate) []decisionCandidate {
+	mapped := make([]decisionCandidate, 0, len(cands))
+	for _, c := range cands {
+		mapped = append(mapped, decisionCandidate{
+			MachineID: c.MachineID,
+			Able:      c.Able,
+			Score:     c.Score,
+			Reason:    c.Reason,
+		})
+	}
+	return mapped
+}
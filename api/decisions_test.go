@@ -0,0 +1,105 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/registry"
+)
+
+func TestDecisionsList(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	at := time.Date(2014, time.October, 15, 10, 30, 0, 0, time.UTC)
+	fr.RecordDecision("foo.service", job.Decision{
+		Time:      at,
+		Type:      job.DecisionTypeSchedule,
+		MachineID: "XXX",
+		Reason:    "target state launched and unit not scheduled",
+		Candidates: []job.Candidate{
+			{MachineID: "XXX", Able: true, Score: 1},
+			{MachineID: "YYY", Able: false, Reason: "local Machine metadata insufficient"},
+		},
+	}, 10)
+	fr.RecordDecision("foo.service", job.Decision{
+		Time:      at.Add(time.Minute),
+		Type:      job.DecisionTypeUnschedule,
+		MachineID: "XXX",
+		Reason:    "target state inactive",
+	}, 10)
+	fr.SetUnschedulable("bar.service", &job.Unschedulable{Reason: "no agents able to run job", Failures: 2, RetryAt: at})
+
+	resource := &decisionsResource{&client.RegistryClient{Registry: fr}, "/decisions"}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{
+			"/decisions/foo.service",
+			`{"decisions":[{"time":"2014-10-15T10:30:00Z","type":"schedule","machineID":"XXX","reason":"target state launched and unit not scheduled","candidates":[{"machineID":"XXX","able":true,"score":1},{"machineID":"YYY","able":false,"score":0,"reason":"local Machine metadata insufficient"}]},{"time":"2014-10-15T10:31:00Z","type":"unschedule","machineID":"XXX","reason":"target state inactive"}]}`,
+		},
+		{
+			"/decisions/bar.service",
+			`{"decisions":[],"unschedulable":{"reason":"no agents able to run job","failures":2,"retryAt":"2014-10-15T10:30:00Z"}}`,
+		},
+	}
+
+	for i, tt := range tests {
+		rw := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://example.com"+tt.path, nil)
+		if err != nil {
+			t.Fatalf("case %d: failed creating http.Request: %v", i, err)
+		}
+
+		resource.ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Errorf("case %d: expected 200, got %d", i, rw.Code)
+			continue
+		}
+		if got := rw.Body.String(); got != tt.want {
+			t.Errorf("case %d: expected body:\n%s\n\nReceived body:\n%s\n", i, tt.want, got)
+		}
+	}
+}
+
+func TestDecisionsBadRequest(t *testing.T) {
+	resource := &decisionsResource{&client.RegistryClient{Registry: registry.NewFakeRegistry()}, "/decisions"}
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"POST", "/decisions/foo.service", http.StatusMethodNotAllowed},
+		{"GET", "/decisions/", http.StatusNotFound},
+	}
+
+	for i, tt := range tests {
+		rw := httptest.NewRecorder()
+		req, err := http.NewRequest(tt.method, "http://example.com"+tt.path, nil)
+		if err != nil {
+			t.Fatalf("case %d: failed creating http.Request: %v", i, err)
+		}
+
+		resource.ServeHTTP(rw, req)
+		if err := assertErrorResponse(rw, tt.code); err != nil {
+			t.Errorf("case %d: %v", i, err)
+		}
+	}
+}
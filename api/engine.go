@@ -0,0 +1,81 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/machine"
+)
+
+func wireUpEngineResource(mux *http.ServeMux, prefix string, cAPI client.API) {
+	res := path.Join(prefix, "engine")
+	er := engineResource{cAPI}
+	mux.Handle(res, &er)
+}
+
+// engineResource exposes which engines lead the schedule of the cluster
+type engineResource struct {
+	cAPI client.API
+}
+
+type engineStatus struct {
+	MachineID     string     `json:"machineID"`
+	Shards        []int      `json:"shards"`
+	LeaseExpiry   time.Time  `json:"leaseExpiry"`
+	LastReconcile *time.Time `json:"lastReconcile,omitempty"`
+}
+
+type enginePage struct {
+	Engines []engineStatus `json:"engines"`
+}
+
+func (er *engineResource) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		sendError(rw, http.StatusMethodNotAllowed, errors.New("only GET supported against this resource"))
+		return
+	}
+
+	statuses, err := er.cAPI.EngineStatuses()
+	if err != nil {
+		log.Errorf("Failed fetching engine statuses: %v", err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	sendResponse(rw, http.StatusOK, enginePage{Engines: mapEngineStatuses(statuses)})
+}
+
+func mapEngineStatuses(statuses []machine.EngineStatus) []engineStatus {
+	mapped := make([]engineStatus, 0, len(statuses))
+	for _, st := range statuses {
+		ms := engineStatus{
+			MachineID:   st.MachineID,
+			Shards:      st.Shards,
+			LeaseExpiry: st.LeaseExpiry,
+		}
+		if !st.LastReconcile.IsZero() {
+			last := st.LastReconcile
+			ms.LastReconcile = &last
+		}
+		mapped = append(mapped, ms)
+	}
+	return mapped
+}
@@ -0,0 +1,56 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+)
+
+func TestEngineStatusList(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	at := time.Date(2014, time.October, 15, 10, 30, 0, 0, time.UTC)
+	fr.SetEngineStatus(machine.EngineStatus{MachineID: "YYY", Shards: []int{1}, LeaseExpiry: at}, time.Minute)
+	fr.SetEngineStatus(machine.EngineStatus{MachineID: "XXX", Shards: []int{0, 2}, LeaseExpiry: at, LastReconcile: at.Add(-time.Second)}, time.Minute)
+
+	resource := &engineResource{&client.RegistryClient{Registry: fr}}
+	rw := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://example.com/engine", nil)
+	if err != nil {
+		t.Fatalf("Failed creating http.Request: %v", err)
+	}
+
+	resource.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rw.Code)
+	}
+	want := `{"engines":[{"machineID":"XXX","shards":[0,2],"leaseExpiry":"2014-10-15T10:30:00Z","lastReconcile":"2014-10-15T10:29:59Z"},{"machineID":"YYY","shards":[1],"leaseExpiry":"2014-10-15T10:30:00Z"}]}`
+	if got := rw.Body.String(); got != want {
+		t.Errorf("Expected body:\n%s\n\nReceived body:\n%s\n", want, got)
+	}
+
+	req, _ = http.NewRequest("POST", "http://example.com/engine", nil)
+	rw = httptest.NewRecorder()
+	resource.ServeHTTP(rw, req)
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", rw.Code)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/schema"
+)
+
+func wireUpHistoryResource(mux *http.ServeMux, prefix string, cAPI client.API) {
+	base := path.Join(prefix, "history")
+	hr := historyResource{cAPI, base}
+	mux.Handle(base+"/", &hr)
+
+	hlr := historyLimitResource{cAPI}
+	mux.Handle(path.Join(prefix, "history-limit"), &hlr)
+}
+
+// historyResource exposes the Revisions retained of each Unit, including
+// those since destroyed
+type historyResource struct {
+	cAPI     client.API
+	basePath string
+}
+
+type revision struct {
+	Number       int                  `json:"number"`
+	Time         time.Time            `json:"time"`
+	Event        string               `json:"event"`
+	DesiredState string               `json:"desiredState,omitempty"`
+	Options      []*schema.UnitOption `json:"options"`
+}
+
+type historyPage struct {
+	Revisions []revision `json:"revisions"`
+}
+
+func (hr *historyResource) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	item, ok := isItemPath(hr.basePath, req.URL.Path)
+	if !ok {
+		sendError(rw, http.StatusNotFound, nil)
+		return
+	}
+	if req.Method != "GET" {
+		sendError(rw, http.StatusMethodNotAllowed, errors.New("only GET supported against this resource"))
+		return
+	}
+
+	revs, err := hr.cAPI.UnitHistory(item)
+	if err != nil {
+		log.Errorf("Failed fetching history of Unit(%s): %v", item, err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	sendResponse(rw, http.StatusOK, historyPage{Revisions: mapRevisions(revs)})
+}
+
+func mapRevisions(revs []job.Revision) []revision {
+	mapped := make([]revision, 0, len(revs))
+	for _, r := range revs {
+		uf := r.Unit
+		mapped = append(mapped, revision{
+			Number:       r.Number,
+			Time:         r.Time,
+			Event:        string(r.Event),
+			DesiredState: string(r.TargetState),
+			Options:      schema.MapUnitFileToSchemaUnitOptions(&uf),
+		})
+	}
+	return mapped
+}
+
+// historyLimitResource exposes the cluster-wide number of Revisions
+// retained of each Unit, and allows operators to change it
+type historyLimitResource struct {
+	cAPI client.API
+}
+
+type historyLimit struct {
+	Limit int `json:"limit"`
+}
+
+func (hlr *historyLimitResource) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		hlr.get(rw)
+	case "PUT":
+		hlr.set(rw, req)
+	default:
+		sendError(rw, http.StatusMethodNotAllowed, errors.New("only GET and PUT supported against this resource"))
+	}
+}
+
+func (hlr *historyLimitResource) get(rw http.ResponseWriter) {
+	limit, err := hlr.cAPI.UnitHistoryLimit()
+	if err != nil {
+		log.Errorf("Failed fetching unit history limit: %v", err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+	sendResponse(rw, http.StatusOK, historyLimit{Limit: limit})
+}
+
+func (hlr *historyLimitResource) set(rw http.ResponseWriter, req *http.Request) {
+	if err := validateContentType(req); err != nil {
+		sendError(rw, http.StatusUnsupportedMediaType, err)
+		return
+	}
+
+	var body historyLimit
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		sendError(rw, http.StatusBadRequest, fmt.Errorf("unable to decode body: %v", err))
+		return
+	}
+	if body.Limit < 0 {
+		sendError(rw, http.StatusBadRequest, fmt.Errorf("invalid history limit %d", body.Limit))
+		return
+	}
+
+	if err := hlr.cAPI.SetUnitHistoryLimit(body.Limit); err != nil {
+		log.Errorf("Failed setting unit history limit: %v", err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,98 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestHistoryList(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	fr.SetUnitHistoryLimit(10)
+	uf, _ := unit.NewUnitFile("[Service]\nExecStart=/bin/true")
+	fr.CreateUnit(&job.Unit{Name: "foo.service", Unit: *uf, TargetState: job.JobStateLaunched})
+	fr.DestroyUnit("foo.service")
+
+	resource := &historyResource{&client.RegistryClient{Registry: fr}, "/history"}
+	rw := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://example.com/history/foo.service", nil)
+	if err != nil {
+		t.Fatalf("Failed creating http.Request: %v", err)
+	}
+
+	resource.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rw.Code)
+	}
+
+	// times are those at which the Revisions were recorded
+	got := regexp.MustCompile(`"time":"[^"]*"`).ReplaceAllString(rw.Body.String(), `"time":""`)
+	want := `{"revisions":[{"number":1,"time":"","event":"create","desiredState":"launched","options":[{"name":"ExecStart","section":"Service","value":"/bin/true"}]},{"number":2,"time":"","event":"destroy","options":[{"name":"ExecStart","section":"Service","value":"/bin/true"}]}]}`
+	if got != want {
+		t.Errorf("Expected body:\n%s\n\nReceived body:\n%s\n", want, got)
+	}
+
+	req, _ = http.NewRequest("POST", "http://example.com/history/foo.service", nil)
+	rw = httptest.NewRecorder()
+	resource.ServeHTTP(rw, req)
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", rw.Code)
+	}
+}
+
+func TestHistoryLimitResource(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	resource := &historyLimitResource{&client.RegistryClient{Registry: fr}}
+
+	do := func(method, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "http://example.com/history-limit", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed creating http.Request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		rw := httptest.NewRecorder()
+		resource.ServeHTTP(rw, req)
+		return rw
+	}
+
+	want := `{"limit":0}`
+	if got := do("GET", "").Body.String(); got != want {
+		t.Errorf("Expected body:\n%s\n\nReceived body:\n%s\n", want, got)
+	}
+
+	if rw := do("PUT", `{"limit":10}`); rw.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rw.Code)
+	}
+	want = `{"limit":10}`
+	if got := do("GET", "").Body.String(); got != want {
+		t.Errorf("Expected body:\n%s\n\nReceived body:\n%s\n", want, got)
+	}
+
+	if rw := do("PUT", `{"limit":-1}`); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for negative limit, got %d", rw.Code)
+	}
+	if rw := do("DELETE", ""); rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for DELETE, got %d", rw.Code)
+	}
+}
@@ -29,6 +29,7 @@ func wireUpMachinesResource(mux *http.ServeMux, prefix string, cAPI client.API)
 	res := path.Join(prefix, "machines")
 	mr := machinesResource{cAPI}
 	mux.Handle(res, &mr)
+	mux.Handle(res+"/", &metadataResource{cAPI, res})
 }
 
 type machinesResource struct {
@@ -0,0 +1,93 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/machine"
+)
+
+// metadataResource allows operators to set and remove metadata of Machines
+// at runtime, at paths of the form machines/<machine>/metadata/<key>
+type metadataResource struct {
+	cAPI     client.API
+	basePath string
+}
+
+type metadataValue struct {
+	Value string `json:"value"`
+}
+
+func (mr *metadataResource) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, mr.basePath+"/"), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] != "metadata" {
+		sendError(rw, http.StatusNotFound, nil)
+		return
+	}
+	machID, key := parts[0], parts[2]
+	if err := machine.ValidateMetadataKey(key); err != nil {
+		sendError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	switch req.Method {
+	case "PUT":
+		mr.set(rw, req, machID, key)
+	case "DELETE":
+		mr.remove(rw, machID, key)
+	default:
+		sendError(rw, http.StatusMethodNotAllowed, errors.New("only PUT and DELETE supported against this resource"))
+	}
+}
+
+func (mr *metadataResource) set(rw http.ResponseWriter, req *http.Request, machID, key string) {
+	if err := validateContentType(req); err != nil {
+		sendError(rw, http.StatusUnsupportedMediaType, err)
+		return
+	}
+
+	var body metadataValue
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		sendError(rw, http.StatusBadRequest, fmt.Errorf("unable to decode body: %v", err))
+		return
+	}
+	if body.Value == "" {
+		sendError(rw, http.StatusBadRequest, errors.New("metadata value must not be empty"))
+		return
+	}
+
+	if err := mr.cAPI.SetMachineMetadata(machID, key, body.Value); err != nil {
+		log.Errorf("Failed setting metadata %q of Machine(%s): %v", key, machID, err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (mr *metadataResource) remove(rw http.ResponseWriter, machID, key string) {
+	if err := mr.cAPI.SetMachineMetadata(machID, key, ""); err != nil {
+		log.Errorf("Failed removing metadata %q of Machine(%s): %v", key, machID, err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
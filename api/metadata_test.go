@@ -0,0 +1,77 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+)
+
+func TestMetadataResource(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	fr.SetMachines([]machine.MachineState{{ID: "XXX", Metadata: map[string]string{"region": "us-east"}}})
+	resource := &metadataResource{&client.RegistryClient{Registry: fr}, "/machines"}
+
+	do := func(method, p, body string) int {
+		req, err := http.NewRequest(method, "http://example.com"+p, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed creating http.Request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		rw := httptest.NewRecorder()
+		resource.ServeHTTP(rw, req)
+		return rw.Code
+	}
+	metadata := func() map[string]string {
+		machines, _ := fr.Machines()
+		return machines[0].Metadata
+	}
+
+	if code := do("PUT", "/machines/XXX/metadata/disk", `{"value":"ssd"}`); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+	if want := map[string]string{"region": "us-east", "disk": "ssd"}; !reflect.DeepEqual(want, metadata()) {
+		t.Errorf("Expected metadata %v, got %v", want, metadata())
+	}
+
+	if code := do("DELETE", "/machines/XXX/metadata/disk", ""); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+	if want := map[string]string{"region": "us-east"}; !reflect.DeepEqual(want, metadata()) {
+		t.Errorf("Expected metadata %v, got %v", want, metadata())
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{"PUT", "/machines/XXX/metadata/disk", `{"value":""}`, http.StatusBadRequest},
+		{"PUT", "/machines/XXX/metadata/a=b", `{"value":"c"}`, http.StatusBadRequest},
+		{"GET", "/machines/XXX/metadata/disk", "", http.StatusMethodNotAllowed},
+		{"PUT", "/machines/XXX/schedulability", `{"value":"c"}`, http.StatusNotFound},
+		{"PUT", "/machines/XXX/metadata", `{"value":"c"}`, http.StatusNotFound},
+	} {
+		if code := do(tt.method, tt.path, tt.body); code != tt.code {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.code, code)
+		}
+	}
+}
@@ -15,6 +15,7 @@
 package api
 
 import (
+	"expvar"
 	"net/http"
 
 	"github.com/coreos/fleet/client"
@@ -23,18 +24,37 @@ import (
 	"github.com/coreos/fleet/version"
 )
 
-func NewServeMux(reg registry.Registry) http.Handler {
+// NewServeMux returns the HTTP handler of the fleet API. The placement
+// resource limits each Machine to maxUnits Units and rates Machines using
+// the given scorer weights, as the engine does. If reg is an
+// AuditedRegistry, changes to Units are attributed to the client making
+// each request and the audit log is served.
+func NewServeMux(reg registry.Registry, maxUnits int, weights map[string]float64) http.Handler {
 	sm := http.NewServeMux()
 	cAPI := &client.RegistryClient{Registry: reg}
 
 	for _, prefix := range []string{"/v1-alpha", "/fleet/v1"} {
+		wireUpDecisionsResource(sm, prefix, cAPI)
 		wireUpDiscoveryResource(sm, prefix)
+		wireUpEngineResource(sm, prefix, cAPI)
+		wireUpHistoryResource(sm, prefix, cAPI)
 		wireUpMachinesResource(sm, prefix, cAPI)
+		wireUpPlacementResource(sm, prefix, reg, maxUnits, weights)
+		wireUpSchedulabilityResource(sm, prefix, cAPI)
+		wireUpSignaturesResource(sm, prefix, cAPI)
+		wireUpSnapshotResource(sm, prefix, reg)
 		wireUpStateResource(sm, prefix, cAPI)
-		wireUpUnitsResource(sm, prefix, cAPI)
+		wireUpVersionResource(sm, prefix, cAPI)
+		if areg, ok := reg.(*registry.AuditedRegistry); ok {
+			wireUpAuditResource(sm, prefix, areg)
+			wireUpAuditedUnitsResource(sm, prefix, areg)
+		} else {
+			wireUpUnitsResource(sm, prefix, cAPI)
+		}
 		sm.HandleFunc(prefix, methodNotAllowedHandler)
 	}
 
+	sm.Handle("/debug/vars", expvar.Handler())
 	sm.HandleFunc("/", baseHandler)
 
 	hdlr := http.Handler(sm)
@@ -62,6 +82,27 @@ func (si *serverInfoMiddleware) ServeHTTP(rw http.ResponseWriter, req *http.Requ
 	si.next.ServeHTTP(rw, req)
 }
 
+// ReadOnly wraps the handler of the fleet API so that requests changing
+// the cluster are answered with 503 Service Unavailable, as is the case
+// while fleetd runs in read-only mode. Every other request, including
+// placement queries, is passed through.
+func ReadOnly(next http.Handler) http.Handler {
+	return &readOnlyMiddleware{next}
+}
+
+type readOnlyMiddleware struct {
+	next http.Handler
+}
+
+func (ro *readOnlyMiddleware) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "PUT", "DELETE", "PATCH":
+		sendError(rw, http.StatusServiceUnavailable, registry.ErrReadOnly)
+		return
+	}
+	ro.next.ServeHTTP(rw, req)
+}
+
 func methodNotAllowedHandler(rw http.ResponseWriter, req *http.Request) {
 	sendError(rw, http.StatusMethodNotAllowed, nil)
 }
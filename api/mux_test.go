@@ -38,7 +38,7 @@ func TestDefaultHandlers(t *testing.T) {
 
 	for i, tt := range tests {
 		fr := registry.NewFakeRegistry()
-		hdlr := NewServeMux(fr)
+		hdlr := NewServeMux(fr, 0, nil)
 		rr := httptest.NewRecorder()
 
 		req, err := http.NewRequest(tt.method, tt.path, nil)
@@ -61,3 +61,31 @@ func TestDefaultHandlers(t *testing.T) {
 		}
 	}
 }
+
+func TestReadOnly(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"GET", "/fleet/v1/units", http.StatusOK},
+		{"PUT", "/fleet/v1/units/foo.service", http.StatusServiceUnavailable},
+		{"DELETE", "/fleet/v1/units/foo.service", http.StatusServiceUnavailable},
+	}
+
+	for i, tt := range tests {
+		hdlr := ReadOnly(NewServeMux(registry.NewFakeRegistry(), 0, nil))
+		rr := httptest.NewRecorder()
+
+		req, err := http.NewRequest(tt.method, tt.path, nil)
+		if err != nil {
+			t.Errorf("case %d: failed setting up http.Request for test: %v", i, err)
+			continue
+		}
+
+		hdlr.ServeHTTP(rr, req)
+		if rr.Code != tt.code {
+			t.Errorf("case %d: expected %d, got %d", i, tt.code, rr.Code)
+		}
+	}
+}
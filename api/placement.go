@@ -0,0 +1,90 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/coreos/fleet/engine"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/schema"
+)
+
+func wireUpPlacementResource(mux *http.ServeMux, prefix string, reg registry.Registry, maxUnits int, weights map[string]float64) {
+	res := path.Join(prefix, "placement")
+	pr := placementResource{reg, maxUnits, weights}
+	mux.Handle(res, &pr)
+}
+
+// placementResource simulates the scheduling of a Unit without persisting
+// anything to the Registry
+type placementResource struct {
+	reg      registry.Registry
+	maxUnits int
+	weights  map[string]float64
+}
+
+type placement struct {
+	MachineID string              `json:"machineID,omitempty"`
+	Reason    string              `json:"reason"`
+	Machines  []decisionCandidate `json:"machines"`
+}
+
+func (pr *placementResource) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		sendError(rw, http.StatusBadRequest, fmt.Errorf("only HTTP POST supported against this resource"))
+		return
+	}
+
+	if err := validateContentType(req); err != nil {
+		sendError(rw, http.StatusUnsupportedMediaType, err)
+		return
+	}
+
+	var su schema.Unit
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&su); err != nil {
+		sendError(rw, http.StatusBadRequest, fmt.Errorf("unable to decode body: %v", err))
+		return
+	}
+	if err := ValidateName(su.Name); err != nil {
+		sendError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if err := ValidateOptions(su.Options); err != nil {
+		sendError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	uf := schema.MapSchemaUnitOptionsToUnitFile(su.Options)
+	p, err := engine.SimulatePlacement(pr.reg, su.Name, *uf, pr.maxUnits, pr.weights)
+	if err != nil {
+		log.Errorf("Failed simulating placement of Unit(%s): %v", su.Name, err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := placement{
+		MachineID: p.MachineID,
+		Reason:    p.Reason,
+		Machines:  mapCandidates(p.Machines),
+	}
+
+	sendResponse(rw, http.StatusOK, resp)
+}
@@ -0,0 +1,86 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+)
+
+func TestPlacementSimulate(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	fr.SetMachines([]machine.MachineState{
+		{ID: "XXX", Metadata: map[string]string{"ping": "pong"}},
+		{ID: "YYY"},
+	})
+	resource := &placementResource{reg: fr}
+	rw := httptest.NewRecorder()
+	body := strings.NewReader(`{"name":"foo.service","options":[{"section":"X-Fleet","name":"MachineMetadata","value":"ping=pong"}]}`)
+	req, err := http.NewRequest("POST", "http://example.com/placement", body)
+	if err != nil {
+		t.Fatalf("Failed creating http.Request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resource.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rw.Code)
+	}
+
+	got := rw.Body.String()
+	expected := `{"machineID":"XXX","reason":"highest-scoring machine able to run unit","machines":[{"machineID":"XXX","able":true,"score":0},{"machineID":"YYY","able":false,"score":0,"reason":"local Machine metadata insufficient"}]}`
+	if got != expected {
+		t.Errorf("Expected body:\n%s\n\nReceived body:\n%s\n", expected, got)
+	}
+
+	if units, _ := fr.Units(); len(units) != 0 {
+		t.Errorf("Simulated placement should not persist Units, found %v", units)
+	}
+}
+
+func TestPlacementSimulateBadRequest(t *testing.T) {
+	tests := []struct {
+		method string
+		ct     string
+		body   string
+		code   int
+	}{
+		{"GET", "application/json", "", http.StatusBadRequest},
+		{"POST", "text/plain", `{"name":"foo.service"}`, http.StatusUnsupportedMediaType},
+		{"POST", "application/json", `{"name":`, http.StatusBadRequest},
+		{"POST", "application/json", `{"name":"foo.bar"}`, http.StatusBadRequest},
+	}
+
+	for i, tt := range tests {
+		resource := &placementResource{reg: registry.NewFakeRegistry()}
+		rw := httptest.NewRecorder()
+		req, err := http.NewRequest(tt.method, "http://example.com/placement", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("case %d: failed creating http.Request: %v", i, err)
+		}
+		req.Header.Set("Content-Type", tt.ct)
+
+		resource.ServeHTTP(rw, req)
+
+		if err := assertErrorResponse(rw, tt.code); err != nil {
+			t.Errorf("case %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/machine"
+)
+
+func wireUpSchedulabilityResource(mux *http.ServeMux, prefix string, cAPI client.API) {
+	base := path.Join(prefix, "schedulability")
+	sr := schedulabilityResource{cAPI, base}
+	mux.Handle(base, &sr)
+	mux.Handle(base+"/", &sr)
+}
+
+// schedulabilityResource exposes which Machines are cordoned or draining,
+// and allows operators to cordon, drain and uncordon Machines
+type schedulabilityResource struct {
+	cAPI     client.API
+	basePath string
+}
+
+type machineSchedulability struct {
+	MachineID      string `json:"machineID,omitempty"`
+	Schedulability string `json:"schedulability"`
+}
+
+type schedulabilityPage struct {
+	Machines []machineSchedulability `json:"machines"`
+}
+
+func (sr *schedulabilityResource) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == sr.basePath {
+		if req.Method != "GET" {
+			sendError(rw, http.StatusMethodNotAllowed, errors.New("only GET supported against this resource"))
+			return
+		}
+		sr.list(rw)
+		return
+	}
+
+	item, ok := isItemPath(sr.basePath, req.URL.Path)
+	if !ok {
+		sendError(rw, http.StatusNotFound, nil)
+		return
+	}
+	if req.Method != "PUT" {
+		sendError(rw, http.StatusMethodNotAllowed, errors.New("only PUT supported against this resource"))
+		return
+	}
+	sr.set(rw, req, item)
+}
+
+// list responds with the Machines that are not schedulable
+func (sr *schedulabilityResource) list(rw http.ResponseWriter) {
+	machines, err := sr.cAPI.Machines()
+	if err != nil {
+		log.Errorf("Failed fetching Machines: %v", err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	page := schedulabilityPage{Machines: make([]machineSchedulability, 0)}
+	for _, ms := range machines {
+		if ms.Schedulability != machine.Schedulable {
+			page.Machines = append(page.Machines, machineSchedulability{MachineID: ms.ID, Schedulability: string(ms.Schedulability)})
+		}
+	}
+	sendResponse(rw, http.StatusOK, page)
+}
+
+func (sr *schedulabilityResource) set(rw http.ResponseWriter, req *http.Request, machID string) {
+	if err := validateContentType(req); err != nil {
+		sendError(rw, http.StatusUnsupportedMediaType, err)
+		return
+	}
+
+	var body machineSchedulability
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		sendError(rw, http.StatusBadRequest, fmt.Errorf("unable to decode body: %v", err))
+		return
+	}
+	s, ok := machine.ParseSchedulability(body.Schedulability)
+	if !ok {
+		sendError(rw, http.StatusBadRequest, fmt.Errorf("invalid schedulability %q", body.Schedulability))
+		return
+	}
+
+	if err := sr.cAPI.SetMachineSchedulability(machID, s); err != nil {
+		log.Errorf("Failed setting schedulability of Machine(%s): %v", machID, err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
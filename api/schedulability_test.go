@@ -0,0 +1,71 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+)
+
+func TestSchedulabilityResource(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	fr.SetMachines([]machine.MachineState{{ID: "XXX"}, {ID: "YYY"}})
+	resource := &schedulabilityResource{&client.RegistryClient{Registry: fr}, "/schedulability"}
+
+	do := func(method, p, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "http://example.com"+p, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed creating http.Request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		rw := httptest.NewRecorder()
+		resource.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if rw := do("PUT", "/schedulability/YYY", `{"schedulability":"draining"}`); rw.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rw.Code)
+	}
+
+	rw := do("GET", "/schedulability", "")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rw.Code)
+	}
+	want := `{"machines":[{"machineID":"YYY","schedulability":"draining"}]}`
+	if got := rw.Body.String(); got != want {
+		t.Errorf("Expected body:\n%s\n\nReceived body:\n%s\n", want, got)
+	}
+
+	if rw := do("PUT", "/schedulability/YYY", `{"schedulability":"evicted"}`); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid schedulability, got %d", rw.Code)
+	}
+	if rw := do("DELETE", "/schedulability/YYY", ""); rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for DELETE, got %d", rw.Code)
+	}
+
+	if rw := do("PUT", "/schedulability/YYY", `{"schedulability":""}`); rw.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rw.Code)
+	}
+	want = `{"machines":[]}`
+	if got := do("GET", "/schedulability", "").Body.String(); got != want {
+		t.Errorf("Expected body:\n%s\n\nReceived body:\n%s\n", want, got)
+	}
+}
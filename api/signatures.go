@@ -0,0 +1,98 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/sign"
+)
+
+func wireUpSignaturesResource(mux *http.ServeMux, prefix string, cAPI client.API) {
+	base := path.Join(prefix, "signatures")
+	sr := signaturesResource{cAPI, base}
+	mux.Handle(base+"/", &sr)
+}
+
+// signaturesResource exposes the Signatures of each Unit, and allows
+// clients to sign Units before creating them
+type signaturesResource struct {
+	cAPI     client.API
+	basePath string
+}
+
+type signaturesPage struct {
+	Signatures []sign.Signature `json:"signatures"`
+}
+
+func (sr *signaturesResource) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	item, ok := isItemPath(sr.basePath, req.URL.Path)
+	if !ok {
+		sendError(rw, http.StatusNotFound, nil)
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+		sr.get(rw, item)
+	case "PUT":
+		sr.set(rw, req, item)
+	default:
+		sendError(rw, http.StatusMethodNotAllowed, errors.New("only GET and PUT supported against this resource"))
+	}
+}
+
+func (sr *signaturesResource) get(rw http.ResponseWriter, name string) {
+	sigs, err := sr.cAPI.UnitSignatures(name)
+	if err != nil {
+		log.Errorf("Failed fetching signatures of Unit(%s): %v", name, err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+	if sigs == nil {
+		sigs = make([]sign.Signature, 0)
+	}
+	sendResponse(rw, http.StatusOK, signaturesPage{Signatures: sigs})
+}
+
+func (sr *signaturesResource) set(rw http.ResponseWriter, req *http.Request, name string) {
+	if err := validateContentType(req); err != nil {
+		sendError(rw, http.StatusUnsupportedMediaType, err)
+		return
+	}
+
+	var body signaturesPage
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		sendError(rw, http.StatusBadRequest, fmt.Errorf("unable to decode body: %v", err))
+		return
+	}
+	if len(body.Signatures) == 0 {
+		sendError(rw, http.StatusBadRequest, errors.New("at least one signature must be provided"))
+		return
+	}
+
+	if err := sr.cAPI.SetUnitSignatures(name, body.Signatures); err != nil {
+		log.Errorf("Failed setting signatures of Unit(%s): %v", name, err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,88 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/sign"
+)
+
+func TestSignaturesResource(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	resource := &signaturesResource{&client.RegistryClient{Registry: fr}, "/signatures"}
+
+	do := func(method, p, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "http://example.com"+p, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed creating http.Request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		rw := httptest.NewRecorder()
+		resource.ServeHTTP(rw, req)
+		return rw
+	}
+
+	// "a2V5" and "YmxvYg==" are "key" and "blob" encoded in base64
+	rw := do("PUT", "/signatures/foo.service", `{"signatures":[{"key":"a2V5","format":"ssh-rsa","blob":"YmxvYg=="}]}`)
+	if rw.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rw.Code)
+	}
+	want := []sign.Signature{{Key: []byte("key"), Format: "ssh-rsa", Blob: []byte("blob")}}
+	if got, _ := fr.UnitSignatures("foo.service"); !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected signatures %v, got %v", want, got)
+	}
+
+	rw = do("GET", "/signatures/foo.service", "")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rw.Code)
+	}
+	var page signaturesPage
+	if err := json.NewDecoder(rw.Body).Decode(&page); err != nil {
+		t.Fatalf("Received unparseable body: %v", err)
+	}
+	if !reflect.DeepEqual(want, page.Signatures) {
+		t.Errorf("Expected signatures %v, got %v", want, page.Signatures)
+	}
+
+	rw = do("GET", "/signatures/bar.service", "")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rw.Code)
+	}
+	if body := strings.TrimSpace(rw.Body.String()); body != `{"signatures":[]}` {
+		t.Errorf("Expected no signatures, got %s", body)
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{"PUT", "/signatures/foo.service", `{"signatures":[]}`, http.StatusBadRequest},
+		{"PUT", "/signatures/foo.service", `{`, http.StatusBadRequest},
+		{"DELETE", "/signatures/foo.service", "", http.StatusMethodNotAllowed},
+		{"GET", "/signatures/", "", http.StatusNotFound},
+	} {
+		if code := do(tt.method, tt.path, tt.body).Code; code != tt.code {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.code, code)
+		}
+	}
+}
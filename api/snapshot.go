@@ -0,0 +1,96 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/registry"
+)
+
+func wireUpSnapshotResource(mux *http.ServeMux, prefix string, reg registry.Registry) {
+	res := path.Join(prefix, "snapshot")
+	sr := snapshotResource{reg}
+	mux.Handle(res, &sr)
+}
+
+// snapshotResource serves a Snapshot of the cluster, and restores one
+// sent to it. Restoring through an AuditedRegistry attributes the Units
+// created to the client making the request.
+type snapshotResource struct {
+	reg registry.Registry
+}
+
+type restoreOutcomes struct {
+	Outcomes []registry.RestoreOutcome `json:"outcomes"`
+}
+
+func (sr *snapshotResource) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		sr.get(rw)
+	case "PUT":
+		sr.restore(rw, req)
+	default:
+		sendError(rw, http.StatusMethodNotAllowed, errors.New("only GET and PUT supported against this resource"))
+	}
+}
+
+func (sr *snapshotResource) get(rw http.ResponseWriter) {
+	cAPI := &client.RegistryClient{Registry: sr.reg}
+	snap, err := cAPI.Snapshot()
+	if err != nil {
+		log.Errorf("Failed taking snapshot: %v", err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+	sendResponse(rw, http.StatusOK, snap)
+}
+
+func (sr *snapshotResource) restore(rw http.ResponseWriter, req *http.Request) {
+	if err := validateContentType(req); err != nil {
+		sendError(rw, http.StatusUnsupportedMediaType, err)
+		return
+	}
+
+	var snap registry.Snapshot
+	if err := json.NewDecoder(req.Body).Decode(&snap); err != nil {
+		sendError(rw, http.StatusBadRequest, fmt.Errorf("unable to decode body: %v", err))
+		return
+	}
+	if snap.Version < 1 || snap.Version > registry.SnapshotVersion {
+		sendError(rw, http.StatusBadRequest, fmt.Errorf("unsupported snapshot version %d", snap.Version))
+		return
+	}
+
+	reg := sr.reg
+	if areg, ok := reg.(*registry.AuditedRegistry); ok {
+		reg = areg.WithIdentity(requestIdentity(req))
+	}
+	cAPI := &client.RegistryClient{Registry: reg}
+	outcomes, err := cAPI.RestoreSnapshot(&snap)
+	if err != nil {
+		log.Errorf("Failed restoring snapshot: %v", err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+	sendResponse(rw, http.StatusOK, restoreOutcomes{outcomes})
+}
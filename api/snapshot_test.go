@@ -0,0 +1,83 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+)
+
+func TestSnapshotResource(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	fr.SetMachines([]machine.MachineState{{ID: "XXX"}})
+	resource := &snapshotResource{fr}
+
+	do := func(method, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "http://example.com/snapshot", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed creating http.Request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		rw := httptest.NewRecorder()
+		resource.ServeHTTP(rw, req)
+		return rw
+	}
+
+	body := `{"version":2,"units":[{"name":"foo.service","options":[{"section":"Service","name":"ExecStart","value":"/bin/true"}],"desiredState":"launched"}],"machines":[{"id":"XXX","metadata":{"ping":"pong"},"schedulability":"cordoned"}]}`
+	rw := do("PUT", body)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rw.Code, rw.Body.String())
+	}
+	var ro restoreOutcomes
+	if err := json.NewDecoder(rw.Body).Decode(&ro); err != nil {
+		t.Fatalf("Failed decoding body: %v", err)
+	}
+	want := []registry.RestoreOutcome{
+		{Kind: registry.SnapshotKindUnit, Name: "foo.service", Detail: "launched"},
+		{Kind: registry.SnapshotKindMachine, Name: "XXX", Detail: "metadata ping; cordoned"},
+	}
+	if !reflect.DeepEqual(want, ro.Outcomes) {
+		t.Fatalf("Expected outcomes %v, got %v", want, ro.Outcomes)
+	}
+
+	rw = do("GET", "")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rw.Code)
+	}
+	var snap registry.Snapshot
+	if err := json.NewDecoder(rw.Body).Decode(&snap); err != nil {
+		t.Fatalf("Failed decoding body: %v", err)
+	}
+	if snap.Version != registry.SnapshotVersion || len(snap.Units) != 1 || len(snap.Machines) != 1 {
+		t.Fatalf("Unexpected snapshot %+v", snap)
+	}
+	if md := snap.Machines[0].Metadata; md["ping"] != "pong" {
+		t.Errorf("Expected restored metadata in snapshot, got %v", md)
+	}
+
+	if rw := do("PUT", `{"version":99}`); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown version, got %d", rw.Code)
+	}
+	if rw := do("DELETE", ""); rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for DELETE, got %d", rw.Code)
+	}
+}
@@ -15,6 +15,7 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"path"
@@ -28,6 +29,7 @@ func wireUpStateResource(mux *http.ServeMux, prefix string, cAPI client.API) {
 	base := path.Join(prefix, "state")
 	sr := stateResource{cAPI, base}
 	mux.Handle(base, &sr)
+	mux.Handle(path.Join(base, "changes"), &stateChangesResource{cAPI})
 }
 
 type stateResource struct {
@@ -123,3 +125,58 @@ func extractUnitStatePageData(all []*schema.UnitState, tok PageToken) (items []*
 
 	return
 }
+
+// stateChangesResource streams the changes made to UnitStates, one JSON
+// object per line, until the client goes away
+type stateChangesResource struct {
+	cAPI client.API
+}
+
+func (scr *stateChangesResource) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		sendError(rw, http.StatusMethodNotAllowed, errors.New("only GET supported against this resource"))
+		return
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	changes, err := scr.cAPI.WatchUnitStates(stop)
+	if err != nil {
+		log.Errorf("Failed watching UnitStates: %v", err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	var gone <-chan bool
+	if cn, ok := rw.(http.CloseNotifier); ok {
+		gone = cn.CloseNotify()
+	}
+	flush := func() {
+		if f, ok := rw.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	// the headers are sent at once, so that the client knows the watch
+	// has started before any change is made
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	flush()
+
+	enc := json.NewEncoder(rw)
+	for {
+		select {
+		case c, ok := <-changes:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(c); err != nil {
+				log.Debugf("Stopped streaming UnitState changes: %v", err)
+				return
+			}
+			flush()
+		case <-gone:
+			return
+		}
+	}
+}
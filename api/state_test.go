@@ -18,9 +18,11 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/coreos/fleet/client"
 	"github.com/coreos/fleet/registry"
@@ -243,3 +245,80 @@ func TestExtractUnitStatePage(t *testing.T) {
 		}
 	}
 }
+
+// watchingAPI serves the changes sent on each channel in turn to the
+// successive watches of UnitStates
+type watchingAPI struct {
+	client.API
+	watches chan chan client.UnitStateChange
+}
+
+func (wa *watchingAPI) WatchUnitStates(stop chan struct{}) (<-chan client.UnitStateChange, error) {
+	return <-wa.watches, nil
+}
+
+func TestUnitStateChangesStream(t *testing.T) {
+	wa := &watchingAPI{watches: make(chan chan client.UnitStateChange, 2)}
+	first, second := make(chan client.UnitStateChange), make(chan client.UnitStateChange)
+	wa.watches <- first
+	wa.watches <- second
+
+	mux := http.NewServeMux()
+	wireUpStateResource(mux, "/fleet/v1", wa)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ep, _ := url.Parse(srv.URL)
+	cAPI, err := client.NewHTTPClient(&http.Client{}, *ep)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	changes, err := cAPI.WatchUnitStates(stop)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	next := func() client.UnitStateChange {
+		select {
+		case c := <-changes:
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for a change")
+		}
+		return client.UnitStateChange{}
+	}
+
+	want := client.UnitStateChange{Name: "foo.service", MachineID: "XXX"}
+	first <- want
+	if got := next(); got != want {
+		t.Fatalf("expected change %v, got %v", want, got)
+	}
+
+	// the stream ending, the client reconnects and reports that changes
+	// may have been missed
+	close(first)
+	if got := next(); got != (client.UnitStateChange{}) {
+		t.Fatalf("expected missed changes, got %v", got)
+	}
+	want = client.UnitStateChange{Name: "bar.service", MachineID: "YYY"}
+	second <- want
+	if got := next(); got != want {
+		t.Fatalf("expected change %v, got %v", want, got)
+	}
+}
+
+func TestUnitStateChangesUnsupported(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	ep, _ := url.Parse(srv.URL)
+	cAPI, err := client.NewHTTPClient(&http.Client{}, *ep)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := cAPI.WatchUnitStates(make(chan struct{})); err != client.ErrWatchUnsupported {
+		t.Fatalf("expected ErrWatchUnsupported from a server without the stream, got %v", err)
+	}
+}
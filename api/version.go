@@ -0,0 +1,99 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-semver/semver"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/log"
+)
+
+func wireUpVersionResource(mux *http.ServeMux, prefix string, cAPI client.API) {
+	res := path.Join(prefix, "version")
+	vr := versionResource{cAPI}
+	mux.Handle(res, &vr)
+}
+
+// versionResource exposes the cluster-wide minimum version of fleetd, and
+// allows operators to raise or remove it
+type versionResource struct {
+	cAPI client.API
+}
+
+type versionGate struct {
+	MinimumVersion string `json:"minimumVersion"`
+}
+
+func (vr *versionResource) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		vr.get(rw)
+	case "PUT":
+		vr.set(rw, req)
+	default:
+		sendError(rw, http.StatusMethodNotAllowed, errors.New("only GET and PUT supported against this resource"))
+	}
+}
+
+func (vr *versionResource) get(rw http.ResponseWriter) {
+	v, err := vr.cAPI.MinimumVersion()
+	if err != nil {
+		log.Errorf("Failed fetching minimum version: %v", err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	var gate versionGate
+	if v != nil {
+		gate.MinimumVersion = v.String()
+	}
+	sendResponse(rw, http.StatusOK, gate)
+}
+
+func (vr *versionResource) set(rw http.ResponseWriter, req *http.Request) {
+	if err := validateContentType(req); err != nil {
+		sendError(rw, http.StatusUnsupportedMediaType, err)
+		return
+	}
+
+	var body versionGate
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		sendError(rw, http.StatusBadRequest, fmt.Errorf("unable to decode body: %v", err))
+		return
+	}
+
+	var v *semver.Version
+	if body.MinimumVersion != "" {
+		var err error
+		if v, err = semver.NewVersion(body.MinimumVersion); err != nil {
+			sendError(rw, http.StatusBadRequest, fmt.Errorf("invalid minimum version %q", body.MinimumVersion))
+			return
+		}
+	}
+
+	if err := vr.cAPI.SetMinimumVersion(v); err != nil {
+		log.Errorf("Failed setting minimum version: %v", err)
+		sendError(rw, http.StatusInternalServerError, nil)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,69 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/registry"
+)
+
+func TestVersionResource(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	resource := &versionResource{&client.RegistryClient{Registry: fr}}
+
+	do := func(method, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "http://example.com/version", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed creating http.Request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		rw := httptest.NewRecorder()
+		resource.ServeHTTP(rw, req)
+		return rw
+	}
+
+	want := `{"minimumVersion":""}`
+	if got := do("GET", "").Body.String(); got != want {
+		t.Errorf("Expected body:\n%s\n\nReceived body:\n%s\n", want, got)
+	}
+
+	if rw := do("PUT", `{"minimumVersion":"0.10.0"}`); rw.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rw.Code)
+	}
+	want = `{"minimumVersion":"0.10.0"}`
+	if got := do("GET", "").Body.String(); got != want {
+		t.Errorf("Expected body:\n%s\n\nReceived body:\n%s\n", want, got)
+	}
+
+	if rw := do("PUT", `{"minimumVersion":"ten"}`); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid version, got %d", rw.Code)
+	}
+	if rw := do("DELETE", ""); rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for DELETE, got %d", rw.Code)
+	}
+
+	if rw := do("PUT", `{"minimumVersion":""}`); rw.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rw.Code)
+	}
+	want = `{"minimumVersion":""}`
+	if got := do("GET", "").Body.String(); got != want {
+		t.Errorf("Expected body:\n%s\n\nReceived body:\n%s\n", want, got)
+	}
+}
@@ -15,18 +15,67 @@
 package client
 
 import (
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-semver/semver"
+
+	"github.com/coreos/fleet/job"
 	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
 	"github.com/coreos/fleet/schema"
+	"github.com/coreos/fleet/sign"
 )
 
 type API interface {
 	Machines() ([]machine.MachineState, error)
+	SetMachineSchedulability(machID string, s machine.Schedulability) error
+	// SetMachineMetadata sets a metadata key of a Machine, or removes it
+	// if the value is empty
+	SetMachineMetadata(machID, key, value string) error
 
 	Unit(string) (*schema.Unit, error)
 	Units() ([]*schema.Unit, error)
 	UnitStates() ([]*schema.UnitState, error)
+	// UnitState returns the state of the given Unit on the given
+	// Machine, or nil if there is none
+	UnitState(name, machID string) (*schema.UnitState, error)
+	// WatchUnitStates emits the Units whose state changes until stop is
+	// closed, or returns ErrWatchUnsupported if the client is unable to
+	WatchUnitStates(stop chan struct{}) (<-chan UnitStateChange, error)
 
 	SetUnitTargetState(name, target string) error
 	CreateUnit(*schema.Unit) error
 	DestroyUnit(string) error
+
+	// SetUnitSignatures records the Signatures of a Unit, which agents
+	// verifying Units check before loading it
+	SetUnitSignatures(name string, sigs []sign.Signature) error
+	UnitSignatures(name string) ([]sign.Signature, error)
+
+	CreateRollout(*job.Rollout) error
+	Rollout(template string) (*job.Rollout, error)
+	SetRolloutControl(template string, c job.RolloutControl) error
+
+	Decisions(name string) ([]job.Decision, error)
+	Unschedulable(name string) (*job.Unschedulable, error)
+
+	UnitHistory(name string) ([]job.Revision, error)
+	// UnitHistoryLimit returns the cluster-wide number of Revisions
+	// retained of each Unit, or 0 if none are recorded
+	UnitHistoryLimit() (int, error)
+	// SetUnitHistoryLimit sets the number of Revisions retained of each
+	// Unit, 0 recording none
+	SetUnitHistoryLimit(limit int) error
+
+	EngineStatuses() ([]machine.EngineStatus, error)
+
+	// MinimumVersion returns the cluster-wide minimum version of fleetd
+	// to which new Units are scheduled, or nil if there is none
+	MinimumVersion() (*semver.Version, error)
+	// SetMinimumVersion sets the minimum version of fleetd, or removes
+	// it if nil
+	SetMinimumVersion(v *semver.Version) error
+
+	// Snapshot returns what operators set in the cluster, to be brought
+	// back with RestoreSnapshot in another or a recovered cluster
+	Snapshot() (*registry.Snapshot, error)
+	RestoreSnapshot(s *registry.Snapshot) ([]registry.RestoreOutcome, error)
 }
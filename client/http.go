@@ -15,14 +15,23 @@
 package client
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/url"
 	"path"
+	"time"
 
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-semver/semver"
 	"github.com/coreos/fleet/Godeps/_workspace/src/google.golang.org/api/googleapi"
 
+	"github.com/coreos/fleet/job"
 	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
 	"github.com/coreos/fleet/schema"
+	"github.com/coreos/fleet/sign"
 )
 
 func NewHTTPClient(c *http.Client, ep url.URL) (API, error) {
@@ -35,12 +44,15 @@ func NewHTTPClient(c *http.Client, ep url.URL) (API, error) {
 	ep.Path = path.Join(ep.Path, "fleet", "v1") + "/"
 	svc.BasePath = ep.String()
 
-	return &HTTPClient{svc: svc}, nil
+	return &HTTPClient{svc: svc, hc: c}, nil
 }
 
 type HTTPClient struct {
 	svc *schema.Service
 
+	// hc is used directly for resources not described by the schema
+	hc *http.Client
+
 	//NOTE(bcwaldon): This is only necessary until the API interface
 	// is fully implemented by HTTPClient
 	API
@@ -64,9 +76,100 @@ func (c *HTTPClient) Machines() ([]machine.MachineState, error) {
 			call = nil
 		}
 	}
+
+	sched, err := c.schedulability()
+	if err != nil {
+		return nil, err
+	}
+	for i := range machines {
+		machines[i].Schedulability = sched[machines[i].ID]
+	}
 	return machines, nil
 }
 
+// schedulabilityPage is the response of the schedulability resource of the
+// API, listing the Machines that are not schedulable
+type schedulabilityPage struct {
+	Machines []struct {
+		MachineID      string
+		Schedulability machine.Schedulability
+	}
+}
+
+func (c *HTTPClient) schedulability() (map[string]machine.Schedulability, error) {
+	resp, err := c.hc.Get(c.svc.BasePath + "schedulability")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		// servers predating the schedulability resource have every
+		// Machine schedulable
+		if is404(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	var page schedulabilityPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	sched := make(map[string]machine.Schedulability, len(page.Machines))
+	for _, m := range page.Machines {
+		sched[m.MachineID] = m.Schedulability
+	}
+	return sched, nil
+}
+
+func (c *HTTPClient) SetMachineSchedulability(machID string, s machine.Schedulability) error {
+	body, err := json.Marshal(struct {
+		Schedulability machine.Schedulability `json:"schedulability"`
+	}{s})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", c.svc.BasePath+path.Join("schedulability", machID), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return googleapi.CheckResponse(resp)
+}
+
+func (c *HTTPClient) SetMachineMetadata(machID, key, value string) error {
+	method, body := "DELETE", []byte(nil)
+	if value != "" {
+		b, err := json.Marshal(struct {
+			Value string `json:"value"`
+		}{value})
+		if err != nil {
+			return err
+		}
+		method, body = "PUT", b
+	}
+
+	req, err := http.NewRequest(method, c.svc.BasePath+path.Join("machines", machID, "metadata", key), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return googleapi.CheckResponse(resp)
+}
+
 func (c *HTTPClient) Units() ([]*schema.Unit, error) {
 	var units []*schema.Unit
 	call := c.svc.Units.List()
@@ -117,6 +220,108 @@ func (c *HTTPClient) UnitStates() ([]*schema.UnitState, error) {
 	return states, nil
 }
 
+func (c *HTTPClient) UnitState(name, machID string) (*schema.UnitState, error) {
+	page, err := c.svc.UnitState.List().UnitName(name).MachineID(machID).Do()
+	if err != nil {
+		return nil, err
+	}
+	for _, us := range page.States {
+		if us.Name == name && us.MachineID == machID {
+			return us, nil
+		}
+	}
+	return nil, nil
+}
+
+// watchRetryInterval is how long WatchUnitStates waits before
+// reconnecting to a stream of changes which ended
+const watchRetryInterval = time.Second
+
+// WatchUnitStates follows the stream of changes to UnitStates served by
+// the API, returning ErrWatchUnsupported if the server predates it. Should
+// the stream end, it is reconnected and a missed change emitted, as
+// changes may have been made in between.
+func (c *HTTPClient) WatchUnitStates(stop chan struct{}) (<-chan UnitStateChange, error) {
+	resp, err := c.streamUnitStates()
+	if is404(err) {
+		return nil, ErrWatchUnsupported
+	} else if err != nil {
+		return nil, err
+	}
+
+	out := make(chan UnitStateChange)
+	go func() {
+		defer close(out)
+		for {
+			if !followUnitStates(resp.Body, out, stop) {
+				return
+			}
+			select {
+			case <-time.After(watchRetryInterval):
+			case <-stop:
+				return
+			}
+			if resp, err = c.streamUnitStates(); err != nil {
+				return
+			}
+			select {
+			case out <- UnitStateChange{}:
+			case <-stop:
+				resp.Body.Close()
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// streamUnitStates opens the stream of changes to UnitStates, returning
+// once the server has started watching for them
+func (c *HTTPClient) streamUnitStates() (*http.Response, error) {
+	resp, err := c.hc.Get(c.svc.BasePath + "state/changes")
+	if err != nil {
+		return nil, err
+	}
+	if err := googleapi.CheckResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// followUnitStates emits the changes read from the given stream until it
+// ends, in which case it returns true, or until stop is closed
+func followUnitStates(body io.ReadCloser, out chan<- UnitStateChange, stop chan struct{}) bool {
+	done := make(chan struct{})
+	defer close(done)
+	// closing the body interrupts the decoder waiting for a change
+	go func() {
+		select {
+		case <-stop:
+		case <-done:
+		}
+		body.Close()
+	}()
+
+	dec := json.NewDecoder(body)
+	for {
+		var usc UnitStateChange
+		if err := dec.Decode(&usc); err != nil {
+			select {
+			case <-stop:
+				return false
+			default:
+				return true
+			}
+		}
+		select {
+		case out <- usc:
+		case <-stop:
+			return false
+		}
+	}
+}
+
 func (c *HTTPClient) DestroyUnit(name string) error {
 	return c.svc.Units.Delete(name).Do()
 }
@@ -133,6 +338,301 @@ func (c *HTTPClient) SetUnitTargetState(name, target string) error {
 	return c.svc.Units.Set(name, &u).Do()
 }
 
+// signaturesPage is the body of the signatures resource of the API
+type signaturesPage struct {
+	Signatures []sign.Signature `json:"signatures"`
+}
+
+func (c *HTTPClient) SetUnitSignatures(name string, sigs []sign.Signature) error {
+	body, err := json.Marshal(signaturesPage{Signatures: sigs})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", c.svc.BasePath+path.Join("signatures", name), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return googleapi.CheckResponse(resp)
+}
+
+func (c *HTTPClient) UnitSignatures(name string) ([]sign.Signature, error) {
+	resp, err := c.hc.Get(c.svc.BasePath + path.Join("signatures", name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var page signaturesPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	return page.Signatures, nil
+}
+
+var errRolloutsUnsupported = errors.New("rolling updates are not supported by the API driver")
+
+func (c *HTTPClient) CreateRollout(ro *job.Rollout) error {
+	return errRolloutsUnsupported
+}
+
+func (c *HTTPClient) Rollout(template string) (*job.Rollout, error) {
+	return nil, errRolloutsUnsupported
+}
+
+func (c *HTTPClient) SetRolloutControl(template string, ctl job.RolloutControl) error {
+	return errRolloutsUnsupported
+}
+
+// decisionPage is the response of the decisions resource of the API
+type decisionPage struct {
+	Decisions     []job.Decision
+	Unschedulable *job.Unschedulable
+}
+
+func (c *HTTPClient) decisionPage(name string) (*decisionPage, error) {
+	resp, err := c.hc.Get(c.svc.BasePath + path.Join("decisions", name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var page decisionPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (c *HTTPClient) Decisions(name string) ([]job.Decision, error) {
+	page, err := c.decisionPage(name)
+	if err != nil {
+		return nil, err
+	}
+	return page.Decisions, nil
+}
+
+func (c *HTTPClient) Unschedulable(name string) (*job.Unschedulable, error) {
+	page, err := c.decisionPage(name)
+	if err != nil {
+		return nil, err
+	}
+	return page.Unschedulable, nil
+}
+
+// historyPage is the response of the history resource of the API
+type historyPage struct {
+	Revisions []struct {
+		Number       int
+		Time         time.Time
+		Event        job.RevisionEvent
+		DesiredState job.JobState
+		Options      []*schema.UnitOption
+	}
+}
+
+func (c *HTTPClient) UnitHistory(name string) ([]job.Revision, error) {
+	resp, err := c.hc.Get(c.svc.BasePath + path.Join("history", name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var page historyPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+
+	revs := make([]job.Revision, 0, len(page.Revisions))
+	for _, r := range page.Revisions {
+		revs = append(revs, job.Revision{
+			Number:      r.Number,
+			Time:        r.Time,
+			Event:       r.Event,
+			TargetState: r.DesiredState,
+			Unit:        *schema.MapSchemaUnitOptionsToUnitFile(r.Options),
+		})
+	}
+	return revs, nil
+}
+
+// enginePage is the response of the engine resource of the API
+type enginePage struct {
+	Engines []machine.EngineStatus
+}
+
+func (c *HTTPClient) EngineStatuses() ([]machine.EngineStatus, error) {
+	resp, err := c.hc.Get(c.svc.BasePath + "engine")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var page enginePage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	return page.Engines, nil
+}
+
+// versionGate is the body of the version resource of the API
+type versionGate struct {
+	MinimumVersion string `json:"minimumVersion"`
+}
+
+func (c *HTTPClient) MinimumVersion() (*semver.Version, error) {
+	resp, err := c.hc.Get(c.svc.BasePath + "version")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var gate versionGate
+	if err := json.NewDecoder(resp.Body).Decode(&gate); err != nil {
+		return nil, err
+	}
+	if gate.MinimumVersion == "" {
+		return nil, nil
+	}
+	return semver.NewVersion(gate.MinimumVersion)
+}
+
+func (c *HTTPClient) SetMinimumVersion(v *semver.Version) error {
+	var gate versionGate
+	if v != nil {
+		gate.MinimumVersion = v.String()
+	}
+	body, err := json.Marshal(gate)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", c.svc.BasePath+"version", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return googleapi.CheckResponse(resp)
+}
+
+type historyLimit struct {
+	Limit int `json:"limit"`
+}
+
+func (c *HTTPClient) UnitHistoryLimit() (int, error) {
+	resp, err := c.hc.Get(c.svc.BasePath + "history-limit")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return 0, err
+	}
+
+	var hl historyLimit
+	if err := json.NewDecoder(resp.Body).Decode(&hl); err != nil {
+		return 0, err
+	}
+	return hl.Limit, nil
+}
+
+func (c *HTTPClient) SetUnitHistoryLimit(limit int) error {
+	body, err := json.Marshal(historyLimit{Limit: limit})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", c.svc.BasePath+"history-limit", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return googleapi.CheckResponse(resp)
+}
+
+func (c *HTTPClient) Snapshot() (*registry.Snapshot, error) {
+	resp, err := c.hc.Get(c.svc.BasePath + "snapshot")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var snap registry.Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+type restoreOutcomes struct {
+	Outcomes []registry.RestoreOutcome `json:"outcomes"`
+}
+
+func (c *HTTPClient) RestoreSnapshot(s *registry.Snapshot) ([]registry.RestoreOutcome, error) {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("PUT", c.svc.BasePath+"snapshot", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var ro restoreOutcomes
+	if err := json.NewDecoder(resp.Body).Decode(&ro); err != nil {
+		return nil, err
+	}
+	return ro.Outcomes, nil
+}
+
 func is404(err error) bool {
 	googerr, ok := err.(*googleapi.Error)
 	return ok && googerr.Code == http.StatusNotFound
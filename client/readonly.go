@@ -0,0 +1,75 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-semver/semver"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/schema"
+	"github.com/coreos/fleet/sign"
+)
+
+// ReadOnlyAPI passes reads through to the wrapped API, refusing every
+// change with registry.ErrReadOnly
+type ReadOnlyAPI struct {
+	API
+}
+
+func (ReadOnlyAPI) SetUnitTargetState(name, target string) error {
+	return registry.ErrReadOnly
+}
+
+func (ReadOnlyAPI) CreateUnit(*schema.Unit) error {
+	return registry.ErrReadOnly
+}
+
+func (ReadOnlyAPI) DestroyUnit(string) error {
+	return registry.ErrReadOnly
+}
+
+func (ReadOnlyAPI) SetUnitSignatures(name string, sigs []sign.Signature) error {
+	return registry.ErrReadOnly
+}
+
+func (ReadOnlyAPI) CreateRollout(*job.Rollout) error {
+	return registry.ErrReadOnly
+}
+
+func (ReadOnlyAPI) SetRolloutControl(template string, c job.RolloutControl) error {
+	return registry.ErrReadOnly
+}
+
+func (ReadOnlyAPI) SetMachineSchedulability(machID string, s machine.Schedulability) error {
+	return registry.ErrReadOnly
+}
+
+func (ReadOnlyAPI) SetMachineMetadata(machID, key, value string) error {
+	return registry.ErrReadOnly
+}
+
+func (ReadOnlyAPI) SetMinimumVersion(v *semver.Version) error {
+	return registry.ErrReadOnly
+}
+
+func (ReadOnlyAPI) SetUnitHistoryLimit(limit int) error {
+	return registry.ErrReadOnly
+}
+
+func (ReadOnlyAPI) RestoreSnapshot(s *registry.Snapshot) ([]registry.RestoreOutcome, error) {
+	return nil, registry.ErrReadOnly
+}
@@ -103,3 +103,11 @@ func (rc *RegistryClient) UnitStates() ([]*schema.UnitState, error) {
 func (rc *RegistryClient) SetUnitTargetState(name, target string) error {
 	return rc.Registry.SetUnitTargetState(name, job.JobState(target))
 }
+
+func (rc *RegistryClient) Snapshot() (*registry.Snapshot, error) {
+	return registry.TakeSnapshot(rc.Registry)
+}
+
+func (rc *RegistryClient) RestoreSnapshot(s *registry.Snapshot) ([]registry.RestoreOutcome, error) {
+	return registry.RestoreSnapshot(rc.Registry, s)
+}
@@ -0,0 +1,67 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/schema"
+)
+
+// ErrWatchUnsupported is returned by clients unable to watch for changes
+var ErrWatchUnsupported = errors.New("watching for changes is not supported by this client")
+
+// UnitStateChange identifies a Unit whose state on a Machine changed. If
+// Name is empty, changes were missed and any state may have changed.
+type UnitStateChange struct {
+	Name      string `json:"name,omitempty"`
+	MachineID string `json:"machineID,omitempty"`
+}
+
+func (rc *RegistryClient) UnitState(name, machID string) (*schema.UnitState, error) {
+	us, err := rc.Registry.UnitState(name, machID)
+	if err != nil || us == nil {
+		return nil, err
+	}
+	return schema.MapUnitStateToSchemaUnitState(us), nil
+}
+
+// WatchUnitStates follows the changes made to the UnitStates in the
+// Registry
+func (rc *RegistryClient) WatchUnitStates(stop chan struct{}) (<-chan UnitStateChange, error) {
+	out := make(chan UnitStateChange)
+	changes := rc.Registry.Watch(registry.UnitStatesKeyspace, 0, stop)
+	go func() {
+		defer close(out)
+		for c := range changes {
+			var usc UnitStateChange
+			switch c.Type {
+			case registry.UnitStateUpdated:
+				usc = UnitStateChange{Name: c.Name, MachineID: c.MachineID}
+			case registry.ChangesMissed:
+			default:
+				continue
+			}
+
+			select {
+			case out <- usc:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
@@ -19,19 +19,75 @@ import (
 )
 
 type Config struct {
+	RegistryURL             string
+	ReadOnly                bool
+	DryRun                  bool
+	AuditLog                bool
+	RegistryCacheMaxAge     float64
+	Namespace               string
 	EtcdServers             []string
+	EtcdDiscoverySRV        string
 	EtcdKeyPrefix           string
 	EtcdKeyFile             string
 	EtcdCertFile            string
 	EtcdCAFile              string
+	EtcdUsername            string
+	EtcdPassword            string
+	EtcdPasswordFile        string
+	EtcdCompressUnits       bool
+	UnitEncryptionKeyFile   string
+	UnitHistoryLimit        int
 	EtcdRequestTimeout      float64
+	EtcdRetryAttempts       int
+	EtcdRetryBackoff        float64
+	EtcdRetryMaxBackoff     float64
+	EtcdRequestRate         float64
+	EtcdRequestBurst        int
+	EtcdMaxIdleConns        int
+	EtcdDialTimeout         float64
+	EtcdKeepalive           float64
+	EtcdEndpointFailures    int
 	EngineReconcileInterval float64
+	EngineReconcileJitter   float64
+	EngineReconcileDebounce float64
+	EngineLeaseTTL          float64
+	EngineLeaseRenewal      float64
+	EngineRescheduleGrace   float64
+	EngineResyncInterval    float64
+	EngineMaxSchedule       int
+	EngineShards            int
+	MaxUnitsPerMachine      int
+	EngineRebalanceInterval float64
+	EngineRebalanceBy       string
+	EngineRebalanceMoves    int
+	EngineScorerWeights     string
+	EngineDecisionHistory   int
+	EngineGCInterval        float64
+	EngineReconcileWorkers  int
 	PublicIP                string
 	Verbosity               int
 	RawMetadata             string
+	RawTaints               string
 	AgentTTL                string
+	AgentHeartbeatInterval  string
+	AgentStateFile          string
+	AgentTaskWorkers        int
+	UnitStateBatchWindow    float64
+	ShutdownMode            string
+	ShutdownTimeout         float64
+	UsageInterval           float64
+	UnitHooksDir            string
+	UnitLogSink             string
+	UnitSlice               string
+	SystemdUser             bool
+	UnitStartRetries        int
+	UnitStartRetryBackoff   float64
+	MaintenanceWindows      string
+	DockerEndpoint          string
+	RktPath                 string
 	VerifyUnits             bool
 	AuthorizedKeysFile      string
+	UnitPolicyFile          string
 }
 
 func (c *Config) Metadata() map[string]string {
@@ -51,3 +107,15 @@ func (c *Config) Metadata() map[string]string {
 
 	return meta
 }
+
+// Taints returns the comma-delimited taints of the local Machine
+func (c *Config) Taints() []string {
+	var taints []string
+	for _, taint := range strings.Split(c.RawTaints, ",") {
+		taint = strings.TrimSpace(taint)
+		if taint != "" {
+			taints = append(taints, taint)
+		}
+	}
+	return taints
+}
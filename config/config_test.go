@@ -44,3 +44,17 @@ func TestConfigMetadataNotSet(t *testing.T) {
 		t.Errorf("Parsed %d keys, expected 0", len(metadata))
 	}
 }
+
+func TestConfigTaints(t *testing.T) {
+	cfg := Config{RawTaints: "dedicated, gpu,,"}
+	taints := cfg.Taints()
+
+	if len(taints) != 2 || taints[0] != "dedicated" || taints[1] != "gpu" {
+		t.Errorf("Parsed taints %v, expected [dedicated gpu]", taints)
+	}
+
+	cfg = Config{}
+	if taints := cfg.Taints(); len(taints) != 0 {
+		t.Errorf("Parsed taints %v, expected none", taints)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// client speaks the small part of the Docker Engine API needed to manage
+// the lifecycle of containers
+type client struct {
+	hc   *http.Client
+	base string
+}
+
+func newClient(endpoint string) (*client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "unix":
+		sock := u.Path
+		tr := &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.DialTimeout("unix", sock, 10*time.Second)
+			},
+		}
+		// the host is ignored when dialing a socket
+		return &client{hc: &http.Client{Transport: tr}, base: "http://docker"}, nil
+	case "tcp", "http":
+		return &client{hc: &http.Client{}, base: "http://" + u.Host}, nil
+	}
+	return nil, fmt.Errorf("unsupported Docker endpoint %q", endpoint)
+}
+
+// apiError is returned for responses of the Docker API indicating failure
+type apiError struct {
+	Code    int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("Docker API responded %d: %s", e.Code, e.Message)
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*apiError)
+	return ok && e.Code == http.StatusNotFound
+}
+
+// do sends a request with the given body, if any, encoded as JSON and
+// decodes the response into out, if given. Responses of 304 Not Modified,
+// such as when starting a running container, are not errors.
+func (c *client) do(method, p string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	u := c.base + p
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var msg struct{ Message string }
+		b, _ := ioutil.ReadAll(resp.Body)
+		if json.Unmarshal(b, &msg) != nil || msg.Message == "" {
+			msg.Message = string(bytes.TrimSpace(b))
+		}
+		return &apiError{Code: resp.StatusCode, Message: msg.Message}
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// containerSummary is an entry of the list of containers
+type containerSummary struct {
+	Id     string
+	Labels map[string]string
+}
+
+func (c *client) listContainers(label string) ([]containerSummary, error) {
+	filters, err := json.Marshal(map[string][]string{"label": []string{label}})
+	if err != nil {
+		return nil, err
+	}
+	var cs []containerSummary
+	err = c.do("GET", "/containers/json", url.Values{"all": {"1"}, "filters": {string(filters)}}, nil, &cs)
+	return cs, err
+}
+
+// containerConfig is the body of a request to create a container
+type containerConfig struct {
+	Image        string
+	Cmd          []string            `json:",omitempty"`
+	Env          []string            `json:",omitempty"`
+	Labels       map[string]string   `json:",omitempty"`
+	ExposedPorts map[string]struct{} `json:",omitempty"`
+	HostConfig   hostConfig
+}
+
+type hostConfig struct {
+	Binds        []string                 `json:",omitempty"`
+	PortBindings map[string][]portBinding `json:",omitempty"`
+}
+
+type portBinding struct {
+	HostIP   string `json:"HostIp,omitempty"`
+	HostPort string
+}
+
+func (c *client) createContainer(name string, cfg *containerConfig) error {
+	return c.do("POST", "/containers/create", url.Values{"name": {name}}, cfg, nil)
+}
+
+// pullImage pulls the given image, which the Docker API reports on as a
+// stream of progress messages, any of which may carry an error
+func (c *client) pullImage(image string) error {
+	req, err := http.NewRequest("POST", c.base+"/images/create?"+url.Values{"fromImage": {image}}.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return &apiError{Code: resp.StatusCode, Message: string(bytes.TrimSpace(b))}
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct{ Error string }
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("failed pulling image %s: %s", image, msg.Error)
+		}
+	}
+}
+
+func (c *client) startContainer(name string) error {
+	return c.do("POST", "/containers/"+name+"/start", nil, nil, nil)
+}
+
+func (c *client) stopContainer(name string, timeout time.Duration) error {
+	return c.do("POST", "/containers/"+name+"/stop", url.Values{"t": {fmt.Sprint(int(timeout.Seconds()))}}, nil, nil)
+}
+
+func (c *client) restartContainer(name string, timeout time.Duration) error {
+	return c.do("POST", "/containers/"+name+"/restart", url.Values{"t": {fmt.Sprint(int(timeout.Seconds()))}}, nil, nil)
+}
+
+func (c *client) removeContainer(name string) error {
+	return c.do("DELETE", "/containers/"+name, url.Values{"force": {"1"}}, nil, nil)
+}
+
+// containerState is the state of a container, as reported by inspecting it
+type containerState struct {
+	Status     string
+	Running    bool
+	Paused     bool
+	Restarting bool
+	ExitCode   int
+	FinishedAt time.Time
+}
+
+func (c *client) inspectContainer(name string) (*containerState, error) {
+	var info struct {
+		State containerState
+	}
+	if err := c.do("GET", "/containers/"+name+"/json", nil, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info.State, nil
+}
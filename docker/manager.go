@@ -0,0 +1,336 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	// unitLabel and hashLabel mark the containers created for Units with
+	// the name of the Unit and the Hash of its unit file
+	unitLabel = "io.coreos.fleet.unit"
+	hashLabel = "io.coreos.fleet.unit-hash"
+
+	// containerPrefix prefixes the names of the containers of Units
+	containerPrefix = "fleet-"
+)
+
+// restarter is implemented by UnitManagers able to restart a unit in a
+// single operation
+type restarter interface {
+	TriggerRestart(string)
+}
+
+// UnitManager runs the Units declaring an [X-Docker] section as containers,
+// directly through the Docker API, and passes every other Unit on to the
+// wrapped UnitManager.
+type UnitManager struct {
+	unit.UnitManager
+
+	c     *client
+	mu    sync.Mutex
+	specs map[string]*ContainerSpec
+	// hashes holds the Hash of the unit file of each container Unit
+	hashes map[string]string
+}
+
+// NewUnitManager returns a UnitManager talking to the Docker daemon at the
+// given endpoint, such as unix:///var/run/docker.sock. Containers created
+// for Units before fleetd last started are picked up again.
+func NewUnitManager(endpoint string, um unit.UnitManager) (*UnitManager, error) {
+	c, err := newClient(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &UnitManager{
+		UnitManager: um,
+		c:           c,
+		specs:       make(map[string]*ContainerSpec),
+		hashes:      make(map[string]string),
+	}
+
+	cs, err := c.listContainers(unitLabel)
+	if err != nil {
+		return nil, err
+	}
+	for _, cont := range cs {
+		m.hashes[cont.Labels[unitLabel]] = cont.Labels[hashLabel]
+	}
+	return m, nil
+}
+
+// containerName returns the name of the container of the named Unit,
+// replacing characters Docker does not allow in names
+func containerName(name string) string {
+	return containerPrefix + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func (m *UnitManager) isContainer(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.hashes[name]
+	return ok
+}
+
+// Load creates the container of a Unit declaring one, pulling its image if
+// necessary. An existing container is only replaced if the unit file of
+// the Unit has changed.
+func (m *UnitManager) Load(name string, uf unit.UnitFile) error {
+	spec, err := ParseContainerSpec(uf)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash, isContainer := m.hashes[name]
+	if spec == nil {
+		if isContainer {
+			m.remove(name)
+		}
+		return m.UnitManager.Load(name, uf)
+	}
+
+	if !isContainer {
+		// the Unit may have been handed to the wrapped UnitManager before
+		m.UnitManager.Unload(name)
+	} else if hash == uf.Hash().String() {
+		m.specs[name] = spec
+		return nil
+	} else {
+		m.remove(name)
+	}
+
+	cfg := newContainerConfig(name, uf.Hash().String(), spec)
+	cname := containerName(name)
+	log.Infof("Creating Docker container %s of unit %s", cname, name)
+	err = m.c.createContainer(cname, cfg)
+	if isNotFound(err) {
+		log.Infof("Pulling Docker image %s of unit %s", spec.Image, name)
+		if err = m.c.pullImage(spec.Image); err == nil {
+			err = m.c.createContainer(cname, cfg)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	m.specs[name] = spec
+	m.hashes[name] = uf.Hash().String()
+	return nil
+}
+
+func newContainerConfig(name, hash string, spec *ContainerSpec) *containerConfig {
+	cfg := containerConfig{
+		Image:  spec.Image,
+		Cmd:    spec.Command,
+		Env:    spec.Environment,
+		Labels: map[string]string{unitLabel: name, hashLabel: hash},
+		HostConfig: hostConfig{
+			Binds: spec.Volumes,
+		},
+	}
+	if len(spec.Ports) > 0 {
+		cfg.ExposedPorts = make(map[string]struct{}, len(spec.Ports))
+		cfg.HostConfig.PortBindings = make(map[string][]portBinding, len(spec.Ports))
+		for cont, host := range spec.Ports {
+			cfg.ExposedPorts[cont] = struct{}{}
+			cfg.HostConfig.PortBindings[cont] = []portBinding{{HostPort: host}}
+		}
+	}
+	return &cfg
+}
+
+// Unload removes the container of a Unit, stopping it if still running
+func (m *UnitManager) Unload(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.hashes[name]; !ok {
+		m.UnitManager.Unload(name)
+		return
+	}
+	m.remove(name)
+}
+
+// remove removes the container of the named Unit; m.mu must be held
+func (m *UnitManager) remove(name string) {
+	log.Infof("Removing Docker container of unit %s", name)
+	if err := m.c.removeContainer(containerName(name)); err != nil && !isNotFound(err) {
+		log.Errorf("Failed removing Docker container of unit %s: %v", name, err)
+	}
+	delete(m.hashes, name)
+	delete(m.specs, name)
+}
+
+func (m *UnitManager) TriggerStart(name string) {
+	if !m.isContainer(name) {
+		m.UnitManager.TriggerStart(name)
+		return
+	}
+
+	if err := m.c.startContainer(containerName(name)); err != nil {
+		log.Errorf("Failed to start Docker container of unit %s: %v", name, err)
+		return
+	}
+	log.Infof("Started Docker container of unit %s", name)
+}
+
+func (m *UnitManager) TriggerStop(name string) {
+	if !m.isContainer(name) {
+		m.UnitManager.TriggerStop(name)
+		return
+	}
+
+	if err := m.c.stopContainer(containerName(name), m.stopTimeout(name)); err != nil {
+		log.Errorf("Failed to stop Docker container of unit %s: %v", name, err)
+		return
+	}
+	log.Infof("Stopped Docker container of unit %s", name)
+}
+
+// TriggerRestart restarts the container of a Unit in a single operation.
+// Other Units are restarted by the wrapped UnitManager, if it is able to,
+// or else stopped and started again.
+func (m *UnitManager) TriggerRestart(name string) {
+	if !m.isContainer(name) {
+		if r, ok := m.UnitManager.(restarter); ok {
+			r.TriggerRestart(name)
+		} else {
+			m.UnitManager.TriggerStop(name)
+			m.UnitManager.TriggerStart(name)
+		}
+		return
+	}
+
+	if err := m.c.restartContainer(containerName(name), m.stopTimeout(name)); err != nil {
+		log.Errorf("Failed to restart Docker container of unit %s: %v", name, err)
+		return
+	}
+	log.Infof("Restarted Docker container of unit %s", name)
+}
+
+// stopTimeout returns how long the container of the named Unit is given to
+// stop before it is killed. Containers picked up again after fleetd
+// restarted get the default until they are loaded anew.
+func (m *UnitManager) stopTimeout(name string) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if spec, ok := m.specs[name]; ok {
+		return spec.StopTimeout
+	}
+	return defaultStopTimeout
+}
+
+func (m *UnitManager) Units() ([]string, error) {
+	units, err := m.UnitManager.Units()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name := range m.hashes {
+		units = append(units, name)
+	}
+	sort.Strings(units)
+	return units, nil
+}
+
+func (m *UnitManager) GetUnitState(name string) (*unit.UnitState, error) {
+	m.mu.Lock()
+	hash, ok := m.hashes[name]
+	m.mu.Unlock()
+
+	if !ok {
+		return m.UnitManager.GetUnitState(name)
+	}
+	return m.containerState(name, hash)
+}
+
+func (m *UnitManager) GetUnitStates(filter pkg.Set) (map[string]*unit.UnitState, error) {
+	m.mu.Lock()
+	hashes := make(map[string]string)
+	others := pkg.NewUnsafeSet()
+	for _, name := range filter.Values() {
+		if hash, ok := m.hashes[name]; ok {
+			hashes[name] = hash
+		} else {
+			others.Add(name)
+		}
+	}
+	m.mu.Unlock()
+
+	states, err := m.UnitManager.GetUnitStates(others)
+	if err != nil {
+		return nil, err
+	}
+	for name, hash := range hashes {
+		us, err := m.containerState(name, hash)
+		if err != nil {
+			return nil, err
+		}
+		states[name] = us
+	}
+	return states, nil
+}
+
+// containerState maps the state of the container of a Unit onto the
+// states systemd reports for its units
+func (m *UnitManager) containerState(name, hash string) (*unit.UnitState, error) {
+	cs, err := m.c.inspectContainer(containerName(name))
+	if isNotFound(err) {
+		return &unit.UnitState{LoadState: "not-found", ActiveState: "inactive", SubState: "dead"}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	us := unit.UnitState{LoadState: "loaded", UnitHash: hash}
+	switch {
+	case cs.Restarting:
+		us.ActiveState, us.SubState = "activating", "auto-restart"
+	case cs.Paused:
+		us.ActiveState, us.SubState = "active", "paused"
+	case cs.Running:
+		us.ActiveState, us.SubState = "active", "running"
+	case cs.Status == "exited" && cs.ExitCode != 0:
+		us.ActiveState, us.SubState = "failed", "failed"
+	default:
+		us.ActiveState, us.SubState = "inactive", "dead"
+	}
+
+	if !cs.Running && !cs.FinishedAt.IsZero() {
+		finished := cs.FinishedAt
+		us.ExitStatus = cs.ExitCode
+		us.ExitTime = &finished
+	}
+	return &us, nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/unit"
+)
+
+// fakeDaemon serves the parts of the Docker API used by the UnitManager
+type fakeDaemon struct {
+	mu         sync.Mutex
+	images     map[string]bool
+	containers map[string]*fakeContainer
+	calls      []string
+}
+
+type fakeContainer struct {
+	cfg   containerConfig
+	state containerState
+}
+
+func (fd *fakeDaemon) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	fd.calls = append(fd.calls, req.Method+" "+req.URL.Path)
+
+	notFound := func() {
+		rw.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(rw).Encode(map[string]string{"message": "no such thing"})
+	}
+
+	switch {
+	case req.Method == "POST" && req.URL.Path == "/images/create":
+		fd.images[req.URL.Query().Get("fromImage")] = true
+		rw.Write([]byte(`{"status":"Pulling"}` + "\n" + `{"status":"Done"}`))
+	case req.Method == "POST" && req.URL.Path == "/containers/create":
+		var cfg containerConfig
+		json.NewDecoder(req.Body).Decode(&cfg)
+		if !fd.images[cfg.Image] {
+			notFound()
+			return
+		}
+		fd.containers[req.URL.Query().Get("name")] = &fakeContainer{cfg: cfg, state: containerState{Status: "created"}}
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte(`{"Id":"abc"}`))
+	case req.Method == "GET" && req.URL.Path == "/containers/json":
+		var cs []containerSummary
+		for _, c := range fd.containers {
+			cs = append(cs, containerSummary{Labels: c.cfg.Labels})
+		}
+		json.NewEncoder(rw).Encode(cs)
+	case len(parts) >= 2 && parts[0] == "containers":
+		c, ok := fd.containers[parts[1]]
+		if !ok {
+			notFound()
+			return
+		}
+		switch {
+		case req.Method == "DELETE":
+			delete(fd.containers, parts[1])
+		case len(parts) == 3 && parts[2] == "json":
+			json.NewEncoder(rw).Encode(map[string]interface{}{"State": c.state})
+			return
+		case len(parts) == 3 && (parts[2] == "start" || parts[2] == "restart"):
+			c.state = containerState{Status: "running", Running: true}
+		case len(parts) == 3 && parts[2] == "stop":
+			c.state = containerState{Status: "exited", ExitCode: 137, FinishedAt: time.Now()}
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		notFound()
+	}
+}
+
+func TestUnitManager(t *testing.T) {
+	fd := &fakeDaemon{images: map[string]bool{}, containers: map[string]*fakeContainer{}}
+	srv := httptest.NewServer(fd)
+	defer srv.Close()
+
+	fum := unit.NewFakeUnitManager()
+	m, err := NewUnitManager("tcp://"+strings.TrimPrefix(srv.URL, "http://"), fum)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cuf, _ := unit.NewUnitFile("[X-Docker]\nImage=nginx\nPublishPort=8080:80\n")
+	suf, _ := unit.NewUnitFile("[Service]\nExecStart=/bin/true\n")
+	if err := m.Load("web@1.service", *cuf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.Load("plain.service", *suf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the missing image is pulled, and only container units reach Docker
+	c, ok := fd.containers["fleet-web_1.service"]
+	if !ok {
+		t.Fatalf("expected container to be created, got %v", fd.calls)
+	}
+	wantBindings := map[string][]portBinding{"80/tcp": []portBinding{{HostPort: "8080"}}}
+	if !reflect.DeepEqual(wantBindings, c.cfg.HostConfig.PortBindings) {
+		t.Errorf("expected port bindings %v, got %v", wantBindings, c.cfg.HostConfig.PortBindings)
+	}
+	units, _ := m.Units()
+	if want := []string{"plain.service", "web@1.service"}; !reflect.DeepEqual(want, units) {
+		t.Errorf("expected units %v, got %v", want, units)
+	}
+
+	// loading an unchanged unit file keeps the container
+	fd.calls = nil
+	if err := m.Load("web@1.service", *cuf); err != nil || len(fd.calls) != 0 {
+		t.Errorf("expected no Docker calls, got %v (%v)", fd.calls, err)
+	}
+
+	m.TriggerStart("web@1.service")
+	m.TriggerStart("plain.service")
+	states, err := m.GetUnitStates(pkg.NewUnsafeSet("web@1.service", "plain.service"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &unit.UnitState{LoadState: "loaded", ActiveState: "active", SubState: "running", UnitHash: cuf.Hash().String()}
+	if !reflect.DeepEqual(want, states["web@1.service"]) {
+		t.Errorf("expected state %#v, got %#v", want, states["web@1.service"])
+	}
+	if us := states["plain.service"]; us == nil || us.ActiveState != "active" {
+		t.Errorf("expected plain.service to be active, got %#v", us)
+	}
+
+	m.TriggerStop("web@1.service")
+	us, err := m.GetUnitState("web@1.service")
+	if err != nil || us.ActiveState != "failed" || us.ExitStatus != 137 {
+		t.Errorf("expected container killed on stop to be failed, got %#v (%v)", us, err)
+	}
+
+	// a restarted fleetd picks up the containers it created
+	m2, err := NewUnitManager("tcp://"+strings.TrimPrefix(srv.URL, "http://"), unit.NewFakeUnitManager())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if units, _ := m2.Units(); !reflect.DeepEqual([]string{"web@1.service"}, units) {
+		t.Errorf("expected container unit to be picked up, got %v", units)
+	}
+
+	m.Unload("web@1.service")
+	if len(fd.containers) != 0 {
+		t.Errorf("expected container to be removed, got %v", fd.containers)
+	}
+}
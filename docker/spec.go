@@ -0,0 +1,123 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	// sectionName is the section of a unit file describing the container
+	// a Unit runs as
+	sectionName = "X-Docker"
+
+	defaultStopTimeout = 10 * time.Second
+)
+
+// ContainerSpec describes the container a Unit runs as
+type ContainerSpec struct {
+	Image       string
+	Command     []string
+	Environment []string
+	// Ports maps ports of the container, such as "80/tcp", to ports of
+	// the host
+	Ports       map[string]string
+	Volumes     []string
+	StopTimeout time.Duration
+}
+
+// ParseContainerSpec returns the ContainerSpec declared by the [X-Docker]
+// section of the given unit file, or nil if it has no such section
+func ParseContainerSpec(uf unit.UnitFile) (*ContainerSpec, error) {
+	section, ok := uf.Contents[sectionName]
+	if !ok {
+		return nil, nil
+	}
+
+	spec := ContainerSpec{StopTimeout: defaultStopTimeout}
+	for key, values := range section {
+		if len(values) == 0 {
+			continue
+		}
+		last := values[len(values)-1]
+		switch key {
+		case "Image":
+			spec.Image = last
+		case "Command":
+			spec.Command = strings.Fields(last)
+		case "Environment":
+			for _, v := range values {
+				if !strings.Contains(v, "=") {
+					return nil, fmt.Errorf("invalid Environment %q, expected KEY=VALUE", v)
+				}
+			}
+			spec.Environment = values
+		case "PublishPort":
+			spec.Ports = make(map[string]string, len(values))
+			for _, v := range values {
+				host, cont, err := parsePort(v)
+				if err != nil {
+					return nil, err
+				}
+				spec.Ports[cont] = host
+			}
+		case "Volume":
+			spec.Volumes = values
+		case "StopTimeout":
+			secs, err := strconv.Atoi(last)
+			if err != nil || secs < 0 {
+				return nil, fmt.Errorf("invalid StopTimeout %q", last)
+			}
+			spec.StopTimeout = time.Duration(secs) * time.Second
+		default:
+			return nil, fmt.Errorf("unrecognized %s option %q", sectionName, key)
+		}
+	}
+
+	if spec.Image == "" {
+		return nil, errors.New("Image must be set")
+	}
+	return &spec, nil
+}
+
+// parsePort splits a PublishPort value of the form HOST:CONTAINER[/PROTO]
+// into the port of the host and that of the container, with its protocol
+func parsePort(v string) (host, cont string, err error) {
+	parts := strings.SplitN(v, ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid PublishPort %q, expected HOST:CONTAINER", v)
+	}
+	host, cont = parts[0], parts[1]
+
+	proto := "tcp"
+	if i := strings.Index(cont, "/"); i >= 0 {
+		cont, proto = cont[:i], cont[i+1:]
+	}
+	for _, p := range []string{host, cont} {
+		if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+			return "", "", fmt.Errorf("invalid PublishPort %q, bad port %q", v, p)
+		}
+	}
+	if proto != "tcp" && proto != "udp" {
+		return "", "", fmt.Errorf("invalid PublishPort %q, bad protocol %q", v, proto)
+	}
+	return host, cont + "/" + proto, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/unit"
+)
+
+func TestParseContainerSpec(t *testing.T) {
+	tests := []struct {
+		contents string
+		want     *ContainerSpec
+		err      bool
+	}{
+		{"[Service]\nExecStart=/bin/true\n", nil, false},
+		{
+			"[X-Docker]\nImage=nginx:1.9\n",
+			&ContainerSpec{Image: "nginx:1.9", StopTimeout: 10 * time.Second},
+			false,
+		},
+		{
+			"[X-Docker]\nImage=redis\nCommand=redis-server --appendonly yes\nEnvironment=A=1\nEnvironment=B=2\nPublishPort=6379:6379\nPublishPort=5353:53/udp\nVolume=/data:/data\nStopTimeout=30\n",
+			&ContainerSpec{
+				Image:       "redis",
+				Command:     []string{"redis-server", "--appendonly", "yes"},
+				Environment: []string{"A=1", "B=2"},
+				Ports:       map[string]string{"6379/tcp": "6379", "53/udp": "5353"},
+				Volumes:     []string{"/data:/data"},
+				StopTimeout: 30 * time.Second,
+			},
+			false,
+		},
+		{"[X-Docker]\nCommand=true\n", nil, true},
+		{"[X-Docker]\nImage=redis\nEnvironment=A\n", nil, true},
+		{"[X-Docker]\nImage=redis\nPublishPort=6379\n", nil, true},
+		{"[X-Docker]\nImage=redis\nPublishPort=6379:70000\n", nil, true},
+		{"[X-Docker]\nImage=redis\nPublishPort=6379:6379/sctp\n", nil, true},
+		{"[X-Docker]\nImage=redis\nStopTimeout=soon\n", nil, true},
+		{"[X-Docker]\nImage=redis\nPrivileged=true\n", nil, true},
+	}
+
+	for i, tt := range tests {
+		uf, err := unit.NewUnitFile(tt.contents)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		spec, err := ParseContainerSpec(*uf)
+		if (err != nil) != tt.err {
+			t.Errorf("case %d: expected error %t, got %v", i, tt.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.want, spec) {
+			t.Errorf("case %d: expected %#v, got %#v", i, tt.want, spec)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"time"
+
+	"github.com/coreos/fleet/agent"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+)
+
+const (
+	// unschedulableBackoffMin and unschedulableBackoffMax bound the
+	// amount of time the engine waits before trying again to schedule a
+	// Job that could not be scheduled
+	unschedulableBackoffMin = 5 * time.Second
+	unschedulableBackoffMax = 5 * time.Minute
+)
+
+// unschedulableJob tracks a Job the engine has been unable to schedule
+type unschedulableJob struct {
+	job.Unschedulable
+
+	// unitHash identifies the version of the Unit that failed to be
+	// scheduled. A new version is tried right away.
+	unitHash string
+}
+
+// unschedulableBackoff returns how long to wait after the given number of
+// consecutive failures to schedule a Job
+func unschedulableBackoff(failures int) time.Duration {
+	d := unschedulableBackoffMin
+	for i := 1; i < failures && d < unschedulableBackoffMax; i++ {
+		d *= 2
+	}
+	if d > unschedulableBackoffMax {
+		d = unschedulableBackoffMax
+	}
+	return d
+}
+
+// backingOff reports whether an attempt to schedule the Job should be
+// deferred, as it failed to be scheduled too recently
+func (r *Reconciler) backingOff(j *job.Job, now time.Time) bool {
+	u, ok := r.unschedulable[j.Name]
+	if !ok {
+		return false
+	}
+	if u.unitHash != j.Unit.Hash().String() {
+		r.clearUnschedulable(j.Name)
+		return false
+	}
+	return now.Before(u.RetryAt)
+}
+
+// failedToSchedule records a failed attempt to schedule the Job, deferring
+// the next attempt by an exponentially growing amount of time
+func (r *Reconciler) failedToSchedule(j *job.Job, reason string, now time.Time) {
+	u, ok := r.unschedulable[j.Name]
+	if !ok || u.unitHash != j.Unit.Hash().String() {
+		u = &unschedulableJob{unitHash: j.Unit.Hash().String()}
+		r.unschedulable[j.Name] = u
+	}
+
+	u.Failures++
+	delay := unschedulableBackoff(u.Failures)
+	u.RetryAt = now.Add(delay)
+	if u.Reason != reason {
+		log.Infof("Unable to schedule Job(%s), retrying in %v: %s", j.Name, delay, reason)
+	} else {
+		log.Debugf("Unable to schedule Job(%s) after %d attempts, retrying in %v: %s", j.Name, u.Failures, delay, reason)
+	}
+	u.Reason = reason
+	r.unschedulableChanged[j.Name] = true
+}
+
+// clearUnschedulable forgets any failed attempts to schedule the named Job
+func (r *Reconciler) clearUnschedulable(name string) {
+	if _, ok := r.unschedulable[name]; !ok {
+		return
+	}
+	delete(r.unschedulable, name)
+	r.unschedulableChanged[name] = true
+}
+
+// retryOnNewMachines ends the backoff of each Job that could not be
+// scheduled but is able to run on a Machine that has joined the cluster
+// since the previous reconciliation, so that it is offered to the new
+// Machine right away. Its past failures are retained.
+func (r *Reconciler) retryOnNewMachines(clust *clusterState, agents map[string]*agent.AgentState, now time.Time) {
+	known := r.knownMachines
+	r.knownMachines = make(map[string]bool, len(clust.machines))
+	for _, ms := range clust.machines {
+		r.knownMachines[ms.ID] = true
+	}
+	if known == nil || len(r.unschedulable) == 0 {
+		return
+	}
+
+	var joined []*agent.AgentState
+	for _, ms := range clust.machines {
+		if !known[ms.ID] {
+			joined = append(joined, agents[ms.ID])
+		}
+	}
+
+	for name, u := range r.unschedulable {
+		j, ok := clust.jobs[name]
+		if !ok || !now.Before(u.RetryAt) {
+			continue
+		}
+		for _, as := range joined {
+			if able, _ := as.AbleToRun(j); able {
+				log.Infof("Retrying to schedule Job(%s) as Machine(%s) joined the cluster", name, as.MState.ID)
+				u.RetryAt = now
+				r.unschedulableChanged[name] = true
+				break
+			}
+		}
+	}
+}
+
+// pruneUnschedulable forgets the failed attempts of Jobs that have since
+// been scheduled, deactivated or destroyed
+func (r *Reconciler) pruneUnschedulable(clust *clusterState) {
+	for name := range r.unschedulable {
+		j, ok := clust.jobs[name]
+		if !ok {
+			// the record is removed along with the Job
+			delete(r.unschedulable, name)
+			continue
+		}
+		if j.Scheduled() || j.TargetState == job.JobStateInactive {
+			r.clearUnschedulable(name)
+		}
+	}
+}
+
+// saveUnschedulable persists the changes made to the records of the Jobs
+// that could not be scheduled since they were last saved
+func (e *Engine) saveUnschedulable() {
+	for name := range e.rec.unschedulableChanged {
+		var u *job.Unschedulable
+		if uj, ok := e.rec.unschedulable[name]; ok {
+			u = &uj.Unschedulable
+		}
+		if err := e.registry.SetUnschedulable(name, u); err != nil {
+			log.Errorf("Failed saving unschedulable state of Job(%s): %v", name, err)
+			continue
+		}
+		delete(e.rec.unschedulableChanged, name)
+	}
+}
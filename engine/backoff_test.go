@@ -0,0 +1,152 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+)
+
+func TestUnschedulableBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{4, 40 * time.Second},
+		{7, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+
+	for i, tt := range tests {
+		if got := unschedulableBackoff(tt.failures); got != tt.want {
+			t.Errorf("case %d: expected %v, got %v", i, tt.want, got)
+		}
+	}
+}
+
+func TestCalculateClusterTasksBackoff(t *testing.T) {
+	ssd := newUnitFile(t, "[X-Fleet]\nMachineMetadata=disk=ssd")
+	newClust := func(uf string, machines ...machine.MachineState) *clusterState {
+		units := []job.Unit{job.Unit{Name: "foo.service", Unit: newUnitFile(t, uf), TargetState: job.JobStateLaunched}}
+		return newClusterState(units, nil, machines)
+	}
+	count := func(r *Reconciler, clust *clusterState) int {
+		n := 0
+		for _ = range r.calculateClusterTasks(clust, make(chan struct{})) {
+			n++
+		}
+		return n
+	}
+	failures := func(r *Reconciler) int {
+		if u, ok := r.unschedulable["foo.service"]; ok {
+			return u.Failures
+		}
+		return 0
+	}
+
+	fclock := clockwork.NewFakeClock()
+	r := NewReconciler(0, 0)
+	r.clock = fclock
+	hdd := machine.MachineState{ID: "XXX", Metadata: map[string]string{"disk": "hdd"}}
+	contents := ssd.String()
+
+	if n := count(r, newClust(contents, hdd)); n != 0 || failures(r) != 1 {
+		t.Fatalf("expected no tasks and 1 failure, got %d tasks and %d failures", n, failures(r))
+	}
+
+	// attempts within the backoff period are skipped
+	fclock.Advance(4 * time.Second)
+	if count(r, newClust(contents, hdd)); failures(r) != 1 {
+		t.Fatalf("expected attempt to be skipped, got %d failures", failures(r))
+	}
+
+	fclock.Advance(time.Second)
+	if count(r, newClust(contents, hdd)); failures(r) != 2 {
+		t.Fatalf("expected a second attempt, got %d failures", failures(r))
+	}
+	want := fclock.Now().Add(10 * time.Second)
+	if got := r.unschedulable["foo.service"].RetryAt; !got.Equal(want) {
+		t.Fatalf("expected next attempt at %v, got %v", want, got)
+	}
+
+	// a new version of the Unit is tried right away
+	if n := count(r, newClust("[X-Fleet]\nMachineMetadata=disk=hdd", hdd)); n != 1 || failures(r) != 0 {
+		t.Fatalf("expected new version to be scheduled, got %d tasks and %d failures", n, failures(r))
+	}
+
+	// the record of the Job is saved, then removed once it is scheduled
+	reg := registry.NewFakeRegistry()
+	e := &Engine{rec: r, registry: reg}
+	count(r, newClust(contents, hdd))
+	e.saveUnschedulable()
+	u, err := reg.Unschedulable("foo.service")
+	if err != nil || u == nil || u.Failures != 1 || u.Reason != "no agents able to run job" {
+		t.Fatalf("expected saved record with 1 failure, got %#v (err %v)", u, err)
+	}
+
+	fclock.Advance(5 * time.Second)
+	ssdMachine := machine.MachineState{ID: "YYY", Metadata: map[string]string{"disk": "ssd"}}
+	if n := count(r, newClust(contents, hdd, ssdMachine)); n != 1 {
+		t.Fatalf("expected Job to be scheduled after backoff, got %d tasks", n)
+	}
+	e.saveUnschedulable()
+	if u, err := reg.Unschedulable("foo.service"); err != nil || u != nil {
+		t.Fatalf("expected record to be removed, got %#v (err %v)", u, err)
+	}
+}
+
+func TestCalculateClusterTasksRetryOnNewMachine(t *testing.T) {
+	units := []job.Unit{job.Unit{Name: "foo.service", Unit: newUnitFile(t, "[X-Fleet]\nMachineMetadata=disk=ssd"), TargetState: job.JobStateLaunched}}
+	count := func(r *Reconciler, machines ...machine.MachineState) int {
+		n := 0
+		for _ = range r.calculateClusterTasks(newClusterState(units, nil, machines), make(chan struct{})) {
+			n++
+		}
+		return n
+	}
+
+	fclock := clockwork.NewFakeClock()
+	r := NewReconciler(0, 0)
+	r.clock = fclock
+	hdd := machine.MachineState{ID: "XXX", Metadata: map[string]string{"disk": "hdd"}}
+	if n := count(r, hdd); n != 0 || r.unschedulable["foo.service"].Failures != 1 {
+		t.Fatalf("expected no tasks and a failed attempt, got %d tasks", n)
+	}
+
+	// a Machine unable to run the Job does not end its backoff
+	fclock.Advance(time.Second)
+	other := machine.MachineState{ID: "YYY", Metadata: map[string]string{"disk": "hdd"}}
+	if n := count(r, hdd, other); n != 0 || r.unschedulable["foo.service"].Failures != 1 {
+		t.Fatalf("expected attempt to be skipped, got %d tasks and %d failures", n, r.unschedulable["foo.service"].Failures)
+	}
+
+	// one able to run it is offered the Job right away
+	fclock.Advance(time.Second)
+	ssd := machine.MachineState{ID: "ZZZ", Metadata: map[string]string{"disk": "ssd"}}
+	if n := count(r, hdd, other, ssd); n != 1 {
+		t.Fatalf("expected Job to be scheduled to new Machine, got %d tasks", n)
+	}
+	if _, ok := r.unschedulable["foo.service"]; ok {
+		t.Fatalf("expected record of failed attempts to be cleared once scheduled")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"time"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/unit"
+)
+
+// reconcileBatchJobs records the completion of every scheduled batch Job
+// owned by the local engine whose Unit has exited, then unschedules it so
+// that it either stays finished or is retried. It returns whether any
+// batch Job remains scheduled, in which case its Unit must be watched for
+// an exit.
+func (e *Engine) reconcileBatchJobs(snap *snapshot) (running bool) {
+	var batch []job.Job
+	for _, u := range snap.units {
+		j := job.Job{Name: u.Name, Unit: u.Unit, TargetState: u.TargetState}
+		// Jobs with a cron Schedule track their runs separately
+		if cs, _ := j.CronSchedule(); cs != nil {
+			continue
+		}
+		if u.IsBatch() && u.TargetState == job.JobStateLaunched && e.rec.owned(&j) {
+			batch = append(batch, j)
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	targets := snap.targets()
+	byName := snap.statesByName()
+	if snap.comps == nil {
+		snap.comps = make(map[string]*job.Completion)
+	}
+	comps := snap.comps
+
+	for i := range batch {
+		j := &batch[i]
+		tgt := targets[j.Name]
+		if tgt == "" {
+			continue
+		}
+
+		c := batchCompletion(j, tgt, comps[j.Name], byName[j.Name])
+		if c != nil {
+			if err := e.registry.SaveCompletion(j.Name, c); err != nil {
+				log.Errorf("Failed recording completion of Job(%s): %v", j.Name, err)
+				running = true
+				continue
+			}
+			log.Infof("Job(%s) %s on Machine(%s) with exit status %d after %d attempt(s)", j.Name, c.State, tgt, c.ExitStatus, c.Attempts)
+			comps[j.Name] = c
+		} else if prev := comps[j.Name]; prev == nil || prev.UnitHash != j.Unit.Hash().String() || !prev.Done() {
+			running = true
+			continue
+		}
+
+		if err := e.registry.UnscheduleUnit(j.Name, tgt); err != nil {
+			log.Errorf("Failed unscheduling completed Job(%s) from Machine(%s): %v", j.Name, tgt, err)
+			running = true
+			continue
+		}
+		e.recordDecision(&task{Type: taskTypeUnscheduleUnit, Reason: "batch unit completed", JobName: j.Name, MachineID: tgt})
+		snap.unschedule(j.Name)
+	}
+	return
+}
+
+// batchCompletion determines whether the given batch Job, scheduled to the
+// given Machine, has exited since its previous Completion (if any), and
+// returns its new Completion if so. A Job that exits successfully is done;
+// one that fails is retried until it has failed more times than its
+// BatchRetries option allows. Completions of other versions of the Unit
+// are disregarded.
+func batchCompletion(j *job.Job, machID string, prev *job.Completion, states []*unit.UnitState) *job.Completion {
+	if prev != nil && prev.UnitHash != j.Unit.Hash().String() {
+		prev = nil
+	}
+	if prev == nil {
+		return nextCompletion(j, machID, 0, time.Time{}, states)
+	}
+	if prev.Done() {
+		return nil
+	}
+	return nextCompletion(j, machID, prev.Attempts, prev.FinishedAt, states)
+}
+
+// nextCompletion looks for an exit of the current version of the given
+// Job's Unit on the given Machine later than since, and returns the
+// resulting Completion, counting the given number of previous attempts.
+func nextCompletion(j *job.Job, machID string, attempts int, since time.Time, states []*unit.UnitState) *job.Completion {
+	hash := j.Unit.Hash().String()
+	for _, us := range states {
+		if us.MachineID != machID || us.UnitHash != hash || us.ExitTime == nil {
+			continue
+		}
+		// the exit has already been recorded
+		if !us.ExitTime.After(since) {
+			continue
+		}
+
+		c := job.Completion{
+			UnitHash:   hash,
+			MachineID:  machID,
+			ExitStatus: us.ExitStatus,
+			FinishedAt: *us.ExitTime,
+			Attempts:   attempts + 1,
+		}
+
+		switch {
+		case us.ExitStatus == 0 && us.ActiveState != "failed":
+			c.State = job.CompletionStateSucceeded
+		case c.Attempts > j.BatchRetries():
+			c.State = job.CompletionStateFailed
+		default:
+			c.State = job.CompletionStateRetrying
+		}
+		return &c
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestBatchCompletion(t *testing.T) {
+	uf := newUnitFile(t, "[X-Fleet]\nBatch=true\nBatchRetries=1")
+	j := &job.Job{Name: "foo.service", Unit: uf}
+	hash := uf.Hash().String()
+
+	first := time.Unix(1000, 0)
+	second := time.Unix(2000, 0)
+	exited := func(machID, hash, active string, status int, at time.Time) *unit.UnitState {
+		return &unit.UnitState{UnitName: "foo.service", MachineID: machID, UnitHash: hash, ActiveState: active, ExitStatus: status, ExitTime: &at}
+	}
+
+	tests := []struct {
+		prev   *job.Completion
+		states []*unit.UnitState
+		want   *job.Completion
+	}{
+		// still running
+		{
+			states: []*unit.UnitState{&unit.UnitState{UnitName: "foo.service", MachineID: "XXX", UnitHash: hash, ActiveState: "active"}},
+			want:   nil,
+		},
+		// exits reported by other machines or versions are ignored
+		{
+			states: []*unit.UnitState{
+				exited("YYY", hash, "inactive", 0, first),
+				exited("XXX", "abc", "inactive", 0, first),
+			},
+			want: nil,
+		},
+		// successful exit
+		{
+			states: []*unit.UnitState{exited("XXX", hash, "inactive", 0, first)},
+			want:   &job.Completion{UnitHash: hash, MachineID: "XXX", FinishedAt: first, Attempts: 1, State: job.CompletionStateSucceeded},
+		},
+		// first failure is retried
+		{
+			states: []*unit.UnitState{exited("XXX", hash, "failed", 2, first)},
+			want:   &job.Completion{UnitHash: hash, MachineID: "XXX", ExitStatus: 2, FinishedAt: first, Attempts: 1, State: job.CompletionStateRetrying},
+		},
+		// an exit that has already been recorded is not counted again
+		{
+			prev:   &job.Completion{UnitHash: hash, MachineID: "XXX", ExitStatus: 2, FinishedAt: first, Attempts: 1, State: job.CompletionStateRetrying},
+			states: []*unit.UnitState{exited("XXX", hash, "failed", 2, first)},
+			want:   nil,
+		},
+		// failing more often than allowed is final
+		{
+			prev:   &job.Completion{UnitHash: hash, MachineID: "YYY", ExitStatus: 2, FinishedAt: first, Attempts: 1, State: job.CompletionStateRetrying},
+			states: []*unit.UnitState{exited("XXX", hash, "failed", 1, second)},
+			want:   &job.Completion{UnitHash: hash, MachineID: "XXX", ExitStatus: 1, FinishedAt: second, Attempts: 2, State: job.CompletionStateFailed},
+		},
+		// nothing changes once done
+		{
+			prev:   &job.Completion{UnitHash: hash, MachineID: "XXX", FinishedAt: first, Attempts: 1, State: job.CompletionStateSucceeded},
+			states: []*unit.UnitState{exited("XXX", hash, "inactive", 0, second)},
+			want:   nil,
+		},
+		// completions of a previous version are disregarded
+		{
+			prev:   &job.Completion{UnitHash: "abc", MachineID: "XXX", FinishedAt: second, Attempts: 1, State: job.CompletionStateSucceeded},
+			states: []*unit.UnitState{exited("XXX", hash, "inactive", 0, first)},
+			want:   &job.Completion{UnitHash: hash, MachineID: "XXX", FinishedAt: first, Attempts: 1, State: job.CompletionStateSucceeded},
+		},
+	}
+
+	for i, tt := range tests {
+		got := batchCompletion(j, "XXX", tt.prev, tt.states)
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("case %d: expected %#v, got %#v", i, tt.want, got)
+		}
+	}
+}
+
+func TestCalculateClusterTasksSkipsCompletedBatchJobs(t *testing.T) {
+	uf := newUnitFile(t, "[X-Fleet]\nBatch=true")
+	units := []job.Unit{
+		job.Unit{Name: "done.service", Unit: uf, TargetState: job.JobStateLaunched},
+		job.Unit{Name: "retry.service", Unit: uf, TargetState: job.JobStateLaunched},
+	}
+	clust := newClusterState(units, []job.ScheduledUnit{}, []machine.MachineState{machine.MachineState{ID: "XXX"}})
+	clust.markDormant(map[string]*job.Completion{
+		"done.service":  &job.Completion{UnitHash: uf.Hash().String(), State: job.CompletionStateSucceeded},
+		"retry.service": &job.Completion{UnitHash: uf.Hash().String(), State: job.CompletionStateRetrying},
+	}, nil)
+
+	var scheduled []string
+	for tsk := range NewReconciler(0, 0).calculateClusterTasks(clust, make(chan struct{})) {
+		scheduled = append(scheduled, tsk.JobName)
+	}
+
+	if want := []string{"retry.service"}; !reflect.DeepEqual(want, scheduled) {
+		t.Fatalf("expected %v scheduled, got %v", want, scheduled)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"time"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/unit"
+)
+
+// reconcileCronJobs starts a run of every Job with a cron Schedule owned by
+// the local engine whose next run is due, and records the outcome of runs
+// whose Unit has exited. A Job is only scheduled while a run is in
+// progress, and is unscheduled again once the run has finished. It returns
+// whether any run is in progress, in which case its Unit must be watched
+// for an exit, and the earliest time at which a run is next due.
+func (e *Engine) reconcileCronJobs(snap *snapshot) (running bool, next time.Time) {
+	var cron []job.Job
+	for _, u := range snap.units {
+		j := job.Job{Name: u.Name, Unit: u.Unit, TargetState: u.TargetState}
+		if sched, _ := j.CronSchedule(); sched != nil && u.TargetState == job.JobStateLaunched && e.rec.owned(&j) {
+			cron = append(cron, j)
+		}
+	}
+	if len(cron) == 0 {
+		return
+	}
+
+	targets := snap.targets()
+	byName := snap.statesByName()
+	if snap.hists == nil {
+		snap.hists = make(map[string]*job.RunHistory)
+	}
+
+	now := e.rec.clock.Now()
+	for i := range cron {
+		j := &cron[i]
+		h := snap.hists[j.Name]
+		if h == nil {
+			h = &job.RunHistory{}
+		}
+
+		tgt := targets[j.Name]
+		changed, finished := advanceRunHistory(j, h, tgt, byName[j.Name], now)
+		if changed {
+			if err := e.registry.SaveRunHistory(j.Name, h); err != nil {
+				log.Errorf("Failed saving run history of Job(%s): %v", j.Name, err)
+				changed, finished = false, false
+			}
+		}
+		if h.Current() != nil {
+			running = true
+		}
+		if !h.NextRun.IsZero() && (next.IsZero() || h.NextRun.Before(next)) {
+			next = h.NextRun
+		}
+		if !changed {
+			continue
+		}
+		snap.hists[j.Name] = h
+
+		if finished {
+			r := h.Runs[len(h.Runs)-1]
+			log.Infof("Run of Job(%s) due at %v %s on Machine(%s) with exit status %d", j.Name, r.ScheduledAt, r.State, tgt, r.ExitStatus)
+			if err := e.registry.UnscheduleUnit(j.Name, tgt); err != nil {
+				log.Errorf("Failed unscheduling Job(%s) from Machine(%s): %v", j.Name, tgt, err)
+				running = true
+			} else {
+				e.recordDecision(&task{Type: taskTypeUnscheduleUnit, Reason: "scheduled run completed", JobName: j.Name, MachineID: tgt})
+				snap.unschedule(j.Name)
+			}
+		} else if run := h.Current(); run != nil && run.Attempts == 0 {
+			log.Infof("Starting run of Job(%s) due at %v", j.Name, run.ScheduledAt)
+		}
+	}
+	return
+}
+
+// advanceRunHistory updates the RunHistory of a Job with a cron Schedule,
+// which is currently scheduled to the given Machine (if any). If a run is
+// in progress and its Unit has exited since the run began, the outcome is
+// recorded. A failed run is retried, as for any batch Job. Otherwise, a
+// new run is started if one is due. Runs never overlap: a run that falls
+// due while another is in progress starts once that one finishes. It
+// returns whether the RunHistory changed, and whether the Job's Unit has
+// exited and should be unscheduled.
+func advanceRunHistory(j *job.Job, h *job.RunHistory, machID string, states []*unit.UnitState, now time.Time) (changed, exited bool) {
+	sched, err := j.CronSchedule()
+	if err != nil || sched == nil {
+		return
+	}
+
+	if run := h.Current(); run != nil {
+		if machID == "" {
+			return
+		}
+
+		since := run.ScheduledAt
+		if run.FinishedAt.After(since) {
+			since = run.FinishedAt
+		}
+		c := nextCompletion(j, machID, run.Attempts, since, states)
+		if c == nil {
+			return
+		}
+
+		run.Completion = *c
+		return true, true
+	}
+
+	if h.NextRun.IsZero() {
+		// the schedule may never match, e.g. "0 0 30 2 *"
+		if h.NextRun = sched.Next(now); h.NextRun.IsZero() {
+			return
+		}
+		return true, false
+	}
+
+	if now.Before(h.NextRun) {
+		return
+	}
+
+	h.Start(h.NextRun)
+	h.NextRun = sched.Next(now)
+	return true, false
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestAdvanceRunHistory(t *testing.T) {
+	uf := newUnitFile(t, "[X-Fleet]\nSchedule=0 * * * *\nBatchRetries=1")
+	j := &job.Job{Name: "foo.service", Unit: uf}
+	exited := func(status int, at time.Time) []*unit.UnitState {
+		return []*unit.UnitState{&unit.UnitState{UnitName: j.Name, MachineID: "XXX", UnitHash: uf.Hash().String(), ExitStatus: status, ExitTime: &at}}
+	}
+
+	start := time.Date(2014, time.October, 15, 10, 30, 0, 0, time.UTC)
+	var h job.RunHistory
+
+	// the first pass only determines when the first run is due
+	if changed, _ := advanceRunHistory(j, &h, "", nil, start); !changed || h.Current() != nil {
+		t.Fatalf("expected next run to be computed without starting a run")
+	}
+	if want := start.Add(30 * time.Minute); !h.NextRun.Equal(want) {
+		t.Fatalf("expected next run at %v, got %v", want, h.NextRun)
+	}
+
+	// nothing happens before the run is due
+	if changed, _ := advanceRunHistory(j, &h, "", nil, start.Add(time.Minute)); changed {
+		t.Fatalf("unexpected change before run is due")
+	}
+
+	// once due, a run starts
+	due := start.Add(30 * time.Minute)
+	if changed, _ := advanceRunHistory(j, &h, "", nil, due.Add(time.Second)); !changed || h.Current() == nil {
+		t.Fatalf("expected run to start")
+	}
+	if !h.Current().ScheduledAt.Equal(due) {
+		t.Fatalf("expected run due at %v, got %v", due, h.Current().ScheduledAt)
+	}
+
+	// an exit from before the run began is disregarded
+	if changed, _ := advanceRunHistory(j, &h, "XXX", exited(0, due.Add(-time.Minute)), due.Add(time.Minute)); changed {
+		t.Fatalf("unexpected change from stale exit")
+	}
+
+	// a failure is retried
+	changed, done := advanceRunHistory(j, &h, "XXX", exited(1, due.Add(time.Minute)), due.Add(2*time.Minute))
+	if !changed || !done || h.Current() == nil || h.Current().State != job.CompletionStateRetrying {
+		t.Fatalf("expected failed run to be retried, got %#v", h.Runs)
+	}
+
+	// a later run falling due does not start while this one is retried
+	if changed, _ := advanceRunHistory(j, &h, "", nil, due.Add(time.Hour+time.Minute)); changed || len(h.Runs) != 1 {
+		t.Fatalf("unexpected overlapping run: %#v", h.Runs)
+	}
+
+	// success finishes the run
+	changed, done = advanceRunHistory(j, &h, "YYY", []*unit.UnitState{}, due.Add(time.Hour+time.Minute))
+	if changed || done {
+		t.Fatalf("unexpected completion without exit")
+	}
+	changed, done = advanceRunHistory(j, &h, "XXX", exited(0, due.Add(time.Hour+2*time.Minute)), due.Add(time.Hour+3*time.Minute))
+	if !changed || !done || h.Current() != nil {
+		t.Fatalf("expected run to finish, got %#v", h.Runs)
+	}
+	if r := h.Runs[0]; r.State != job.CompletionStateSucceeded || r.Attempts != 2 {
+		t.Fatalf("expected run to succeed on second attempt, got %#v", r)
+	}
+
+	// the overdue run then starts straight away
+	if changed, _ := advanceRunHistory(j, &h, "", nil, due.Add(time.Hour+4*time.Minute)); !changed || len(h.Runs) != 2 {
+		t.Fatalf("expected overdue run to start, got %#v", h.Runs)
+	}
+	if want := due.Add(2 * time.Hour); !h.NextRun.Equal(want) {
+		t.Fatalf("expected next run at %v, got %v", want, h.NextRun)
+	}
+}
+
+func TestMarkDormantCronJobs(t *testing.T) {
+	uf := newUnitFile(t, "[X-Fleet]\nSchedule=@hourly")
+	units := []job.Unit{
+		job.Unit{Name: "idle.service", Unit: uf, TargetState: job.JobStateLaunched},
+		job.Unit{Name: "new.service", Unit: uf, TargetState: job.JobStateLaunched},
+		job.Unit{Name: "running.service", Unit: uf, TargetState: job.JobStateLaunched},
+	}
+	clust := newClusterState(units, []job.ScheduledUnit{}, nil)
+
+	idle := job.RunHistory{}
+	idle.Start(time.Unix(0, 0))
+	idle.Runs[0].State = job.CompletionStateSucceeded
+	running := job.RunHistory{}
+	running.Start(time.Unix(0, 0))
+
+	clust.markDormant(nil, map[string]*job.RunHistory{
+		"idle.service":    &idle,
+		"running.service": &running,
+	})
+
+	for name, want := range map[string]bool{"idle.service": true, "new.service": true, "running.service": false} {
+		if got := clust.dormant[name]; got != want {
+			t.Errorf("Job(%s): expected dormant %t, got %t", name, want, got)
+		}
+	}
+}
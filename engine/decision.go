@@ -0,0 +1,48 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+)
+
+// recordDecision persists the scheduling decision carried out by the given
+// task in the Registry, unless the engine keeps no decision history.
+// Failing to record a decision does not affect the decision itself.
+func (e *Engine) recordDecision(t *task) {
+	if e.decisionHistory <= 0 {
+		return
+	}
+
+	d := job.Decision{
+		Time:       e.rec.clock.Now(),
+		MachineID:  t.MachineID,
+		Reason:     t.Reason,
+		Candidates: t.Candidates,
+	}
+	switch t.Type {
+	case taskTypeAttemptScheduleUnit, taskTypeRescheduleUnit:
+		d.Type = job.DecisionTypeSchedule
+	case taskTypeUnscheduleUnit:
+		d.Type = job.DecisionTypeUnschedule
+	default:
+		return
+	}
+
+	if err := e.registry.RecordDecision(t.JobName, d, e.decisionHistory); err != nil {
+		log.Errorf("Failed recording scheduling decision for Job(%s): %v", t.JobName, err)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+)
+
+func TestDoTaskRecordsDecisions(t *testing.T) {
+	fclock := clockwork.NewFakeClock()
+	fr := registry.NewFakeRegistry()
+	if err := fr.CreateUnit(&job.Unit{Name: "foo.service"}); err != nil {
+		t.Fatalf("error creating unit: %v", err)
+	}
+	e := &Engine{registry: fr, rec: NewReconciler(0, 0), decisionHistory: 2}
+	e.rec.clock = fclock
+
+	cands := []job.Candidate{{MachineID: "XXX", Able: true, Score: 1}}
+	tasks := []*task{
+		&task{Type: taskTypeAttemptScheduleUnit, Reason: "first", JobName: "foo.service", MachineID: "XXX", Candidates: cands},
+		&task{Type: taskTypeUnscheduleUnit, Reason: "second", JobName: "foo.service", MachineID: "XXX"},
+		&task{Type: taskTypeAttemptScheduleUnit, Reason: "third", JobName: "foo.service", MachineID: "YYY"},
+	}
+	for _, tsk := range tasks {
+		if err := doTask(tsk, e); err != nil {
+			t.Fatalf("unexpected error resolving task %s: %v", tsk, err)
+		}
+	}
+
+	// only the most recent decisions are retained
+	want := []job.Decision{
+		job.Decision{Time: fclock.Now(), Type: job.DecisionTypeUnschedule, MachineID: "XXX", Reason: "second"},
+		job.Decision{Time: fclock.Now(), Type: job.DecisionTypeSchedule, MachineID: "YYY", Reason: "third"},
+	}
+	if got, _ := fr.Decisions("foo.service"); !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected decisions %#v, got %#v", want, got)
+	}
+
+	// nothing is recorded when the history is disabled
+	e.decisionHistory = 0
+	fr.UnscheduleUnit("foo.service", "YYY")
+	if err := doTask(tasks[0], e); err != nil {
+		t.Fatalf("unexpected error resolving task %s: %v", tasks[0], err)
+	}
+	if got, _ := fr.Decisions("foo.service"); !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected decisions %#v, got %#v", want, got)
+	}
+}
+
+func TestCalculateClusterTasksExplained(t *testing.T) {
+	uf := newUnitFile(t, "[X-Fleet]\nMachineMetadata=disk=ssd")
+	clust := newClusterState(
+		[]job.Unit{job.Unit{Name: "foo.service", Unit: uf, TargetState: job.JobStateLaunched}},
+		[]job.ScheduledUnit{},
+		[]machine.MachineState{
+			machine.MachineState{ID: "XXX"},
+			machine.MachineState{ID: "YYY", Metadata: map[string]string{"disk": "ssd"}},
+		},
+	)
+
+	r := NewReconciler(0, 0)
+	r.explain = true
+	var tasks []*task
+	for tsk := range r.calculateClusterTasks(clust, make(chan struct{})) {
+		tasks = append(tasks, tsk)
+	}
+
+	want := []*task{
+		&task{
+			Type:      taskTypeAttemptScheduleUnit,
+			Reason:    "target state launched and unit not scheduled",
+			JobName:   "foo.service",
+			MachineID: "YYY",
+			Candidates: []job.Candidate{
+				job.Candidate{MachineID: "XXX", Able: false, Reason: "local Machine metadata insufficient"},
+				job.Candidate{MachineID: "YYY", Able: true},
+			},
+		},
+	}
+	if !reflect.DeepEqual(want, tasks) {
+		t.Fatalf("expected tasks %#v, got %#v", want, tasks)
+	}
+}
+
+func TestCalculateClusterTasksExplainedGroups(t *testing.T) {
+	uf := newUnitFile(t, "[X-Fleet]\nGroup=app")
+	clust := newClusterState(
+		[]job.Unit{
+			job.Unit{Name: "a.service", Unit: uf, TargetState: job.JobStateLaunched},
+			job.Unit{Name: "b.service", Unit: uf, TargetState: job.JobStateLaunched},
+		},
+		[]job.ScheduledUnit{},
+		[]machine.MachineState{machine.MachineState{ID: "XXX"}},
+	)
+
+	r := NewReconciler(0, 0)
+	r.explain = true
+	want := []job.Candidate{job.Candidate{MachineID: "XXX", Able: true}}
+	for tsk := range r.calculateClusterTasks(clust, make(chan struct{})) {
+		if !reflect.DeepEqual(want, tsk.Candidates) {
+			t.Errorf("expected candidates %#v for %s, got %#v", want, tsk.JobName, tsk.Candidates)
+		}
+	}
+}
@@ -16,6 +16,7 @@ package engine
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/coreos/fleet/log"
@@ -40,49 +41,248 @@ type Engine struct {
 	rStream   pkg.EventStream
 	machine   machine.Machine
 
-	lease   registry.Lease
+	// leases holds the lease of each shard of the schedule, which is
+	// nil or held by another Machine for shards not owned locally. The
+	// leases are maintained in the background, so access is guarded by
+	// leaseMu.
+	leaseMu sync.Mutex
+	leases  []registry.Lease
 	trigger chan struct{}
+
+	// leaseRenewal is how often the leases held are renewed, each by a
+	// registry.LeaseKeeper of its own. leaseLost is signalled when any
+	// of them is lost, so that leadership is updated right away.
+	leaseRenewal time.Duration
+	leaseLost    chan struct{}
+
+	// lastReconcile is when the cluster was last reconciled successfully.
+	// It is published alongside the leases while any are held, so it is
+	// also guarded by leaseMu.
+	lastReconcile time.Time
+	published     bool
+
+	// resigned is set once the engine has given up its leases for good,
+	// after which it must not acquire any again
+	resigned bool
+
+	// resyncInterval bounds how long the engine may go without rebuilding
+	// the cluster state when no changes have been observed. A value of
+	// zero rebuilds the cluster state on every reconciliation.
+	resyncInterval time.Duration
+	lastSync       time.Time
+	changes        *changeTracker
+
+	// cache is the snapshot of the previous reconciliation, last read in
+	// full from the Registry at lastRefresh. Tasks are applied to it by
+	// the reconcile workers, so updates are guarded by cacheMu.
+	cache       *snapshot
+	cacheMu     sync.Mutex
+	lastRefresh time.Time
+
+	// reconcileWorkers is the number of tasks carried out concurrently
+	reconcileWorkers int
+
+	// maxUnits is the cluster-wide maximum number of Units the engine will
+	// schedule to a single Machine. A value of zero means no limit.
+	maxUnits int
+
+	// reconcileDebounce is how long reconciliation is held off after a
+	// change to the cluster, in case more changes follow
+	reconcileDebounce time.Duration
+
+	// rebalanceInterval is how often the engine moves units from the most
+	// to the least loaded Machines. A value of zero disables rebalancing.
+	rebalanceInterval time.Duration
+	lastRebalance     time.Time
+
+	// gcInterval is how often the engine owning the first shard removes
+	// orphaned state from the Registry. Orphans found by the previous
+	// pass are kept in gcCandidates. A value of zero disables garbage
+	// collection.
+	gcInterval   time.Duration
+	lastGC       time.Time
+	gcCandidates map[registry.Orphan]bool
+
+	// decisionHistory is the number of scheduling decisions recorded in
+	// the Registry for each Job. A value of zero disables recording.
+	decisionHistory int
+
+	// awaitingUnits is set while batch Jobs, cron runs, Rollouts or Jobs
+	// to be started after others are waiting on Units to change state.
+	// UnitStates are not watched, so the cluster is reconciled on every
+	// pass until they are done.
+	awaitingUnits bool
+
+	// nextRun is the earliest time at which a cron Job is next due, or
+	// zero if none is
+	nextRun time.Time
+}
+
+// Config holds the settings of an Engine
+type Config struct {
+	// ReconcileDebounce is how long the engine waits for a burst of
+	// cluster changes to end before reconciling. A value of zero
+	// reconciles on every change.
+	ReconcileDebounce time.Duration
+
+	// RescheduleGrace is how long the engine waits after a Machine is
+	// lost before rescheduling its Units
+	RescheduleGrace time.Duration
+
+	// ResyncInterval is how often the cluster is reconciled in the
+	// absence of relevant changes. A value of zero reconciles on every
+	// pass.
+	ResyncInterval time.Duration
+
+	// MaxSchedule limits the number of Units scheduled in a single
+	// reconciliation. A value of zero means no limit.
+	MaxSchedule int
+
+	// Shards is the number of partitions of the schedule. Values below
+	// one are treated as one.
+	Shards int
+
+	// MaxUnits limits the number of Units scheduled to a single Machine.
+	// A value of zero means no limit.
+	MaxUnits int
+
+	// RebalanceInterval is how often Units are moved from the most to
+	// the least loaded Machines, in groups of Machines sharing the value
+	// of the RebalanceBy metadata key if set, and at most RebalanceMoves
+	// at a time. A zero interval disables rebalancing.
+	RebalanceInterval time.Duration
+	RebalanceBy       string
+	RebalanceMoves    int
+
+	// ScorerWeights weights the scorers rating Machines, as parsed by
+	// ParseScorerWeights
+	ScorerWeights map[string]float64
+
+	// ReconcileWorkers is the number of scheduling tasks carried out
+	// against the Registry concurrently. Values below one are treated
+	// as one.
+	ReconcileWorkers int
+
+	// DecisionHistory is the number of scheduling decisions recorded for
+	// each Job. A value of zero disables recording.
+	DecisionHistory int
+
+	// GCInterval is how often orphaned state is removed from the
+	// Registry. A value of zero disables garbage collection.
+	GCInterval time.Duration
 }
 
-func New(reg *registry.EtcdRegistry, rStream pkg.EventStream, mach machine.Machine) *Engine {
-	rec := NewReconciler()
+func New(b *registry.Backend, mach machine.Machine, cfg Config) *Engine {
+	rec := NewReconciler(cfg.RescheduleGrace, cfg.MaxSchedule)
+	rec.sched = newScoringScheduler(cfg.ScorerWeights)
+	rec.explain = cfg.DecisionHistory > 0
+	rec.rebalanceBy = cfg.RebalanceBy
+	rec.rebalanceMoves = cfg.RebalanceMoves
+	shards := cfg.Shards
+	if shards < 1 {
+		shards = 1
+	}
 	return &Engine{
-		rec:       rec,
-		registry:  reg,
-		cRegistry: reg,
-		lRegistry: reg,
-		rStream:   rStream,
-		machine:   mach,
-		trigger:   make(chan struct{}),
+		rec:            rec,
+		registry:       b.Registry,
+		cRegistry:      b.ClusterRegistry,
+		lRegistry:      b.LeaseRegistry,
+		rStream:        b.EngineEvents,
+		machine:        mach,
+		leases:         make([]registry.Lease, shards),
+		trigger:        make(chan struct{}),
+		leaseLost:      make(chan struct{}, 1),
+		resyncInterval: cfg.ResyncInterval,
+		changes:        &changeTracker{EventStream: b.EngineEvents},
+		maxUnits:       cfg.MaxUnits,
+
+		reconcileDebounce: cfg.ReconcileDebounce,
+		rebalanceInterval: cfg.RebalanceInterval,
+		decisionHistory:   cfg.DecisionHistory,
+		reconcileWorkers:  cfg.ReconcileWorkers,
+		gcInterval:        cfg.GCInterval,
+	}
+}
+
+// leaseTimings returns the lease TTL and renewal interval used with the
+// given reconcile interval and jitter. If leaseTTL or renewIval are zero,
+// they default to five times the longest reconcile interval and to the
+// reconcile interval, respectively.
+func leaseTimings(ival, jitter, leaseTTL, renewIval time.Duration) (time.Duration, time.Duration) {
+	if leaseTTL <= 0 {
+		leaseTTL = (ival + jitter) * 5
+	}
+	if renewIval <= 0 {
+		renewIval = ival
+	}
+	return leaseTTL, renewIval
+}
+
+// ValidateLeaseTimings returns an error if the lease the engine would hold
+// with the given settings, once defaults are applied, could lapse between
+// renewals
+func ValidateLeaseTimings(ival, jitter, leaseTTL, renewIval time.Duration) error {
+	leaseTTL, renewIval = leaseTimings(ival, jitter, leaseTTL, renewIval)
+	if renewIval >= leaseTTL {
+		return fmt.Errorf("engine lease renewal interval (%v) must be less than engine lease TTL (%v)", renewIval, leaseTTL)
 	}
+	return nil
 }
 
-func (e *Engine) Run(ival time.Duration, stop chan bool) {
-	leaseTTL := ival * 5
+// Run reconciles the cluster every ival, extended by up to jitter, and
+// after bursts of changes to the cluster, for as long as the local engine
+// holds leadership. Each lease held is renewed every renewIval on its own
+// goroutine, so that neither a long reconciliation nor a slow etcd call
+// can cause it to lapse, while leases are acquired and released every
+// renewIval and as soon as one is lost. Zero values of leaseTTL and
+// renewIval are defaulted as by leaseTimings.
+func (e *Engine) Run(ival, jitter, leaseTTL, renewIval time.Duration, stop chan bool) {
+	leaseTTL, renewIval = leaseTimings(ival, jitter, leaseTTL, renewIval)
 	machID := e.machine.State().ID
 
-	reconcile := func() {
-		if !ensureEngineVersionMatch(e.cRegistry, engineVersion) {
-			return
+	e.leaseMu.Lock()
+	e.leaseRenewal = renewIval
+	e.leaseMu.Unlock()
+
+	e.maintainLeadership(machID, leaseTTL)
+	go func() {
+		ticker := time.NewTicker(renewIval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			case <-e.leaseLost:
+			}
+			e.maintainLeadership(machID, leaseTTL)
 		}
+	}()
 
-		var l registry.Lease
-		if isLeader(e.lease, machID) {
-			l = renewLeadership(e.lease, leaseTTL)
-		} else {
-			l = acquireLeadership(e.lRegistry, machID, engineVersion, leaseTTL)
+	reconcile := func() {
+		// another engine resigning frees its leases right away, so
+		// there is no need to wait for the next renewal to take over
+		if e.changes.takeResignation() {
+			e.maintainLeadership(machID, leaseTTL)
 		}
 
-		// log all leadership changes
-		if l != nil && e.lease == nil && l.MachineID() != machID {
-			log.Infof("Engine leader is %s", l.MachineID())
-		} else if l != nil && e.lease != nil && l.MachineID() != e.lease.MachineID() {
-			log.Infof("Engine leadership changed from %s to %s", e.lease.MachineID(), l.MachineID())
+		e.leaseMu.Lock()
+		owned := e.ownedShards(machID)
+		shards := len(e.leases)
+		e.leaseMu.Unlock()
+
+		if len(owned) == 0 {
+			return
 		}
+		e.rec.owns = ownsJob(owned, shards)
 
-		e.lease = l
+		// a single engine collects garbage for the whole cluster
+		if owned[0] == 0 && e.gcDue() {
+			e.collectGarbage()
+		}
 
-		if !isLeader(e.lease, machID) {
+		if !e.needsReconcile() {
+			log.Debugf("No cluster changes observed, skipping reconciliation")
 			return
 		}
 
@@ -105,9 +305,19 @@ func (e *Engine) Run(ival time.Duration, stop chan bool) {
 		}()
 
 		start := time.Now()
-		e.rec.Reconcile(e, abort)
+		if e.rec.Reconcile(e, abort) {
+			e.lastSync = start
+			e.leaseMu.Lock()
+			e.lastReconcile = time.Now()
+			e.leaseMu.Unlock()
+		} else {
+			// try again on the next pass
+			e.changes.mark()
+		}
 		close(monitor)
 		elapsed := time.Now().Sub(start)
+		statReconciles.Add(1)
+		statReconcileDuration.Observe(elapsed)
 
 		msg := fmt.Sprintf("Engine completed reconciliation in %s", elapsed)
 		if elapsed > ival {
@@ -117,18 +327,169 @@ func (e *Engine) Run(ival time.Duration, stop chan bool) {
 		}
 	}
 
-	rec := pkg.NewPeriodicReconciler(ival, reconcile, e.rStream)
+	rec := pkg.NewDebouncedPeriodicReconciler(ival, jitter, e.reconcileDebounce, reconcile, e.changes)
 	rec.Run(stop)
 }
 
-func (e *Engine) Purge() {
-	// only purge the lease if we are the leader
-	if !isLeader(e.lease, e.machine.State().ID) {
+// maintainLeadership renews or acquires the leases the local engine should
+// hold, and publishes the resulting status of the engine. Acquiring
+// leadership of any shard is treated as a change to the cluster, so the
+// next reconciliation rebuilds the cluster state.
+func (e *Engine) maintainLeadership(machID string, ttl time.Duration) {
+	if !ensureEngineVersionMatch(e.cRegistry, engineVersion) {
 		return
 	}
-	err := e.lease.Release()
-	if err != nil {
-		log.Errorf("Failed to release lease: %v", err)
+
+	e.leaseMu.Lock()
+	defer e.leaseMu.Unlock()
+	if e.resigned {
+		return
+	}
+	if e.updateLeadership(machID, ttl) {
+		e.changes.mark()
+	}
+	e.publishStatus(machID, ttl)
+}
+
+// needsReconcile determines whether the cluster state must be rebuilt and
+// reconciled. This is the case when any relevant change has been observed
+// in the Registry (including the engine having just been elected), while
+// Jobs are held on lost Machines or Units are awaited, when a cron Job is
+// due, or when the resync or rebalance interval has elapsed.
+func (e *Engine) needsReconcile() bool {
+	changed := e.changes.reset()
+	if changed || e.resyncInterval == 0 || e.rec.awaitingLostMachines() || e.awaitingUnits || e.rebalanceDue() {
+		return true
+	}
+	if !e.nextRun.IsZero() && !e.rec.clock.Now().Before(e.nextRun) {
+		return true
+	}
+	return time.Now().Sub(e.lastSync) >= e.resyncInterval
+}
+
+// changeTracker wraps an EventStream, remembering whether any Event has
+// been emitted since it was last reset, and which Events have been emitted
+// since they were last drained
+type changeTracker struct {
+	pkg.EventStream
+
+	mu      sync.Mutex
+	changed bool
+	events  map[pkg.Event]bool
+	// stale is set when changes may have been missed, so the events
+	// alone do not describe what has changed
+	stale bool
+	// resigned is set when another engine has resigned a lease, which
+	// is not a change to the cluster itself
+	resigned bool
+}
+
+func (ct *changeTracker) Next(stop chan struct{}) chan pkg.Event {
+	in := ct.EventStream.Next(stop)
+	out := make(chan pkg.Event)
+	go func() {
+		select {
+		case <-stop:
+		case ev := <-in:
+			ct.record(ev)
+			select {
+			case <-stop:
+			case out <- ev:
+			}
+		}
+	}()
+	return out
+}
+
+// mark records an unspecified change, after which the cluster state must
+// be read in full
+func (ct *changeTracker) mark() {
+	ct.mu.Lock()
+	ct.changed = true
+	ct.stale = true
+	ct.mu.Unlock()
+}
+
+func (ct *changeTracker) record(ev pkg.Event) {
+	ct.mu.Lock()
+	if ev == registry.EngineResignedEvent {
+		ct.resigned = true
+		ct.mu.Unlock()
+		return
+	}
+	ct.changed = true
+	if ct.events == nil {
+		ct.events = make(map[pkg.Event]bool)
+	}
+	ct.events[ev] = true
+	ct.mu.Unlock()
+}
+
+// drain returns the Events recorded since the last drain, or nil if the
+// cluster state must be read in full, and clears them
+func (ct *changeTracker) drain() map[pkg.Event]bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	events := ct.events
+	if ct.stale {
+		events = nil
+	} else if events == nil {
+		events = make(map[pkg.Event]bool)
+	}
+	ct.events = nil
+	ct.stale = false
+	return events
+}
+
+// takeResignation reports whether another engine has resigned a lease
+// since it was last called
+func (ct *changeTracker) takeResignation() bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	resigned := ct.resigned
+	ct.resigned = false
+	return resigned
+}
+
+// reset clears the tracked state, returning whether any change had been
+// observed
+func (ct *changeTracker) reset() bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	changed := ct.changed
+	ct.changed = false
+	return changed
+}
+
+// Purge resigns the leadership of the local engine. The leases it holds
+// are released and their resignation recorded, so that another engine
+// may take over immediately rather than once the leases would have
+// expired. The engine does not acquire any lease afterwards.
+func (e *Engine) Purge() {
+	// only purge the leases we hold
+	machID := e.machine.State().ID
+	e.leaseMu.Lock()
+	defer e.leaseMu.Unlock()
+	e.resigned = true
+	for i, l := range e.leases {
+		if !isLeader(l, machID) {
+			continue
+		}
+		err := l.Release()
+		if err != nil {
+			log.Errorf("Failed to release lease: %v", err)
+			continue
+		}
+		e.leases[i] = nil
+		if err := e.lRegistry.MarkResigned(shardLeaseName(i, len(e.leases)), machID); err != nil {
+			log.Errorf("Failed recording resignation of lease: %v", err)
+		}
+	}
+	if e.published {
+		if err := e.registry.RemoveEngineStatus(machID); err != nil {
+			log.Errorf("Failed removing engine status: %v", err)
+		}
+		e.published = false
 	}
 }
 
@@ -164,8 +525,8 @@ func ensureEngineVersionMatch(cReg registry.ClusterRegistry, expect int) bool {
 	return true
 }
 
-func acquireLeadership(lReg registry.LeaseRegistry, machID string, ver int, ttl time.Duration) registry.Lease {
-	existing, err := lReg.GetLease(engineLeaseName)
+func acquireLeadership(lReg registry.LeaseRegistry, name, machID string, ver int, ttl time.Duration) registry.Lease {
+	existing, err := lReg.GetLease(name)
 	if err != nil {
 		log.Errorf("Unable to determine current lessee: %v", err)
 		return nil
@@ -173,7 +534,7 @@ func acquireLeadership(lReg registry.LeaseRegistry, machID string, ver int, ttl
 
 	var l registry.Lease
 	if existing == nil {
-		l, err = lReg.AcquireLease(engineLeaseName, machID, ver, ttl)
+		l, err = lReg.AcquireLease(name, machID, ver, ttl)
 		if err != nil {
 			log.Errorf("Engine leadership acquisition failed: %v", err)
 			return nil
@@ -182,6 +543,7 @@ func acquireLeadership(lReg registry.LeaseRegistry, machID string, ver int, ttl
 			return nil
 		}
 		log.Infof("Engine leadership acquired")
+		statLeadershipAcquisitions.Add(1)
 		return l
 	}
 
@@ -191,7 +553,7 @@ func acquireLeadership(lReg registry.LeaseRegistry, machID string, ver int, ttl
 	}
 
 	rem := existing.TimeRemaining()
-	l, err = lReg.StealLease(engineLeaseName, machID, ver, ttl+rem, existing.Index())
+	l, err = lReg.StealLease(name, machID, ver, ttl+rem, existing.Index())
 	if err != nil {
 		log.Errorf("Engine leadership steal failed: %v", err)
 		return nil
@@ -201,6 +563,7 @@ func acquireLeadership(lReg registry.LeaseRegistry, machID string, ver int, ttl
 	}
 
 	log.Infof("Stole engine leadership from Machine(%s)", existing.MachineID())
+	statLeadershipAcquisitions.Add(1)
 
 	if rem > 0 {
 		log.Infof("Waiting %v for previous lease to expire before continuing reconciliation", rem)
@@ -225,38 +588,102 @@ func (e *Engine) Trigger() {
 	e.trigger <- struct{}{}
 }
 
-func (e *Engine) clusterState() (*clusterState, error) {
-	units, err := e.registry.Units()
-	if err != nil {
-		log.Errorf("Failed fetching Units from Registry: %v", err)
-		return nil, err
+// cacheTask applies a task carried out against the Registry to the cached
+// snapshot, so that the next reconciliation sees its effect even before
+// the resulting Event is observed
+func (e *Engine) cacheTask(t *task) {
+	e.cacheMu.Lock()
+	defer e.cacheMu.Unlock()
+	if e.cache == nil {
+		return
+	}
+	switch t.Type {
+	case taskTypeUnscheduleUnit:
+		e.cache.unschedule(t.JobName)
+	case taskTypeAttemptScheduleUnit, taskTypeRescheduleUnit:
+		e.cache.schedule(t.JobName, t.MachineID)
+	}
+}
+
+// snapshot returns the current state of the cluster. The snapshot of the
+// previous reconciliation is reused, rereading from the Registry only the
+// records that have changed since, unless the cluster state must be read
+// in full or the resync interval has elapsed since it last was.
+func (e *Engine) snapshot() (*snapshot, error) {
+	changed := e.changes.drain()
+	withStates := needsUnitStates(e.rec.sched)
+	now := e.rec.clock.Now()
+	if e.cache == nil || e.resyncInterval == 0 || now.Sub(e.lastRefresh) >= e.resyncInterval {
+		changed = nil
 	}
 
-	sUnits, err := e.registry.Schedule()
-	if err != nil {
-		log.Errorf("Failed fetching schedule from Registry: %v", err)
+	if changed == nil {
+		snap, err := fetchSnapshot(e.registry, withStates)
+		if err != nil {
+			e.cache = nil
+			return nil, err
+		}
+		e.cache = snap
+		e.lastRefresh = now
+		return snap, nil
+	}
+
+	if err := e.cache.refresh(e.registry, changed, withStates); err != nil {
+		e.cache = nil
 		return nil, err
 	}
+	return e.cache, nil
+}
 
-	machines, err := e.registry.Machines()
+// getClusterState reads the current state of the cluster from the given
+// Registry as seen by a scheduler using every scorer, limiting each
+// Machine to maxUnits Units
+func getClusterState(reg registry.Registry, maxUnits int) (*clusterState, error) {
+	snap, err := fetchSnapshot(reg, true)
 	if err != nil {
-		log.Errorf("Failed fetching Machines from Registry: %v", err)
 		return nil, err
 	}
-
-	return newClusterState(units, sUnits, machines), nil
+	return snap.clusterState(maxUnits), nil
 }
 
 func (e *Engine) unscheduleUnit(name, machID string) (err error) {
 	err = e.registry.UnscheduleUnit(name, machID)
 	if err != nil {
 		log.Errorf("Failed unscheduling Unit(%s) from Machine(%s): %v", name, machID, err)
+		e.checkScheduleConflict(err)
 	} else {
 		log.Infof("Unscheduled Job(%s) from Machine(%s)", name, machID)
 	}
 	return
 }
 
+// rescheduleUnit moves a Unit between Machines in a single write to the
+// Registry, so that a crash of the engine part way through cannot leave
+// the Unit unscheduled
+func (e *Engine) rescheduleUnit(name, from, to string) (err error) {
+	err = e.registry.RescheduleUnit(name, from, to)
+	if err != nil {
+		log.Errorf("Failed rescheduling Unit(%s) from Machine(%s) to Machine(%s): %v", name, from, to, err)
+		e.checkScheduleConflict(err)
+	} else {
+		log.Infof("Rescheduled Unit(%s) from Machine(%s) to Machine(%s)", name, from, to)
+	}
+	return
+}
+
+// checkScheduleConflict determines whether the schedule was changed behind
+// the back of the engine, which may happen while leadership changes hands,
+// in which case the cluster state is read in full on the next pass rather
+// than trusting the snapshot
+func (e *Engine) checkScheduleConflict(err error) {
+	if _, ok := err.(registry.ScheduleConflictError); !ok {
+		return
+	}
+	log.Warningf("Schedule changed by another engine, rereading cluster state")
+	statScheduleConflicts.Add(1)
+	e.changes.mark()
+}
+
 // attemptScheduleUnit tries to persist a scheduling decision in the
 // Registry, returning true on success. If any communication with the
 // Registry fails, false is returned.
@@ -264,6 +691,7 @@ func (e *Engine) attemptScheduleUnit(name, machID string) bool {
 	err := e.registry.ScheduleUnit(name, machID)
 	if err != nil {
 		log.Errorf("Failed scheduling Unit(%s) to Machine(%s): %v", name, machID, err)
+		e.checkScheduleConflict(err)
 		return false
 	}
 
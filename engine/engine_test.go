@@ -15,9 +15,12 @@
 package engine
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
 	"github.com/coreos/fleet/registry"
 )
 
@@ -111,7 +114,7 @@ func TestAcquireLeadership(t *testing.T) {
 			lReg.SetLease(engineLeaseName, tt.exist.machID, tt.exist.ver, time.Millisecond)
 		}
 
-		got := acquireLeadership(lReg, tt.local.machID, tt.local.ver, time.Millisecond)
+		got := acquireLeadership(lReg, engineLeaseName, tt.local.machID, tt.local.ver, time.Millisecond)
 
 		if tt.wantAcquire != (isLeader(got, tt.local.machID)) {
 			t.Errorf("case %d: wantAcquire=%t but got %#v", i, tt.wantAcquire, got)
@@ -162,3 +165,260 @@ func TestIsLeader(t *testing.T) {
 		}
 	}
 }
+
+func TestEngineNeedsReconcile(t *testing.T) {
+	tests := []struct {
+		changed  bool
+		lost     bool
+		awaiting bool
+		nextRun  time.Duration
+		resync   time.Duration
+		lastSync time.Duration
+		want     bool
+	}{
+		// nothing happened recently
+		{resync: time.Minute, lastSync: time.Second, want: false},
+		// observed changes trigger reconciliation
+		{changed: true, resync: time.Minute, lastSync: time.Second, want: true},
+		// Jobs held on lost Machines need regular reconciliation
+		{lost: true, resync: time.Minute, lastSync: time.Second, want: true},
+		// Units awaited by batch Jobs, cron runs or Rollouts as well
+		{awaiting: true, resync: time.Minute, lastSync: time.Second, want: true},
+		// a cron Job is due
+		{nextRun: -time.Second, resync: time.Minute, lastSync: time.Second, want: true},
+		{nextRun: time.Second, resync: time.Minute, lastSync: time.Second, want: false},
+		// resync interval elapsed
+		{resync: time.Minute, lastSync: 2 * time.Minute, want: true},
+		// resync disabled
+		{resync: 0, lastSync: time.Second, want: true},
+	}
+
+	for i, tt := range tests {
+		e := &Engine{
+			rec:            NewReconciler(0, 0),
+			resyncInterval: tt.resync,
+			lastSync:       time.Now().Add(-tt.lastSync),
+			changes:        &changeTracker{},
+			awaitingUnits:  tt.awaiting,
+		}
+		if tt.nextRun != 0 {
+			e.nextRun = e.rec.clock.Now().Add(tt.nextRun)
+		}
+		if tt.changed {
+			e.changes.mark()
+		}
+		if tt.lost {
+			e.rec.lostMachines["XXX"] = time.Now()
+		}
+
+		if got := e.needsReconcile(); got != tt.want {
+			t.Errorf("case %d: expected %t, got %t", i, tt.want, got)
+		}
+		if e.changes.reset() {
+			t.Errorf("case %d: tracked changes not reset", i)
+		}
+	}
+}
+
+func TestEngineMaintainLeadership(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	e := &Engine{
+		registry:  fr,
+		cRegistry: registry.NewFakeClusterRegistry(nil, engineVersion),
+		lRegistry: registry.NewFakeLeaseRegistry(),
+		leases:    make([]registry.Lease, 1),
+		changes:   &changeTracker{},
+	}
+
+	// acquiring leadership forces a reconciliation
+	e.maintainLeadership("XXX", time.Second)
+	if !isLeader(e.leases[0], "XXX") {
+		t.Fatalf("expected XXX to acquire leadership, got %#v", e.leases[0])
+	}
+	if !e.changes.reset() {
+		t.Errorf("acquiring leadership did not mark a change")
+	}
+
+	// merely renewing it does not
+	e.maintainLeadership("XXX", time.Second)
+	if !isLeader(e.leases[0], "XXX") {
+		t.Fatalf("expected XXX to retain leadership, got %#v", e.leases[0])
+	}
+	if e.changes.reset() {
+		t.Errorf("renewing leadership unexpectedly marked a change")
+	}
+
+	// the status of the engine is published along with its leases
+	e.lastReconcile = time.Unix(1000, 0)
+	e.maintainLeadership("XXX", time.Second)
+	statuses, _ := fr.EngineStatuses()
+	if len(statuses) != 1 {
+		t.Fatalf("expected 1 engine status, got %#v", statuses)
+	}
+	st := statuses[0]
+	if st.MachineID != "XXX" || !reflect.DeepEqual(st.Shards, []int{0}) || !st.LastReconcile.Equal(e.lastReconcile) || st.LeaseExpiry.IsZero() {
+		t.Errorf("unexpected engine status %#v", st)
+	}
+
+	// and removed once they are lost
+	e.leases[0].Release()
+	e.leases[0] = nil
+	e.lRegistry.AcquireLease(engineLeaseName, "YYY", engineVersion, time.Second)
+	e.maintainLeadership("XXX", time.Second)
+	if statuses, _ := fr.EngineStatuses(); len(statuses) != 0 {
+		t.Errorf("expected engine status to be removed, got %#v", statuses)
+	}
+}
+
+func TestEnginePurgeResigns(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	lReg := registry.NewFakeLeaseRegistry()
+	e := &Engine{
+		registry:  fr,
+		cRegistry: registry.NewFakeClusterRegistry(nil, engineVersion),
+		lRegistry: lReg,
+		machine:   &machine.FakeMachine{MachineState: machine.MachineState{ID: "XXX"}},
+		leases:    make([]registry.Lease, 1),
+		changes:   &changeTracker{},
+	}
+	e.maintainLeadership("XXX", time.Second)
+	if !isLeader(e.leases[0], "XXX") {
+		t.Fatalf("expected XXX to acquire leadership, got %#v", e.leases[0])
+	}
+
+	// the lease is released and its resignation recorded
+	e.Purge()
+	if l, _ := lReg.GetLease(engineLeaseName); l != nil {
+		t.Fatalf("expected lease to be released, got %#v", l)
+	}
+	if got := lReg.Resigned(engineLeaseName); got != "XXX" {
+		t.Fatalf("expected resignation by XXX to be recorded, got %q", got)
+	}
+	if statuses, _ := fr.EngineStatuses(); len(statuses) != 0 {
+		t.Errorf("expected engine status to be removed, got %#v", statuses)
+	}
+
+	// and not acquired again
+	e.maintainLeadership("XXX", time.Second)
+	if l, _ := lReg.GetLease(engineLeaseName); l != nil {
+		t.Fatalf("expected resigned engine not to acquire lease, got %#v", l)
+	}
+}
+
+func TestEngineKeepsLeases(t *testing.T) {
+	lReg := registry.NewFakeLeaseRegistry()
+	e := &Engine{
+		registry:     registry.NewFakeRegistry(),
+		cRegistry:    registry.NewFakeClusterRegistry(nil, engineVersion),
+		lRegistry:    lReg,
+		machine:      &machine.FakeMachine{MachineState: machine.MachineState{ID: "XXX"}},
+		leases:       make([]registry.Lease, 1),
+		changes:      &changeTracker{},
+		leaseRenewal: time.Hour,
+		leaseLost:    make(chan struct{}, 1),
+	}
+
+	// an acquired lease is renewed in the background
+	e.maintainLeadership("XXX", time.Second)
+	k, ok := e.leases[0].(*registry.LeaseKeeper)
+	if !ok || !isLeader(k, "XXX") {
+		t.Fatalf("expected XXX to hold a kept lease, got %#v", e.leases[0])
+	}
+	e.maintainLeadership("XXX", time.Second)
+	if e.leases[0] != k {
+		t.Fatalf("expected the kept lease to be held still, got %#v", e.leases[0])
+	}
+
+	// until it is released
+	e.Purge()
+	select {
+	case <-k.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the lease to no longer be renewed")
+	}
+	if l, _ := lReg.GetLease(engineLeaseName); l != nil {
+		t.Fatalf("expected lease to be released, got %#v", l)
+	}
+	select {
+	case <-e.leaseLost:
+		t.Fatalf("expected releasing the lease not to be reported as its loss")
+	default:
+	}
+}
+
+func TestChangeTrackerResignation(t *testing.T) {
+	ct := &changeTracker{}
+	ct.record(registry.EngineResignedEvent)
+	if ct.reset() {
+		t.Errorf("resignation unexpectedly recorded as a change to the cluster")
+	}
+	if !ct.takeResignation() {
+		t.Fatalf("expected resignation to be recorded")
+	}
+	if ct.takeResignation() {
+		t.Errorf("resignation not cleared once taken")
+	}
+}
+
+func TestValidateLeaseTimings(t *testing.T) {
+	tests := []struct {
+		ival, jitter, ttl, renew time.Duration
+		ok                       bool
+	}{
+		// defaults are valid
+		{2 * time.Second, 0, 0, 0, true},
+		{2 * time.Second, time.Second, 0, 0, true},
+		{2 * time.Second, 0, 10 * time.Second, 5 * time.Second, true},
+		// renewal must come before the lease expires
+		{2 * time.Second, 0, 10 * time.Second, 10 * time.Second, false},
+		// a short TTL is checked against the default renewal interval
+		{2 * time.Second, 0, time.Second, 0, false},
+		// a long renewal interval is checked against the default TTL
+		{2 * time.Second, 0, 0, 10 * time.Second, false},
+	}
+
+	for i, tt := range tests {
+		err := ValidateLeaseTimings(tt.ival, tt.jitter, tt.ttl, tt.renew)
+		if (err == nil) != tt.ok {
+			t.Errorf("case %d: expected valid %t, got err %v", i, tt.ok, err)
+		}
+	}
+}
+
+func TestEngineScheduleConflict(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	fr.SetJobs([]job.Job{{Name: "foo.service", TargetMachineID: "YYY"}})
+	e := &Engine{registry: fr, changes: &changeTracker{}}
+
+	// another engine having scheduled the Unit fails the attempt, and
+	// has the cluster state reread
+	if e.attemptScheduleUnit("foo.service", "XXX") {
+		t.Fatalf("expected scheduling of an already scheduled Unit to fail")
+	}
+	if !e.changes.reset() {
+		t.Errorf("schedule conflict did not mark a change")
+	}
+	if err := e.unscheduleUnit("foo.service", "XXX"); err == nil {
+		t.Fatalf("expected unscheduling from the wrong Machine to fail")
+	}
+	if !e.changes.reset() {
+		t.Errorf("schedule conflict did not mark a change")
+	}
+	if err := e.rescheduleUnit("foo.service", "XXX", "ZZZ"); err == nil {
+		t.Fatalf("expected rescheduling from the wrong Machine to fail")
+	}
+	if !e.changes.reset() {
+		t.Errorf("schedule conflict did not mark a change")
+	}
+	if su, _ := fr.ScheduledUnit("foo.service"); su == nil || su.TargetMachineID != "YYY" {
+		t.Fatalf("expected Unit to remain scheduled to YYY, got %#v", su)
+	}
+
+	// a Unit is moved in a single step, never left unscheduled
+	if err := e.rescheduleUnit("foo.service", "YYY", "ZZZ"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if su, _ := fr.ScheduledUnit("foo.service"); su == nil || su.TargetMachineID != "ZZZ" {
+		t.Fatalf("expected Unit to be scheduled to ZZZ, got %#v", su)
+	}
+}
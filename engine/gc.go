@@ -0,0 +1,66 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/registry"
+)
+
+// gcDue reports whether the garbage collection interval has elapsed since
+// the last pass. Garbage collection is disabled by a zero interval, and
+// is not supported by every Registry.
+func (e *Engine) gcDue() bool {
+	if _, ok := e.registry.(registry.GCRegistry); !ok {
+		return false
+	}
+	return e.gcInterval > 0 && e.rec.clock.Now().Sub(e.lastGC) >= e.gcInterval
+}
+
+// collectGarbage removes the orphaned state of the Registry. Orphans are
+// only removed once found unchanged by two consecutive passes, so that
+// state written just ahead of whatever is to refer to it, such as the
+// unit file of a Job being created, is left alone.
+func (e *Engine) collectGarbage() {
+	e.lastGC = e.rec.clock.Now()
+	gcReg := e.registry.(registry.GCRegistry)
+
+	orphans, err := gcReg.Orphans()
+	if err != nil {
+		log.Errorf("Failed finding orphaned Registry state: %v", err)
+		return
+	}
+
+	candidates := make(map[registry.Orphan]bool, len(orphans))
+	var removed int
+	for _, o := range orphans {
+		if !e.gcCandidates[o] {
+			candidates[o] = true
+			continue
+		}
+		if err := gcReg.RemoveOrphan(o); err != nil {
+			log.Errorf("Failed removing orphaned %s of %s at %s: %v", o.Kind, o.Name, o.Key, err)
+			continue
+		}
+		log.Infof("Removed orphaned %s of %s at %s", o.Kind, o.Name, o.Key)
+		removed++
+	}
+	e.gcCandidates = candidates
+
+	statOrphansRemoved.Add(int64(removed))
+	if removed > 0 || len(candidates) > 0 {
+		log.Infof("Garbage collection removed %d orphaned key(s) from the Registry, %d more to be removed if still orphaned on the next pass", removed, len(candidates))
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/registry"
+)
+
+// gcRegistry reports a fixed set of Orphans, recording those removed
+type gcRegistry struct {
+	*registry.FakeRegistry
+	orphans []registry.Orphan
+	removed []registry.Orphan
+}
+
+func (g *gcRegistry) Orphans() ([]registry.Orphan, error) {
+	return g.orphans, nil
+}
+
+func (g *gcRegistry) RemoveOrphan(o registry.Orphan) error {
+	g.removed = append(g.removed, o)
+	return nil
+}
+
+func TestEngineCollectGarbage(t *testing.T) {
+	state := registry.Orphan{Kind: registry.OrphanUnitState, Name: "foo.service", Key: "/fleet/states/foo.service/XXX", Index: 4}
+	file := registry.Orphan{Kind: registry.OrphanUnitFile, Name: "abc", Key: "/fleet/unit/abc", Index: 7}
+	reg := &gcRegistry{FakeRegistry: registry.NewFakeRegistry(), orphans: []registry.Orphan{state, file}}
+
+	fclock := clockwork.NewFakeClock()
+	e := &Engine{registry: reg, rec: NewReconciler(0, 0), gcInterval: time.Minute}
+	e.rec.clock = fclock
+	fclock.Advance(time.Minute)
+
+	if !e.gcDue() {
+		t.Fatalf("garbage collection not due before the first pass")
+	}
+
+	// orphans are only removed once found by a second pass
+	e.collectGarbage()
+	if len(reg.removed) != 0 {
+		t.Fatalf("expected nothing removed by the first pass, got %v", reg.removed)
+	}
+	if e.gcDue() {
+		t.Fatalf("garbage collection due before the interval elapsed")
+	}
+
+	// and only if unchanged since
+	fclock.Advance(time.Minute)
+	if !e.gcDue() {
+		t.Fatalf("garbage collection not due after the interval elapsed")
+	}
+	changed := file
+	changed.Index = 9
+	reg.orphans = []registry.Orphan{state, changed}
+	e.collectGarbage()
+	if want := []registry.Orphan{state}; !reflect.DeepEqual(want, reg.removed) {
+		t.Fatalf("expected %v removed, got %v", want, reg.removed)
+	}
+
+	reg.orphans = []registry.Orphan{changed}
+	e.collectGarbage()
+	if want := []registry.Orphan{state, changed}; !reflect.DeepEqual(want, reg.removed) {
+		t.Fatalf("expected %v removed, got %v", want, reg.removed)
+	}
+}
+
+func TestEngineGCUnsupported(t *testing.T) {
+	e := &Engine{registry: registry.NewFakeRegistry(), rec: NewReconciler(0, 0), gcInterval: time.Minute}
+	if e.gcDue() {
+		t.Fatalf("garbage collection due for a Registry not supporting it")
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+)
+
+// reconcileInstances spreads the instances of each template Unit with an
+// Instances option owned by the local engine across the Machines able to
+// run the template, recording a single InstanceSchedule per template
+// rather than scheduling each instance as a Job of its own. The agent of
+// each Machine names and runs the instances assigned to it.
+func (e *Engine) reconcileInstances(snap *snapshot, clust *clusterState) {
+	for _, u := range snap.units {
+		n, ok := u.Instances()
+		if !ok {
+			continue
+		}
+		j := job.Job{Name: u.Name, Unit: u.Unit, TargetState: u.TargetState}
+		if !e.rec.owned(&j) {
+			continue
+		}
+
+		cur, err := e.registry.UnitInstances(u.Name)
+		if err != nil {
+			log.Errorf("Failed fetching instances of Unit(%s): %v", u.Name, err)
+			continue
+		}
+
+		var eligible []string
+		if u.TargetState != job.JobStateInactive {
+			for machID, as := range clust.agents() {
+				if able, _ := as.AbleToRun(&j); able {
+					eligible = append(eligible, machID)
+				}
+			}
+		}
+
+		plan := planInstances(n, cur, eligible)
+		if reflect.DeepEqual(plan, cur) || (plan.Count() == 0 && cur.Count() == 0) {
+			continue
+		}
+		if err := e.registry.ScheduleInstances(u.Name, plan); err != nil {
+			log.Errorf("Failed scheduling instances of Unit(%s): %v", u.Name, err)
+			continue
+		}
+		log.Infof("Scheduled %d instances of Unit(%s) across %d Machines", plan.Count(), u.Name, len(plan))
+	}
+}
+
+// planInstances assigns the instances numbered 1 to n to the eligible
+// Machines, as evenly as possible. Instances already assigned to an
+// eligible Machine stay there unless it holds more than its share, so
+// scaling or losing a Machine only moves the instances it must.
+func planInstances(n int, cur job.InstanceSchedule, eligible []string) job.InstanceSchedule {
+	plan := make(job.InstanceSchedule)
+	if n < 1 || len(eligible) == 0 {
+		return plan
+	}
+	sort.Strings(eligible)
+
+	// keep the instances still wanted on the Machines still eligible
+	taken := make(map[int]bool)
+	for _, machID := range eligible {
+		for _, num := range cur[machID] {
+			if num <= n && !taken[num] {
+				taken[num] = true
+				plan[machID] = append(plan[machID], num)
+			}
+		}
+	}
+
+	// each Machine gets base instances, and those holding the most get
+	// one more until the remainder is used up
+	base, extra := n/len(eligible), n%len(eligible)
+	byLoad := instancesHeld{machIDs: append([]string(nil), eligible...), plan: plan}
+	sort.Stable(byLoad)
+	share := make(map[string]int, len(eligible))
+	for i, machID := range byLoad.machIDs {
+		share[machID] = base
+		if i < extra {
+			share[machID]++
+		}
+	}
+
+	for _, machID := range eligible {
+		if nums := plan[machID]; len(nums) > share[machID] {
+			sort.Ints(nums)
+			for _, num := range nums[share[machID]:] {
+				delete(taken, num)
+			}
+			plan[machID] = nums[:share[machID]]
+		}
+	}
+
+	next := 1
+	for _, machID := range eligible {
+		for len(plan[machID]) < share[machID] {
+			for taken[next] {
+				next++
+			}
+			taken[next] = true
+			plan[machID] = append(plan[machID], next)
+		}
+	}
+
+	for machID, nums := range plan {
+		if len(nums) == 0 {
+			delete(plan, machID)
+			continue
+		}
+		sort.Ints(nums)
+	}
+	return plan
+}
+
+// instancesHeld orders Machines by the number of instances they hold in a
+// plan, most first
+type instancesHeld struct {
+	machIDs []string
+	plan    job.InstanceSchedule
+}
+
+func (h instancesHeld) Len() int      { return len(h.machIDs) }
+func (h instancesHeld) Swap(i, j int) { h.machIDs[i], h.machIDs[j] = h.machIDs[j], h.machIDs[i] }
+func (h instancesHeld) Less(i, j int) bool {
+	return len(h.plan[h.machIDs[i]]) > len(h.plan[h.machIDs[j]])
+}
@@ -0,0 +1,66 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/fleet/job"
+)
+
+func TestPlanInstances(t *testing.T) {
+	tests := []struct {
+		n        int
+		cur      job.InstanceSchedule
+		eligible []string
+		want     job.InstanceSchedule
+	}{
+		// nowhere to run
+		{3, nil, nil, job.InstanceSchedule{}},
+		// spread evenly from scratch
+		{5, nil, []string{"XXX", "YYY"}, job.InstanceSchedule{
+			"XXX": []int{1, 2, 3},
+			"YYY": []int{4, 5},
+		}},
+		// scaling up keeps the existing instances in place
+		{6, job.InstanceSchedule{"XXX": []int{1, 2}, "YYY": []int{3, 4}}, []string{"XXX", "YYY"}, job.InstanceSchedule{
+			"XXX": []int{1, 2, 5},
+			"YYY": []int{3, 4, 6},
+		}},
+		// scaling down drops the highest instances
+		{2, job.InstanceSchedule{"XXX": []int{1, 3}, "YYY": []int{2, 4}}, []string{"XXX", "YYY"}, job.InstanceSchedule{
+			"XXX": []int{1},
+			"YYY": []int{2},
+		}},
+		// the instances of a lost Machine move to the others
+		{4, job.InstanceSchedule{"XXX": []int{1, 2}, "YYY": []int{3, 4}}, []string{"XXX", "ZZZ"}, job.InstanceSchedule{
+			"XXX": []int{1, 2},
+			"ZZZ": []int{3, 4},
+		}},
+		// a new Machine only takes what it must
+		{4, job.InstanceSchedule{"XXX": []int{1, 2, 3, 4}}, []string{"XXX", "YYY"}, job.InstanceSchedule{
+			"XXX": []int{1, 2},
+			"YYY": []int{3, 4},
+		}},
+	}
+
+	for i, tt := range tests {
+		got := planInstances(tt.n, tt.cur, tt.eligible)
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("case %d: expected %v, got %v", i, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/coreos/fleet/agent"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+)
+
+// rebalanceDue reports whether the rebalance interval has elapsed since the
+// last rebalancing pass. Rebalancing is disabled by a zero interval.
+func (e *Engine) rebalanceDue() bool {
+	return e.rebalanceInterval > 0 && e.rec.clock.Now().Sub(e.lastRebalance) >= e.rebalanceInterval
+}
+
+// rebalance migrates a bounded number of movable Jobs from the most loaded
+// to the least loaded parts of the given cluster
+func (e *Engine) rebalance(clust *clusterState) {
+	e.lastRebalance = e.rec.clock.Now()
+	if e.rec.awaitingLostMachines() {
+		log.Debugf("Jobs held on lost Machines, deferring rebalancing")
+		return
+	}
+
+	for _, t := range e.rec.calculateRebalanceTasks(clust) {
+		if err := doTask(t, e); err != nil {
+			log.Errorf("Failed resolving task: task=%s err=%v", t, err)
+			return
+		}
+	}
+}
+
+// calculateRebalanceTasks returns the tasks necessary to move up to
+// rebalanceMoves Jobs, rescheduling each from its current Machine to its
+// new one. The moves are applied to the given clusterState.
+func (r *Reconciler) calculateRebalanceTasks(clust *clusterState) []*task {
+	var tasks []*task
+	for moves := 0; moves < r.rebalanceMoves; moves++ {
+		j, machID := r.nextMove(clust)
+		if j == nil {
+			break
+		}
+
+		reason := fmt.Sprintf("rebalancing from Machine(%s) to Machine(%s)", j.TargetMachineID, machID)
+		tasks = append(tasks, &task{
+			Type:          taskTypeRescheduleUnit,
+			Reason:        reason,
+			JobName:       j.Name,
+			MachineID:     machID,
+			FromMachineID: j.TargetMachineID,
+		})
+		clust.schedule(j.Name, machID)
+	}
+	return tasks
+}
+
+// nextMove finds a single movable Job whose migration reduces the imbalance
+// of the cluster, returning it along with the Machine it should be moved to.
+// Buckets are considered from the most to the least loaded, and a Job is
+// only moved if the destination does not end up more loaded than the source
+// and the Scheduler rates the destination no worse than the source.
+func (r *Reconciler) nextMove(clust *clusterState) (*job.Job, string) {
+	agents := clust.agents()
+	buckets := loadBuckets(agents, r.rebalanceBy)
+	pinned := clust.peerTargets()
+
+	for i := len(buckets) - 1; i > 0; i-- {
+		src := buckets[i]
+		for _, dst := range buckets[:i] {
+			if !src.relieves(dst) {
+				continue
+			}
+
+			for k := len(src.agents) - 1; k >= 0; k-- {
+				for _, j := range r.movableJobs(clust, src.agents[k], pinned) {
+					for _, as := range dst.agents {
+						if able, _ := as.AbleToRun(j); able && r.scoresNoWorse(clust, j, src.agents[k], as) {
+							return j, as.MState.ID
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return nil, ""
+}
+
+// scoresNoWorse reports whether the Scheduler rates the agent dst no worse
+// for the Job than the agent src it currently runs on. The source is rated
+// as if the Job were not yet scheduled to it, as the destination is.
+func (r *Reconciler) scoresNoWorse(clust *clusterState, j *job.Job, src, dst *agent.AgentState) bool {
+	ss, ok := r.sched.(*scoringScheduler)
+	if !ok {
+		return true
+	}
+
+	without := *src
+	without.Units = make(map[string]*job.Unit, len(src.Units))
+	for name, u := range src.Units {
+		if name != j.Name {
+			without.Units[name] = u
+		}
+	}
+	return ss.score(clust, j, dst) >= ss.score(clust, j, &without)
+}
+
+// movableJobs returns the Jobs scheduled to the given agent that the local
+// engine may move elsewhere, ordered by name. Jobs bound to a Machine or to
+// other Jobs, members of groups, spread instances and batch Jobs stay put.
+func (r *Reconciler) movableJobs(clust *clusterState, as *agent.AgentState, pinned map[string]bool) []*job.Job {
+	var names sort.StringSlice
+	for name := range as.Units {
+		j, ok := clust.jobs[name]
+		if !ok || !r.owned(j) || pinned[name] {
+			continue
+		}
+		if _, ok := j.RequiredTarget(); ok || len(j.Peers()) > 0 || j.IsBatch() {
+			continue
+		}
+		if _, ok := j.Group(); ok {
+			continue
+		}
+		if _, ok := j.SpreadKey(); ok {
+			continue
+		}
+		names = append(names, name)
+	}
+	names.Sort()
+
+	jobs := make([]*job.Job, len(names))
+	for i, name := range names {
+		jobs[i] = clust.jobs[name]
+	}
+	return jobs
+}
+
+// peerTargets returns the names of all Jobs that some other Job must share
+// a Machine with
+func (cs *clusterState) peerTargets() map[string]bool {
+	pinned := make(map[string]bool)
+	for _, j := range cs.jobs {
+		for _, peer := range j.Peers() {
+			pinned[peer] = true
+		}
+	}
+	return pinned
+}
+
+// loadBucket is a set of agents whose load is balanced against other
+// buckets as a whole: either a single Machine, or all Machines sharing a
+// value of the rebalancing metadata key
+type loadBucket struct {
+	name   string
+	agents []*agent.AgentState
+	units  int
+}
+
+// relieves reports whether moving a single unit from lb to other would
+// leave other no more loaded, per Machine, than lb
+func (lb *loadBucket) relieves(other *loadBucket) bool {
+	return (other.units+1)*len(lb.agents) <= (lb.units-1)*len(other.agents)
+}
+
+// loadBuckets divides the given agents into buckets, ordered ascending by
+// the average number of units per Machine. If key is empty, each agent forms
+// its own bucket; otherwise agents lacking the key are left out. Within a
+// bucket, agents are ordered ascending by their number of units.
+func loadBuckets(agents map[string]*agent.AgentState, key string) []*loadBucket {
+	bMap := make(map[string]*loadBucket)
+	for id, as := range agents {
+		name := id
+		if key != "" {
+			var ok bool
+			if name, ok = as.MState.Metadata[key]; !ok {
+				continue
+			}
+		}
+
+		lb, ok := bMap[name]
+		if !ok {
+			lb = &loadBucket{name: name}
+			bMap[name] = lb
+		}
+		lb.agents = append(lb.agents, as)
+		lb.units += len(as.Units)
+	}
+
+	buckets := make([]*loadBucket, 0, len(bMap))
+	for _, lb := range bMap {
+		sort.Sort(sortableAgentStates(lb.agents))
+		buckets = append(buckets, lb)
+	}
+	sort.Sort(bucketsByLoad(buckets))
+	return buckets
+}
+
+type bucketsByLoad []*loadBucket
+
+func (bl bucketsByLoad) Len() int      { return len(bl) }
+func (bl bucketsByLoad) Swap(i, j int) { bl[i], bl[j] = bl[j], bl[i] }
+
+func (bl bucketsByLoad) Less(i, j int) bool {
+	li, lj := bl[i].units*len(bl[j].agents), bl[j].units*len(bl[i].agents)
+	if li != lj {
+		return li < lj
+	}
+	return bl[i].name < bl[j].name
+}
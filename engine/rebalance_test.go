@@ -0,0 +1,199 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestCalculateRebalanceTasks(t *testing.T) {
+	machines := []machine.MachineState{
+		machine.MachineState{ID: "XXX", Metadata: map[string]string{"region": "east"}},
+		machine.MachineState{ID: "YYY", Metadata: map[string]string{"region": "east"}},
+		machine.MachineState{ID: "ZZZ", Metadata: map[string]string{"region": "west"}},
+	}
+
+	tests := []struct {
+		// unit name -> current target
+		units map[string]string
+		by    string
+		moves int
+		// expected moves, as unit name -> new target
+		want map[string]string
+	}{
+		// already balanced
+		{
+			units: map[string]string{"a.service": "XXX", "b.service": "YYY", "c.service": "ZZZ"},
+			moves: 5,
+			want:  map[string]string{},
+		},
+		// a difference of a single unit is not worth a move
+		{
+			units: map[string]string{"a.service": "XXX", "b.service": "XXX", "c.service": "YYY", "d.service": "ZZZ"},
+			moves: 5,
+			want:  map[string]string{},
+		},
+		// the number of moves is bounded
+		{
+			units: map[string]string{"a.service": "XXX", "b.service": "XXX", "c.service": "XXX", "d.service": "XXX"},
+			moves: 1,
+			want:  map[string]string{"a.service": "YYY"},
+		},
+		// units move from the most to the least loaded machines
+		{
+			units: map[string]string{"a.service": "XXX", "b.service": "XXX", "c.service": "XXX", "d.service": "XXX", "e.service": "XXX", "f.service": "YYY", "g.service": "YYY"},
+			moves: 5,
+			want:  map[string]string{"a.service": "ZZZ", "b.service": "ZZZ"},
+		},
+		// buckets are balanced by their average load
+		{
+			units: map[string]string{"a.service": "XXX", "b.service": "XXX", "c.service": "YYY", "d.service": "YYY"},
+			by:    "region",
+			moves: 5,
+			want:  map[string]string{"c.service": "ZZZ"},
+		},
+	}
+
+	for i, tt := range tests {
+		var units []job.Unit
+		var sUnits []job.ScheduledUnit
+		for name, target := range tt.units {
+			units = append(units, job.Unit{Name: name, Unit: unit.UnitFile{}, TargetState: job.JobStateLaunched})
+			sUnits = append(sUnits, job.ScheduledUnit{Name: name, TargetMachineID: target})
+		}
+		clust := newClusterState(units, sUnits, machines)
+
+		r := NewReconciler(0, 0)
+		r.rebalanceBy = tt.by
+		r.rebalanceMoves = tt.moves
+
+		got := make(map[string]string)
+		for _, tsk := range r.calculateRebalanceTasks(clust) {
+			if tsk.Type == taskTypeRescheduleUnit {
+				got[tsk.JobName] = tsk.MachineID
+			}
+		}
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("case %d: expected moves %v, got %v", i, tt.want, got)
+		}
+	}
+}
+
+func TestCalculateRebalanceTasksImmovable(t *testing.T) {
+	contents := map[string]string{
+		"pinned.service": "[X-Fleet]\nMachineID=XXX",
+		"peer.service":   "[X-Fleet]\nMachineOf=bound.service",
+		"bound.service":  "",
+		"group.service":  "[X-Fleet]\nGroup=foo",
+		"batch.service":  "[X-Fleet]\nBatch=true",
+	}
+
+	var units []job.Unit
+	var sUnits []job.ScheduledUnit
+	for name, c := range contents {
+		uf := newUnitFile(t, c)
+		units = append(units, job.Unit{Name: name, Unit: uf, TargetState: job.JobStateLaunched})
+		sUnits = append(sUnits, job.ScheduledUnit{Name: name, TargetMachineID: "XXX"})
+	}
+	machines := []machine.MachineState{machine.MachineState{ID: "XXX"}, machine.MachineState{ID: "YYY"}}
+	clust := newClusterState(units, sUnits, machines)
+
+	r := NewReconciler(0, 0)
+	r.rebalanceMoves = 5
+	if tasks := r.calculateRebalanceTasks(clust); len(tasks) != 0 {
+		t.Fatalf("expected no units to be moved, got %v", tasks)
+	}
+
+	// units not owned by the local engine stay put as well
+	units = append(units, job.Unit{Name: "free.service", Unit: unit.UnitFile{}, TargetState: job.JobStateLaunched})
+	sUnits = append(sUnits, job.ScheduledUnit{Name: "free.service", TargetMachineID: "XXX"})
+	clust = newClusterState(units, sUnits, machines)
+	r.owns = func(j *job.Job) bool { return false }
+	if tasks := r.calculateRebalanceTasks(clust); len(tasks) != 0 {
+		t.Fatalf("expected no units to be moved, got %v", tasks)
+	}
+
+	r.owns = nil
+	tasks := r.calculateRebalanceTasks(clust)
+	want := []*task{
+		&task{Type: taskTypeRescheduleUnit, JobName: "free.service", MachineID: "YYY", FromMachineID: "XXX", Reason: "rebalancing from Machine(XXX) to Machine(YYY)"},
+	}
+	if !reflect.DeepEqual(want, tasks) {
+		t.Fatalf("expected tasks %v, got %v", want, tasks)
+	}
+}
+
+func TestCalculateRebalanceTasksScores(t *testing.T) {
+	machines := []machine.MachineState{
+		machine.MachineState{ID: "XXX", Metadata: map[string]string{"region": "east"}},
+		machine.MachineState{ID: "YYY", Metadata: map[string]string{"region": "west"}},
+	}
+
+	var units []job.Unit
+	var sUnits []job.ScheduledUnit
+	for _, name := range []string{"a.service", "b.service", "c.service", "d.service"} {
+		uf := newUnitFile(t, "[X-Fleet]\nPreferredMachineMetadata=region=east")
+		units = append(units, job.Unit{Name: name, Unit: uf, TargetState: job.JobStateLaunched})
+		sUnits = append(sUnits, job.ScheduledUnit{Name: name, TargetMachineID: "XXX"})
+	}
+
+	tests := []struct {
+		weights map[string]float64
+		want    int
+	}{
+		// the destination is less loaded, which outweighs the preference
+		{map[string]float64{"load": 1}, 2},
+		// the preference outweighs the load
+		{map[string]float64{"load": 1, "metadata": 2}, 0},
+	}
+
+	for i, tt := range tests {
+		clust := newClusterState(units, sUnits, machines)
+		r := NewReconciler(0, 0)
+		r.sched = newScoringScheduler(tt.weights)
+		r.rebalanceMoves = 5
+		if got := len(r.calculateRebalanceTasks(clust)); got != tt.want {
+			t.Errorf("case %d: expected %d tasks, got %d", i, tt.want, got)
+		}
+	}
+}
+
+func TestEngineRebalanceDue(t *testing.T) {
+	fclock := clockwork.NewFakeClock()
+	e := &Engine{rec: NewReconciler(0, 0), rebalanceInterval: time.Minute}
+	e.rec.clock = fclock
+	e.lastRebalance = fclock.Now()
+
+	if e.rebalanceDue() {
+		t.Fatalf("rebalance due before the interval elapsed")
+	}
+	fclock.Advance(time.Minute)
+	if !e.rebalanceDue() {
+		t.Fatalf("rebalance not due after the interval elapsed")
+	}
+
+	e.rebalance(newClusterState(nil, nil, nil))
+	if !e.lastRebalance.Equal(fclock.Now()) {
+		t.Fatalf("expected last rebalance at %v, got %v", fclock.Now(), e.lastRebalance)
+	}
+}
@@ -16,14 +16,26 @@ package engine
 
 import (
 	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
 
 	"github.com/coreos/fleet/job"
 	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/pkg"
 )
 
 const (
 	taskTypeUnscheduleUnit      = "UnscheduleUnit"
 	taskTypeAttemptScheduleUnit = "AttemptScheduleUnit"
+	taskTypeRescheduleUnit      = "RescheduleUnit"
+
+	// taskQueueLength is the number of tasks that may be queued for each
+	// reconcile worker, so that one slow worker does not hold up the rest
+	taskQueueLength = 16
 )
 
 type task struct {
@@ -31,60 +43,233 @@ type task struct {
 	Reason    string
 	JobName   string
 	MachineID string
+
+	// Candidates holds the verdict of the scheduler on each Machine it
+	// considered for an AttemptScheduleUnit task, if explained
+	Candidates []job.Candidate
+
+	// Group names the group of Jobs the task schedules a member of. The
+	// tasks of a group are always delivered consecutively.
+	Group string
+
+	// MemberOf names the group the Job belongs to for tasks that do not
+	// schedule it as part of its group, such as unscheduling a member,
+	// so that they are carried out by the same worker as the group.
+	MemberOf string
+
+	// FromMachineID is the Machine a RescheduleUnit task moves the Job
+	// away from, to MachineID
+	FromMachineID string
 }
 
 func (t *task) String() string {
 	return fmt.Sprintf("{Type: %s, JobName: %s, MachineID: %s, Reason: %q}", t.Type, t.JobName, t.MachineID, t.Reason)
 }
 
-func NewReconciler() *Reconciler {
+func NewReconciler(rescheduleGrace time.Duration, maxSchedule int) *Reconciler {
 	return &Reconciler{
-		sched: &leastLoadedScheduler{},
+		sched:           &scoringScheduler{},
+		clock:           clockwork.NewRealClock(),
+		rescheduleGrace: rescheduleGrace,
+		lostMachines:    make(map[string]time.Time),
+		maxSchedule:     maxSchedule,
+		pendingSince:    make(map[string]time.Time),
+
+		unschedulable:        make(map[string]*unschedulableJob),
+		unschedulableChanged: make(map[string]bool),
 	}
 }
 
 type Reconciler struct {
 	sched Scheduler
+	clock clockwork.Clock
+
+	// rescheduleGrace is the cluster-wide amount of time to wait after
+	// a machine disappears before rescheduling its units elsewhere
+	rescheduleGrace time.Duration
+
+	// lostMachines tracks when each machine targeted by a Job was first
+	// noticed to be missing from the cluster
+	lostMachines map[string]time.Time
+
+	// maxSchedule caps the number of scheduling decisions made in a single
+	// reconciliation. A value of zero means no limit.
+	maxSchedule int
+
+	// pendingSince tracks when each unscheduled Job was first noticed, so
+	// that the longest-waiting Jobs are scheduled first
+	pendingSince map[string]time.Time
+
+	// unschedulable tracks the Jobs that could not be scheduled, so that
+	// attempts to schedule them back off. unschedulableChanged holds the
+	// names of those whose record must be saved to the Registry.
+	unschedulable        map[string]*unschedulableJob
+	unschedulableChanged map[string]bool
+
+	// knownMachines holds the IDs of the Machines in the cluster as of the
+	// previous reconciliation, so that Jobs backing off can be retried as
+	// soon as a Machine joins
+	knownMachines map[string]bool
+
+	// rebalanceBy is the Machine metadata key by which the load of the
+	// cluster is balanced. If empty, each Machine is balanced individually.
+	rebalanceBy string
+
+	// rebalanceMoves caps the number of Jobs moved in a single
+	// rebalancing pass
+	rebalanceMoves int
+
+	// explain determines whether the scheduler's verdict on each candidate
+	// Machine is attached to scheduling tasks
+	explain bool
+
+	// owns reports whether the local engine is responsible for scheduling
+	// the given Job. If nil, the engine is responsible for all Jobs.
+	owns func(*job.Job) bool
 }
 
-func (r *Reconciler) Reconcile(e *Engine, stop chan struct{}) {
+// Reconcile fetches the current state of the cluster, advances any
+// Rollouts, batch Jobs, cron Jobs and replacements, spreads the instances
+// of scaled templates, and resolves any tasks necessary to converge it.
+// It returns false if the cluster state could not be determined.
+func (r *Reconciler) Reconcile(e *Engine, stop chan struct{}) bool {
 	log.Debugf("Polling Registry for actionable work")
 
-	clust, err := e.clusterState()
+	snap, err := e.snapshot()
 	if err != nil {
 		log.Errorf("Failed getting current cluster state: %v", err)
+		statReconcileFailures.Add(1)
+		return false
+	}
+
+	rolling := e.reconcileRollouts(snap)
+	batch := e.reconcileBatchJobs(snap)
+	cron, next := e.reconcileCronJobs(snap)
+	replacing := e.reconcileReplacements(snap)
+	e.nextRun = next
+
+	clust := snap.clusterState(e.maxUnits)
+	e.reconcileInstances(snap, clust)
+	e.awaitingUnits = rolling || batch || cron || replacing || len(clust.waiting) > 0
+	resolveTasks(e, r.calculateClusterTasks(clust, stop))
+	e.saveUnschedulable()
+
+	if e.rebalanceDue() {
+		e.rebalance(clust)
+	}
+
+	return true
+}
+
+// resolveTasks carries out the received tasks, spreading them across the
+// reconcile workers of the engine. Tasks are assigned to workers by Job, or
+// by group for every task of a member of a group, so the tasks of each are
+// still carried out in the order they were received.
+func resolveTasks(e *Engine, taskchan chan *task) {
+	workers := e.reconcileWorkers
+	if workers <= 1 {
+		resolveTaskQueue(e, taskchan)
 		return
 	}
 
-	for t := range r.calculateClusterTasks(clust, stop) {
-		err = doTask(t, e)
+	var wg sync.WaitGroup
+	queues := make([]chan *task, workers)
+	for i := range queues {
+		queues[i] = make(chan *task, taskQueueLength)
+		wg.Add(1)
+		go func(q chan *task) {
+			defer wg.Done()
+			resolveTaskQueue(e, q)
+		}(queues[i])
+	}
+
+	for t := range taskchan {
+		queues[taskWorker(t, workers)] <- t
+	}
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+}
+
+// taskWorker returns which of the given number of workers must carry out
+// the task
+func taskWorker(t *task, workers int) int {
+	key := t.JobName
+	if t.Group != "" {
+		key = t.Group
+	} else if t.MemberOf != "" {
+		key = t.MemberOf
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(workers))
+}
+
+// resolveTaskQueue carries out each received task in turn. If any member
+// of a group fails to be scheduled, the members already scheduled are
+// unscheduled again and the remaining members are skipped.
+func resolveTaskQueue(e *Engine, taskchan chan *task) {
+	// placed holds the tasks of the group currently being scheduled
+	var placed []*task
+	failed := pkg.NewUnsafeSet()
+	for t := range taskchan {
+		if t.Group != "" && failed.Contains(t.Group) {
+			log.Debugf("Skipping task of failed group %q: %s", t.Group, t)
+			continue
+		}
+
+		err := doTask(t, e)
 		if err != nil {
 			log.Errorf("Failed resolving task: task=%s err=%v", t, err)
+			if t.Group != "" {
+				failed.Add(t.Group)
+				rollbackGroup(e, t.Group, placed)
+			}
+			continue
+		}
+
+		if t.Group == "" {
+			continue
+		}
+		if len(placed) > 0 && placed[0].Group != t.Group {
+			placed = nil
 		}
+		placed = append(placed, t)
 	}
 }
 
 func (r *Reconciler) calculateClusterTasks(clust *clusterState, stopchan chan struct{}) (taskchan chan *task) {
 	taskchan = make(chan *task)
 
-	send := func(typ, reason, jName, machID string) bool {
+	sendTask := func(t *task) bool {
 		select {
 		case <-stopchan:
 			return false
 		default:
 		}
 
-		taskchan <- &task{Type: typ, Reason: reason, JobName: jName, MachineID: machID}
+		if t.Group == "" {
+			if j := clust.jobs[t.JobName]; j != nil {
+				t.MemberOf, _ = j.Group()
+			}
+		}
+		taskchan <- t
 		return true
 	}
+	send := func(typ, reason, jName, machID string) bool {
+		return sendTask(&task{Type: typ, Reason: reason, JobName: jName, MachineID: machID})
+	}
 
 	go func() {
 		defer close(taskchan)
 
 		agents := clust.agents()
+		r.trackLostMachines(clust)
 
 		for _, j := range clust.jobs {
-			if !j.Scheduled() {
+			if !j.Scheduled() || !r.owned(j) {
 				continue
 			}
 
@@ -97,6 +282,10 @@ func (r *Reconciler) calculateClusterTasks(clust *clusterState, stopchan chan st
 
 				as, ok := agents[j.TargetMachineID]
 				if !ok {
+					if grace, lost := r.rescheduleDelay(j); lost < grace {
+						log.Debugf("Holding Job(%s) on missing Machine(%s) for %v more", j.Name, j.TargetMachineID, grace-lost)
+						return
+					}
 					unschedule = true
 					reason = fmt.Sprintf("target Machine(%s) went away", j.TargetMachineID)
 					return
@@ -109,6 +298,12 @@ func (r *Reconciler) calculateClusterTasks(clust *clusterState, stopchan chan st
 					return
 				}
 
+				if clust.unhealthy[j.Name] == j.TargetMachineID {
+					unschedule = true
+					reason = fmt.Sprintf("unit unhealthy on target Machine(%s)", j.TargetMachineID)
+					return
+				}
+
 				return
 			}
 
@@ -124,41 +319,305 @@ func (r *Reconciler) calculateClusterTasks(clust *clusterState, stopchan chan st
 			clust.unschedule(j.Name)
 		}
 
-		for _, j := range clust.jobs {
-			if j.Scheduled() || j.TargetState == job.JobStateInactive {
+		decisions := 0
+		groups := pkg.NewUnsafeSet()
+		pending := r.pendingJobs(clust)
+		now := r.clock.Now()
+		r.pruneUnschedulable(clust)
+		r.retryOnNewMachines(clust, agents, now)
+		for i, j := range pending {
+			if r.maxSchedule > 0 && decisions >= r.maxSchedule {
+				log.Debugf("Reached limit of %d scheduling decisions, deferring %d Job(s) to next reconciliation", r.maxSchedule, len(pending)-i)
+				return
+			}
+
+			if g, ok := j.Group(); ok {
+				if groups.Contains(g) {
+					continue
+				}
+				groups.Add(g)
+
+				if name, dep, ok := groupWaiting(clust, g); ok {
+					log.Debugf("Holding group %q until Job(%s) of Job(%s) is active", g, dep, name)
+					continue
+				}
+
+				// a group backs off as a whole, tracked by its first
+				// pending member
+				if r.backingOff(j, now) {
+					continue
+				}
+
+				placed, err := r.decideGroup(clust, g)
+				if err != nil {
+					r.failedToSchedule(j, fmt.Sprintf("unable to schedule group %q: %v", g, err), now)
+					continue
+				}
+				r.clearUnschedulable(j.Name)
+
+				// a group larger than the limit may only be scheduled on
+				// its own, or it would never be scheduled at all
+				if r.maxSchedule > 0 && decisions > 0 && decisions+len(placed) > r.maxSchedule {
+					log.Debugf("Scheduling group %q would exceed limit of %d scheduling decisions, deferring to next reconciliation", g, r.maxSchedule)
+					for _, t := range placed {
+						clust.unschedule(t.JobName)
+					}
+					continue
+				}
+
+				// once the first member has been sent, the rest of the
+				// group must follow so that it is never partially placed
+				select {
+				case <-stopchan:
+					return
+				default:
+				}
+				for _, t := range placed {
+					taskchan <- t
+				}
+				decisions += len(placed)
+				continue
+			}
+
+			if dep, ok := clust.waiting[j.Name]; ok {
+				log.Debugf("Holding Job(%s) until Job(%s) is active", j.Name, dep)
+				continue
+			}
+
+			if r.backingOff(j, now) {
 				continue
 			}
 
 			dec, err := r.sched.Decide(clust, j)
 			if err != nil {
-				log.Debugf("Unable to schedule Job(%s): %v", j.Name, err)
+				r.failedToSchedule(j, err.Error(), now)
 				continue
 			}
+			r.clearUnschedulable(j.Name)
 
-			reason := fmt.Sprintf("target state %s and unit not scheduled", j.TargetState)
-			if !send(taskTypeAttemptScheduleUnit, reason, j.Name, dec.machineID) {
+			t := &task{
+				Type:      taskTypeAttemptScheduleUnit,
+				Reason:    fmt.Sprintf("target state %s and unit not scheduled", j.TargetState),
+				JobName:   j.Name,
+				MachineID: dec.machineID,
+			}
+			if r.explain {
+				t.Candidates = r.sched.Explain(clust, j)
+			}
+			if !sendTask(t) {
 				return
 			}
 
 			clust.schedule(j.Name, dec.machineID)
+			decisions++
 		}
 	}()
 
 	return
 }
 
+// groupWaiting returns the first member of the named group waiting for
+// another Job to become active, and the Job it is waiting for
+func groupWaiting(clust *clusterState, g string) (name, dep string, ok bool) {
+	for _, m := range clust.groupMembers(g) {
+		if dep, ok = clust.waiting[m.Name]; ok {
+			return m.Name, dep, true
+		}
+	}
+	return "", "", false
+}
+
+func (r *Reconciler) owned(j *job.Job) bool {
+	return r.owns == nil || r.owns(j)
+}
+
+// pendingJobs returns all Jobs that should be scheduled, ordered by how
+// long they have been waiting. Jobs that were first noticed at the same
+// time are ordered by name. Dormant batch Jobs are never pending.
+func (r *Reconciler) pendingJobs(clust *clusterState) []*job.Job {
+	var pending []*job.Job
+	for _, j := range clust.jobs {
+		if j.Scheduled() || j.TargetState == job.JobStateInactive || !r.owned(j) || clust.dormant[j.Name] {
+			continue
+		}
+		pending = append(pending, j)
+	}
+
+	now := r.clock.Now()
+	seen := make(map[string]time.Time, len(pending))
+	for _, j := range pending {
+		since, ok := r.pendingSince[j.Name]
+		if !ok {
+			since = now
+		}
+		seen[j.Name] = since
+	}
+	r.pendingSince = seen
+
+	sort.Sort(jobsByWait{pending, seen})
+	return pending
+}
+
+type jobsByWait struct {
+	jobs  []*job.Job
+	since map[string]time.Time
+}
+
+func (jw jobsByWait) Len() int      { return len(jw.jobs) }
+func (jw jobsByWait) Swap(i, j int) { jw.jobs[i], jw.jobs[j] = jw.jobs[j], jw.jobs[i] }
+
+func (jw jobsByWait) Less(i, j int) bool {
+	ti, tj := jw.since[jw.jobs[i].Name], jw.since[jw.jobs[j].Name]
+	if !ti.Equal(tj) {
+		return ti.Before(tj)
+	}
+	return jw.jobs[i].Name < jw.jobs[j].Name
+}
+
+// trackLostMachines records the time at which each machine targeted by a
+// Job was first noticed missing, and forgets machines that have returned or
+// are no longer targeted by any Job.
+func (r *Reconciler) trackLostMachines(clust *clusterState) {
+	targeted := pkg.NewUnsafeSet()
+	for _, j := range clust.jobs {
+		if j.Scheduled() {
+			targeted.Add(j.TargetMachineID)
+		}
+	}
+
+	for machID := range r.lostMachines {
+		if _, ok := clust.machines[machID]; ok || !targeted.Contains(machID) {
+			delete(r.lostMachines, machID)
+		}
+	}
+
+	now := r.clock.Now()
+	for _, machID := range targeted.Values() {
+		if _, ok := clust.machines[machID]; ok {
+			continue
+		}
+		if _, ok := r.lostMachines[machID]; !ok {
+			r.lostMachines[machID] = now
+		}
+	}
+}
+
+// awaitingLostMachines reports whether any Jobs are being held on Machines
+// that have gone away, in which case the Reconciler must keep running
+// until their reschedule grace periods elapse
+func (r *Reconciler) awaitingLostMachines() bool {
+	return len(r.lostMachines) > 0
+}
+
+// rescheduleDelay returns the grace period that applies to the given Job
+// and how long its target machine has been missing. A per-Job
+// RescheduleAfter option takes precedence over the cluster-wide setting.
+func (r *Reconciler) rescheduleDelay(j *job.Job) (grace, lost time.Duration) {
+	grace = r.rescheduleGrace
+	if d, ok := j.RescheduleAfter(); ok {
+		grace = d
+	}
+
+	if since, ok := r.lostMachines[j.TargetMachineID]; ok {
+		lost = r.clock.Now().Sub(since)
+	}
+	return
+}
+
+// decideGroup attempts to place every unscheduled member of the named group
+// of Jobs. Members are placed tentatively in the provided clusterState so
+// that they may depend on one another (e.g. through MachineOf). If any member
+// cannot be placed, all tentative placements are rolled back and an error is
+// returned. On success, a task scheduling each newly-placed member is
+// returned.
+func (r *Reconciler) decideGroup(clust *clusterState, name string) ([]*task, error) {
+	var pending []*job.Job
+	for _, m := range clust.groupMembers(name) {
+		if m.TargetState == job.JobStateInactive {
+			return nil, fmt.Errorf("member Unit(%s) has target state %s", m.Name, m.TargetState)
+		}
+		if !m.Scheduled() {
+			pending = append(pending, m)
+		}
+	}
+
+	var placed []*task
+	for len(pending) > 0 {
+		var remaining []*job.Job
+		for _, m := range pending {
+			dec, err := r.sched.Decide(clust, m)
+			if err != nil {
+				remaining = append(remaining, m)
+				continue
+			}
+
+			t := &task{
+				Type:      taskTypeAttemptScheduleUnit,
+				Reason:    fmt.Sprintf("target state %s and unit not scheduled, group %q placeable", m.TargetState, name),
+				JobName:   m.Name,
+				MachineID: dec.machineID,
+				Group:     name,
+			}
+			if r.explain {
+				t.Candidates = r.sched.Explain(clust, m)
+			}
+			clust.schedule(m.Name, dec.machineID)
+			placed = append(placed, t)
+		}
+
+		if len(remaining) == len(pending) {
+			for _, t := range placed {
+				clust.unschedule(t.JobName)
+			}
+			return nil, fmt.Errorf("unable to place member Unit(%s)", remaining[0].Name)
+		}
+		pending = remaining
+	}
+
+	return placed, nil
+}
+
+// rollbackGroup unschedules the members of the named group that have
+// already been scheduled in the current reconciliation, after another
+// member failed to persist
+func rollbackGroup(e *Engine, name string, placed []*task) {
+	for _, p := range placed {
+		if p.Group != name {
+			continue
+		}
+		t := &task{
+			Type:      taskTypeUnscheduleUnit,
+			Reason:    fmt.Sprintf("rolling back group %q after a member failed to schedule", name),
+			JobName:   p.JobName,
+			MachineID: p.MachineID,
+		}
+		if err := doTask(t, e); err != nil {
+			log.Errorf("Failed resolving task: task=%s err=%v", t, err)
+		}
+	}
+}
+
 func doTask(t *task, e *Engine) (err error) {
 	switch t.Type {
 	case taskTypeUnscheduleUnit:
 		err = e.unscheduleUnit(t.JobName, t.MachineID)
 	case taskTypeAttemptScheduleUnit:
-		e.attemptScheduleUnit(t.JobName, t.MachineID)
+		if !e.attemptScheduleUnit(t.JobName, t.MachineID) {
+			err = fmt.Errorf("unable to schedule Unit(%s) to Machine(%s)", t.JobName, t.MachineID)
+		}
+	case taskTypeRescheduleUnit:
+		err = e.rescheduleUnit(t.JobName, t.FromMachineID, t.MachineID)
 	default:
 		err = fmt.Errorf("unrecognized task type %q", t.Type)
 	}
 
 	if err == nil {
 		log.Infof("EngineReconciler completed task: %s", t)
+		statTasksResolved.Add(1)
+		e.recordDecision(t)
+		e.cacheTask(t)
+	} else {
+		statTaskFailures.Add(1)
 	}
 
 	return
@@ -15,11 +15,18 @@
 package engine
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/agent"
 	"github.com/coreos/fleet/job"
 	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/unit"
 )
 
 func TestCalculateClusterTasks(t *testing.T) {
@@ -157,7 +164,7 @@ func TestCalculateClusterTasks(t *testing.T) {
 	}
 
 	for i, tt := range tests {
-		r := NewReconciler()
+		r := NewReconciler(0, 0)
 		tasks := make([]*task, 0)
 		for tsk := range r.calculateClusterTasks(tt.clust, make(chan struct{})) {
 			tasks = append(tasks, tsk)
@@ -168,3 +175,527 @@ func TestCalculateClusterTasks(t *testing.T) {
 		}
 	}
 }
+
+func TestCalculateClusterTasksGroups(t *testing.T) {
+	newGroupUnit := func(opts string) unit.UnitFile {
+		return newUnitFile(t, fmt.Sprintf("[X-Fleet]\nGroup=app\n%s", opts))
+	}
+
+	machines := []machine.MachineState{
+		machine.MachineState{ID: "XXX"},
+		machine.MachineState{ID: "YYY"},
+	}
+
+	tests := []struct {
+		units []job.Unit
+		tasks []*task
+	}{
+		// all members placeable, so all are scheduled
+		{
+			units: []job.Unit{
+				job.Unit{Name: "app.service", Unit: newGroupUnit(""), TargetState: job.JobStateLaunched},
+				job.Unit{Name: "sidecar.service", Unit: newGroupUnit("MachineOf=app.service"), TargetState: job.JobStateLaunched},
+			},
+			tasks: []*task{
+				&task{
+					Type:      taskTypeAttemptScheduleUnit,
+					Reason:    `target state launched and unit not scheduled, group "app" placeable`,
+					JobName:   "app.service",
+					MachineID: "XXX",
+					Group:     "app",
+				},
+				&task{
+					Type:      taskTypeAttemptScheduleUnit,
+					Reason:    `target state launched and unit not scheduled, group "app" placeable`,
+					JobName:   "sidecar.service",
+					MachineID: "XXX",
+					Group:     "app",
+				},
+			},
+		},
+
+		// members may depend on each other regardless of name ordering
+		{
+			units: []job.Unit{
+				job.Unit{Name: "a.service", Unit: newGroupUnit("MachineOf=b.service"), TargetState: job.JobStateLaunched},
+				job.Unit{Name: "b.service", Unit: newGroupUnit("MachineID=YYY"), TargetState: job.JobStateLaunched},
+			},
+			tasks: []*task{
+				&task{
+					Type:      taskTypeAttemptScheduleUnit,
+					Reason:    `target state launched and unit not scheduled, group "app" placeable`,
+					JobName:   "b.service",
+					MachineID: "YYY",
+					Group:     "app",
+				},
+				&task{
+					Type:      taskTypeAttemptScheduleUnit,
+					Reason:    `target state launched and unit not scheduled, group "app" placeable`,
+					JobName:   "a.service",
+					MachineID: "YYY",
+					Group:     "app",
+				},
+			},
+		},
+
+		// one member unplaceable, so nothing is scheduled
+		{
+			units: []job.Unit{
+				job.Unit{Name: "app.service", Unit: newGroupUnit(""), TargetState: job.JobStateLaunched},
+				job.Unit{Name: "sidecar.service", Unit: newGroupUnit("MachineID=ZZZ"), TargetState: job.JobStateLaunched},
+			},
+			tasks: []*task{},
+		},
+
+		// one member not yet desired, so nothing is scheduled
+		{
+			units: []job.Unit{
+				job.Unit{Name: "app.service", Unit: newGroupUnit(""), TargetState: job.JobStateLaunched},
+				job.Unit{Name: "sidecar.service", Unit: newGroupUnit(""), TargetState: job.JobStateInactive},
+			},
+			tasks: []*task{},
+		},
+	}
+
+	for i, tt := range tests {
+		clust := newClusterState(tt.units, []job.ScheduledUnit{}, machines)
+		r := NewReconciler(0, 0)
+		tasks := make([]*task, 0)
+		for tsk := range r.calculateClusterTasks(clust, make(chan struct{})) {
+			tasks = append(tasks, tsk)
+		}
+
+		if !reflect.DeepEqual(tt.tasks, tasks) {
+			t.Errorf("case %d: task mismatch\nexpected %v\n got %v", i, tt.tasks, tasks)
+		}
+	}
+}
+
+func TestCalculateClusterTasksRescheduleGrace(t *testing.T) {
+	jsLaunched := job.JobStateLaunched
+	newClust := func(contents string) *clusterState {
+		return newClusterState(
+			[]job.Unit{
+				job.Unit{Name: "foo.service", Unit: newUnitFile(t, contents), TargetState: job.JobStateLaunched},
+			},
+			[]job.ScheduledUnit{
+				job.ScheduledUnit{Name: "foo.service", State: &jsLaunched, TargetMachineID: "ZZZ"},
+			},
+			[]machine.MachineState{
+				machine.MachineState{ID: "XXX"},
+			},
+		)
+	}
+
+	count := func(r *Reconciler, clust *clusterState) int {
+		n := 0
+		for _ = range r.calculateClusterTasks(clust, make(chan struct{})) {
+			n++
+		}
+		return n
+	}
+
+	fclock := clockwork.NewFakeClock()
+	r := NewReconciler(time.Minute, 0)
+	r.clock = fclock
+
+	// cluster-wide grace period holds the Job in place
+	if n := count(r, newClust("[X-Fleet]")); n != 0 {
+		t.Fatalf("expected no tasks within grace period, got %d", n)
+	}
+
+	fclock.Advance(30 * time.Second)
+	if n := count(r, newClust("[X-Fleet]")); n != 0 {
+		t.Fatalf("expected no tasks within grace period, got %d", n)
+	}
+
+	// once the grace period expires, the Job is rescheduled
+	fclock.Advance(31 * time.Second)
+	if n := count(r, newClust("[X-Fleet]")); n != 2 {
+		t.Fatalf("expected unschedule and schedule tasks after grace period, got %d", n)
+	}
+
+	// a per-Job RescheduleAfter overrides the cluster-wide setting
+	r = NewReconciler(time.Minute, 0)
+	r.clock = fclock
+	if n := count(r, newClust("[X-Fleet]\nRescheduleAfter=0s")); n != 2 {
+		t.Fatalf("expected immediate reschedule with RescheduleAfter=0s, got %d", n)
+	}
+}
+
+func TestCalculateClusterTasksScheduleLimit(t *testing.T) {
+	newClust := func(names ...string) *clusterState {
+		var units []job.Unit
+		for _, n := range names {
+			units = append(units, job.Unit{Name: n, Unit: unit.UnitFile{}, TargetState: job.JobStateLaunched})
+		}
+		return newClusterState(units, []job.ScheduledUnit{}, []machine.MachineState{machine.MachineState{ID: "XXX"}})
+	}
+
+	scheduled := func(r *Reconciler, clust *clusterState) []string {
+		var names []string
+		for tsk := range r.calculateClusterTasks(clust, make(chan struct{})) {
+			names = append(names, tsk.JobName)
+		}
+		return names
+	}
+
+	fclock := clockwork.NewFakeClock()
+	r := NewReconciler(0, 2)
+	r.clock = fclock
+
+	// only the first two Jobs are scheduled in the first pass
+	got := scheduled(r, newClust("c.service", "b.service", "a.service"))
+	if want := []string{"a.service", "b.service"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	// the deferred Job has been waiting longest, so goes before newer ones
+	fclock.Advance(time.Second)
+	got = scheduled(r, newClust("c.service", "a-new.service", "b-new.service"))
+	if want := []string{"c.service", "a-new.service"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestCalculateClusterTasksScheduleLimitGroups(t *testing.T) {
+	grouped := newUnitFile(t, "[X-Fleet]\nGroup=app")
+	units := []job.Unit{
+		job.Unit{Name: "a.service", Unit: unit.UnitFile{}, TargetState: job.JobStateLaunched},
+		job.Unit{Name: "b-app.service", Unit: grouped, TargetState: job.JobStateLaunched},
+		job.Unit{Name: "c-app.service", Unit: grouped, TargetState: job.JobStateLaunched},
+		job.Unit{Name: "d.service", Unit: unit.UnitFile{}, TargetState: job.JobStateLaunched},
+	}
+
+	scheduled := func(r *Reconciler) []string {
+		clust := newClusterState(units, []job.ScheduledUnit{}, []machine.MachineState{machine.MachineState{ID: "XXX"}})
+		var names []string
+		for tsk := range r.calculateClusterTasks(clust, make(chan struct{})) {
+			names = append(names, tsk.JobName)
+		}
+		return names
+	}
+
+	// the group would exceed the limit, so is deferred in favour of d
+	got := scheduled(NewReconciler(0, 2))
+	if want := []string{"a.service", "d.service"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	// a group larger than the limit is scheduled on its own
+	got = scheduled(NewReconciler(0, 1))
+	if want := []string{"a.service"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	units = units[1:]
+	got = scheduled(NewReconciler(0, 1))
+	if want := []string{"b-app.service", "c-app.service"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestResolveTasksRollsBackGroups(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	// missing.service does not exist, so cannot be scheduled
+	for _, name := range []string{"a.service", "b.service", "other.service"} {
+		if err := fr.CreateUnit(&job.Unit{Name: name}); err != nil {
+			t.Fatalf("error creating unit: %v", err)
+		}
+	}
+	e := &Engine{registry: fr, rec: NewReconciler(0, 0)}
+
+	taskchan := make(chan *task)
+	go func() {
+		defer close(taskchan)
+		for _, tsk := range []*task{
+			&task{Type: taskTypeAttemptScheduleUnit, JobName: "a.service", MachineID: "XXX", Group: "app"},
+			&task{Type: taskTypeAttemptScheduleUnit, JobName: "missing.service", MachineID: "XXX", Group: "app"},
+			&task{Type: taskTypeAttemptScheduleUnit, JobName: "b.service", MachineID: "XXX", Group: "app"},
+			&task{Type: taskTypeAttemptScheduleUnit, JobName: "other.service", MachineID: "XXX"},
+		} {
+			taskchan <- tsk
+		}
+	}()
+	resolveTasks(e, taskchan)
+
+	sUnits, err := fr.Schedule()
+	if err != nil {
+		t.Fatalf("unexpected error fetching schedule: %v", err)
+	}
+	got := make(map[string]string)
+	for _, su := range sUnits {
+		got[su.Name] = su.TargetMachineID
+	}
+	want := map[string]string{"a.service": "", "b.service": "", "other.service": "XXX"}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected schedule %v, got %v", want, got)
+	}
+}
+
+func TestResolveTasksWorkers(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	var tasks []*task
+	want := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("u%d.service", i)
+		if err := fr.CreateUnit(&job.Unit{Name: name}); err != nil {
+			t.Fatalf("error creating unit: %v", err)
+		}
+		// each Unit is scheduled, unscheduled and scheduled again, which
+		// must happen in order for it to end up scheduled
+		machID := fmt.Sprintf("M%d", i%3)
+		tasks = append(tasks,
+			&task{Type: taskTypeAttemptScheduleUnit, JobName: name, MachineID: "XXX"},
+			&task{Type: taskTypeUnscheduleUnit, JobName: name, MachineID: "XXX"},
+			&task{Type: taskTypeAttemptScheduleUnit, JobName: name, MachineID: machID},
+		)
+		want[name] = machID
+	}
+	for _, name := range []string{"a.service", "b.service"} {
+		if err := fr.CreateUnit(&job.Unit{Name: name}); err != nil {
+			t.Fatalf("error creating unit: %v", err)
+		}
+		want[name] = ""
+	}
+	// a failing member still rolls back its group
+	tasks = append(tasks,
+		&task{Type: taskTypeAttemptScheduleUnit, JobName: "a.service", MachineID: "XXX", Group: "app"},
+		&task{Type: taskTypeAttemptScheduleUnit, JobName: "missing.service", MachineID: "XXX", Group: "app"},
+		&task{Type: taskTypeAttemptScheduleUnit, JobName: "b.service", MachineID: "XXX", Group: "app"},
+	)
+
+	e := &Engine{registry: fr, rec: NewReconciler(0, 0), reconcileWorkers: 4}
+	taskchan := make(chan *task)
+	go func() {
+		defer close(taskchan)
+		for _, tsk := range tasks {
+			taskchan <- tsk
+		}
+	}()
+	resolveTasks(e, taskchan)
+
+	sUnits, err := fr.Schedule()
+	if err != nil {
+		t.Fatalf("unexpected error fetching schedule: %v", err)
+	}
+	got := make(map[string]string)
+	for _, su := range sUnits {
+		got[su.Name] = su.TargetMachineID
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected schedule %v, got %v", want, got)
+	}
+}
+
+func TestResolveTasksGroupMemberReplaced(t *testing.T) {
+	groupUnit := newUnitFile(t, "[X-Fleet]\nGroup=app")
+	units := []job.Unit{
+		job.Unit{Name: "app.service", Unit: groupUnit, TargetState: job.JobStateLaunched},
+		job.Unit{Name: "sidecar.service", Unit: groupUnit, TargetState: job.JobStateLaunched},
+	}
+	// the group was placed on a Machine that went away
+	sUnits := []job.ScheduledUnit{
+		job.ScheduledUnit{Name: "app.service", TargetMachineID: "ZZZ"},
+		job.ScheduledUnit{Name: "sidecar.service", TargetMachineID: "ZZZ"},
+	}
+	machines := []machine.MachineState{
+		machine.MachineState{ID: "XXX"},
+	}
+
+	fr := registry.NewFakeRegistry()
+	for _, u := range units {
+		u := u
+		if err := fr.CreateUnit(&u); err != nil {
+			t.Fatalf("error creating unit: %v", err)
+		}
+		if err := fr.ScheduleUnit(u.Name, "ZZZ"); err != nil {
+			t.Fatalf("error scheduling unit: %v", err)
+		}
+	}
+
+	// a single pass both unschedules the members and places the group
+	// again, which must be carried out in order by one worker
+	clust := newClusterState(units, sUnits, machines)
+	var tasks []*task
+	for tsk := range NewReconciler(0, 0).calculateClusterTasks(clust, make(chan struct{})) {
+		tasks = append(tasks, tsk)
+	}
+	if len(tasks) != 4 {
+		t.Fatalf("expected 4 tasks, got %v", tasks)
+	}
+	workers := 8
+	for _, tsk := range tasks {
+		if w, want := taskWorker(tsk, workers), taskWorker(&task{Group: "app"}, workers); w != want {
+			t.Errorf("task %s assigned to worker %d, expected %d", tsk, w, want)
+		}
+	}
+
+	e := &Engine{registry: fr, rec: NewReconciler(0, 0), reconcileWorkers: workers}
+	taskchan := make(chan *task)
+	go func() {
+		defer close(taskchan)
+		for _, tsk := range tasks {
+			taskchan <- tsk
+		}
+	}()
+	resolveTasks(e, taskchan)
+
+	for _, u := range units {
+		su, err := fr.ScheduledUnit(u.Name)
+		if err != nil {
+			t.Fatalf("unexpected error fetching schedule: %v", err)
+		}
+		if su == nil || su.TargetMachineID != "XXX" {
+			t.Errorf("expected %s to be scheduled to XXX, got %v", u.Name, su)
+		}
+	}
+}
+
+func TestTaskWorker(t *testing.T) {
+	// members of a group share a worker
+	first := taskWorker(&task{JobName: "a.service", Group: "app"}, 8)
+	for _, name := range []string{"b.service", "c.service", "d.service"} {
+		if w := taskWorker(&task{JobName: name, Group: "app"}, 8); w != first {
+			t.Errorf("member %s of group assigned to worker %d, expected %d", name, w, first)
+		}
+	}
+
+	for _, name := range []string{"a.service", "b.service", "c.service"} {
+		w := taskWorker(&task{JobName: name}, 4)
+		if w < 0 || w >= 4 {
+			t.Errorf("task of %s assigned out-of-range worker %d", name, w)
+		}
+	}
+}
+
+func TestCalculateClusterTasksUnitLimit(t *testing.T) {
+	var units []job.Unit
+	for _, n := range []string{"a.service", "b.service", "c.service", "d.service"} {
+		units = append(units, job.Unit{Name: n, Unit: unit.UnitFile{}, TargetState: job.JobStateLaunched})
+	}
+	machines := []machine.MachineState{
+		machine.MachineState{ID: "XXX", Metadata: map[string]string{agent.MaxUnitsMetadataKey: "1"}},
+		machine.MachineState{ID: "YYY"},
+	}
+	clust := newClusterState(units, []job.ScheduledUnit{}, machines)
+	clust.maxUnits = 2
+
+	counts := make(map[string]int)
+	for tsk := range NewReconciler(0, 0).calculateClusterTasks(clust, make(chan struct{})) {
+		if tsk.Type == taskTypeAttemptScheduleUnit {
+			counts[tsk.MachineID]++
+		}
+	}
+
+	if want := map[string]int{"XXX": 1, "YYY": 2}; !reflect.DeepEqual(want, counts) {
+		t.Fatalf("expected units scheduled %v, got %v", want, counts)
+	}
+}
+
+func TestCalculateClusterTasksStartAfter(t *testing.T) {
+	units := []job.Unit{
+		job.Unit{Name: "db.service", TargetState: job.JobStateLaunched},
+		job.Unit{Name: "app.service", Unit: newUnitFile(t, "[X-Fleet]\nStartAfter=db.service"), TargetState: job.JobStateLaunched},
+	}
+	sUnits := []job.ScheduledUnit{job.ScheduledUnit{Name: "db.service", TargetMachineID: "XXX"}}
+	machines := []machine.MachineState{machine.MachineState{ID: "XXX"}}
+
+	tests := []struct {
+		activeState string
+		want        []string
+	}{
+		// the dependency is not active yet
+		{"activating", nil},
+		{"active", []string{"app.service"}},
+	}
+
+	for i, tt := range tests {
+		clust := newClusterState(units, sUnits, machines)
+		clust.markWaiting([]*unit.UnitState{
+			&unit.UnitState{UnitName: "db.service", MachineID: "XXX", ActiveState: tt.activeState},
+		})
+
+		var got []string
+		for tsk := range NewReconciler(0, 0).calculateClusterTasks(clust, make(chan struct{})) {
+			got = append(got, tsk.JobName)
+		}
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("case %d: expected %v, got %v", i, tt.want, got)
+		}
+	}
+}
+
+func TestCalculateClusterTasksUnhealthy(t *testing.T) {
+	units := []job.Unit{
+		job.Unit{Name: "app.service", Unit: newUnitFile(t, "[X-Fleet]\nHealthCheck=tcp://127.0.0.1:8080"), TargetState: job.JobStateLaunched},
+	}
+	sUnits := []job.ScheduledUnit{job.ScheduledUnit{Name: "app.service", TargetMachineID: "XXX"}}
+	// XXX would be preferred, being listed first and equally loaded
+	machines := []machine.MachineState{machine.MachineState{ID: "XXX"}, machine.MachineState{ID: "YYY"}}
+
+	clust := newClusterState(units, sUnits, machines)
+	clust.countFailures([]*unit.UnitState{
+		&unit.UnitState{UnitName: "app.service", MachineID: "XXX", ActiveState: "failed", SubState: agent.SubStateUnhealthy},
+	})
+
+	var got []*task
+	for tsk := range NewReconciler(0, 0).calculateClusterTasks(clust, make(chan struct{})) {
+		tsk.Candidates = nil
+		got = append(got, tsk)
+	}
+
+	// the unit is moved away from the Machine it was unhealthy on
+	if len(got) != 2 || got[0].Type != taskTypeUnscheduleUnit || got[0].MachineID != "XXX" || got[0].Reason != "unit unhealthy on target Machine(XXX)" {
+		t.Fatalf("expected unit to be unscheduled from XXX, got %v", got)
+	}
+	if got[1].Type != taskTypeAttemptScheduleUnit || got[1].MachineID != "YYY" {
+		t.Fatalf("expected unit to be scheduled to YYY, got %v", got[1])
+	}
+}
+
+func TestCalculateClusterTasksSchedulability(t *testing.T) {
+	units := []job.Unit{
+		job.Unit{Name: "app.service", Unit: newUnitFile(t, ""), TargetState: job.JobStateLaunched},
+		job.Unit{Name: "pinned.service", Unit: newUnitFile(t, "[X-Fleet]\nMachineID=XXX"), TargetState: job.JobStateLaunched},
+		job.Unit{Name: "new.service", Unit: newUnitFile(t, ""), TargetState: job.JobStateLaunched},
+	}
+	sUnits := []job.ScheduledUnit{
+		job.ScheduledUnit{Name: "app.service", TargetMachineID: "XXX"},
+		job.ScheduledUnit{Name: "pinned.service", TargetMachineID: "XXX"},
+	}
+
+	calculate := func(sched machine.Schedulability) []*task {
+		// XXX would be preferred for new units, being listed first
+		machines := []machine.MachineState{
+			machine.MachineState{ID: "XXX", Schedulability: sched},
+			machine.MachineState{ID: "YYY"},
+			machine.MachineState{ID: "ZZZ"},
+		}
+		var got []*task
+		for tsk := range NewReconciler(0, 0).calculateClusterTasks(newClusterState(units, sUnits, machines), make(chan struct{})) {
+			tsk.Candidates = nil
+			got = append(got, tsk)
+		}
+		return got
+	}
+
+	// a cordoned Machine keeps its units, but new ones are placed elsewhere
+	got := calculate(machine.Cordoned)
+	if len(got) != 1 || got[0].Type != taskTypeAttemptScheduleUnit || got[0].JobName != "new.service" || got[0].MachineID == "XXX" {
+		t.Fatalf("expected only new.service to be scheduled away from XXX, got %v", got)
+	}
+
+	// a draining Machine has its movable units moved elsewhere
+	got = calculate(machine.Draining)
+	if len(got) != 3 {
+		t.Fatalf("expected 3 tasks, got %v", got)
+	}
+	if got[0].Type != taskTypeUnscheduleUnit || got[0].JobName != "app.service" || got[0].MachineID != "XXX" {
+		t.Errorf("expected app.service to be unscheduled from XXX, got %v", got[0])
+	}
+	for _, tsk := range got[1:] {
+		if tsk.Type != taskTypeAttemptScheduleUnit || tsk.MachineID == "XXX" {
+			t.Errorf("expected unit to be scheduled away from XXX, got %v", tsk)
+		}
+	}
+}
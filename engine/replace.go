@@ -0,0 +1,86 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/unit"
+)
+
+// reconcileReplacements sets the target state of the Units named by the
+// Replaces option of each launched Job owned by the local engine to
+// inactive, once that Job is active on its target Machine. The replaced
+// Units are then unscheduled like any other inactive Unit. It returns
+// whether any replacement is waiting for its Unit to become active.
+func (e *Engine) reconcileReplacements(snap *snapshot) (waiting bool) {
+	launched := make(map[string]bool)
+	for _, u := range snap.units {
+		launched[u.Name] = u.TargetState != job.JobStateInactive
+	}
+
+	var targets map[string]string
+	var byName map[string][]*unit.UnitState
+	for _, u := range snap.units {
+		j := job.Job{Name: u.Name, Unit: u.Unit, TargetState: u.TargetState}
+		if u.TargetState != job.JobStateLaunched || u.IsGlobal() || !e.rec.owned(&j) {
+			continue
+		}
+
+		var old []string
+		for _, name := range j.Replaces() {
+			if name != j.Name && launched[name] {
+				old = append(old, name)
+			}
+		}
+		if len(old) == 0 {
+			continue
+		}
+
+		if targets == nil {
+			targets, byName = snap.targets(), snap.statesByName()
+		}
+		if !activeOn(byName[j.Name], targets[j.Name]) {
+			waiting = true
+			continue
+		}
+
+		for _, name := range old {
+			if err := e.registry.SetUnitTargetState(name, job.JobStateInactive); err != nil {
+				log.Errorf("Failed stopping Unit(%s) replaced by Unit(%s): %v", name, j.Name, err)
+				waiting = true
+				continue
+			}
+			log.Infof("Stopped Unit(%s) replaced by Unit(%s)", name, j.Name)
+			snap.setTargetState(name, job.JobStateInactive)
+			launched[name] = false
+		}
+	}
+	return
+}
+
+// activeOn reports whether any of the given UnitStates shows its Unit
+// active on the given Machine
+func activeOn(states []*unit.UnitState, machID string) bool {
+	if machID == "" {
+		return false
+	}
+	for _, us := range states {
+		if us.MachineID == machID && us.ActiveState == "active" {
+			return true
+		}
+	}
+	return false
+}
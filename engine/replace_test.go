@@ -0,0 +1,71 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"testing"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestReconcileReplacements(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	units := []*job.Unit{
+		&job.Unit{Name: "foo-v1.service", TargetState: job.JobStateLaunched},
+		&job.Unit{Name: "foo-v2.service", Unit: newUnitFile(t, "[X-Fleet]\nReplaces=foo-v1.service"), TargetState: job.JobStateLaunched},
+	}
+	for _, u := range units {
+		if err := fr.CreateUnit(u); err != nil {
+			t.Fatalf("error creating unit: %v", err)
+		}
+	}
+	if err := fr.ScheduleUnit("foo-v2.service", "YYY"); err != nil {
+		t.Fatalf("error scheduling unit: %v", err)
+	}
+	e := &Engine{registry: fr, rec: NewReconciler(0, 0)}
+
+	tests := []struct {
+		states  []*unit.UnitState
+		waiting bool
+		want    job.JobState
+	}{
+		// the new version is not active yet
+		{nil, true, job.JobStateLaunched},
+		// only the target Machine of the new version counts
+		{[]*unit.UnitState{&unit.UnitState{UnitName: "foo-v2.service", MachineID: "XXX", ActiveState: "active"}}, true, job.JobStateLaunched},
+		{[]*unit.UnitState{&unit.UnitState{UnitName: "foo-v2.service", MachineID: "YYY", ActiveState: "active"}}, false, job.JobStateInactive},
+	}
+
+	for i, tt := range tests {
+		snap, err := fetchSnapshot(fr, false)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		snap.states = tt.states
+
+		if waiting := e.reconcileReplacements(snap); waiting != tt.waiting {
+			t.Errorf("case %d: expected waiting %t, got %t", i, tt.waiting, waiting)
+		}
+		u, err := fr.Unit("foo-v1.service")
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if u.TargetState != tt.want {
+			t.Errorf("case %d: expected target state %s, got %s", i, tt.want, u.TargetState)
+		}
+	}
+}
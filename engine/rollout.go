@@ -0,0 +1,217 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/unit"
+)
+
+// reconcileRollouts advances every running Rollout whose template is owned
+// by the local engine. It returns whether any Rollout is still running, in
+// which case the Units it replaced must be watched until they are active.
+func (e *Engine) reconcileRollouts(snap *snapshot) (running bool) {
+	for i := range snap.rollouts {
+		ro := &snap.rollouts[i]
+		if ro.Done() || !e.rec.owned(&job.Job{Name: ro.Template, Unit: ro.Unit}) {
+			continue
+		}
+
+		for _, name := range advanceRollout(ro, snap.units, snap.states, e.rec.clock.Now()) {
+			if err := e.registry.UpdateUnitFile(name, ro.Unit); err != nil {
+				log.Errorf("Failed replacing Unit(%s) during rollout of %s: %v", name, ro.Template, err)
+				continue
+			}
+			log.Infof("Replaced Unit(%s) during rollout of %s", name, ro.Template)
+			snap.setUnitFile(name, ro.Unit)
+		}
+
+		if err := e.registry.SaveRollout(ro); err != nil {
+			log.Errorf("Failed saving progress of rollout of %s: %v", ro.Template, err)
+		}
+
+		if ro.Done() {
+			log.Infof("Rollout of %s %s: %d/%d instances updated", ro.Template, ro.State, len(ro.Updated), ro.Total)
+		} else {
+			running = true
+		}
+	}
+	return
+}
+
+// advanceRollout records the progress of a running Rollout given the
+// current Units in the cluster and their reported states, and returns the
+// names of the Units whose unit file should now be replaced with the new
+// version of the template. The template itself is replaced immediately,
+// so that new instances use the new version. Instances are replaced in
+// name order, keeping at most BatchSize of them in progress at once. An
+// instance is in progress until every state reported for it shows the new
+// version active. If any replaced instance fails, or remains in progress
+// for longer than the Timeout of the Rollout, the Rollout fails and no
+// further instances are replaced. A Rollout with Canaries first replaces
+// only that many instances, then replaces no more until they have all
+// been active for its Soak period, or it is promoted by an operator. An
+// operator may also abort the Rollout at any time.
+func advanceRollout(ro *job.Rollout, units []job.Unit, states []*unit.UnitState, now time.Time) []string {
+	hash := ro.Unit.Hash()
+	replaced := make(map[string]time.Time)
+
+	byName := make(map[string][]*unit.UnitState)
+	for _, us := range states {
+		byName[us.UnitName] = append(byName[us.UnitName], us)
+	}
+
+	sorted := make([]job.Unit, len(units))
+	copy(sorted, units)
+	sort.Sort(unitsByName(sorted))
+
+	var replace, outdated, inProgress, updated []string
+	var failed string
+	for _, u := range sorted {
+		if u.Name == ro.Template {
+			if u.Unit.Hash() != hash {
+				replace = append(replace, u.Name)
+			}
+			continue
+		}
+
+		uni := unit.NewUnitNameInfo(u.Name)
+		if uni == nil || !uni.IsInstance() || uni.Template != ro.Template {
+			continue
+		}
+
+		if u.Unit.Hash() != hash {
+			outdated = append(outdated, u.Name)
+			continue
+		}
+
+		// instances that are not meant to be running need not be waited on
+		if u.TargetState != job.JobStateLaunched {
+			updated = append(updated, u.Name)
+			continue
+		}
+
+		active := len(byName[u.Name]) > 0
+		for _, us := range byName[u.Name] {
+			if us.UnitHash != hash.String() {
+				active = false
+				continue
+			}
+			if us.ActiveState == "failed" && failed == "" {
+				failed = u.Name
+			}
+			if us.ActiveState != "active" {
+				active = false
+			}
+		}
+
+		if active {
+			updated = append(updated, u.Name)
+			continue
+		}
+
+		inProgress = append(inProgress, u.Name)
+		since, ok := ro.Replaced[u.Name]
+		if !ok {
+			since = now
+		}
+		replaced[u.Name] = since
+		if ro.Timeout > 0 && now.Sub(since) > ro.Timeout && failed == "" {
+			ro.Reason = fmt.Sprintf("Unit(%s) did not become active within %v of being replaced", u.Name, ro.Timeout)
+			failed = u.Name
+		}
+	}
+
+	ro.Total = len(outdated) + len(inProgress) + len(updated)
+	ro.Updated = updated
+
+	aborted := ro.Control == job.RolloutControlAbort
+	if aborted && failed == "" {
+		ro.Reason = "aborted by operator"
+	}
+
+	if failed != "" || aborted {
+		ro.InProgress = inProgress
+		ro.Replaced = replaced
+		ro.State = job.RolloutStateFailed
+		if ro.Reason == "" {
+			ro.Reason = fmt.Sprintf("Unit(%s) failed after being replaced", failed)
+		}
+		return nil
+	}
+
+	batch := ro.BatchSize
+	if batch < 1 {
+		batch = 1
+	}
+	if ro.Canarying() && len(outdated) > 0 {
+		batch = canaryBatch(ro, len(updated), len(inProgress), batch, now)
+	}
+	for len(inProgress) < batch && len(outdated) > 0 {
+		inProgress = append(inProgress, outdated[0])
+		replace = append(replace, outdated[0])
+		replaced[outdated[0]] = now
+		outdated = outdated[1:]
+	}
+	ro.InProgress = inProgress
+	ro.Replaced = replaced
+
+	if len(inProgress) == 0 && len(outdated) == 0 {
+		ro.State = job.RolloutStateComplete
+	}
+
+	return replace
+}
+
+// canaryBatch returns how many instances of a Rollout in its canary phase
+// may be in progress, given how many instances already run the new
+// version and how many are still in progress. Once every canary has been
+// active for the soak period, or the Rollout has been promoted, the canary
+// phase ends and the given batch size applies.
+func canaryBatch(ro *job.Rollout, updated, inProgress, batch int, now time.Time) int {
+	if ro.Control == job.RolloutControlPromote {
+		ro.Promoted = true
+		return batch
+	}
+
+	if updated+inProgress < ro.Canaries {
+		ro.SoakingSince = time.Time{}
+		return ro.Canaries - updated
+	}
+	if inProgress > 0 {
+		ro.SoakingSince = time.Time{}
+		return 0
+	}
+
+	if ro.SoakingSince.IsZero() {
+		ro.SoakingSince = now
+	}
+	if now.Sub(ro.SoakingSince) < ro.Soak {
+		return 0
+	}
+	ro.Promoted = true
+	return batch
+}
+
+type unitsByName []job.Unit
+
+func (un unitsByName) Len() int           { return len(un) }
+func (un unitsByName) Less(i, j int) bool { return un[i].Name < un[j].Name }
+func (un unitsByName) Swap(i, j int)      { un[i], un[j] = un[j], un[i] }
@@ -0,0 +1,220 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestAdvanceRollout(t *testing.T) {
+	oldUF := newUnitFile(t, "[Service]\nExecStart=/bin/old")
+	newVer := newUnitFile(t, "[Service]\nExecStart=/bin/new")
+
+	unitOf := func(name string, uf unit.UnitFile) job.Unit {
+		return job.Unit{Name: name, Unit: uf, TargetState: job.JobStateLaunched}
+	}
+	stateOf := func(name string, uf unit.UnitFile, active string) *unit.UnitState {
+		return &unit.UnitState{UnitName: name, UnitHash: uf.Hash().String(), ActiveState: active, MachineID: "XXX"}
+	}
+
+	tests := []struct {
+		units      []job.Unit
+		states     []*unit.UnitState
+		batch      int
+		replace    []string
+		state      job.RolloutState
+		updated    []string
+		inProgress []string
+	}{
+		// the template and the first batch are replaced straight away
+		{
+			units: []job.Unit{
+				unitOf("foo@.service", oldUF),
+				unitOf("foo@1.service", oldUF),
+				unitOf("foo@2.service", oldUF),
+				unitOf("foo@3.service", oldUF),
+				unitOf("bar@1.service", oldUF),
+			},
+			batch:      2,
+			replace:    []string{"foo@.service", "foo@1.service", "foo@2.service"},
+			state:      job.RolloutStateRunning,
+			inProgress: []string{"foo@1.service", "foo@2.service"},
+		},
+		// the next instance is replaced once one in the batch is active
+		{
+			units: []job.Unit{
+				unitOf("foo@.service", newVer),
+				unitOf("foo@1.service", newVer),
+				unitOf("foo@2.service", newVer),
+				unitOf("foo@3.service", oldUF),
+			},
+			states: []*unit.UnitState{
+				stateOf("foo@1.service", newVer, "active"),
+				stateOf("foo@2.service", oldUF, "active"),
+			},
+			batch:      2,
+			replace:    []string{"foo@3.service"},
+			state:      job.RolloutStateRunning,
+			updated:    []string{"foo@1.service"},
+			inProgress: []string{"foo@2.service", "foo@3.service"},
+		},
+		// a failed instance stops the rollout
+		{
+			units: []job.Unit{
+				unitOf("foo@1.service", newVer),
+				unitOf("foo@2.service", oldUF),
+			},
+			states: []*unit.UnitState{
+				stateOf("foo@1.service", newVer, "failed"),
+			},
+			batch:      1,
+			state:      job.RolloutStateFailed,
+			inProgress: []string{"foo@1.service"},
+		},
+		// the rollout completes once every instance is active
+		{
+			units: []job.Unit{
+				unitOf("foo@.service", newVer),
+				unitOf("foo@1.service", newVer),
+				{Name: "foo@2.service", Unit: newVer, TargetState: job.JobStateInactive},
+			},
+			states: []*unit.UnitState{
+				stateOf("foo@1.service", newVer, "active"),
+			},
+			batch:   1,
+			state:   job.RolloutStateComplete,
+			updated: []string{"foo@1.service", "foo@2.service"},
+		},
+	}
+
+	for i, tt := range tests {
+		ro := job.Rollout{Template: "foo@.service", Unit: newVer, BatchSize: tt.batch, State: job.RolloutStateRunning}
+		replace := advanceRollout(&ro, tt.units, tt.states, time.Now())
+		if !reflect.DeepEqual(tt.replace, replace) {
+			t.Errorf("case %d: expected replacements %v, got %v", i, tt.replace, replace)
+		}
+		if ro.State != tt.state {
+			t.Errorf("case %d: expected state %s, got %s", i, tt.state, ro.State)
+		}
+		if !reflect.DeepEqual(tt.updated, ro.Updated) {
+			t.Errorf("case %d: expected updated %v, got %v", i, tt.updated, ro.Updated)
+		}
+		if !reflect.DeepEqual(tt.inProgress, ro.InProgress) {
+			t.Errorf("case %d: expected in progress %v, got %v", i, tt.inProgress, ro.InProgress)
+		}
+	}
+}
+
+func TestAdvanceRolloutTimeout(t *testing.T) {
+	oldUF := newUnitFile(t, "[Service]\nExecStart=/bin/old")
+	newVer := newUnitFile(t, "[Service]\nExecStart=/bin/new")
+	units := []job.Unit{
+		job.Unit{Name: "foo@1.service", Unit: oldUF, TargetState: job.JobStateLaunched},
+	}
+
+	start := time.Now()
+	ro := job.Rollout{Template: "foo@.service", Unit: newVer, BatchSize: 1, Timeout: time.Minute, State: job.RolloutStateRunning}
+	if replace := advanceRollout(&ro, units, nil, start); !reflect.DeepEqual([]string{"foo@1.service"}, replace) {
+		t.Fatalf("expected foo@1.service to be replaced, got %v", replace)
+	}
+
+	// the replaced instance never reports itself active
+	units[0].Unit = newVer
+	advanceRollout(&ro, units, nil, start.Add(time.Minute))
+	if ro.State != job.RolloutStateRunning {
+		t.Fatalf("expected rollout to be running within timeout, got %s", ro.State)
+	}
+	advanceRollout(&ro, units, nil, start.Add(time.Minute+time.Second))
+	if ro.State != job.RolloutStateFailed {
+		t.Fatalf("expected rollout to fail after timeout, got %s", ro.State)
+	}
+	if want := "Unit(foo@1.service) did not become active within 1m0s of being replaced"; ro.Reason != want {
+		t.Fatalf("expected reason %q, got %q", want, ro.Reason)
+	}
+}
+
+func TestAdvanceRolloutCanaries(t *testing.T) {
+	oldUF := newUnitFile(t, "[Service]\nExecStart=/bin/old")
+	newVer := newUnitFile(t, "[Service]\nExecStart=/bin/new")
+	units := []job.Unit{
+		job.Unit{Name: "foo@1.service", Unit: oldUF, TargetState: job.JobStateLaunched},
+		job.Unit{Name: "foo@2.service", Unit: oldUF, TargetState: job.JobStateLaunched},
+		job.Unit{Name: "foo@3.service", Unit: oldUF, TargetState: job.JobStateLaunched},
+	}
+	active := []*unit.UnitState{
+		&unit.UnitState{UnitName: "foo@1.service", UnitHash: newVer.Hash().String(), ActiveState: "active", MachineID: "XXX"},
+	}
+
+	start := time.Now()
+	ro := job.Rollout{Template: "foo@.service", Unit: newVer, BatchSize: 2, Canaries: 1, Soak: time.Minute, State: job.RolloutStateRunning}
+
+	// only the canary is replaced, regardless of the batch size
+	if replace := advanceRollout(&ro, units, nil, start); !reflect.DeepEqual([]string{"foo@1.service"}, replace) {
+		t.Fatalf("expected only the canary to be replaced, got %v", replace)
+	}
+	units[0].Unit = newVer
+
+	// nothing more is replaced while the canary soaks
+	if replace := advanceRollout(&ro, units, active, start.Add(time.Second)); len(replace) != 0 {
+		t.Fatalf("expected no replacements while soaking, got %v", replace)
+	}
+	if !ro.SoakingSince.Equal(start.Add(time.Second)) || !ro.Canarying() {
+		t.Fatalf("expected canary to soak since %v, got %v", start.Add(time.Second), ro.SoakingSince)
+	}
+
+	// the rest follows once the soak period has elapsed
+	replace := advanceRollout(&ro, units, active, start.Add(time.Minute+time.Second))
+	if want := []string{"foo@2.service", "foo@3.service"}; !reflect.DeepEqual(want, replace) {
+		t.Fatalf("expected %v to be replaced after soaking, got %v", want, replace)
+	}
+	if ro.Canarying() {
+		t.Fatalf("expected rollout to be promoted")
+	}
+}
+
+func TestAdvanceRolloutControl(t *testing.T) {
+	oldUF := newUnitFile(t, "[Service]\nExecStart=/bin/old")
+	newVer := newUnitFile(t, "[Service]\nExecStart=/bin/new")
+	units := []job.Unit{
+		job.Unit{Name: "foo@1.service", Unit: newVer, TargetState: job.JobStateLaunched},
+		job.Unit{Name: "foo@2.service", Unit: oldUF, TargetState: job.JobStateLaunched},
+	}
+	active := []*unit.UnitState{
+		&unit.UnitState{UnitName: "foo@1.service", UnitHash: newVer.Hash().String(), ActiveState: "active", MachineID: "XXX"},
+	}
+	newRollout := func(c job.RolloutControl) *job.Rollout {
+		return &job.Rollout{Template: "foo@.service", Unit: newVer, BatchSize: 1, Canaries: 1, Soak: time.Hour, State: job.RolloutStateRunning, Control: c}
+	}
+
+	// promoting ends the soak period early
+	ro := newRollout(job.RolloutControlPromote)
+	if replace := advanceRollout(ro, units, active, time.Now()); !reflect.DeepEqual([]string{"foo@2.service"}, replace) {
+		t.Fatalf("expected foo@2.service to be replaced once promoted, got %v", replace)
+	}
+
+	// aborting fails the rollout
+	ro = newRollout(job.RolloutControlAbort)
+	if replace := advanceRollout(ro, units, active, time.Now()); len(replace) != 0 {
+		t.Fatalf("expected no replacements once aborted, got %v", replace)
+	}
+	if ro.State != job.RolloutStateFailed || ro.Reason != "aborted by operator" {
+		t.Fatalf("expected rollout to be aborted, got state %s (%s)", ro.State, ro.Reason)
+	}
+}
@@ -16,10 +16,12 @@ package engine
 
 import (
 	"fmt"
+	"math"
 	"sort"
 
 	"github.com/coreos/fleet/agent"
 	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/unit"
 )
 
 type decision struct {
@@ -28,12 +30,22 @@ type decision struct {
 
 type Scheduler interface {
 	Decide(*clusterState, *job.Job) (*decision, error)
+
+	// Explain rates every agent considered for running the Job, in the
+	// order in which they are considered
+	Explain(*clusterState, *job.Job) []job.Candidate
 }
 
-type leastLoadedScheduler struct{}
+// scoringScheduler places each Job on the highest-scoring agent able to run
+// it, as rated by a set of weighted scorers. Agents with equal scores are
+// ordered by load, so a scoringScheduler without scorers places each Job on
+// the least-loaded agent.
+type scoringScheduler struct {
+	scorers []weightedScorer
+}
 
-func (lls *leastLoadedScheduler) Decide(clust *clusterState, j *job.Job) (*decision, error) {
-	agents := lls.sortedAgents(clust)
+func (ss *scoringScheduler) Decide(clust *clusterState, j *job.Job) (*decision, error) {
+	agents := ss.candidates(clust, j)
 
 	if len(agents) == 0 {
 		return nil, fmt.Errorf("zero agents available")
@@ -61,9 +73,63 @@ func (lls *leastLoadedScheduler) Decide(clust *clusterState, j *job.Job) (*decis
 	return &dec, nil
 }
 
+func (ss *scoringScheduler) Explain(clust *clusterState, j *job.Job) []job.Candidate {
+	var cands []job.Candidate
+	for _, as := range ss.candidates(clust, j) {
+		able, reason := as.AbleToRun(j)
+		cands = append(cands, job.Candidate{
+			MachineID: as.MState.ID,
+			Able:      able,
+			Score:     ss.score(clust, j, as),
+			Reason:    reason,
+		})
+	}
+	return cands
+}
+
+// candidates returns all agents in the order in which they should be
+// considered for running the given Job. The SpreadBy option of the Job
+// takes precedence over the scores of the agents.
+func (ss *scoringScheduler) candidates(clust *clusterState, j *job.Job) []*agent.AgentState {
+	agents := ss.sortedAgents(clust)
+	if len(ss.scorers) > 0 {
+		scores := make(map[string]float64, len(agents))
+		for _, as := range agents {
+			scores[as.MState.ID] = ss.score(clust, j, as)
+		}
+		sort.Stable(agentsByScore{agents, scores})
+	}
+	if key, ok := j.SpreadKey(); ok {
+		agents = spreadAgents(clust, j, key, agents)
+	}
+	if machID, ok := clust.unhealthy[j.Name]; ok {
+		agents = lastAgent(agents, machID)
+	}
+	return agents
+}
+
+// lastAgent moves the agent of the given Machine to the end of the list,
+// so that a Job found unhealthy on it is only placed there again if no
+// other agent is able to run it
+func lastAgent(agents []*agent.AgentState, machID string) []*agent.AgentState {
+	sorted := make([]*agent.AgentState, 0, len(agents))
+	var last *agent.AgentState
+	for _, as := range agents {
+		if as.MState.ID == machID {
+			last = as
+			continue
+		}
+		sorted = append(sorted, as)
+	}
+	if last != nil {
+		sorted = append(sorted, last)
+	}
+	return sorted
+}
+
 // sortedAgents returns a list of AgentState objects sorted ascending
 // by the number of scheduled units
-func (lls *leastLoadedScheduler) sortedAgents(clust *clusterState) []*agent.AgentState {
+func (ss *scoringScheduler) sortedAgents(clust *clusterState) []*agent.AgentState {
 	agents := clust.agents()
 
 	sas := make(sortableAgentStates, 0)
@@ -75,6 +141,40 @@ func (lls *leastLoadedScheduler) sortedAgents(clust *clusterState) []*agent.Agen
 	return []*agent.AgentState(sas)
 }
 
+// spreadAgents reorders the given agents so that those in the metadata
+// buckets hosting the fewest instances of the Job's template come first.
+// Agents lacking the metadata key are moved to the end of the list. The
+// relative order of agents within a bucket is preserved.
+func spreadAgents(clust *clusterState, j *job.Job, key string, agents []*agent.AgentState) []*agent.AgentState {
+	uni := unit.NewUnitNameInfo(j.Name)
+	if uni == nil || !uni.IsInstance() {
+		return agents
+	}
+
+	counts := clust.spreadCounts(uni.Template, key)
+	rank := func(as *agent.AgentState) int {
+		v, ok := as.MState.Metadata[key]
+		if !ok {
+			return math.MaxInt32
+		}
+		return counts[v]
+	}
+
+	spread := make([]*agent.AgentState, len(agents))
+	copy(spread, agents)
+	sort.Stable(agentsByRank{spread, rank})
+	return spread
+}
+
+type agentsByRank struct {
+	agents []*agent.AgentState
+	rank   func(*agent.AgentState) int
+}
+
+func (ar agentsByRank) Len() int           { return len(ar.agents) }
+func (ar agentsByRank) Swap(i, j int)      { ar.agents[i], ar.agents[j] = ar.agents[j], ar.agents[i] }
+func (ar agentsByRank) Less(i, j int) bool { return ar.rank(ar.agents[i]) < ar.rank(ar.agents[j]) }
+
 type sortableAgentStates []*agent.AgentState
 
 func (sas sortableAgentStates) Len() int      { return len(sas) }
@@ -48,7 +48,7 @@ func TestSchedulerDecisions(t *testing.T) {
 	}
 
 	for i, tt := range tests {
-		sched := &leastLoadedScheduler{}
+		sched := &scoringScheduler{}
 		dec, err := sched.Decide(tt.clust, tt.job)
 
 		if err != nil && tt.dec != nil {
@@ -65,6 +65,78 @@ func TestSchedulerDecisions(t *testing.T) {
 	}
 }
 
+func TestSchedulerSpreadDecisions(t *testing.T) {
+	uf := newUnitFile(t, "[X-Fleet]\nSpreadBy=zone")
+
+	machines := []machine.MachineState{
+		machine.MachineState{ID: "A", Metadata: map[string]string{"zone": "a"}},
+		machine.MachineState{ID: "B", Metadata: map[string]string{"zone": "a"}},
+		machine.MachineState{ID: "C", Metadata: map[string]string{"zone": "b"}},
+		machine.MachineState{ID: "D"},
+	}
+
+	tests := []struct {
+		units  []job.Unit
+		sUnits []job.ScheduledUnit
+		job    *job.Job
+		dec    *decision
+	}{
+		// prefer the zone without any instances, even when more loaded
+		{
+			units: []job.Unit{
+				job.Unit{Name: "foo@1.service", Unit: uf, TargetState: job.JobStateLaunched},
+				job.Unit{Name: "bar.service", TargetState: job.JobStateLaunched},
+			},
+			sUnits: []job.ScheduledUnit{
+				job.ScheduledUnit{Name: "foo@1.service", TargetMachineID: "A"},
+				job.ScheduledUnit{Name: "bar.service", TargetMachineID: "C"},
+			},
+			job: &job.Job{Name: "foo@2.service", Unit: uf},
+			dec: &decision{machineID: "C"},
+		},
+
+		// once zones are balanced, fall back to least-loaded ordering
+		{
+			units: []job.Unit{
+				job.Unit{Name: "foo@1.service", Unit: uf, TargetState: job.JobStateLaunched},
+				job.Unit{Name: "foo@2.service", Unit: uf, TargetState: job.JobStateLaunched},
+			},
+			sUnits: []job.ScheduledUnit{
+				job.ScheduledUnit{Name: "foo@1.service", TargetMachineID: "A"},
+				job.ScheduledUnit{Name: "foo@2.service", TargetMachineID: "C"},
+			},
+			job: &job.Job{Name: "foo@3.service", Unit: uf},
+			dec: &decision{machineID: "B"},
+		},
+
+		// non-instance units are not spread
+		{
+			units: []job.Unit{
+				job.Unit{Name: "bar.service", TargetState: job.JobStateLaunched},
+			},
+			sUnits: []job.ScheduledUnit{
+				job.ScheduledUnit{Name: "bar.service", TargetMachineID: "A"},
+			},
+			job: &job.Job{Name: "foo.service", Unit: uf},
+			dec: &decision{machineID: "B"},
+		},
+	}
+
+	for i, tt := range tests {
+		clust := newClusterState(tt.units, tt.sUnits, machines)
+		sched := &scoringScheduler{}
+		dec, err := sched.Decide(clust, tt.job)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(tt.dec, dec) {
+			t.Errorf("case %d: expected decision %#v, got %#v", i, tt.dec, dec)
+		}
+	}
+}
+
 func TestAgentStateSorting(t *testing.T) {
 	tests := []struct {
 		in  []*agent.AgentState
@@ -0,0 +1,220 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/fleet/agent"
+	"github.com/coreos/fleet/job"
+)
+
+// DefaultScorerWeights is the weight given to each built-in scorer unless
+// configured otherwise
+const DefaultScorerWeights = "load=1,metadata=1,conflicts=1,failures=1"
+
+// scorer rates how well suited an agent is to run a Job, from 0 (least)
+// to 1 (most). The scheduler sums the weighted scores of all scorers and
+// prefers the agents with the highest totals.
+type scorer interface {
+	score(clust *clusterState, j *job.Job, as *agent.AgentState) float64
+}
+
+// Scorer rates how well suited an agent is to run a Job, from 0 (least)
+// to 1 (most). Scorers other than the built-in ones may be made available
+// to the scheduler with RegisterScorer.
+type Scorer interface {
+	Score(j *job.Job, as *agent.AgentState) float64
+}
+
+// registeredScorer adapts a Scorer to the scorer interface
+type registeredScorer struct {
+	Scorer
+}
+
+func (rs registeredScorer) score(clust *clusterState, j *job.Job, as *agent.AgentState) float64 {
+	return rs.Score(j, as)
+}
+
+// scorers holds the built-in and registered scorers by name
+var scorers = map[string]scorer{
+	"load":      loadScorer{},
+	"metadata":  metadataScorer{},
+	"conflicts": conflictsScorer{},
+	"failures":  failureScorer{},
+}
+
+// RegisterScorer makes a Scorer available under the given name, so that it
+// may be given a weight in engine_scorer_weights. It is not safe to call
+// concurrently with scheduling and is intended to be called from an init
+// function. RegisterScorer panics if the name is already in use.
+func RegisterScorer(name string, s Scorer) {
+	if s == nil {
+		panic("engine: RegisterScorer scorer is nil")
+	}
+	if _, ok := scorers[name]; ok {
+		panic(fmt.Sprintf("engine: RegisterScorer called twice for scorer %q", name))
+	}
+	scorers[name] = registeredScorer{s}
+}
+
+// loadScorer favours agents running fewer units
+type loadScorer struct{}
+
+func (loadScorer) score(clust *clusterState, j *job.Job, as *agent.AgentState) float64 {
+	return 1 / float64(1+len(as.Units))
+}
+
+// metadataScorer favours agents satisfying more of the Job's
+// PreferredMachineMetadata
+type metadataScorer struct{}
+
+func (metadataScorer) score(clust *clusterState, j *job.Job, as *agent.AgentState) float64 {
+	prefs := j.PreferredMetadata()
+	if len(prefs) == 0 {
+		return 0
+	}
+
+	var met int
+	for key, values := range prefs {
+		if v, ok := as.MState.Metadata[key]; ok && values.Contains(v) {
+			met++
+		}
+	}
+	return float64(met) / float64(len(prefs))
+}
+
+// conflictsScorer favours agents running fewer units matching the Job's
+// PreferredConflicts
+type conflictsScorer struct{}
+
+func (conflictsScorer) score(clust *clusterState, j *job.Job, as *agent.AgentState) float64 {
+	prefs := j.PreferredConflicts()
+	if len(prefs) == 0 {
+		return 0
+	}
+
+	var found int
+	for name := range as.Units {
+		if name == j.Name {
+			continue
+		}
+		for _, pattern := range prefs {
+			if matched, _ := path.Match(pattern, name); matched {
+				found++
+				break
+			}
+		}
+	}
+	return 1 / float64(1+found)
+}
+
+// failureScorer favours agents with fewer failed units
+type failureScorer struct{}
+
+func (failureScorer) score(clust *clusterState, j *job.Job, as *agent.AgentState) float64 {
+	return 1 / float64(1+clust.failures[as.MState.ID])
+}
+
+type weightedScorer struct {
+	scorer
+	weight float64
+}
+
+// ParseScorerWeights parses a comma-separated list of `name=weight` pairs,
+// e.g. "load=1,metadata=2". Scorers not listed are disabled. An error is
+// returned for unknown scorers and malformed weights.
+func ParseScorerWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid scorer weight %q: must be of the form name=weight", pair)
+		}
+
+		name := strings.TrimSpace(parts[0])
+		if _, ok := scorers[name]; !ok {
+			return nil, fmt.Errorf("unknown scorer %q", name)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight %q for scorer %q", parts[1], name)
+		}
+		weights[name] = w
+	}
+	return weights, nil
+}
+
+// newScoringScheduler returns a scoringScheduler using the named scorers
+// with the given weights. Unknown names are ignored.
+func newScoringScheduler(weights map[string]float64) *scoringScheduler {
+	var names sort.StringSlice
+	for name := range weights {
+		if _, ok := scorers[name]; ok && weights[name] != 0 {
+			names = append(names, name)
+		}
+	}
+	names.Sort()
+
+	ss := &scoringScheduler{}
+	for _, name := range names {
+		ss.scorers = append(ss.scorers, weightedScorer{scorers[name], weights[name]})
+	}
+	return ss
+}
+
+// needsUnitStates reports whether the given Scheduler rates Machines by
+// the state of the Units they run
+func needsUnitStates(s Scheduler) bool {
+	ss, ok := s.(*scoringScheduler)
+	if !ok {
+		return true
+	}
+	for _, ws := range ss.scorers {
+		if _, ok := ws.scorer.(failureScorer); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// score returns the total weighted score of the given agent for the Job
+func (ss *scoringScheduler) score(clust *clusterState, j *job.Job, as *agent.AgentState) float64 {
+	var total float64
+	for _, ws := range ss.scorers {
+		total += ws.weight * ws.score(clust, j, as)
+	}
+	return total
+}
+
+type agentsByScore struct {
+	agents []*agent.AgentState
+	scores map[string]float64
+}
+
+func (as agentsByScore) Len() int      { return len(as.agents) }
+func (as agentsByScore) Swap(i, j int) { as.agents[i], as.agents[j] = as.agents[j], as.agents[i] }
+
+func (as agentsByScore) Less(i, j int) bool {
+	return as.scores[as.agents[i].MState.ID] > as.scores[as.agents[j].MState.ID]
+}
@@ -0,0 +1,160 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/fleet/agent"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestParseScorerWeights(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]float64
+		err  bool
+	}{
+		{"", map[string]float64{}, false},
+		{DefaultScorerWeights, map[string]float64{"load": 1, "metadata": 1, "conflicts": 1, "failures": 1}, false},
+		{" load = 2.5 , failures=-1,", map[string]float64{"load": 2.5, "failures": -1}, false},
+		{"load", nil, true},
+		{"load=heavy", nil, true},
+		{"cpu=1", nil, true},
+	}
+
+	for i, tt := range tests {
+		got, err := ParseScorerWeights(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("case %d: expected error %t, got %v", i, tt.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("case %d: expected %v, got %v", i, tt.want, got)
+		}
+	}
+}
+
+func TestScoringSchedulerDecisions(t *testing.T) {
+	uf := newUnitFile(t, "[X-Fleet]\nPreferredMachineMetadata=disk=ssd")
+	preferring := &job.Job{Name: "foo.service", Unit: uf}
+	plain := &job.Job{Name: "foo.service"}
+	uf = newUnitFile(t, "[X-Fleet]\nPreferredMachineMetadata=disk=ssd\nPreferredConflicts=a.*")
+	avoiding := &job.Job{Name: "foo.service", Unit: uf}
+
+	machines := []machine.MachineState{
+		machine.MachineState{ID: "XXX"},
+		machine.MachineState{ID: "YYY", Metadata: map[string]string{"disk": "ssd"}},
+	}
+	newClust := func(failures map[string]int) *clusterState {
+		units := []job.Unit{
+			job.Unit{Name: "a.service", TargetState: job.JobStateLaunched},
+			job.Unit{Name: "b.service", TargetState: job.JobStateLaunched},
+		}
+		sUnits := []job.ScheduledUnit{
+			job.ScheduledUnit{Name: "a.service", TargetMachineID: "YYY"},
+			job.ScheduledUnit{Name: "b.service", TargetMachineID: "YYY"},
+		}
+		clust := newClusterState(units, sUnits, machines)
+		clust.failures = failures
+		return clust
+	}
+
+	tests := []struct {
+		weights  map[string]float64
+		failures map[string]int
+		job      *job.Job
+		want     string
+	}{
+		// without scorers, the least-loaded machine is chosen
+		{nil, nil, preferring, "XXX"},
+		{map[string]float64{"load": 1}, nil, preferring, "XXX"},
+		// preferred metadata outweighs a small difference in load
+		{map[string]float64{"load": 1, "metadata": 1}, nil, preferring, "YYY"},
+		// preferences are irrelevant to Jobs without any
+		{map[string]float64{"load": 1, "metadata": 1}, nil, plain, "XXX"},
+		// failures drive Jobs away from a machine
+		{map[string]float64{"load": 1, "metadata": 1, "failures": 1}, map[string]int{"YYY": 3}, preferring, "XXX"},
+		// a negative weight packs Jobs onto busy machines
+		{map[string]float64{"load": -1}, nil, plain, "YYY"},
+		// preferred conflicts drive Jobs away from matching units
+		{map[string]float64{"load": 1, "metadata": 1, "conflicts": 1}, nil, avoiding, "XXX"},
+	}
+
+	for i, tt := range tests {
+		dec, err := newScoringScheduler(tt.weights).Decide(newClust(tt.failures), tt.job)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if dec.machineID != tt.want {
+			t.Errorf("case %d: expected Machine(%s), got Machine(%s)", i, tt.want, dec.machineID)
+		}
+	}
+}
+
+type machineScorer string
+
+func (ms machineScorer) Score(j *job.Job, as *agent.AgentState) float64 {
+	if as.MState.ID == string(ms) {
+		return 1
+	}
+	return 0
+}
+
+func TestRegisterScorer(t *testing.T) {
+	RegisterScorer("prefer-yyy", machineScorer("YYY"))
+	defer delete(scorers, "prefer-yyy")
+
+	weights, err := ParseScorerWeights("load=1,prefer-yyy=2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	machines := []machine.MachineState{machine.MachineState{ID: "XXX"}, machine.MachineState{ID: "YYY"}}
+	units := []job.Unit{job.Unit{Name: "a.service", TargetState: job.JobStateLaunched}}
+	sUnits := []job.ScheduledUnit{job.ScheduledUnit{Name: "a.service", TargetMachineID: "YYY"}}
+	clust := newClusterState(units, sUnits, machines)
+
+	dec, err := newScoringScheduler(weights).Decide(clust, &job.Job{Name: "foo.service"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dec.machineID != "YYY" {
+		t.Fatalf("expected Machine(YYY), got Machine(%s)", dec.machineID)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected registering a scorer twice to panic")
+		}
+	}()
+	RegisterScorer("load", machineScorer("XXX"))
+}
+
+func TestCountFailures(t *testing.T) {
+	clust := newClusterState(nil, nil, nil)
+	clust.countFailures([]*unit.UnitState{
+		&unit.UnitState{UnitName: "a.service", MachineID: "XXX", ActiveState: "failed"},
+		&unit.UnitState{UnitName: "b.service", MachineID: "XXX", ActiveState: "failed"},
+		&unit.UnitState{UnitName: "c.service", MachineID: "YYY", ActiveState: "active"},
+	})
+	if want := map[string]int{"XXX": 2}; !reflect.DeepEqual(want, clust.failures) {
+		t.Fatalf("expected failures %v, got %v", want, clust.failures)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+)
+
+// shardLeaseName returns the name of the lease that must be held to
+// schedule the given shard. An unsharded engine uses the original
+// engine-leader lease so it remains compatible with older engines.
+func shardLeaseName(shard, shards int) string {
+	if shards == 1 {
+		return engineLeaseName
+	}
+	return fmt.Sprintf("%s-%d", engineLeaseName, shard)
+}
+
+// jobShard returns the shard responsible for scheduling the given Job.
+// All members of a group share a shard so they can be placed together.
+func jobShard(j *job.Job, shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+
+	key := j.Name
+	if g, ok := j.Group(); ok {
+		key = g
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// ownsJob returns a function reporting whether a Job belongs to any of
+// the given shards
+func ownsJob(owned []int, shards int) func(*job.Job) bool {
+	if shards <= 1 {
+		return nil
+	}
+
+	set := make(map[int]bool, len(owned))
+	for _, s := range owned {
+		set[s] = true
+	}
+	return func(j *job.Job) bool {
+		return set[jobShard(j, shards)]
+	}
+}
+
+// ownedShards returns the shards whose lease is held by the given Machine
+func (e *Engine) ownedShards(machID string) []int {
+	var owned []int
+	for i, l := range e.leases {
+		if isLeader(l, machID) {
+			owned = append(owned, i)
+		}
+	}
+	return owned
+}
+
+// fairShare determines the maximum number of shards the local engine
+// should own, spreading the shards evenly across all Machines
+func (e *Engine) fairShare() int {
+	shards := len(e.leases)
+	if shards == 1 {
+		return 1
+	}
+
+	machines, err := e.registry.Machines()
+	if err != nil || len(machines) == 0 {
+		log.Errorf("Unable to determine fair share of engine shards: %v", err)
+		return 1
+	}
+
+	return (shards + len(machines) - 1) / len(machines)
+}
+
+// updateLeadership renews the leases of the shards owned by the local
+// engine and attempts to acquire unowned shards up to its fair share,
+// releasing any shards in excess of it. It returns true if the local
+// engine acquired any shard it did not previously own.
+func (e *Engine) updateLeadership(machID string, ttl time.Duration) (acquired bool) {
+	share := e.fairShare()
+	held := len(e.ownedShards(machID))
+
+	for i, cur := range e.leases {
+		var l registry.Lease
+		if isLeader(cur, machID) {
+			if held > share {
+				log.Infof("Releasing engine shard %d to rebalance across cluster", i)
+				if err := cur.Release(); err != nil {
+					log.Errorf("Failed to release lease: %v", err)
+				}
+				e.leases[i] = nil
+				held--
+				continue
+			}
+
+			l = renewLeadership(cur, ttl)
+			if l == nil {
+				held--
+			}
+		} else if held < share {
+			l = acquireLeadership(e.lRegistry, shardLeaseName(i, len(e.leases)), machID, engineVersion, ttl)
+			if isLeader(l, machID) {
+				held++
+				acquired = true
+				l = e.keepLease(l, ttl)
+			}
+		} else {
+			continue
+		}
+
+		logLeadershipChange(i, len(e.leases), cur, l, machID)
+		e.leases[i] = l
+	}
+
+	return
+}
+
+// keepLease has the given lease, just acquired, renewed in the background
+// if the engine is running, signalling leaseLost should it be lost. The
+// caller must hold leaseMu.
+func (e *Engine) keepLease(l registry.Lease, ttl time.Duration) registry.Lease {
+	if e.leaseRenewal <= 0 {
+		return l
+	}
+
+	k := registry.KeepLease(l, ttl, e.leaseRenewal)
+	go func() {
+		<-k.Done()
+		select {
+		case <-k.Lost():
+		default:
+			return
+		}
+		select {
+		case e.leaseLost <- struct{}{}:
+		default:
+		}
+	}()
+	return k
+}
+
+// publishStatus publishes which shards the local engine leads, when its
+// leases expire and when it last reconciled the cluster, expiring along
+// with the leases. The status is removed once no lease is held. The
+// caller must hold leaseMu.
+func (e *Engine) publishStatus(machID string, ttl time.Duration) {
+	owned := e.ownedShards(machID)
+	if len(owned) == 0 {
+		if e.published {
+			if err := e.registry.RemoveEngineStatus(machID); err != nil {
+				log.Errorf("Failed removing engine status: %v", err)
+				return
+			}
+			e.published = false
+		}
+		return
+	}
+
+	st := machine.EngineStatus{
+		MachineID:     machID,
+		Shards:        owned,
+		LeaseExpiry:   time.Now().Add(ttl),
+		LastReconcile: e.lastReconcile,
+	}
+	if err := e.registry.SetEngineStatus(st, ttl); err != nil {
+		log.Errorf("Failed publishing engine status: %v", err)
+		return
+	}
+	e.published = true
+}
+
+func logLeadershipChange(shard, shards int, prev, cur registry.Lease, machID string) {
+	subject := "Engine"
+	if shards > 1 {
+		subject = fmt.Sprintf("Engine shard %d", shard)
+	}
+
+	if cur != nil && prev == nil && cur.MachineID() != machID {
+		log.Infof("%s leader is %s", subject, cur.MachineID())
+	} else if cur != nil && prev != nil && cur.MachineID() != prev.MachineID() {
+		log.Infof("%s leadership changed from %s to %s", subject, prev.MachineID(), cur.MachineID())
+	}
+}
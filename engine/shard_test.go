@@ -0,0 +1,130 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestJobShard(t *testing.T) {
+	newJob := func(name, contents string) *job.Job {
+		return &job.Job{Name: name, Unit: newUnitFile(t, contents)}
+	}
+
+	if s := jobShard(newJob("foo.service", ""), 1); s != 0 {
+		t.Errorf("unsharded engine assigned shard %d", s)
+	}
+
+	// group members always share a shard, whatever their names
+	counts := make(map[int]int)
+	for _, name := range []string{"a.service", "b.service", "c.service", "d.service", "e.service"} {
+		counts[jobShard(newJob(name, "[X-Fleet]\nGroup=db"), 8)]++
+	}
+	if len(counts) != 1 {
+		t.Errorf("group members assigned to multiple shards: %v", counts)
+	}
+
+	// assignments are stable and within range
+	for _, name := range []string{"a.service", "b.service", "c.service"} {
+		first := jobShard(newJob(name, ""), 4)
+		if first < 0 || first >= 4 {
+			t.Errorf("Job(%s) assigned out-of-range shard %d", name, first)
+		}
+		if again := jobShard(newJob(name, ""), 4); again != first {
+			t.Errorf("Job(%s) assigned shard %d then %d", name, first, again)
+		}
+	}
+}
+
+func TestEngineUpdateLeadershipShards(t *testing.T) {
+	fr := registry.NewFakeRegistry()
+	fr.SetMachines([]machine.MachineState{{ID: "XXX"}, {ID: "YYY"}})
+	lReg := registry.NewFakeLeaseRegistry()
+
+	newEngine := func() *Engine {
+		return &Engine{
+			registry:  fr,
+			lRegistry: lReg,
+			leases:    make([]registry.Lease, 4),
+		}
+	}
+
+	x := newEngine()
+	if !x.updateLeadership("XXX", time.Second) {
+		t.Fatalf("expected XXX to acquire shards")
+	}
+	if got, want := x.ownedShards("XXX"), []int{0, 1}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("XXX owns %v, expected %v", got, want)
+	}
+
+	// XXX already holds its fair share, so acquires nothing more
+	if x.updateLeadership("XXX", time.Second) {
+		t.Fatalf("XXX unexpectedly acquired more shards")
+	}
+
+	y := newEngine()
+	y.updateLeadership("YYY", time.Second)
+	if got, want := y.ownedShards("YYY"), []int{2, 3}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("YYY owns %v, expected %v", got, want)
+	}
+
+	// once YYY leaves the cluster, XXX picks up its shards
+	for _, l := range y.leases {
+		if isLeader(l, "YYY") {
+			l.Release()
+		}
+	}
+	fr.SetMachines([]machine.MachineState{{ID: "XXX"}})
+	x.updateLeadership("XXX", time.Second)
+	if got, want := x.ownedShards("XXX"), []int{0, 1, 2, 3}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("XXX owns %v, expected %v", got, want)
+	}
+
+	// when the cluster grows, XXX releases shards in excess of its share
+	fr.SetMachines([]machine.MachineState{{ID: "XXX"}, {ID: "YYY"}, {ID: "ZZZ"}, {ID: "AAA"}})
+	x.updateLeadership("XXX", time.Second)
+	if got, want := x.ownedShards("XXX"), []int{3}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("XXX owns %v, expected %v", got, want)
+	}
+}
+
+func TestCalculateClusterTasksOwnedShards(t *testing.T) {
+	clust := newClusterState(
+		[]job.Unit{
+			job.Unit{Name: "foo.service", Unit: unit.UnitFile{}, TargetState: job.JobStateLaunched},
+			job.Unit{Name: "bar.service", Unit: unit.UnitFile{}, TargetState: job.JobStateLaunched},
+		},
+		[]job.ScheduledUnit{},
+		[]machine.MachineState{machine.MachineState{ID: "XXX"}},
+	)
+
+	r := NewReconciler(0, 0)
+	r.owns = func(j *job.Job) bool { return j.Name == "bar.service" }
+
+	var got []string
+	for tsk := range r.calculateClusterTasks(clust, make(chan struct{})) {
+		got = append(got, tsk.JobName)
+	}
+	if want := []string{"bar.service"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected tasks for %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/unit"
+)
+
+// Placement describes where the engine would schedule a Unit given the
+// current state of the cluster.
+type Placement struct {
+	// MachineID identifies the machine the Unit would be scheduled to. It
+	// is empty if the Unit is global or could not be scheduled anywhere.
+	MachineID string
+
+	// Reason explains the overall outcome of the placement
+	Reason string
+
+	// Machines holds the verdict for each machine in the cluster, in the
+	// order in which the scheduler would consider them. Machines are not
+	// scored for global Units.
+	Machines []job.Candidate
+}
+
+// SimulatePlacement determines where the engine would schedule a Unit with
+// the given name and contents, without persisting anything to the Registry.
+// If a Unit with the same name already exists, it is treated as though it
+// were not yet scheduled. The given cluster-wide limit on the number of
+// Units per Machine and scorer weights are honoured, as they would be by
+// the engine.
+func SimulatePlacement(reg registry.Registry, name string, uf unit.UnitFile, maxUnits int, weights map[string]float64) (*Placement, error) {
+	clust, err := getClusterState(reg, maxUnits)
+	if err != nil {
+		return nil, err
+	}
+
+	return simulatePlacement(clust, newScoringScheduler(weights), name, uf), nil
+}
+
+func simulatePlacement(clust *clusterState, sched *scoringScheduler, name string, uf unit.UnitFile) *Placement {
+	delete(clust.jobs, name)
+	delete(clust.gUnits, name)
+
+	u := job.Unit{Name: name, Unit: uf}
+	if u.IsGlobal() {
+		p := Placement{Reason: "global units run on every machine with matching metadata"}
+		for _, as := range sched.sortedAgents(clust) {
+			c := job.Candidate{MachineID: as.MState.ID, Able: true}
+			if !u.MetadataSatisfiedBy(as.MState) {
+				c.Able = false
+				c.Reason = "local Machine metadata insufficient"
+			} else if taint, ok := u.UntoleratedTaint(as.MState); ok {
+				c.Able = false
+				c.Reason = fmt.Sprintf("local Machine taint %q not tolerated", taint)
+			} else if d, ok := u.UnsupportedDirective(as.MState); ok {
+				c.Able = false
+				c.Reason = fmt.Sprintf("local systemd does not support %s", d)
+			}
+			p.Machines = append(p.Machines, c)
+		}
+		return &p
+	}
+
+	j := &job.Job{
+		Name:        name,
+		Unit:        uf,
+		TargetState: job.JobStateLaunched,
+	}
+
+	p := Placement{Machines: sched.Explain(clust, j)}
+
+	dec, err := sched.Decide(clust, j)
+	if err != nil {
+		p.Reason = err.Error()
+	} else {
+		p.MachineID = dec.machineID
+		p.Reason = "highest-scoring machine able to run unit"
+	}
+
+	return &p
+}
@@ -0,0 +1,87 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestSimulatePlacement(t *testing.T) {
+	machines := []machine.MachineState{
+		machine.MachineState{ID: "XXX", Metadata: map[string]string{"region": "us"}},
+		machine.MachineState{ID: "YYY", Metadata: map[string]string{"region": "eu"}},
+	}
+	existing := job.Unit{
+		Name:        "bar.service",
+		Unit:        unit.UnitFile{},
+		TargetState: job.JobStateLaunched,
+	}
+	sUnits := []job.ScheduledUnit{
+		{Name: "bar.service", TargetMachineID: "XXX"},
+	}
+
+	tests := []struct {
+		contents string
+		want     Placement
+	}{
+		// highest-scoring machine is chosen
+		{
+			contents: "",
+			want: Placement{
+				MachineID: "YYY",
+				Reason:    "highest-scoring machine able to run unit",
+				Machines: []job.Candidate{
+					{MachineID: "YYY", Able: true},
+					{MachineID: "XXX", Able: true},
+				},
+			},
+		},
+		// conflicts rule out a machine
+		{
+			contents: "[X-Fleet]\nConflicts=bar.service\nMachineMetadata=region=us\n",
+			want: Placement{
+				Reason: "no agents able to run job",
+				Machines: []job.Candidate{
+					{MachineID: "YYY", Able: false, Reason: "local Machine metadata insufficient"},
+					{MachineID: "XXX", Able: false, Reason: "found conflict with locally-scheduled Unit(bar.service)"},
+				},
+			},
+		},
+		// global units report per-machine metadata verdicts
+		{
+			contents: "[X-Fleet]\nGlobal=true\nMachineMetadata=region=eu\n",
+			want: Placement{
+				Reason: "global units run on every machine with matching metadata",
+				Machines: []job.Candidate{
+					{MachineID: "YYY", Able: true},
+					{MachineID: "XXX", Able: false, Reason: "local Machine metadata insufficient"},
+				},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		clust := newClusterState([]job.Unit{existing}, sUnits, machines)
+		got := simulatePlacement(clust, &scoringScheduler{}, "foo.service", newUnitFile(t, tt.contents))
+		if !reflect.DeepEqual(tt.want, *got) {
+			t.Errorf("case %d: unexpected placement\nexpected: %#v\nreceived: %#v", i, tt.want, *got)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-semver/semver"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/unit"
+)
+
+// snapshot holds everything the engine reads from the Registry in a single
+// reconciliation. Every pass of a reconciliation works from the same
+// snapshot, updating it as the pass changes the Registry, so that the
+// Registry is only read once per reconciliation. The engine keeps its
+// snapshot between reconciliations, rereading only what has changed.
+type snapshot struct {
+	units    []job.Unit
+	sUnits   []job.ScheduledUnit
+	machines []machine.MachineState
+	states   []*unit.UnitState
+	comps    map[string]*job.Completion
+	hists    map[string]*job.RunHistory
+	rollouts []job.Rollout
+
+	// minVersion is the cluster-wide minimum version of fleetd, if any
+	minVersion *semver.Version
+}
+
+// fetchSnapshot reads the Units, the schedule, the Machines and the
+// Rollouts of the cluster from the given Registry. Completions and
+// RunHistories are only read if batch Jobs or cron Jobs exist, and
+// UnitStates only if they are needed by any of those, by a running
+// Rollout, by a Job to be started after or to replace another or with a
+// HealthCheck, or withStates is set.
+func fetchSnapshot(reg registry.Registry, withStates bool) (*snapshot, error) {
+	var snap snapshot
+	if err := snap.refresh(reg, nil, withStates); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// refresh rereads the parts of the snapshot affected by the given Events
+// from the Registry, or all of them if changed is nil. Completions,
+// RunHistories and UnitStates are not watched, so they are always reread
+// if needed. On error, the snapshot must be discarded.
+func (s *snapshot) refresh(reg registry.Registry, changed map[pkg.Event]bool, withStates bool) error {
+	var err error
+	all := changed == nil
+	jobs := changed[registry.JobTargetChangeEvent] || changed[registry.JobTargetStateChangeEvent]
+
+	if all || jobs {
+		s.units, err = reg.Units()
+		if err != nil {
+			log.Errorf("Failed fetching Units from Registry: %v", err)
+			return err
+		}
+
+		s.sUnits, err = reg.Schedule()
+		if err != nil {
+			log.Errorf("Failed fetching schedule from Registry: %v", err)
+			return err
+		}
+	}
+
+	if all || changed[registry.MachineChangeEvent] {
+		s.machines, err = reg.Machines()
+		if err != nil {
+			log.Errorf("Failed fetching Machines from Registry: %v", err)
+			return err
+		}
+	}
+
+	if all || changed[registry.RolloutChangeEvent] {
+		s.rollouts, err = reg.Rollouts()
+		if err != nil {
+			log.Errorf("Failed fetching Rollouts from Registry: %v", err)
+			return err
+		}
+	}
+	// the minimum version is not watched, so it is always reread
+	s.minVersion, err = reg.MinimumVersion()
+	if err != nil {
+		log.Errorf("Failed fetching minimum version from Registry: %v", err)
+		return err
+	}
+
+	var rolling bool
+	for _, ro := range s.rollouts {
+		rolling = rolling || !ro.Done()
+	}
+
+	var batch, cron, deps bool
+	for _, u := range s.units {
+		j := job.Job{Name: u.Name, Unit: u.Unit}
+		deps = deps || len(j.StartAfter()) > 0 || len(j.Replaces()) > 0
+		if hc, _ := j.HealthCheck(); hc != nil {
+			deps = true
+		}
+		if sched, _ := j.CronSchedule(); sched != nil {
+			cron = true
+		} else if j.IsBatch() {
+			batch = true
+		}
+	}
+
+	s.comps, s.hists, s.states = nil, nil, nil
+	if batch {
+		s.comps, err = reg.Completions()
+		if err != nil {
+			log.Errorf("Failed fetching Completions from Registry: %v", err)
+			return err
+		}
+	}
+
+	if cron {
+		s.hists, err = reg.RunHistories()
+		if err != nil {
+			log.Errorf("Failed fetching RunHistories from Registry: %v", err)
+			return err
+		}
+	}
+
+	if withStates || batch || cron || rolling || deps {
+		s.states, err = reg.UnitStates()
+		if err != nil {
+			log.Errorf("Failed fetching UnitStates from Registry: %v", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// clusterState assembles the snapshot into a clusterState, limiting each
+// Machine to maxUnits Units
+func (s *snapshot) clusterState(maxUnits int) *clusterState {
+	clust := newClusterState(s.units, s.sUnits, s.machines)
+	clust.maxUnits = maxUnits
+	clust.minVersion = s.minVersion
+	clust.markDormant(s.comps, s.hists)
+	clust.countFailures(s.states)
+	clust.markWaiting(s.states)
+	return clust
+}
+
+// unschedule records that the named Unit has been unscheduled
+func (s *snapshot) unschedule(name string) {
+	for i := range s.sUnits {
+		if s.sUnits[i].Name == name {
+			s.sUnits[i].TargetMachineID = ""
+		}
+	}
+}
+
+// schedule records that the named Unit has been scheduled to the Machine
+func (s *snapshot) schedule(name, machID string) {
+	for i := range s.sUnits {
+		if s.sUnits[i].Name == name {
+			s.sUnits[i].TargetMachineID = machID
+			return
+		}
+	}
+	s.sUnits = append(s.sUnits, job.ScheduledUnit{Name: name, TargetMachineID: machID})
+}
+
+// setTargetState records that the target state of the named Unit changed
+func (s *snapshot) setTargetState(name string, state job.JobState) {
+	for i := range s.units {
+		if s.units[i].Name == name {
+			s.units[i].TargetState = state
+		}
+	}
+}
+
+// setUnitFile records that the unit file of the named Unit was replaced
+func (s *snapshot) setUnitFile(name string, uf unit.UnitFile) {
+	for i := range s.units {
+		if s.units[i].Name == name {
+			s.units[i].Unit = uf
+		}
+	}
+}
+
+// statesByName groups the UnitStates of the snapshot by Unit name
+func (s *snapshot) statesByName() map[string][]*unit.UnitState {
+	byName := make(map[string][]*unit.UnitState)
+	for _, us := range s.states {
+		byName[us.UnitName] = append(byName[us.UnitName], us)
+	}
+	return byName
+}
+
+// targets maps the name of each Unit in the schedule to its target Machine
+func (s *snapshot) targets() map[string]string {
+	targets := make(map[string]string, len(s.sUnits))
+	for _, su := range s.sUnits {
+		targets[su.Name] = su.TargetMachineID
+	}
+	return targets
+}
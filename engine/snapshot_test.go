@@ -0,0 +1,179 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/unit"
+)
+
+// readCountingRegistry counts the reads of records that are only needed
+// by some Jobs
+type readCountingRegistry struct {
+	*registry.FakeRegistry
+	reads map[string]int
+}
+
+func (r *readCountingRegistry) Units() ([]job.Unit, error) {
+	r.reads["units"]++
+	return r.FakeRegistry.Units()
+}
+
+func (r *readCountingRegistry) Machines() ([]machine.MachineState, error) {
+	r.reads["machines"]++
+	return r.FakeRegistry.Machines()
+}
+
+func (r *readCountingRegistry) Completions() (map[string]*job.Completion, error) {
+	r.reads["completions"]++
+	return r.FakeRegistry.Completions()
+}
+
+func (r *readCountingRegistry) RunHistories() (map[string]*job.RunHistory, error) {
+	r.reads["runs"]++
+	return r.FakeRegistry.RunHistories()
+}
+
+func (r *readCountingRegistry) UnitStates() ([]*unit.UnitState, error) {
+	r.reads["states"]++
+	return r.FakeRegistry.UnitStates()
+}
+
+func TestFetchSnapshotReads(t *testing.T) {
+	tests := []struct {
+		contents   string
+		withStates bool
+		want       map[string]int
+	}{
+		{"", false, map[string]int{"units": 1, "machines": 1}},
+		{"", true, map[string]int{"units": 1, "machines": 1, "states": 1}},
+		{"[X-Fleet]\nBatch=true", false, map[string]int{"units": 1, "machines": 1, "completions": 1, "states": 1}},
+		{"[X-Fleet]\nSchedule=@daily", false, map[string]int{"units": 1, "machines": 1, "runs": 1, "states": 1}},
+		{"[X-Fleet]\nStartAfter=db.service", false, map[string]int{"units": 1, "machines": 1, "states": 1}},
+	}
+
+	for i, tt := range tests {
+		reg := &readCountingRegistry{registry.NewFakeRegistry(), make(map[string]int)}
+		if err := reg.CreateUnit(&job.Unit{Name: "foo.service", Unit: newUnitFile(t, tt.contents)}); err != nil {
+			t.Fatalf("case %d: error creating unit: %v", i, err)
+		}
+		if _, err := fetchSnapshot(reg, tt.withStates); err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if !reflect.DeepEqual(tt.want, reg.reads) {
+			t.Errorf("case %d: expected reads %v, got %v", i, tt.want, reg.reads)
+		}
+	}
+}
+
+func TestNeedsUnitStates(t *testing.T) {
+	if needsUnitStates(newScoringScheduler(map[string]float64{"load": 1})) {
+		t.Errorf("load scorer should not need UnitStates")
+	}
+	if !needsUnitStates(newScoringScheduler(map[string]float64{"load": 1, "failures": 1})) {
+		t.Errorf("failure scorer should need UnitStates")
+	}
+}
+
+func TestEngineSnapshotCache(t *testing.T) {
+	reg := &readCountingRegistry{registry.NewFakeRegistry(), make(map[string]int)}
+	fclock := clockwork.NewFakeClock()
+	e := &Engine{
+		rec:            NewReconciler(0, 0),
+		registry:       reg,
+		changes:        &changeTracker{},
+		resyncInterval: time.Minute,
+	}
+	e.rec.clock = fclock
+
+	snapshot := func() *snapshot {
+		snap, err := e.snapshot()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return snap
+	}
+	expectReads := func(step string, units, machines int) {
+		if reg.reads["units"] != units || reg.reads["machines"] != machines {
+			t.Fatalf("%s: expected %d Units and %d Machines reads, got %v", step, units, machines, reg.reads)
+		}
+	}
+
+	snap := snapshot()
+	expectReads("initial", 1, 1)
+
+	if snapshot() != snap {
+		t.Fatalf("expected the snapshot to be reused")
+	}
+	expectReads("no changes", 1, 1)
+
+	e.changes.record(registry.MachineChangeEvent)
+	snapshot()
+	expectReads("Machine change", 1, 2)
+
+	e.changes.record(registry.JobTargetStateChangeEvent)
+	snapshot()
+	expectReads("Job change", 2, 2)
+
+	e.changes.mark()
+	snapshot()
+	expectReads("missed changes", 3, 3)
+
+	fclock.Advance(time.Minute)
+	snapshot()
+	expectReads("resync", 4, 4)
+
+	// tasks carried out by the engine are applied to the cached snapshot
+	e.cacheTask(&task{Type: taskTypeAttemptScheduleUnit, JobName: "foo.service", MachineID: "XXX"})
+	if got := snapshot().targets()["foo.service"]; got != "XXX" {
+		t.Fatalf("expected cached target XXX, got %q", got)
+	}
+	e.cacheTask(&task{Type: taskTypeUnscheduleUnit, JobName: "foo.service", MachineID: "XXX"})
+	if got := snapshot().targets()["foo.service"]; got != "" {
+		t.Fatalf("expected no cached target, got %q", got)
+	}
+	expectReads("tasks", 4, 4)
+}
+
+func TestChangeTrackerDrain(t *testing.T) {
+	ct := &changeTracker{}
+	if got := ct.drain(); got == nil || len(got) != 0 {
+		t.Fatalf("expected no Events, got %v", got)
+	}
+
+	ct.record(registry.MachineChangeEvent)
+	want := map[pkg.Event]bool{registry.MachineChangeEvent: true}
+	if got := ct.drain(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected Events %v, got %v", want, got)
+	}
+
+	ct.record(registry.MachineChangeEvent)
+	ct.mark()
+	if got := ct.drain(); got != nil {
+		t.Fatalf("expected a full read after a missed change, got %v", got)
+	}
+	if !ct.reset() {
+		t.Fatalf("expected changes to be reported")
+	}
+}
@@ -15,15 +15,68 @@
 package engine
 
 import (
+	"sort"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-semver/semver"
+
 	"github.com/coreos/fleet/agent"
 	"github.com/coreos/fleet/job"
 	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/unit"
 )
 
 type clusterState struct {
 	jobs     map[string]*job.Job
 	gUnits   map[string]*job.Unit
 	machines map[string]*machine.MachineState
+
+	// maxUnits is the cluster-wide maximum number of Units each Machine
+	// may run. A value of zero means no limit.
+	maxUnits int
+
+	// minVersion is the cluster-wide minimum version of fleetd, below
+	// which Machines accept no new Units. If nil, there is no minimum.
+	minVersion *semver.Version
+
+	// dormant holds the names of batch Jobs that must not be scheduled:
+	// those that have finished for good, and those with a cron Schedule
+	// that have no run in progress
+	dormant map[string]bool
+
+	// failures holds the number of failed Units on each Machine
+	failures map[string]int
+
+	// unhealthy maps the names of Jobs whose agent gave up on them
+	// after their HealthCheck, or the Unit itself, kept failing to the
+	// Machine they failed on
+	unhealthy map[string]string
+
+	// waiting maps the names of Jobs that must not be scheduled yet to
+	// the name of a Job given by their StartAfter option that is not
+	// active anywhere in the cluster
+	waiting map[string]string
+
+	// agentIndex holds the AgentState of each Machine, groupIndex the
+	// names of the members of each group, ordered by name, and
+	// templateIndex the names of the instances of each template unit.
+	// The indexes are built on first use, and agentIndex is kept up to
+	// date as Jobs are scheduled and unscheduled, so that lookups made
+	// for every scheduling decision need not visit every Job.
+	agentIndex    map[string]*agent.AgentState
+	groupIndex    map[string][]string
+	templateIndex map[string][]string
+
+	// spreadIndex holds the spread counts of each template unit and
+	// machine metadata key asked about. Each is built on first use and
+	// kept up to date as instances are scheduled and unscheduled.
+	spreadIndex map[spreadKey]map[string]int
+}
+
+// spreadKey identifies the spread counts of the instances of a template
+// unit over a machine metadata key
+type spreadKey struct {
+	template string
+	key      string
 }
 
 func newClusterState(units []job.Unit, sUnits []job.ScheduledUnit, machines []machine.MachineState) *clusterState {
@@ -36,6 +89,11 @@ func newClusterState(units []job.Unit, sUnits []job.ScheduledUnit, machines []ma
 	jMap := make(map[string]*job.Job)
 	guMap := make(map[string]*job.Unit)
 	for _, u := range units {
+		if _, ok := u.Instances(); ok {
+			// the instances of scaled templates are spread by
+			// reconcileInstances rather than scheduled as Jobs
+			continue
+		}
 		if u.IsGlobal() {
 			u := u
 			guMap[u.Name] = &u
@@ -68,11 +126,85 @@ func newClusterState(units []job.Unit, sUnits []job.ScheduledUnit, machines []ma
 	}
 }
 
+// markDormant records which batch Jobs must not be scheduled, based on the
+// given Completions and RunHistories
+func (cs *clusterState) markDormant(comps map[string]*job.Completion, hists map[string]*job.RunHistory) {
+	cs.dormant = make(map[string]bool)
+	for name, j := range cs.jobs {
+		if !j.IsBatch() {
+			continue
+		}
+
+		if sched, _ := j.CronSchedule(); sched != nil {
+			if h := hists[name]; h == nil || h.Current() == nil {
+				cs.dormant[name] = true
+			}
+			continue
+		}
+
+		if c := comps[name]; c != nil && c.Done() && c.UnitHash == j.Unit.Hash().String() {
+			cs.dormant[name] = true
+		}
+	}
+}
+
+// countFailures records the number of failed Units on each Machine, based
+// on the given UnitStates
+func (cs *clusterState) countFailures(states []*unit.UnitState) {
+	cs.failures = make(map[string]int)
+	cs.unhealthy = make(map[string]string)
+	for _, us := range states {
+		if us.ActiveState == "failed" {
+			cs.failures[us.MachineID]++
+			if agent.GaveUp(us) {
+				cs.unhealthy[us.UnitName] = us.MachineID
+			}
+		}
+	}
+}
+
+// markWaiting records which unscheduled Jobs are waiting for another Job
+// to become active, based on the given UnitStates
+func (cs *clusterState) markWaiting(states []*unit.UnitState) {
+	active := make(map[string]bool)
+	for _, us := range states {
+		if us.ActiveState == "active" {
+			active[us.UnitName] = true
+		}
+	}
+
+	cs.waiting = make(map[string]string)
+	for name, j := range cs.jobs {
+		if j.Scheduled() || j.TargetState == job.JobStateInactive {
+			continue
+		}
+		for _, dep := range j.StartAfter() {
+			if !active[dep] {
+				cs.waiting[name] = dep
+				break
+			}
+		}
+	}
+}
+
+// agents returns the AgentState of each Machine, reflecting the Units
+// currently scheduled to it. The AgentStates are shared by all callers and
+// must not be modified.
 func (cs *clusterState) agents() map[string]*agent.AgentState {
+	if cs.agentIndex == nil {
+		cs.agentIndex = cs.buildAgents()
+	}
+	return cs.agentIndex
+}
+
+func (cs *clusterState) buildAgents() map[string]*agent.AgentState {
 	agents := make(map[string]*agent.AgentState, len(cs.machines))
 	for _, ms := range cs.machines {
 		ms := ms
-		agents[ms.ID] = agent.NewAgentState(ms)
+		as := agent.NewAgentState(ms)
+		as.MaxUnits = cs.maxUnits
+		as.MinVersion = cs.minVersion
+		agents[ms.ID] = as
 	}
 
 	for _, j := range cs.jobs {
@@ -81,19 +213,16 @@ func (cs *clusterState) agents() map[string]*agent.AgentState {
 			continue
 		}
 		if as, ok := agents[j.TargetMachineID]; ok {
-			u := &job.Unit{
-				Name:        j.Name,
-				Unit:        j.Unit,
-				TargetState: j.TargetState,
-			}
-			as.Units[j.Name] = u
+			as.Units[j.Name] = jobUnit(j)
 		}
 	}
 
 	for _, gu := range cs.gUnits {
 		gu := gu
 		for _, a := range agents {
-			if machine.HasMetadata(a.MState, gu.RequiredTargetMetadata()) {
+			_, tainted := gu.UntoleratedTaint(a.MState)
+			_, unsupported := gu.UnsupportedDirective(a.MState)
+			if gu.MetadataSatisfiedBy(a.MState) && !tainted && !unsupported {
 				a.Units[gu.Name] = gu
 			}
 		}
@@ -102,12 +231,126 @@ func (cs *clusterState) agents() map[string]*agent.AgentState {
 	return agents
 }
 
+func jobUnit(j *job.Job) *job.Unit {
+	return &job.Unit{
+		Name:        j.Name,
+		Unit:        j.Unit,
+		TargetState: j.TargetState,
+	}
+}
+
+// buildJobIndexes indexes the Jobs by group and by template
+func (cs *clusterState) buildJobIndexes() {
+	cs.groupIndex = make(map[string][]string)
+	cs.templateIndex = make(map[string][]string)
+	for name, j := range cs.jobs {
+		if g, ok := j.Group(); ok {
+			cs.groupIndex[g] = append(cs.groupIndex[g], name)
+		}
+		if uni := unit.NewUnitNameInfo(name); uni != nil && uni.IsInstance() {
+			cs.templateIndex[uni.Template] = append(cs.templateIndex[uni.Template], name)
+		}
+	}
+	for _, names := range cs.groupIndex {
+		sort.Strings(names)
+	}
+}
+
+// spreadCounts returns the number of scheduled instances of the given
+// template unit per distinct value of the given machine metadata key.
+// Instances scheduled to machines lacking the key are not counted. The
+// counts are shared by all callers and must not be modified.
+func (cs *clusterState) spreadCounts(tmpl, key string) map[string]int {
+	sk := spreadKey{tmpl, key}
+	if counts, ok := cs.spreadIndex[sk]; ok {
+		return counts
+	}
+
+	if cs.spreadIndex == nil {
+		cs.spreadIndex = make(map[spreadKey]map[string]int)
+	}
+	counts := cs.buildSpreadCounts(tmpl, key)
+	cs.spreadIndex[sk] = counts
+	return counts
+}
+
+func (cs *clusterState) buildSpreadCounts(tmpl, key string) map[string]int {
+	if cs.templateIndex == nil {
+		cs.buildJobIndexes()
+	}
+
+	counts := make(map[string]int)
+	for _, name := range cs.templateIndex[tmpl] {
+		if v, ok := cs.spreadValue(cs.jobs[name], key); ok {
+			counts[v]++
+		}
+	}
+	return counts
+}
+
+// spreadValue returns the value of the given metadata key on the Machine
+// the Job is scheduled to, if the Job counts towards the spread of its
+// template
+func (cs *clusterState) spreadValue(j *job.Job, key string) (string, bool) {
+	if !j.Scheduled() || j.TargetState == job.JobStateInactive {
+		return "", false
+	}
+	ms, ok := cs.machines[j.TargetMachineID]
+	if !ok {
+		return "", false
+	}
+	v, ok := ms.Metadata[key]
+	return v, ok
+}
+
+// indexSpread adds delta to the spread counts the Job is counted in
+func (cs *clusterState) indexSpread(j *job.Job, delta int) {
+	if len(cs.spreadIndex) == 0 {
+		return
+	}
+	uni := unit.NewUnitNameInfo(j.Name)
+	if uni == nil || !uni.IsInstance() {
+		return
+	}
+	for sk, counts := range cs.spreadIndex {
+		if sk.template != uni.Template {
+			continue
+		}
+		if v, ok := cs.spreadValue(j, sk.key); ok {
+			counts[v] += delta
+			if counts[v] == 0 {
+				delete(counts, v)
+			}
+		}
+	}
+}
+
+// groupMembers returns all Jobs belonging to the named group, ordered by name
+func (cs *clusterState) groupMembers(name string) []*job.Job {
+	if cs.groupIndex == nil {
+		cs.buildJobIndexes()
+	}
+	names := cs.groupIndex[name]
+
+	members := make([]*job.Job, len(names))
+	for i, n := range names {
+		members[i] = cs.jobs[n]
+	}
+	return members
+}
+
 func (cs *clusterState) schedule(jobName, targetMachineID string) {
 	j := cs.jobs[jobName]
 	if j == nil {
 		return
 	}
+	cs.unindexAgent(j)
+	cs.indexSpread(j, -1)
 	j.TargetMachineID = targetMachineID
+	cs.indexSpread(j, 1)
+	if as, ok := cs.agentIndex[targetMachineID]; ok && j.TargetState != job.JobStateInactive {
+		as.Units[j.Name] = jobUnit(j)
+	}
 }
 
 func (cs *clusterState) unschedule(jobName string) {
@@ -115,5 +358,14 @@ func (cs *clusterState) unschedule(jobName string) {
 	if j == nil {
 		return
 	}
+	cs.unindexAgent(j)
+	cs.indexSpread(j, -1)
 	j.TargetMachineID = ""
 }
+
+// unindexAgent removes the Job from the AgentState of its target Machine
+func (cs *clusterState) unindexAgent(j *job.Job) {
+	if as, ok := cs.agentIndex[j.TargetMachineID]; ok {
+		delete(as.Units, j.Name)
+	}
+}
@@ -17,6 +17,7 @@ package engine
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/coreos/fleet/agent"
@@ -25,8 +26,7 @@ import (
 	"github.com/coreos/fleet/unit"
 )
 
-func newUnitWithMetadata(t *testing.T, metadata string) unit.UnitFile {
-	contents := fmt.Sprintf("[X-Fleet]\nMachineMetadata=%s", metadata)
+func newUnitFile(t *testing.T, contents string) unit.UnitFile {
 	u, err := unit.NewUnitFile(contents)
 	if err != nil {
 		t.Fatalf("error creating unit from %q: %v", contents, err)
@@ -34,6 +34,10 @@ func newUnitWithMetadata(t *testing.T, metadata string) unit.UnitFile {
 	return *u
 }
 
+func newUnitWithMetadata(t *testing.T, metadata string) unit.UnitFile {
+	return newUnitFile(t, fmt.Sprintf("[X-Fleet]\nMachineMetadata=%s", metadata))
+}
+
 func TestClusterStateAgents(t *testing.T) {
 	tests := []struct {
 		clust  *clusterState
@@ -345,3 +349,72 @@ func TestClusterStateAgents(t *testing.T) {
 		}
 	}
 }
+
+func TestClusterStateIndexes(t *testing.T) {
+	units := []job.Unit{
+		job.Unit{Name: "a.service", TargetState: job.JobStateLaunched},
+		job.Unit{Name: "b.service", Unit: newUnitFile(t, "[X-Fleet]\nGroup=app"), TargetState: job.JobStateLaunched},
+		job.Unit{Name: "c.service", Unit: newUnitFile(t, "[X-Fleet]\nGroup=app"), TargetState: job.JobStateLaunched},
+		job.Unit{Name: "web@1.service", TargetState: job.JobStateLaunched},
+		job.Unit{Name: "web@2.service", TargetState: job.JobStateLaunched},
+	}
+	sUnits := []job.ScheduledUnit{
+		job.ScheduledUnit{Name: "a.service", TargetMachineID: "XXX"},
+		job.ScheduledUnit{Name: "web@1.service", TargetMachineID: "XXX"},
+	}
+	machines := []machine.MachineState{
+		machine.MachineState{ID: "XXX", Metadata: map[string]string{"region": "us"}},
+		machine.MachineState{ID: "YYY", Metadata: map[string]string{"region": "eu"}},
+	}
+	clust := newClusterState(units, sUnits, machines)
+
+	unitsOn := func(machID string) []string {
+		var names []string
+		for name := range clust.agents()[machID].Units {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	if got, want := unitsOn("XXX"), []string{"a.service", "web@1.service"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected XXX to run %v, got %v", want, got)
+	}
+
+	// the agents follow Jobs as they are scheduled and unscheduled
+	clust.schedule("a.service", "YYY")
+	clust.schedule("web@2.service", "YYY")
+	clust.unschedule("web@1.service")
+	if got := unitsOn("XXX"); len(got) != 0 {
+		t.Errorf("expected XXX to run nothing, got %v", got)
+	}
+	if got, want := unitsOn("YYY"), []string{"a.service", "web@2.service"}; !reflect.DeepEqual(want, got) {
+		t.Errorf("expected YYY to run %v, got %v", want, got)
+	}
+	if !reflect.DeepEqual(clust.agents(), clust.buildAgents()) {
+		t.Errorf("indexed agents diverged from those of the cluster state")
+	}
+
+	var members []string
+	for _, m := range clust.groupMembers("app") {
+		members = append(members, m.Name)
+	}
+	if want := []string{"b.service", "c.service"}; !reflect.DeepEqual(want, members) {
+		t.Errorf("expected group members %v, got %v", want, members)
+	}
+
+	if got, want := clust.spreadCounts("web@.service", "region"), map[string]int{"eu": 1}; !reflect.DeepEqual(want, got) {
+		t.Errorf("expected spread counts %v, got %v", want, got)
+	}
+
+	// the spread counts follow instances as they are scheduled and
+	// unscheduled
+	clust.schedule("web@1.service", "XXX")
+	clust.unschedule("web@2.service")
+	if got, want := clust.spreadCounts("web@.service", "region"), map[string]int{"us": 1}; !reflect.DeepEqual(want, got) {
+		t.Errorf("expected spread counts %v, got %v", want, got)
+	}
+	if got, want := clust.spreadCounts("web@.service", "region"), clust.buildSpreadCounts("web@.service", "region"); !reflect.DeepEqual(want, got) {
+		t.Errorf("indexed spread counts %v diverged from those of the cluster state %v", got, want)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// engineStats is published through expvar under the "engine" key
+	engineStats = expvar.NewMap("engine")
+
+	statReconciles             = new(expvar.Int)
+	statReconcileFailures      = new(expvar.Int)
+	statTasksResolved          = new(expvar.Int)
+	statTaskFailures           = new(expvar.Int)
+	statLeadershipAcquisitions = new(expvar.Int)
+	statOrphansRemoved         = new(expvar.Int)
+	statScheduleConflicts      = new(expvar.Int)
+	statReconcileDuration      = newDurationHistogram(
+		10*time.Millisecond,
+		50*time.Millisecond,
+		100*time.Millisecond,
+		500*time.Millisecond,
+		time.Second,
+		2*time.Second,
+		5*time.Second,
+		10*time.Second,
+	)
+)
+
+func init() {
+	engineStats.Set("reconciles", statReconciles)
+	engineStats.Set("reconcileFailures", statReconcileFailures)
+	engineStats.Set("tasksResolved", statTasksResolved)
+	engineStats.Set("taskFailures", statTaskFailures)
+	engineStats.Set("leadershipAcquisitions", statLeadershipAcquisitions)
+	engineStats.Set("orphansRemoved", statOrphansRemoved)
+	engineStats.Set("scheduleConflicts", statScheduleConflicts)
+	engineStats.Set("reconcileDuration", statReconcileDuration)
+}
+
+// durationHistogram is an expvar.Var that records the distribution of
+// observed durations across a fixed set of upper bounds. Bucket counts
+// are cumulative, so each bucket includes every observation less than
+// or equal to its bound.
+type durationHistogram struct {
+	mu     sync.Mutex
+	bounds []time.Duration
+	counts []int64
+	count  int64
+	sum    time.Duration
+}
+
+func newDurationHistogram(bounds ...time.Duration) *durationHistogram {
+	return &durationHistogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)),
+	}
+}
+
+func (h *durationHistogram) Observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, b := range h.bounds {
+		if d <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += d
+}
+
+// String renders the histogram as a JSON object, satisfying expvar.Var.
+// Bucket keys and the sum are expressed in seconds.
+func (h *durationHistogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buckets := make([]string, 0, len(h.bounds)+1)
+	for i, b := range h.bounds {
+		buckets = append(buckets, fmt.Sprintf("%q: %d", fmt.Sprint(b.Seconds()), h.counts[i]))
+	}
+	buckets = append(buckets, fmt.Sprintf("%q: %d", "+Inf", h.count))
+
+	return fmt.Sprintf(`{"count": %d, "sum": %v, "buckets": {%s}}`, h.count, h.sum.Seconds(), strings.Join(buckets, ", "))
+}
@@ -0,0 +1,49 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDurationHistogram(t *testing.T) {
+	h := newDurationHistogram(100*time.Millisecond, time.Second)
+	for _, d := range []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 500 * time.Millisecond, 3 * time.Second} {
+		h.Observe(d)
+	}
+
+	var got struct {
+		Count   int64            `json:"count"`
+		Sum     float64          `json:"sum"`
+		Buckets map[string]int64 `json:"buckets"`
+	}
+	if err := json.Unmarshal([]byte(h.String()), &got); err != nil {
+		t.Fatalf("Histogram rendered invalid JSON %q: %v", h.String(), err)
+	}
+
+	if got.Count != 4 {
+		t.Errorf("Expected count 4, got %d", got.Count)
+	}
+	if got.Sum != 3.65 {
+		t.Errorf("Expected sum 3.65, got %v", got.Sum)
+	}
+	want := map[string]int64{"0.1": 2, "1": 3, "+Inf": 4}
+	if !reflect.DeepEqual(want, got.Buckets) {
+		t.Errorf("Expected buckets %v, got %v", want, got.Buckets)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Credentials returns the username and password with which to
+// authenticate to etcd
+type Credentials func() (username, password string, err error)
+
+// StaticCredentials always returns the given username and password
+func StaticCredentials(username, password string) Credentials {
+	return func() (string, string, error) {
+		return username, password, nil
+	}
+}
+
+// PasswordFileCredentials returns the given username along with the
+// password read from the named file, ignoring surrounding whitespace. The
+// file is read again whenever etcd rejects the password, so that it may be
+// rotated without restarting.
+func PasswordFileCredentials(username, file string) Credentials {
+	return func() (string, string, error) {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", "", err
+		}
+		password := strings.TrimSpace(string(b))
+		if password == "" {
+			return "", "", errors.New("empty etcd password file")
+		}
+		return username, password, nil
+	}
+}
+
+// SetCredentials has the client authenticate every request to etcd with
+// the username and password returned by creds. These are cached until
+// etcd responds 401 Unauthorized, after which they are fetched again for
+// the next attempt, picking up rotated credentials.
+func (c *client) SetCredentials(creds Credentials) {
+	c.transport = &authTransport{transport: c.transport, creds: creds}
+}
+
+// authTransport adds basic authentication to each request made through
+// the wrapped transport
+type authTransport struct {
+	transport
+	creds Credentials
+
+	mu       sync.Mutex
+	cached   bool
+	username string
+	password string
+}
+
+func (at *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	username, password, err := at.credentials()
+	if err != nil {
+		return nil, err
+	}
+
+	// the request is set up by the client itself, and is modified in
+	// place so that it may still be cancelled by the wrapped transport
+	req.SetBasicAuth(username, password)
+
+	resp, err := at.transport.RoundTrip(req)
+	if err == nil && resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		at.mu.Lock()
+		at.cached = false
+		at.mu.Unlock()
+	}
+	return resp, err
+}
+
+func (at *authTransport) credentials() (string, string, error) {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	if !at.cached {
+		username, password, err := at.creds()
+		if err != nil {
+			return "", "", err
+		}
+		at.username, at.password, at.cached = username, password, true
+	}
+	return at.username, at.password, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientCredentialsRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fleet-etcd-auth")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(file, []byte("old\n"), 0600); err != nil {
+		t.Fatalf("failed writing password file: %v", err)
+	}
+
+	var mu sync.Mutex
+	password := "old"
+	rejected := 0
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if u, p, ok := r.BasicAuth(); !ok || u != "fleet" || p != password {
+			rejected++
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-Etcd-Index", "1")
+		fmt.Fprint(w, `{"action":"get","node":{"key":"/foo","value":"bar"}}`)
+	})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	c, err := NewClient([]string{srv.URL}, &http.Transport{}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	c.SetCredentials(PasswordFileCredentials("fleet", file))
+
+	res, err := c.Do(&Get{Key: "/foo"})
+	if err != nil || res == nil || res.Node.Value != "bar" {
+		t.Fatalf("unexpected result %v, err %v", res, err)
+	}
+	if rejected != 0 {
+		t.Fatalf("expected no rejected requests, got %d", rejected)
+	}
+
+	// rotate the password
+	mu.Lock()
+	password = "new"
+	mu.Unlock()
+	if err := ioutil.WriteFile(file, []byte("new\n"), 0600); err != nil {
+		t.Fatalf("failed writing password file: %v", err)
+	}
+
+	res, err = c.Do(&Get{Key: "/foo"})
+	if err != nil || res == nil || res.Node.Value != "bar" {
+		t.Fatalf("unexpected result %v, err %v", res, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if rejected != 1 {
+		t.Fatalf("expected the stale password to be rejected once, got %d", rejected)
+	}
+}
+
+func TestPasswordFileCredentialsEmpty(t *testing.T) {
+	f, err := ioutil.TempFile("", "fleet-etcd-auth")
+	if err != nil {
+		t.Fatalf("failed creating temp file: %v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if _, _, err := PasswordFileCredentials("fleet", f.Name())(); err == nil {
+		t.Fatalf("expected error reading an empty password file")
+	}
+}
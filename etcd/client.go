@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/coreos/fleet/log"
@@ -48,6 +49,40 @@ func NewClient(endpoints []string, transport *http.Transport, actionTimeout time
 		endpoints = []string{defaultEndpoint}
 	}
 
+	parsed, err := parseEndpoints(endpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client{
+		endpoints:     parsed,
+		transport:     transport,
+		actionTimeout: actionTimeout,
+	}, nil
+}
+
+// SetEndpoints replaces the endpoints against which requests are made,
+// such as when fleetd reloads its configuration. Requests already in
+// flight carry on against their original endpoint.
+func (c *client) SetEndpoints(endpoints []string) error {
+	if len(endpoints) == 0 {
+		endpoints = []string{defaultEndpoint}
+	}
+
+	parsed, err := parseEndpoints(endpoints)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.endpoints = parsed
+	c.failures = make(map[string]int)
+	return nil
+}
+
+// parseEndpoints parses and validates the given endpoint URLs
+func parseEndpoints(endpoints []string) ([]url.URL, error) {
 	parsed := make([]url.URL, len(endpoints))
 	for i, ep := range endpoints {
 		u, err := url.Parse(ep)
@@ -63,12 +98,7 @@ func NewClient(endpoints []string, transport *http.Transport, actionTimeout time
 
 		parsed[i] = *u
 	}
-
-	return &client{
-		endpoints:     parsed,
-		transport:     transport,
-		actionTimeout: actionTimeout,
-	}, nil
+	return parsed, nil
 }
 
 // setDefaultPath will set the Path attribute of the provided
@@ -114,6 +144,21 @@ type client struct {
 	endpoints     []url.URL
 	transport     transport
 	actionTimeout time.Duration
+
+	// mu guards endpoints, which discovery and rotation may replace
+	// while requests are made
+	mu          sync.Mutex
+	discovery   *srvDiscovery
+	rotateAfter int
+	failures    map[string]int
+}
+
+// currentEndpoints returns the endpoints against which to attempt the
+// next request
+func (c *client) currentEndpoints() []url.URL {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.endpoints
 }
 
 // a requestFunc must never return a nil *http.Response and a nil error together
@@ -176,11 +221,13 @@ func (c *client) requestHTTP(req *http.Request, cancel <-chan struct{}) (resp *h
 // retrieved, a nil object is returned.
 func (c *client) resolve(act Action, rf requestFunc, cancel <-chan struct{}) (*Result, error) {
 	requests := func() (res *Result, err error) {
-		for eIndex := 0; eIndex < len(c.endpoints); eIndex++ {
-			endpoint := c.endpoints[eIndex]
+		endpoints := c.currentEndpoints()
+		for eIndex := 0; eIndex < len(endpoints); eIndex++ {
+			endpoint := endpoints[eIndex]
 			ar := newActionResolver(act, &endpoint, rf)
 			res, err = ar.Resolve(cancel)
 			if res != nil || err != nil {
+				c.endpointSucceeded(endpoint)
 				break
 			}
 
@@ -189,6 +236,7 @@ func (c *client) resolve(act Action, rf requestFunc, cancel <-chan struct{}) (*R
 				return
 			default:
 			}
+			c.endpointFailed(endpoint)
 		}
 
 		return
@@ -210,6 +258,9 @@ func (c *client) resolve(act Action, rf requestFunc, cancel <-chan struct{}) (*R
 
 			log.Errorf("Unable to get result for %v, retrying in %v", act, sleep)
 
+			// no endpoint was usable, so they may since have moved
+			c.rediscover()
+
 			select {
 			case <-cancel:
 				return nil, errors.New("cancelled")
@@ -246,12 +297,20 @@ func (c *client) Do(act Action) (*Result, error) {
 	select {
 	case <-time.After(c.actionTimeout):
 		close(cancel)
-		return nil, errors.New("timeout reached")
+		return nil, timeoutError{}
 	case r := <-result:
 		return r.res, r.err
 	}
 }
 
+// timeoutError is returned by Do once the action timeout is reached. It is
+// a net.Error, so may be told apart from other failures.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout reached" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
 // Make any necessary HTTP requests to resolve the given Action, returning
 // a Result if one can be acquired. If the provided channel is ever closed,
 // all in-flight HTTP requests will be aborted and an error will be returned.
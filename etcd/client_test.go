@@ -61,6 +61,33 @@ func TestNewClient(t *testing.T) {
 }
 
 // client.SetDefaultPath should only overwrite the path if it is unset
+func TestClientSetEndpoints(t *testing.T) {
+	c, err := NewClient([]string{"http://192.0.2.3"}, &http.Transport{}, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.SetEndpointRotation(2)
+	c.endpointFailed(c.currentEndpoints()[0])
+
+	if err := c.SetEndpoints([]string{"boots://pants"}); err == nil {
+		t.Errorf("expected error setting bogus endpoint")
+	}
+	if got := c.currentEndpoints(); len(got) != 1 || got[0].Host != "192.0.2.3" {
+		t.Errorf("expected endpoints unchanged after error, got %v", got)
+	}
+
+	if err := c.SetEndpoints([]string{"http://192.0.2.4", "http://192.0.2.5"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := c.currentEndpoints()
+	if len(got) != 2 || got[0].Host != "192.0.2.4" || got[1].Host != "192.0.2.5" {
+		t.Errorf("expected new endpoints, got %v", got)
+	}
+	if len(c.failures) != 0 {
+		t.Errorf("expected failures of old endpoints to be forgotten, got %v", c.failures)
+	}
+}
+
 func TestSetDefaultPath(t *testing.T) {
 	tests := []struct {
 		in  string
@@ -478,7 +505,7 @@ func (n *nilNilTransport) CancelRequest(req *http.Request) {}
 
 // Ensure that any request that somehow returns (nil, nil) propagates an actual error
 func TestNilNilRequestHTTP(t *testing.T) {
-	c := &client{[]url.URL{}, &nilNilTransport{}, time.Second}
+	c := &client{endpoints: []url.URL{}, transport: &nilNilTransport{}, actionTimeout: time.Second}
 	cancel := make(chan struct{})
 	resp, body, err := c.requestHTTP(nil, cancel)
 	if err == nil {
@@ -512,7 +539,7 @@ func (r *respAndErrTransport) CancelRequest(req *http.Request) {}
 
 // Ensure that the body of a response is closed even when an error is returned
 func TestRespAndErrRequestHTTP(t *testing.T) {
-	c := &client{[]url.URL{}, &respAndErrTransport{}, time.Second}
+	c := &client{endpoints: []url.URL{}, transport: &respAndErrTransport{}, actionTimeout: time.Second}
 	cancel := make(chan struct{})
 	resp, body, err := c.requestHTTP(nil, cancel)
 	if err == nil {
@@ -22,6 +22,7 @@ import (
 
 const (
 	ErrorKeyNotFound       = 100
+	ErrorCompareFailed     = 101
 	ErrorNodeExist         = 105
 	ErrorEventIndexCleared = 401
 )
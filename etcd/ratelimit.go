@@ -0,0 +1,89 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"sync"
+	"time"
+)
+
+// NewRateLimitedClient wraps the given Client so that Do makes at most qps
+// Actions per second on average, allowing bursts of up to burst Actions.
+// Callers exceeding the rate block until a token is available. Wait is
+// passed through, as a watch holds a single long-lived request.
+func NewRateLimitedClient(c Client, qps float64, burst int) Client {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimitedClient{
+		Client: c,
+		bucket: newTokenBucket(qps, burst, time.Now),
+		sleep:  time.Sleep,
+	}
+}
+
+type rateLimitedClient struct {
+	Client
+	bucket *tokenBucket
+	sleep  func(time.Duration)
+}
+
+func (rc *rateLimitedClient) Do(act Action) (*Result, error) {
+	if d := rc.bucket.take(); d > 0 {
+		rc.sleep(d)
+	}
+	return rc.Client.Do(act)
+}
+
+// tokenBucket holds up to burst tokens, refilled at rate tokens per second
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now func() time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now(),
+		now:    now,
+	}
+}
+
+// take removes a token from the bucket, returning how long the caller must
+// wait before that token would have been available. Tokens may be taken
+// ahead of time, in which case later callers wait correspondingly longer.
+func (tb *tokenBucket) take() time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
@@ -0,0 +1,83 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	tb := newTokenBucket(10, 2, func() time.Time { return now })
+
+	var waits []time.Duration
+	take := func() { waits = append(waits, tb.take()) }
+
+	// the burst is available at once, after which callers wait their turn
+	take()
+	take()
+	take()
+	take()
+
+	// time passing pays back the tokens taken ahead of time first
+	now = now.Add(200 * time.Millisecond)
+	take()
+
+	// an idle bucket fills up to the burst, and no further
+	now = now.Add(time.Hour)
+	take()
+	take()
+	take()
+
+	want := []time.Duration{
+		0,
+		0,
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		100 * time.Millisecond,
+		0,
+		0,
+		100 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(want, waits) {
+		t.Errorf("expected waits %v, got %v", want, waits)
+	}
+}
+
+func TestRateLimitedClient(t *testing.T) {
+	fc := &failingClient{}
+	var slept []time.Duration
+	now := time.Unix(0, 0)
+	rc := &rateLimitedClient{
+		Client: fc,
+		bucket: newTokenBucket(1, 1, func() time.Time { return now }),
+		sleep:  func(d time.Duration) { slept = append(slept, d) },
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := rc.Do(&Get{Key: "/foo"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if fc.calls != 2 {
+		t.Errorf("expected 2 calls to the wrapped Client, got %d", fc.calls)
+	}
+	if want := []time.Duration{time.Second}; !reflect.DeepEqual(want, slept) {
+		t.Errorf("expected sleeps %v, got %v", want, slept)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/coreos/fleet/log"
+)
+
+const (
+	// errorWatcherCleared is the etcd error code reported, along with 400
+	// Bad Request, for a watch cut short as etcd recovers or shuts down,
+	// which clears up once it is back. Errors etcd reports along with 500
+	// Internal Server Error, such as while electing a leader, never reach
+	// a Client's caller, as the Client tries the next endpoint instead.
+	errorWatcherCleared = 400
+)
+
+// RetryPolicy determines how often and how quickly a failed Action is
+// attempted again
+type RetryPolicy struct {
+	// Attempts is the greatest number of times an Action is made,
+	// including the first. A value of 1 or less disables retries.
+	Attempts int
+
+	// Backoff is the delay before the first retry, doubling with each
+	// one after it up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Jitter is the fraction of each delay by which it is randomly
+	// shortened, so that many clients failing at once do not retry in
+	// lockstep.
+	Jitter float64
+
+	// Timeout, if non-zero, bounds the time spent on an Action across all
+	// its attempts. As a Client already tries every endpoint until its
+	// action timeout, it should be the same timeout.
+	Timeout time.Duration
+}
+
+// delay returns the time to wait before the given retry, counting from 1
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry && (p.MaxBackoff == 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 && d > 0 {
+		d -= time.Duration(p.Jitter * rand.Float64() * float64(d))
+	}
+	return d
+}
+
+// NewRetryClient wraps the given Client so that Do makes each Action up to
+// the number of attempts of the policy, as long as it fails with a
+// transient error, is safe to make again and the policy's timeout allows.
+// Wait is passed through, as it already retries until cancelled.
+func NewRetryClient(c Client, p RetryPolicy) Client {
+	return &retryClient{Client: c, policy: p, sleep: time.Sleep, now: time.Now}
+}
+
+type retryClient struct {
+	Client
+	policy RetryPolicy
+	sleep  func(time.Duration)
+	now    func() time.Time
+}
+
+func (rc *retryClient) Do(act Action) (res *Result, err error) {
+	var deadline time.Time
+	if rc.policy.Timeout > 0 {
+		deadline = rc.now().Add(rc.policy.Timeout)
+	}
+
+	res, err = rc.Client.Do(act)
+	for attempt := 1; err != nil && attempt < rc.policy.Attempts && IsTransientError(err) && retrySafe(act); attempt++ {
+		d := rc.policy.delay(attempt)
+		if !deadline.IsZero() && !rc.now().Add(d).Before(deadline) {
+			return
+		}
+
+		log.Infof("Failed %v, retrying in %v: %v", act, d, err)
+		rc.sleep(d)
+		res, err = rc.retry(act, deadline)
+	}
+	return
+}
+
+// retry makes the Action again, giving up once the deadline, if any, is
+// reached. The attempt is then left to complete on its own, bounded by the
+// action timeout of the Client.
+func (rc *retryClient) retry(act Action, deadline time.Time) (*Result, error) {
+	if deadline.IsZero() {
+		return rc.Client.Do(act)
+	}
+
+	type re struct {
+		res *Result
+		err error
+	}
+	result := make(chan re, 1)
+	go func() {
+		r, e := rc.Client.Do(act)
+		result <- re{r, e}
+	}()
+
+	select {
+	case <-time.After(deadline.Sub(rc.now())):
+		return nil, timeoutError{}
+	case r := <-result:
+		return r.res, r.err
+	}
+}
+
+// IsTransientError determines whether an error returned by a Client may
+// clear up if the Action is made again: the action timeout being reached,
+// a temporary network failure or a watch cut short by etcd. Anything else,
+// such as a missing key, a failed comparison or a response that could not
+// be decoded, is permanent.
+func IsTransientError(err error) bool {
+	switch e := err.(type) {
+	case Error:
+		return e.ErrorCode == errorWatcherCleared
+	case net.Error:
+		return e.Timeout() || e.Temporary()
+	}
+	return false
+}
+
+// retrySafe determines whether the given Action may be made again after
+// an attempt whose outcome is unknown, as it may well have succeeded.
+// Creates and comparisons against the previous value of a key would then
+// spuriously fail, so they are never retried.
+func retrySafe(act Action) bool {
+	switch a := act.(type) {
+	case *Get, *Watch, *Update:
+		return true
+	case *Set:
+		return a.PreviousIndex == 0 && a.PreviousValue == ""
+	case *Delete:
+		return a.PreviousIndex == 0 && a.PreviousValue == ""
+	}
+	return false
+}
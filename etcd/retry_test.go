@@ -0,0 +1,170 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// failingClient fails each Action with the given errors in turn, then
+// succeeds
+type failingClient struct {
+	errs  []error
+	calls int
+}
+
+func (fc *failingClient) Do(Action) (*Result, error) {
+	fc.calls++
+	if len(fc.errs) > 0 {
+		err := fc.errs[0]
+		fc.errs = fc.errs[1:]
+		return nil, err
+	}
+	return &Result{Action: "get"}, nil
+}
+
+func (fc *failingClient) Wait(Action, <-chan struct{}) (*Result, error) {
+	return nil, errors.New("unimplemented")
+}
+
+// temporaryError is a temporary network failure
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "connection reset" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+func TestRetryClient(t *testing.T) {
+	timeout := timeoutError{}
+	cleared := Error{ErrorCode: errorWatcherCleared}
+	notFound := Error{ErrorCode: ErrorKeyNotFound}
+
+	tests := []struct {
+		act     Action
+		errs    []error
+		calls   int
+		success bool
+	}{
+		// success needs no retry
+		{&Get{Key: "/foo"}, nil, 1, true},
+		// transient errors are retried
+		{&Get{Key: "/foo"}, []error{timeout, cleared}, 3, true},
+		{&Get{Key: "/foo"}, []error{temporaryError{}}, 2, true},
+		// until the attempts run out
+		{&Get{Key: "/foo"}, []error{timeout, timeout, timeout}, 3, false},
+		// permanent errors are returned at once
+		{&Get{Key: "/foo"}, []error{notFound}, 1, false},
+		{&Get{Key: "/foo"}, []error{errors.New("invalid character 'x' looking for beginning of value")}, 1, false},
+		// unconditional writes are retried
+		{&Set{Key: "/foo", Value: "bar"}, []error{timeout}, 2, true},
+		{&Delete{Key: "/foo"}, []error{timeout}, 2, true},
+		// but creates and comparisons are not
+		{&Create{Key: "/foo", Value: "bar"}, []error{timeout}, 1, false},
+		{&Set{Key: "/foo", Value: "bar", PreviousIndex: 4}, []error{timeout}, 1, false},
+		{&Delete{Key: "/foo", PreviousValue: "bar"}, []error{timeout}, 1, false},
+	}
+
+	for i, tt := range tests {
+		fc := &failingClient{errs: tt.errs}
+		var slept []time.Duration
+		rc := &retryClient{
+			Client: fc,
+			policy: RetryPolicy{Attempts: 3, Backoff: 100 * time.Millisecond, MaxBackoff: time.Second},
+			sleep:  func(d time.Duration) { slept = append(slept, d) },
+			now:    time.Now,
+		}
+
+		res, err := rc.Do(tt.act)
+		if tt.success != (err == nil && res != nil) {
+			t.Errorf("case %d: unexpected result %v, error %v", i, res, err)
+		}
+		if fc.calls != tt.calls {
+			t.Errorf("case %d: expected %d attempts, got %d", i, tt.calls, fc.calls)
+		}
+		if len(slept) != tt.calls-1 {
+			t.Errorf("case %d: expected %d delays, got %v", i, tt.calls-1, slept)
+		}
+	}
+}
+
+func TestRetryClientTimeout(t *testing.T) {
+	now := time.Now()
+	fc := &failingClient{errs: []error{temporaryError{}, temporaryError{}, temporaryError{}}}
+	var slept []time.Duration
+	rc := &retryClient{
+		Client: fc,
+		policy: RetryPolicy{Attempts: 5, Backoff: 100 * time.Millisecond, MaxBackoff: time.Second, Timeout: 500 * time.Millisecond},
+		sleep: func(d time.Duration) {
+			slept = append(slept, d)
+			now = now.Add(d)
+		},
+		now: func() time.Time { return now },
+	}
+
+	// no retry is made once its delay would reach the timeout
+	if _, err := rc.Do(&Get{Key: "/foo"}); err == nil {
+		t.Fatalf("expected error once the timeout is reached")
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}
+	if fc.calls != 3 || !reflect.DeepEqual(want, slept) {
+		t.Fatalf("expected 3 attempts after delays %v, got %d after %v", want, fc.calls, slept)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	var got []time.Duration
+	for retry := 1; retry <= 4; retry++ {
+		got = append(got, p.delay(retry))
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("expected delays %v, got %v", want, got)
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.delay(1); d < 50*time.Millisecond || d > 100*time.Millisecond {
+			t.Fatalf("delay %v outside of jitter range", d)
+		}
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{timeoutError{}, true},
+		{temporaryError{}, true},
+		{Error{ErrorCode: errorWatcherCleared}, true},
+		{Error{ErrorCode: ErrorKeyNotFound}, false},
+		{Error{ErrorCode: ErrorNodeExist}, false},
+		{errors.New("unable to build request"), false},
+		{&url.Error{Op: "Get", URL: "http://%zz", Err: errors.New("invalid URL escape")}, false},
+		{&json.SyntaxError{}, false},
+	}
+	for i, tt := range tests {
+		if got := IsTransientError(tt.err); got != tt.transient {
+			t.Errorf("case %d: expected %t for %v, got %t", i, tt.transient, tt.err, got)
+		}
+	}
+}
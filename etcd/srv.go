@@ -0,0 +1,109 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/fleet/log"
+)
+
+const (
+	// srvRediscoverInterval is the least amount of time between two
+	// lookups of the SRV records of the discovery domain, so that an
+	// outage of etcd does not also flood DNS
+	srvRediscoverInterval = 10 * time.Second
+)
+
+// lookupSRV is replaced in tests
+var lookupSRV = net.LookupSRV
+
+// DiscoverEndpoints returns the etcd endpoints named by the SRV records of
+// the given domain, as etcd's own --discovery-srv does: https endpoints
+// from _etcd-client-ssl._tcp records, followed by http endpoints from
+// _etcd-client._tcp records.
+func DiscoverEndpoints(domain string) ([]string, error) {
+	var endpoints []string
+	var errs []string
+	for _, svc := range []struct {
+		service string
+		scheme  string
+	}{
+		{"etcd-client-ssl", "https"},
+		{"etcd-client", "http"},
+	} {
+		_, addrs, err := lookupSRV(svc.service, "tcp", domain)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		for _, srv := range addrs {
+			host := strings.TrimSuffix(srv.Target, ".")
+			endpoints = append(endpoints, fmt.Sprintf("%s://%s", svc.scheme, net.JoinHostPort(host, fmt.Sprint(srv.Port))))
+		}
+	}
+
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no etcd endpoints found in SRV records of %s: %s", domain, strings.Join(errs, "; "))
+	}
+	return endpoints, nil
+}
+
+// srvDiscovery tracks the domain whose SRV records a client rediscovers
+// its endpoints from
+type srvDiscovery struct {
+	domain string
+	last   time.Time
+}
+
+// SetEndpointDiscovery has the client look its endpoints up again in the
+// SRV records of the given domain whenever none of them is usable, so
+// that etcd members may be replaced without reconfiguring fleet. The
+// endpoints the client was created with are kept until a lookup succeeds.
+func (c *client) SetEndpointDiscovery(domain string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.discovery = &srvDiscovery{domain: domain, last: time.Now()}
+}
+
+// rediscover replaces the endpoints of the client with those currently
+// named by the SRV records of its discovery domain, if any
+func (c *client) rediscover() {
+	c.mu.Lock()
+	d := c.discovery
+	if d == nil || time.Since(d.last) < srvRediscoverInterval {
+		c.mu.Unlock()
+		return
+	}
+	d.last = time.Now()
+	c.mu.Unlock()
+
+	endpoints, err := DiscoverEndpoints(d.domain)
+	if err == nil {
+		var parsed []url.URL
+		if parsed, err = parseEndpoints(endpoints); err == nil {
+			c.mu.Lock()
+			c.endpoints = parsed
+			c.mu.Unlock()
+			log.Infof("Rediscovered etcd endpoints %v from SRV records of %s", endpoints, d.domain)
+			return
+		}
+	}
+	log.Errorf("Failed rediscovering etcd endpoints from SRV records of %s: %v", d.domain, err)
+}
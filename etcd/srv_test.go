@@ -0,0 +1,106 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func stubLookupSRV(records map[string][]*net.SRV) func() {
+	orig := lookupSRV
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		addrs, ok := records["_"+service+"._"+proto+"."+name]
+		if !ok {
+			return "", nil, errors.New("no such host")
+		}
+		return "", addrs, nil
+	}
+	return func() { lookupSRV = orig }
+}
+
+func TestDiscoverEndpoints(t *testing.T) {
+	defer stubLookupSRV(map[string][]*net.SRV{
+		"_etcd-client-ssl._tcp.example.com": {
+			{Target: "a.example.com.", Port: 2379},
+		},
+		"_etcd-client._tcp.example.com": {
+			{Target: "b.example.com.", Port: 4001},
+			{Target: "c.example.com.", Port: 4001},
+		},
+	})()
+
+	got, err := DiscoverEndpoints("example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		"https://a.example.com:2379",
+		"http://b.example.com:4001",
+		"http://c.example.com:4001",
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("expected endpoints %v, got %v", want, got)
+	}
+
+	if _, err := DiscoverEndpoints("example.org"); err == nil {
+		t.Errorf("expected error for domain without SRV records")
+	}
+}
+
+func TestClientRediscover(t *testing.T) {
+	records := map[string][]*net.SRV{
+		"_etcd-client._tcp.example.com": {
+			{Target: "new.example.com.", Port: 4001},
+		},
+	}
+	defer stubLookupSRV(records)()
+
+	c, err := NewClient([]string{"http://old.example.com:4001"}, &http.Transport{}, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// without discovery, endpoints never change
+	c.rediscover()
+	if got := c.currentEndpoints()[0].Host; got != "old.example.com:4001" {
+		t.Fatalf("expected endpoint to be unchanged, got %s", got)
+	}
+
+	// lookups are rate limited
+	c.SetEndpointDiscovery("example.com")
+	c.rediscover()
+	if got := c.currentEndpoints()[0].Host; got != "old.example.com:4001" {
+		t.Fatalf("expected endpoint to be unchanged, got %s", got)
+	}
+
+	c.discovery.last = time.Now().Add(-srvRediscoverInterval)
+	c.rediscover()
+	if got := c.currentEndpoints()[0].Host; got != "new.example.com:4001" {
+		t.Fatalf("expected rediscovered endpoint, got %s", got)
+	}
+
+	// a failed lookup keeps the known endpoints
+	delete(records, "_etcd-client._tcp.example.com")
+	c.discovery.last = time.Now().Add(-srvRediscoverInterval)
+	c.rediscover()
+	if got := c.currentEndpoints()[0].Host; got != "new.example.com:4001" {
+		t.Fatalf("expected endpoint to be kept, got %s", got)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/coreos/fleet/log"
+)
+
+// TransportConfig tunes the HTTP transport through which a client reaches
+// etcd. Zero values keep the defaults of net/http.
+type TransportConfig struct {
+	// MaxIdleConnsPerHost is the number of connections to each etcd
+	// endpoint kept open for reuse between requests
+	MaxIdleConnsPerHost int
+
+	// DialTimeout bounds the time taken to establish a connection
+	DialTimeout time.Duration
+
+	// KeepAlive is the interval between TCP keepalive probes on open
+	// connections, so that connections silently dropped by the network
+	// are noticed
+	KeepAlive time.Duration
+}
+
+// NewTransport creates an http.Transport configured by tc, securing
+// connections with the given TLS configuration
+func NewTransport(tc TransportConfig, tlsConfig *tls.Config) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   tc.DialTimeout,
+		KeepAlive: tc.KeepAlive,
+	}
+	return &http.Transport{
+		Dial:                dialer.Dial,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConnsPerHost: tc.MaxIdleConnsPerHost,
+	}
+}
+
+// SetEndpointRotation has the client move an endpoint to the back of its
+// list of endpoints once requests to it have failed the given number of
+// times in a row, so that subsequent requests go to healthier endpoints
+// first rather than waiting on the broken one each time.
+func (c *client) SetEndpointRotation(failures int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rotateAfter = failures
+	c.failures = make(map[string]int)
+}
+
+// endpointFailed records that no usable Result could be got from the given
+// endpoint, rotating it to the back of the endpoints if need be
+func (c *client) endpointFailed(ep url.URL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rotateAfter <= 0 {
+		return
+	}
+
+	key := ep.String()
+	c.failures[key]++
+	if c.failures[key] < c.rotateAfter {
+		return
+	}
+	delete(c.failures, key)
+
+	// the endpoints may be read concurrently, so are replaced
+	// rather than modified in place
+	rotated := make([]url.URL, 0, len(c.endpoints))
+	var found bool
+	for _, e := range c.endpoints {
+		if e.String() == key {
+			found = true
+			continue
+		}
+		rotated = append(rotated, e)
+	}
+	if !found || len(rotated) == 0 {
+		return
+	}
+	c.endpoints = append(rotated, ep)
+	log.Infof("Moved etcd endpoint %s to the back of the endpoints after %d consecutive failures", key, c.rotateAfter)
+}
+
+// endpointSucceeded records that the given endpoint yielded a Result
+func (c *client) endpointSucceeded(ep url.URL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rotateAfter <= 0 {
+		return
+	}
+	delete(c.failures, ep.String())
+}
@@ -0,0 +1,57 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEndpointRotation(t *testing.T) {
+	c, err := NewClient([]string{"http://a:4001", "http://b:4001"}, &http.Transport{}, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.SetEndpointRotation(2)
+
+	var hosts []string
+	rf := func(req *http.Request, cancel <-chan struct{}) (*http.Response, []byte, error) {
+		hosts = append(hosts, req.URL.Host)
+		if req.URL.Host == "a:4001" {
+			return nil, nil, errors.New("unreachable")
+		}
+		return &http.Response{StatusCode: http.StatusOK}, []byte(`{"action":"get","node":{"key":"/foo","value":"bar"}}`), nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.resolve(&Get{Key: "/foo"}, rf, make(chan struct{})); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// a is tried first until it has failed twice, after which b is
+	// preferred
+	want := []string{"a:4001", "b:4001", "a:4001", "b:4001", "b:4001"}
+	if len(hosts) != len(want) {
+		t.Fatalf("expected requests to %v, got %v", want, hosts)
+	}
+	for i := range want {
+		if hosts[i] != want[i] {
+			t.Fatalf("expected requests to %v, got %v", want, hosts)
+		}
+	}
+}
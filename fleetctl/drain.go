@@ -0,0 +1,160 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/schema"
+)
+
+var (
+	cmdCordon = &Command{
+		Name:    "cordon",
+		Summary: "Stop new units from being scheduled to a machine",
+		Usage:   "MACHINE",
+		Description: `Marks the given machine unschedulable. Units already scheduled to it keep
+running there, but no new units are placed on it until it is uncordoned.
+Global units are not affected.
+
+Keep new units off a machine:
+	fleetctl cordon 2c8b2e1a`,
+		Run: runCordon,
+	}
+
+	cmdDrain = &Command{
+		Name:    "drain",
+		Summary: "Move the units of a machine elsewhere ahead of maintenance",
+		Usage:   "[--no-block] [--block-attempts=N] MACHINE",
+		Description: `Marks the given machine unschedulable and has the engine move the units
+scheduled to it to other machines. Global units are not affected, and units
+unable to run anywhere else, as they require the machine or share it with
+such a unit, stay where they are. The machine remains unschedulable, even
+across restarts, until it is uncordoned.
+
+By default, drain waits until the units have been moved:
+	fleetctl drain 2c8b2e1a`,
+		Run: runDrain,
+	}
+
+	cmdUncordon = &Command{
+		Name:    "uncordon",
+		Summary: "Allow units to be scheduled to a machine again",
+		Usage:   "MACHINE",
+		Description: `Marks the given cordoned or drained machine schedulable again. Units moved
+away from it while draining are not moved back.
+
+Return a machine to service after maintenance:
+	fleetctl uncordon 2c8b2e1a`,
+		Run: runUncordon,
+	}
+)
+
+func init() {
+	cmdDrain.Flags.IntVar(&sharedFlags.BlockAttempts, "block-attempts", 0, "Wait until the units have been moved, performing up to N attempts before giving up. A value of 0 indicates no limit.")
+	cmdDrain.Flags.BoolVar(&sharedFlags.NoBlock, "no-block", false, "Do not wait until the units have been moved before exiting.")
+}
+
+func runCordon(args []string) (exit int) {
+	_, exit = setSchedulability(args, machine.Cordoned)
+	return
+}
+
+func runUncordon(args []string) (exit int) {
+	_, exit = setSchedulability(args, machine.Schedulable)
+	return
+}
+
+func runDrain(args []string) (exit int) {
+	ms, exit := setSchedulability(args, machine.Draining)
+	if exit != 0 || sharedFlags.NoBlock {
+		return
+	}
+
+	if err := waitForDrain(ms.ID, sharedFlags.BlockAttempts); err != nil {
+		stderr("%v", err)
+		return 1
+	}
+	stdout("Machine %s drained", ms.ShortID())
+	return
+}
+
+func setSchedulability(args []string, s machine.Schedulability) (*machine.MachineState, int) {
+	if len(args) != 1 {
+		stderr("One machine must be provided.")
+		return nil, 1
+	}
+
+	ms, err := findMachine(args[0])
+	if err != nil {
+		stderr("%v", err)
+		return nil, 1
+	}
+	if err := cAPI.SetMachineSchedulability(ms.ID, s); err != nil {
+		stderr("Error setting schedulability of Machine(%s): %v", ms.ID, err)
+		return nil, 1
+	}
+	return ms, 0
+}
+
+// findMachine returns the active Machine matching the given full or short ID
+func findMachine(id string) (*machine.MachineState, error) {
+	machines, err := cAPI.Machines()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving list of active machines: %v", err)
+	}
+	for _, ms := range machines {
+		if ms.MatchID(id) {
+			return &ms, nil
+		}
+	}
+	return nil, fmt.Errorf("machine %s not found", id)
+}
+
+// waitForDrain waits until no movable Unit remains scheduled to the given
+// Machine, performing up to maxAttempts attempts, or any number if zero
+func waitForDrain(machID string, maxAttempts int) error {
+	for attempt := 0; maxAttempts < 1 || attempt < maxAttempts; attempt++ {
+		units, err := cAPI.Units()
+		if err != nil {
+			return fmt.Errorf("error retrieving list of units: %v", err)
+		}
+		if len(movableUnits(units, machID)) == 0 {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return fmt.Errorf("timed out waiting for Machine(%s) to drain", machID)
+}
+
+// movableUnits returns the names of the Units scheduled to the given
+// Machine that a draining Machine does not keep
+func movableUnits(units []*schema.Unit, machID string) []string {
+	var names []string
+	for _, u := range units {
+		if u.MachineID != machID {
+			continue
+		}
+		j := job.Job{Name: u.Name, Unit: *schema.MapSchemaUnitOptionsToUnitFile(u.Options)}
+		if _, ok := j.RequiredTarget(); ok || len(j.Peers()) > 0 {
+			continue
+		}
+		names = append(names, u.Name)
+	}
+	return names
+}
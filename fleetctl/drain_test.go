@@ -0,0 +1,69 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/schema"
+)
+
+func TestSetSchedulability(t *testing.T) {
+	reg := registry.NewFakeRegistry()
+	reg.SetMachines([]machine.MachineState{{ID: "abcdef0123456789"}})
+	cAPI = &client.RegistryClient{Registry: reg}
+
+	if code := runCordon([]string{"missing"}); code == 0 {
+		t.Errorf("expected cordoning an unknown machine to fail")
+	}
+
+	for _, tt := range []struct {
+		run  func([]string) int
+		want machine.Schedulability
+	}{
+		{runCordon, machine.Cordoned},
+		{runUncordon, machine.Schedulable},
+	} {
+		if code := tt.run([]string{"abcdef01"}); code != 0 {
+			t.Fatalf("expected success, got exit code %d", code)
+		}
+		machines, _ := reg.Machines()
+		if got := machines[0].Schedulability; got != tt.want {
+			t.Errorf("expected schedulability %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestMovableUnits(t *testing.T) {
+	opt := func(name, value string) []*schema.UnitOption {
+		return []*schema.UnitOption{{Section: "X-Fleet", Name: name, Value: value}}
+	}
+	units := []*schema.Unit{
+		{Name: "app.service", MachineID: "XXX"},
+		{Name: "pinned.service", MachineID: "XXX", Options: opt("MachineID", "XXX")},
+		{Name: "sidecar.service", MachineID: "XXX", Options: opt("MachineOf", "pinned.service")},
+		{Name: "other.service", MachineID: "YYY"},
+		{Name: "global.service", Options: opt("Global", "true")},
+	}
+
+	want := []string{"app.service"}
+	if got := movableUnits(units, "XXX"); !reflect.DeepEqual(want, got) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+var cmdExport = &Command{
+	Name:    "export",
+	Summary: "Write a snapshot of the cluster to a file",
+	Usage:   "[FILE]",
+	Description: `Write a snapshot of what operators set in the cluster to the given file, or to
+standard output if none is given: every unit along with its desired state, the
+rolling updates in progress, and the metadata and schedulability set on
+machines at runtime. The file is portable JSON, from which restore recreates
+the cluster in another or a recovered one.
+
+Back up the cluster:
+	fleetctl export fleet-backup.json`,
+	Run: runExport,
+}
+
+func runExport(args []string) (exit int) {
+	if len(args) > 1 {
+		stderr("One file must be provided at most.")
+		return 1
+	}
+
+	snap, err := cAPI.Snapshot()
+	if err != nil {
+		stderr("Error taking snapshot of the cluster: %v", err)
+		return 1
+	}
+
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		stderr("Error encoding cluster: %v", err)
+		return 1
+	}
+	b = append(b, '\n')
+
+	if len(args) == 0 {
+		os.Stdout.Write(b)
+		return 0
+	}
+	if err := ioutil.WriteFile(args[0], b, 0600); err != nil {
+		stderr("Error writing cluster to %s: %v", args[0], err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,130 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestExportRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fleetctl-export")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cluster.json")
+
+	uf, _ := unit.NewUnitFile("[Service]\nExecStart=/bin/true")
+	units := []job.Unit{
+		{Name: "bar.service", Unit: *uf, TargetState: job.JobStateLaunched},
+		{Name: "foo.service", Unit: *uf, TargetState: job.JobStateInactive},
+	}
+	reg := registry.NewFakeRegistry()
+	reg.SetJobs([]job.Job{
+		{Name: "bar.service", Unit: *uf, TargetState: job.JobStateLaunched, TargetMachineID: "XXX"},
+		{Name: "foo.service", Unit: *uf, TargetState: job.JobStateInactive},
+	})
+	reg.SetMachines([]machine.MachineState{{ID: "XXX", Metadata: map[string]string{"ping": "pong"}}})
+	reg.SetMachineMetadata("XXX", "rack", "2")
+	reg.SetMachineSchedulability("XXX", machine.Cordoned)
+	reg.CreateRollout(&job.Rollout{Template: "baz@.service", Unit: *uf, BatchSize: 2, State: job.RolloutStateRunning})
+	reg.CreateRollout(&job.Rollout{Template: "qux@.service", Unit: *uf, BatchSize: 1, State: job.RolloutStateComplete})
+	cAPI = &client.RegistryClient{Registry: reg}
+
+	if code := runExport([]string{file}); code != 0 {
+		t.Fatalf("export failed with exit code %d", code)
+	}
+
+	// restoring into a fresh cluster recreates the units in their
+	// desired states, leaving those which exist untouched, along with
+	// the running rollouts and what was set on machines at runtime
+	fresh := registry.NewFakeRegistry()
+	fresh.SetJobs([]job.Job{{Name: "foo.service", Unit: *uf, TargetState: job.JobStateLoaded}})
+	cAPI = &client.RegistryClient{Registry: fresh}
+	if code := runRestore([]string{file}); code != 0 {
+		t.Fatalf("restore failed with exit code %d", code)
+	}
+
+	got, err := fresh.Units()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	units[1].TargetState = job.JobStateLoaded
+	if !reflect.DeepEqual(units, got) {
+		t.Fatalf("expected restored units %v, got %v", units, got)
+	}
+	if su, _ := fresh.ScheduledUnit("bar.service"); su == nil || su.TargetMachineID != "" {
+		t.Fatalf("expected the schedule not to be restored, got %v", su)
+	}
+
+	rollouts, _ := fresh.Rollouts()
+	if len(rollouts) != 1 || rollouts[0].Template != "baz@.service" || rollouts[0].BatchSize != 2 || rollouts[0].State != job.RolloutStateRunning {
+		t.Fatalf("expected the running rollout alone to be restored, got %v", rollouts)
+	}
+	if md, _ := fresh.MachineMetadata("XXX"); !reflect.DeepEqual(md, map[string]string{"rack": "2"}) {
+		t.Fatalf("expected the runtime metadata alone to be restored, got %v", md)
+	}
+	fresh.SetMachines([]machine.MachineState{{ID: "XXX"}})
+	if ms, _ := fresh.Machines(); ms[0].Schedulability != machine.Cordoned {
+		t.Fatalf("expected the schedulability to be restored, got %q", ms[0].Schedulability)
+	}
+}
+
+func TestRestoreVersion1(t *testing.T) {
+	f, err := ioutil.TempFile("", "fleetctl-export")
+	if err != nil {
+		t.Fatalf("failed creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"version": 1, "units": [{"name": "foo.service", "options": [], "desiredState": "loaded"}], "machines": [{"ID": "XXX", "Metadata": {"ping": "pong"}}]}`)
+	f.Close()
+
+	reg := registry.NewFakeRegistry()
+	cAPI = &client.RegistryClient{Registry: reg}
+	if code := runRestore([]string{f.Name()}); code != 0 {
+		t.Fatalf("restore failed with exit code %d", code)
+	}
+	if u, _ := reg.Unit("foo.service"); u == nil || u.TargetState != job.JobStateLoaded {
+		t.Fatalf("expected foo.service to be restored, got %v", u)
+	}
+	if md, _ := reg.MachineMetadata("XXX"); len(md) != 0 {
+		t.Fatalf("expected the machines of a version 1 export not to be restored, got %v", md)
+	}
+}
+
+func TestRestoreBadVersion(t *testing.T) {
+	f, err := ioutil.TempFile("", "fleetctl-export")
+	if err != nil {
+		t.Fatalf("failed creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"version": 99, "units": []}`)
+	f.Close()
+
+	cAPI = &client.RegistryClient{Registry: registry.NewFakeRegistry()}
+	if code := runRestore([]string{f.Name()}); code == 0 {
+		t.Fatalf("expected restoring an unknown export version to fail")
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/user"
 	"path"
 	"strings"
 	"sync"
@@ -39,6 +40,7 @@ import (
 	"github.com/coreos/fleet/pkg"
 	"github.com/coreos/fleet/registry"
 	"github.com/coreos/fleet/schema"
+	"github.com/coreos/fleet/sign"
 	"github.com/coreos/fleet/ssh"
 	"github.com/coreos/fleet/unit"
 	"github.com/coreos/fleet/version"
@@ -79,6 +81,9 @@ var (
 		ExperimentalAPI bool
 		Endpoint        string
 		RequestTimeout  float64
+		ReadOnly        bool
+		Audit           bool
+		Output          string
 
 		KeyFile  string
 		CertFile string
@@ -90,7 +95,18 @@ var (
 		SSHTimeout            float64
 		SSHUserName           string
 
-		EtcdKeyPrefix string
+		EtcdKeyPrefix    string
+		Namespace        string
+		Federation       string
+		EtcdUsername     string
+		EtcdPassword     string
+		EtcdPasswordFile string
+
+		EtcdCompressUnits bool
+		EtcdRequestRate   float64
+		EtcdRequestBurst  int
+
+		UnitEncryptionKeyFile string
 	}{}
 
 	// flags used by multiple commands
@@ -119,7 +135,20 @@ func init() {
 	globalFlagset.BoolVar(&globalFlags.Version, "version", false, "Print the version and exit")
 	globalFlagset.StringVar(&globalFlags.ClientDriver, "driver", clientDriverEtcd, fmt.Sprintf("Adapter used to execute fleetctl commands. Options include %q and %q.", clientDriverAPI, clientDriverEtcd))
 	globalFlagset.StringVar(&globalFlags.Endpoint, "endpoint", "http://127.0.0.1:4001", fmt.Sprintf("Location of the fleet API if --driver=%s. Alternatively, if --driver=%s, location of the etcd API.", clientDriverAPI, clientDriverEtcd))
-	globalFlagset.StringVar(&globalFlags.EtcdKeyPrefix, "etcd-key-prefix", registry.DefaultKeyPrefix, "Keyspace for fleet data in etcd (development use only!)")
+	globalFlagset.BoolVar(&globalFlags.ReadOnly, "read-only", false, "Refuse to make any changes to the cluster, only allowing it to be inspected.")
+	globalFlagset.StringVar(&globalFlags.Output, "output", "", fmt.Sprintf("Print the lists of units, unit files and machines as %q or %q rather than as tables.", outputJSON, outputYAML))
+	globalFlagset.StringVar(&globalFlags.Output, "o", "", "Shorthand for --output")
+	globalFlagset.BoolVar(&globalFlags.Audit, "audit", false, "Record each change made to a unit in the audit log of the cluster if --driver=etcd, as fleetd does with audit_log enabled.")
+	globalFlagset.StringVar(&globalFlags.EtcdKeyPrefix, "etcd-key-prefix", registry.DefaultKeyPrefix, "Keyspace for fleet data in etcd, which must match the etcd_key_prefix of fleetd if --driver=etcd.")
+	globalFlagset.StringVar(&globalFlags.Namespace, "namespace", "", "Namespace of the fleet cluster to manage if --driver=etcd.")
+	globalFlagset.StringVar(&globalFlags.Federation, "federation", "", "Comma-separated list of <cluster>=<etcd endpoint> pairs to manage as one if --driver=etcd, in place of --endpoint.")
+	globalFlagset.StringVar(&globalFlags.EtcdUsername, "etcd-username", "", "Username used to authenticate to etcd if --driver=etcd.")
+	globalFlagset.StringVar(&globalFlags.EtcdPassword, "etcd-password", "", "Password used to authenticate to etcd if --driver=etcd.")
+	globalFlagset.StringVar(&globalFlags.EtcdPasswordFile, "etcd-password-file", "", "File holding the password used to authenticate to etcd if --driver=etcd.")
+	globalFlagset.BoolVar(&globalFlags.EtcdCompressUnits, "etcd-compress-units", false, "Store unit files gzipped in etcd if --driver=etcd. Only use once every fleetd in the cluster supports it.")
+	globalFlagset.StringVar(&globalFlags.UnitEncryptionKeyFile, "unit-encryption-key-file", "", "File holding the hex-encoded AES key with which unit files are encrypted in etcd if --driver=etcd, which must match the unit_encryption_key_file of fleetd.")
+	globalFlagset.Float64Var(&globalFlags.EtcdRequestRate, "etcd-request-rate", 0.0, "Greatest average number of etcd requests per second to make if --driver=etcd. 0 means no limit.")
+	globalFlagset.IntVar(&globalFlags.EtcdRequestBurst, "etcd-request-burst", 10, "Number of etcd requests which may be made at once in excess of --etcd-request-rate.")
 
 	globalFlagset.StringVar(&globalFlags.KeyFile, "key-file", "", "Location of TLS key file used to secure communication with the fleet API or etcd")
 	globalFlagset.StringVar(&globalFlags.CertFile, "cert-file", "", "Location of TLS cert file used to secure communication with the fleet API or etcd")
@@ -155,20 +184,34 @@ func init() {
 	out.Init(os.Stdout, 0, 8, 1, '\t', 0)
 	commands = []*Command{
 		cmdCatUnit,
+		cmdCordon,
 		cmdDestroyUnit,
+		cmdDrain,
+		cmdExport,
 		cmdFDForward,
 		cmdHelp,
+		cmdHistory,
 		cmdJournal,
+		cmdListDecisions,
 		cmdListMachines,
 		cmdListUnitFiles,
 		cmdListUnits,
 		cmdLoadUnits,
+		cmdMinVersion,
+		cmdRestore,
+		cmdRevert,
+		cmdRollingRestart,
+		cmdRollingUpdate,
+		cmdSetMetadata,
 		cmdSSH,
 		cmdStartUnit,
 		cmdStatusUnits,
 		cmdStopUnit,
 		cmdSubmitUnit,
+		cmdTop,
+		cmdUncordon,
 		cmdUnloadUnit,
+		cmdUnsetMetadata,
 		cmdVerifyUnit,
 		cmdVersion,
 	}
@@ -228,6 +271,11 @@ func main() {
 		log.EnableDebug()
 	}
 
+	if err := validateOutputFormat(globalFlags.Output); err != nil {
+		stderr("%v", err)
+		os.Exit(2)
+	}
+
 	if globalFlags.Version {
 		args = []string{"version"}
 	} else if len(args) < 1 || globalFlags.Help {
@@ -254,11 +302,6 @@ func main() {
 		os.Exit(2)
 	}
 
-	if sharedFlags.Sign {
-		stderr("WARNING: The signed/verified units feature is DEPRECATED and cannot be used.")
-		os.Exit(2)
-	}
-
 	if cmd.Name != "help" && cmd.Name != "version" {
 		var err error
 		cAPI, err = getClient()
@@ -296,6 +339,14 @@ func getFlagsFromEnv(prefix string, fs *flag.FlagSet) {
 
 // getClient initializes a client of fleet based on CLI flags
 func getClient() (client.API, error) {
+	api, err := getDriverClient()
+	if err != nil || !globalFlags.ReadOnly {
+		return api, err
+	}
+	return client.ReadOnlyAPI{API: api}, nil
+}
+
+func getDriverClient() (client.API, error) {
 	// The user explicitly set --experimental-api=true, so it trumps the
 	// --driver flag. This behavior exists for backwards-compatibility.
 	if globalFlags.ExperimentalAPI {
@@ -423,20 +474,89 @@ func getRegistryClient() (client.API, error) {
 		TLSClientConfig: tlsConfig,
 	}
 
-	timeout := getRequestTimeoutFlag()
-	machines := []string{globalFlags.Endpoint}
-	eClient, err := etcd.NewClient(machines, trans, timeout)
+	prefix, err := registry.NamespaceKeyPrefix(globalFlags.EtcdKeyPrefix, globalFlags.Namespace)
 	if err != nil {
 		return nil, err
 	}
 
-	reg := registry.NewEtcdRegistry(eClient, globalFlags.EtcdKeyPrefix)
+	timeout := getRequestTimeoutFlag()
+	newRegistry := func(endpoint string) (registry.Registry, error) {
+		eClient, err := etcd.NewClient([]string{endpoint}, trans, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if globalFlags.EtcdUsername != "" {
+			creds := etcd.StaticCredentials(globalFlags.EtcdUsername, globalFlags.EtcdPassword)
+			if globalFlags.EtcdPasswordFile != "" {
+				creds = etcd.PasswordFileCredentials(globalFlags.EtcdUsername, globalFlags.EtcdPasswordFile)
+			}
+			eClient.SetCredentials(creds)
+		}
+
+		var client etcd.Client = eClient
+		if globalFlags.EtcdRequestRate > 0 {
+			client = etcd.NewRateLimitedClient(client, globalFlags.EtcdRequestRate, globalFlags.EtcdRequestBurst)
+		}
+
+		reg := registry.NewEtcdRegistry(client, prefix)
+		reg.SetUnitCompression(globalFlags.EtcdCompressUnits)
+		if globalFlags.UnitEncryptionKeyFile != "" {
+			key, err := registry.ReadUnitEncryptionKeyFile(globalFlags.UnitEncryptionKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if err := reg.SetUnitEncryptionKey(key); err != nil {
+				return nil, err
+			}
+		}
+
+		if msg, ok := checkVersion(reg); !ok {
+			stderr(msg)
+		}
+		if globalFlags.Audit {
+			return registry.NewAuditedRegistry(reg, reg, "", auditIdentity()), nil
+		}
+		return reg, nil
+	}
+
+	if globalFlags.Federation == "" {
+		reg, err := newRegistry(globalFlags.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return &client.RegistryClient{Registry: reg}, nil
+	}
 
-	if msg, ok := checkVersion(reg); !ok {
-		stderr(msg)
+	// each cluster of the federation is reached at its own etcd endpoint
+	fed := registry.NewFederatedRegistry()
+	for _, member := range strings.Split(globalFlags.Federation, ",") {
+		parts := strings.SplitN(member, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid federation member %q, expected <cluster>=<etcd endpoint>", member)
+		}
+		reg, err := newRegistry(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if err := fed.AddCluster(parts[0], reg); err != nil {
+			return nil, err
+		}
 	}
+	return &client.RegistryClient{Registry: fed}, nil
+}
 
-	return &client.RegistryClient{Registry: reg}, nil
+// auditIdentity describes the local user and host, to whom changes made
+// with --audit are attributed
+func auditIdentity() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("fleetctl:%s@%s", name, host)
 }
 
 // getChecker creates and returns a HostKeyChecker, or nil if any error is encountered
@@ -542,6 +662,21 @@ func createUnit(name string, uf *unit.UnitFile) (*schema.Unit, error) {
 	if err := j.ValidateRequirements(); err != nil {
 		log.Warningf("Unit %s: %v", name, err)
 	}
+	// signatures are recorded first, so that no agent sees the Unit
+	// without them
+	if sharedFlags.Sign {
+		signers, err := unitSigners()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get keys to sign unit %s with: %v", name, err)
+		}
+		sigs, err := sign.SignUnit(signers, name, *uf)
+		if err != nil {
+			return nil, fmt.Errorf("failed signing unit %s: %v", name, err)
+		}
+		if err := cAPI.SetUnitSignatures(name, sigs); err != nil {
+			return nil, fmt.Errorf("failed recording signatures of unit %s: %v", name, err)
+		}
+	}
 	err := cAPI.CreateUnit(&u)
 	if err != nil {
 		return nil, fmt.Errorf("failed creating unit %s: %v", name, err)
@@ -0,0 +1,176 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/schema"
+)
+
+var (
+	flagSetHistoryLimit int
+	cmdHistory          = &Command{
+		Name:    "history",
+		Summary: "List the past revisions of a unit",
+		Usage:   "[-l|--full] [--no-legend] [--set-limit=N] [UNIT]",
+		Description: `Lists the revisions retained of the given unit, oldest first: each time it was
+created, destroyed or given a new desired state, along with the hash of its
+unit file at the time. Revisions outlive the unit, and are only recorded once
+a history limit is set for the cluster. A past revision may be brought back
+with revert.
+
+The history limit is kept in the cluster, so that every change is recorded
+whichever client makes it. It is set by fleetd started with a non-zero
+unit_history_limit, or with --set-limit, where 0 stops recording revisions.
+
+Show the revisions of a unit destroyed by mistake:
+	fleetctl history foo.service
+
+Retain the last 10 revisions of each unit:
+	fleetctl history --set-limit=10`,
+		Run: runHistory,
+	}
+
+	cmdRevert = &Command{
+		Name:    "revert",
+		Summary: "Recreate a unit as it was at a past revision",
+		Usage:   "UNIT REVISION",
+		Description: `Recreates the given unit with the unit file and desired state it had at one of
+the revisions listed by history. The unit is destroyed first if it exists,
+stopping it wherever it is running, and may then be scheduled elsewhere.
+
+Bring back the second revision of a unit:
+	fleetctl revert foo.service 2`,
+		Run: runRevert,
+	}
+)
+
+func init() {
+	cmdHistory.Flags.BoolVar(&sharedFlags.Full, "full", false, "Do not ellipsize fields on output")
+	cmdHistory.Flags.BoolVar(&sharedFlags.Full, "l", false, "Shorthand for --full")
+	cmdHistory.Flags.BoolVar(&sharedFlags.NoLegend, "no-legend", false, "Do not print a legend (column headers)")
+	cmdHistory.Flags.IntVar(&flagSetHistoryLimit, "set-limit", -1, "Set the number of revisions retained of each unit in the cluster, 0 recording none.")
+}
+
+func runHistory(args []string) (exit int) {
+	if flagSetHistoryLimit >= 0 {
+		if len(args) != 0 {
+			stderr("No unit may be given with --set-limit.")
+			return 1
+		}
+		if err := cAPI.SetUnitHistoryLimit(flagSetHistoryLimit); err != nil {
+			stderr("Error setting unit history limit: %v", err)
+			return 1
+		}
+		return 0
+	}
+
+	if len(args) != 1 {
+		stderr("One unit must be provided.")
+		return 1
+	}
+
+	name := unitNameMangle(args[0])
+	revs, err := cAPI.UnitHistory(name)
+	if err != nil {
+		stderr("Error retrieving history of Unit(%s): %v", name, err)
+		return 1
+	}
+
+	if !sharedFlags.NoLegend {
+		fmt.Fprintln(out, "REVISION\tTIME\tEVENT\tDSTATE\tHASH")
+	}
+	for _, r := range revs {
+		fmt.Fprintln(out, formatRevision(r, sharedFlags.Full))
+	}
+	out.Flush()
+	return
+}
+
+// formatRevision returns the line describing a single Revision
+func formatRevision(r job.Revision, full bool) string {
+	hash := r.Unit.Hash().Short()
+	if full {
+		hash = r.Unit.Hash().String()
+	}
+	state := string(r.TargetState)
+	if state == "" {
+		state = "-"
+	}
+	return fmt.Sprintf("%d\t%s\t%s\t%s\t%s", r.Number, r.Time.Local().Format(time.RFC3339), r.Event, state, hash)
+}
+
+func runRevert(args []string) (exit int) {
+	if len(args) != 2 {
+		stderr("One unit and one revision must be provided.")
+		return 1
+	}
+
+	name := unitNameMangle(args[0])
+	number, err := strconv.Atoi(args[1])
+	if err != nil {
+		stderr("Invalid revision %q: %v", args[1], err)
+		return 1
+	}
+
+	revs, err := cAPI.UnitHistory(name)
+	if err != nil {
+		stderr("Error retrieving history of Unit(%s): %v", name, err)
+		return 1
+	}
+	var rev *job.Revision
+	for i := range revs {
+		if revs[i].Number == number {
+			rev = &revs[i]
+		}
+	}
+	if rev == nil {
+		stderr("Revision %d of Unit(%s) not found", number, name)
+		return 1
+	}
+	if rev.Event == job.RevisionEventDestroy {
+		stderr("Revision %d records the destruction of Unit(%s), revert to an earlier one", number, name)
+		return 1
+	}
+
+	u, err := cAPI.Unit(name)
+	if err != nil {
+		stderr("Error retrieving Unit(%s) from Registry: %v", name, err)
+		return 1
+	}
+	if u != nil {
+		if err := cAPI.DestroyUnit(name); err != nil {
+			stderr("Error destroying Unit(%s): %v", name, err)
+			return 1
+		}
+	}
+
+	su := schema.Unit{
+		Name:         name,
+		Options:      schema.MapUnitFileToSchemaUnitOptions(&rev.Unit),
+		DesiredState: string(rev.TargetState),
+	}
+	if err := cAPI.CreateUnit(&su); err != nil {
+		stderr("Error recreating Unit(%s): %v", name, err)
+		return 1
+	}
+
+	stdout("Reverted %s to revision %d (%s)", name, number, rev.TargetState)
+	return
+}
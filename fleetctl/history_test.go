@@ -0,0 +1,93 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestFormatRevision(t *testing.T) {
+	at := time.Date(2014, time.October, 15, 10, 30, 0, 0, time.UTC)
+	ts := at.Local().Format(time.RFC3339)
+	uf := newUnitFile(t, "[Service]\nExecStart=/bin/true")
+	short := uf.Hash().Short()
+
+	for i, tt := range []struct {
+		rev  job.Revision
+		want string
+	}{
+		{job.Revision{Number: 1, Time: at, Event: job.RevisionEventCreate, TargetState: job.JobStateLaunched, Unit: *uf}, "1\t" + ts + "\tcreate\tlaunched\t" + short},
+		{job.Revision{Number: 2, Time: at, Event: job.RevisionEventDestroy, Unit: *uf}, "2\t" + ts + "\tdestroy\t-\t" + short},
+	} {
+		if got := formatRevision(tt.rev, false); got != tt.want {
+			t.Errorf("case %d: expected %q, got %q", i, tt.want, got)
+		}
+	}
+}
+
+func TestRevert(t *testing.T) {
+	reg := registry.NewFakeRegistry()
+	reg.SetUnitHistoryLimit(10)
+	cAPI = &client.RegistryClient{Registry: reg}
+
+	first, _ := unit.NewUnitFile("[Service]\nExecStart=/bin/true")
+	second, _ := unit.NewUnitFile("[Service]\nExecStart=/bin/false")
+	reg.CreateUnit(&job.Unit{Name: "foo.service", Unit: *first, TargetState: job.JobStateLaunched})
+	reg.DestroyUnit("foo.service")
+	reg.CreateUnit(&job.Unit{Name: "foo.service", Unit: *second, TargetState: job.JobStateLoaded})
+
+	// neither a destruction nor an unknown revision can be reverted to
+	for _, rev := range []string{"2", "9", "x"} {
+		if code := runRevert([]string{"foo.service", rev}); code == 0 {
+			t.Errorf("expected reverting to revision %s to fail", rev)
+		}
+	}
+
+	if code := runRevert([]string{"foo.service", "1"}); code != 0 {
+		t.Fatalf("revert failed with exit code %d", code)
+	}
+	u, err := reg.Unit("foo.service")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &job.Unit{Name: "foo.service", Unit: *first, TargetState: job.JobStateLaunched}
+	if !reflect.DeepEqual(want, u) {
+		t.Fatalf("expected reverted unit %v, got %v", want, u)
+	}
+}
+
+func TestSetHistoryLimit(t *testing.T) {
+	defer func() { flagSetHistoryLimit = -1 }()
+	reg := registry.NewFakeRegistry()
+	cAPI = &client.RegistryClient{Registry: reg}
+
+	flagSetHistoryLimit = 5
+	if code := runHistory([]string{"foo.service"}); code == 0 {
+		t.Errorf("expected a unit given with --set-limit to fail")
+	}
+	if code := runHistory(nil); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	if limit, _ := reg.UnitHistoryLimit(); limit != 5 {
+		t.Errorf("expected history limit 5, got %d", limit)
+	}
+}
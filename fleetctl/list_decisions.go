@@ -0,0 +1,106 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+)
+
+var (
+	flagCandidates   bool
+	cmdListDecisions = &Command{
+		Name:    "list-decisions",
+		Summary: "List the scheduling decisions the engine made about a unit",
+		Usage:   "[-l|--full] [--no-legend] [--candidates] UNIT",
+		Description: `Lists the most recent decisions the fleet engine made to schedule the given unit
+to a machine or unschedule it, oldest first, along with the reason for each
+decision. Decisions are only recorded if engine_decision_history is set, and
+are removed when the unit is destroyed. If the engine has been unable to
+schedule the unit, the reason is shown as well.
+
+Show how each machine was rated when the unit was scheduled:
+	fleetctl list-decisions --candidates foo.service`,
+		Run: runListDecisions,
+	}
+)
+
+func init() {
+	cmdListDecisions.Flags.BoolVar(&sharedFlags.Full, "full", false, "Do not ellipsize fields on output")
+	cmdListDecisions.Flags.BoolVar(&sharedFlags.Full, "l", false, "Shorthand for --full")
+	cmdListDecisions.Flags.BoolVar(&sharedFlags.NoLegend, "no-legend", false, "Do not print a legend (column headers)")
+	cmdListDecisions.Flags.BoolVar(&flagCandidates, "candidates", false, "Print the machines considered for each scheduling decision")
+}
+
+func runListDecisions(args []string) (exit int) {
+	if len(args) != 1 {
+		stderr("One unit must be provided.")
+		return 1
+	}
+
+	name := unitNameMangle(args[0])
+	decs, err := cAPI.Decisions(name)
+	if err != nil {
+		stderr("Error retrieving scheduling decisions of Unit(%s): %v", name, err)
+		return 1
+	}
+
+	if !sharedFlags.NoLegend {
+		fmt.Fprintln(out, "TIME\tTYPE\tMACHINE\tREASON")
+	}
+	for _, d := range decs {
+		fmt.Fprintln(out, strings.Join(formatDecision(d, flagCandidates, sharedFlags.Full), "\n"))
+	}
+
+	u, err := cAPI.Unschedulable(name)
+	if err != nil {
+		stderr("Error retrieving unschedulable state of Unit(%s): %v", name, err)
+		return 1
+	}
+	if u != nil {
+		fmt.Fprintln(out, formatUnschedulable(u))
+	}
+
+	out.Flush()
+	return
+}
+
+// formatDecision returns the lines describing a single decision, followed
+// by one for each of its candidate Machines if requested
+func formatDecision(d job.Decision, candidates, full bool) []string {
+	lines := []string{fmt.Sprintf("%s\t%s\t%s\t%s", d.Time.Local().Format(time.RFC3339), d.Type, machineIDLegend(machine.MachineState{ID: d.MachineID}, full), d.Reason)}
+	if !candidates {
+		return lines
+	}
+
+	for _, c := range d.Candidates {
+		verdict := fmt.Sprintf("score %.2f", c.Score)
+		if !c.Able {
+			verdict = fmt.Sprintf("unable: %s", c.Reason)
+		}
+		lines = append(lines, fmt.Sprintf("\tcandidate\t%s\t%s", machineIDLegend(machine.MachineState{ID: c.MachineID}, full), verdict))
+	}
+	return lines
+}
+
+// formatUnschedulable returns the line describing why the engine has been
+// unable to schedule a unit
+func formatUnschedulable(u *job.Unschedulable) string {
+	return fmt.Sprintf("-\tunschedulable\t-\t%s (%d failed attempt(s), retrying after %s)", u.Reason, u.Failures, u.RetryAt.Local().Format(time.RFC3339))
+}
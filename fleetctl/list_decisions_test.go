@@ -0,0 +1,76 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/job"
+)
+
+func TestFormatDecision(t *testing.T) {
+	at := time.Date(2014, time.October, 15, 10, 30, 0, 0, time.UTC)
+	ts := at.Local().Format(time.RFC3339)
+	d := job.Decision{
+		Time:      at,
+		Type:      job.DecisionTypeSchedule,
+		MachineID: "4d389537d9d14bdabe8be54a9c29f68d",
+		Reason:    "target state launched and unit not scheduled",
+		Candidates: []job.Candidate{
+			{MachineID: "4d389537d9d14bdabe8be54a9c29f68d", Able: true, Score: 1.5},
+			{MachineID: "c31e44e1f858436e933e59c642517860", Reason: "local Machine metadata insufficient"},
+		},
+	}
+
+	tests := []struct {
+		candidates bool
+		full       bool
+		want       []string
+	}{
+		{
+			false, false,
+			[]string{ts + "\tschedule\t4d389537...\ttarget state launched and unit not scheduled"},
+		},
+		{
+			true, false,
+			[]string{
+				ts + "\tschedule\t4d389537...\ttarget state launched and unit not scheduled",
+				"\tcandidate\t4d389537...\tscore 1.50",
+				"\tcandidate\tc31e44e1...\tunable: local Machine metadata insufficient",
+			},
+		},
+		{
+			false, true,
+			[]string{ts + "\tschedule\t4d389537d9d14bdabe8be54a9c29f68d\ttarget state launched and unit not scheduled"},
+		},
+	}
+
+	for i, tt := range tests {
+		if got := formatDecision(d, tt.candidates, tt.full); !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("case %d: expected %q, got %q", i, tt.want, got)
+		}
+	}
+}
+
+func TestFormatUnschedulable(t *testing.T) {
+	at := time.Date(2014, time.October, 15, 10, 30, 0, 0, time.UTC)
+	u := &job.Unschedulable{Reason: "no agents able to run job", Failures: 3, RetryAt: at}
+	want := "-\tunschedulable\t-\tno agents able to run job (3 failed attempt(s), retrying after " + at.Local().Format(time.RFC3339) + ")"
+	if got := formatUnschedulable(u); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
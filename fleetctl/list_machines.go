@@ -17,7 +17,9 @@ package main
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coreos/fleet/machine"
 )
@@ -38,7 +40,22 @@ For easily parsable output, you can remove the column headers:
 	fleetctl list-machines --no-legend
 
 Output the list without truncation:
-	fleetctl list-machines --full`,
+	fleetctl list-machines --full
+
+Show which machines lead the engine of the cluster:
+	fleetctl list-machines --fields=machine,ip,engine
+
+Show which machines are cordoned or draining:
+	fleetctl list-machines --fields=machine,ip,schedulability
+
+Show the free and total resources of each machine:
+	fleetctl list-machines --fields=machine,cpu,memory,disk
+
+Show the version of fleetd running on each machine:
+	fleetctl list-machines --fields=machine,ip,version
+
+For scripts, print the machines as JSON, or YAML, instead:
+	fleetctl -o json list-machines`,
 		Run: runListMachines,
 	}
 
@@ -58,7 +75,48 @@ Output the list without truncation:
 			}
 			return formatMetadata(ms.Metadata)
 		},
+		"cpu": func(ms *machine.MachineState, full bool) string {
+			if ms.Resources == nil {
+				return "-"
+			}
+			return fmt.Sprintf("%s/%s", formatCores(ms.Resources.Free.Cores), formatCores(ms.Resources.Total.Cores))
+		},
+		"memory": func(ms *machine.MachineState, full bool) string {
+			if ms.Resources == nil {
+				return "-"
+			}
+			return fmt.Sprintf("%s/%s", formatMB(ms.Resources.Free.Memory), formatMB(ms.Resources.Total.Memory))
+		},
+		"disk": func(ms *machine.MachineState, full bool) string {
+			if ms.Resources == nil {
+				return "-"
+			}
+			return fmt.Sprintf("%s/%s", formatMB(ms.Resources.Free.Disk), formatMB(ms.Resources.Total.Disk))
+		},
+		"schedulability": func(ms *machine.MachineState, full bool) string {
+			if ms.Schedulability == machine.Schedulable {
+				return "-"
+			}
+			return string(ms.Schedulability)
+		},
+		"version": func(ms *machine.MachineState, full bool) string {
+			if len(ms.Version) == 0 {
+				return "-"
+			}
+			return ms.Version
+		},
+		"engine": func(ms *machine.MachineState, full bool) string {
+			st, ok := listMachinesEngines[ms.ID]
+			if !ok {
+				return "-"
+			}
+			return formatEngineStatus(st)
+		},
 	}
+
+	// listMachinesEngines holds the status of each engine holding a
+	// lease, indexed by Machine ID, if the engine field is requested
+	listMachinesEngines map[string]machine.EngineStatus
 )
 
 type machineToField func(ms *machine.MachineState, full bool) string
@@ -90,6 +148,27 @@ func runListMachines(args []string) (exit int) {
 		return 1
 	}
 
+	if globalFlags.Output != "" {
+		return printStructured(newMachinesOutput(machines))
+	}
+
+	listMachinesEngines = nil
+	for _, c := range cols {
+		if c != "engine" {
+			continue
+		}
+		statuses, err := cAPI.EngineStatuses()
+		if err != nil {
+			stderr("Error retrieving engine status: %v", err)
+			return 1
+		}
+		listMachinesEngines = make(map[string]machine.EngineStatus, len(statuses))
+		for _, st := range statuses {
+			listMachinesEngines[st.MachineID] = st
+		}
+		break
+	}
+
 	if !sharedFlags.NoLegend {
 		fmt.Fprintln(out, strings.ToUpper(strings.Join(cols, "\t")))
 	}
@@ -123,6 +202,33 @@ func formatMetadata(metadata map[string]string) string {
 	return strings.Join(pairs, ",")
 }
 
+// formatCores formats hundredths of a CPU core as a number of cores
+func formatCores(c int) string {
+	return strconv.FormatFloat(float64(c)/100, 'f', -1, 64)
+}
+
+// formatMB formats an amount in MB, in GB once it reaches one
+func formatMB(mb int) string {
+	if mb < 1024 {
+		return fmt.Sprintf("%dM", mb)
+	}
+	return fmt.Sprintf("%.1fG", float64(mb)/1024)
+}
+
+// formatEngineStatus describes the shards an engine leads, when its lease
+// expires and when it last reconciled the cluster
+func formatEngineStatus(st machine.EngineStatus) string {
+	shards := make([]string, len(st.Shards))
+	for i, s := range st.Shards {
+		shards[i] = strconv.Itoa(s)
+	}
+	last := "never"
+	if !st.LastReconcile.IsZero() {
+		last = st.LastReconcile.Local().Format(time.RFC3339)
+	}
+	return fmt.Sprintf("leader of shard(s) %s, lease expires %s, reconciled %s", strings.Join(shards, ","), st.LeaseExpiry.Local().Format(time.RFC3339), last)
+}
+
 func machineToFieldKeys(m map[string]machineToField) (keys []string) {
 	for k, _ := range m {
 		keys = append(keys, k)
@@ -16,9 +16,11 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"github.com/coreos/fleet/machine"
 	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/resource"
 )
 
 func newTestRegistryForListMachines() registry.Registry {
@@ -76,8 +78,42 @@ func TestListMachinesFieldsEmpty(t *testing.T) {
 		Version:  ver,
 	}
 
-	for _, tt := range []string{"ip", "metadata"} {
+	for _, tt := range []string{"ip", "metadata", "engine", "cpu", "memory", "disk", "schedulability"} {
 		f := listMachinesFields[tt](ms, false)
 		assertEqual(t, tt, "-", f)
 	}
 }
+
+func TestListMachinesFieldsEngine(t *testing.T) {
+	exp := time.Date(2014, time.October, 15, 10, 30, 0, 0, time.UTC)
+	listMachinesEngines = map[string]machine.EngineStatus{
+		"abcdef": machine.EngineStatus{MachineID: "abcdef", Shards: []int{0, 2}, LeaseExpiry: exp, LastReconcile: exp.Add(-time.Second)},
+		"ghijkl": machine.EngineStatus{MachineID: "ghijkl", Shards: []int{1}, LeaseExpiry: exp},
+	}
+	defer func() { listMachinesEngines = nil }()
+
+	want := "leader of shard(s) 0,2, lease expires " + exp.Local().Format(time.RFC3339) + ", reconciled " + exp.Add(-time.Second).Local().Format(time.RFC3339)
+	val := listMachinesFields["engine"](&machine.MachineState{ID: "abcdef"}, false)
+	assertEqual(t, "engine", want, val)
+
+	want = "leader of shard(s) 1, lease expires " + exp.Local().Format(time.RFC3339) + ", reconciled never"
+	val = listMachinesFields["engine"](&machine.MachineState{ID: "ghijkl"}, false)
+	assertEqual(t, "engine", want, val)
+
+	val = listMachinesFields["engine"](&machine.MachineState{ID: "mnopqr"}, false)
+	assertEqual(t, "engine", "-", val)
+}
+
+func TestListMachinesFieldsResources(t *testing.T) {
+	ms := &machine.MachineState{
+		ID: "abcdef",
+		Resources: &machine.Resources{
+			Total: resource.ResourceTuple{Cores: 400, Memory: 4096, Disk: 20480},
+			Free:  resource.ResourceTuple{Cores: 250, Memory: 512, Disk: 10752},
+		},
+	}
+
+	assertEqual(t, "cpu", "2.5/4", listMachinesFields["cpu"](ms, false))
+	assertEqual(t, "memory", "512M/4.0G", listMachinesFields["memory"](ms, false))
+	assertEqual(t, "disk", "10.5G/20.0G", listMachinesFields["disk"](ms, false))
+}
@@ -46,11 +46,14 @@ func mapTargetField(u schema.Unit, full bool) string {
 var (
 	listUnitFilesFieldsFlag string
 	cmdListUnitFiles        = &Command{
-		Name:        "list-unit-files",
-		Summary:     "List the units that exist in the cluster.",
-		Usage:       "[--fields]",
-		Description: `Lists all unit files that exist in the cluster (whether or not they are loaded onto a machine).`,
-		Run:         runListUnitFiles,
+		Name:    "list-unit-files",
+		Summary: "List the units that exist in the cluster.",
+		Usage:   "[--fields]",
+		Description: `Lists all unit files that exist in the cluster (whether or not they are loaded onto a machine).
+
+For scripts, print the unit files, along with their contents, as JSON, or YAML, instead:
+	fleetctl -o yaml list-unit-files`,
+		Run: runListUnitFiles,
 	}
 	listUnitFilesFields = map[string]unitToField{
 		"unit": func(u schema.Unit, full bool) string {
@@ -122,6 +125,10 @@ func runListUnitFiles(args []string) (exit int) {
 		return 1
 	}
 
+	if globalFlags.Output != "" {
+		return printStructured(newUnitFilesOutput(units))
+	}
+
 	if !sharedFlags.NoLegend {
 		fmt.Fprintln(out, strings.ToUpper(strings.Join(cols, "\t")))
 	}
@@ -16,23 +16,35 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/coreos/fleet/client"
 	"github.com/coreos/fleet/machine"
 	"github.com/coreos/fleet/schema"
 )
 
 const (
 	defaultListUnitsFields = "unit,machine,active,sub"
+
+	// listUnitsPollInterval is how often the whole list is fetched again
+	// while watching, if the client is unable to watch for changes
+	listUnitsPollInterval = 2 * time.Second
+
+	// clearScreen moves the cursor of the terminal home and clears it
+	clearScreen = "\033[H\033[2J"
 )
 
 var (
 	listUnitsFieldsFlag string
+	listUnitsWatch      bool
 	cmdListUnits        = &Command{
 		Name:    "list-units",
 		Summary: "List the current state of units in the cluster",
-		Usage:   "[--no-legend] [-l|--full] [--fields]",
+		Usage:   "[--no-legend] [-l|--full] [--fields] [--watch]",
 		Description: `Lists the state of all units in the cluster loaded onto a machine.
 
 For easily parsable output, you can remove the column headers:
@@ -42,7 +54,17 @@ Output the list without ellipses:
 	fleetctl list-units --full
 
 Or, choose the columns to display:
-	fleetctl list-units --fields=unit,machine`,
+	fleetctl list-units --fields=unit,machine
+
+Show why units failed, with the exit status of their main process, the
+result given by systemd and when they entered their current state:
+	fleetctl list-units --fields=unit,machine,active,sub,exit,result,since
+
+Keep the list updated as the state of units changes, until interrupted:
+	fleetctl list-units --watch
+
+For scripts, print the state of every unit as JSON, or YAML, instead:
+	fleetctl -o json list-units`,
 		Run: runListUnits,
 	}
 
@@ -71,6 +93,30 @@ Or, choose the columns to display:
 			}
 			return us.SystemdSubState
 		},
+		"exit": func(us *schema.UnitState, full bool) string {
+			if us == nil || us.ExitTime == "" {
+				return "-"
+			}
+			return strconv.FormatInt(us.ExitStatus, 10)
+		},
+		"result": func(us *schema.UnitState, full bool) string {
+			if us == nil || us.Result == "" {
+				return "-"
+			}
+			return us.Result
+		},
+		"reason": func(us *schema.UnitState, full bool) string {
+			if us == nil || us.FailureReason == "" {
+				return "-"
+			}
+			return us.FailureReason
+		},
+		"since": func(us *schema.UnitState, full bool) string {
+			if us == nil || us.StateChangeTime == "" {
+				return "-"
+			}
+			return us.StateChangeTime
+		},
 		"machine": func(us *schema.UnitState, full bool) string {
 			if us == nil || us.MachineID == "" {
 				return "-"
@@ -99,6 +145,7 @@ func init() {
 	cmdListUnits.Flags.BoolVar(&sharedFlags.Full, "full", false, "Do not ellipsize fields on output")
 	cmdListUnits.Flags.BoolVar(&sharedFlags.Full, "l", false, "Shorthand for --full")
 	cmdListUnits.Flags.BoolVar(&sharedFlags.NoLegend, "no-legend", false, "Do not print a legend (column headers)")
+	cmdListUnits.Flags.BoolVar(&listUnitsWatch, "watch", false, "Keep the list updated as the state of units changes, until interrupted")
 	cmdListUnits.Flags.StringVar(&listUnitsFieldsFlag, "fields", defaultListUnitsFields, fmt.Sprintf("Columns to print for each Unit. Valid fields are %q", strings.Join(usToFieldKeys(listUnitsFields), ",")))
 }
 
@@ -116,12 +163,29 @@ func runListUnits(args []string) (exit int) {
 		}
 	}
 
+	if listUnitsWatch {
+		if globalFlags.Output != "" {
+			stderr("--watch cannot be combined with --output")
+			return 1
+		}
+		return watchUnitStates(cols)
+	}
+
 	states, err := cAPI.UnitStates()
 	if err != nil {
 		stderr("Error retrieving list of units from repository: %v", err)
 		return 1
 	}
 
+	if globalFlags.Output != "" {
+		return printStructured(newUnitStatesOutput(states))
+	}
+
+	printUnitStates(states, cols)
+	return
+}
+
+func printUnitStates(states []*schema.UnitState, cols []string) {
 	if !sharedFlags.NoLegend {
 		fmt.Fprintln(out, strings.ToUpper(strings.Join(cols, "\t")))
 	}
@@ -135,7 +199,138 @@ func runListUnits(args []string) (exit int) {
 	}
 
 	out.Flush()
-	return
+}
+
+// watchUnitStates prints the state of the units, printing it again on a
+// cleared terminal whenever it changes, until interrupted. Only the states
+// which changed are fetched again, unless the client is unable to watch
+// for changes, in which case the whole list is fetched periodically.
+func watchUnitStates(cols []string) int {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// the watch starts before the list is fetched, so that no change
+	// made in between is missed
+	changes, err := cAPI.WatchUnitStates(stop)
+	var poll <-chan time.Time
+	if err == client.ErrWatchUnsupported {
+		ticker := time.NewTicker(listUnitsPollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	} else if err != nil {
+		stderr("Error watching state of units: %v", err)
+		return 1
+	}
+
+	view := newUnitStatesView()
+	if err := view.reset(); err != nil {
+		stderr("Error retrieving list of units from repository: %v", err)
+		return 1
+	}
+
+	for {
+		fmt.Fprint(os.Stdout, clearScreen)
+		printUnitStates(view.sorted(), cols)
+
+		select {
+		case c, ok := <-changes:
+			if !ok {
+				stderr("Stopped watching state of units")
+				return 1
+			}
+			// changes arriving together are applied before printing
+			// the list again
+			for ok {
+				if err = view.update(c); err != nil {
+					break
+				}
+				select {
+				case c, ok = <-changes:
+				default:
+					ok = false
+				}
+			}
+		case <-poll:
+			err = view.reset()
+		}
+		if err != nil {
+			stderr("Error retrieving state of units: %v", err)
+			return 1
+		}
+	}
+}
+
+type unitStateKey struct {
+	name   string
+	machID string
+}
+
+// unitStatesView holds the state of each unit on each Machine as last
+// fetched while watching
+type unitStatesView struct {
+	states map[unitStateKey]*schema.UnitState
+}
+
+func newUnitStatesView() *unitStatesView {
+	return &unitStatesView{states: make(map[unitStateKey]*schema.UnitState)}
+}
+
+// reset fetches the state of every unit
+func (v *unitStatesView) reset() error {
+	states, err := cAPI.UnitStates()
+	if err != nil {
+		return err
+	}
+	v.states = make(map[unitStateKey]*schema.UnitState, len(states))
+	for _, us := range states {
+		v.states[unitStateKey{us.Name, us.MachineID}] = us
+	}
+	return nil
+}
+
+// update fetches the state of the unit that changed, or of every unit
+// should changes have been missed
+func (v *unitStatesView) update(c client.UnitStateChange) error {
+	if c.Name == "" {
+		return v.reset()
+	}
+	us, err := cAPI.UnitState(c.Name, c.MachineID)
+	if err != nil {
+		return err
+	}
+	key := unitStateKey{c.Name, c.MachineID}
+	if us == nil {
+		delete(v.states, key)
+	} else {
+		v.states[key] = us
+	}
+	return nil
+}
+
+// sorted returns the states ordered by unit name, then by Machine
+func (v *unitStatesView) sorted() []*schema.UnitState {
+	keys := make([]unitStateKey, 0, len(v.states))
+	for k := range v.states {
+		keys = append(keys, k)
+	}
+	sort.Sort(unitStateKeys(keys))
+
+	states := make([]*schema.UnitState, len(keys))
+	for i, k := range keys {
+		states[i] = v.states[k]
+	}
+	return states
+}
+
+type unitStateKeys []unitStateKey
+
+func (ks unitStateKeys) Len() int      { return len(ks) }
+func (ks unitStateKeys) Swap(i, j int) { ks[i], ks[j] = ks[j], ks[i] }
+func (ks unitStateKeys) Less(i, j int) bool {
+	if ks[i].name != ks[j].name {
+		return ks[i].name < ks[j].name
+	}
+	return ks[i].machID < ks[j].machID
 }
 
 func usToFieldKeys(m map[string]usToField) (keys []string) {
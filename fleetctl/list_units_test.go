@@ -15,12 +15,15 @@
 package main
 
 import (
+	"reflect"
 	"testing"
 
+	"github.com/coreos/fleet/client"
 	"github.com/coreos/fleet/job"
 	"github.com/coreos/fleet/machine"
 	"github.com/coreos/fleet/registry"
 	"github.com/coreos/fleet/schema"
+	"github.com/coreos/fleet/unit"
 )
 
 func newFakeRegistryForListUnits(t *testing.T, jobs []job.Job) registry.Registry {
@@ -37,7 +40,7 @@ func assertEqual(t *testing.T, name string, want, got interface{}) {
 
 func TestListUnitsFieldsToStrings(t *testing.T) {
 	// nil UnitState shouldn't happen, but just in case
-	for _, tt := range []string{"unit", "load", "active", "sub", "machine", "hash"} {
+	for _, tt := range []string{"unit", "load", "active", "sub", "machine", "hash", "exit", "result", "reason", "since"} {
 		f := listUnitsFields[tt](nil, false)
 		assertEqual(t, tt, "-", f)
 	}
@@ -56,6 +59,9 @@ func TestListUnitsFieldsToStrings(t *testing.T) {
 		"sub":     "baz",
 		"machine": "-",
 		"unit":    "sleep",
+		"exit":    "-",
+		"result":  "-",
+		"since":   "-",
 	} {
 		got := listUnitsFields[k](us, false)
 		assertEqual(t, k, want, got)
@@ -81,4 +87,72 @@ func TestListUnitsFieldsToStrings(t *testing.T) {
 	suh := listUnitsFields["hash"](us, false)
 	assertEqual(t, "hash", uh, fuh)
 	assertEqual(t, "hash", uh[:7], suh)
+
+	us.ExitStatus = 0
+	us.ExitTime = "2014-10-24T09:03:34Z"
+	us.Result = "timeout"
+	us.StateChangeTime = "2014-10-24T09:03:35Z"
+	for k, want := range map[string]string{
+		"exit":   "0",
+		"result": "timeout",
+		"since":  "2014-10-24T09:03:35Z",
+	} {
+		got := listUnitsFields[k](us, false)
+		assertEqual(t, k, want, got)
+	}
+}
+
+func TestUnitStatesView(t *testing.T) {
+	reg := registry.NewFakeRegistry()
+	reg.SetUnitStates([]unit.UnitState{
+		{UnitName: "foo.service", MachineID: "YYY", ActiveState: "active"},
+		{UnitName: "foo.service", MachineID: "XXX", ActiveState: "active"},
+		{UnitName: "bar.service", MachineID: "XXX", ActiveState: "active"},
+	})
+	cAPI = &client.RegistryClient{Registry: reg}
+
+	view := newUnitStatesView()
+	if err := view.reset(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	listed := func() (got []string) {
+		for _, us := range view.sorted() {
+			got = append(got, us.Name+"/"+us.MachineID+"/"+us.SystemdActiveState)
+		}
+		return
+	}
+
+	want := []string{"bar.service/XXX/active", "foo.service/XXX/active", "foo.service/YYY/active"}
+	if got := listed(); !reflect.DeepEqual(want, got) {
+		t.Errorf("expected states %v, got %v", want, got)
+	}
+
+	// only the state named by a change is fetched again
+	reg.SaveUnitState("foo.service", &unit.UnitState{UnitName: "foo.service", MachineID: "XXX", ActiveState: "failed"}, 0)
+	reg.SaveUnitState("bar.service", &unit.UnitState{UnitName: "bar.service", MachineID: "XXX", ActiveState: "failed"}, 0)
+	reg.SaveUnitState("baz.service", &unit.UnitState{UnitName: "baz.service", MachineID: "ZZZ", ActiveState: "active"}, 0)
+	for _, c := range []client.UnitStateChange{{Name: "foo.service", MachineID: "XXX"}, {Name: "baz.service", MachineID: "ZZZ"}} {
+		if err := view.update(c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	want = []string{"bar.service/XXX/active", "baz.service/ZZZ/active", "foo.service/XXX/failed", "foo.service/YYY/active"}
+	if got := listed(); !reflect.DeepEqual(want, got) {
+		t.Errorf("expected states %v, got %v", want, got)
+	}
+
+	// expired states are dropped, and everything is fetched again once
+	// changes were missed
+	reg.RemoveUnitState("baz.service")
+	if err := view.update(client.UnitStateChange{Name: "baz.service", MachineID: "ZZZ"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := view.update(client.UnitStateChange{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want = []string{"bar.service/XXX/failed", "foo.service/XXX/failed", "foo.service/YYY/active"}
+	if got := listed(); !reflect.DeepEqual(want, got) {
+		t.Errorf("expected states %v, got %v", want, got)
+	}
 }
@@ -0,0 +1,109 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/coreos/fleet/machine"
+)
+
+var (
+	cmdSetMetadata = &Command{
+		Name:    "set-metadata",
+		Summary: "Set metadata of a machine without restarting fleetd",
+		Usage:   "MACHINE KEY=VALUE...",
+		Description: `Sets the given metadata keys of a machine, in addition to or in place of the
+metadata configured on it. The metadata is kept across restarts of the machine
+until unset. Units are rescheduled, and global units started or stopped, to
+match the new metadata.
+
+Mark a machine as having an SSD:
+	fleetctl set-metadata 2c8b2e1a disk=ssd`,
+		Run: runSetMetadata,
+	}
+
+	cmdUnsetMetadata = &Command{
+		Name:    "unset-metadata",
+		Summary: "Remove metadata set on a machine with set-metadata",
+		Usage:   "MACHINE KEY...",
+		Description: `Removes the given metadata keys set on a machine with set-metadata. Keys
+configured on the machine itself revert to their configured values.
+
+	fleetctl unset-metadata 2c8b2e1a disk`,
+		Run: runUnsetMetadata,
+	}
+)
+
+func runSetMetadata(args []string) (exit int) {
+	if len(args) < 2 {
+		stderr("A machine and at least one KEY=VALUE pair must be provided.")
+		return 1
+	}
+
+	md := make(map[string]string)
+	var keys []string
+	for _, arg := range args[1:] {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			stderr("Invalid metadata %q, expected KEY=VALUE", arg)
+			return 1
+		}
+		if err := machine.ValidateMetadataKey(parts[0]); err != nil {
+			stderr("Invalid metadata %q: %v", arg, err)
+			return 1
+		}
+		if _, ok := md[parts[0]]; !ok {
+			keys = append(keys, parts[0])
+		}
+		md[parts[0]] = parts[1]
+	}
+
+	return setMetadata(args[0], keys, md)
+}
+
+func runUnsetMetadata(args []string) (exit int) {
+	if len(args) < 2 {
+		stderr("A machine and at least one key must be provided.")
+		return 1
+	}
+
+	for _, key := range args[1:] {
+		if err := machine.ValidateMetadataKey(key); err != nil {
+			stderr("Invalid metadata key: %v", err)
+			return 1
+		}
+	}
+
+	return setMetadata(args[0], args[1:], map[string]string{})
+}
+
+// setMetadata sets the given keys of the given Machine to their values in
+// md, removing those without a value
+func setMetadata(id string, keys []string, md map[string]string) int {
+	ms, err := findMachine(id)
+	if err != nil {
+		stderr("%v", err)
+		return 1
+	}
+
+	for _, key := range keys {
+		if err := cAPI.SetMachineMetadata(ms.ID, key, md[key]); err != nil {
+			stderr("Error setting metadata %q of Machine(%s): %v", key, ms.ID, err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,61 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/registry"
+)
+
+func TestSetMetadata(t *testing.T) {
+	reg := registry.NewFakeRegistry()
+	reg.SetMachines([]machine.MachineState{{ID: "abcdef0123456789", Metadata: map[string]string{"region": "us-east"}}})
+	cAPI = &client.RegistryClient{Registry: reg}
+
+	metadata := func() map[string]string {
+		machines, _ := reg.Machines()
+		return machines[0].Metadata
+	}
+
+	for _, args := range [][]string{
+		{"abcdef01"},
+		{"missing", "disk=ssd"},
+		{"abcdef01", "disk"},
+		{"abcdef01", "disk="},
+		{"abcdef01", "a/b=c"},
+	} {
+		if code := runSetMetadata(args); code == 0 {
+			t.Errorf("expected set-metadata %v to fail", args)
+		}
+	}
+
+	if code := runSetMetadata([]string{"abcdef01", "disk=ssd", "region=us-west"}); code != 0 {
+		t.Fatalf("expected success, got exit code %d", code)
+	}
+	if want := map[string]string{"region": "us-west", "disk": "ssd"}; !reflect.DeepEqual(want, metadata()) {
+		t.Errorf("expected metadata %v, got %v", want, metadata())
+	}
+
+	if code := runUnsetMetadata([]string{"abcdef01", "region", "disk"}); code != 0 {
+		t.Fatalf("expected success, got exit code %d", code)
+	}
+	if want := map[string]string{"region": "us-east"}; !reflect.DeepEqual(want, metadata()) {
+		t.Errorf("expected metadata %v, got %v", want, metadata())
+	}
+}
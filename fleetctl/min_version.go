@@ -0,0 +1,127 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-semver/semver"
+
+	"github.com/coreos/fleet/machine"
+)
+
+var (
+	minVersionFlags = struct {
+		Clear bool
+		Force bool
+	}{}
+
+	cmdMinVersion = &Command{
+		Name:    "min-version",
+		Summary: "Show or set the minimum version of fleetd new units are scheduled to",
+		Usage:   "[--clear] [--force] [VERSION]",
+		Description: `Without arguments, prints the minimum version of fleetd set for the cluster.
+
+Given a version, stops new units from being scheduled to machines running an
+older fleetd. Units already scheduled to such machines keep running there,
+which allows the machines of a cluster to be upgraded one after another. As
+the gate is meant to be raised once the cluster has been upgraded, it is
+refused while any machine runs an older fleetd, unless --force is given.
+
+Require fleetd 0.10.0 or newer:
+	fleetctl min-version 0.10.0
+
+Remove the minimum version:
+	fleetctl min-version --clear`,
+		Run: runMinVersion,
+	}
+)
+
+func init() {
+	cmdMinVersion.Flags.BoolVar(&minVersionFlags.Clear, "clear", false, "Remove the minimum version of the cluster.")
+	cmdMinVersion.Flags.BoolVar(&minVersionFlags.Force, "force", false, "Set the minimum version even if machines run an older fleetd.")
+}
+
+func runMinVersion(args []string) (exit int) {
+	if minVersionFlags.Clear {
+		if len(args) != 0 {
+			stderr("No version may be provided with --clear.")
+			return 1
+		}
+		if err := cAPI.SetMinimumVersion(nil); err != nil {
+			stderr("Error removing minimum version: %v", err)
+			return 1
+		}
+		return 0
+	}
+
+	switch len(args) {
+	case 0:
+		v, err := cAPI.MinimumVersion()
+		if err != nil {
+			stderr("Error retrieving minimum version: %v", err)
+			return 1
+		}
+		if v == nil {
+			stdout("No minimum version set")
+		} else {
+			stdout("%s", v)
+		}
+		return 0
+	case 1:
+	default:
+		stderr("At most one version may be provided.")
+		return 1
+	}
+
+	v, err := semver.NewVersion(args[0])
+	if err != nil {
+		stderr("Invalid version %q: %v", args[0], err)
+		return 1
+	}
+
+	if !minVersionFlags.Force {
+		machines, err := cAPI.Machines()
+		if err != nil {
+			stderr("Error retrieving list of active machines: %v", err)
+			return 1
+		}
+		if older := machinesBelowVersion(machines, *v); len(older) > 0 {
+			stderr("Refusing to set minimum version %s, as these machines run an older fleetd:", v)
+			for _, ms := range older {
+				stderr("%s\t%s", ms.ShortID(), listMachinesFields["version"](&ms, false))
+			}
+			stderr("Upgrade them first, or pass --force.")
+			return 1
+		}
+	}
+
+	if err := cAPI.SetMinimumVersion(v); err != nil {
+		stderr("Error setting minimum version: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// machinesBelowVersion returns the given Machines running a fleetd older
+// than min, including those whose version is unknown
+func machinesBelowVersion(machines []machine.MachineState, min semver.Version) []machine.MachineState {
+	var older []machine.MachineState
+	for _, ms := range machines {
+		v, err := semver.NewVersion(ms.Version)
+		if err != nil || v.LessThan(min) {
+			older = append(older, ms)
+		}
+	}
+	return older
+}
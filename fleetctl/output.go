@@ -0,0 +1,263 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/schema"
+)
+
+const (
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+var (
+	// plainYAMLScalar matches the strings which may be written to YAML
+	// unquoted, as long as they are not also read as another type
+	plainYAMLScalar = regexp.MustCompile(`^[A-Za-z0-9_/][A-Za-z0-9_/.@=+-]*$`)
+	yamlDate        = regexp.MustCompile(`^[0-9]{4}-[0-9]{1,2}-[0-9]{1,2}`)
+	yamlReserved    = map[string]bool{
+		"true": true, "false": true, "yes": true, "no": true, "on": true, "off": true,
+		"y": true, "n": true, "null": true,
+	}
+)
+
+// The structured output of the list commands wraps each list in an object,
+// as the API does, always holding the list even if it is empty. Entities
+// take the form in which the API represents them.
+type unitFilesOutput struct {
+	Units []*schema.Unit `json:"units"`
+}
+
+type unitStatesOutput struct {
+	States []*schema.UnitState `json:"states"`
+}
+
+type machinesOutput struct {
+	Machines []*schema.Machine `json:"machines"`
+}
+
+func newUnitFilesOutput(units []*schema.Unit) unitFilesOutput {
+	o := unitFilesOutput{Units: make([]*schema.Unit, 0, len(units))}
+	o.Units = append(o.Units, units...)
+	return o
+}
+
+func newUnitStatesOutput(states []*schema.UnitState) unitStatesOutput {
+	o := unitStatesOutput{States: make([]*schema.UnitState, 0, len(states))}
+	o.States = append(o.States, states...)
+	return o
+}
+
+func newMachinesOutput(machines []machine.MachineState) machinesOutput {
+	o := machinesOutput{Machines: make([]*schema.Machine, 0, len(machines))}
+	for i := range machines {
+		o.Machines = append(o.Machines, schema.MapMachineStateToSchema(&machines[i]))
+	}
+	return o
+}
+
+// validateOutputFormat checks the format requested through --output
+func validateOutputFormat(format string) error {
+	switch format {
+	case "", outputJSON, outputYAML:
+		return nil
+	}
+	return fmt.Errorf("invalid output format %q, must be %q or %q", format, outputJSON, outputYAML)
+}
+
+// printStructured writes v to stdout in the format requested through
+// --output, returning 1 if it could not be written
+func printStructured(v interface{}) int {
+	if err := writeStructured(os.Stdout, globalFlags.Output, v); err != nil {
+		stderr("Error writing output: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// writeStructured writes v, as it encodes to JSON, to w in the given format
+func writeStructured(w io.Writer, format string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if format == outputYAML {
+		if b, err = jsonToYAML(b); err != nil {
+			return err
+		}
+	} else {
+		b = append(b, '\n')
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// yamlMap holds the members of a JSON object in their original order
+type yamlMap []yamlMember
+
+type yamlMember struct {
+	key   string
+	value interface{}
+}
+
+// jsonToYAML converts the given JSON document to block-style YAML,
+// keeping the members of objects in order
+func jsonToYAML(b []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	v, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, line := range yamlLines(v) {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		m := yamlMap{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, yamlMember{key.(string), value})
+		}
+		_, err = dec.Token()
+		return m, err
+	case json.Delim('['):
+		l := []interface{}{}
+		for dec.More() {
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, value)
+		}
+		_, err = dec.Token()
+		return l, err
+	}
+	return tok, nil
+}
+
+// yamlLines returns the lines representing v in YAML, without indentation
+func yamlLines(v interface{}) []string {
+	var lines []string
+	switch v := v.(type) {
+	case yamlMap:
+		if len(v) == 0 {
+			return []string{"{}"}
+		}
+		for _, m := range v {
+			key := yamlScalar(m.key)
+			if !yamlCollection(m.value) {
+				lines = append(lines, key+": "+yamlLines(m.value)[0])
+				continue
+			}
+			lines = append(lines, key+":")
+			for _, l := range yamlLines(m.value) {
+				lines = append(lines, "  "+l)
+			}
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			return []string{"[]"}
+		}
+		for _, e := range v {
+			for i, l := range yamlLines(e) {
+				if i == 0 {
+					lines = append(lines, "- "+l)
+				} else {
+					lines = append(lines, "  "+l)
+				}
+			}
+		}
+	default:
+		lines = []string{yamlScalar(v)}
+	}
+	return lines
+}
+
+// yamlCollection reports whether v is a non-empty object or array, which
+// is written on the lines following its key
+func yamlCollection(v interface{}) bool {
+	switch v := v.(type) {
+	case yamlMap:
+		return len(v) > 0
+	case []interface{}:
+		return len(v) > 0
+	}
+	return false
+}
+
+func yamlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		if yamlPlain(v) {
+			return v
+		}
+		// JSON strings are valid double-quoted YAML scalars
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+// yamlPlain reports whether s reads back as the same string if written
+// unquoted
+func yamlPlain(s string) bool {
+	if !plainYAMLScalar.MatchString(s) || yamlReserved[strings.ToLower(s)] || yamlDate.MatchString(s) {
+		return false
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return false
+	}
+	if _, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return false
+	}
+	return true
+}
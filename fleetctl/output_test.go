@@ -0,0 +1,104 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/schema"
+)
+
+func TestWriteStructured(t *testing.T) {
+	units := newUnitFilesOutput([]*schema.Unit{
+		{
+			Name:         "foo@1.service",
+			DesiredState: "launched",
+			Options: []*schema.UnitOption{
+				{Section: "Service", Name: "ExecStart", Value: "/bin/sleep 1000"},
+				{Section: "X-Fleet", Name: "Global", Value: "true"},
+			},
+		},
+	})
+	machines := newMachinesOutput([]machine.MachineState{
+		{ID: "XXX", PublicIP: "10.0.0.1", Metadata: map[string]string{"region": "us-east"}, Version: "0.10.0"},
+	})
+
+	tests := []struct {
+		format string
+		v      interface{}
+		want   string
+	}{
+		{outputJSON, newUnitStatesOutput(nil), "{\n  \"states\": []\n}\n"},
+		{outputYAML, newUnitStatesOutput(nil), "states: []\n"},
+		{
+			outputYAML,
+			units,
+			`units:
+  - desiredState: launched
+    name: foo@1.service
+    options:
+      - name: ExecStart
+        section: Service
+        value: "/bin/sleep 1000"
+      - name: Global
+        section: X-Fleet
+        value: "true"
+`,
+		},
+		{
+			outputYAML,
+			machines,
+			`machines:
+  - id: XXX
+    metadata:
+      region: us-east
+    primaryIP: 10.0.0.1
+    version: 0.10.0
+`,
+		},
+	}
+
+	for i, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeStructured(&buf, tt.format, tt.v); err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("case %d: expected output:\n%s\nreceived:\n%s", i, tt.want, got)
+		}
+	}
+}
+
+func TestYAMLScalar(t *testing.T) {
+	tests := map[string]string{
+		"foo.service":          "foo.service",
+		"":                     `""`,
+		"yes":                  `"yes"`,
+		"12":                   `"12"`,
+		"0x1F":                 `"0x1F"`,
+		"1e3":                  `"1e3"`,
+		"2014-10-15T10:30:00Z": `"2014-10-15T10:30:00Z"`,
+		"a: b":                 `"a: b"`,
+		"- foo":                `"- foo"`,
+	}
+	for in, want := range tests {
+		if got := yamlScalar(in); got != want {
+			t.Errorf("yamlScalar(%q): expected %s, got %s", in, want, got)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/coreos/fleet/registry"
+)
+
+var cmdRestore = &Command{
+	Name:    "restore",
+	Summary: "Recreate a cluster from a snapshot written by export",
+	Usage:   "FILE",
+	Description: `Recreate every unit held in a snapshot written by export, in the desired
+state it had when exported, along with the rolling updates then in progress.
+Units and rolling updates which already exist in the cluster are left
+untouched, so that restore may be repeated after a failure. The metadata and
+schedulability set on machines at runtime are restored as well, taking effect
+whenever the machines rejoin the cluster.
+
+Restore a backup into a new cluster:
+	fleetctl restore fleet-backup.json`,
+	Run: runRestore,
+}
+
+func runRestore(args []string) (exit int) {
+	if len(args) != 1 {
+		stderr("One file must be provided.")
+		return 1
+	}
+
+	b, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		stderr("Error reading %s: %v", args[0], err)
+		return 1
+	}
+	var snap registry.Snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		stderr("Error decoding %s: %v", args[0], err)
+		return 1
+	}
+	if snap.Version < 1 || snap.Version > registry.SnapshotVersion {
+		stderr("Unable to restore %s: unsupported export version %d", args[0], snap.Version)
+		return 1
+	}
+
+	outcomes, err := cAPI.RestoreSnapshot(&snap)
+	if err != nil {
+		stderr("Error restoring %s: %v", args[0], err)
+		return 1
+	}
+	for _, o := range outcomes {
+		what := o.Name
+		switch o.Kind {
+		case registry.SnapshotKindRollout:
+			what = "rolling update of " + o.Name
+		case registry.SnapshotKindMachine:
+			what = "machine " + o.Name
+		}
+		switch {
+		case o.Error != "":
+			stderr("Error restoring %s: %s", what, o.Error)
+			exit = 1
+		case o.Skipped != "":
+			stderr("Not restoring %s: %s", what, o.Skipped)
+		case o.Detail != "":
+			stdout("Restored %s (%s)", what, o.Detail)
+		default:
+			stdout("Restored %s", what)
+		}
+	}
+	return
+}
@@ -0,0 +1,305 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path"
+	"sort"
+	"time"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/schema"
+	"github.com/coreos/fleet/unit"
+)
+
+var (
+	restartFlags struct {
+		BatchSize        int
+		InstanceTimeout  time.Duration
+		FailureThreshold int
+	}
+	cmdRollingRestart = &Command{
+		Name:    "rolling-restart",
+		Summary: "Restart every launched instance of a template unit, a batch at a time.",
+		Usage:   "[--batch-size=N] [--instance-timeout=DURATION] [--failure-threshold=N] TEMPLATE",
+		Description: `Restart the launched instances of the given template unit in batches, by
+stopping every instance of a batch, starting them again and waiting for each of
+them to report itself active before moving on to the next batch. Instances are
+restarted on the machines they are already scheduled to; the unit files are
+left untouched.
+
+An instance fails to restart if systemd reports it failed, or if it does not
+become active again within the instance timeout. Failed instances, including
+those which did not stop in time, are left launched. Once more instances have failed than the failure threshold allows,
+the restart stops, leaving the remaining instances alone. fleetctl exits
+non-zero if any instance failed to restart.
+
+Instances of global templates, and those created through the Instances option
+of a template, are not restarted.
+
+Restart every instance of foo@.service, one at a time:
+	fleetctl rolling-restart foo@.service
+
+Restart the instances of foo@.service three at a time, carrying on past up to
+two failed instances:
+	fleetctl rolling-restart --batch-size=3 --failure-threshold=2 foo@.service`,
+		Run: runRollingRestart,
+	}
+)
+
+func init() {
+	cmdRollingRestart.Flags.IntVar(&restartFlags.BatchSize, "batch-size", 1, "Maximum number of instances restarted at once.")
+	cmdRollingRestart.Flags.DurationVar(&restartFlags.InstanceTimeout, "instance-timeout", 5*time.Minute, "Count an instance as failed if it is not active again within this long after being stopped. A value of 0 means no limit.")
+	cmdRollingRestart.Flags.IntVar(&restartFlags.FailureThreshold, "failure-threshold", 0, "Number of instances allowed to fail to restart before the rolling restart is stopped.")
+}
+
+func runRollingRestart(args []string) (exit int) {
+	if len(args) != 1 {
+		stderr("One template unit must be provided.")
+		return 1
+	}
+	if restartFlags.BatchSize < 1 {
+		stderr("Batch size must be at least 1.")
+		return 1
+	}
+	if restartFlags.InstanceTimeout < 0 || restartFlags.FailureThreshold < 0 {
+		stderr("Instance timeout and failure threshold must not be negative.")
+		return 1
+	}
+
+	name := path.Base(args[0])
+	uni := unit.NewUnitNameInfo(name)
+	if uni == nil || uni.IsInstance() || uni.Template != name {
+		stderr("Unit %s is not a template unit.", name)
+		return 1
+	}
+
+	units, err := cAPI.Units()
+	if err != nil {
+		stderr("Error retrieving list of units from repository: %v", err)
+		return 1
+	}
+	instances := launchedInstances(units, name)
+	if len(instances) == 0 {
+		stderr("No launched instances of %s found", name)
+		return 1
+	}
+
+	return rollingRestart(name, instances, 500*time.Millisecond)
+}
+
+// launchedInstances returns the sorted names of the launched, non-global
+// instances of the given template
+func launchedInstances(units []*schema.Unit, template string) []string {
+	var names []string
+	for _, u := range units {
+		uni := unit.NewUnitNameInfo(u.Name)
+		if uni == nil || !uni.IsInstance() || uni.Template != template {
+			continue
+		}
+		if job.JobState(u.DesiredState) != job.JobStateLaunched {
+			continue
+		}
+		if schema.MapSchemaUnitToUnit(u).IsGlobal() {
+			continue
+		}
+		names = append(names, u.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rollingRestart restarts the given instances of a template a batch at a
+// time, stopping once the failure threshold is exceeded
+func rollingRestart(template string, instances []string, sleep time.Duration) (exit int) {
+	restarted, failures := 0, 0
+	for i := 0; i < len(instances); i += restartFlags.BatchSize {
+		end := i + restartFlags.BatchSize
+		if end > len(instances) {
+			end = len(instances)
+		}
+		batch := instances[i:end]
+
+		failed := restartInstances(batch, restartFlags.InstanceTimeout, sleep)
+		for _, name := range batch {
+			if reason, ok := failed[name]; ok {
+				stderr("Instance %s failed to restart: %s", name, reason)
+				failures++
+			} else {
+				restarted++
+			}
+		}
+		stdout("Restarted %d/%d instances of %s", restarted, len(instances), template)
+
+		if failures > restartFlags.FailureThreshold {
+			stderr("Rolling restart of %s stopped after %d failed instances", template, failures)
+			return 1
+		}
+	}
+
+	if failures > 0 {
+		stderr("Rolling restart of %s complete, %d instances failed to restart", template, failures)
+		return 1
+	}
+	stdout("Rolling restart of %s complete", template)
+	return 0
+}
+
+// reasonUnitGone is the reason given for instances destroyed while they
+// were being restarted
+const reasonUnitGone = "unit no longer exists"
+
+// restartInstances stops the given instances, starts them again and waits
+// for them to become active, returning the reason each failed instance
+// failed for. A timeout of 0 means no limit.
+func restartInstances(names []string, timeout, sleep time.Duration) map[string]string {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	failed := make(map[string]string)
+
+	// An instance which failed while being stopped is only counted as
+	// failing to start once systemd has reported it in another state, so
+	// that the state published for the stop is not mistaken for it.
+	stoppedFailed := make(map[string]bool)
+
+	stopping := setInstanceTargetStates(names, job.JobStateLoaded, failed)
+	waitForInstances(stopping, deadline, sleep, failed, func(name string, u *schema.Unit, us *schema.UnitState) (bool, string) {
+		if job.JobState(u.CurrentState) != job.JobStateLoaded {
+			return false, ""
+		}
+		if us == nil || us.SystemdActiveState == "inactive" {
+			return true, ""
+		}
+		if us.SystemdActiveState == "failed" {
+			stoppedFailed[name] = true
+			return true, ""
+		}
+		return false, ""
+	})
+
+	// Every instance is launched again, including those which failed to
+	// stop, so that none of them is left stopped.
+	var relaunch []string
+	for _, name := range names {
+		if failed[name] != reasonUnitGone {
+			relaunch = append(relaunch, name)
+		}
+	}
+	launched := setInstanceTargetStates(relaunch, job.JobStateLaunched, failed)
+
+	var pending []string
+	for _, name := range launched {
+		if _, ok := failed[name]; !ok {
+			pending = append(pending, name)
+		}
+	}
+	waitForInstances(pending, deadline, sleep, failed, func(name string, u *schema.Unit, us *schema.UnitState) (bool, string) {
+		if us == nil {
+			return false, ""
+		}
+		switch us.SystemdActiveState {
+		case "active":
+			return true, ""
+		case "failed":
+			if !stoppedFailed[name] {
+				return false, "unit failed"
+			}
+		default:
+			stoppedFailed[name] = false
+		}
+		return false, ""
+	})
+
+	return failed
+}
+
+// setInstanceTargetStates sets the target state of each of the given
+// instances, returning the names of those for which it succeeded. The
+// first reason an instance failed for is kept.
+func setInstanceTargetStates(names []string, js job.JobState, failed map[string]string) []string {
+	var ok []string
+	for _, name := range names {
+		if err := cAPI.SetUnitTargetState(name, string(js)); err != nil {
+			if _, ok := failed[name]; !ok {
+				failed[name] = err.Error()
+			}
+			continue
+		}
+		ok = append(ok, name)
+	}
+	return ok
+}
+
+// waitForInstances polls the given instances until check reports each of
+// them done or failed, or the deadline passes. Instances which are not done
+// are recorded in failed, and the names of those which are done returned.
+func waitForInstances(names []string, deadline time.Time, sleep time.Duration, failed map[string]string, check func(string, *schema.Unit, *schema.UnitState) (bool, string)) []string {
+	var done []string
+	pending := names
+	for len(pending) > 0 {
+		states, err := cAPI.UnitStates()
+		if err != nil {
+			log.Warningf("Error retrieving unit states from Registry: %v", err)
+		}
+
+		var next []string
+		for _, name := range pending {
+			u, err := cAPI.Unit(name)
+			if err != nil {
+				log.Warningf("Error retrieving Unit(%s) from Registry: %v", name, err)
+				next = append(next, name)
+				continue
+			}
+			if u == nil {
+				failed[name] = reasonUnitGone
+				continue
+			}
+
+			var us *schema.UnitState
+			for _, s := range states {
+				if s.Name == name && (u.MachineID == "" || s.MachineID == u.MachineID) {
+					us = s
+					break
+				}
+			}
+
+			ok, reason := check(name, u, us)
+			switch {
+			case reason != "":
+				failed[name] = reason
+			case ok:
+				done = append(done, name)
+			default:
+				next = append(next, name)
+			}
+		}
+
+		pending = next
+		if len(pending) == 0 {
+			break
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			for _, name := range pending {
+				failed[name] = "timed out"
+			}
+			break
+		}
+		time.Sleep(sleep)
+	}
+	return done
+}
@@ -0,0 +1,189 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/unit"
+)
+
+// restartRegistry acts on target states as the agents would, reporting
+// the instances in fail as failed whenever they are started. The agents
+// never act on the target states of the instances in stuck.
+type restartRegistry struct {
+	registry.FakeRegistry
+	fail    map[string]bool
+	stuck   map[string]bool
+	current map[string]job.JobState
+	active  map[string]string
+	started []string
+}
+
+func (r *restartRegistry) SetUnitTargetState(name string, target job.JobState) error {
+	if err := r.FakeRegistry.SetUnitTargetState(name, target); err != nil {
+		return err
+	}
+	if r.stuck[name] {
+		return nil
+	}
+	r.current[name] = target
+	switch {
+	case target != job.JobStateLaunched:
+		r.active[name] = "inactive"
+	case r.fail[name]:
+		r.active[name] = "failed"
+	default:
+		r.active[name] = "active"
+	}
+	if target == job.JobStateLaunched {
+		r.started = append(r.started, name)
+	}
+	return nil
+}
+
+func (r *restartRegistry) ScheduledUnit(name string) (*job.ScheduledUnit, error) {
+	su, err := r.FakeRegistry.ScheduledUnit(name)
+	if su != nil {
+		js := r.current[name]
+		su.State = &js
+	}
+	return su, err
+}
+
+func (r *restartRegistry) UnitStates() ([]*unit.UnitState, error) {
+	var states []*unit.UnitState
+	for name, as := range r.active {
+		states = append(states, &unit.UnitState{UnitName: name, ActiveState: as, MachineID: "XXX"})
+	}
+	return states, nil
+}
+
+func newRestartRegistry(names []string, fail ...string) *restartRegistry {
+	r := &restartRegistry{
+		FakeRegistry: *registry.NewFakeRegistry(),
+		fail:         make(map[string]bool),
+		stuck:        make(map[string]bool),
+		current:      make(map[string]job.JobState),
+		active:       make(map[string]string),
+	}
+	var jobs []job.Job
+	for _, name := range names {
+		jobs = append(jobs, job.Job{Name: name, TargetState: job.JobStateLaunched, TargetMachineID: "XXX"})
+		r.current[name] = job.JobStateLaunched
+		r.active[name] = "active"
+	}
+	r.SetJobs(jobs)
+	for _, name := range fail {
+		r.fail[name] = true
+	}
+	return r
+}
+
+func TestLaunchedInstances(t *testing.T) {
+	reg := registry.NewFakeRegistry()
+	reg.SetJobs([]job.Job{
+		{Name: "foo@2.service", TargetState: job.JobStateLaunched},
+		{Name: "foo@1.service", TargetState: job.JobStateLaunched},
+		{Name: "foo@3.service", TargetState: job.JobStateLoaded},
+		{Name: "foo@.service", TargetState: job.JobStateLaunched},
+		{Name: "bar@1.service", TargetState: job.JobStateLaunched},
+		{Name: "foo.service", TargetState: job.JobStateLaunched},
+		{Name: "foo@4.service", TargetState: job.JobStateLaunched, Unit: *newUnitFile(t, "[X-Fleet]\nGlobal=true")},
+	})
+	cAPI = &client.RegistryClient{Registry: reg}
+
+	units, err := cAPI.Units()
+	if err != nil {
+		t.Fatalf("unexpected error retrieving units: %v", err)
+	}
+	want := []string{"foo@1.service", "foo@2.service"}
+	if got := launchedInstances(units, "foo@.service"); !reflect.DeepEqual(want, got) {
+		t.Errorf("expected instances %v, got %v", want, got)
+	}
+}
+
+func TestRollingRestart(t *testing.T) {
+	defer func() {
+		restartFlags.BatchSize = 1
+		restartFlags.InstanceTimeout = 5 * time.Minute
+		restartFlags.FailureThreshold = 0
+	}()
+	restartFlags.InstanceTimeout = 0
+
+	names := []string{"foo@1.service", "foo@2.service", "foo@3.service"}
+	tests := []struct {
+		batch     int
+		threshold int
+		fail      []string
+		exit      int
+		started   []string
+	}{
+		{1, 0, nil, 0, names},
+		{2, 0, nil, 0, names},
+		// the first failure stops the restart by default
+		{1, 0, []string{"foo@2.service"}, 1, []string{"foo@1.service", "foo@2.service"}},
+		// the whole batch is restarted before the restart stops
+		{2, 0, []string{"foo@1.service"}, 1, []string{"foo@1.service", "foo@2.service"}},
+		// failures below the threshold are carried past, but still fail
+		{1, 1, []string{"foo@2.service"}, 1, names},
+	}
+
+	for i, tt := range tests {
+		restartFlags.BatchSize = tt.batch
+		restartFlags.FailureThreshold = tt.threshold
+		reg := newRestartRegistry(names, tt.fail...)
+		cAPI = &client.RegistryClient{Registry: reg}
+
+		if exit := rollingRestart("foo@.service", names, time.Millisecond); exit != tt.exit {
+			t.Errorf("case %d: expected exit code %d, got %d", i, tt.exit, exit)
+		}
+		if !reflect.DeepEqual(tt.started, reg.started) {
+			t.Errorf("case %d: expected %v to be started, got %v", i, tt.started, reg.started)
+		}
+	}
+}
+
+func TestRestartInstancesTimeout(t *testing.T) {
+	names := []string{"foo@1.service", "foo@2.service"}
+	reg := newRestartRegistry(names)
+	// the agent never gets round to stopping the first instance
+	reg.stuck["foo@1.service"] = true
+	cAPI = &client.RegistryClient{Registry: reg}
+
+	failed := restartInstances(names, 10*time.Millisecond, time.Millisecond)
+	if want := map[string]string{"foo@1.service": "timed out"}; !reflect.DeepEqual(want, failed) {
+		t.Errorf("expected failures %v, got %v", want, failed)
+	}
+	if want := []string{"foo@2.service"}; !reflect.DeepEqual(want, reg.started) {
+		t.Errorf("expected %v to be started, got %v", want, reg.started)
+	}
+
+	// the instance which did not stop in time is not left stopped
+	for _, name := range names {
+		u, err := reg.Unit(name)
+		if err != nil {
+			t.Fatalf("unexpected error retrieving %s: %v", name, err)
+		}
+		if u.TargetState != job.JobStateLaunched {
+			t.Errorf("expected %s to be launched, got target state %s", name, u.TargetState)
+		}
+	}
+}
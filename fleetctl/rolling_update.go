@@ -0,0 +1,243 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/unit"
+)
+
+var (
+	flagBatchSize       int
+	flagInstanceTimeout time.Duration
+	flagCanaries        int
+	flagSoak            time.Duration
+	flagPromote         bool
+	flagAbort           bool
+	flagRolloutStatus   bool
+	cmdRollingUpdate    = &Command{
+		Name:    "rolling-update",
+		Summary: "Replace every instance of a template unit with a new version, a batch at a time.",
+		Usage:   "[--batch-size=N] [--instance-timeout=DURATION] [--canaries=N] [--soak=DURATION] [--status|--promote|--abort] [--no-block] TEMPLATE_FILE",
+		Description: `Replace the template unit in the cluster with the given local unit file, then
+have the fleet engine replace the running instances of that template with the
+new version. Instances are replaced in batches, and the next batch is only
+replaced once every instance of the current batch reports itself active. If
+any replaced instance fails, or does not become active within the instance
+timeout, the update stops.
+
+With --canaries, only the given number of instances is replaced at first. The
+rest of the update is held until every canary has been active for the soak
+period, after which it proceeds in batches as usual. A held update may be
+resumed straight away with --promote, or stopped with --abort; --status shows
+the progress of the current update of a template.
+
+By default fleetctl blocks until the update completes or fails, printing its
+progress. Use --no-block to return as soon as the update has started.
+
+Update every instance of foo@.service, two at a time:
+	fleetctl rolling-update --batch-size=2 foo@.service
+
+Update a single canary instance of foo@.service first, and the rest once it has
+been active for an hour:
+	fleetctl rolling-update --canaries=1 --soak=1h foo@.service`,
+		Run: runRollingUpdate,
+	}
+)
+
+func init() {
+	cmdRollingUpdate.Flags.IntVar(&flagBatchSize, "batch-size", 1, "Maximum number of instances replaced at once.")
+	cmdRollingUpdate.Flags.DurationVar(&flagInstanceTimeout, "instance-timeout", 10*time.Minute, "Fail the update if a replaced instance does not become active within this long. A value of 0 means no limit.")
+	cmdRollingUpdate.Flags.IntVar(&flagCanaries, "canaries", 0, "Number of instances replaced before the rest of the update is held for the soak period. A value of 0 disables canaries.")
+	cmdRollingUpdate.Flags.DurationVar(&flagSoak, "soak", 5*time.Minute, "How long every canary must have been active before the remaining instances are replaced.")
+	cmdRollingUpdate.Flags.BoolVar(&flagPromote, "promote", false, "Resume a rolling update held for its canaries without waiting for the soak period.")
+	cmdRollingUpdate.Flags.BoolVar(&flagAbort, "abort", false, "Stop a running rolling update, leaving the instances already replaced as they are.")
+	cmdRollingUpdate.Flags.BoolVar(&flagRolloutStatus, "status", false, "Print the progress of the current rolling update of a template and exit.")
+	cmdRollingUpdate.Flags.BoolVar(&sharedFlags.NoBlock, "no-block", false, "Do not wait until the rolling update has finished before exiting.")
+}
+
+func runRollingUpdate(args []string) (exit int) {
+	if len(args) != 1 {
+		stderr("One template unit file must be provided.")
+		return 1
+	}
+	if flagBatchSize < 1 {
+		stderr("Batch size must be at least 1.")
+		return 1
+	}
+	if flagInstanceTimeout < 0 {
+		stderr("Instance timeout must not be negative.")
+		return 1
+	}
+	if flagCanaries < 0 || flagSoak < 0 {
+		stderr("Canaries and soak period must not be negative.")
+		return 1
+	}
+
+	name := path.Base(args[0])
+	uni := unit.NewUnitNameInfo(name)
+	if uni == nil || uni.IsInstance() || uni.Template != name {
+		stderr("Unit %s is not a template unit.", name)
+		return 1
+	}
+
+	switch {
+	case flagPromote && flagAbort:
+		stderr("Only one of --promote and --abort may be given.")
+		return 1
+	case flagRolloutStatus:
+		return printRolloutStatus(name)
+	case flagPromote:
+		return controlRollout(name, job.RolloutControlPromote)
+	case flagAbort:
+		return controlRollout(name, job.RolloutControlAbort)
+	}
+
+	uf, err := getUnitFromFile(args[0])
+	if err != nil {
+		stderr("Failed getting Unit(%s) from file: %v", name, err)
+		return 1
+	}
+
+	ro := job.Rollout{
+		Template:  name,
+		Unit:      *uf,
+		BatchSize: flagBatchSize,
+		Timeout:   flagInstanceTimeout,
+		Canaries:  flagCanaries,
+		Soak:      flagSoak,
+		State:     job.RolloutStateRunning,
+	}
+	if err := cAPI.CreateRollout(&ro); err != nil {
+		stderr("Error starting rolling update of %s: %v", name, err)
+		return 1
+	}
+
+	if sharedFlags.NoBlock {
+		stdout("Triggered rolling update of %s", name)
+		return
+	}
+
+	return waitForRollout(name, time.Second)
+}
+
+// controlRollout gives an instruction to the running Rollout of the given
+// template, then waits for it unless asked not to block
+func controlRollout(name string, c job.RolloutControl) int {
+	ro, err := cAPI.Rollout(name)
+	if err != nil {
+		stderr("Error retrieving rolling update of %s: %v", name, err)
+		return 1
+	}
+	if ro == nil || ro.Done() {
+		stderr("No rolling update of %s is running", name)
+		return 1
+	}
+	if c == job.RolloutControlPromote && !ro.Canarying() {
+		stderr("Rolling update of %s is not held for canaries", name)
+		return 1
+	}
+
+	if err := cAPI.SetRolloutControl(name, c); err != nil {
+		stderr("Error instructing rolling update of %s to %s: %v", name, c, err)
+		return 1
+	}
+
+	if sharedFlags.NoBlock {
+		stdout("Instructed rolling update of %s to %s", name, c)
+		return 0
+	}
+	return waitForRollout(name, time.Second)
+}
+
+// printRolloutStatus prints the progress of the current Rollout of the
+// given template
+func printRolloutStatus(name string) int {
+	ro, err := cAPI.Rollout(name)
+	if err != nil {
+		stderr("Error retrieving rolling update of %s: %v", name, err)
+		return 1
+	}
+	if ro == nil {
+		stderr("No rolling update of %s exists", name)
+		return 1
+	}
+
+	stdout("Rolling update of %s %s: %d/%d instances updated", name, ro.State, len(ro.Updated), ro.Total)
+	if len(ro.InProgress) > 0 {
+		stdout("In progress: %s", strings.Join(ro.InProgress, " "))
+	}
+	if ro.Canarying() {
+		stdout("%s", canaryStatus(ro))
+	}
+	if ro.Reason != "" {
+		stdout("Reason: %s", ro.Reason)
+	}
+	return 0
+}
+
+// canaryStatus describes the canary phase of a Rollout
+func canaryStatus(ro *job.Rollout) string {
+	if ro.SoakingSince.IsZero() {
+		return fmt.Sprintf("Waiting for %d canaries of %s to become active", ro.Canaries, ro.Template)
+	}
+	return fmt.Sprintf("Canaries of %s active, holding until %s", ro.Template, ro.SoakingSince.Add(ro.Soak).Format(time.RFC3339))
+}
+
+// waitForRollout polls the Rollout of the given template until it is
+// done, printing progress whenever the number of updated instances or the
+// state of its canaries changes
+func waitForRollout(name string, sleep time.Duration) int {
+	last := -1
+	var lastCanary string
+	for {
+		ro, err := cAPI.Rollout(name)
+		if err != nil {
+			stderr("Error retrieving rolling update of %s: %v", name, err)
+			return 1
+		}
+		if ro == nil {
+			stderr("Rolling update of %s no longer exists", name)
+			return 1
+		}
+
+		if len(ro.Updated) != last {
+			last = len(ro.Updated)
+			stdout("Updated %d/%d instances of %s", last, ro.Total, name)
+		}
+		if ro.Canarying() {
+			if status := canaryStatus(ro); status != lastCanary {
+				lastCanary = status
+				stdout("%s", status)
+			}
+		}
+
+		switch ro.State {
+		case job.RolloutStateComplete:
+			stdout("Rolling update of %s complete", name)
+			return 0
+		case job.RolloutStateFailed:
+			stderr("Rolling update of %s failed: %s", name, ro.Reason)
+			return 1
+		}
+
+		time.Sleep(sleep)
+	}
+}
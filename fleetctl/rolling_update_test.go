@@ -0,0 +1,151 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestRunRollingUpdateInvalidArgs(t *testing.T) {
+	defer func() {
+		flagBatchSize = 1
+		flagInstanceTimeout = 10 * time.Minute
+	}()
+
+	tests := []struct {
+		args    []string
+		batch   int
+		timeout time.Duration
+	}{
+		// exactly one template must be given
+		{nil, 1, 0},
+		{[]string{"foo@.service", "bar@.service"}, 1, 0},
+		// only templates can be updated
+		{[]string{"foo.service"}, 1, 0},
+		{[]string{"foo@1.service"}, 1, 0},
+		// batches must not be empty
+		{[]string{"foo@.service"}, 0, 0},
+		// timeouts must not be negative
+		{[]string{"foo@.service"}, 1, -time.Second},
+	}
+
+	for i, tt := range tests {
+		flagBatchSize = tt.batch
+		flagInstanceTimeout = tt.timeout
+		if exit := runRollingUpdate(tt.args); exit != 1 {
+			t.Errorf("case %d: expected exit code 1, got %d", i, exit)
+		}
+	}
+}
+
+// rolloutProgressRegistry returns the Rollout of a template as running
+// for a number of polls before reporting its final state
+type rolloutProgressRegistry struct {
+	registry.FakeRegistry
+	polls int
+	final job.RolloutState
+}
+
+func (r *rolloutProgressRegistry) Rollout(template string) (*job.Rollout, error) {
+	ro, err := r.FakeRegistry.Rollout(template)
+	if ro == nil || err != nil {
+		return ro, err
+	}
+	if r.polls > 0 {
+		r.polls--
+		ro.State = job.RolloutStateRunning
+	} else {
+		ro.State = r.final
+	}
+	return ro, nil
+}
+
+func TestWaitForRollout(t *testing.T) {
+	tests := []struct {
+		create bool
+		final  job.RolloutState
+		exit   int
+	}{
+		{true, job.RolloutStateComplete, 0},
+		{true, job.RolloutStateFailed, 1},
+		// the rollout went away
+		{false, job.RolloutStateComplete, 1},
+	}
+
+	for i, tt := range tests {
+		reg := &rolloutProgressRegistry{FakeRegistry: *registry.NewFakeRegistry(), polls: 2, final: tt.final}
+		if tt.create {
+			ro := &job.Rollout{Template: "foo@.service", Unit: unit.UnitFile{}, BatchSize: 1, State: job.RolloutStateRunning}
+			if err := reg.CreateRollout(ro); err != nil {
+				t.Fatalf("case %d: unexpected error creating rollout: %v", i, err)
+			}
+		}
+		cAPI = &client.RegistryClient{Registry: reg}
+
+		if exit := waitForRollout("foo@.service", time.Millisecond); exit != tt.exit {
+			t.Errorf("case %d: expected exit code %d, got %d", i, tt.exit, exit)
+		}
+		if tt.create && reg.polls != 0 {
+			t.Errorf("case %d: returned before rollout finished", i)
+		}
+	}
+}
+
+func TestControlRollout(t *testing.T) {
+	defer func() { sharedFlags.NoBlock = false }()
+	sharedFlags.NoBlock = true
+
+	tests := []struct {
+		ro   *job.Rollout
+		c    job.RolloutControl
+		exit int
+	}{
+		// a running rollout may be aborted
+		{&job.Rollout{Template: "foo@.service", State: job.RolloutStateRunning}, job.RolloutControlAbort, 0},
+		// only rollouts held for canaries may be promoted
+		{&job.Rollout{Template: "foo@.service", State: job.RolloutStateRunning}, job.RolloutControlPromote, 1},
+		{&job.Rollout{Template: "foo@.service", State: job.RolloutStateRunning, Canaries: 1}, job.RolloutControlPromote, 0},
+		// finished rollouts cannot be instructed
+		{&job.Rollout{Template: "foo@.service", State: job.RolloutStateComplete}, job.RolloutControlAbort, 1},
+		{nil, job.RolloutControlAbort, 1},
+	}
+
+	for i, tt := range tests {
+		reg := registry.NewFakeRegistry()
+		if tt.ro != nil {
+			if err := reg.CreateRollout(tt.ro); err != nil {
+				t.Fatalf("case %d: unexpected error creating rollout: %v", i, err)
+			}
+		}
+		cAPI = &client.RegistryClient{Registry: reg}
+
+		if exit := controlRollout("foo@.service", tt.c); exit != tt.exit {
+			t.Errorf("case %d: expected exit code %d, got %d", i, tt.exit, exit)
+			continue
+		}
+		if tt.exit != 0 {
+			continue
+		}
+		if ro, _ := reg.Rollout("foo@.service"); ro.Control != tt.c {
+			t.Errorf("case %d: expected control %q, got %q", i, tt.c, ro.Control)
+		}
+	}
+}
@@ -35,6 +35,9 @@ Show status of a single unit:
 Show status of an entire directory with glob matching:
 fleetctl status myservice/*
 
+Units the agent refused to load, as they failed its validation, are not
+known to systemd; the reason they failed is shown instead.
+
 This command does not work with global units.`,
 	Run: runStatusUnits,
 }
@@ -72,12 +75,30 @@ func runStatusUnits(args []string) (exit int) {
 		}
 	}
 
+	states, err := cAPI.UnitStates()
+	if err != nil {
+		stderr("Error retrieving unit states: %v", err)
+		return 1
+	}
+	reasons := make(map[string]string)
+	for _, us := range states {
+		if us.FailureReason != "" {
+			reasons[us.Name] = us.FailureReason
+		}
+	}
+
 	for i, name := range names {
 		// This extra newline is here to match systemctl status output
 		if i != 0 {
 			fmt.Printf("\n")
 		}
 
+		if reason, ok := reasons[name]; ok {
+			fmt.Printf("%s failed validation on machine %s: %s\n", name, uMap[name].MachineID, reason)
+			exit = 1
+			break
+		}
+
 		cmd := fmt.Sprintf("systemctl status -l %s", name)
 		if exit = runCommand(cmd, uMap[name].MachineID); exit != 0 {
 			break
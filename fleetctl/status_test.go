@@ -0,0 +1,48 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestStatusInvalidUnit(t *testing.T) {
+	js := job.JobStateLoaded
+	reg := registry.NewFakeRegistry()
+	reg.SetJobs([]job.Job{
+		job.Job{Name: "hello.service", State: &js, TargetMachineID: "XXX"},
+	})
+	reg.SetUnitStates([]unit.UnitState{
+		unit.UnitState{
+			UnitName:      "hello.service",
+			LoadState:     "error",
+			ActiveState:   "failed",
+			SubState:      "invalid",
+			MachineID:     "XXX",
+			FailureReason: "ExecStart: executable /usr/bin/hello not found",
+		},
+	})
+	cAPI = &client.RegistryClient{Registry: reg}
+
+	// the reason is reported without reaching out to the machine
+	if exit := runStatusUnits([]string{"hello.service"}); exit != 1 {
+		t.Errorf("Expected exit code 1, got %d", exit)
+	}
+}
@@ -32,7 +32,7 @@ Submit a directory of units with glob matching:
 }
 
 func init() {
-	cmdSubmitUnit.Flags.BoolVar(&sharedFlags.Sign, "sign", false, "DEPRECATED - this option cannot be used")
+	cmdSubmitUnit.Flags.BoolVar(&sharedFlags.Sign, "sign", false, "Sign unit files with the keys held by the local ssh-agent")
 }
 
 func runSubmitUnits(args []string) (exit int) {
@@ -0,0 +1,238 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/resource"
+	"github.com/coreos/fleet/schema"
+)
+
+const (
+	topSortCPU    = "cpu"
+	topSortMemory = "memory"
+	topSortName   = "name"
+)
+
+var (
+	topFlags = struct {
+		Sort     string
+		Limit    int
+		Once     bool
+		Interval time.Duration
+	}{}
+
+	cmdTop = &Command{
+		Name:    "top",
+		Summary: "Show how the capacity of the cluster is used",
+		Usage:   "[-l|--full] [--sort=cpu|memory|name] [--limit=N] [--once] [--interval=DURATION]",
+		Description: `Shows, for each machine, the CPU and memory in use out of its capacity, the
+number of units loaded there and how much of the machine those units use,
+followed by the usage of each unit. The overview is refreshed until
+interrupted.
+
+Machines publish their capacity with every heartbeat. The usage of units is
+only known if their fleetd samples it, as configured by usage_interval.
+
+Show the ten units using the most memory:
+	fleetctl top --sort=memory --limit=10
+
+Print the overview once, such as from a script:
+	fleetctl top --once`,
+		Run: runTop,
+	}
+)
+
+func init() {
+	cmdTop.Flags.BoolVar(&sharedFlags.Full, "full", false, "Do not ellipsize fields on output")
+	cmdTop.Flags.BoolVar(&sharedFlags.Full, "l", false, "Shorthand for --full")
+	cmdTop.Flags.StringVar(&topFlags.Sort, "sort", topSortCPU, fmt.Sprintf("Order machines and units by %q or %q in use, most first, or by %q.", topSortCPU, topSortMemory, topSortName))
+	cmdTop.Flags.IntVar(&topFlags.Limit, "limit", 0, "Show at most N units. A value of 0 indicates no limit.")
+	cmdTop.Flags.BoolVar(&topFlags.Once, "once", false, "Print the overview once rather than refreshing it.")
+	cmdTop.Flags.DurationVar(&topFlags.Interval, "interval", 5*time.Second, "Time between refreshes of the overview.")
+}
+
+func runTop(args []string) (exit int) {
+	switch topFlags.Sort {
+	case topSortCPU, topSortMemory, topSortName:
+	default:
+		stderr("Invalid sort order %q, must be one of %q, %q or %q", topFlags.Sort, topSortCPU, topSortMemory, topSortName)
+		return 1
+	}
+	if topFlags.Interval <= 0 {
+		stderr("Interval must be positive.")
+		return 1
+	}
+
+	for {
+		machines, err := cAPI.Machines()
+		if err != nil {
+			stderr("Error retrieving list of active machines: %v", err)
+			return 1
+		}
+		states, err := cAPI.UnitStates()
+		if err != nil {
+			stderr("Error retrieving list of units from repository: %v", err)
+			return 1
+		}
+
+		if !topFlags.Once {
+			fmt.Fprint(os.Stdout, clearScreen)
+		}
+		tms, units := summarizeTop(machines, states, topFlags.Sort, topFlags.Limit)
+		printTop(tms, units)
+
+		if topFlags.Once {
+			return 0
+		}
+		time.Sleep(topFlags.Interval)
+	}
+}
+
+// topMachine sums up the units loaded on a Machine and their usage
+type topMachine struct {
+	machine.MachineState
+	units int
+	usage resource.ResourceTuple
+}
+
+// used returns the CPU and memory in use on the Machine, or nil if the
+// Machine does not publish its resources
+func (tm *topMachine) used() *resource.ResourceTuple {
+	if tm.Resources == nil {
+		return nil
+	}
+	used := resource.Sub(tm.Resources.Total, tm.Resources.Free)
+	return &used
+}
+
+// summarizeTop sums up the usage of the units loaded on each Machine, and
+// returns the Machines and the states of the units in the given order,
+// keeping at most limit units if it is positive
+func summarizeTop(machines []machine.MachineState, states []*schema.UnitState, order string, limit int) ([]*topMachine, []*schema.UnitState) {
+	tms := make([]*topMachine, len(machines))
+	byID := make(map[string]*topMachine, len(machines))
+	for i, ms := range machines {
+		tms[i] = &topMachine{MachineState: ms}
+		byID[ms.ID] = tms[i]
+	}
+
+	for _, us := range states {
+		tm, ok := byID[us.MachineID]
+		if !ok {
+			continue
+		}
+		tm.units++
+		if us.Usage != nil {
+			tm.usage.Cores += int(us.Usage.Cores)
+			tm.usage.Memory += int(us.Usage.Memory)
+		}
+	}
+
+	sort.Stable(topMachinesBy{tms, order})
+
+	units := make([]*schema.UnitState, len(states))
+	copy(units, states)
+	sort.Stable(topUnitsBy{units, order})
+	if limit > 0 && len(units) > limit {
+		units = units[:limit]
+	}
+	return tms, units
+}
+
+func printTop(tms []*topMachine, units []*schema.UnitState) {
+	fmt.Fprintln(out, "MACHINE\tUNITS\tCPU\tMEMORY\tUNITS CPU\tUNITS MEMORY")
+	for _, tm := range tms {
+		cpu, mem := "-", "-"
+		if used := tm.used(); used != nil {
+			cpu = fmt.Sprintf("%s/%s", formatCores(used.Cores), formatCores(tm.Resources.Total.Cores))
+			mem = fmt.Sprintf("%s/%s", formatMB(used.Memory), formatMB(tm.Resources.Total.Memory))
+		}
+		fmt.Fprintf(out, "%s\t%d\t%s\t%s\t%s\t%s\n", machineIDLegend(tm.MachineState, sharedFlags.Full), tm.units, cpu, mem, formatCores(tm.usage.Cores), formatMB(tm.usage.Memory))
+	}
+	out.Flush()
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "UNIT\tMACHINE\tACTIVE\tCPU\tMEMORY")
+	for _, us := range units {
+		cpu, mem := "-", "-"
+		if us.Usage != nil {
+			cpu, mem = formatCores(int(us.Usage.Cores)), formatMB(int(us.Usage.Memory))
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\n", us.Name, machineIDLegend(machine.MachineState{ID: us.MachineID}, sharedFlags.Full), us.SystemdActiveState, cpu, mem)
+	}
+	out.Flush()
+}
+
+// topMachinesBy orders Machines by the CPU or memory in use, most first,
+// or by ID. Machines not publishing their resources come last.
+type topMachinesBy struct {
+	tms   []*topMachine
+	order string
+}
+
+func (s topMachinesBy) Len() int      { return len(s.tms) }
+func (s topMachinesBy) Swap(i, j int) { s.tms[i], s.tms[j] = s.tms[j], s.tms[i] }
+func (s topMachinesBy) Less(i, j int) bool {
+	a, b := s.tms[i], s.tms[j]
+	if s.order == topSortName {
+		return a.ID < b.ID
+	}
+	return topMore(a.used(), b.used(), s.order)
+}
+
+// topUnitsBy orders the states of units by the CPU or memory they use,
+// most first, or by name. Units of unknown usage come last.
+type topUnitsBy struct {
+	units []*schema.UnitState
+	order string
+}
+
+func (s topUnitsBy) Len() int      { return len(s.units) }
+func (s topUnitsBy) Swap(i, j int) { s.units[i], s.units[j] = s.units[j], s.units[i] }
+func (s topUnitsBy) Less(i, j int) bool {
+	a, b := s.units[i], s.units[j]
+	if s.order == topSortName {
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.MachineID < b.MachineID
+	}
+	return topMore(mapSchemaUsage(a.Usage), mapSchemaUsage(b.Usage), s.order)
+}
+
+// topMore reports whether a uses more of the resource given by order than
+// b, a nil usage being less than any
+func topMore(a, b *resource.ResourceTuple, order string) bool {
+	if a == nil || b == nil {
+		return a != nil
+	}
+	if order == topSortMemory {
+		return a.Memory > b.Memory
+	}
+	return a.Cores > b.Cores
+}
+
+func mapSchemaUsage(r *schema.Resources) *resource.ResourceTuple {
+	if r == nil {
+		return nil
+	}
+	return &resource.ResourceTuple{Cores: int(r.Cores), Memory: int(r.Memory)}
+}
@@ -0,0 +1,81 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/resource"
+	"github.com/coreos/fleet/schema"
+)
+
+func TestSummarizeTop(t *testing.T) {
+	machines := []machine.MachineState{
+		{ID: "XXX", Resources: &machine.Resources{
+			Total: resource.ResourceTuple{Cores: 200, Memory: 4096},
+			Free:  resource.ResourceTuple{Cores: 150, Memory: 1024},
+		}},
+		{ID: "YYY", Resources: &machine.Resources{
+			Total: resource.ResourceTuple{Cores: 400, Memory: 4096},
+			Free:  resource.ResourceTuple{Cores: 100, Memory: 3072},
+		}},
+		{ID: "ZZZ"},
+	}
+	states := []*schema.UnitState{
+		{Name: "a.service", MachineID: "XXX", Usage: &schema.Resources{Cores: 20, Memory: 2048}},
+		{Name: "b.service", MachineID: "XXX", Usage: &schema.Resources{Cores: 10, Memory: 512}},
+		{Name: "c.service", MachineID: "YYY", Usage: &schema.Resources{Cores: 250, Memory: 256}},
+		{Name: "d.service", MachineID: "YYY"},
+	}
+
+	names := func(tms []*topMachine, units []*schema.UnitState) (got []string) {
+		for _, tm := range tms {
+			got = append(got, tm.ID)
+		}
+		for _, us := range units {
+			got = append(got, us.Name)
+		}
+		return
+	}
+
+	tests := []struct {
+		order string
+		limit int
+		want  []string
+	}{
+		{topSortCPU, 0, []string{"YYY", "XXX", "ZZZ", "c.service", "a.service", "b.service", "d.service"}},
+		{topSortMemory, 0, []string{"XXX", "YYY", "ZZZ", "a.service", "b.service", "c.service", "d.service"}},
+		{topSortName, 2, []string{"XXX", "YYY", "ZZZ", "a.service", "b.service"}},
+	}
+	for i, tt := range tests {
+		tms, units := summarizeTop(machines, states, tt.order, tt.limit)
+		if got := names(tms, units); !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("case %d: expected order %v, got %v", i, tt.want, got)
+		}
+	}
+
+	tms, _ := summarizeTop(machines, states, topSortName, 0)
+	if tm := tms[0]; tm.units != 2 || tm.usage != (resource.ResourceTuple{Cores: 30, Memory: 2560}) {
+		t.Errorf("expected 2 units using %v on XXX, got %d using %v", resource.ResourceTuple{Cores: 30, Memory: 2560}, tm.units, tm.usage)
+	}
+	if used := tms[1].used(); used == nil || *used != (resource.ResourceTuple{Cores: 300, Memory: 1024}) {
+		t.Errorf("unexpected resources used on YYY: %v", used)
+	}
+	if used := tms[2].used(); used != nil {
+		t.Errorf("expected unknown resources used on ZZZ, got %v", used)
+	}
+}
@@ -14,14 +14,89 @@
 
 package main
 
-var cmdVerifyUnit = &Command{
-	Name:    "verify",
-	Summary: "DEPRECATED - No longer works",
-	Usage:   "UNIT",
-	Run:     runVerifyUnit,
+import (
+	"errors"
+
+	gossh "github.com/coreos/fleet/Godeps/_workspace/src/golang.org/x/crypto/ssh"
+
+	"github.com/coreos/fleet/schema"
+	"github.com/coreos/fleet/sign"
+	"github.com/coreos/fleet/ssh"
+)
+
+var (
+	cmdVerifyUnit = &Command{
+		Name:    "verify",
+		Summary: "Check that a submitted unit is signed by a key held by the local ssh-agent",
+		Usage:   "UNIT",
+		Description: `Verifies the signatures recorded for a unit submitted with --sign against the
+contents of the unit in the cluster and the keys held by the local ssh-agent.
+Agents configured with verify_units make the same check, against the keys of
+their authorized_keys_file, before loading the unit.
+
+	fleetctl verify foo.service`,
+		Run: runVerifyUnit,
+	}
+
+	// unitSigners returns the keys with which units are signed
+	unitSigners = agentSigners
+)
+
+// agentSigners returns the keys held by the local ssh-agent
+func agentSigners() ([]gossh.Signer, error) {
+	agent, err := ssh.SSHAgentClient()
+	if err != nil {
+		return nil, err
+	}
+	signers, err := agent.Signers()
+	if err != nil {
+		return nil, err
+	}
+	if len(signers) == 0 {
+		return nil, errors.New("ssh-agent holds no keys to sign with")
+	}
+	return signers, nil
 }
 
 func runVerifyUnit(args []string) (exit int) {
-	stderr("WARNING: The signed/verified units feature is DEPRECATED and cannot be used.")
-	return 2
+	if len(args) != 1 {
+		stderr("One unit must be provided")
+		return 1
+	}
+
+	name := unitNameMangle(args[0])
+	u, err := cAPI.Unit(name)
+	if err != nil {
+		stderr("Error retrieving Unit %s: %v", name, err)
+		return 1
+	}
+	if u == nil {
+		stderr("Unit %s not found", name)
+		return 1
+	}
+
+	sigs, err := cAPI.UnitSignatures(name)
+	if err != nil {
+		stderr("Error retrieving signatures of Unit %s: %v", name, err)
+		return 1
+	}
+
+	signers, err := unitSigners()
+	if err != nil {
+		stderr("Unable to get keys to verify with: %v", err)
+		return 1
+	}
+	keys := make([]gossh.PublicKey, 0, len(signers))
+	for _, s := range signers {
+		keys = append(keys, s.PublicKey())
+	}
+
+	uf := schema.MapSchemaUnitOptionsToUnitFile(u.Options)
+	if err := sign.NewVerifier(keys).Verify(name, *uf, sigs); err != nil {
+		stderr("Unit %s failed verification: %v", name, err)
+		return 1
+	}
+
+	stdout("Unit %s is signed by a trusted key", name)
+	return
 }
@@ -0,0 +1,85 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	gossh "github.com/coreos/fleet/Godeps/_workspace/src/golang.org/x/crypto/ssh"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/registry"
+)
+
+func TestSignAndVerifyUnit(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reg := registry.NewFakeRegistry()
+	cAPI = &client.RegistryClient{Registry: reg}
+	oldSigners := unitSigners
+	unitSigners = func() ([]gossh.Signer, error) { return []gossh.Signer{signer}, nil }
+	defer func() {
+		unitSigners = oldSigners
+		sharedFlags.Sign = false
+	}()
+
+	// units created without --sign carry no signatures
+	if _, err := createUnit("unsigned.service", newUnitFile(t, "[Service]\nExecStart=/bin/true")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code := runVerifyUnit([]string{"unsigned.service"}); code == 0 {
+		t.Errorf("expected verification of unsigned unit to fail")
+	}
+
+	sharedFlags.Sign = true
+	if _, err := createUnit("signed.service", newUnitFile(t, "[Service]\nExecStart=/bin/true")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sigs, _ := reg.UnitSignatures("signed.service"); len(sigs) != 1 {
+		t.Fatalf("expected 1 signature, got %d", len(sigs))
+	}
+	if code := runVerifyUnit([]string{"signed.service"}); code != 0 {
+		t.Errorf("expected verification of signed unit to succeed, got exit code %d", code)
+	}
+
+	// changing the unit behind fleetctl's back invalidates its signatures
+	sigs, _ := reg.UnitSignatures("signed.service")
+	reg.DestroyUnit("signed.service")
+	reg.CreateUnit(&job.Unit{Name: "signed.service", Unit: *newUnitFile(t, "[Service]\nExecStart=/bin/false")})
+	reg.SetUnitSignatures("signed.service", sigs)
+	if code := runVerifyUnit([]string{"signed.service"}); code == 0 {
+		t.Errorf("expected verification of tampered unit to fail")
+	}
+
+	unitSigners = func() ([]gossh.Signer, error) { return nil, errors.New("no keys") }
+	if _, err := createUnit("nokeys.service", newUnitFile(t, "[Service]\nExecStart=/bin/true")); err == nil {
+		t.Errorf("expected signing without keys to fail")
+	}
+	if u, _ := reg.Unit("nokeys.service"); u != nil {
+		t.Errorf("expected unit failing to be signed not to be created")
+	}
+}
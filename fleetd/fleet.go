@@ -27,7 +27,9 @@ import (
 
 	"github.com/coreos/fleet/agent"
 	"github.com/coreos/fleet/config"
+	"github.com/coreos/fleet/engine"
 	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/pkg"
 	"github.com/coreos/fleet/registry"
 	"github.com/coreos/fleet/server"
 	"github.com/coreos/fleet/version"
@@ -57,18 +59,74 @@ func main() {
 
 	cfgset := flag.NewFlagSet("fleet", flag.ExitOnError)
 	cfgset.Int("verbosity", 0, "Logging level")
+	cfgset.String("registry_url", registry.DefaultBackendURL, fmt.Sprintf("URL of the registry backend, whose scheme is one of %q", strings.Join(registry.BackendSchemes(), ",")))
+	cfgset.Bool("read_only", false, "Refuse all changes to the registry, observing the cluster without acting on it")
+	cfgset.Bool("dry_run", false, "Log every action the agent would take on its units, and why it would refuse any, without carrying them out or changing the registry")
+	cfgset.Bool("audit_log", false, "Record each Unit created, destroyed, scheduled or given a target state in an append-only log in the registry")
+	cfgset.String("namespace", "", "Namespace of the registry holding this fleet cluster, letting several clusters share one etcd cluster")
+	cfgset.Float64("registry_cache_max_age", 5.0, "Maximum age in seconds of the units and machines the API serves from memory. 0 disables caching.")
 	cfgset.Var(&stringSlice{}, "etcd_servers", "List of etcd endpoints")
+	cfgset.String("etcd_discovery_srv", "", "Domain whose _etcd-client._tcp and _etcd-client-ssl._tcp SRV records name the etcd endpoints, in place of etcd_servers")
 	cfgset.String("etcd_keyfile", "", "SSL key file used to secure etcd communication")
 	cfgset.String("etcd_certfile", "", "SSL certification file used to secure etcd communication")
 	cfgset.String("etcd_cafile", "", "SSL Certificate Authority file used to secure etcd communication")
+	cfgset.String("etcd_username", "", "Username used to authenticate to etcd")
+	cfgset.String("etcd_password", "", "Password used to authenticate to etcd")
+	cfgset.String("etcd_password_file", "", "File holding the password used to authenticate to etcd, reread when the password is rejected")
+	cfgset.Bool("etcd_compress_units", false, "Store unit files gzipped in etcd. Only enable once every fleetd and fleetctl in the cluster supports it.")
+	cfgset.String("unit_encryption_key_file", "", "File holding the hex-encoded AES key with which unit files are encrypted in etcd. Must be the same across the cluster.")
+	cfgset.Int("unit_history_limit", 0, "Number of past revisions to retain of each unit, including units since destroyed, set for the whole cluster when fleetd starts. 0 leaves the limit of the cluster alone.")
 	cfgset.String("etcd_key_prefix", registry.DefaultKeyPrefix, "Keyspace for fleet data in etcd")
 	cfgset.Float64("etcd_request_timeout", 1.0, "Amount of time in seconds to allow a single etcd request before considering it failed.")
+	cfgset.Int("etcd_retry_attempts", 3, "Number of times an etcd request failing with a transient error is made before giving up. Set to 1 to disable retries.")
+	cfgset.Float64("etcd_retry_backoff", 0.1, "Amount of time in seconds to wait before retrying a failed etcd request, doubling with each retry.")
+	cfgset.Float64("etcd_retry_max_backoff", 1.0, "Greatest amount of time in seconds to wait before retrying a failed etcd request.")
+	cfgset.Float64("etcd_request_rate", 0.0, "Greatest average number of etcd requests per second fleetd should make. 0 means no limit.")
+	cfgset.Int("etcd_request_burst", 10, "Number of etcd requests fleetd may make at once in excess of etcd_request_rate.")
+	cfgset.Int("etcd_max_idle_conns", 2, "Number of idle connections to each etcd endpoint kept open for reuse.")
+	cfgset.Float64("etcd_dial_timeout", 30.0, "Amount of time in seconds to allow establishing a connection to etcd. 0 means no limit.")
+	cfgset.Float64("etcd_keepalive", 30.0, "Interval in seconds between TCP keepalive probes on connections to etcd.")
+	cfgset.Int("etcd_endpoint_failures", 3, "Number of consecutive failed requests to an etcd endpoint after which it is tried last. 0 disables rotation.")
 	cfgset.Float64("engine_reconcile_interval", 2.0, "Interval at which the engine should reconcile the cluster schedule in etcd.")
+	cfgset.Float64("engine_reconcile_jitter", 0.0, "Maximum random amount of time in seconds added to each engine reconcile interval.")
+	cfgset.Float64("engine_reconcile_debounce", 0.0, "Amount of time in seconds the engine waits for a burst of cluster changes to end before reconciling. 0 reconciles on every change.")
+	cfgset.Float64("engine_lease_ttl", 0.0, "Amount of time in seconds for which the engine leader lease is valid. 0 means five times the engine reconcile interval.")
+	cfgset.Float64("engine_lease_renew_interval", 0.0, "Interval in seconds at which the engine renews its leader lease. 0 means the engine reconcile interval.")
+	cfgset.Float64("engine_reschedule_grace_period", 0.0, "Amount of time in seconds the engine should wait after a machine disappears before rescheduling its units.")
+	cfgset.Int("engine_max_schedule_per_reconcile", 0, "Maximum number of units the engine should schedule in a single reconciliation. 0 means no limit.")
+	cfgset.Int("max_units_per_machine", 0, "Maximum number of units the engine should schedule to a single machine, unless overridden by the machine's max_units metadata. 0 means no limit.")
+	cfgset.Int("engine_shards", 1, "Number of partitions of the schedule, each of which may be reconciled by a different engine. Must be the same across the cluster.")
+	cfgset.Float64("engine_rebalance_interval", 0.0, "Interval in seconds at which the engine moves units from the most to the least loaded machines. 0 disables rebalancing.")
+	cfgset.String("engine_rebalance_by", "", "Machine metadata key by which the engine balances units across groups of machines. If empty, each machine is balanced individually.")
+	cfgset.Int("engine_rebalance_max_moves", 1, "Maximum number of units the engine should move in a single rebalancing pass.")
+	cfgset.String("engine_scorer_weights", engine.DefaultScorerWeights, "Comma-separated list of name=weight pairs giving the weight of each scorer the engine uses to choose between machines able to run a unit.")
+	cfgset.Int("engine_decision_history", 0, "Number of scheduling decisions the engine should record in etcd for each unit. 0 disables recording.")
+	cfgset.Float64("engine_gc_interval", 600, "Interval in seconds at which the engine removes orphaned state from etcd. 0 disables garbage collection.")
+	cfgset.Int("engine_reconcile_workers", 1, "Number of scheduling decisions the engine should persist to etcd concurrently.")
+	cfgset.Float64("engine_resync_interval", 60.0, "Maximum amount of time in seconds the engine should go without rescanning the cluster when no changes have been observed. 0 rescans on every reconciliation.")
 	cfgset.String("public_ip", "", "IP address that fleet machine should publish")
 	cfgset.String("metadata", "", "List of key-value metadata to assign to the fleet machine")
+	cfgset.String("taints", "", "List of taints keeping units that do not tolerate them off the fleet machine")
 	cfgset.String("agent_ttl", agent.DefaultTTL, "TTL in seconds of fleet machine state in etcd")
-	cfgset.Bool("verify_units", false, "DEPRECATED - This option is ignored")
-	cfgset.String("authorized_keys_file", "", "DEPRECATED - This option is ignored")
+	cfgset.String("agent_heartbeat_interval", "", "Interval at which the agent refreshes the presence of its machine, the heartbeats of its units and their state in etcd. Must be less than agent_ttl. Defaults to half of agent_ttl.")
+	cfgset.String("shutdown_mode", server.ShutdownModeUnload, "What the agent does with its units when fleetd shuts down: \"unload\" stops and unloads them, \"keep\" leaves them running.")
+	cfgset.Float64("shutdown_timeout", 60.0, "Amount of time in seconds to wait for units to be stopped and unloaded when fleetd shuts down in the \"unload\" shutdown mode. 0 means no limit.")
+	cfgset.Float64("usage_interval", 10.0, "Interval in seconds at which the agent samples the CPU and memory used by each unit, published along with its state. 0 disables sampling.")
+	cfgset.String("agent_state_file", agent.DefaultStateFile, "File in which the agent persists the desired state of its units, from which it keeps managing them while etcd is unreachable. If empty, the desired state is only held in memory.")
+	cfgset.Int("agent_task_workers", agent.DefaultTaskWorkers, "Number of units the agent loads, starts, stops or unloads at once. Units are started after, and stopped before, the units they depend on through After= or Requires=.")
+	cfgset.Float64("unit_state_batch_window", 1.0, "Amount of time in seconds during which the agent collects changes to the states of its units before publishing those that still differ at once. 0 publishes every change as it is seen.")
+	cfgset.String("docker_endpoint", "", "Docker API endpoint through which the agent runs units declaring an [X-Docker] section as containers, such as unix:///var/run/docker.sock. If empty, such units are not supported.")
+	cfgset.String("rkt_path", "", "Path to the rkt binary with which the agent runs units declaring an [X-Rkt] section as pods. If empty, such units are not supported.")
+	cfgset.String("unit_hooks_dir", "", "Directory of executables the agent runs before loading, after starting, before stopping and after unloading each unit. If empty, no hooks are run.")
+	cfgset.String("unit_log_sink", "", "URL to which the agent forwards the journal entries of its units, tagged with the unit name and machine ID: syslog+udp://HOST:PORT, syslog+tcp://HOST:PORT or an http(s):// endpoint. If empty, logs are not forwarded.")
+	cfgset.String("unit_slice", "", "systemd slice, such as fleet.slice, in which the agent places the units it loads. Units already running move to it once restarted. If empty, units are placed in the default slice of systemd.")
+	cfgset.Bool("systemd_user", false, "Have the agent manage its units through the user instance of systemd (systemd --user) of the user running fleetd, rather than the system instance.")
+	cfgset.Int("unit_start_retries", agent.DefaultStartRetries, "Number of times the agent restarts a unit that fails once started before reporting it failed, so that the engine reschedules it to another machine.")
+	cfgset.Float64("unit_start_retry_backoff", agent.DefaultStartRetryBackoff.Seconds(), "Amount of time in seconds the agent waits before restarting a failed unit, doubling with each restart.")
+	cfgset.String("maintenance_windows", "", "Comma-separated recurring windows, such as \"Sun 02:00-04:00\", during which the machine is drained. Times are in the local time zone of the machine, and \"*\" stands for every day.")
+	cfgset.Bool("verify_units", false, "Refuse to load units unless signed by a key listed in authorized_keys_file")
+	cfgset.String("unit_policy_file", "", "File restricting the directives of the units the agent loads, such as denied directives, required sandboxing options and denied executables. Units violating it are reported failed instead of being loaded.")
+	cfgset.String("authorized_keys_file", "", "File listing, in the format of an SSH authorized_keys file, the public keys trusted to sign units when verify_units is set")
 
 	globalconf.Register("", cfgset)
 	cfg, err := getConfig(cfgset, *cfgPath)
@@ -83,26 +141,43 @@ func main() {
 	}
 	srv.Run()
 
+	// reconfigure applies what it can of a reloaded config to the running
+	// Server, restarting the server components only when other options
+	// have changed. A config which cannot be used is logged and ignored.
 	reconfigure := func() {
 		log.Infof("Reloading configuration from %s", *cfgPath)
 
 		cfg, err := getConfig(cfgset, *cfgPath)
 		if err != nil {
-			log.Fatalf(err.Error())
+			log.Errorf("Failed reloading configuration, keeping the current one: %v", err)
+			return
+		}
+
+		err = srv.Reconfigure(*cfg)
+		if err == nil {
+			log.Infof("Applied configuration to running server components")
+			return
+		} else if err != server.ErrRestartRequired {
+			log.Errorf("Failed applying configuration, keeping the current one: %v", err)
+			return
 		}
 
 		log.Infof("Restarting server components")
 		srv.Stop()
 
-		srv, err = server.New(*cfg)
+		next, err := server.New(*cfg)
 		if err != nil {
-			log.Fatalf(err.Error())
+			log.Errorf("Failed creating Server, resuming with the current configuration: %v", err)
+			srv.Run()
+			return
 		}
+		srv = next
 		srv.Run()
 	}
 
 	shutdown := func() {
 		log.Infof("Gracefully shutting down")
+		pkg.SdNotify("STOPPING=1")
 		srv.Stop()
 		srv.Purge()
 		os.Exit(0)
@@ -141,13 +216,13 @@ func getConfig(flagset *flag.FlagSet, userCfgFile string) (*config.Config, error
 	opts := globalconf.Options{EnvPrefix: "FLEET_"}
 
 	if userCfgFile != "" {
-		// Fail hard if a user-provided config is not usable
+		// Unlike the default config, a user-provided config must be usable
 		fi, err := os.Stat(userCfgFile)
 		if err != nil {
-			log.Fatalf("Unable to use config file %s: %v", userCfgFile, err)
+			return nil, fmt.Errorf("unable to use config file %s: %v", userCfgFile, err)
 		}
 		if fi.IsDir() {
-			log.Fatalf("Provided config %s is a directory, not a file", userCfgFile)
+			return nil, fmt.Errorf("provided config %s is a directory, not a file", userCfgFile)
 		}
 
 		log.Infof("Using provided config file %s", userCfgFile)
@@ -165,33 +240,93 @@ func getConfig(flagset *flag.FlagSet, userCfgFile string) (*config.Config, error
 		return nil, err
 	}
 
+	// options removed from the config since it was last read, such as
+	// before a reload, fall back to their defaults
+	flagset.VisitAll(func(f *flag.Flag) {
+		if ss, ok := f.Value.(*stringSlice); ok {
+			*ss = nil
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+	})
 	gconf.ParseSet("", flagset)
 
 	cfg := config.Config{
 		Verbosity:               (*flagset.Lookup("verbosity")).Value.(flag.Getter).Get().(int),
+		RegistryURL:             (*flagset.Lookup("registry_url")).Value.(flag.Getter).Get().(string),
+		ReadOnly:                (*flagset.Lookup("read_only")).Value.(flag.Getter).Get().(bool),
+		DryRun:                  (*flagset.Lookup("dry_run")).Value.(flag.Getter).Get().(bool),
+		AuditLog:                (*flagset.Lookup("audit_log")).Value.(flag.Getter).Get().(bool),
+		RegistryCacheMaxAge:     (*flagset.Lookup("registry_cache_max_age")).Value.(flag.Getter).Get().(float64),
+		Namespace:               (*flagset.Lookup("namespace")).Value.(flag.Getter).Get().(string),
 		EtcdServers:             (*flagset.Lookup("etcd_servers")).Value.(flag.Getter).Get().(stringSlice),
+		EtcdDiscoverySRV:        (*flagset.Lookup("etcd_discovery_srv")).Value.(flag.Getter).Get().(string),
+		UnitEncryptionKeyFile:   (*flagset.Lookup("unit_encryption_key_file")).Value.(flag.Getter).Get().(string),
+		UnitHistoryLimit:        (*flagset.Lookup("unit_history_limit")).Value.(flag.Getter).Get().(int),
 		EtcdKeyPrefix:           (*flagset.Lookup("etcd_key_prefix")).Value.(flag.Getter).Get().(string),
 		EtcdKeyFile:             (*flagset.Lookup("etcd_keyfile")).Value.(flag.Getter).Get().(string),
 		EtcdCertFile:            (*flagset.Lookup("etcd_certfile")).Value.(flag.Getter).Get().(string),
 		EtcdCAFile:              (*flagset.Lookup("etcd_cafile")).Value.(flag.Getter).Get().(string),
+		EtcdUsername:            (*flagset.Lookup("etcd_username")).Value.(flag.Getter).Get().(string),
+		EtcdPassword:            (*flagset.Lookup("etcd_password")).Value.(flag.Getter).Get().(string),
+		EtcdPasswordFile:        (*flagset.Lookup("etcd_password_file")).Value.(flag.Getter).Get().(string),
+		EtcdCompressUnits:       (*flagset.Lookup("etcd_compress_units")).Value.(flag.Getter).Get().(bool),
 		EtcdRequestTimeout:      (*flagset.Lookup("etcd_request_timeout")).Value.(flag.Getter).Get().(float64),
+		EtcdRetryAttempts:       (*flagset.Lookup("etcd_retry_attempts")).Value.(flag.Getter).Get().(int),
+		EtcdRetryBackoff:        (*flagset.Lookup("etcd_retry_backoff")).Value.(flag.Getter).Get().(float64),
+		EtcdRetryMaxBackoff:     (*flagset.Lookup("etcd_retry_max_backoff")).Value.(flag.Getter).Get().(float64),
+		EtcdRequestRate:         (*flagset.Lookup("etcd_request_rate")).Value.(flag.Getter).Get().(float64),
+		EtcdRequestBurst:        (*flagset.Lookup("etcd_request_burst")).Value.(flag.Getter).Get().(int),
+		EtcdMaxIdleConns:        (*flagset.Lookup("etcd_max_idle_conns")).Value.(flag.Getter).Get().(int),
+		EtcdDialTimeout:         (*flagset.Lookup("etcd_dial_timeout")).Value.(flag.Getter).Get().(float64),
+		EtcdKeepalive:           (*flagset.Lookup("etcd_keepalive")).Value.(flag.Getter).Get().(float64),
+		EtcdEndpointFailures:    (*flagset.Lookup("etcd_endpoint_failures")).Value.(flag.Getter).Get().(int),
 		EngineReconcileInterval: (*flagset.Lookup("engine_reconcile_interval")).Value.(flag.Getter).Get().(float64),
+		EngineReconcileJitter:   (*flagset.Lookup("engine_reconcile_jitter")).Value.(flag.Getter).Get().(float64),
+		EngineReconcileDebounce: (*flagset.Lookup("engine_reconcile_debounce")).Value.(flag.Getter).Get().(float64),
+		EngineLeaseTTL:          (*flagset.Lookup("engine_lease_ttl")).Value.(flag.Getter).Get().(float64),
+		EngineLeaseRenewal:      (*flagset.Lookup("engine_lease_renew_interval")).Value.(flag.Getter).Get().(float64),
+		EngineRescheduleGrace:   (*flagset.Lookup("engine_reschedule_grace_period")).Value.(flag.Getter).Get().(float64),
+		EngineResyncInterval:    (*flagset.Lookup("engine_resync_interval")).Value.(flag.Getter).Get().(float64),
+		EngineMaxSchedule:       (*flagset.Lookup("engine_max_schedule_per_reconcile")).Value.(flag.Getter).Get().(int),
+		EngineShards:            (*flagset.Lookup("engine_shards")).Value.(flag.Getter).Get().(int),
+		MaxUnitsPerMachine:      (*flagset.Lookup("max_units_per_machine")).Value.(flag.Getter).Get().(int),
+		EngineRebalanceInterval: (*flagset.Lookup("engine_rebalance_interval")).Value.(flag.Getter).Get().(float64),
+		EngineRebalanceBy:       (*flagset.Lookup("engine_rebalance_by")).Value.(flag.Getter).Get().(string),
+		EngineRebalanceMoves:    (*flagset.Lookup("engine_rebalance_max_moves")).Value.(flag.Getter).Get().(int),
+		EngineScorerWeights:     (*flagset.Lookup("engine_scorer_weights")).Value.(flag.Getter).Get().(string),
+		EngineDecisionHistory:   (*flagset.Lookup("engine_decision_history")).Value.(flag.Getter).Get().(int),
+		EngineGCInterval:        (*flagset.Lookup("engine_gc_interval")).Value.(flag.Getter).Get().(float64),
+		EngineReconcileWorkers:  (*flagset.Lookup("engine_reconcile_workers")).Value.(flag.Getter).Get().(int),
 		PublicIP:                (*flagset.Lookup("public_ip")).Value.(flag.Getter).Get().(string),
 		RawMetadata:             (*flagset.Lookup("metadata")).Value.(flag.Getter).Get().(string),
+		RawTaints:               (*flagset.Lookup("taints")).Value.(flag.Getter).Get().(string),
 		AgentTTL:                (*flagset.Lookup("agent_ttl")).Value.(flag.Getter).Get().(string),
+		AgentHeartbeatInterval:  (*flagset.Lookup("agent_heartbeat_interval")).Value.(flag.Getter).Get().(string),
+		ShutdownMode:            (*flagset.Lookup("shutdown_mode")).Value.(flag.Getter).Get().(string),
+		ShutdownTimeout:         (*flagset.Lookup("shutdown_timeout")).Value.(flag.Getter).Get().(float64),
+		UsageInterval:           (*flagset.Lookup("usage_interval")).Value.(flag.Getter).Get().(float64),
+		AgentStateFile:          (*flagset.Lookup("agent_state_file")).Value.(flag.Getter).Get().(string),
+		AgentTaskWorkers:        (*flagset.Lookup("agent_task_workers")).Value.(flag.Getter).Get().(int),
+		UnitStateBatchWindow:    (*flagset.Lookup("unit_state_batch_window")).Value.(flag.Getter).Get().(float64),
+		UnitHooksDir:            (*flagset.Lookup("unit_hooks_dir")).Value.(flag.Getter).Get().(string),
+		UnitLogSink:             (*flagset.Lookup("unit_log_sink")).Value.(flag.Getter).Get().(string),
+		UnitSlice:               (*flagset.Lookup("unit_slice")).Value.(flag.Getter).Get().(string),
+		SystemdUser:             (*flagset.Lookup("systemd_user")).Value.(flag.Getter).Get().(bool),
+		UnitStartRetries:        (*flagset.Lookup("unit_start_retries")).Value.(flag.Getter).Get().(int),
+		UnitStartRetryBackoff:   (*flagset.Lookup("unit_start_retry_backoff")).Value.(flag.Getter).Get().(float64),
+		MaintenanceWindows:      (*flagset.Lookup("maintenance_windows")).Value.(flag.Getter).Get().(string),
+		DockerEndpoint:          (*flagset.Lookup("docker_endpoint")).Value.(flag.Getter).Get().(string),
+		RktPath:                 (*flagset.Lookup("rkt_path")).Value.(flag.Getter).Get().(string),
 		VerifyUnits:             (*flagset.Lookup("verify_units")).Value.(flag.Getter).Get().(bool),
 		AuthorizedKeysFile:      (*flagset.Lookup("authorized_keys_file")).Value.(flag.Getter).Get().(string),
-	}
-
-	if cfg.VerifyUnits {
-		log.Error("Config option verify_units is no longer supported - ignoring")
-	}
-	if len(cfg.AuthorizedKeysFile) > 0 {
-		log.Error("Config option authorized_keys_file is no longer supported - ignoring")
+		UnitPolicyFile:          (*flagset.Lookup("unit_policy_file")).Value.(flag.Getter).Get().(string),
 	}
 
 	if cfg.Verbosity > 0 {
 		log.EnableDebug()
+	} else {
+		log.DisableDebug()
 	}
 
 	return &cfg, nil
@@ -35,7 +35,16 @@ type machineHeart struct {
 	mach machine.Machine
 }
 
+// resourceMeter is implemented by Machines able to measure their
+// resources, which are then published afresh with each heartbeat
+type resourceMeter interface {
+	RefreshResources()
+}
+
 func (h *machineHeart) Beat(ttl time.Duration) (uint64, error) {
+	if rm, ok := h.mach.(resourceMeter); ok {
+		rm.RefreshResources()
+	}
 	return h.reg.SetMachineState(h.mach.State(), ttl)
 }
 
@@ -21,8 +21,10 @@ import (
 	"github.com/coreos/fleet/log"
 )
 
-func NewMonitor(ttl time.Duration) *Monitor {
-	return &Monitor{ttl, ttl / 2}
+// NewMonitor returns a Monitor beating a Heart with the given TTL at the
+// given interval, which must be less than the TTL
+func NewMonitor(ttl, ival time.Duration) *Monitor {
+	return &Monitor{ttl, ival}
 }
 
 type Monitor struct {
@@ -0,0 +1,48 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"time"
+)
+
+type AuditOperation string
+
+const (
+	AuditOperationCreate         = AuditOperation("create")
+	AuditOperationDestroy        = AuditOperation("destroy")
+	AuditOperationSetTargetState = AuditOperation("set-target-state")
+	AuditOperationSchedule       = AuditOperation("schedule")
+)
+
+// AuditEntry records a single change made to a Job in the Registry
+type AuditEntry struct {
+	// Time is when the change was made
+	Time time.Time
+
+	Operation AuditOperation
+	JobName   string
+
+	// Detail is the target state or Machine ID the Job was given, if any
+	Detail string `json:",omitempty"`
+
+	// MachineID is the Machine from which the change was made, which is
+	// empty for changes made by fleetctl directly against the Registry
+	MachineID string `json:",omitempty"`
+
+	// Identity describes who requested the change, e.g. the engine or
+	// the user and address of an API client
+	Identity string
+}
@@ -0,0 +1,97 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"time"
+)
+
+type CompletionState string
+
+const (
+	CompletionStateSucceeded = CompletionState("succeeded")
+	CompletionStateFailed    = CompletionState("failed")
+	CompletionStateRetrying  = CompletionState("retrying")
+)
+
+// Completion records the outcome of the most recent run of a batch Job
+type Completion struct {
+	// UnitHash identifies the version of the Unit that was run
+	UnitHash string
+
+	// MachineID is the Machine on which the Unit ran
+	MachineID string
+
+	// ExitStatus is the exit status of the Unit's main process
+	ExitStatus int
+
+	// FinishedAt is the time at which the Unit's main process exited
+	FinishedAt time.Time
+
+	// Attempts is the number of times this version of the Unit has run
+	Attempts int
+
+	State CompletionState
+}
+
+// Done determines whether the batch Job has finished for good, either by
+// succeeding or by failing more times than it may be retried
+func (c *Completion) Done() bool {
+	return c.State == CompletionStateSucceeded || c.State == CompletionStateFailed
+}
+
+// MaxRunHistory is the number of runs of a scheduled Job retained in its
+// RunHistory
+const MaxRunHistory = 10
+
+// Run is a single run of a Job with a cron Schedule
+type Run struct {
+	// ScheduledAt is the time at which the run was due
+	ScheduledAt time.Time
+
+	// Completion records the outcome of the run once its Unit has exited.
+	// Until then, Attempts is zero.
+	Completion
+}
+
+// RunHistory tracks the runs of a Job with a cron Schedule
+type RunHistory struct {
+	// NextRun is the time at which the next run is due
+	NextRun time.Time
+
+	// Runs lists the most recent runs, oldest first
+	Runs []Run
+}
+
+// Current returns the run in progress, or nil if there is none
+func (h *RunHistory) Current() *Run {
+	if len(h.Runs) == 0 {
+		return nil
+	}
+	r := &h.Runs[len(h.Runs)-1]
+	if r.Done() {
+		return nil
+	}
+	return r
+}
+
+// Start records the beginning of a new run due at the given time,
+// discarding the oldest runs beyond MaxRunHistory
+func (h *RunHistory) Start(at time.Time) {
+	h.Runs = append(h.Runs, Run{ScheduledAt: at})
+	if len(h.Runs) > MaxRunHistory {
+		h.Runs = h.Runs[len(h.Runs)-MaxRunHistory:]
+	}
+}
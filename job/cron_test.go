@@ -0,0 +1,99 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronScheduleInvalid(t *testing.T) {
+	for i, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"@often",
+	} {
+		if _, err := ParseCronSchedule(expr); err == nil {
+			t.Errorf("case %d: expected error parsing %q", i, expr)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2014, time.October, 15, 10, 30, 20, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2014, time.October, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2014, time.October, 15, 10, 45, 0, 0, time.UTC)},
+		{"30 * * * *", time.Date(2014, time.October, 15, 11, 30, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2014, time.October, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17 * * *", time.Date(2014, time.October, 15, 11, 0, 0, 0, time.UTC)},
+		{"0 3,6 * * *", time.Date(2014, time.October, 16, 3, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2014, time.October, 16, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2014, time.November, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2014, time.October, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2014, time.October, 16, 0, 0, 0, 0, time.UTC)},
+		// either restricted day field may match
+		{"0 0 1 * 5", time.Date(2014, time.October, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2016, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for i, tt := range tests {
+		cs, err := ParseCronSchedule(tt.expr)
+		if err != nil {
+			t.Errorf("case %d: unexpected error parsing %q: %v", i, tt.expr, err)
+			continue
+		}
+		if got := cs.Next(from); !got.Equal(tt.want) {
+			t.Errorf("case %d: %q: expected next run at %v, got %v", i, tt.expr, tt.want, got)
+		}
+	}
+}
+
+func TestRunHistoryStart(t *testing.T) {
+	var h RunHistory
+	start := time.Unix(0, 0)
+	for i := 0; i < MaxRunHistory+2; i++ {
+		if r := h.Current(); r != nil {
+			r.State = CompletionStateSucceeded
+		}
+		h.Start(start.Add(time.Duration(i) * time.Minute))
+	}
+
+	if len(h.Runs) != MaxRunHistory {
+		t.Fatalf("expected %d runs retained, got %d", MaxRunHistory, len(h.Runs))
+	}
+	if want := start.Add(2 * time.Minute); !h.Runs[0].ScheduledAt.Equal(want) {
+		t.Errorf("expected oldest run due at %v, got %v", want, h.Runs[0].ScheduledAt)
+	}
+	if h.Current() != &h.Runs[MaxRunHistory-1] {
+		t.Errorf("expected latest run to be in progress")
+	}
+}
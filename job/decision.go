@@ -0,0 +1,70 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"time"
+)
+
+type DecisionType string
+
+const (
+	DecisionTypeSchedule   = DecisionType("schedule")
+	DecisionTypeUnschedule = DecisionType("unschedule")
+)
+
+// Decision records a single scheduling decision the engine made about a Job
+type Decision struct {
+	// Time is when the decision was carried out
+	Time time.Time
+
+	Type DecisionType
+
+	// MachineID is the Machine the Job was scheduled to or unscheduled from
+	MachineID string
+
+	// Reason explains why the engine made the decision
+	Reason string
+
+	// Candidates lists the Machines the engine considered when scheduling
+	// the Job, in order of preference. It is empty for other decisions.
+	Candidates []Candidate `json:",omitempty"`
+}
+
+// Candidate describes how the engine rated a Machine when scheduling a Job
+type Candidate struct {
+	MachineID string
+	Able      bool
+
+	// Score is the weighted score of the Machine, where higher is better
+	Score float64
+
+	// Reason explains why the Machine was unable to run the Job, if so
+	Reason string `json:",omitempty"`
+}
+
+// Unschedulable records that the engine has repeatedly been unable to
+// schedule a Job, and when it will next try
+type Unschedulable struct {
+	// Reason explains why the Job could not be scheduled on the most
+	// recent attempt
+	Reason string
+
+	// Failures is the number of consecutive failed attempts
+	Failures int
+
+	// RetryAt is the earliest time of the next attempt
+	RetryAt time.Time
+}
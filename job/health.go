@@ -0,0 +1,154 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type HealthCheckType string
+
+const (
+	HealthCheckTCP  = HealthCheckType("tcp")
+	HealthCheckHTTP = HealthCheckType("http")
+	HealthCheckExec = HealthCheckType("exec")
+
+	DefaultHealthCheckInterval = 10 * time.Second
+	DefaultHealthCheckTimeout  = 5 * time.Second
+	DefaultHealthCheckFailures = 3
+	DefaultHealthCheckRestarts = 3
+)
+
+// HealthCheck describes how the agent running a Job determines whether the
+// Job is healthy, and what it does when it is not
+type HealthCheck struct {
+	Type HealthCheckType
+
+	// Target is the address to connect to for a TCP check, the URL to
+	// request for an HTTP check, or the command line to run for an exec
+	// check
+	Target string
+
+	// Interval is the time between two checks, and Timeout the time
+	// after which a single check is considered failed
+	Interval time.Duration
+	Timeout  time.Duration
+
+	// Failures is the number of consecutive failed checks after which the
+	// Job is restarted, and Restarts the number of restarts after which
+	// it is reported failed rather than restarted again
+	Failures int
+	Restarts int
+}
+
+// HealthCheck returns the HealthCheck declared by the HealthCheck option of
+// the Job, along with the HealthCheckInterval, HealthCheckTimeout,
+// HealthCheckFailures and HealthCheckRestarts options. If the Job has no
+// HealthCheck, nil is returned. An error is returned if any option is
+// invalid.
+func (j *Job) HealthCheck() (*HealthCheck, error) {
+	reqs := j.requirements()
+	last := func(key string) (string, bool) {
+		values := reqs[key]
+		if len(values) == 0 {
+			return "", false
+		}
+		return strings.TrimSpace(values[len(values)-1]), true
+	}
+
+	v, ok := last(fleetHealthCheck)
+	if !ok {
+		return nil, nil
+	}
+	hc, err := parseHealthCheck(v)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range []struct {
+		key string
+		dst *time.Duration
+	}{
+		{fleetHealthCheckInterval, &hc.Interval},
+		{fleetHealthCheckTimeout, &hc.Timeout},
+	} {
+		if v, ok := last(d.key); ok {
+			dur, err := time.ParseDuration(v)
+			if err != nil || dur <= 0 {
+				return nil, fmt.Errorf("invalid value %q for %s: must be a positive duration", v, d.key)
+			}
+			*d.dst = dur
+		}
+	}
+
+	for _, n := range []struct {
+		key string
+		dst *int
+		min int
+	}{
+		{fleetHealthCheckFailures, &hc.Failures, 1},
+		{fleetHealthCheckRestarts, &hc.Restarts, 0},
+	} {
+		if v, ok := last(n.key); ok {
+			i, err := strconv.Atoi(v)
+			if err != nil || i < n.min {
+				return nil, fmt.Errorf("invalid value %q for %s: must be an integer of at least %d", v, n.key, n.min)
+			}
+			*n.dst = i
+		}
+	}
+
+	return hc, nil
+}
+
+// parseHealthCheck parses the value of a HealthCheck option: a tcp://
+// address, an http:// or https:// URL, or exec: followed by a command line
+func parseHealthCheck(v string) (*HealthCheck, error) {
+	hc := &HealthCheck{
+		Interval: DefaultHealthCheckInterval,
+		Timeout:  DefaultHealthCheckTimeout,
+		Failures: DefaultHealthCheckFailures,
+		Restarts: DefaultHealthCheckRestarts,
+	}
+
+	if strings.HasPrefix(v, "exec:") {
+		hc.Type = HealthCheckExec
+		hc.Target = strings.TrimSpace(strings.TrimPrefix(v, "exec:"))
+		if hc.Target == "" {
+			return nil, fmt.Errorf("invalid value %q for %s: missing command", v, fleetHealthCheck)
+		}
+		return hc, nil
+	}
+
+	u, err := url.Parse(v)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid value %q for %s: must be a tcp://, http:// or https:// URL, or exec: followed by a command", v, fleetHealthCheck)
+	}
+	switch u.Scheme {
+	case "tcp":
+		hc.Type = HealthCheckTCP
+		hc.Target = u.Host
+	case "http", "https":
+		hc.Type = HealthCheckHTTP
+		hc.Target = v
+	default:
+		return nil, fmt.Errorf("invalid value %q for %s: unsupported scheme %q", v, fleetHealthCheck, u.Scheme)
+	}
+	return hc, nil
+}
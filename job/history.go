@@ -0,0 +1,49 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"time"
+
+	"github.com/coreos/fleet/unit"
+)
+
+type RevisionEvent string
+
+const (
+	RevisionEventCreate         = RevisionEvent("create")
+	RevisionEventSetTargetState = RevisionEvent("set-target-state")
+	RevisionEventDestroy        = RevisionEvent("destroy")
+)
+
+// Revision records a single change to a Job's contents or target state.
+// The Revisions of a Job outlive it, so that a destroyed Job may be
+// recreated as it was.
+type Revision struct {
+	// Number counts the Revisions of the Job, starting from 1
+	Number int
+
+	// Time is when the change was made
+	Time time.Time
+
+	Event RevisionEvent
+
+	// TargetState is the target state of the Job after the change, which
+	// is empty for its destruction
+	TargetState JobState
+
+	// Unit is the unit file of the Job at the time of the change
+	Unit unit.UnitFile
+}
@@ -0,0 +1,159 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/fleet/unit"
+)
+
+// Instances returns the number of instances of a template Job the engine
+// spreads across the cluster, as given by its Instances option. Such a Job
+// is not scheduled itself: the engine assigns each Machine a share of its
+// instances, which the agent of the Machine names and runs locally.
+func (j *Job) Instances() (int, bool) {
+	values := j.requirements()[fleetInstances]
+	if len(values) == 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(values[len(values)-1]))
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+// validateInstances returns an error if the Instances option is invalid or
+// set on a Unit that is neither a template nor one of its instances
+func (j *Job) validateInstances() error {
+	values := j.requirements()[fleetInstances]
+	if len(values) == 0 {
+		return nil
+	}
+	if _, ok := j.Instances(); !ok {
+		return fmt.Errorf("invalid value %q for %s: must be a positive integer", values[len(values)-1], fleetInstances)
+	}
+	if uni := unit.NewUnitNameInfo(j.Name); uni == nil || uni.Template == "" {
+		return fmt.Errorf("%s can only be set on template units", fleetInstances)
+	}
+	if u := (Unit{Name: j.Name, Unit: j.Unit}); u.IsGlobal() || j.IsBatch() {
+		return fmt.Errorf("%s units cannot be %s or %s", fleetInstances, fleetGlobal, fleetBatch)
+	}
+	return nil
+}
+
+// Instances returns the number of instances the engine spreads across the
+// cluster if the Unit is a template with an Instances option
+func (u *Unit) Instances() (int, bool) {
+	uni := unit.NewUnitNameInfo(u.Name)
+	if uni == nil || uni.Template != u.Name {
+		return 0, false
+	}
+	j := &Job{Name: u.Name, Unit: u.Unit}
+	return j.Instances()
+}
+
+// InstanceName returns the name of the numbered instance of a template
+func InstanceName(template string, n int) string {
+	i := strings.LastIndex(template, "@")
+	return fmt.Sprintf("%s@%d%s", template[:i], n, template[i+1:])
+}
+
+// InstanceSchedule maps the ID of each Machine to the numbers of the
+// instances of a template Unit assigned to it, in ascending order. It is
+// stored in the Registry as ranges, such as "1-200,351".
+type InstanceSchedule map[string][]int
+
+// Count returns the number of instances assigned to any Machine
+func (s InstanceSchedule) Count() (n int) {
+	for _, nums := range s {
+		n += len(nums)
+	}
+	return
+}
+
+func (s InstanceSchedule) MarshalJSON() ([]byte, error) {
+	ranges := make(map[string]string, len(s))
+	for machID, nums := range s {
+		ranges[machID] = FormatInstanceRanges(nums)
+	}
+	return json.Marshal(ranges)
+}
+
+func (s *InstanceSchedule) UnmarshalJSON(b []byte) error {
+	var ranges map[string]string
+	if err := json.Unmarshal(b, &ranges); err != nil {
+		return err
+	}
+	*s = make(InstanceSchedule, len(ranges))
+	for machID, r := range ranges {
+		nums, err := ParseInstanceRanges(r)
+		if err != nil {
+			return err
+		}
+		(*s)[machID] = nums
+	}
+	return nil
+}
+
+// FormatInstanceRanges formats ascending instance numbers as ranges, such
+// as "1-3,7"
+func FormatInstanceRanges(nums []int) string {
+	var parts []string
+	for i := 0; i < len(nums); {
+		j := i
+		for j+1 < len(nums) && nums[j+1] == nums[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(nums[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", nums[i], nums[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseInstanceRanges parses the ranges formatted by FormatInstanceRanges
+func ParseInstanceRanges(s string) ([]int, error) {
+	var nums []int
+	if s == "" {
+		return nums, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid instance range %q", part)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return nil, fmt.Errorf("invalid instance range %q", part)
+			}
+		}
+		for n := first; n <= last; n++ {
+			nums = append(nums, n)
+		}
+	}
+	sort.Ints(nums)
+	return nums, nil
+}
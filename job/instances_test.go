@@ -0,0 +1,90 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJobInstances(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     int
+		valid    bool
+	}{
+		{"foo@.service", "[X-Fleet]\nInstances=500", 500, true},
+		{"foo@.service", "[Service]\nExecStart=/bin/true", 0, true},
+		{"foo@.service", "[X-Fleet]\nInstances=0", 0, false},
+		{"foo@.service", "[X-Fleet]\nInstances=many", 0, false},
+		{"foo@.service", "[X-Fleet]\nInstances=5\nGlobal=true", 5, false},
+		{"foo@.service", "[X-Fleet]\nInstances=5\nBatch=true", 5, false},
+		{"foo.service", "[X-Fleet]\nInstances=5", 5, false},
+	}
+	for i, tt := range tests {
+		j := NewJob(tt.name, *newUnit(t, tt.contents))
+		if n, _ := j.Instances(); n != tt.want {
+			t.Errorf("case %d: expected %d instances, got %d", i, tt.want, n)
+		}
+		if err := j.ValidateRequirements(); (err == nil) != tt.valid {
+			t.Errorf("case %d: expected valid=%t, got error %v", i, tt.valid, err)
+		}
+	}
+
+	// instances created from a scaled template are scheduled as Jobs
+	u := Unit{Name: "foo@1.service", Unit: *newUnit(t, "[X-Fleet]\nInstances=5")}
+	if _, ok := u.Instances(); ok {
+		t.Errorf("expected instance of a scaled template not to be scaled itself")
+	}
+}
+
+func TestInstanceName(t *testing.T) {
+	if got := InstanceName("foo@.service", 12); got != "foo@12.service" {
+		t.Errorf("unexpected instance name %q", got)
+	}
+}
+
+func TestInstanceScheduleJSON(t *testing.T) {
+	s := InstanceSchedule{
+		"XXX": []int{1, 2, 3, 7},
+		"YYY": []int{4, 6},
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `{"XXX":"1-3,7","YYY":"4,6"}`; string(b) != want {
+		t.Errorf("expected %s, got %s", want, b)
+	}
+
+	var got InstanceSchedule
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(s, got) {
+		t.Errorf("expected %v, got %v", s, got)
+	}
+	if got.Count() != 6 {
+		t.Errorf("expected 6 instances, got %d", got.Count())
+	}
+
+	for _, bad := range []string{"a", "3-1", "1-b"} {
+		if _, err := ParseInstanceRanges(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
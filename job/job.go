@@ -16,8 +16,12 @@ package job
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/machine"
 	"github.com/coreos/fleet/pkg"
 	"github.com/coreos/fleet/unit"
 )
@@ -44,8 +48,52 @@ const (
 	fleetConflicts = "Conflicts"
 	// Machine metadata key in the unit file
 	fleetMachineMetadata = "MachineMetadata"
+	// Prefer, but do not require, machines with this specific metadata
+	fleetPreferredMachineMetadata = "PreferredMachineMetadata"
+	// Prefer, but do not require, machines not running units matching these globs
+	fleetPreferredConflicts = "PreferredConflicts"
 	// Require that the unit be scheduled on every machine in the cluster
 	fleetGlobal = "Global"
+	// Attach key=value labels to a unit that other units may refer to
+	fleetLabel = "Label"
+	// Prevent a unit from being collocated with other units carrying a matching label
+	fleetConflictsLabel = "ConflictsLabel"
+	// Spread instances of a template unit across distinct values of a machine metadata key
+	fleetSpreadBy = "SpreadBy"
+	// Require that all units sharing a group name be scheduled together or not at all
+	fleetGroup = "Group"
+	// Amount of time to wait after a unit's machine disappears before rescheduling it
+	fleetRescheduleAfter = "RescheduleAfter"
+	// Run the unit to completion rather than indefinitely
+	fleetBatch = "Batch"
+	// Number of times a failed batch unit should be run again
+	fleetBatchRetries = "BatchRetries"
+	// Run the unit as a batch unit at the times given by a cron expression
+	fleetSchedule = "Schedule"
+	// Allow the unit to run on machines carrying the given taints
+	fleetTolerations = "Tolerations"
+	// Only schedule the unit once the given unit is active somewhere in the cluster
+	fleetStartAfter = "StartAfter"
+	// Stop the given units once the unit is active on its machine
+	fleetReplaces = "Replaces"
+	// Machine facts key in the unit file
+	fleetMachineFacts = "MachineFacts"
+	// Check the health of the unit with a TCP connection, HTTP request or command
+	fleetHealthCheck = "HealthCheck"
+	// Time between two health checks of the unit
+	fleetHealthCheckInterval = "HealthCheckInterval"
+	// Time after which a single health check is considered failed
+	fleetHealthCheckTimeout = "HealthCheckTimeout"
+	// Number of consecutive failed health checks after which the unit is restarted
+	fleetHealthCheckFailures = "HealthCheckFailures"
+	// Number of restarts after which an unhealthy unit is reported failed
+	fleetHealthCheckRestarts = "HealthCheckRestarts"
+	// Number of CPU cores reserved for the unit, such as 0.5
+	fleetCores = "Cores"
+	// Amount of memory reserved for the unit, in MB unless suffixed with M or G
+	fleetMemory = "Memory"
+	// Number of instances of a template unit the engine spreads across the cluster
+	fleetInstances = "Instances"
 
 	deprecatedXPrefix          = "X-"
 	deprecatedXConditionPrefix = "X-Condition"
@@ -62,7 +110,29 @@ var validRequirements = pkg.NewUnsafeSet(
 	fleetConflicts,
 	deprecatedXConditionPrefix+fleetMachineMetadata,
 	fleetMachineMetadata,
+	fleetPreferredMachineMetadata,
+	fleetPreferredConflicts,
 	fleetGlobal,
+	fleetLabel,
+	fleetConflictsLabel,
+	fleetSpreadBy,
+	fleetGroup,
+	fleetRescheduleAfter,
+	fleetBatch,
+	fleetBatchRetries,
+	fleetSchedule,
+	fleetTolerations,
+	fleetStartAfter,
+	fleetReplaces,
+	fleetMachineFacts,
+	fleetHealthCheck,
+	fleetHealthCheckInterval,
+	fleetHealthCheckTimeout,
+	fleetHealthCheckFailures,
+	fleetHealthCheckRestarts,
+	fleetCores,
+	fleetMemory,
+	fleetInstances,
 )
 
 func ParseJobState(s string) (JobState, error) {
@@ -162,6 +232,58 @@ func (u *Unit) RequiredTargetMetadata() map[string]pkg.Set {
 	return j.RequiredTargetMetadata()
 }
 
+func (u *Unit) MetadataSatisfiedBy(ms *machine.MachineState) bool {
+	j := &Job{
+		Name: u.Name,
+		Unit: u.Unit,
+	}
+	return j.MetadataSatisfiedBy(ms)
+}
+
+// UntoleratedTaint returns the first taint of the given Machine that the
+// Unit does not tolerate, if any
+func (u *Unit) UntoleratedTaint(ms *machine.MachineState) (string, bool) {
+	j := &Job{
+		Name: u.Name,
+		Unit: u.Unit,
+	}
+	return j.UntoleratedTaint(ms)
+}
+
+// UnsupportedDirective describes the first directive of the Unit that the
+// systemd of the given Machine does not understand, if any
+func (u *Unit) UnsupportedDirective(ms *machine.MachineState) (string, bool) {
+	j := &Job{
+		Name: u.Name,
+		Unit: u.Unit,
+	}
+	return j.UnsupportedDirective(ms)
+}
+
+func (u *Unit) UnsupportedFeature(ms *machine.MachineState) (string, bool) {
+	j := &Job{
+		Name: u.Name,
+		Unit: u.Unit,
+	}
+	return j.UnsupportedFeature(ms)
+}
+
+func (u *Unit) Labels() map[string]pkg.Set {
+	j := &Job{
+		Name: u.Name,
+		Unit: u.Unit,
+	}
+	return j.Labels()
+}
+
+func (u *Unit) ConflictingLabels() map[string]pkg.Set {
+	j := &Job{
+		Name: u.Name,
+		Unit: u.Unit,
+	}
+	return j.ConflictingLabels()
+}
+
 // requirements returns all relevant options from the [X-Fleet] section of a unit file.
 // Relevant options are identified with a `X-` prefix in the unit.
 // This prefix is stripped from relevant options before being returned.
@@ -194,6 +316,37 @@ func (j *Job) ValidateRequirements() error {
 			return fmt.Errorf("unrecognized requirement in [X-Fleet] section: %q", key)
 		}
 	}
+	if _, err := j.MetadataExpressions(); err != nil {
+		return err
+	}
+	if _, _, err := j.factsExpressions(); err != nil {
+		return err
+	}
+	if values := j.requirements()[fleetRescheduleAfter]; len(values) > 0 {
+		if d, err := time.ParseDuration(strings.TrimSpace(values[len(values)-1])); err != nil || d < 0 {
+			return fmt.Errorf("invalid value %q for %s: must be a non-negative duration", values[len(values)-1], fleetRescheduleAfter)
+		}
+	}
+	if values := j.requirements()[fleetBatchRetries]; len(values) > 0 {
+		if n, err := strconv.Atoi(strings.TrimSpace(values[len(values)-1])); err != nil || n < 0 {
+			return fmt.Errorf("invalid value %q for %s: must be a non-negative integer", values[len(values)-1], fleetBatchRetries)
+		}
+	}
+	if _, err := j.CronSchedule(); err != nil {
+		return err
+	}
+	if _, err := j.HealthCheck(); err != nil {
+		return err
+	}
+	if _, err := j.Resources(); err != nil {
+		return err
+	}
+	if u := (Unit{Name: j.Name, Unit: j.Unit}); j.IsBatch() && u.IsGlobal() {
+		return fmt.Errorf("%s units cannot be %s", fleetBatch, fleetGlobal)
+	}
+	if err := j.validateInstances(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -206,6 +359,14 @@ func (j *Job) Conflicts() []string {
 	return conflicts
 }
 
+// PreferredConflicts returns a list of Job names, or globs of Job names,
+// which this Job would rather not share a machine with, as declared by the
+// PreferredConflicts option. Unlike Conflicts, they do not limit the
+// eligible Machines.
+func (j *Job) PreferredConflicts() []string {
+	return append([]string{}, j.requirements()[fleetPreferredConflicts]...)
+}
+
 // Peers returns a list of Job names that must be scheduled to the same
 // machine as this Job.
 func (j *Job) Peers() []string {
@@ -215,6 +376,18 @@ func (j *Job) Peers() []string {
 	return peers
 }
 
+// StartAfter returns a list of Job names that must be active somewhere in
+// the cluster before this Job is scheduled.
+func (j *Job) StartAfter() []string {
+	return append([]string{}, j.requirements()[fleetStartAfter]...)
+}
+
+// Replaces returns a list of Job names that should be stopped once this
+// Job is active on its target machine.
+func (j *Job) Replaces() []string {
+	return append([]string{}, j.requirements()[fleetReplaces]...)
+}
+
 // RequiredTarget determines whether or not this Job must be scheduled to
 // a specific machine. If such a requirement exists, the first value returned
 // represents the ID of such a machine, while the second value will be a bool
@@ -251,33 +424,301 @@ func (j *Job) RequiredTarget() (string, bool) {
 
 // RequiredTargetMetadata return all machine-related metadata from a Job's
 // requirements. Valid metadata fields are strings of the form `key=value`,
-// where both key and value are not the empty string.
+// where both key and value are not the empty string. Requirements using
+// the expression syntax are returned by MetadataExpressions instead.
 func (j *Job) RequiredTargetMetadata() map[string]pkg.Set {
-	metadata := make(map[string]pkg.Set)
+	var plain []string
+	for _, v := range j.metadataRequirements() {
+		if !isMetadataExpression(v) {
+			plain = append(plain, v)
+		}
+	}
+	return keyValuePairs(plain)
+}
 
-	for _, key := range []string{
-		deprecatedXConditionPrefix + fleetMachineMetadata,
-		fleetMachineMetadata,
-	} {
-		for _, valuePair := range j.requirements()[key] {
-			s := strings.Split(valuePair, "=")
+// MetadataExpressions returns all MachineMetadata requirements of a Job
+// that use the expression syntax, e.g. `region!=eu` or `memory>=8192`.
+// An error is returned if any such requirement is malformed.
+func (j *Job) MetadataExpressions() ([]MetadataExpression, error) {
+	var exprs []MetadataExpression
+	for _, v := range j.metadataRequirements() {
+		if !isMetadataExpression(v) {
+			continue
+		}
+		me, err := ParseMetadataExpression(v)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, *me)
+	}
+	return exprs, nil
+}
 
-			if len(s) != 2 {
-				continue
-			}
+// MetadataSatisfiedBy determines whether the given Machine meets all of
+// the Job's MachineMetadata and MachineFacts requirements. Plain
+// `key=value` requirements for the same key are alternatives, while every
+// expression must hold. A Job with malformed expressions cannot be
+// satisfied by any Machine.
+func (j *Job) MetadataSatisfiedBy(ms *machine.MachineState) bool {
+	if !machine.HasMetadata(ms, j.RequiredTargetMetadata()) {
+		return false
+	}
+	exprs, err := j.MetadataExpressions()
+	if err != nil {
+		log.Debugf("Job(%s) has invalid MachineMetadata: %v", j.Name, err)
+		return false
+	}
+	for _, me := range exprs {
+		if !me.Matches(ms.Metadata) {
+			log.Debugf("Local Metadata does not satisfy expression %s", me.String())
+			return false
+		}
+	}
+	return j.factsSatisfiedBy(ms)
+}
 
-			if len(s[0]) == 0 || len(s[1]) == 0 {
-				continue
-			}
+// factsExpressions parses the MachineFacts requirements of a Job, which
+// take the same forms as MachineMetadata. Plain `key=value` requirements
+// are returned separately from those using the expression syntax.
+func (j *Job) factsExpressions() (map[string]pkg.Set, []MetadataExpression, error) {
+	var plain []string
+	var exprs []MetadataExpression
+	for _, v := range j.requirements()[fleetMachineFacts] {
+		if !isMetadataExpression(v) {
+			plain = append(plain, v)
+			continue
+		}
+		me, err := ParseMetadataExpression(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		exprs = append(exprs, *me)
+	}
+	return keyValuePairs(plain), exprs, nil
+}
 
-			if _, ok := metadata[s[0]]; !ok {
-				metadata[s[0]] = pkg.NewUnsafeSet()
-			}
-			metadata[s[0]].Add(s[1])
+// factsSatisfiedBy determines whether the facts detected about the given
+// Machine meet all of the Job's MachineFacts requirements
+func (j *Job) factsSatisfiedBy(ms *machine.MachineState) bool {
+	plain, exprs, err := j.factsExpressions()
+	if err != nil {
+		log.Debugf("Job(%s) has invalid MachineFacts: %v", j.Name, err)
+		return false
+	}
+	for key, values := range plain {
+		if v, ok := ms.Facts[key]; !ok || !values.Contains(v) {
+			log.Debugf("Local Machine fact %s does not match requirement", key)
+			return false
+		}
+	}
+	for _, me := range exprs {
+		if !me.Matches(ms.Facts) {
+			log.Debugf("Local Machine facts do not satisfy expression %s", me.String())
+			return false
+		}
+	}
+	return true
+}
+
+// Tolerations returns the taints of Machines the Job may run on, as
+// declared by the Tolerations option. Each value may list several taints
+// separated by whitespace.
+func (j *Job) Tolerations() pkg.Set {
+	tolerations := pkg.NewUnsafeSet()
+	for _, v := range j.requirements()[fleetTolerations] {
+		for _, taint := range strings.Fields(v) {
+			tolerations.Add(taint)
 		}
 	}
+	return tolerations
+}
+
+// UntoleratedTaint returns the first taint of the given Machine that the
+// Job does not tolerate, if any. A Job may only run on a tainted Machine
+// if it tolerates all of its taints.
+func (j *Job) UntoleratedTaint(ms *machine.MachineState) (string, bool) {
+	if len(ms.Taints) == 0 {
+		return "", false
+	}
+	tolerations := j.Tolerations()
+	for _, taint := range ms.Taints {
+		if !tolerations.Contains(taint) {
+			return taint, true
+		}
+	}
+	return "", false
+}
+
+// UnsupportedDirective describes the first directive of the Job's Unit
+// that the systemd of the given Machine does not understand, along with
+// the version of systemd introducing it. Machines whose version of systemd
+// is not known are assumed to understand every directive.
+func (j *Job) UnsupportedDirective(ms *machine.MachineState) (string, bool) {
+	v, err := strconv.Atoi(ms.Facts[machine.FactSystemdVersion])
+	if err != nil {
+		return "", false
+	}
+	d, ok := j.Unit.UnsupportedDirective(v)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%s, introduced in systemd %d", d, unit.RequiredSystemdVersion(d)), true
+}
 
-	return metadata
+func (j *Job) metadataRequirements() []string {
+	requirements := j.requirements()
+	var values []string
+	values = append(values, requirements[deprecatedXConditionPrefix+fleetMachineMetadata]...)
+	values = append(values, requirements[fleetMachineMetadata]...)
+	return values
+}
+
+// PreferredMetadata returns the Machine metadata a Job would rather be
+// scheduled next to, as declared by the PreferredMachineMetadata option.
+// Unlike MachineMetadata, preferences do not limit the eligible Machines.
+func (j *Job) PreferredMetadata() map[string]pkg.Set {
+	return j.keyValueRequirements(fleetPreferredMachineMetadata)
+}
+
+// Labels returns the set of labels attached to a Job through the Label
+// option. Labels take the same `key=value` form as MachineMetadata.
+func (j *Job) Labels() map[string]pkg.Set {
+	return j.keyValueRequirements(fleetLabel)
+}
+
+// ConflictingLabels returns the labels which, if carried by any other Job
+// on a given machine, prevent this Job from being scheduled there.
+func (j *Job) ConflictingLabels() map[string]pkg.Set {
+	return j.keyValueRequirements(fleetConflictsLabel)
+}
+
+// keyValueRequirements collects all values of the given requirement keys
+// that are of the form `key=value`, where both key and value are not the
+// empty string. Any malformed values are ignored.
+func (j *Job) keyValueRequirements(keys ...string) map[string]pkg.Set {
+	requirements := j.requirements()
+	var values []string
+	for _, key := range keys {
+		values = append(values, requirements[key]...)
+	}
+	return keyValuePairs(values)
+}
+
+// keyValuePairs parses values of the form `key=value`, grouping the
+// values of each key into a set. Malformed values are ignored.
+func keyValuePairs(values []string) map[string]pkg.Set {
+	pairs := make(map[string]pkg.Set)
+
+	for _, valuePair := range values {
+		s := strings.Split(valuePair, "=")
+
+		if len(s) != 2 {
+			continue
+		}
+
+		if len(s[0]) == 0 || len(s[1]) == 0 {
+			continue
+		}
+
+		if _, ok := pairs[s[0]]; !ok {
+			pairs[s[0]] = pkg.NewUnsafeSet()
+		}
+		pairs[s[0]].Add(s[1])
+	}
+
+	return pairs
+}
+
+// SpreadKey returns the machine metadata key across whose values instances
+// of this Job's template should be spread, as declared by the SpreadBy
+// option. If multiple values are provided, the last one wins. If no such
+// option exists, an empty string and false are returned.
+func (j *Job) SpreadKey() (string, bool) {
+	values := j.requirements()[fleetSpreadBy]
+	if len(values) == 0 {
+		return "", false
+	}
+	last := strings.TrimSpace(values[len(values)-1])
+	return last, len(last) > 0
+}
+
+// Group returns the name of the group of Jobs that must be scheduled
+// together with this Job, as declared by the Group option. If multiple
+// values are provided, the last one wins. If no such option exists, an
+// empty string and false are returned.
+func (j *Job) Group() (string, bool) {
+	values := j.requirements()[fleetGroup]
+	if len(values) == 0 {
+		return "", false
+	}
+	last := strings.TrimSpace(values[len(values)-1])
+	return last, len(last) > 0
+}
+
+// RescheduleAfter returns the amount of time the engine should wait after
+// this Job's target machine disappears before rescheduling it, as declared
+// by the RescheduleAfter option. The value must be parseable by
+// time.ParseDuration. If no valid option exists, zero and false are returned.
+func (j *Job) RescheduleAfter() (time.Duration, bool) {
+	values := j.requirements()[fleetRescheduleAfter]
+	if len(values) == 0 {
+		return 0, false
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(values[len(values)-1]))
+	if err != nil || d < 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+// IsBatch returns whether the Job runs to completion rather than
+// indefinitely, as declared by the Batch option or implied by the
+// Schedule option. Batch Jobs are not rescheduled once they have
+// completed successfully.
+func (j *Job) IsBatch() bool {
+	if len(j.requirements()[fleetSchedule]) > 0 {
+		return true
+	}
+	values := j.requirements()[fleetBatch]
+	if len(values) == 0 {
+		return false
+	}
+	// Last value found wins
+	last := values[len(values)-1]
+	return strings.ToLower(strings.TrimSpace(last)) == "true"
+}
+
+// IsBatch returns whether the Unit runs to completion rather than
+// indefinitely
+func (u *Unit) IsBatch() bool {
+	j := &Job{Name: u.Name, Unit: u.Unit}
+	return j.IsBatch()
+}
+
+// BatchRetries returns the number of times a batch Job should be run again
+// after failing, as declared by the BatchRetries option. If no valid option
+// exists, zero is returned.
+func (j *Job) BatchRetries() int {
+	values := j.requirements()[fleetBatchRetries]
+	if len(values) == 0 {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(values[len(values)-1]))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// CronSchedule returns the times at which the Job should run, as declared
+// by the Schedule option in cron syntax. If no option exists, nil is
+// returned. An error is returned if the expression is invalid.
+func (j *Job) CronSchedule() (*CronSchedule, error) {
+	values := j.requirements()[fleetSchedule]
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return ParseCronSchedule(values[len(values)-1])
 }
 
 func (j *Job) Scheduled() bool {
@@ -18,8 +18,11 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/coreos/fleet/machine"
 	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/resource"
 	"github.com/coreos/fleet/unit"
 )
 
@@ -412,6 +415,16 @@ X-ConditionMachineMetadata="one=abc" "two=def"`,
 				"two":    pkg.NewUnsafeSet("def"),
 			},
 		},
+		// expressions are excluded
+		{
+			`[X-Fleet]
+MachineMetadata=region=us
+MachineMetadata=region!=eu
+MachineMetadata="memory>=8192" "zone in (a,b)"`,
+			map[string]pkg.Set{
+				"region": pkg.NewUnsafeSet("us"),
+			},
+		},
 	}
 	for i, tt := range testCases {
 		j := NewJob("echo.service", *newUnit(t, tt.unit))
@@ -424,6 +437,240 @@ X-ConditionMachineMetadata="one=abc" "two=def"`,
 	}
 }
 
+func TestJobLabels(t *testing.T) {
+	testCases := []struct {
+		unit      string
+		labels    map[string]pkg.Set
+		conflicts map[string]pkg.Set
+	}{
+		// no labels
+		{
+			`[X-Fleet]`,
+			map[string]pkg.Set{},
+			map[string]pkg.Set{},
+		},
+		// labels and conflicting labels are tracked separately
+		{
+			`[X-Fleet]
+Label=tier=frontend
+Label="app=web" "app=api"
+ConflictsLabel=tier=frontend`,
+			map[string]pkg.Set{
+				"tier": pkg.NewUnsafeSet("frontend"),
+				"app":  pkg.NewUnsafeSet("web", "api"),
+			},
+			map[string]pkg.Set{
+				"tier": pkg.NewUnsafeSet("frontend"),
+			},
+		},
+		// bad fields just get ignored
+		{
+			`[X-Fleet]
+Label=tier=
+ConflictsLabel==frontend`,
+			map[string]pkg.Set{},
+			map[string]pkg.Set{},
+		},
+	}
+	for i, tt := range testCases {
+		j := NewJob("echo.service", *newUnit(t, tt.unit))
+		if labels := j.Labels(); !reflect.DeepEqual(labels, tt.labels) {
+			t.Errorf("case %d: labels differ: want %#v, got %#v", i, tt.labels, labels)
+		}
+		if conflicts := j.ConflictingLabels(); !reflect.DeepEqual(conflicts, tt.conflicts) {
+			t.Errorf("case %d: conflicting labels differ: want %#v, got %#v", i, tt.conflicts, conflicts)
+		}
+	}
+}
+
+func TestJobPreferredMetadata(t *testing.T) {
+	testCases := []struct {
+		unit string
+		want map[string]pkg.Set
+	}{
+		{`[X-Fleet]`, map[string]pkg.Set{}},
+		{`[X-Fleet]
+PreferredMachineMetadata=region=us-east
+PreferredMachineMetadata="disk=ssd" "disk=nvme"
+MachineMetadata=region=us-west`, map[string]pkg.Set{
+			"region": pkg.NewUnsafeSet("us-east"),
+			"disk":   pkg.NewUnsafeSet("ssd", "nvme"),
+		}},
+		{`[X-Fleet]
+PreferredMachineMetadata=disk=`, map[string]pkg.Set{}},
+	}
+	for i, tt := range testCases {
+		j := NewJob("echo.service", *newUnit(t, tt.unit))
+		if got := j.PreferredMetadata(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("case %d: preferred metadata differ: want %#v, got %#v", i, tt.want, got)
+		}
+	}
+}
+
+func TestJobMachineFacts(t *testing.T) {
+	ms := &machine.MachineState{
+		Metadata: map[string]string{"arch": "arm"},
+		Facts:    map[string]string{machine.FactArch: "amd64", machine.FactSystemdVersion: "215"},
+	}
+	testCases := []struct {
+		unit string
+		want bool
+	}{
+		{`[X-Fleet]`, true},
+		// facts are matched separately from metadata
+		{`[X-Fleet]
+MachineFacts=arch=amd64`, true},
+		{`[X-Fleet]
+MachineMetadata=arch=amd64`, false},
+		{`[X-Fleet]
+MachineFacts=arch=arm
+MachineFacts=arch=amd64`, true},
+		{`[X-Fleet]
+MachineFacts=systemd_version>=208`, true},
+		{`[X-Fleet]
+MachineFacts=systemd_version>=216`, false},
+		{`[X-Fleet]
+MachineFacts=docker=true`, false},
+	}
+	for i, tt := range testCases {
+		j := NewJob("echo.service", *newUnit(t, tt.unit))
+		if got := j.MetadataSatisfiedBy(ms); got != tt.want {
+			t.Errorf("case %d: expected %t, got %t", i, tt.want, got)
+		}
+	}
+}
+
+func TestJobUntoleratedTaint(t *testing.T) {
+	testCases := []struct {
+		unit   string
+		taints []string
+		taint  string
+		ok     bool
+	}{
+		// untainted machines accept any Job
+		{`[X-Fleet]`, nil, "", false},
+		{`[X-Fleet]`, []string{"dedicated"}, "dedicated", true},
+		{`[X-Fleet]
+Tolerations=dedicated`, []string{"dedicated"}, "", false},
+		// every taint must be tolerated
+		{`[X-Fleet]
+Tolerations=dedicated`, []string{"dedicated", "gpu"}, "gpu", true},
+		{`[X-Fleet]
+Tolerations=dedicated gpu`, []string{"dedicated", "gpu"}, "", false},
+		{`[X-Fleet]
+Tolerations=gpu
+Tolerations=dedicated`, []string{"dedicated", "gpu"}, "", false},
+	}
+	for i, tt := range testCases {
+		j := NewJob("echo.service", *newUnit(t, tt.unit))
+		taint, ok := j.UntoleratedTaint(&machine.MachineState{Taints: tt.taints})
+		if taint != tt.taint || ok != tt.ok {
+			t.Errorf("case %d: UntoleratedTaint returned (%q, %t), want (%q, %t)", i, taint, ok, tt.taint, tt.ok)
+		}
+	}
+}
+
+func TestJobSpreadKey(t *testing.T) {
+	testCases := []struct {
+		unit string
+		key  string
+		ok   bool
+	}{
+		{`[X-Fleet]`, "", false},
+		{`[X-Fleet]
+SpreadBy=zone`, "zone", true},
+		// last value wins
+		{`[X-Fleet]
+SpreadBy=zone
+SpreadBy=rack`, "rack", true},
+		{`[X-Fleet]
+SpreadBy=`, "", false},
+	}
+	for i, tt := range testCases {
+		j := NewJob("echo.service", *newUnit(t, tt.unit))
+		key, ok := j.SpreadKey()
+		if key != tt.key || ok != tt.ok {
+			t.Errorf("case %d: SpreadKey returned (%q, %t), want (%q, %t)", i, key, ok, tt.key, tt.ok)
+		}
+	}
+}
+
+func TestJobGroup(t *testing.T) {
+	testCases := []struct {
+		unit  string
+		group string
+		ok    bool
+	}{
+		{`[X-Fleet]`, "", false},
+		{`[X-Fleet]
+Group=app`, "app", true},
+		{`[X-Fleet]
+Group=%p`, "echo", true},
+	}
+	for i, tt := range testCases {
+		j := NewJob("echo.service", *newUnit(t, tt.unit))
+		group, ok := j.Group()
+		if group != tt.group || ok != tt.ok {
+			t.Errorf("case %d: Group returned (%q, %t), want (%q, %t)", i, group, ok, tt.group, tt.ok)
+		}
+	}
+}
+
+func TestJobRescheduleAfter(t *testing.T) {
+	testCases := []struct {
+		unit string
+		d    time.Duration
+		ok   bool
+	}{
+		{`[X-Fleet]`, 0, false},
+		{`[X-Fleet]
+RescheduleAfter=30s`, 30 * time.Second, true},
+		{`[X-Fleet]
+RescheduleAfter=0`, 0, true},
+		{`[X-Fleet]
+RescheduleAfter=soon`, 0, false},
+		{`[X-Fleet]
+RescheduleAfter=-1s`, 0, false},
+	}
+	for i, tt := range testCases {
+		j := NewJob("echo.service", *newUnit(t, tt.unit))
+		d, ok := j.RescheduleAfter()
+		if d != tt.d || ok != tt.ok {
+			t.Errorf("case %d: RescheduleAfter returned (%v, %t), want (%v, %t)", i, d, ok, tt.d, tt.ok)
+		}
+	}
+}
+
+func TestJobBatch(t *testing.T) {
+	testCases := []struct {
+		unit    string
+		batch   bool
+		retries int
+	}{
+		{`[X-Fleet]`, false, 0},
+		{`[X-Fleet]
+Batch=true`, true, 0},
+		{`[X-Fleet]
+Batch=True
+BatchRetries=3`, true, 3},
+		{`[X-Fleet]
+Batch=false
+BatchRetries=3`, false, 3},
+		{`[X-Fleet]
+Batch=true
+BatchRetries=lots`, true, 0},
+	}
+	for i, tt := range testCases {
+		j := NewJob("echo.service", *newUnit(t, tt.unit))
+		if batch := j.IsBatch(); batch != tt.batch {
+			t.Errorf("case %d: IsBatch returned %t, want %t", i, batch, tt.batch)
+		}
+		if retries := j.BatchRetries(); retries != tt.retries {
+			t.Errorf("case %d: BatchRetries returned %d, want %d", i, retries, tt.retries)
+		}
+	}
+}
+
 func TestInstanceUnitPrintf(t *testing.T) {
 	u := unit.NewUnitNameInfo("foo@bar.waldo")
 	if u == nil {
@@ -529,7 +776,25 @@ func TestValidateRequirements(t *testing.T) {
 		"Conflicts=foo",
 		"X-ConditionMachineMetadata=up=down",
 		"MachineMetadata=true=false",
+		"MachineMetadata=memory>=8192",
+		"PreferredMachineMetadata=disk=ssd",
+		`MachineMetadata="region notin (eu,ap)"`,
 		"Global=true",
+		"Label=tier=frontend",
+		"ConflictsLabel=tier=frontend",
+		"SpreadBy=zone",
+		"Group=app",
+		"RescheduleAfter=30s",
+		"Batch=true",
+		"BatchRetries=3",
+		"Schedule=*/5 * * * *",
+		"Schedule=@daily",
+		"Tolerations=dedicated gpu",
+		"PreferredConflicts=bar*",
+		"StartAfter=db.service",
+		"Replaces=foo-v1.service",
+		"MachineFacts=arch=amd64",
+		"MachineFacts=systemd_version>=215",
 	}
 	for i, req := range tests {
 		contents := fmt.Sprintf("[X-Fleet]\n%s", req)
@@ -551,6 +816,17 @@ func TestBadValidateRequirements(t *testing.T) {
 		"MachineId=true",
 		"X-MachineMetadata=none",
 		"X-ConditionMetadata=foo=foo",
+		"MachineMetadata=memory>=lots",
+		`MachineMetadata="region in ()"`,
+		"MachineFacts=kernel_major>=new",
+		"RescheduleAfter=-5s",
+		"RescheduleAfter=soon",
+		"BatchRetries=-1",
+		"BatchRetries=many",
+		"Batch=true\nGlobal=true",
+		"Schedule=every day",
+		"Schedule=@daily\nGlobal=true",
+		"Instances=3",
 	}
 	for i, req := range tests {
 		contents := fmt.Sprintf("[X-Fleet]\n%s", req)
@@ -560,3 +836,99 @@ func TestBadValidateRequirements(t *testing.T) {
 		}
 	}
 }
+
+func TestJobHealthCheck(t *testing.T) {
+	defaults := func(typ HealthCheckType, target string) *HealthCheck {
+		return &HealthCheck{
+			Type:     typ,
+			Target:   target,
+			Interval: DefaultHealthCheckInterval,
+			Timeout:  DefaultHealthCheckTimeout,
+			Failures: DefaultHealthCheckFailures,
+			Restarts: DefaultHealthCheckRestarts,
+		}
+	}
+
+	testCases := []struct {
+		unit string
+		hc   *HealthCheck
+		err  bool
+	}{
+		{`[X-Fleet]`, nil, false},
+		{`[X-Fleet]
+HealthCheck=tcp://127.0.0.1:8080`, defaults(HealthCheckTCP, "127.0.0.1:8080"), false},
+		{`[X-Fleet]
+HealthCheck=http://127.0.0.1:8080/healthz`, defaults(HealthCheckHTTP, "http://127.0.0.1:8080/healthz"), false},
+		{`[X-Fleet]
+HealthCheck=exec:/usr/bin/check-app --quick`, defaults(HealthCheckExec, "/usr/bin/check-app --quick"), false},
+		{`[X-Fleet]
+HealthCheck=tcp://127.0.0.1:8080
+HealthCheckInterval=30s
+HealthCheckTimeout=2s
+HealthCheckFailures=5
+HealthCheckRestarts=0`, &HealthCheck{Type: HealthCheckTCP, Target: "127.0.0.1:8080", Interval: 30 * time.Second, Timeout: 2 * time.Second, Failures: 5, Restarts: 0}, false},
+		{`[X-Fleet]
+HealthCheck=udp://127.0.0.1:53`, nil, true},
+		{`[X-Fleet]
+HealthCheck=exec:`, nil, true},
+		{`[X-Fleet]
+HealthCheck=tcp://127.0.0.1:8080
+HealthCheckInterval=0`, nil, true},
+		{`[X-Fleet]
+HealthCheck=tcp://127.0.0.1:8080
+HealthCheckFailures=0`, nil, true},
+	}
+	for i, tt := range testCases {
+		j := NewJob("echo.service", *newUnit(t, tt.unit))
+		hc, err := j.HealthCheck()
+		if (err != nil) != tt.err {
+			t.Errorf("case %d: unexpected error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.hc, hc) {
+			t.Errorf("case %d: HealthCheck returned %#v, want %#v", i, hc, tt.hc)
+		}
+		if err := j.ValidateRequirements(); (err != nil) != tt.err {
+			t.Errorf("case %d: unexpected validation error %v", i, err)
+		}
+	}
+}
+
+func TestJobResources(t *testing.T) {
+	testCases := []struct {
+		unit string
+		res  resource.ResourceTuple
+		err  bool
+	}{
+		{`[X-Fleet]`, resource.ResourceTuple{}, false},
+		{`[X-Fleet]
+Cores=0.5`, resource.ResourceTuple{Cores: 50}, false},
+		{`[X-Fleet]
+Cores=2
+Memory=512`, resource.ResourceTuple{Cores: 200, Memory: 512}, false},
+		{`[X-Fleet]
+Memory=512M`, resource.ResourceTuple{Memory: 512}, false},
+		{`[X-Fleet]
+Memory=2G`, resource.ResourceTuple{Memory: 2048}, false},
+		{`[X-Fleet]
+Cores=0`, resource.ResourceTuple{}, true},
+		{`[X-Fleet]
+Cores=lots`, resource.ResourceTuple{}, true},
+		{`[X-Fleet]
+Memory=2T`, resource.ResourceTuple{}, true},
+	}
+	for i, tt := range testCases {
+		j := NewJob("echo.service", *newUnit(t, tt.unit))
+		res, err := j.Resources()
+		if (err != nil) != tt.err {
+			t.Errorf("case %d: unexpected error %v", i, err)
+			continue
+		}
+		if !tt.err && res != tt.res {
+			t.Errorf("case %d: Resources returned %#v, want %#v", i, res, tt.res)
+		}
+		if err := j.ValidateRequirements(); (err != nil) != tt.err {
+			t.Errorf("case %d: unexpected validation error %v", i, err)
+		}
+	}
+}
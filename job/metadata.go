@@ -0,0 +1,185 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Operators that may be used in MachineMetadata expressions
+const (
+	MetadataOpEqual          = "="
+	MetadataOpNotEqual       = "!="
+	MetadataOpGreater        = ">"
+	MetadataOpGreaterOrEqual = ">="
+	MetadataOpLess           = "<"
+	MetadataOpLessOrEqual    = "<="
+	MetadataOpIn             = "in"
+	MetadataOpNotIn          = "notin"
+)
+
+var (
+	// comparison operators, longest first so that e.g. ">=" is not
+	// mistaken for ">"
+	metadataComparisonOps = []string{
+		MetadataOpNotEqual,
+		MetadataOpGreaterOrEqual,
+		MetadataOpLessOrEqual,
+		MetadataOpGreater,
+		MetadataOpLess,
+		MetadataOpEqual,
+	}
+
+	metadataSetExpr = regexp.MustCompile(`^([^\s=!<>]+)\s+(in|notin)\s*\((.*)\)$`)
+)
+
+// MetadataExpression is a condition on the value of a single key of a
+// Machine's metadata, e.g. "region!=eu", "memory>=8192" or
+// "region in (us-east,us-west)".
+type MetadataExpression struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+// ParseMetadataExpression parses a single MachineMetadata value.
+func ParseMetadataExpression(s string) (*MetadataExpression, error) {
+	s = strings.TrimSpace(s)
+
+	if m := metadataSetExpr.FindStringSubmatch(s); m != nil {
+		var values []string
+		for _, v := range strings.Split(m[3], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("metadata expression %q has no values", s)
+		}
+		return &MetadataExpression{Key: m[1], Operator: m[2], Values: values}, nil
+	}
+
+	idx := strings.IndexAny(s, "!<>=")
+	if idx < 1 {
+		return nil, fmt.Errorf("metadata expression %q has no key or operator", s)
+	}
+
+	var op string
+	for _, o := range metadataComparisonOps {
+		if strings.HasPrefix(s[idx:], o) {
+			op = o
+			break
+		}
+	}
+	if op == "" {
+		return nil, fmt.Errorf("metadata expression %q has invalid operator", s)
+	}
+
+	key, value := s[:idx], s[idx+len(op):]
+	if value == "" {
+		return nil, fmt.Errorf("metadata expression %q has no value", s)
+	}
+
+	if isNumericOp(op) {
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return nil, fmt.Errorf("metadata expression %q requires a numeric value", s)
+		}
+	}
+
+	return &MetadataExpression{Key: key, Operator: op, Values: []string{value}}, nil
+}
+
+// isMetadataExpression determines whether a MachineMetadata value uses
+// the expression syntax, rather than a plain `key=value` pair
+func isMetadataExpression(s string) bool {
+	s = strings.TrimSpace(s)
+	if metadataSetExpr.MatchString(s) {
+		return true
+	}
+	idx := strings.IndexAny(s, "!<>=")
+	return idx >= 0 && s[idx] != '='
+}
+
+func isNumericOp(op string) bool {
+	switch op {
+	case MetadataOpGreater, MetadataOpGreaterOrEqual, MetadataOpLess, MetadataOpLessOrEqual:
+		return true
+	}
+	return false
+}
+
+// Matches determines whether the given Machine metadata satisfies the
+// expression. Negative expressions (!= and notin) are satisfied by
+// metadata lacking the key entirely, while all others require it.
+// Numeric comparisons are not satisfied by non-numeric values.
+func (me *MetadataExpression) Matches(metadata map[string]string) bool {
+	local, ok := metadata[me.Key]
+
+	switch me.Operator {
+	case MetadataOpEqual:
+		return ok && local == me.Values[0]
+	case MetadataOpNotEqual:
+		return !ok || local != me.Values[0]
+	case MetadataOpIn:
+		return ok && me.contains(local)
+	case MetadataOpNotIn:
+		return !ok || !me.contains(local)
+	}
+
+	if !ok {
+		return false
+	}
+	lv, err := strconv.ParseFloat(local, 64)
+	if err != nil {
+		return false
+	}
+	rv, err := strconv.ParseFloat(me.Values[0], 64)
+	if err != nil {
+		return false
+	}
+
+	switch me.Operator {
+	case MetadataOpGreater:
+		return lv > rv
+	case MetadataOpGreaterOrEqual:
+		return lv >= rv
+	case MetadataOpLess:
+		return lv < rv
+	case MetadataOpLessOrEqual:
+		return lv <= rv
+	}
+
+	return false
+}
+
+func (me *MetadataExpression) contains(v string) bool {
+	for _, val := range me.Values {
+		if val == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (me *MetadataExpression) String() string {
+	switch me.Operator {
+	case MetadataOpIn, MetadataOpNotIn:
+		return fmt.Sprintf("%s %s (%s)", me.Key, me.Operator, strings.Join(me.Values, ","))
+	}
+	return me.Key + me.Operator + me.Values[0]
+}
@@ -0,0 +1,133 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/fleet/machine"
+)
+
+func TestParseMetadataExpression(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *MetadataExpression
+	}{
+		{"region=us", &MetadataExpression{"region", "=", []string{"us"}}},
+		{"region!=eu", &MetadataExpression{"region", "!=", []string{"eu"}}},
+		{"memory>=8192", &MetadataExpression{"memory", ">=", []string{"8192"}}},
+		{"memory<=8192", &MetadataExpression{"memory", "<=", []string{"8192"}}},
+		{"cpus>2", &MetadataExpression{"cpus", ">", []string{"2"}}},
+		{"load<0.5", &MetadataExpression{"load", "<", []string{"0.5"}}},
+		{"region in (us-east, us-west)", &MetadataExpression{"region", "in", []string{"us-east", "us-west"}}},
+		{" region notin (eu) ", &MetadataExpression{"region", "notin", []string{"eu"}}},
+
+		// invalid expressions
+		{"", nil},
+		{"region", nil},
+		{"=us", nil},
+		{"region!=", nil},
+		{"region!us", nil},
+		{"memory>=lots", nil},
+		{"region in ()", nil},
+	}
+
+	for i, tt := range tests {
+		got, err := ParseMetadataExpression(tt.in)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("case %d: expected error parsing %q, got %#v", i, tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error parsing %q: %v", i, tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("case %d: expected %#v, got %#v", i, tt.want, got)
+		}
+	}
+}
+
+func TestMetadataExpressionMatches(t *testing.T) {
+	metadata := map[string]string{
+		"region": "us-east",
+		"memory": "16384",
+		"disk":   "ssd",
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"region=us-east", true},
+		{"region=eu", false},
+		{"region!=eu", true},
+		{"region!=us-east", false},
+		{"zone!=a", true},
+		{"memory>=8192", true},
+		{"memory>=16384", true},
+		{"memory>16384", false},
+		{"memory<32768", true},
+		{"memory<=8192", false},
+		{"disk>1", false},
+		{"cpus>1", false},
+		{"region in (us-east,us-west)", true},
+		{"region in (eu)", false},
+		{"zone in (a)", false},
+		{"region notin (eu,ap)", true},
+		{"region notin (us-east)", false},
+		{"zone notin (a)", true},
+	}
+
+	for i, tt := range tests {
+		me, err := ParseMetadataExpression(tt.expr)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error parsing %q: %v", i, tt.expr, err)
+		}
+		if got := me.Matches(metadata); got != tt.want {
+			t.Errorf("case %d: %q matched %t, expected %t", i, tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestJobMetadataSatisfiedBy(t *testing.T) {
+	ms := &machine.MachineState{
+		Metadata: map[string]string{"region": "us-east", "memory": "4096"},
+	}
+
+	tests := []struct {
+		unit string
+		want bool
+	}{
+		{"[X-Fleet]", true},
+		{"[X-Fleet]\nMachineMetadata=region=us-east\nMachineMetadata=region=us-west", true},
+		{"[X-Fleet]\nMachineMetadata=region!=eu", true},
+		{"[X-Fleet]\nMachineMetadata=region=us-east\nMachineMetadata=memory>=8192", false},
+		{"[X-Fleet]\nMachineMetadata=\"region in (us-east,us-west)\" \"memory<8192\"", true},
+		{"[X-Fleet]\nMachineMetadata=\"region notin (us-east)\"", false},
+		// malformed expressions cannot be satisfied
+		{"[X-Fleet]\nMachineMetadata=memory>=lots", false},
+	}
+
+	for i, tt := range tests {
+		j := NewJob("echo.service", *newUnit(t, tt.unit))
+		if got := j.MetadataSatisfiedBy(ms); got != tt.want {
+			t.Errorf("case %d: expected %t, got %t", i, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/fleet/resource"
+)
+
+// Resources returns the CPU and memory the Job reserves through its Cores
+// and Memory options, in the units of a resource.ResourceTuple. A Job
+// without either option reserves nothing. An error is returned if either
+// option is invalid.
+func (j *Job) Resources() (res resource.ResourceTuple, err error) {
+	reqs := j.requirements()
+
+	if values := reqs[fleetCores]; len(values) > 0 {
+		v := strings.TrimSpace(values[len(values)-1])
+		cores, err := strconv.ParseFloat(v, 64)
+		if err != nil || cores <= 0 {
+			return res, fmt.Errorf("invalid value %q for %s: must be a positive number of cores", v, fleetCores)
+		}
+		res.Cores = int(cores*100 + 0.5)
+	}
+
+	if values := reqs[fleetMemory]; len(values) > 0 {
+		v := strings.TrimSpace(values[len(values)-1])
+		mb, err := parseMemory(v)
+		if err != nil || mb <= 0 {
+			return res, fmt.Errorf("invalid value %q for %s: must be a positive amount of memory, such as 512M or 2G", v, fleetMemory)
+		}
+		res.Memory = mb
+	}
+
+	return res, nil
+}
+
+// parseMemory parses an amount of memory in MB, optionally suffixed with M,
+// or in GB when suffixed with G
+func parseMemory(v string) (int, error) {
+	mult := 1
+	switch {
+	case strings.HasSuffix(v, "G"):
+		mult = 1024
+		v = strings.TrimSuffix(v, "G")
+	case strings.HasSuffix(v, "M"):
+		v = strings.TrimSuffix(v, "M")
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, err
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"time"
+
+	"github.com/coreos/fleet/unit"
+)
+
+type RolloutState string
+
+const (
+	RolloutStateRunning  = RolloutState("running")
+	RolloutStateComplete = RolloutState("complete")
+	RolloutStateFailed   = RolloutState("failed")
+)
+
+// RolloutControl is an instruction given to a running Rollout by an
+// operator
+type RolloutControl string
+
+const (
+	// RolloutControlPromote ends the canary phase of a Rollout without
+	// waiting for the soak period to elapse
+	RolloutControlPromote = RolloutControl("promote")
+	// RolloutControlAbort fails the Rollout, leaving the instances that
+	// were already replaced as they are
+	RolloutControlAbort = RolloutControl("abort")
+)
+
+// Rollout describes the replacement of every instance of a template Unit
+// with a new version of that template, a batch of instances at a time.
+// The engine moves on to the next batch only once every instance in the
+// current batch reports itself active. If Canaries is set, the engine
+// first replaces only that many instances, and holds the remainder of the
+// Rollout until they have all been active for the Soak period.
+type Rollout struct {
+	// Template is the name of the template Unit, e.g. foo@.service
+	Template string
+
+	// Unit is the new version of the template
+	Unit unit.UnitFile
+
+	// BatchSize is the maximum number of instances replaced at once
+	BatchSize int
+
+	// Timeout is how long a replaced instance may take to report itself
+	// active before the Rollout fails. A value of zero means no limit.
+	Timeout time.Duration
+
+	// Canaries is the number of instances replaced before the rest of
+	// the Rollout is held for the Soak period. A value of zero disables
+	// the canary phase.
+	Canaries int
+
+	// Soak is how long every canary must have been active before the
+	// remaining instances are replaced
+	Soak time.Duration
+
+	// SoakingSince records when every canary was first seen active. It
+	// is zero while any canary is still in progress.
+	SoakingSince time.Time
+
+	// Promoted is set once the canary phase is over
+	Promoted bool
+
+	// Control is the latest instruction given to the Rollout by an
+	// operator, if any
+	Control RolloutControl
+
+	State RolloutState
+
+	// Reason explains why a Rollout failed
+	Reason string
+
+	// Total is the number of instances of the template in the cluster
+	Total int
+
+	// Updated lists the instances that are running the new version
+	Updated []string
+
+	// InProgress lists the instances being replaced, which have not yet
+	// reported themselves active
+	InProgress []string
+
+	// Replaced records when each instance in progress was replaced
+	Replaced map[string]time.Time
+}
+
+// Done determines whether the Rollout has stopped, either by completing
+// or by failing
+func (ro *Rollout) Done() bool {
+	return ro.State == RolloutStateComplete || ro.State == RolloutStateFailed
+}
+
+// Canarying determines whether the Rollout is in its canary phase
+func (ro *Rollout) Canarying() bool {
+	return !ro.Done() && ro.Canaries > 0 && !ro.Promoted
+}
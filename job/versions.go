@@ -0,0 +1,103 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-semver/semver"
+
+	"github.com/coreos/fleet/machine"
+)
+
+// agentOptionVersions maps the X-Fleet options acted upon by the agent
+// running a Unit, rather than by the engine, to the version of fleetd
+// introducing them. Older agents run Units using these options, but
+// silently ignore the options themselves.
+var agentOptionVersions = map[string]string{
+	fleetBatch:               "0.10.0",
+	fleetBatchRetries:        "0.10.0",
+	fleetSchedule:            "0.10.0",
+	fleetHealthCheck:         "0.10.0",
+	fleetHealthCheckInterval: "0.10.0",
+	fleetHealthCheckTimeout:  "0.10.0",
+	fleetHealthCheckFailures: "0.10.0",
+	fleetHealthCheckRestarts: "0.10.0",
+	fleetCores:               "0.10.0",
+	fleetMemory:              "0.10.0",
+	fleetInstances:           "0.10.0",
+}
+
+// agentSectionVersions maps the unit file sections acted upon by the agent
+// to the version of fleetd introducing them
+var agentSectionVersions = map[string]string{
+	"X-Docker": "0.10.0",
+	"X-Rkt":    "0.10.0",
+}
+
+// RequiredVersion returns the oldest version of fleetd whose agent acts
+// upon every option and section of the Job, along with the option or
+// section requiring that version. A nil version is returned if any version
+// of fleetd is able to run the Job.
+func (j *Job) RequiredVersion() (*semver.Version, string) {
+	required := make(map[string]string)
+	for name := range j.Unit.Contents["X-Fleet"] {
+		if v, ok := agentOptionVersions[name]; ok {
+			required[name] = v
+		}
+	}
+	for section := range j.Unit.Contents {
+		if v, ok := agentSectionVersions[section]; ok {
+			required["["+section+"]"] = v
+		}
+	}
+
+	// the features are visited in order, so the same one is named among
+	// those requiring the same version
+	features := make([]string, 0, len(required))
+	for feature := range required {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+
+	var rv *semver.Version
+	var rFeature string
+	for _, feature := range features {
+		v, err := semver.NewVersion(required[feature])
+		if err != nil {
+			continue
+		}
+		if rv == nil || rv.LessThan(*v) {
+			rv, rFeature = v, feature
+		}
+	}
+	return rv, rFeature
+}
+
+// UnsupportedFeature returns the first option or section of the Job that
+// the fleetd of the given Machine does not act upon, if any. Machines not
+// publishing a valid version are assumed to support every feature.
+func (j *Job) UnsupportedFeature(ms *machine.MachineState) (string, bool) {
+	rv, feature := j.RequiredVersion()
+	if rv == nil {
+		return "", false
+	}
+	v, err := semver.NewVersion(ms.Version)
+	if err != nil || !v.LessThan(*rv) {
+		return "", false
+	}
+	return fmt.Sprintf("%s, introduced in fleet %s", feature, rv), true
+}
@@ -0,0 +1,70 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"testing"
+
+	"github.com/coreos/fleet/machine"
+)
+
+func TestJobRequiredVersion(t *testing.T) {
+	tests := []struct {
+		contents string
+		version  string
+		feature  string
+	}{
+		{"[Service]\nExecStart=/bin/true", "", ""},
+		{"[X-Fleet]\nMachineMetadata=region=us-east", "", ""},
+		{"[X-Fleet]\nMemory=512\nBatch=true", "0.10.0", "Batch"},
+		{"[X-Docker]\nImage=busybox", "0.10.0", "[X-Docker]"},
+	}
+	for i, tt := range tests {
+		j := NewJob("foo.service", *newUnit(t, tt.contents))
+		v, feature := j.RequiredVersion()
+		var version string
+		if v != nil {
+			version = v.String()
+		}
+		if version != tt.version || feature != tt.feature {
+			t.Errorf("case %d: expected %q (%s), got %q (%s)", i, tt.version, tt.feature, version, feature)
+		}
+	}
+}
+
+func TestJobUnsupportedFeature(t *testing.T) {
+	j := NewJob("foo.service", *newUnit(t, "[X-Fleet]\nHealthCheck=/bin/true"))
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{"0.9.2", true},
+		{"0.10.0", false},
+		{"0.10.0+git", false},
+		{"0.11.1", false},
+
+		// Machines of unknown version are given the benefit of the doubt
+		{"", false},
+	}
+	for i, tt := range tests {
+		f, got := j.UnsupportedFeature(&machine.MachineState{Version: tt.version})
+		if got != tt.want {
+			t.Errorf("case %d: expected %t, got %t (%s)", i, tt.want, got, f)
+		}
+		if want := "HealthCheck, introduced in fleet 0.10.0"; got && f != want {
+			t.Errorf("case %d: expected %q, got %q", i, want, f)
+		}
+	}
+}
@@ -39,6 +39,10 @@ func EnableDebug() {
 	debug = true
 }
 
+func DisableDebug() {
+	debug = false
+}
+
 func Debug(v ...interface{}) {
 	if debug {
 		logger.Output(calldepth, header("DEBUG", fmt.Sprint(v...)))
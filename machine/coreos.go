@@ -34,7 +34,7 @@ const (
 )
 
 func NewCoreOSMachine(static MachineState, um unit.UnitManager) *CoreOSMachine {
-	log.Debugf("Created CoreOSMachine with static state %s", static)
+	log.Debugf("Created CoreOSMachine with static state %+v", static)
 	m := &CoreOSMachine{
 		staticState: static,
 		um:          um,
@@ -48,6 +48,7 @@ type CoreOSMachine struct {
 	um           unit.UnitManager
 	staticState  MachineState
 	dynamicState *MachineState
+	resources    *Resources
 }
 
 func (m *CoreOSMachine) String() string {
@@ -65,10 +66,33 @@ func (m *CoreOSMachine) State() (state MachineState) {
 	} else {
 		state = stackState(m.staticState, *m.dynamicState)
 	}
+	if m.resources != nil {
+		state.Resources = m.resources
+	}
 
 	return
 }
 
+// RefreshResources measures the resources of the CoreOSMachine, which
+// change far more often than the rest of its state
+func (m *CoreOSMachine) RefreshResources() {
+	res := measureResources()
+
+	m.Lock()
+	defer m.Unlock()
+	m.resources = res
+}
+
+// SetMetadata replaces the metadata and taints of the CoreOSMachine, such
+// as when fleetd reloads its configuration. The new values are published
+// with the next heartbeat of the machine.
+func (m *CoreOSMachine) SetMetadata(metadata map[string]string, taints []string) {
+	m.Lock()
+	defer m.Unlock()
+	m.staticState.Metadata = metadata
+	m.staticState.Taints = taints
+}
+
 // Refresh updates the current state of the CoreOSMachine.
 func (m *CoreOSMachine) Refresh() {
 	m.RLock()
@@ -111,6 +135,7 @@ func (m *CoreOSMachine) currentState() *MachineState {
 		ID:       id,
 		PublicIP: publicIP,
 		Metadata: make(map[string]string, 0),
+		Facts:    detectFacts(),
 	}
 }
 
@@ -19,9 +19,28 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
+func TestSetMetadata(t *testing.T) {
+	m := NewCoreOSMachine(MachineState{ID: "XXX", Metadata: map[string]string{"region": "us-east"}}, nil)
+	m.dynamicState = &MachineState{ID: "XXX", PublicIP: "192.0.2.3", Metadata: map[string]string{}}
+
+	m.SetMetadata(map[string]string{"region": "us-west", "disk": "ssd"}, []string{"dedicated"})
+
+	state := m.State()
+	if want := map[string]string{"region": "us-west", "disk": "ssd"}; !reflect.DeepEqual(want, state.Metadata) {
+		t.Errorf("expected metadata %v, got %v", want, state.Metadata)
+	}
+	if want := []string{"dedicated"}; !reflect.DeepEqual(want, state.Taints) {
+		t.Errorf("expected taints %v, got %v", want, state.Taints)
+	}
+	if state.PublicIP != "192.0.2.3" {
+		t.Errorf("expected dynamic state to be retained, got public IP %q", state.PublicIP)
+	}
+}
+
 func TestReadLocalMachineIDMissing(t *testing.T) {
 	dir, err := ioutil.TempDir(os.TempDir(), "fleet-")
 	if err != nil {
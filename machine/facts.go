@@ -0,0 +1,138 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package machine
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/fleet/log"
+)
+
+// Keys of the facts detected about the local Machine
+const (
+	FactArch           = "arch"
+	FactKernel         = "kernel"
+	FactKernelMajor    = "kernel_major"
+	FactKernelMinor    = "kernel_minor"
+	FactSystemdVersion = "systemd_version"
+	FactOS             = "os"
+	FactOSVersion      = "os_version"
+	FactDocker         = "docker"
+	FactRkt            = "rkt"
+)
+
+const (
+	kernelReleasePath = "/proc/sys/kernel/osrelease"
+	osReleasePath     = "/etc/os-release"
+)
+
+var (
+	kernelReleaseExpr  = regexp.MustCompile(`^(\d+)\.(\d+)`)
+	systemdVersionExpr = regexp.MustCompile(`^systemd (\d+)`)
+)
+
+// detectFacts returns the facts that can be determined about the local
+// Machine. Facts that cannot be determined are omitted.
+func detectFacts() map[string]string {
+	facts := map[string]string{
+		FactArch:   runtime.GOARCH,
+		FactDocker: strconv.FormatBool(hasCommand("docker")),
+		FactRkt:    strconv.FormatBool(hasCommand("rkt")),
+	}
+
+	if release, err := ioutil.ReadFile(kernelReleasePath); err == nil {
+		for k, v := range kernelFacts(strings.TrimSpace(string(release))) {
+			facts[k] = v
+		}
+	} else {
+		log.Debugf("Unable to read kernel release: %v", err)
+	}
+
+	if release, err := ioutil.ReadFile(osReleasePath); err == nil {
+		for k, v := range osReleaseFacts(string(release)) {
+			facts[k] = v
+		}
+	} else {
+		log.Debugf("Unable to read OS release: %v", err)
+	}
+
+	if out, err := exec.Command("systemctl", "--version").Output(); err == nil {
+		if v, ok := parseSystemdVersion(string(out)); ok {
+			facts[FactSystemdVersion] = v
+		}
+	} else {
+		log.Debugf("Unable to determine systemd version: %v", err)
+	}
+
+	return facts
+}
+
+// kernelFacts returns the facts describing the given kernel release, e.g.
+// "3.17.2+": the release itself, and its major and minor version numbers
+// if it starts with them.
+func kernelFacts(release string) map[string]string {
+	if release == "" {
+		return nil
+	}
+	facts := map[string]string{FactKernel: release}
+	if m := kernelReleaseExpr.FindStringSubmatch(release); m != nil {
+		facts[FactKernelMajor] = m[1]
+		facts[FactKernelMinor] = m[2]
+	}
+	return facts
+}
+
+// osReleaseFacts returns the facts describing the OS from the contents of
+// an os-release file: the ID of the OS, e.g. "coreos", and its version
+func osReleaseFacts(release string) map[string]string {
+	facts := make(map[string]string)
+	for _, line := range strings.Split(release, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		v := strings.Trim(parts[1], `"'`)
+		if v == "" {
+			continue
+		}
+		switch parts[0] {
+		case "ID":
+			facts[FactOS] = v
+		case "VERSION_ID":
+			facts[FactOSVersion] = v
+		}
+	}
+	return facts
+}
+
+// parseSystemdVersion extracts the version number from the output of
+// `systemctl --version`, whose first line reads e.g. "systemd 215"
+func parseSystemdVersion(out string) (string, bool) {
+	m := systemdVersionExpr.FindStringSubmatch(strings.TrimSpace(out))
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
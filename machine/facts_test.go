@@ -0,0 +1,63 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package machine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKernelFacts(t *testing.T) {
+	tests := []struct {
+		release string
+		want    map[string]string
+	}{
+		{"", nil},
+		{"3.17.2+", map[string]string{FactKernel: "3.17.2+", FactKernelMajor: "3", FactKernelMinor: "17"}},
+		{"custom", map[string]string{FactKernel: "custom"}},
+	}
+
+	for i, tt := range tests {
+		if got := kernelFacts(tt.release); !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("case %d: expected %v, got %v", i, tt.want, got)
+		}
+	}
+}
+
+func TestOSReleaseFacts(t *testing.T) {
+	release := `NAME=CoreOS
+ID=coreos
+VERSION="607.0.0"
+VERSION_ID='607.0.0'
+PRETTY_NAME="CoreOS 607.0.0"
+`
+	want := map[string]string{FactOS: "coreos", FactOSVersion: "607.0.0"}
+	if got := osReleaseFacts(release); !reflect.DeepEqual(want, got) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if got := osReleaseFacts("ID=\n# comment\n"); len(got) != 0 {
+		t.Errorf("expected no facts, got %v", got)
+	}
+}
+
+func TestParseSystemdVersion(t *testing.T) {
+	out := "systemd 215\n+PAM +AUDIT +SELINUX +IMA +SYSVINIT +LIBCRYPTSETUP\n"
+	if v, ok := parseSystemdVersion(out); !ok || v != "215" {
+		t.Errorf("expected version 215, got %q (%t)", v, ok)
+	}
+	if v, ok := parseSystemdVersion("no systemd here"); ok {
+		t.Errorf("expected no version, got %q", v)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package machine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow is a recurring period of time during which a Machine
+// is drained, such as to be patched and rebooted
+type MaintenanceWindow struct {
+	// Daily windows recur every day, others only on their Weekday
+	Daily   bool
+	Weekday time.Weekday
+
+	// Start is the time of day the window opens at, and Duration how long
+	// it stays open for, which may run into the following day
+	Start    time.Duration
+	Duration time.Duration
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseMaintenanceWindows parses a comma-separated list of windows of the
+// form "DAY HH:MM-HH:MM", where DAY is the abbreviated name of a weekday,
+// such as "Sun", or "*" for every day. A window ending at or before the
+// time it starts ends on the following day.
+func ParseMaintenanceWindows(s string) ([]MaintenanceWindow, error) {
+	var windows []MaintenanceWindow
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		w, err := parseMaintenanceWindow(field)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, *w)
+	}
+	return windows, nil
+}
+
+func parseMaintenanceWindow(s string) (*MaintenanceWindow, error) {
+	parts := strings.Fields(s)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("maintenance window %q is not of the form \"DAY HH:MM-HH:MM\"", s)
+	}
+
+	var w MaintenanceWindow
+	if parts[0] == "*" {
+		w.Daily = true
+	} else if wd, ok := weekdays[strings.ToLower(parts[0])]; ok {
+		w.Weekday = wd
+	} else {
+		return nil, fmt.Errorf("maintenance window %q has unknown day %q", s, parts[0])
+	}
+
+	times := strings.Split(parts[1], "-")
+	if len(times) != 2 {
+		return nil, fmt.Errorf("maintenance window %q is not of the form \"DAY HH:MM-HH:MM\"", s)
+	}
+	start, err := parseTimeOfDay(times[0])
+	if err != nil {
+		return nil, fmt.Errorf("maintenance window %q: %v", s, err)
+	}
+	end, err := parseTimeOfDay(times[1])
+	if err != nil {
+		return nil, fmt.Errorf("maintenance window %q: %v", s, err)
+	}
+	if end <= start {
+		end += 24 * time.Hour
+	}
+	w.Start = start
+	w.Duration = end - start
+	return &w, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether the window is open at the given time, taken in
+// its own location
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	// a window open at t opened on the same day or, running past
+	// midnight, on the day before
+	for _, days := range []int{0, -1} {
+		day := time.Date(t.Year(), t.Month(), t.Day()+days, 0, 0, 0, 0, t.Location())
+		if !w.Daily && day.Weekday() != w.Weekday {
+			continue
+		}
+		start := day.Add(w.Start)
+		if !t.Before(start) && t.Before(start.Add(w.Duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+// InMaintenance reports whether any of the given windows is open at the
+// given time
+func InMaintenance(windows []MaintenanceWindow, t time.Time) bool {
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
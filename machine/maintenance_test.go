@@ -0,0 +1,86 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package machine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseMaintenanceWindows(t *testing.T) {
+	got, err := ParseMaintenanceWindows("Sun 02:00-04:00, * 23:30-00:15")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []MaintenanceWindow{
+		{Weekday: time.Sunday, Start: 2 * time.Hour, Duration: 2 * time.Hour},
+		{Daily: true, Start: 23*time.Hour + 30*time.Minute, Duration: 45 * time.Minute},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("expected %#v, got %#v", want, got)
+	}
+
+	if got, err := ParseMaintenanceWindows(""); err != nil || len(got) != 0 {
+		t.Errorf("expected no windows, got %v, %v", got, err)
+	}
+
+	for _, s := range []string{
+		"Sun",
+		"Sun 02:00",
+		"Funday 02:00-04:00",
+		"Sun 2am-4am",
+		"Sun 02:00-25:00",
+		"Sun 02:00-04:00 UTC",
+	} {
+		if _, err := ParseMaintenanceWindows(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestInMaintenance(t *testing.T) {
+	windows, err := ParseMaintenanceWindows("Sun 02:00-04:00, Sat 23:00-01:00")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 2015-03-01 is a Sunday
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2015, time.March, day, hour, min, 0, 0, time.UTC)
+	}
+	for i, tt := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{at(1, 1, 59), false},
+		{at(1, 2, 0), true},
+		{at(1, 3, 59), true},
+		{at(1, 4, 0), false},
+		// the following Sunday
+		{at(8, 3, 0), true},
+		// a Monday
+		{at(2, 3, 0), false},
+		// the Saturday window runs into Sunday
+		{at(7, 23, 30), true},
+		{at(8, 0, 30), true},
+		{at(8, 1, 0), false},
+		{at(6, 23, 30), false},
+	} {
+		if got := InMaintenance(windows, tt.t); got != tt.want {
+			t.Errorf("case %d: %v: expected %t, got %t", i, tt.t, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package machine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/resource"
+)
+
+const (
+	meminfoPath = "/proc/meminfo"
+	loadavgPath = "/proc/loadavg"
+
+	// diskPath is the filesystem whose space is measured
+	diskPath = "/"
+)
+
+// Resources describes the capacity of a Machine and how much of it is
+// unused, in the units of a resource.ResourceTuple
+type Resources struct {
+	Total resource.ResourceTuple
+	Free  resource.ResourceTuple
+}
+
+// measureResources returns the Resources of the local Machine. Resources
+// that cannot be measured are left zero.
+func measureResources() *Resources {
+	res := &Resources{}
+
+	res.Total.Cores = runtime.NumCPU() * 100
+	res.Free.Cores = res.Total.Cores
+	if b, err := ioutil.ReadFile(loadavgPath); err == nil {
+		if load, err := parseLoadavg(string(b)); err == nil {
+			res.Free.Cores = freeCores(res.Total.Cores, load)
+		} else {
+			log.Debugf("Unable to parse %s: %v", loadavgPath, err)
+		}
+	} else {
+		log.Debugf("Unable to read load average: %v", err)
+	}
+
+	if f, err := os.Open(meminfoPath); err == nil {
+		res.Total.Memory, res.Free.Memory, err = parseMeminfo(f)
+		f.Close()
+		if err != nil {
+			log.Debugf("Unable to parse %s: %v", meminfoPath, err)
+		}
+	} else {
+		log.Debugf("Unable to read memory usage: %v", err)
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(diskPath, &st); err == nil {
+		res.Total.Disk = int(st.Blocks * uint64(st.Bsize) >> 20)
+		res.Free.Disk = int(st.Bavail * uint64(st.Bsize) >> 20)
+	} else {
+		log.Debugf("Unable to measure disk space of %s: %v", diskPath, err)
+	}
+
+	return res
+}
+
+// freeCores estimates the unused CPU capacity, in hundredths of a core,
+// from the one-minute load average
+func freeCores(total int, load float64) int {
+	free := total - int(load*100)
+	if free < 0 {
+		free = 0
+	}
+	return free
+}
+
+// parseLoadavg returns the one-minute load average from the contents of
+// /proc/loadavg, e.g. "0.20 0.18 0.12 1/80 11206"
+func parseLoadavg(s string) (float64, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty load average")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// parseMeminfo returns the total and available memory in MB from the
+// contents of /proc/meminfo. Kernels predating MemAvailable have the
+// available memory estimated from the free and cache memory.
+func parseMeminfo(r io.Reader) (total, free int, err error) {
+	kb := make(map[string]int)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		// e.g. "MemTotal:        2048236 kB"
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		kb[strings.TrimSuffix(fields[0], ":")] = v
+	}
+	if err = s.Err(); err != nil {
+		return
+	}
+
+	t, ok := kb["MemTotal"]
+	if !ok {
+		return 0, 0, fmt.Errorf("no MemTotal")
+	}
+	avail, ok := kb["MemAvailable"]
+	if !ok {
+		avail = kb["MemFree"] + kb["Buffers"] + kb["Cached"]
+	}
+	return t >> 10, avail >> 10, nil
+}
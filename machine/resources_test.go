@@ -0,0 +1,67 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package machine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMeminfo(t *testing.T) {
+	tests := []struct {
+		meminfo     string
+		total, free int
+		err         bool
+	}{
+		{
+			"MemTotal:        2048000 kB\nMemFree:          102400 kB\nMemAvailable:    1024000 kB\nBuffers:           10240 kB\n",
+			2000, 1000, false,
+		},
+		// older kernels lack MemAvailable
+		{
+			"MemTotal:        2048000 kB\nMemFree:          102400 kB\nBuffers:          102400 kB\nCached:           204800 kB\n",
+			2000, 400, false,
+		},
+		{"", 0, 0, true},
+	}
+
+	for i, tt := range tests {
+		total, free, err := parseMeminfo(strings.NewReader(tt.meminfo))
+		if (err != nil) != tt.err {
+			t.Errorf("case %d: unexpected error %v", i, err)
+			continue
+		}
+		if total != tt.total || free != tt.free {
+			t.Errorf("case %d: expected %d/%d MB, got %d/%d MB", i, tt.free, tt.total, free, total)
+		}
+	}
+}
+
+func TestFreeCores(t *testing.T) {
+	load, err := parseLoadavg("1.50 0.18 0.12 1/80 11206\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := freeCores(400, load); got != 250 {
+		t.Errorf("expected 250 free, got %d", got)
+	}
+	// an overloaded Machine has nothing free
+	if got := freeCores(100, load); got != 0 {
+		t.Errorf("expected 0 free, got %d", got)
+	}
+	if _, err := parseLoadavg(""); err == nil {
+		t.Errorf("expected error parsing empty load average")
+	}
+}
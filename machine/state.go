@@ -14,6 +14,12 @@
 
 package machine
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
 const (
 	shortIDLen = 8
 )
@@ -25,6 +31,82 @@ type MachineState struct {
 	PublicIP string
 	Metadata map[string]string
 	Version  string
+
+	// Taints keep Units off the Machine unless they tolerate every one
+	// of them
+	Taints []string `json:",omitempty"`
+
+	// Facts are detected by fleetd itself, such as the CPU architecture
+	// or the version of systemd, and cannot be configured
+	Facts map[string]string `json:",omitempty"`
+
+	// Resources are the CPU, memory and disk capacity of the Machine and
+	// how much of each is free, measured anew for every heartbeat
+	Resources *Resources `json:",omitempty"`
+
+	// Schedulability is set by operators rather than published by the
+	// Machine, so it is stored apart from the rest of the MachineState
+	Schedulability Schedulability `json:"-"`
+}
+
+// Schedulability tells whether the engine may place Units on a Machine
+type Schedulability string
+
+const (
+	// Schedulable Machines accept new Units
+	Schedulable Schedulability = ""
+
+	// Cordoned Machines keep the Units they run, but accept no new ones
+	Cordoned Schedulability = "cordoned"
+
+	// Draining Machines accept no new Units and have the Units they run
+	// moved elsewhere, save for those unable to run anywhere else
+	Draining Schedulability = "draining"
+
+	// Maintenance Machines are draining for the length of one of their
+	// maintenance windows. It is set and cleared by the agent of the
+	// Machine itself, so cannot be set by operators.
+	Maintenance Schedulability = "maintenance"
+)
+
+// ParseSchedulability returns the Schedulability of the given name, which
+// is empty for Machines that are schedulable
+func ParseSchedulability(name string) (Schedulability, bool) {
+	switch s := Schedulability(name); s {
+	case Schedulable, Cordoned, Draining:
+		return s, true
+	}
+	return Schedulable, false
+}
+
+// WithMetadata returns a copy of the MachineState whose metadata is overlaid
+// with the given metadata, such as that set by operators at runtime rather
+// than configured on the Machine
+func (ms MachineState) WithMetadata(md map[string]string) MachineState {
+	if len(md) == 0 {
+		return ms
+	}
+	merged := make(map[string]string, len(ms.Metadata)+len(md))
+	for k, v := range ms.Metadata {
+		merged[k] = v
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	ms.Metadata = merged
+	return ms
+}
+
+// ValidateMetadataKey returns an error if the given metadata key cannot be
+// set on a Machine at runtime
+func ValidateMetadataKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("metadata key must not be empty")
+	}
+	if strings.ContainsAny(key, "/,= ") {
+		return fmt.Errorf("metadata key %q must not contain '/', ',', '=' or spaces", key)
+	}
+	return nil
 }
 
 func (ms MachineState) ShortID() string {
@@ -62,5 +144,33 @@ func stackState(top, bottom MachineState) MachineState {
 		state.Version = top.Version
 	}
 
+	if len(top.Taints) > 0 {
+		state.Taints = top.Taints
+	}
+
+	if len(top.Facts) > 0 {
+		state.Facts = top.Facts
+	}
+
+	if top.Resources != nil {
+		state.Resources = top.Resources
+	}
+
 	return state
 }
+
+// EngineStatus describes the engine of a Machine holding the lease of
+// the schedule, or of some of its shards
+type EngineStatus struct {
+	MachineID string
+
+	// Shards lists the shards of the schedule whose lease the engine holds
+	Shards []int
+
+	// LeaseExpiry is when the leases of the engine expire unless renewed
+	LeaseExpiry time.Time
+
+	// LastReconcile is when the engine last reconciled the cluster
+	// successfully, or zero if it has not yet
+	LastReconcile time.Time
+}
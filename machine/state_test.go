@@ -14,7 +14,10 @@
 
 package machine
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestStackState(t *testing.T) {
 	top := MachineState{
@@ -22,6 +25,7 @@ func TestStackState(t *testing.T) {
 		PublicIP: "1.2.3.4",
 		Metadata: map[string]string{"ping": "pong"},
 		Version:  "1",
+		Taints:   []string{"dedicated"},
 	}
 	bottom := MachineState{
 		ID:       "595989bb-cbb7-49ce-8726-722d6e157b4e",
@@ -46,6 +50,10 @@ func TestStackState(t *testing.T) {
 	if stacked.Version != "1" {
 		t.Errorf("Unexpected Version value %s", stacked.Version)
 	}
+
+	if len(stacked.Taints) != 1 || stacked.Taints[0] != "dedicated" {
+		t.Errorf("Unexpected Taints %v", stacked.Taints)
+	}
 }
 
 func TestStackStateEmptyTop(t *testing.T) {
@@ -86,10 +94,10 @@ var shortIDTests = []struct {
 	},
 	{
 		m: MachineState{
-			"595989bb-cbb7-49ce-8726-722d6e157b4e",
-			"5.6.7.8",
-			map[string]string{"foo": "bar"},
-			"",
+			ID:       "595989bb-cbb7-49ce-8726-722d6e157b4e",
+			PublicIP: "5.6.7.8",
+			Metadata: map[string]string{"foo": "bar"},
+			Version:  "",
 		},
 		s: "595989bb",
 		l: "595989bb-cbb7-49ce-8726-722d6e157b4e",
@@ -134,3 +142,32 @@ func TestStateMatchID(t *testing.T) {
 		}
 	}
 }
+
+func TestWithMetadata(t *testing.T) {
+	ms := MachineState{ID: "XXX", Metadata: map[string]string{"region": "us-east", "disk": "hdd"}}
+
+	got := ms.WithMetadata(map[string]string{"disk": "ssd", "rack": "12"})
+	want := map[string]string{"region": "us-east", "disk": "ssd", "rack": "12"}
+	if !reflect.DeepEqual(want, got.Metadata) {
+		t.Errorf("expected metadata %v, got %v", want, got.Metadata)
+	}
+	if ms.Metadata["disk"] != "hdd" {
+		t.Errorf("expected original metadata to be left alone, got %v", ms.Metadata)
+	}
+}
+
+func TestValidateMetadataKey(t *testing.T) {
+	for key, valid := range map[string]bool{
+		"region":    true,
+		"disk-type": true,
+		"":          false,
+		"a/b":       false,
+		"a=b":       false,
+		"a,b":       false,
+		"a b":       false,
+	} {
+		if err := ValidateMetadataKey(key); valid != (err == nil) {
+			t.Errorf("key %q: expected valid=%t, got err=%v", key, valid, err)
+		}
+	}
+}
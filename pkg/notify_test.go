@@ -0,0 +1,93 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fleet-testing-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	defer os.Setenv("NOTIFY_SOCKET", os.Getenv("NOTIFY_SOCKET"))
+
+	os.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := SdNotify("READY=1"); sent || err != nil {
+		t.Errorf("expected nothing sent without a socket, got sent=%t err=%v", sent, err)
+	}
+
+	sock := path.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", sock)
+	if sent, err := SdNotify("READY=1"); !sent || err != nil {
+		t.Fatalf("expected state sent, got sent=%t err=%v", sent, err)
+	}
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if got := string(buf[:n]); got != "READY=1" {
+		t.Errorf("expected READY=1, got %q", got)
+	}
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	defer os.Setenv("WATCHDOG_USEC", os.Getenv("WATCHDOG_USEC"))
+	defer os.Setenv("WATCHDOG_PID", os.Getenv("WATCHDOG_PID"))
+
+	self := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		usec string
+		pid  string
+		want time.Duration
+		fail bool
+	}{
+		{"", "", 0, false},
+		{"30000000", "", 30 * time.Second, false},
+		{"30000000", self, 30 * time.Second, false},
+		{"30000000", "1", 0, false},
+		{"soon", "", 0, true},
+		{"0", "", 0, true},
+	}
+	for i, tt := range tests {
+		os.Setenv("WATCHDOG_USEC", tt.usec)
+		os.Setenv("WATCHDOG_PID", tt.pid)
+		got, err := SdWatchdogInterval()
+		if (err != nil) != tt.fail {
+			t.Errorf("case %d: unexpected error %v", i, err)
+		}
+		if got != tt.want {
+			t.Errorf("case %d: expected %v, got %v", i, tt.want, got)
+		}
+	}
+}
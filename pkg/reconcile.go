@@ -15,6 +15,7 @@
 package pkg
 
 import (
+	"math/rand"
 	"time"
 
 	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
@@ -38,19 +39,50 @@ type PeriodicReconciler interface {
 // NewPeriodicReconciler creates a PeriodicReconciler that will run recFunc at least every
 // ival, or in response to anything emitted from EventStream.Next()
 func NewPeriodicReconciler(interval time.Duration, recFunc func(), eStream EventStream) PeriodicReconciler {
+	return NewJitteredPeriodicReconciler(interval, 0, recFunc, eStream)
+}
+
+// NewJitteredPeriodicReconciler behaves like NewPeriodicReconciler, but
+// extends each interval by a random amount of time up to jitter. This
+// prevents many reconcilers started at the same time from running in
+// lockstep.
+func NewJitteredPeriodicReconciler(interval, jitter time.Duration, recFunc func(), eStream EventStream) PeriodicReconciler {
+	return NewDebouncedPeriodicReconciler(interval, jitter, 0, recFunc, eStream)
+}
+
+// NewDebouncedPeriodicReconciler behaves like NewJitteredPeriodicReconciler,
+// but coalesces bursts of events: rather than running recFunc once per
+// event, it waits until no event has been emitted for the debounce window.
+// Events cannot postpone reconciliation past the next periodic run.
+func NewDebouncedPeriodicReconciler(interval, jitter, debounce time.Duration, recFunc func(), eStream EventStream) PeriodicReconciler {
 	return &reconciler{
-		ival:    interval,
-		rFunc:   recFunc,
-		eStream: eStream,
-		clock:   clockwork.NewRealClock(),
+		ival:     interval,
+		jitter:   jitter,
+		debounce: debounce,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		rFunc:    recFunc,
+		eStream:  eStream,
+		clock:    clockwork.NewRealClock(),
 	}
 }
 
 type reconciler struct {
-	ival    time.Duration
-	rFunc   func()
-	eStream EventStream
-	clock   clockwork.Clock
+	ival     time.Duration
+	jitter   time.Duration
+	debounce time.Duration
+	rand     *rand.Rand
+	rFunc    func()
+	eStream  EventStream
+	clock    clockwork.Clock
+}
+
+// nextInterval returns the amount of time to wait before the next
+// periodic reconciliation
+func (r *reconciler) nextInterval() time.Duration {
+	if r.jitter <= 0 {
+		return r.ival
+	}
+	return r.ival + time.Duration(r.rand.Int63n(int64(r.jitter)))
 }
 
 func (r *reconciler) Run(stop chan bool) {
@@ -68,23 +100,36 @@ func (r *reconciler) Run(stop chan bool) {
 		}
 	}()
 
-	ticker := r.clock.After(r.ival)
+	ticker := r.clock.After(r.nextInterval())
 
 	// When starting up, reconcile once immediately
 	log.Debug("Initial reconciliation commencing")
 	r.rFunc()
 
+	// debounced fires once the debounce window has passed without events
+	var debounced <-chan time.Time
+
 	for {
 		select {
 		case <-stop:
 			log.Debug("Reconciler exiting due to stop signal")
 			return
 		case <-ticker:
-			ticker = r.clock.After(r.ival)
+			ticker = r.clock.After(r.nextInterval())
+			debounced = nil
 			log.Debug("Reconciler tick")
 			r.rFunc()
 		case <-trigger:
-			ticker = r.clock.After(r.ival)
+			if r.debounce > 0 {
+				debounced = r.clock.After(r.debounce)
+				continue
+			}
+			ticker = r.clock.After(r.nextInterval())
+			log.Debug("Reconciler triggered")
+			r.rFunc()
+		case <-debounced:
+			ticker = r.clock.After(r.nextInterval())
+			debounced = nil
 			log.Debug("Reconciler triggered")
 			r.rFunc()
 		}
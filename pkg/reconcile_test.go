@@ -136,3 +136,89 @@ func TestPeriodicReconcilerRun(t *testing.T) {
 		t.Fatalf("PeriodicReconciler.Run did not return after stop signal!")
 	}
 }
+
+// TestPeriodicReconcilerDebounce validates that a burst of events results in
+// a single reconciliation once the debounce window has passed
+func TestPeriodicReconcilerDebounce(t *testing.T) {
+	debounce := time.Second
+	fclock := clockwork.NewFakeClock()
+	fes := &fakeEventStream{make(chan Event)}
+	called := make(chan struct{})
+	rec := func() {
+		go func() {
+			called <- struct{}{}
+		}()
+	}
+	pr := &reconciler{
+		ival:     5 * time.Hour,
+		debounce: debounce,
+		rFunc:    rec,
+		eStream:  fes,
+		clock:    fclock,
+	}
+	stop := make(chan bool)
+	defer close(stop)
+	go pr.Run(stop)
+
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatalf("rFunc() not called at start-up as expected!")
+	}
+
+	blockUntil := func(n int) {
+		blocked := make(chan struct{})
+		go func() {
+			fclock.BlockUntil(n)
+			close(blocked)
+		}()
+		select {
+		case <-blocked:
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %d sleepers", n)
+		}
+	}
+
+	// each event starts a new debounce window, alongside the periodic
+	// one; superseded windows keep sleeping until they expire
+	for _, sleepers := range []int{2, 3, 3} {
+		fes.trigger()
+		blockUntil(sleepers)
+		fclock.Advance(debounce / 2)
+	}
+	select {
+	case <-called:
+		t.Fatalf("rFunc() called before the debounce window passed!")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	fclock.Advance(debounce / 2)
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatalf("rFunc() not called after the debounce window passed!")
+	}
+	select {
+	case <-called:
+		t.Fatalf("rFunc() called unexpectedly!")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestPeriodicReconcilerNextInterval(t *testing.T) {
+	ival := 5 * time.Second
+	pr := NewPeriodicReconciler(ival, func() {}, nil).(*reconciler)
+	for i := 0; i < 10; i++ {
+		if got := pr.nextInterval(); got != ival {
+			t.Fatalf("Expected unjittered interval %v, got %v", ival, got)
+		}
+	}
+
+	jitter := time.Second
+	pr = NewJitteredPeriodicReconciler(ival, jitter, func() {}, nil).(*reconciler)
+	for i := 0; i < 100; i++ {
+		if got := pr.nextInterval(); got < ival || got >= ival+jitter {
+			t.Fatalf("Jittered interval %v outside of range [%v, %v)", got, ival, ival+jitter)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+)
+
+const (
+	auditPrefix = "audit"
+
+	// auditCreateAttempts is the number of keys tried when recording an
+	// AuditEntry, in case another entry was recorded at the same time
+	auditCreateAttempts = 10
+)
+
+// auditPath returns the keypath of an AuditEntry recorded at the given
+// time. Keys are zero-padded so that they sort in the order recorded.
+func (r *EtcdRegistry) auditPath(t time.Time) string {
+	return path.Join(r.keyPrefix, auditPrefix, fmt.Sprintf("%020d", t.UnixNano()))
+}
+
+// AuditLog returns every AuditEntry recorded, oldest first
+func (r *EtcdRegistry) AuditLog() ([]job.AuditEntry, error) {
+	req := etcd.Get{
+		Key:       path.Join(r.keyPrefix, auditPrefix),
+		Sorted:    true,
+		Recursive: true,
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	var entries []job.AuditEntry
+	for _, node := range res.Node.Nodes {
+		var e job.AuditEntry
+		if err := unmarshal(node.Value, &e); err != nil {
+			log.Errorf("Failed parsing audit entry at key %s: %v", node.Key, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// RecordAudit appends an AuditEntry to the log. Entries are only ever
+// created, never updated, so that the log cannot be rewritten by fleet.
+func (r *EtcdRegistry) RecordAudit(e job.AuditEntry) error {
+	json, err := marshal(e)
+	if err != nil {
+		return err
+	}
+
+	t := e.Time
+	for i := 0; ; i++ {
+		req := etcd.Create{
+			Key:   r.auditPath(t),
+			Value: json,
+		}
+		_, err = r.etcd.Do(&req)
+		if !isNodeExist(err) || i+1 >= auditCreateAttempts {
+			return err
+		}
+		t = t.Add(time.Nanosecond)
+	}
+}
+
+// NewAuditedRegistry wraps a Registry so that each Job it creates,
+// destroys, schedules or gives a target state is recorded in the given
+// AuditRegistry, attributed to the given Machine and identity.
+func NewAuditedRegistry(reg Registry, audit AuditRegistry, machID, identity string) *AuditedRegistry {
+	return &AuditedRegistry{
+		Registry:  reg,
+		audit:     audit,
+		machID:    machID,
+		identity:  identity,
+		clockFunc: time.Now,
+	}
+}
+
+// AuditedRegistry records the changes made to Jobs through it in an
+// AuditRegistry. Changes which fail are not recorded, while failing to
+// record a change is logged but does not fail it.
+type AuditedRegistry struct {
+	Registry
+
+	audit     AuditRegistry
+	machID    string
+	identity  string
+	clockFunc func() time.Time
+}
+
+// WithIdentity returns a copy of the AuditedRegistry attributing changes
+// to the given identity, e.g. that of a single API request
+func (ar *AuditedRegistry) WithIdentity(identity string) *AuditedRegistry {
+	cp := *ar
+	cp.identity = identity
+	return &cp
+}
+
+func (ar *AuditedRegistry) record(op job.AuditOperation, name, detail string) {
+	e := job.AuditEntry{
+		Time:      ar.clockFunc(),
+		Operation: op,
+		JobName:   name,
+		Detail:    detail,
+		MachineID: ar.machID,
+		Identity:  ar.identity,
+	}
+	if err := ar.audit.RecordAudit(e); err != nil {
+		log.Errorf("Failed recording audit entry for %s of Job(%s) by %s: %v", op, name, ar.identity, err)
+	}
+}
+
+func (ar *AuditedRegistry) CreateUnit(u *job.Unit) error {
+	if err := ar.Registry.CreateUnit(u); err != nil {
+		return err
+	}
+	ar.record(job.AuditOperationCreate, u.Name, string(u.TargetState))
+	return nil
+}
+
+func (ar *AuditedRegistry) DestroyUnit(name string) error {
+	if err := ar.Registry.DestroyUnit(name); err != nil {
+		return err
+	}
+	ar.record(job.AuditOperationDestroy, name, "")
+	return nil
+}
+
+func (ar *AuditedRegistry) SetUnitTargetState(name string, state job.JobState) error {
+	if err := ar.Registry.SetUnitTargetState(name, state); err != nil {
+		return err
+	}
+	ar.record(job.AuditOperationSetTargetState, name, string(state))
+	return nil
+}
+
+func (ar *AuditedRegistry) ScheduleUnit(name, machID string) error {
+	if err := ar.Registry.ScheduleUnit(name, machID); err != nil {
+		return err
+	}
+	ar.record(job.AuditOperationSchedule, name, machID)
+	return nil
+}
+
+func (ar *AuditedRegistry) RescheduleUnit(name, from, to string) error {
+	if err := ar.Registry.RescheduleUnit(name, from, to); err != nil {
+		return err
+	}
+	ar.record(job.AuditOperationSchedule, name, to)
+	return nil
+}
+
+// AuditLog and RecordAudit pass through to the AuditRegistry
+func (ar *AuditedRegistry) AuditLog() ([]job.AuditEntry, error) {
+	return ar.audit.AuditLog()
+}
+
+func (ar *AuditedRegistry) RecordAudit(e job.AuditEntry) error {
+	return ar.audit.RecordAudit(e)
+}
+
+// Orphans and RemoveOrphan pass through to the wrapped Registry, so that
+// the engine can still collect garbage through an AuditedRegistry
+func (ar *AuditedRegistry) Orphans() ([]Orphan, error) {
+	if gc, ok := ar.Registry.(GCRegistry); ok {
+		return gc.Orphans()
+	}
+	return nil, nil
+}
+
+func (ar *AuditedRegistry) RemoveOrphan(o Orphan) error {
+	if gc, ok := ar.Registry.(GCRegistry); ok {
+		return gc.RemoveOrphan(o)
+	}
+	return nil
+}
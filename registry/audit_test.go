@@ -0,0 +1,95 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestAuditedRegistry(t *testing.T) {
+	fr := NewFakeRegistry()
+	at := time.Date(2014, time.October, 15, 10, 30, 0, 0, time.UTC)
+	ar := NewAuditedRegistry(fr, fr, "XXX", "engine")
+	ar.clockFunc = func() time.Time { return at }
+
+	uf, _ := unit.NewUnitFile("")
+	if err := ar.CreateUnit(&job.Unit{Name: "foo.service", Unit: *uf, TargetState: job.JobStateLoaded}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ar.WithIdentity("api:alice@10.0.0.1:1234").SetUnitTargetState("foo.service", job.JobStateLaunched); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ar.ScheduleUnit("foo.service", "YYY"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// failed changes are not recorded
+	if err := ar.ScheduleUnit("foo.service", "ZZZ"); err == nil {
+		t.Fatalf("expected scheduling twice to fail")
+	}
+	if err := ar.SetUnitTargetState("bar.service", job.JobStateLaunched); err == nil {
+		t.Fatalf("expected changing a missing Unit to fail")
+	}
+
+	if err := ar.DestroyUnit("foo.service"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []job.AuditEntry{
+		{Time: at, Operation: job.AuditOperationCreate, JobName: "foo.service", Detail: "loaded", MachineID: "XXX", Identity: "engine"},
+		{Time: at, Operation: job.AuditOperationSetTargetState, JobName: "foo.service", Detail: "launched", MachineID: "XXX", Identity: "api:alice@10.0.0.1:1234"},
+		{Time: at, Operation: job.AuditOperationSchedule, JobName: "foo.service", Detail: "YYY", MachineID: "XXX", Identity: "engine"},
+		{Time: at, Operation: job.AuditOperationDestroy, JobName: "foo.service", MachineID: "XXX", Identity: "engine"},
+	}
+	got, err := ar.AuditLog()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("expected audit log %v, got %v", want, got)
+	}
+}
+
+func TestRecordAudit(t *testing.T) {
+	// an entry recorded at the same time as another is given the next key
+	e := &testEtcdClient{
+		err: []error{etcd.Error{ErrorCode: etcd.ErrorNodeExist}},
+	}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+	entry := job.AuditEntry{
+		Time:      time.Unix(0, 1000),
+		Operation: job.AuditOperationDestroy,
+		JobName:   "foo.service",
+		Identity:  "engine",
+	}
+	if err := r.RecordAudit(entry); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	json, _ := marshal(entry)
+	want := []action{
+		{key: "/fleet/audit/00000000000000001000", val: json},
+		{key: "/fleet/audit/00000000000000001001", val: json},
+	}
+	if !reflect.DeepEqual(want, e.creates) {
+		t.Errorf("expected creates %v, got %v", want, e.creates)
+	}
+}
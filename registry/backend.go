@@ -0,0 +1,234 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/fleet/config"
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/pkg"
+)
+
+const (
+	// DefaultBackendURL selects the etcd backend, configured by the
+	// etcd_* options
+	DefaultBackendURL = "etcd://"
+
+	// retryJitter is the fraction by which delays between retries of
+	// etcd requests are randomized
+	retryJitter = 0.2
+)
+
+// Backend is a store in which the fleet registry is kept
+type Backend struct {
+	Registry
+	ClusterRegistry
+	LeaseRegistry
+
+	// Events emits the Events of interest to agents, and EngineEvents
+	// additionally those of interest to the engine. CacheEvents emits
+	// the same Events as EngineEvents, for a CachedRegistry to watch.
+	Events       pkg.EventStream
+	EngineEvents pkg.EventStream
+	CacheEvents  pkg.EventStream
+
+	// Endpoints, if set, replaces the endpoints of the store when the
+	// etcd_servers option changes
+	Endpoints EndpointSetter
+}
+
+// EndpointSetter replaces the endpoints through which a Backend is reached
+type EndpointSetter interface {
+	SetEndpoints(endpoints []string) error
+}
+
+// BackendFactory creates a Backend from the URL selecting it, and from
+// the configuration of fleetd for any settings not given by the URL
+type BackendFactory func(u *url.URL, cfg config.Config) (*Backend, error)
+
+var (
+	backendsMu sync.Mutex
+	backends   = make(map[string]BackendFactory)
+)
+
+func init() {
+	RegisterBackend("etcd", newEtcdBackend)
+	RegisterBackend("mem", newMemBackend)
+}
+
+// RegisterBackend makes a Backend available under the given URL scheme.
+// It panics if the scheme is already registered.
+func RegisterBackend(scheme string, f BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if _, ok := backends[scheme]; ok {
+		panic(fmt.Sprintf("registry backend %q registered twice", scheme))
+	}
+	backends[scheme] = f
+}
+
+// NewBackend creates the Backend selected by the scheme of the given URL
+func NewBackend(rawurl string, cfg config.Config) (*Backend, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry URL %q: %v", rawurl, err)
+	}
+
+	backendsMu.Lock()
+	f, ok := backends[u.Scheme]
+	backendsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown registry backend %q, expected one of %q", u.Scheme, BackendSchemes())
+	}
+	return f(u, cfg)
+}
+
+// BackendSchemes lists the URL schemes of the registered Backends
+func BackendSchemes() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	var schemes []string
+	for s := range backends {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// newEtcdBackend keeps the registry in etcd. The hosts of the URL, if any,
+// are the etcd endpoints to use in place of the etcd_servers option, and
+// its path the key prefix in place of etcd_key_prefix, e.g.
+// etcd://10.0.0.1:4001,10.0.0.2:4001/fleet
+func newEtcdBackend(u *url.URL, cfg config.Config) (*Backend, error) {
+	servers := cfg.EtcdServers
+	if cfg.EtcdDiscoverySRV != "" {
+		discovered, err := etcd.DiscoverEndpoints(cfg.EtcdDiscoverySRV)
+		if err != nil {
+			return nil, err
+		}
+		servers = discovered
+	}
+	if u.Host != "" {
+		servers = nil
+		for _, host := range strings.Split(u.Host, ",") {
+			servers = append(servers, "http://"+host)
+		}
+	}
+	prefix := cfg.EtcdKeyPrefix
+	if u.Path != "" && u.Path != "/" {
+		prefix = u.Path
+	}
+	prefix, err := NamespaceKeyPrefix(prefix, cfg.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := pkg.ReadTLSConfigFiles(cfg.EtcdCAFile, cfg.EtcdCertFile, cfg.EtcdKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(cfg.EtcdRequestTimeout*1000) * time.Millisecond
+	trans := etcd.NewTransport(etcd.TransportConfig{
+		MaxIdleConnsPerHost: cfg.EtcdMaxIdleConns,
+		DialTimeout:         time.Duration(cfg.EtcdDialTimeout*1000) * time.Millisecond,
+		KeepAlive:           time.Duration(cfg.EtcdKeepalive*1000) * time.Millisecond,
+	}, tlsConfig)
+	eClient, err := etcd.NewClient(servers, trans, timeout)
+	if err != nil {
+		return nil, err
+	}
+	eClient.SetEndpointRotation(cfg.EtcdEndpointFailures)
+	if cfg.EtcdDiscoverySRV != "" && u.Host == "" {
+		eClient.SetEndpointDiscovery(cfg.EtcdDiscoverySRV)
+	}
+	if cfg.EtcdUsername != "" {
+		creds := etcd.StaticCredentials(cfg.EtcdUsername, cfg.EtcdPassword)
+		if cfg.EtcdPasswordFile != "" {
+			creds = etcd.PasswordFileCredentials(cfg.EtcdUsername, cfg.EtcdPasswordFile)
+		}
+		eClient.SetCredentials(creds)
+	}
+
+	var client etcd.Client = eClient
+	if cfg.EtcdRequestRate > 0 {
+		client = etcd.NewRateLimitedClient(client, cfg.EtcdRequestRate, cfg.EtcdRequestBurst)
+	}
+	if ics := registeredInterceptors(); len(ics) > 0 {
+		client = NewInstrumentedClient(client, prefix, ics...)
+	}
+
+	// transient failures, such as a connection reset by a restarting
+	// etcd, are retried rather than failing reconciliations and heartbeats
+	if cfg.EtcdRetryAttempts > 1 {
+		client = etcd.NewRetryClient(client, etcd.RetryPolicy{
+			Attempts:   cfg.EtcdRetryAttempts,
+			Backoff:    time.Duration(cfg.EtcdRetryBackoff*1000) * time.Millisecond,
+			MaxBackoff: time.Duration(cfg.EtcdRetryMaxBackoff*1000) * time.Millisecond,
+			Jitter:     retryJitter,
+			Timeout:    timeout,
+		})
+	}
+
+	reg := NewEtcdRegistry(client, prefix)
+	reg.SetUnitCompression(cfg.EtcdCompressUnits)
+	if cfg.UnitEncryptionKeyFile != "" {
+		key, err := ReadUnitEncryptionKeyFile(cfg.UnitEncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := reg.SetUnitEncryptionKey(key); err != nil {
+			return nil, err
+		}
+	}
+	backend := &Backend{
+		Registry:        reg,
+		ClusterRegistry: reg,
+		LeaseRegistry:   reg,
+		Events:          NewEtcdEventStream(client, prefix),
+		EngineEvents:    NewEtcdEngineEventStream(client, prefix),
+		CacheEvents:     NewEtcdEngineEventStream(client, prefix),
+	}
+	// endpoints named by the URL or discovered through SRV records take
+	// precedence over etcd_servers, so are left alone
+	if cfg.EtcdDiscoverySRV == "" && u.Host == "" {
+		backend.Endpoints = eClient
+	}
+	return backend, nil
+}
+
+// newMemBackend keeps the registry in the memory of fleetd, which is
+// only of use to a single machine cluster for development and testing.
+// Nothing is shared with other machines or survives a restart.
+func newMemBackend(u *url.URL, cfg config.Config) (*Backend, error) {
+	reg := newMemRegistry()
+	engineEvents := []pkg.Event{JobTargetChangeEvent, JobTargetStateChangeEvent, MachineChangeEvent, RolloutChangeEvent}
+	return &Backend{
+		Registry:        reg,
+		ClusterRegistry: NewFakeClusterRegistry(nil, 0),
+		LeaseRegistry:   NewFakeLeaseRegistry(),
+		Events:          reg.events.stream(JobTargetChangeEvent, JobTargetStateChangeEvent),
+		EngineEvents:    reg.events.stream(engineEvents...),
+		CacheEvents:     reg.events.stream(engineEvents...),
+	}, nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/config"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/pkg"
+)
+
+func TestNewBackendEtcd(t *testing.T) {
+	cfg := config.Config{EtcdServers: []string{"http://127.0.0.1:4001"}, EtcdKeyPrefix: DefaultKeyPrefix, EtcdRequestTimeout: 1}
+
+	tests := []struct {
+		url       string
+		namespace string
+		prefix    string
+	}{
+		{DefaultBackendURL, "", DefaultKeyPrefix},
+		{"etcd:///fleet-test", "", "/fleet-test"},
+		{"etcd://10.0.0.1:4001,10.0.0.2:4001/fleet", "", "/fleet"},
+		{DefaultBackendURL, "team-a", "/_coreos.com/fleet/namespaces/team-a/"},
+		{"etcd:///fleet", "team-b", "/fleet/namespaces/team-b/"},
+	}
+
+	for i, tt := range tests {
+		cfg.Namespace = tt.namespace
+		b, err := NewBackend(tt.url, cfg)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		reg, ok := b.Registry.(*EtcdRegistry)
+		if !ok {
+			t.Errorf("case %d: expected an EtcdRegistry, got %T", i, b.Registry)
+			continue
+		}
+		if reg.keyPrefix != tt.prefix {
+			t.Errorf("case %d: expected key prefix %q, got %q", i, tt.prefix, reg.keyPrefix)
+		}
+		if b.Events == nil || b.EngineEvents == nil || b.ClusterRegistry == nil || b.LeaseRegistry == nil {
+			t.Errorf("case %d: incomplete Backend %#v", i, b)
+		}
+		// only endpoints taken from etcd_servers may be replaced
+		if hasHost := strings.Contains(tt.url, ":4001"); hasHost != (b.Endpoints == nil) {
+			t.Errorf("case %d: expected replaceable endpoints=%t", i, !hasHost)
+		}
+	}
+}
+
+func TestNamespaceKeyPrefixInvalid(t *testing.T) {
+	for _, ns := range []string{"team/a", "..", ".", "team a", "../job"} {
+		if _, err := NamespaceKeyPrefix(DefaultKeyPrefix, ns); err == nil {
+			t.Errorf("expected error using namespace %q", ns)
+		}
+	}
+}
+
+func TestNewBackendMem(t *testing.T) {
+	b, err := NewBackend("mem://", config.Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a machine may heartbeat against the backend and lead its engine
+	if _, err := b.SetMachineState(machine.MachineState{ID: "XXX"}, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if machines, _ := b.Machines(); len(machines) != 1 || machines[0].ID != "XXX" {
+		t.Fatalf("expected Machine XXX, got %#v", machines)
+	}
+	if l, err := b.AcquireLease("engine-leader", "XXX", 1, 0); err != nil || l == nil {
+		t.Fatalf("expected to acquire lease, got %v (err %v)", l, err)
+	}
+	if err := b.RemoveMachineState("XXX"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if machines, _ := b.Machines(); len(machines) != 0 {
+		t.Fatalf("expected no Machines, got %#v", machines)
+	}
+}
+
+func TestNewBackendMemEvents(t *testing.T) {
+	b, err := NewBackend("mem://", config.Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+
+	next := func(es pkg.EventStream, change func()) (pkg.Event, bool) {
+		ch := es.Next(stop)
+		change()
+		select {
+		case ev := <-ch:
+			return ev, true
+		case <-time.After(100 * time.Millisecond):
+			return "", false
+		}
+	}
+
+	// agents are notified of changes to the schedule
+	ev, ok := next(b.Events, func() { b.CreateUnit(&job.Unit{Name: "foo.service", TargetState: job.JobStateLaunched}) })
+	if !ok || ev != JobTargetStateChangeEvent {
+		t.Fatalf("expected %v, got %v", JobTargetStateChangeEvent, ev)
+	}
+	ev, ok = next(b.Events, func() { b.ScheduleUnit("foo.service", "XXX") })
+	if !ok || ev != JobTargetChangeEvent {
+		t.Fatalf("expected %v, got %v", JobTargetChangeEvent, ev)
+	}
+
+	// but not of Machines, which only the engine watches
+	ms := machine.MachineState{ID: "XXX"}
+	if ev, ok = next(b.Events, func() { b.SetMachineState(ms, 0) }); ok {
+		t.Fatalf("expected no Event, got %v", ev)
+	}
+	ev, ok = next(b.EngineEvents, func() { b.SetMachineState(machine.MachineState{ID: "YYY"}, 0) })
+	if !ok || ev != MachineChangeEvent {
+		t.Fatalf("expected %v, got %v", MachineChangeEvent, ev)
+	}
+
+	// heartbeats publishing the same state are not changes
+	if ev, ok = next(b.EngineEvents, func() { b.SetMachineState(ms, 0) }); ok {
+		t.Fatalf("expected no Event, got %v", ev)
+	}
+}
+
+func TestNewBackendUnknown(t *testing.T) {
+	for _, u := range []string{"consul://127.0.0.1:8500", "127.0.0.1:4001", "%zz"} {
+		if _, err := NewBackend(u, config.Config{}); err == nil {
+			t.Errorf("expected error creating Backend from %q", u)
+		}
+	}
+}
+
+func TestRegisterBackendTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected registering a scheme twice to panic")
+		}
+	}()
+	RegisterBackend("etcd", func(*url.URL, config.Config) (*Backend, error) { return nil, nil })
+}
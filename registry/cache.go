@@ -0,0 +1,235 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/unit"
+)
+
+// CachedRegistry serves the Units, the schedule and the Machines of the
+// cluster from memory, reading them from the wrapped Registry only once
+// they have been invalidated. The copies are invalidated by the Events
+// observed while Run is watching, by writes made through the
+// CachedRegistry itself, and in any case once they are older than the
+// maximum age, bounding how stale they may be when a change is missed.
+// Nothing is cached while Run is not watching.
+type CachedRegistry struct {
+	Registry
+
+	events pkg.EventStream
+	maxAge time.Duration
+	clock  clockwork.Clock
+
+	mu       sync.Mutex
+	watching bool
+	// gen counts invalidations, so that a read racing with one is not
+	// cached
+	gen      uint64
+	units    *cachedRead
+	sched    *cachedRead
+	machines *cachedRead
+}
+
+// cachedRead holds the result of a read and when it was made
+type cachedRead struct {
+	at  time.Time
+	val interface{}
+}
+
+// NewCachedRegistry wraps the given Registry, invalidating the cached reads
+// on the Events emitted by the given EventStream, or once they are older
+// than maxAge
+func NewCachedRegistry(reg Registry, events pkg.EventStream, maxAge time.Duration) *CachedRegistry {
+	return &CachedRegistry{
+		Registry: reg,
+		events:   events,
+		maxAge:   maxAge,
+		clock:    clockwork.NewRealClock(),
+	}
+}
+
+// Run watches for changes to the Registry, invalidating the affected
+// reads, until the stop channel is closed
+func (c *CachedRegistry) Run(stop chan bool) {
+	abort := make(chan struct{})
+	go func() {
+		<-stop
+		close(abort)
+	}()
+
+	c.mu.Lock()
+	c.watching = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.watching = false
+		c.invalidate(true, true)
+		c.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-abort:
+			return
+		case ev := <-c.events.Next(abort):
+			c.mu.Lock()
+			switch ev {
+			case JobTargetChangeEvent, JobTargetStateChangeEvent, RolloutChangeEvent:
+				c.invalidate(true, false)
+			case MachineChangeEvent:
+				c.invalidate(false, true)
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// invalidate discards the cached Units and schedule and/or Machines. The
+// caller must hold mu.
+func (c *CachedRegistry) invalidate(jobs, machines bool) {
+	c.gen++
+	if jobs {
+		c.units, c.sched = nil, nil
+	}
+	if machines {
+		c.machines = nil
+	}
+}
+
+// read returns the cached result of a read if it is fresh, or otherwise
+// reads afresh with the given function, caching the result if watching
+func (c *CachedRegistry) read(cached **cachedRead, fetch func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	if cr := *cached; cr != nil && c.clock.Now().Sub(cr.at) < c.maxAge {
+		c.mu.Unlock()
+		return cr.val, nil
+	}
+	gen := c.gen
+	c.mu.Unlock()
+
+	at := c.clock.Now()
+	val, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.watching && c.gen == gen {
+		*cached = &cachedRead{at: at, val: val}
+	}
+	c.mu.Unlock()
+	return val, nil
+}
+
+// Units returns the Units of the cluster. The returned slice is shared
+// with other callers and must not be modified.
+func (c *CachedRegistry) Units() ([]job.Unit, error) {
+	val, err := c.read(&c.units, func() (interface{}, error) { return c.Registry.Units() })
+	if err != nil {
+		return nil, err
+	}
+	return val.([]job.Unit), nil
+}
+
+// Schedule returns the schedule of the cluster. The returned slice is
+// shared with other callers and must not be modified.
+func (c *CachedRegistry) Schedule() ([]job.ScheduledUnit, error) {
+	val, err := c.read(&c.sched, func() (interface{}, error) { return c.Registry.Schedule() })
+	if err != nil {
+		return nil, err
+	}
+	return val.([]job.ScheduledUnit), nil
+}
+
+// Machines returns the Machines of the cluster. The returned slice is
+// shared with other callers and must not be modified.
+func (c *CachedRegistry) Machines() ([]machine.MachineState, error) {
+	val, err := c.read(&c.machines, func() (interface{}, error) { return c.Registry.Machines() })
+	if err != nil {
+		return nil, err
+	}
+	return val.([]machine.MachineState), nil
+}
+
+// written invalidates the reads affected by a write through the
+// CachedRegistry
+func (c *CachedRegistry) written(jobs, machines bool) {
+	c.mu.Lock()
+	c.invalidate(jobs, machines)
+	c.mu.Unlock()
+}
+
+func (c *CachedRegistry) CreateUnit(u *job.Unit) error {
+	defer c.written(true, false)
+	return c.Registry.CreateUnit(u)
+}
+
+func (c *CachedRegistry) DestroyUnit(name string) error {
+	defer c.written(true, false)
+	return c.Registry.DestroyUnit(name)
+}
+
+func (c *CachedRegistry) ScheduleUnit(name, machID string) error {
+	defer c.written(true, false)
+	return c.Registry.ScheduleUnit(name, machID)
+}
+
+func (c *CachedRegistry) RescheduleUnit(name, from, to string) error {
+	defer c.written(true, false)
+	return c.Registry.RescheduleUnit(name, from, to)
+}
+
+func (c *CachedRegistry) UnscheduleUnit(name, machID string) error {
+	defer c.written(true, false)
+	return c.Registry.UnscheduleUnit(name, machID)
+}
+
+func (c *CachedRegistry) SetUnitTargetState(name string, state job.JobState) error {
+	defer c.written(true, false)
+	return c.Registry.SetUnitTargetState(name, state)
+}
+
+func (c *CachedRegistry) UpdateUnitFile(name string, uf unit.UnitFile) error {
+	defer c.written(true, false)
+	return c.Registry.UpdateUnitFile(name, uf)
+}
+
+func (c *CachedRegistry) SetMachineState(ms machine.MachineState, ttl time.Duration) (uint64, error) {
+	defer c.written(false, true)
+	return c.Registry.SetMachineState(ms, ttl)
+}
+
+func (c *CachedRegistry) SetMachineSchedulability(machID string, s machine.Schedulability) error {
+	defer c.written(false, true)
+	return c.Registry.SetMachineSchedulability(machID, s)
+}
+
+func (c *CachedRegistry) SetMachineMetadata(machID, key, value string) error {
+	defer c.written(false, true)
+	return c.Registry.SetMachineMetadata(machID, key, value)
+}
+
+func (c *CachedRegistry) RemoveMachineState(machID string) error {
+	defer c.written(false, true)
+	return c.Registry.RemoveMachineState(machID)
+}
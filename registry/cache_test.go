@@ -0,0 +1,124 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/pkg"
+)
+
+// countingRegistry counts the reads of Units and Machines
+type countingRegistry struct {
+	*FakeRegistry
+	unitReads    int
+	machineReads int
+}
+
+func (cr *countingRegistry) Units() ([]job.Unit, error) {
+	cr.unitReads++
+	return cr.FakeRegistry.Units()
+}
+
+func (cr *countingRegistry) Machines() ([]machine.MachineState, error) {
+	cr.machineReads++
+	return cr.FakeRegistry.Machines()
+}
+
+// chanEventStream emits the Events sent on its channel
+type chanEventStream chan pkg.Event
+
+func (es chanEventStream) Next(stop chan struct{}) chan pkg.Event {
+	out := make(chan pkg.Event)
+	go func() {
+		select {
+		case <-stop:
+		case ev := <-es:
+			select {
+			case <-stop:
+			case out <- ev:
+			}
+		}
+	}()
+	return out
+}
+
+func TestCachedRegistry(t *testing.T) {
+	reg := &countingRegistry{FakeRegistry: NewFakeRegistry()}
+	reg.SetMachines([]machine.MachineState{{ID: "XXX"}})
+	events := make(chanEventStream)
+	fclock := clockwork.NewFakeClock()
+	c := NewCachedRegistry(reg, events, time.Minute)
+	c.clock = fclock
+
+	// nothing is cached until watching
+	c.Units()
+	c.Units()
+	if reg.unitReads != 2 {
+		t.Fatalf("expected 2 reads while not watching, got %d", reg.unitReads)
+	}
+
+	stop := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		c.Run(stop)
+		close(done)
+	}()
+	// an Event is only received once watching has begun
+	events <- pkg.Event("unrelated")
+
+	c.Units()
+	c.Units()
+	c.Machines()
+	if reg.unitReads != 3 || reg.machineReads != 1 {
+		t.Fatalf("expected reads to be cached, got %d Unit and %d Machine reads", reg.unitReads, reg.machineReads)
+	}
+
+	// writes through the cache invalidate it
+	if err := c.CreateUnit(&job.Unit{Name: "foo.service"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if units, _ := c.Units(); len(units) != 1 || reg.unitReads != 4 {
+		t.Fatalf("expected Units to be reread after a write, got %v after %d reads", units, reg.unitReads)
+	}
+
+	// as do Events, only affecting the reads concerned
+	events <- MachineChangeEvent
+	events <- pkg.Event("unrelated")
+	c.Units()
+	c.Machines()
+	if reg.unitReads != 4 || reg.machineReads != 2 {
+		t.Fatalf("expected only Machines to be reread, got %d Unit and %d Machine reads", reg.unitReads, reg.machineReads)
+	}
+
+	// and age
+	fclock.Advance(time.Minute)
+	c.Units()
+	if reg.unitReads != 5 {
+		t.Fatalf("expected Units to be reread once stale, got %d reads", reg.unitReads)
+	}
+
+	close(stop)
+	<-done
+	c.Units()
+	if reg.unitReads != 6 {
+		t.Fatalf("expected Units to be reread once no longer watching, got %d reads", reg.unitReads)
+	}
+}
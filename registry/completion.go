@@ -0,0 +1,208 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"path"
+	"time"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+)
+
+type completionModel struct {
+	UnitHash   string
+	MachineID  string
+	ExitStatus int
+	FinishedAt time.Time
+	Attempts   int
+	State      job.CompletionState
+}
+
+// jobCompletionPath returns the keypath of a Job's Completion. It lives
+// alongside the Job's other objects, so is removed when the Job is
+// destroyed.
+func (r *EtcdRegistry) jobCompletionPath(jobName string) string {
+	return path.Join(r.keyPrefix, jobPrefix, jobName, "completion")
+}
+
+// Completion retrieves the Completion of the named batch Job, or nil if
+// the Job has not yet completed.
+func (r *EtcdRegistry) Completion(name string) (*job.Completion, error) {
+	req := etcd.Get{
+		Key: r.jobCompletionPath(name),
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	return valueToCompletion(res.Node.Value)
+}
+
+// Completions returns the Completion of every batch Job that has completed,
+// indexed by Job name
+func (r *EtcdRegistry) Completions() (map[string]*job.Completion, error) {
+	req := etcd.Get{
+		Key:       path.Join(r.keyPrefix, jobPrefix),
+		Recursive: true,
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	comps := make(map[string]*job.Completion)
+	for _, dir := range res.Node.Nodes {
+		val := getValueInDir(&dir, "completion")
+		if val == "" {
+			continue
+		}
+
+		_, name := path.Split(dir.Key)
+		c, err := valueToCompletion(val)
+		if err != nil {
+			log.Errorf("Failed parsing Completion of Job(%s): %v", name, err)
+			continue
+		}
+		comps[name] = c
+	}
+
+	return comps, nil
+}
+
+// SaveCompletion records the outcome of the most recent run of the named
+// batch Job
+func (r *EtcdRegistry) SaveCompletion(name string, c *job.Completion) error {
+	cm := completionModel{
+		UnitHash:   c.UnitHash,
+		MachineID:  c.MachineID,
+		ExitStatus: c.ExitStatus,
+		FinishedAt: c.FinishedAt,
+		Attempts:   c.Attempts,
+		State:      c.State,
+	}
+	json, err := marshal(cm)
+	if err != nil {
+		return err
+	}
+
+	req := etcd.Set{
+		Key:   r.jobCompletionPath(name),
+		Value: json,
+	}
+	_, err = r.etcd.Do(&req)
+	return err
+}
+
+// jobRunsPath returns the keypath of the RunHistory of a Job with a cron
+// Schedule
+func (r *EtcdRegistry) jobRunsPath(jobName string) string {
+	return path.Join(r.keyPrefix, jobPrefix, jobName, "runs")
+}
+
+// RunHistory retrieves the RunHistory of the named Job, or nil if the Job
+// has none yet.
+func (r *EtcdRegistry) RunHistory(name string) (*job.RunHistory, error) {
+	req := etcd.Get{
+		Key: r.jobRunsPath(name),
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	var h job.RunHistory
+	if err := unmarshal(res.Node.Value, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// RunHistories returns the RunHistory of every Job that has one, indexed
+// by Job name
+func (r *EtcdRegistry) RunHistories() (map[string]*job.RunHistory, error) {
+	req := etcd.Get{
+		Key:       path.Join(r.keyPrefix, jobPrefix),
+		Recursive: true,
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	hists := make(map[string]*job.RunHistory)
+	for _, dir := range res.Node.Nodes {
+		val := getValueInDir(&dir, "runs")
+		if val == "" {
+			continue
+		}
+
+		_, name := path.Split(dir.Key)
+		var h job.RunHistory
+		if err := unmarshal(val, &h); err != nil {
+			log.Errorf("Failed parsing RunHistory of Job(%s): %v", name, err)
+			continue
+		}
+		hists[name] = &h
+	}
+
+	return hists, nil
+}
+
+// SaveRunHistory persists the RunHistory of the named Job
+func (r *EtcdRegistry) SaveRunHistory(name string, h *job.RunHistory) error {
+	json, err := marshal(h)
+	if err != nil {
+		return err
+	}
+
+	req := etcd.Set{
+		Key:   r.jobRunsPath(name),
+		Value: json,
+	}
+	_, err = r.etcd.Do(&req)
+	return err
+}
+
+func valueToCompletion(val string) (*job.Completion, error) {
+	var cm completionModel
+	if err := unmarshal(val, &cm); err != nil {
+		return nil, err
+	}
+
+	c := job.Completion{
+		UnitHash:   cm.UnitHash,
+		MachineID:  cm.MachineID,
+		ExitStatus: cm.ExitStatus,
+		FinishedAt: cm.FinishedAt,
+		Attempts:   cm.Attempts,
+		State:      cm.State,
+	}
+	return &c, nil
+}
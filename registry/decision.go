@@ -0,0 +1,152 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"path"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/job"
+)
+
+const (
+	decisionPrefix      = "decisions"
+	unschedulablePrefix = "unschedulable"
+)
+
+// decisionsPath returns the keypath of the scheduling decisions made about
+// a Job. Decisions are kept outside of the Job's own keyspace so that
+// reading the Jobs does not read their decisions as well.
+func (r *EtcdRegistry) decisionsPath(jobName string) string {
+	return path.Join(r.keyPrefix, decisionPrefix, jobName)
+}
+
+// Decisions returns the scheduling decisions recorded for the named Job,
+// oldest first
+func (r *EtcdRegistry) Decisions(name string) ([]job.Decision, error) {
+	req := etcd.Get{
+		Key: r.decisionsPath(name),
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	var decs []job.Decision
+	if err := unmarshal(res.Node.Value, &decs); err != nil {
+		return nil, err
+	}
+	return decs, nil
+}
+
+// RecordDecision appends a scheduling decision to those recorded for the
+// named Job, retaining no more than the given number of decisions
+func (r *EtcdRegistry) RecordDecision(name string, d job.Decision, limit int) error {
+	decs, err := r.Decisions(name)
+	if err != nil {
+		return err
+	}
+
+	decs = append(decs, d)
+	if len(decs) > limit {
+		decs = decs[len(decs)-limit:]
+	}
+
+	json, err := marshal(decs)
+	if err != nil {
+		return err
+	}
+
+	req := etcd.Set{
+		Key:   r.decisionsPath(name),
+		Value: json,
+	}
+	_, err = r.etcd.Do(&req)
+	return err
+}
+
+// destroyDecisions removes the scheduling decisions and the unschedulable
+// record of the named Job, if any
+func (r *EtcdRegistry) destroyDecisions(name string) error {
+	req := etcd.Delete{
+		Key: r.decisionsPath(name),
+	}
+	_, err := r.etcd.Do(&req)
+	if isKeyNotFound(err) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return r.SetUnschedulable(name, nil)
+}
+
+// unschedulablePath returns the keypath of the record of why a Job could
+// not be scheduled. Like decisions, it is kept outside of the Job's own
+// keyspace.
+func (r *EtcdRegistry) unschedulablePath(jobName string) string {
+	return path.Join(r.keyPrefix, unschedulablePrefix, jobName)
+}
+
+// Unschedulable returns why the engine has been unable to schedule the
+// named Job, or nil if it has not failed to
+func (r *EtcdRegistry) Unschedulable(name string) (*job.Unschedulable, error) {
+	req := etcd.Get{
+		Key: r.unschedulablePath(name),
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	var u job.Unschedulable
+	if err := unmarshal(res.Node.Value, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// SetUnschedulable records why the named Job could not be scheduled, or
+// removes the record if u is nil
+func (r *EtcdRegistry) SetUnschedulable(name string, u *job.Unschedulable) error {
+	if u == nil {
+		req := etcd.Delete{
+			Key: r.unschedulablePath(name),
+		}
+		_, err := r.etcd.Do(&req)
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return err
+	}
+
+	json, err := marshal(u)
+	if err != nil {
+		return err
+	}
+
+	req := etcd.Set{
+		Key:   r.unschedulablePath(name),
+		Value: json,
+	}
+	_, err = r.etcd.Do(&req)
+	return err
+}
@@ -0,0 +1,90 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"path"
+	"time"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/machine"
+)
+
+const (
+	engineStatusPrefix = "engine-status"
+)
+
+func (r *EtcdRegistry) engineStatusPath(machID string) string {
+	return path.Join(r.keyPrefix, engineStatusPrefix, machID)
+}
+
+// SetEngineStatus publishes the status of the engine of a Machine. The
+// status expires after the given TTL, so engines that have stopped
+// holding leases disappear.
+func (r *EtcdRegistry) SetEngineStatus(st machine.EngineStatus, ttl time.Duration) error {
+	json, err := marshal(st)
+	if err != nil {
+		return err
+	}
+
+	req := etcd.Set{
+		Key:   r.engineStatusPath(st.MachineID),
+		Value: json,
+		TTL:   ttl,
+	}
+	_, err = r.etcd.Do(&req)
+	return err
+}
+
+// RemoveEngineStatus removes the status of the engine of a Machine
+func (r *EtcdRegistry) RemoveEngineStatus(machID string) error {
+	req := etcd.Delete{
+		Key: r.engineStatusPath(machID),
+	}
+	_, err := r.etcd.Do(&req)
+	if isKeyNotFound(err) {
+		err = nil
+	}
+	return err
+}
+
+// EngineStatuses lists the published status of every engine holding
+// any lease, ordered by Machine ID
+func (r *EtcdRegistry) EngineStatuses() ([]machine.EngineStatus, error) {
+	req := etcd.Get{
+		Key:       path.Join(r.keyPrefix, engineStatusPrefix),
+		Sorted:    true,
+		Recursive: true,
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	var statuses []machine.EngineStatus
+	for _, node := range res.Node.Nodes {
+		var st machine.EngineStatus
+		if err := unmarshal(node.Value, &st); err != nil {
+			log.Errorf("Failed parsing engine status at key %s: %v", node.Key, err)
+			continue
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
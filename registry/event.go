@@ -17,6 +17,7 @@ package registry
 import (
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/fleet/etcd"
@@ -29,62 +30,191 @@ const (
 	JobTargetChangeEvent = pkg.Event("JobTargetChangeEvent")
 	// Occurs when any Job's target state is touched
 	JobTargetStateChangeEvent = pkg.Event("JobTargetStateChangeEvent")
+	// Occurs when a Machine joins or leaves the cluster, or its state changes
+	MachineChangeEvent = pkg.Event("MachineChangeEvent")
+	// Occurs when a Rollout is created or its progress changes
+	RolloutChangeEvent = pkg.Event("RolloutChangeEvent")
+	// Occurs when an engine resigns the lease of the schedule or a shard
+	EngineResignedEvent = pkg.Event("EngineResignedEvent")
 )
 
 type etcdEventStream struct {
 	etcd       etcd.Client
 	rootPrefix string
+	prefixes   []string
+
+	// waitIndex holds, for each watched key, the etcd index from which
+	// the next watch must resume so that no change goes unobserved
+	// between successive calls to Next. A zero index watches for changes
+	// from the current index onwards.
+	mu        sync.Mutex
+	waitIndex map[string]uint64
 }
 
+// NewEtcdEventStream returns an EventStream that emits an Event whenever
+// a Job's target or target state changes
 func NewEtcdEventStream(client etcd.Client, rootPrefix string) pkg.EventStream {
-	return &etcdEventStream{client, rootPrefix}
+	return newEtcdEventStream(client, rootPrefix, jobPrefix)
+}
+
+// NewEtcdEngineEventStream returns an EventStream that, in addition to
+// the Job events emitted by NewEtcdEventStream, emits an Event whenever
+// the set of Machines in the cluster or their state changes, a Rollout
+// is created or changes, or an engine resigns a lease
+func NewEtcdEngineEventStream(client etcd.Client, rootPrefix string) pkg.EventStream {
+	return newEtcdEventStream(client, rootPrefix, jobPrefix, machinePrefix, rolloutPrefix, resignedPrefix)
+}
+
+func newEtcdEventStream(client etcd.Client, rootPrefix string, prefixes ...string) *etcdEventStream {
+	return &etcdEventStream{
+		etcd:       client,
+		rootPrefix: rootPrefix,
+		prefixes:   prefixes,
+		waitIndex:  make(map[string]uint64),
+	}
 }
 
 // Next returns a channel which will emit an Event as soon as one of interest occurs
 func (es *etcdEventStream) Next(stop chan struct{}) chan pkg.Event {
 	evchan := make(chan pkg.Event)
-	go func() {
-		for {
-			select {
-			case <-stop:
-				return
-			default:
-			}
 
-			res := watch(es.etcd, path.Join(es.rootPrefix, jobPrefix), stop)
-			if ev, ok := parse(res, es.rootPrefix); ok {
-				evchan <- ev
-				return
-			}
+	// done is closed as soon as an Event has been emitted or stop is
+	// closed, terminating the watch on every prefix
+	done := make(chan struct{})
+	var once sync.Once
+	finish := func() {
+		once.Do(func() { close(done) })
+	}
+	go func() {
+		select {
+		case <-stop:
+			finish()
+		case <-done:
 		}
-
 	}()
 
+	for _, p := range es.prefixes {
+		go func(key string) {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				c, idx, ok := nextChange(es.etcd, es.rootPrefix, key, es.nextIndex(key), done)
+				if !ok {
+					return
+				}
+				es.setIndex(key, idx)
+				if ev, ok := changeEvent(c, es.rootPrefix); ok {
+					select {
+					case evchan <- ev:
+						finish()
+					case <-done:
+					}
+					return
+				}
+			}
+		}(path.Join(es.rootPrefix, p))
+	}
+
 	return evchan
 }
 
+func (es *etcdEventStream) nextIndex(key string) uint64 {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.waitIndex[key]
+}
+
+func (es *etcdEventStream) setIndex(key string, idx uint64) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.waitIndex[key] = idx
+}
+
+// resyncEvent returns the Event emitted in place of any changes to the
+// watched key that may have been missed
+func resyncEvent(key, prefix string) pkg.Event {
+	if strings.HasPrefix(key, path.Join(prefix, machinePrefix)) {
+		return MachineChangeEvent
+	}
+	return JobTargetChangeEvent
+}
+
+func isEventIndexCleared(err error) bool {
+	e, ok := err.(etcd.Error)
+	return ok && e.ErrorCode == etcd.ErrorEventIndexCleared
+}
+
 func parse(res *etcd.Result, prefix string) (ev pkg.Event, ok bool) {
-	if res == nil || res.Node == nil {
+	c, ok := parseChange(res, prefix)
+	if !ok {
 		return
 	}
+	return changeEvent(c, prefix)
+}
 
-	if !strings.HasPrefix(res.Node.Key, path.Join(prefix, jobPrefix)) {
-		return
+// changeEvent returns the Event emitted for the given Change, if any
+func changeEvent(c Change, prefix string) (ev pkg.Event, ok bool) {
+	switch c.Type {
+	case JobTargetChanged:
+		return JobTargetChangeEvent, true
+	case JobTargetStateChanged:
+		return JobTargetStateChangeEvent, true
+	case MachineChanged, MachineLost:
+		return MachineChangeEvent, true
+	case RolloutChanged:
+		return RolloutChangeEvent, true
+	case EngineResigned:
+		return EngineResignedEvent, true
+	case ChangesMissed:
+		return resyncEvent(c.Key, prefix), true
 	}
+	return
+}
 
-	switch path.Base(res.Node.Key) {
-	case "target-state":
-		ev = JobTargetStateChangeEvent
-		ok = true
-	case "target":
-		ev = JobTargetChangeEvent
-		ok = true
+// changedValue reports whether the given Result creates or removes a key,
+// or sets it to a different value than it held before
+func changedValue(res *etcd.Result) bool {
+	switch res.Action {
+	case "create", "delete", "expire":
+		return true
+	case "set", "update", "compareAndSwap":
+		return res.PrevNode == nil || res.PrevNode.Value != res.Node.Value
 	}
+	return false
+}
 
-	return
+// nextChange watches the given key from the given index onwards until a
+// Change of interest occurs, returning it along with the index from which
+// to resume watching. Should the changes since the index no longer be
+// available, ChangesMissed is returned, resuming from the current index.
+// ok is false if stop is closed first.
+func nextChange(client etcd.Client, rootPrefix, key string, idx uint64, stop chan struct{}) (c Change, next uint64, ok bool) {
+	for {
+		res, err := watch(client, key, idx, stop)
+		if isEventIndexCleared(err) {
+			// changes since the last observed index can no longer
+			// be replayed, so assume that something changed
+			log.Debugf("etcd event history cleared, resuming watch of %s from current index", key)
+			return Change{Type: ChangesMissed, Key: key}, 0, true
+		}
+		if res == nil || res.Node == nil {
+			return
+		}
+		idx = res.Node.ModifiedIndex + 1
+		if c, ok = parseChange(res, rootPrefix); ok {
+			return c, idx, true
+		}
+	}
 }
 
-func watch(client etcd.Client, key string, stop chan struct{}) (res *etcd.Result) {
+// watch waits for a change to the given key from the given index onwards,
+// retrying on errors until the stop channel is closed. An error is only
+// returned if the requested index has been cleared from the etcd history.
+func watch(client etcd.Client, key string, idx uint64, stop chan struct{}) (res *etcd.Result, err error) {
 	for res == nil {
 		select {
 		case <-stop:
@@ -93,14 +223,16 @@ func watch(client etcd.Client, key string, stop chan struct{}) (res *etcd.Result
 		default:
 			req := &etcd.Watch{
 				Key:       key,
-				WaitIndex: 0,
+				WaitIndex: idx,
 				Recursive: true,
 			}
 
 			log.Debugf("Creating etcd watcher: %v", req)
 
-			var err error
 			res, err = client.Wait(req, stop)
+			if isEventIndexCleared(err) {
+				return
+			}
 			if err != nil {
 				log.Errorf("etcd watcher %v returned error: %v", req, err)
 			}
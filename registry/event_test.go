@@ -15,8 +15,11 @@
 package registry
 
 import (
+	"errors"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/coreos/fleet/etcd"
 	"github.com/coreos/fleet/pkg"
@@ -91,3 +94,169 @@ func TestFilterEtcdEvents(t *testing.T) {
 		}
 	}
 }
+
+func TestFilterEtcdMachineEvents(t *testing.T) {
+	tests := []struct {
+		action string
+		key    string
+		value  string
+		prev   *etcd.Node
+		ok     bool
+	}{
+		// machines joining, leaving or expiring are always interesting
+		{action: "create", key: "/fleet/machines/XXX/object", ok: true},
+		{action: "delete", key: "/fleet/machines/XXX/object", ok: true},
+		{action: "expire", key: "/fleet/machines/XXX/object", ok: true},
+
+		// heartbeats refreshing unchanged state are ignored
+		{action: "update", key: "/fleet/machines/XXX/object", value: "A", prev: &etcd.Node{Value: "A"}, ok: false},
+
+		// changed state is interesting
+		{action: "update", key: "/fleet/machines/XXX/object", value: "B", prev: &etcd.Node{Value: "A"}, ok: true},
+		{action: "update", key: "/fleet/machines/XXX/object", value: "B", ok: true},
+
+		// other keys under the machine prefix are ignored
+		{action: "create", key: "/fleet/machines/XXX", ok: false},
+		{action: "create", key: "/fleet/machines/XXX/other", ok: false},
+	}
+
+	for i, tt := range tests {
+		res := &etcd.Result{
+			Action:   tt.action,
+			Node:     &etcd.Node{Key: tt.key, Value: tt.value},
+			PrevNode: tt.prev,
+		}
+		ev, ok := parse(res, "/fleet")
+		if ok != tt.ok {
+			t.Errorf("case %d: expected ok=%t, got %t", i, tt.ok, ok)
+			continue
+		}
+		if ok && ev != MachineChangeEvent {
+			t.Errorf("case %d: expected %v, got %v", i, MachineChangeEvent, ev)
+		}
+	}
+}
+
+// watchRecorder is an etcd.Client answering watches with canned responses,
+// recording the index from which each watch was requested. Once out of
+// responses, watches block until cancelled.
+type watchRecorder struct {
+	sync.Mutex
+	indexes []uint64
+	results []*etcd.Result
+	errs    []error
+}
+
+func (w *watchRecorder) Do(etcd.Action) (*etcd.Result, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (w *watchRecorder) Wait(act etcd.Action, cancel <-chan struct{}) (*etcd.Result, error) {
+	w.Lock()
+	w.indexes = append(w.indexes, act.(*etcd.Watch).WaitIndex)
+	if len(w.results) == 0 {
+		w.Unlock()
+		<-cancel
+		return nil, errors.New("cancelled")
+	}
+	res, err := w.results[0], w.errs[0]
+	w.results, w.errs = w.results[1:], w.errs[1:]
+	w.Unlock()
+	return res, err
+}
+
+func TestEtcdEventStreamResumesWatch(t *testing.T) {
+	target := func(idx uint64) *etcd.Result {
+		return &etcd.Result{Action: "set", Node: &etcd.Node{Key: "/fleet/job/foo.service/target", ModifiedIndex: idx}}
+	}
+	client := &watchRecorder{
+		results: []*etcd.Result{target(7), nil, target(9)},
+		errs:    []error{nil, etcd.Error{ErrorCode: etcd.ErrorEventIndexCleared}, nil},
+	}
+	es := NewEtcdEventStream(client, "/fleet")
+
+	for i := 0; i < 3; i++ {
+		select {
+		case ev := <-es.Next(make(chan struct{})):
+			if ev != JobTargetChangeEvent {
+				t.Fatalf("event %d: expected %v, got %v", i, JobTargetChangeEvent, ev)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+
+	// each watch resumes after the last observed change, and starts from
+	// the current index once the history has been cleared
+	client.Lock()
+	defer client.Unlock()
+	if want := []uint64{0, 8, 0}; !reflect.DeepEqual(want, client.indexes) {
+		t.Fatalf("expected watches from indexes %v, got %v", want, client.indexes)
+	}
+}
+
+func TestFilterEtcdRolloutEvents(t *testing.T) {
+	tests := []struct {
+		action string
+		key    string
+		value  string
+		prev   *etcd.Node
+		ok     bool
+	}{
+		{action: "set", key: "/fleet/rollout/foo@.service", value: "A", ok: true},
+		{action: "delete", key: "/fleet/rollout/foo@.service", ok: true},
+
+		// saving unchanged progress is ignored
+		{action: "set", key: "/fleet/rollout/foo@.service", value: "A", prev: &etcd.Node{Value: "A"}, ok: false},
+		{action: "set", key: "/fleet/rollout/foo@.service", value: "B", prev: &etcd.Node{Value: "A"}, ok: true},
+
+		// the prefix itself is ignored
+		{action: "create", key: "/fleet/rollout", ok: false},
+	}
+
+	for i, tt := range tests {
+		res := &etcd.Result{
+			Action:   tt.action,
+			Node:     &etcd.Node{Key: tt.key, Value: tt.value},
+			PrevNode: tt.prev,
+		}
+		ev, ok := parse(res, "/fleet")
+		if ok != tt.ok {
+			t.Errorf("case %d: expected ok=%t, got %t", i, tt.ok, ok)
+			continue
+		}
+		if ok && ev != RolloutChangeEvent {
+			t.Errorf("case %d: expected %v, got %v", i, RolloutChangeEvent, ev)
+		}
+	}
+}
+
+func TestFilterEtcdResignedEvents(t *testing.T) {
+	tests := []struct {
+		action string
+		key    string
+		ok     bool
+	}{
+		{action: "set", key: "/fleet/resigned/engine-leader", ok: true},
+		{action: "create", key: "/fleet/resigned/engine-leader-1", ok: true},
+
+		// the expiry of the record is ignored
+		{action: "expire", key: "/fleet/resigned/engine-leader", ok: false},
+		{action: "delete", key: "/fleet/resigned/engine-leader", ok: false},
+	}
+
+	for i, tt := range tests {
+		res := &etcd.Result{
+			Action: tt.action,
+			Node:   &etcd.Node{Key: tt.key, Value: "XXX"},
+		}
+		ev, ok := parse(res, "/fleet")
+		if ok != tt.ok {
+			t.Errorf("case %d: expected ok=%t, got %t", i, tt.ok, ok)
+			continue
+		}
+		if ok && ev != EngineResignedEvent {
+			t.Errorf("case %d: expected %v, got %v", i, EngineResignedEvent, ev)
+		}
+	}
+}
@@ -24,14 +24,26 @@ import (
 
 	"github.com/coreos/fleet/job"
 	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/sign"
 	"github.com/coreos/fleet/unit"
 )
 
 func NewFakeRegistry() *FakeRegistry {
 	return &FakeRegistry{
 		machines:      []machine.MachineState{},
+		sched:         map[string]machine.Schedulability{},
+		meta:          map[string]map[string]string{},
 		jobStates:     map[string]map[string]*unit.UnitState{},
 		jobs:          map[string]job.Job{},
+		rollouts:      map[string]job.Rollout{},
+		completions:   map[string]job.Completion{},
+		runs:          map[string]job.RunHistory{},
+		decisions:     map[string][]job.Decision{},
+		unschedulable: map[string]job.Unschedulable{},
+		engines:       map[string]machine.EngineStatus{},
+		history:       map[string][]job.Revision{},
+		signatures:    map[string][]sign.Signature{},
+		instances:     map[string]job.InstanceSchedule{},
 		daemonVersion: nil,
 	}
 }
@@ -44,8 +56,22 @@ type FakeRegistry struct {
 	sync.RWMutex
 
 	machines      []machine.MachineState
+	sched         map[string]machine.Schedulability
+	meta          map[string]map[string]string
 	jobStates     map[string]map[string]*unit.UnitState
 	jobs          map[string]job.Job
+	rollouts      map[string]job.Rollout
+	completions   map[string]job.Completion
+	runs          map[string]job.RunHistory
+	decisions     map[string][]job.Decision
+	unschedulable map[string]job.Unschedulable
+	engines       map[string]machine.EngineStatus
+	signatures    map[string][]sign.Signature
+	instances     map[string]job.InstanceSchedule
+	minVersion    *semver.Version
+	audit         []job.AuditEntry
+	history       map[string][]job.Revision
+	historyLimit  int
 	daemonVersion *semver.Version
 }
 
@@ -85,7 +111,89 @@ func (f *FakeRegistry) Machines() ([]machine.MachineState, error) {
 	f.RLock()
 	defer f.RUnlock()
 
-	return f.machines, nil
+	if len(f.sched) == 0 && len(f.meta) == 0 {
+		return f.machines, nil
+	}
+
+	machines := make([]machine.MachineState, len(f.machines))
+	for i, m := range f.machines {
+		m = m.WithMetadata(f.meta[m.ID])
+		m.Schedulability = f.sched[m.ID]
+		machines[i] = m
+	}
+	return machines, nil
+}
+
+func (f *FakeRegistry) SetMachineMetadata(machID, key, value string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if value == "" {
+		delete(f.meta[machID], key)
+		return nil
+	}
+	if f.meta == nil {
+		f.meta = make(map[string]map[string]string)
+	}
+	if f.meta[machID] == nil {
+		f.meta[machID] = make(map[string]string)
+	}
+	f.meta[machID][key] = value
+	return nil
+}
+
+func (f *FakeRegistry) MachineMetadata(machID string) (map[string]string, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	md := make(map[string]string, len(f.meta[machID]))
+	for k, v := range f.meta[machID] {
+		md[k] = v
+	}
+	return md, nil
+}
+
+func (f *FakeRegistry) SetMachineSchedulability(machID string, s machine.Schedulability) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if s == machine.Schedulable {
+		delete(f.sched, machID)
+		return nil
+	}
+	if f.sched == nil {
+		f.sched = make(map[string]machine.Schedulability)
+	}
+	f.sched[machID] = s
+	return nil
+}
+
+func (f *FakeRegistry) SetMachineState(ms machine.MachineState, ttl time.Duration) (uint64, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	machines := make([]machine.MachineState, 0, len(f.machines)+1)
+	for _, m := range f.machines {
+		if m.ID != ms.ID {
+			machines = append(machines, m)
+		}
+	}
+	f.machines = append(machines, ms)
+	return 0, nil
+}
+
+func (f *FakeRegistry) RemoveMachineState(machID string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	machines := make([]machine.MachineState, 0, len(f.machines))
+	for _, m := range f.machines {
+		if m.ID != machID {
+			machines = append(machines, m)
+		}
+	}
+	f.machines = machines
+	return nil
 }
 
 func (f *FakeRegistry) Units() ([]job.Unit, error) {
@@ -185,14 +293,89 @@ func (f *FakeRegistry) CreateUnit(u *job.Unit) error {
 	}
 
 	f.jobs[u.Name] = j
-	return f.unsafeSetUnitTargetState(u.Name, u.TargetState)
+	if err := f.unsafeSetUnitTargetState(u.Name, u.TargetState); err != nil {
+		return err
+	}
+	f.recordRevision(u.Name, job.RevisionEventCreate, u.TargetState, u.Unit)
+	return nil
 }
 
 func (f *FakeRegistry) DestroyUnit(name string) error {
 	f.Lock()
 	defer f.Unlock()
 
+	if j, ok := f.jobs[name]; ok {
+		f.recordRevision(name, job.RevisionEventDestroy, "", j.Unit)
+	}
 	delete(f.jobs, name)
+	delete(f.completions, name)
+	delete(f.runs, name)
+	delete(f.decisions, name)
+	delete(f.unschedulable, name)
+	delete(f.signatures, name)
+	delete(f.instances, name)
+	return nil
+}
+
+func (f *FakeRegistry) UnitHistoryLimit() (int, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	return f.historyLimit, nil
+}
+
+func (f *FakeRegistry) SetUnitHistoryLimit(limit int) error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.historyLimit = limit
+	return nil
+}
+
+func (f *FakeRegistry) UnitHistory(name string) ([]job.Revision, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	return append([]job.Revision(nil), f.history[name]...), nil
+}
+
+func (f *FakeRegistry) recordRevision(name string, ev job.RevisionEvent, state job.JobState, uf unit.UnitFile) {
+	if f.historyLimit <= 0 {
+		return
+	}
+
+	revs := f.history[name]
+	number := 1
+	if len(revs) > 0 {
+		number = revs[len(revs)-1].Number + 1
+	}
+	revs = append(revs, job.Revision{
+		Number:      number,
+		Time:        time.Now(),
+		Event:       ev,
+		TargetState: state,
+		Unit:        uf,
+	})
+	if len(revs) > f.historyLimit {
+		revs = revs[len(revs)-f.historyLimit:]
+	}
+	f.history[name] = revs
+}
+
+func (f *FakeRegistry) UnscheduleUnit(name, machID string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	j, ok := f.jobs[name]
+	if !ok || j.TargetMachineID == "" {
+		return nil
+	}
+	if j.TargetMachineID != machID {
+		return ScheduleConflictError{Name: name, MachineID: machID}
+	}
+
+	j.TargetMachineID = ""
+	f.jobs[name] = j
 	return nil
 }
 
@@ -200,7 +383,11 @@ func (f *FakeRegistry) SetUnitTargetState(name string, target job.JobState) erro
 	f.Lock()
 	defer f.Unlock()
 
-	return f.unsafeSetUnitTargetState(name, target)
+	if err := f.unsafeSetUnitTargetState(name, target); err != nil {
+		return err
+	}
+	f.recordRevision(name, job.RevisionEventSetTargetState, target, f.jobs[name].Unit)
+	return nil
 }
 
 func (f *FakeRegistry) unsafeSetUnitTargetState(name string, target job.JobState) error {
@@ -225,6 +412,9 @@ func (f *FakeRegistry) ScheduleUnit(name string, machID string) error {
 	if !ok {
 		return errors.New("unit does not exist")
 	}
+	if j.TargetMachineID != "" {
+		return ScheduleConflictError{Name: name, MachineID: machID, Schedule: true}
+	}
 
 	j.TargetMachineID = machID
 	f.jobs[name] = j
@@ -232,6 +422,20 @@ func (f *FakeRegistry) ScheduleUnit(name string, machID string) error {
 	return nil
 }
 
+func (f *FakeRegistry) RescheduleUnit(name, from, to string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	j, ok := f.jobs[name]
+	if !ok || j.TargetMachineID != from {
+		return ScheduleConflictError{Name: name, MachineID: from}
+	}
+
+	j.TargetMachineID = to
+	f.jobs[name] = j
+	return nil
+}
+
 func (f *FakeRegistry) SaveUnitState(jobName string, unitState *unit.UnitState, ttl time.Duration) {
 	f.Lock()
 	defer f.Unlock()
@@ -247,6 +451,12 @@ func (f *FakeRegistry) RemoveUnitState(jobName string) error {
 	return nil
 }
 
+func (f *FakeRegistry) UnitState(name, machID string) (*unit.UnitState, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.jobStates[name][machID], nil
+}
+
 func (f *FakeRegistry) UnitStates() ([]*unit.UnitState, error) {
 	f.Lock()
 	defer f.Unlock()
@@ -279,6 +489,321 @@ func (f *FakeRegistry) UnitHeartbeat(name, machID string, ttl time.Duration) err
 
 func (f *FakeRegistry) ClearUnitHeartbeat(string) {}
 
+func (f *FakeRegistry) UpdateUnitFile(name string, uf unit.UnitFile) error {
+	f.Lock()
+	defer f.Unlock()
+
+	j, ok := f.jobs[name]
+	if !ok {
+		return errors.New("job does not exist")
+	}
+
+	j.Unit = uf
+	f.jobs[name] = j
+	return nil
+}
+
+// Watch emits no Changes, closing the channel once stop is closed
+func (f *FakeRegistry) Watch(keyspace string, index uint64, stop chan struct{}) <-chan Change {
+	changes := make(chan Change)
+	go func() {
+		<-stop
+		close(changes)
+	}()
+	return changes
+}
+
+func (f *FakeRegistry) CreateRollout(ro *job.Rollout) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if existing, ok := f.rollouts[ro.Template]; ok && !existing.Done() {
+		return errors.New("rollout already in progress")
+	}
+
+	created := *ro
+	created.Control = ""
+	f.rollouts[ro.Template] = created
+	return nil
+}
+
+func (f *FakeRegistry) Rollout(template string) (*job.Rollout, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	ro, ok := f.rollouts[template]
+	if !ok {
+		return nil, nil
+	}
+	return &ro, nil
+}
+
+func (f *FakeRegistry) Rollouts() ([]job.Rollout, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	var sorted sort.StringSlice
+	for name := range f.rollouts {
+		sorted = append(sorted, name)
+	}
+	sorted.Sort()
+
+	rollouts := make([]job.Rollout, 0, len(sorted))
+	for _, name := range sorted {
+		rollouts = append(rollouts, f.rollouts[name])
+	}
+	return rollouts, nil
+}
+
+func (f *FakeRegistry) SaveRollout(ro *job.Rollout) error {
+	f.Lock()
+	defer f.Unlock()
+
+	saved := *ro
+	saved.Control = f.rollouts[ro.Template].Control
+	f.rollouts[ro.Template] = saved
+	return nil
+}
+
+func (f *FakeRegistry) SetRolloutControl(template string, c job.RolloutControl) error {
+	f.Lock()
+	defer f.Unlock()
+
+	ro, ok := f.rollouts[template]
+	if !ok {
+		return errors.New("rollout does not exist")
+	}
+	ro.Control = c
+	f.rollouts[template] = ro
+	return nil
+}
+
+func (f *FakeRegistry) Completion(name string) (*job.Completion, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	c, ok := f.completions[name]
+	if !ok {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+func (f *FakeRegistry) Completions() (map[string]*job.Completion, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	comps := make(map[string]*job.Completion, len(f.completions))
+	for name, c := range f.completions {
+		c := c
+		comps[name] = &c
+	}
+	return comps, nil
+}
+
+func (f *FakeRegistry) SaveCompletion(name string, c *job.Completion) error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.completions[name] = *c
+	return nil
+}
+
+func (f *FakeRegistry) RunHistory(name string) (*job.RunHistory, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	h, ok := f.runs[name]
+	if !ok {
+		return nil, nil
+	}
+	h.Runs = append([]job.Run(nil), h.Runs...)
+	return &h, nil
+}
+
+func (f *FakeRegistry) RunHistories() (map[string]*job.RunHistory, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	hists := make(map[string]*job.RunHistory, len(f.runs))
+	for name, h := range f.runs {
+		h := h
+		h.Runs = append([]job.Run(nil), h.Runs...)
+		hists[name] = &h
+	}
+	return hists, nil
+}
+
+func (f *FakeRegistry) SaveRunHistory(name string, h *job.RunHistory) error {
+	f.Lock()
+	defer f.Unlock()
+
+	saved := *h
+	saved.Runs = append([]job.Run(nil), h.Runs...)
+	f.runs[name] = saved
+	return nil
+}
+
+func (f *FakeRegistry) Decisions(name string) ([]job.Decision, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	return append([]job.Decision(nil), f.decisions[name]...), nil
+}
+
+func (f *FakeRegistry) RecordDecision(name string, d job.Decision, limit int) error {
+	f.Lock()
+	defer f.Unlock()
+
+	decs := append(f.decisions[name], d)
+	if len(decs) > limit {
+		decs = decs[len(decs)-limit:]
+	}
+	f.decisions[name] = decs
+	return nil
+}
+
+func (f *FakeRegistry) AuditLog() ([]job.AuditEntry, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	return append([]job.AuditEntry(nil), f.audit...), nil
+}
+
+func (f *FakeRegistry) RecordAudit(e job.AuditEntry) error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.audit = append(f.audit, e)
+	return nil
+}
+
+func (f *FakeRegistry) Unschedulable(name string) (*job.Unschedulable, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	u, ok := f.unschedulable[name]
+	if !ok {
+		return nil, nil
+	}
+	return &u, nil
+}
+
+func (f *FakeRegistry) SetUnschedulable(name string, u *job.Unschedulable) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if u == nil {
+		delete(f.unschedulable, name)
+	} else {
+		f.unschedulable[name] = *u
+	}
+	return nil
+}
+
+func (f *FakeRegistry) EngineStatuses() ([]machine.EngineStatus, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	var ids []string
+	for id := range f.engines {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var statuses []machine.EngineStatus
+	for _, id := range ids {
+		statuses = append(statuses, f.engines[id])
+	}
+	return statuses, nil
+}
+
+func (f *FakeRegistry) SetEngineStatus(st machine.EngineStatus, ttl time.Duration) error {
+	f.Lock()
+	defer f.Unlock()
+
+	st.Shards = append([]int(nil), st.Shards...)
+	f.engines[st.MachineID] = st
+	return nil
+}
+
+func (f *FakeRegistry) RemoveEngineStatus(machID string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	delete(f.engines, machID)
+	return nil
+}
+
+func (f *FakeRegistry) SetUnitSignatures(name string, sigs []sign.Signature) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.signatures == nil {
+		f.signatures = make(map[string][]sign.Signature)
+	}
+	f.signatures[name] = append([]sign.Signature(nil), sigs...)
+	return nil
+}
+
+func (f *FakeRegistry) UnitSignatures(name string) ([]sign.Signature, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	return append([]sign.Signature(nil), f.signatures[name]...), nil
+}
+
+func (f *FakeRegistry) ScheduleInstances(name string, s job.InstanceSchedule) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.instances == nil {
+		f.instances = make(map[string]job.InstanceSchedule)
+	}
+	if s.Count() == 0 {
+		delete(f.instances, name)
+		return nil
+	}
+	cp := make(job.InstanceSchedule, len(s))
+	for machID, nums := range s {
+		if len(nums) > 0 {
+			cp[machID] = append([]int(nil), nums...)
+		}
+	}
+	f.instances[name] = cp
+	return nil
+}
+
+func (f *FakeRegistry) UnitInstances(name string) (job.InstanceSchedule, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	s, ok := f.instances[name]
+	if !ok {
+		return nil, nil
+	}
+	cp := make(job.InstanceSchedule, len(s))
+	for machID, nums := range s {
+		cp[machID] = append([]int(nil), nums...)
+	}
+	return cp, nil
+}
+
+func (f *FakeRegistry) MinimumVersion() (*semver.Version, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	return f.minVersion, nil
+}
+
+func (f *FakeRegistry) SetMinimumVersion(v *semver.Version) error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.minVersion = v
+	return nil
+}
+
 func NewFakeClusterRegistry(dVersion *semver.Version, eVersion int) *FakeClusterRegistry {
 	return &FakeClusterRegistry{
 		dVersion: dVersion,
@@ -287,6 +812,7 @@ func NewFakeClusterRegistry(dVersion *semver.Version, eVersion int) *FakeCluster
 }
 
 type FakeClusterRegistry struct {
+	sync.Mutex
 	dVersion *semver.Version
 	eVersion int
 }
@@ -296,10 +822,16 @@ func (fc *FakeClusterRegistry) LatestDaemonVersion() (*semver.Version, error) {
 }
 
 func (fc *FakeClusterRegistry) EngineVersion() (int, error) {
+	fc.Lock()
+	defer fc.Unlock()
+
 	return fc.eVersion, nil
 }
 
 func (fc *FakeClusterRegistry) UpdateEngineVersion(from, to int) error {
+	fc.Lock()
+	defer fc.Unlock()
+
 	if fc.eVersion != from {
 		return errors.New("version mismatch")
 	}
@@ -372,6 +904,20 @@ func NewFakeLeaseRegistry() *FakeLeaseRegistry {
 
 type FakeLeaseRegistry struct {
 	leaseMap map[string]Lease
+	resigned map[string]string
+}
+
+func (fl *FakeLeaseRegistry) MarkResigned(name, machID string) error {
+	if fl.resigned == nil {
+		fl.resigned = make(map[string]string)
+	}
+	fl.resigned[name] = machID
+	return nil
+}
+
+// Resigned returns the Machine that last resigned the named Lease
+func (fl *FakeLeaseRegistry) Resigned(name string) string {
+	return fl.resigned[name]
 }
 
 func (fl *FakeLeaseRegistry) GetLease(name string) (Lease, error) {
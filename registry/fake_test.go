@@ -64,6 +64,11 @@ func TestFakeRegistryUnitLifecycle(t *testing.T) {
 		t.Fatalf("Unit should be scheduled to XXX, got %v", su.TargetMachineID)
 	}
 
+	err = reg.RecordDecision("u1.service", job.Decision{Type: job.DecisionTypeSchedule, MachineID: "XXX"}, 5)
+	if err != nil {
+		t.Fatalf("Received error while calling RecordDecision: %v", err)
+	}
+
 	err = reg.DestroyUnit("u1.service")
 	if err != nil {
 		t.Fatalf("Received error while calling DestroyUnit: %v", err)
@@ -76,4 +81,12 @@ func TestFakeRegistryUnitLifecycle(t *testing.T) {
 	if !reflect.DeepEqual([]job.Unit{}, units) {
 		t.Fatalf("Expected no units, got %v", units)
 	}
+
+	decs, err := reg.Decisions("u1.service")
+	if err != nil {
+		t.Fatalf("Received error while calling Decisions: %v", err)
+	}
+	if len(decs) != 0 {
+		t.Fatalf("Expected no decisions, got %v", decs)
+	}
 }
@@ -0,0 +1,710 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-semver/semver"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/sign"
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	// ClusterMetadataKey is the Machine metadata key giving the cluster
+	// of a Machine in a FederatedRegistry, and by which Units are pinned
+	// to a cluster
+	ClusterMetadataKey = "cluster"
+)
+
+// FederatedRegistry presents the Registries of several clusters, such as
+// one per datacenter, as a single Registry:
+//   - Machines, Units, schedules, UnitStates, Rollouts and engine statuses
+//     are listed from every cluster. Machines are given the name of their
+//     cluster as the value of ClusterMetadataKey, unless their metadata
+//     holds one already.
+//   - a new Unit or Rollout is created in the cluster its unit file pins
+//     it to with MachineMetadata=cluster=<name>, or else in the first
+//     cluster added. The Machines of that cluster must carry the same
+//     metadata for the Unit to be scheduled.
+//   - anything else concerning a Unit, Rollout or Machine is directed to
+//     the cluster holding it.
+type FederatedRegistry struct {
+	mu       sync.RWMutex
+	clusters []federatedCluster
+}
+
+type federatedCluster struct {
+	name string
+	reg  Registry
+}
+
+func NewFederatedRegistry() *FederatedRegistry {
+	return &FederatedRegistry{}
+}
+
+// AddCluster adds the Registry of the named cluster to the federation.
+// The first cluster added is the default one.
+func (f *FederatedRegistry) AddCluster(name string, reg Registry) error {
+	if name == "" {
+		return errors.New("cluster name must not be empty")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.clusters {
+		if c.name == name {
+			return fmt.Errorf("cluster %q already federated", name)
+		}
+	}
+	f.clusters = append(f.clusters, federatedCluster{name: name, reg: reg})
+	return nil
+}
+
+// Clusters lists the names of the federated clusters in the order they
+// were added
+func (f *FederatedRegistry) Clusters() []string {
+	var names []string
+	for _, c := range f.all() {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+func (f *FederatedRegistry) all() []federatedCluster {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]federatedCluster(nil), f.clusters...)
+}
+
+func (f *FederatedRegistry) defaultCluster() (Registry, error) {
+	all := f.all()
+	if len(all) == 0 {
+		return nil, errors.New("no clusters federated")
+	}
+	return all[0].reg, nil
+}
+
+// unitCluster returns the Registry of the cluster holding the named Unit,
+// or of the default cluster if none does
+func (f *FederatedRegistry) unitCluster(name string) (Registry, error) {
+	for _, c := range f.all() {
+		u, err := c.reg.Unit(name)
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		if u != nil {
+			return c.reg, nil
+		}
+	}
+	return f.defaultCluster()
+}
+
+// rolloutCluster returns the Registry of the cluster holding the Rollout
+// of the given template, or of the default cluster if none does
+func (f *FederatedRegistry) rolloutCluster(template string) (Registry, error) {
+	for _, c := range f.all() {
+		ro, err := c.reg.Rollout(template)
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		if ro != nil {
+			return c.reg, nil
+		}
+	}
+	return f.defaultCluster()
+}
+
+// machineCluster returns the Registry of the cluster the identified
+// Machine belongs to, or of the default cluster if it is unknown
+func (f *FederatedRegistry) machineCluster(machID string) (Registry, error) {
+	for _, c := range f.all() {
+		machines, err := c.reg.Machines()
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		for _, m := range machines {
+			if m.ID == machID {
+				return c.reg, nil
+			}
+		}
+	}
+	return f.defaultCluster()
+}
+
+// pinnedCluster returns the Registry of the cluster the given Unit is
+// pinned to by its metadata requirements, or of the default cluster if it
+// is not pinned
+func (f *FederatedRegistry) pinnedCluster(u *job.Unit) (Registry, error) {
+	values, ok := u.RequiredTargetMetadata()[ClusterMetadataKey]
+	if !ok || values.Length() == 0 {
+		return f.defaultCluster()
+	}
+	if values.Length() > 1 {
+		return nil, fmt.Errorf("unit %s requires more than one cluster: %v", u.Name, values.Values())
+	}
+
+	name := values.Values()[0]
+	for _, c := range f.all() {
+		if c.name == name {
+			return c.reg, nil
+		}
+	}
+	return nil, fmt.Errorf("unit %s requires unknown cluster %q", u.Name, name)
+}
+
+func clusterError(name string, err error) error {
+	return fmt.Errorf("cluster %s: %v", name, err)
+}
+
+func (f *FederatedRegistry) Machines() ([]machine.MachineState, error) {
+	var all []machine.MachineState
+	for _, c := range f.all() {
+		machines, err := c.reg.Machines()
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		for _, m := range machines {
+			if _, ok := m.Metadata[ClusterMetadataKey]; !ok {
+				md := make(map[string]string, len(m.Metadata)+1)
+				for k, v := range m.Metadata {
+					md[k] = v
+				}
+				md[ClusterMetadataKey] = c.name
+				m.Metadata = md
+			}
+			all = append(all, m)
+		}
+	}
+	return all, nil
+}
+
+func (f *FederatedRegistry) SetMachineState(ms machine.MachineState, ttl time.Duration) (uint64, error) {
+	reg, err := f.machineCluster(ms.ID)
+	if err != nil {
+		return 0, err
+	}
+	return reg.SetMachineState(ms, ttl)
+}
+
+func (f *FederatedRegistry) SetMachineSchedulability(machID string, s machine.Schedulability) error {
+	reg, err := f.machineCluster(machID)
+	if err != nil {
+		return err
+	}
+	return reg.SetMachineSchedulability(machID, s)
+}
+
+func (f *FederatedRegistry) SetMachineMetadata(machID, key, value string) error {
+	reg, err := f.machineCluster(machID)
+	if err != nil {
+		return err
+	}
+	return reg.SetMachineMetadata(machID, key, value)
+}
+
+func (f *FederatedRegistry) MachineMetadata(machID string) (map[string]string, error) {
+	reg, err := f.machineCluster(machID)
+	if err != nil {
+		return nil, err
+	}
+	return reg.MachineMetadata(machID)
+}
+
+func (f *FederatedRegistry) RemoveMachineState(machID string) error {
+	reg, err := f.machineCluster(machID)
+	if err != nil {
+		return err
+	}
+	return reg.RemoveMachineState(machID)
+}
+
+// CreateUnit creates the Unit in the cluster it is pinned to, failing if
+// another cluster already holds a Unit of the same name
+func (f *FederatedRegistry) CreateUnit(u *job.Unit) error {
+	reg, err := f.pinnedCluster(u)
+	if err != nil {
+		return err
+	}
+	for _, c := range f.all() {
+		if c.reg == reg {
+			continue
+		}
+		existing, err := c.reg.Unit(u.Name)
+		if err != nil {
+			return clusterError(c.name, err)
+		}
+		if existing != nil {
+			return fmt.Errorf("unit %s already exists in cluster %s", u.Name, c.name)
+		}
+	}
+	return reg.CreateUnit(u)
+}
+
+func (f *FederatedRegistry) DestroyUnit(name string) error {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return err
+	}
+	return reg.DestroyUnit(name)
+}
+
+func (f *FederatedRegistry) UpdateUnitFile(name string, uf unit.UnitFile) error {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return err
+	}
+	return reg.UpdateUnitFile(name, uf)
+}
+
+func (f *FederatedRegistry) SetUnitTargetState(name string, state job.JobState) error {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return err
+	}
+	return reg.SetUnitTargetState(name, state)
+}
+
+func (f *FederatedRegistry) ScheduleUnit(name, machID string) error {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return err
+	}
+	return reg.ScheduleUnit(name, machID)
+}
+
+func (f *FederatedRegistry) RescheduleUnit(name, from, to string) error {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return err
+	}
+	return reg.RescheduleUnit(name, from, to)
+}
+
+func (f *FederatedRegistry) UnscheduleUnit(name, machID string) error {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return err
+	}
+	return reg.UnscheduleUnit(name, machID)
+}
+
+func (f *FederatedRegistry) UnitHeartbeat(name, machID string, ttl time.Duration) error {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return err
+	}
+	return reg.UnitHeartbeat(name, machID, ttl)
+}
+
+func (f *FederatedRegistry) ClearUnitHeartbeat(name string) {
+	if reg, err := f.unitCluster(name); err == nil {
+		reg.ClearUnitHeartbeat(name)
+	}
+}
+
+func (f *FederatedRegistry) SaveUnitState(jobName string, us *unit.UnitState, ttl time.Duration) {
+	if reg, err := f.unitCluster(jobName); err == nil {
+		reg.SaveUnitState(jobName, us, ttl)
+	}
+}
+
+func (f *FederatedRegistry) RemoveUnitState(jobName string) error {
+	reg, err := f.unitCluster(jobName)
+	if err != nil {
+		return err
+	}
+	return reg.RemoveUnitState(jobName)
+}
+
+// Watch emits the Changes of every cluster, closing the channel once
+// stop is closed and the watch of every cluster has ended
+func (f *FederatedRegistry) Watch(keyspace string, index uint64, stop chan struct{}) <-chan Change {
+	out := make(chan Change)
+	var wg sync.WaitGroup
+	for _, c := range f.all() {
+		wg.Add(1)
+		go func(changes <-chan Change) {
+			defer wg.Done()
+			for ch := range changes {
+				select {
+				case out <- ch:
+				case <-stop:
+				}
+			}
+		}(c.reg.Watch(keyspace, index, stop))
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+func (f *FederatedRegistry) Schedule() ([]job.ScheduledUnit, error) {
+	var all []job.ScheduledUnit
+	for _, c := range f.all() {
+		sUnits, err := c.reg.Schedule()
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		all = append(all, sUnits...)
+	}
+	return all, nil
+}
+
+func (f *FederatedRegistry) ScheduledUnit(name string) (*job.ScheduledUnit, error) {
+	for _, c := range f.all() {
+		su, err := c.reg.ScheduledUnit(name)
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		if su != nil {
+			return su, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *FederatedRegistry) Unit(name string) (*job.Unit, error) {
+	for _, c := range f.all() {
+		u, err := c.reg.Unit(name)
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		if u != nil {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *FederatedRegistry) Units() ([]job.Unit, error) {
+	var all []job.Unit
+	for _, c := range f.all() {
+		units, err := c.reg.Units()
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		all = append(all, units...)
+	}
+	sort.Stable(unitsByName(all))
+	return all, nil
+}
+
+// unitsByName sorts Units by name, keeping Units of the same name in
+// different clusters in the order of the clusters
+type unitsByName []job.Unit
+
+func (us unitsByName) Len() int           { return len(us) }
+func (us unitsByName) Less(i, j int) bool { return us[i].Name < us[j].Name }
+func (us unitsByName) Swap(i, j int)      { us[i], us[j] = us[j], us[i] }
+
+func (f *FederatedRegistry) UnitStates() ([]*unit.UnitState, error) {
+	var all []*unit.UnitState
+	for _, c := range f.all() {
+		states, err := c.reg.UnitStates()
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		all = append(all, states...)
+	}
+	return all, nil
+}
+
+// UnitState returns the state of the Unit published by the Machine from
+// whichever cluster the Machine belongs to
+func (f *FederatedRegistry) UnitState(name, machID string) (*unit.UnitState, error) {
+	for _, c := range f.all() {
+		us, err := c.reg.UnitState(name, machID)
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		if us != nil {
+			return us, nil
+		}
+	}
+	return nil, nil
+}
+
+// CreateRollout creates the Rollout in the cluster its unit file pins it
+// to
+func (f *FederatedRegistry) CreateRollout(ro *job.Rollout) error {
+	reg, err := f.pinnedCluster(&job.Unit{Name: ro.Template, Unit: ro.Unit})
+	if err != nil {
+		return err
+	}
+	return reg.CreateRollout(ro)
+}
+
+func (f *FederatedRegistry) Rollout(template string) (*job.Rollout, error) {
+	for _, c := range f.all() {
+		ro, err := c.reg.Rollout(template)
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		if ro != nil {
+			return ro, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *FederatedRegistry) Rollouts() ([]job.Rollout, error) {
+	var all []job.Rollout
+	for _, c := range f.all() {
+		rollouts, err := c.reg.Rollouts()
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		all = append(all, rollouts...)
+	}
+	return all, nil
+}
+
+func (f *FederatedRegistry) SaveRollout(ro *job.Rollout) error {
+	reg, err := f.rolloutCluster(ro.Template)
+	if err != nil {
+		return err
+	}
+	return reg.SaveRollout(ro)
+}
+
+func (f *FederatedRegistry) SetRolloutControl(template string, c job.RolloutControl) error {
+	reg, err := f.rolloutCluster(template)
+	if err != nil {
+		return err
+	}
+	return reg.SetRolloutControl(template, c)
+}
+
+func (f *FederatedRegistry) Completion(name string) (*job.Completion, error) {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return nil, err
+	}
+	return reg.Completion(name)
+}
+
+func (f *FederatedRegistry) Completions() (map[string]*job.Completion, error) {
+	all := make(map[string]*job.Completion)
+	for _, c := range f.all() {
+		comps, err := c.reg.Completions()
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		for name, comp := range comps {
+			if _, ok := all[name]; !ok {
+				all[name] = comp
+			}
+		}
+	}
+	return all, nil
+}
+
+func (f *FederatedRegistry) SaveCompletion(name string, comp *job.Completion) error {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return err
+	}
+	return reg.SaveCompletion(name, comp)
+}
+
+func (f *FederatedRegistry) RunHistory(name string) (*job.RunHistory, error) {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return nil, err
+	}
+	return reg.RunHistory(name)
+}
+
+func (f *FederatedRegistry) RunHistories() (map[string]*job.RunHistory, error) {
+	all := make(map[string]*job.RunHistory)
+	for _, c := range f.all() {
+		hists, err := c.reg.RunHistories()
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		for name, h := range hists {
+			if _, ok := all[name]; !ok {
+				all[name] = h
+			}
+		}
+	}
+	return all, nil
+}
+
+func (f *FederatedRegistry) SaveRunHistory(name string, h *job.RunHistory) error {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return err
+	}
+	return reg.SaveRunHistory(name, h)
+}
+
+func (f *FederatedRegistry) Decisions(name string) ([]job.Decision, error) {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return nil, err
+	}
+	return reg.Decisions(name)
+}
+
+func (f *FederatedRegistry) RecordDecision(name string, d job.Decision, limit int) error {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return err
+	}
+	return reg.RecordDecision(name, d, limit)
+}
+
+// UnitHistory returns the Revisions of the named Job kept by the first
+// cluster to have any, as the Job may since have been destroyed
+func (f *FederatedRegistry) UnitHistory(name string) ([]job.Revision, error) {
+	for _, c := range f.all() {
+		revs, err := c.reg.UnitHistory(name)
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		if len(revs) > 0 {
+			return revs, nil
+		}
+	}
+	return nil, nil
+}
+
+// UnitHistoryLimit returns the history limit of the default cluster, as
+// SetUnitHistoryLimit sets the same one in every cluster
+func (f *FederatedRegistry) UnitHistoryLimit() (int, error) {
+	reg, err := f.defaultCluster()
+	if err != nil {
+		return 0, err
+	}
+	return reg.UnitHistoryLimit()
+}
+
+func (f *FederatedRegistry) SetUnitHistoryLimit(limit int) error {
+	for _, c := range f.all() {
+		if err := c.reg.SetUnitHistoryLimit(limit); err != nil {
+			return clusterError(c.name, err)
+		}
+	}
+	return nil
+}
+
+func (f *FederatedRegistry) Unschedulable(name string) (*job.Unschedulable, error) {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return nil, err
+	}
+	return reg.Unschedulable(name)
+}
+
+func (f *FederatedRegistry) SetUnschedulable(name string, u *job.Unschedulable) error {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return err
+	}
+	return reg.SetUnschedulable(name, u)
+}
+
+func (f *FederatedRegistry) EngineStatuses() ([]machine.EngineStatus, error) {
+	var all []machine.EngineStatus
+	for _, c := range f.all() {
+		statuses, err := c.reg.EngineStatuses()
+		if err != nil {
+			return nil, clusterError(c.name, err)
+		}
+		all = append(all, statuses...)
+	}
+	return all, nil
+}
+
+func (f *FederatedRegistry) SetEngineStatus(st machine.EngineStatus, ttl time.Duration) error {
+	reg, err := f.machineCluster(st.MachineID)
+	if err != nil {
+		return err
+	}
+	return reg.SetEngineStatus(st, ttl)
+}
+
+func (f *FederatedRegistry) RemoveEngineStatus(machID string) error {
+	reg, err := f.machineCluster(machID)
+	if err != nil {
+		return err
+	}
+	return reg.RemoveEngineStatus(machID)
+}
+
+// SetUnitSignatures records the Signatures in every cluster, as they may
+// be recorded before the Unit they sign is created in any of them
+func (f *FederatedRegistry) SetUnitSignatures(name string, sigs []sign.Signature) error {
+	for _, c := range f.all() {
+		if err := c.reg.SetUnitSignatures(name, sigs); err != nil {
+			return clusterError(c.name, err)
+		}
+	}
+	return nil
+}
+
+func (f *FederatedRegistry) UnitSignatures(name string) ([]sign.Signature, error) {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return nil, err
+	}
+	return reg.UnitSignatures(name)
+}
+
+// MinimumVersion returns the minimum version of the default cluster, as
+// SetMinimumVersion sets the same one in every cluster
+func (f *FederatedRegistry) MinimumVersion() (*semver.Version, error) {
+	reg, err := f.defaultCluster()
+	if err != nil {
+		return nil, err
+	}
+	return reg.MinimumVersion()
+}
+
+func (f *FederatedRegistry) SetMinimumVersion(v *semver.Version) error {
+	for _, c := range f.all() {
+		if err := c.reg.SetMinimumVersion(v); err != nil {
+			return clusterError(c.name, err)
+		}
+	}
+	return nil
+}
+
+func (f *FederatedRegistry) ScheduleInstances(name string, s job.InstanceSchedule) error {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return err
+	}
+	return reg.ScheduleInstances(name, s)
+}
+
+func (f *FederatedRegistry) UnitInstances(name string) (job.InstanceSchedule, error) {
+	reg, err := f.unitCluster(name)
+	if err != nil {
+		return nil, err
+	}
+	return reg.UnitInstances(name)
+}
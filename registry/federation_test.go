@@ -0,0 +1,135 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/unit"
+)
+
+func newFederation(t *testing.T) (*FederatedRegistry, *FakeRegistry, *FakeRegistry) {
+	dc1, dc2 := NewFakeRegistry(), NewFakeRegistry()
+	dc1.SetMachines([]machine.MachineState{{ID: "XXX"}})
+	dc2.SetMachines([]machine.MachineState{{ID: "YYY", Metadata: map[string]string{"cluster": "west"}}})
+
+	f := NewFederatedRegistry()
+	if err := f.AddCluster("dc1", dc1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := f.AddCluster("dc2", dc2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return f, dc1, dc2
+}
+
+func newPinnedUnit(t *testing.T, name, contents string) *job.Unit {
+	uf, err := unit.NewUnitFile(contents)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return &job.Unit{Name: name, Unit: *uf}
+}
+
+func TestFederatedRegistryMachines(t *testing.T) {
+	f, _, _ := newFederation(t)
+
+	machines, err := f.Machines()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Machines are given their cluster, unless they name their own
+	want := []machine.MachineState{
+		{ID: "XXX", Metadata: map[string]string{"cluster": "dc1"}},
+		{ID: "YYY", Metadata: map[string]string{"cluster": "west"}},
+	}
+	if !reflect.DeepEqual(want, machines) {
+		t.Fatalf("expected Machines %#v, got %#v", want, machines)
+	}
+
+	if err := f.AddCluster("dc1", NewFakeRegistry()); err == nil {
+		t.Fatalf("expected error federating a cluster twice")
+	}
+	if want := []string{"dc1", "dc2"}; !reflect.DeepEqual(want, f.Clusters()) {
+		t.Fatalf("expected clusters %v, got %v", want, f.Clusters())
+	}
+}
+
+func TestFederatedRegistryUnits(t *testing.T) {
+	f, dc1, dc2 := newFederation(t)
+
+	// Units are created in the cluster they are pinned to, or the first
+	pinned := newPinnedUnit(t, "pinned.service", "[X-Fleet]\nMachineMetadata=cluster=dc2")
+	if err := f.CreateUnit(pinned); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loose := newPinnedUnit(t, "loose.service", "[Service]\nExecStart=/bin/true")
+	if err := f.CreateUnit(loose); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u, _ := dc2.Unit("pinned.service"); u == nil {
+		t.Fatalf("expected pinned.service in dc2")
+	}
+	if u, _ := dc1.Unit("loose.service"); u == nil {
+		t.Fatalf("expected loose.service in dc1")
+	}
+
+	for _, u := range []*job.Unit{
+		// names are unique across clusters
+		newPinnedUnit(t, "pinned.service", "[Service]\nExecStart=/bin/true"),
+		newPinnedUnit(t, "unknown.service", "[X-Fleet]\nMachineMetadata=cluster=dc3"),
+		newPinnedUnit(t, "both.service", "[X-Fleet]\nMachineMetadata=cluster=dc1\nMachineMetadata=cluster=dc2"),
+	} {
+		if err := f.CreateUnit(u); err == nil {
+			t.Errorf("expected error creating %s", u.Name)
+		}
+	}
+
+	// the Units of all clusters are listed
+	units, err := f.Units()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, u := range units {
+		names = append(names, u.Name)
+	}
+	if want := []string{"loose.service", "pinned.service"}; !reflect.DeepEqual(want, names) {
+		t.Fatalf("expected Units %v, got %v", want, names)
+	}
+
+	// and changed in the cluster holding them
+	if err := f.SetUnitTargetState("pinned.service", job.JobStateLaunched); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := f.ScheduleUnit("pinned.service", "YYY"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if su, _ := dc2.ScheduledUnit("pinned.service"); su == nil || su.TargetMachineID != "YYY" {
+		t.Fatalf("expected pinned.service scheduled to YYY in dc2, got %#v", su)
+	}
+	if su, _ := f.ScheduledUnit("pinned.service"); su == nil || su.TargetMachineID != "YYY" {
+		t.Fatalf("expected pinned.service scheduled to YYY, got %#v", su)
+	}
+	if err := f.DestroyUnit("pinned.service"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u, _ := f.Unit("pinned.service"); u != nil {
+		t.Fatalf("expected pinned.service to be destroyed, got %#v", u)
+	}
+}
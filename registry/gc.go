@@ -0,0 +1,224 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"path"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	// kinds of orphaned state
+	OrphanUnitState     = "unit-state"
+	OrphanUnitFile      = "unit-file"
+	OrphanDecisions     = "decisions"
+	OrphanUnschedulable = "unschedulable"
+)
+
+// Orphan is state left in the Registry that nothing refers to any more,
+// such as the UnitState of a Job that has since been destroyed or a unit
+// file no Job, Rollout or Revision uses. Crashes between the steps of an
+// operation may leave these behind.
+type Orphan struct {
+	// Kind is one of the Orphan* constants, and Name the Job or, for
+	// unit files, the name the unit file is stored under
+	Kind string
+	Name string
+
+	// Key is the etcd key holding the orphaned state, last modified at
+	// Index
+	Key   string
+	Index uint64
+}
+
+// listChildren returns the nodes directly below the given key, read
+// recursively, or none if the key does not exist
+func (r *EtcdRegistry) listChildren(key string) ([]etcd.Node, error) {
+	req := etcd.Get{
+		Key:       key,
+		Recursive: true,
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+	return res.Node.Nodes, nil
+}
+
+// Orphans lists the orphaned state of the Registry. The candidates are
+// read before the Jobs and Rollouts which may refer to them, so that
+// state written just ahead of a new Job, like its unit file, is only
+// listed if the Job was not yet created by the time the Jobs were read.
+func (r *EtcdRegistry) Orphans() ([]Orphan, error) {
+	var candidates []Orphan
+	add := func(kind string, nodes []etcd.Node) {
+		for _, node := range nodes {
+			candidates = append(candidates, Orphan{
+				Kind:  kind,
+				Name:  path.Base(node.Key),
+				Key:   node.Key,
+				Index: node.ModifiedIndex,
+			})
+		}
+	}
+
+	dirs, err := r.listChildren(path.Join(r.keyPrefix, statesPrefix))
+	if err != nil {
+		return nil, err
+	}
+	// the states of each Job are checked one by one, so that each may
+	// be removed unless it has since been updated
+	for _, dir := range dirs {
+		name := path.Base(dir.Key)
+		for _, node := range dir.Nodes {
+			candidates = append(candidates, Orphan{Kind: OrphanUnitState, Name: name, Key: node.Key, Index: node.ModifiedIndex})
+		}
+	}
+
+	for _, c := range []struct {
+		kind string
+		key  string
+	}{
+		{OrphanUnitState, path.Join(r.keyPrefix, statePrefix)},
+		{OrphanUnitFile, path.Join(r.keyPrefix, unitPrefix)},
+		{OrphanDecisions, path.Join(r.keyPrefix, decisionPrefix)},
+		{OrphanUnschedulable, path.Join(r.keyPrefix, unschedulablePrefix)},
+	} {
+		nodes, err := r.listChildren(c.key)
+		if err != nil {
+			return nil, err
+		}
+		add(c.kind, nodes)
+	}
+
+	jobs, err := r.listChildren(path.Join(r.keyPrefix, jobPrefix))
+	if err != nil {
+		return nil, err
+	}
+	rollouts, err := r.listChildren(path.Join(r.keyPrefix, rolloutPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	// Jobs are only considered to exist once their object is stored,
+	// as other keys of a destroyed Job, like its heartbeat, may recreate
+	// its directory
+	names := make(map[string]bool)
+	files := make(map[string]bool)
+	for _, dir := range jobs {
+		name := path.Base(dir.Key)
+		var instances job.InstanceSchedule
+		exists := false
+		for _, node := range dir.Nodes {
+			switch path.Base(node.Key) {
+			case "object":
+				var jm jobModel
+				if err := unmarshal(node.Value, &jm); err != nil {
+					// keep whatever the Job may refer to
+					log.Errorf("Failed parsing Job at key %s, skipping garbage collection: %v", node.Key, err)
+					return nil, nil
+				}
+				exists = true
+				r.markUnitFile(files, jm.UnitHash)
+			case "instances":
+				if err := unmarshal(node.Value, &instances); err != nil {
+					log.Errorf("Failed parsing instances at key %s, skipping garbage collection: %v", node.Key, err)
+					return nil, nil
+				}
+			}
+		}
+		if !exists {
+			continue
+		}
+		names[name] = true
+		// the instances of a template are named by the agents running
+		// them, so have no Job of their own to keep their state
+		for _, nums := range instances {
+			for _, n := range nums {
+				names[job.InstanceName(name, n)] = true
+			}
+		}
+	}
+	for _, node := range rollouts {
+		var rm rolloutModel
+		if err := unmarshal(node.Value, &rm); err != nil {
+			log.Errorf("Failed parsing Rollout at key %s, skipping garbage collection: %v", node.Key, err)
+			return nil, nil
+		}
+		r.markUnitFile(files, rm.UnitHash)
+	}
+
+	// the unit files of past Revisions are kept for Jobs to be restored
+	history, err := r.listChildren(path.Join(r.keyPrefix, historyPrefix))
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range history {
+		var rms []revisionModel
+		if err := unmarshal(node.Value, &rms); err != nil {
+			log.Errorf("Failed parsing history at key %s, skipping garbage collection: %v", node.Key, err)
+			return nil, nil
+		}
+		for _, rm := range rms {
+			r.markUnitFile(files, rm.UnitHash)
+		}
+	}
+
+	var orphans []Orphan
+	for _, o := range candidates {
+		if o.Kind == OrphanUnitFile {
+			// without the unit encryption key, the unit files named
+			// with it cannot be told apart, so are all kept
+			if r.unitNameKey == nil && isKeyedUnitFileName(o.Name) {
+				continue
+			}
+			if !files[o.Name] {
+				orphans = append(orphans, o)
+			}
+		} else if !names[o.Name] {
+			orphans = append(orphans, o)
+		}
+	}
+	return orphans, nil
+}
+
+// RemoveOrphan deletes the key holding the given orphaned state, unless
+// it has been modified since it was listed. Keys already gone are ignored.
+func (r *EtcdRegistry) RemoveOrphan(o Orphan) error {
+	req := etcd.Delete{
+		Key:           o.Key,
+		PreviousIndex: o.Index,
+	}
+	_, err := r.etcd.Do(&req)
+	if isKeyNotFound(err) {
+		err = nil
+	}
+	return err
+}
+
+// markUnitFile records every name the unit file of the given Hash may be
+// stored under as referenced
+func (r *EtcdRegistry) markUnitFile(names map[string]bool, hash unit.Hash) {
+	for _, name := range r.unitFileNames(hash) {
+		names[name] = true
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestOrphans(t *testing.T) {
+	uf, err := unit.NewUnitFile("[Service]\nExecStart=/bin/true")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	used := uf.Hash().String()
+	ro, err := unit.NewUnitFile("[Service]\nExecStart=/bin/false")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rolled := ro.Hash().String()
+	old, err := unit.NewUnitFile("[Service]\nExecStart=/bin/sleep 1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	revised := old.Hash().String()
+
+	job, _ := marshal(jobModel{Name: "foo.service", UnitHash: uf.Hash()})
+	rollout, _ := marshal(rolloutModel{Template: "bar@.service", UnitHash: ro.Hash()})
+	history, _ := marshal([]revisionModel{{Number: 1, UnitHash: old.Hash()}})
+	template, _ := marshal(jobModel{Name: "web@.service", UnitHash: uf.Hash()})
+	instances, _ := marshal(map[string]string{"XXX": "1-2", "YYY": "3"})
+
+	dir := func(key string, nodes ...etcd.Node) etcd.Node {
+		return etcd.Node{Key: key, Nodes: nodes}
+	}
+	leaf := func(key string, idx uint64) etcd.Node {
+		return etcd.Node{Key: key, ModifiedIndex: idx}
+	}
+	res := func(n etcd.Node) *etcd.Result {
+		return &etcd.Result{Node: &n}
+	}
+
+	e := &testEtcdClient{res: []*etcd.Result{
+		res(dir("/fleet/states",
+			dir("/fleet/states/foo.service", leaf("/fleet/states/foo.service/XXX", 2)),
+			dir("/fleet/states/gone.service", leaf("/fleet/states/gone.service/XXX", 3), leaf("/fleet/states/gone.service/YYY", 4)),
+			// the instances a scaled template runs have no Job
+			dir("/fleet/states/web@3.service", leaf("/fleet/states/web@3.service/YYY", 14)),
+			dir("/fleet/states/web@4.service", leaf("/fleet/states/web@4.service/YYY", 15)),
+		)),
+		res(dir("/fleet/state", leaf("/fleet/state/gone.service", 5), leaf("/fleet/state/web@1.service", 16))),
+		res(dir("/fleet/unit", leaf("/fleet/unit/"+used, 6), leaf("/fleet/unit/"+rolled, 7), leaf("/fleet/unit/"+revised, 13), leaf("/fleet/unit/abc", 8))),
+		res(dir("/fleet/decisions", leaf("/fleet/decisions/foo.service", 9), leaf("/fleet/decisions/gone.service", 10))),
+		res(dir("/fleet/unschedulable", leaf("/fleet/unschedulable/gone.service", 11))),
+		res(dir("/fleet/job",
+			dir("/fleet/job/foo.service", etcd.Node{Key: "/fleet/job/foo.service/object", Value: job}),
+			// a heartbeat outliving its Job does not keep it alive
+			dir("/fleet/job/gone.service", leaf("/fleet/job/gone.service/job-state", 12)),
+			dir("/fleet/job/web@.service",
+				etcd.Node{Key: "/fleet/job/web@.service/instances", Value: instances},
+				etcd.Node{Key: "/fleet/job/web@.service/object", Value: template},
+			),
+		)),
+		res(dir("/fleet/rollout", etcd.Node{Key: "/fleet/rollout/bar@.service", Value: rollout})),
+		// the history of a destroyed Job keeps its unit file
+		res(dir("/fleet/history", etcd.Node{Key: "/fleet/history/gone.service", Value: history})),
+	}}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+
+	got, err := r.Orphans()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Orphan{
+		{Kind: OrphanUnitState, Name: "gone.service", Key: "/fleet/states/gone.service/XXX", Index: 3},
+		{Kind: OrphanUnitState, Name: "gone.service", Key: "/fleet/states/gone.service/YYY", Index: 4},
+		{Kind: OrphanUnitState, Name: "web@4.service", Key: "/fleet/states/web@4.service/YYY", Index: 15},
+		{Kind: OrphanUnitState, Name: "gone.service", Key: "/fleet/state/gone.service", Index: 5},
+		{Kind: OrphanUnitFile, Name: "abc", Key: "/fleet/unit/abc", Index: 8},
+		{Kind: OrphanDecisions, Name: "gone.service", Key: "/fleet/decisions/gone.service", Index: 10},
+		{Kind: OrphanUnschedulable, Name: "gone.service", Key: "/fleet/unschedulable/gone.service", Index: 11},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected orphans %#v, got %#v", want, got)
+	}
+
+	// candidates are read before what may refer to them
+	var keys []string
+	for _, g := range e.gets {
+		keys = append(keys, g.key)
+	}
+	wantKeys := []string{"/fleet/states", "/fleet/state", "/fleet/unit", "/fleet/decisions", "/fleet/unschedulable", "/fleet/job", "/fleet/rollout", "/fleet/history"}
+	if !reflect.DeepEqual(wantKeys, keys) {
+		t.Fatalf("expected gets of %v, got %v", wantKeys, keys)
+	}
+}
+
+func TestOrphansKeyedUnitFiles(t *testing.T) {
+	uf, _ := unit.NewUnitFile("[Service]\nExecStart=/bin/true")
+	gone, _ := unit.NewUnitFile("[Service]\nExecStart=/bin/false")
+	key := []byte("0123456789abcdef0123456789abcdef")
+	keyed := &EtcdRegistry{}
+	keyed.SetUnitEncryptionKey(key)
+	used := keyed.unitFileNames(uf.Hash())[0]
+	unused := keyed.unitFileNames(gone.Hash())[0]
+
+	job, _ := marshal(jobModel{Name: "foo.service", UnitHash: uf.Hash()})
+	results := func() []*etcd.Result {
+		var rs []*etcd.Result
+		for _, key := range []string{"/fleet/states", "/fleet/state", "/fleet/unit", "/fleet/decisions", "/fleet/unschedulable", "/fleet/job", "/fleet/rollout", "/fleet/history"} {
+			rs = append(rs, &etcd.Result{Node: &etcd.Node{Key: key}})
+		}
+		rs[2].Node.Nodes = []etcd.Node{{Key: "/fleet/unit/" + used, ModifiedIndex: 2}, {Key: "/fleet/unit/" + unused, ModifiedIndex: 3}}
+		rs[5].Node.Nodes = []etcd.Node{{Key: "/fleet/job/foo.service", Nodes: []etcd.Node{{Key: "/fleet/job/foo.service/object", Value: job}}}}
+		return rs
+	}
+
+	// with the key, unit files are told apart by their names
+	r := &EtcdRegistry{etcd: &testEtcdClient{res: results()}, keyPrefix: "/fleet/"}
+	r.SetUnitEncryptionKey(key)
+	got, err := r.Orphans()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Orphan{{Kind: OrphanUnitFile, Name: unused, Key: "/fleet/unit/" + unused, Index: 3}}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected orphans %#v, got %#v", want, got)
+	}
+
+	// without it, they are all kept
+	r = &EtcdRegistry{etcd: &testEtcdClient{res: results()}, keyPrefix: "/fleet/"}
+	if got, err = r.Orphans(); err != nil || len(got) != 0 {
+		t.Fatalf("expected no orphans without the key, got %v, %v", got, err)
+	}
+}
+
+func TestRemoveOrphan(t *testing.T) {
+	e := &testEtcdClient{err: []error{etcd.Error{ErrorCode: etcd.ErrorKeyNotFound}}}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+
+	// keys already gone are ignored
+	if err := r.RemoveOrphan(Orphan{Key: "/fleet/unit/abc", Index: 8}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(e.deletes) != 1 || e.deletes[0].key != "/fleet/unit/abc" {
+		t.Fatalf("expected delete of /fleet/unit/abc, got %v", e.deletes)
+	}
+}
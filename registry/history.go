@@ -0,0 +1,233 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	historyPrefix = "history"
+
+	// historyWriteAttempts is the number of times recording a Revision
+	// is attempted when the history of the Job is changed concurrently
+	historyWriteAttempts = 10
+)
+
+// revisionModel is the form in which a job.Revision is stored, referring
+// to its unit file by hash. The unit files of the Revisions retained are
+// kept from garbage collection.
+type revisionModel struct {
+	Number      int
+	Time        time.Time
+	Event       job.RevisionEvent
+	TargetState job.JobState `json:",omitempty"`
+	UnitHash    unit.Hash
+}
+
+// UnitHistoryLimit implements the HistoryRegistry interface
+func (r *EtcdRegistry) UnitHistoryLimit() (int, error) {
+	req := etcd.Get{
+		Key: r.historyLimitPath(),
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return 0, err
+	}
+	return strconv.Atoi(res.Node.Value)
+}
+
+// SetUnitHistoryLimit implements the HistoryRegistry interface
+func (r *EtcdRegistry) SetUnitHistoryLimit(limit int) error {
+	var req etcd.Action
+	if limit <= 0 {
+		req = &etcd.Delete{
+			Key: r.historyLimitPath(),
+		}
+	} else {
+		req = &etcd.Set{
+			Key:   r.historyLimitPath(),
+			Value: strconv.Itoa(limit),
+		}
+	}
+	_, err := r.etcd.Do(req)
+	if limit <= 0 && isKeyNotFound(err) {
+		err = nil
+	}
+	return err
+}
+
+func (r *EtcdRegistry) historyLimitPath() string {
+	return path.Join(r.keyPrefix, "/cluster/unit-history-limit")
+}
+
+// historyPath returns the keypath of the Revisions of a Job. Like its
+// decisions, these are kept outside of the Job's own keyspace, which is
+// removed when the Job is destroyed.
+func (r *EtcdRegistry) historyPath(jobName string) string {
+	return path.Join(r.keyPrefix, historyPrefix, jobName)
+}
+
+// revisionModels returns the stored Revisions of the named Job, along with
+// the index at which they were last modified, or 0 if none are stored
+func (r *EtcdRegistry) revisionModels(name string) ([]revisionModel, uint64, error) {
+	req := etcd.Get{
+		Key: r.historyPath(name),
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, 0, err
+	}
+
+	var rms []revisionModel
+	if err := unmarshal(res.Node.Value, &rms); err != nil {
+		return nil, 0, err
+	}
+	return rms, res.Node.ModifiedIndex, nil
+}
+
+// UnitHistory returns the Revisions retained of the named Job, oldest
+// first
+func (r *EtcdRegistry) UnitHistory(name string) ([]job.Revision, error) {
+	rms, _, err := r.revisionModels(name)
+	if err != nil {
+		return nil, err
+	}
+
+	revs := make([]job.Revision, 0, len(rms))
+	for _, rm := range rms {
+		uf := r.getUnitByHash(rm.UnitHash)
+		if uf == nil {
+			return nil, fmt.Errorf("unable to retrieve unit file %s of revision %d of Job(%s)", rm.UnitHash, rm.Number, name)
+		}
+		revs = append(revs, job.Revision{
+			Number:      rm.Number,
+			Time:        rm.Time,
+			Event:       rm.Event,
+			TargetState: rm.TargetState,
+			Unit:        *uf,
+		})
+	}
+	return revs, nil
+}
+
+// historyLimit returns the cluster-wide history limit, treating a failure
+// to read it as no limit so that the change being recorded goes ahead
+func (r *EtcdRegistry) historyLimit() int {
+	limit, err := r.UnitHistoryLimit()
+	if err != nil {
+		log.Errorf("Failed fetching unit history limit: %v", err)
+		return 0
+	}
+	return limit
+}
+
+// recordRevision appends a Revision to those of the named Job, retaining
+// no more than limit. Failing to record a Revision does not fail the
+// change it describes, which has already been made.
+func (r *EtcdRegistry) recordRevision(name string, ev job.RevisionEvent, state job.JobState, hash unit.Hash, limit int) {
+	if limit <= 0 {
+		return
+	}
+	if err := r.appendRevision(name, ev, state, hash, limit); err != nil {
+		log.Errorf("Failed recording %s revision of Job(%s): %v", ev, name, err)
+	}
+}
+
+// appendRevision records a Revision by swapping the history of the Job for
+// one including it, so that Revisions recorded concurrently by several
+// writers are neither lost nor given the same number. The swap is retried
+// against the new history should another writer get there first.
+func (r *EtcdRegistry) appendRevision(name string, ev job.RevisionEvent, state job.JobState, hash unit.Hash, limit int) error {
+	for i := 0; ; i++ {
+		rms, index, err := r.revisionModels(name)
+		if err != nil {
+			return err
+		}
+
+		number := 1
+		if len(rms) > 0 {
+			number = rms[len(rms)-1].Number + 1
+		}
+		rms = append(rms, revisionModel{
+			Number:      number,
+			Time:        time.Now(),
+			Event:       ev,
+			TargetState: state,
+			UnitHash:    hash,
+		})
+		if len(rms) > limit {
+			rms = rms[len(rms)-limit:]
+		}
+
+		json, err := marshal(rms)
+		if err != nil {
+			return err
+		}
+
+		var req etcd.Action
+		if index == 0 {
+			req = &etcd.Create{
+				Key:   r.historyPath(name),
+				Value: json,
+			}
+		} else {
+			req = &etcd.Set{
+				Key:           r.historyPath(name),
+				Value:         json,
+				PreviousIndex: index,
+			}
+		}
+		_, err = r.etcd.Do(req)
+		if !(isCompareFailed(err) || isNodeExist(err)) || i+1 >= historyWriteAttempts {
+			return err
+		}
+	}
+}
+
+// jobUnitHash returns the hash of the unit file of the named Job, or
+// false if it does not exist
+func (r *EtcdRegistry) jobUnitHash(name string) (unit.Hash, bool, error) {
+	req := etcd.Get{
+		Key: path.Join(r.keyPrefix, jobPrefix, name, "object"),
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return unit.Hash{}, false, err
+	}
+
+	var jm jobModel
+	if err := unmarshal(res.Node.Value, &jm); err != nil {
+		return unit.Hash{}, false, err
+	}
+	return jm.UnitHash, true, nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestRecordRevision(t *testing.T) {
+	uf, _ := unit.NewUnitFile("[Service]\nExecStart=/bin/true")
+	at := time.Date(2014, time.October, 15, 10, 30, 0, 0, time.UTC)
+	existing, _ := marshal([]revisionModel{
+		{Number: 4, Time: at, Event: job.RevisionEventCreate, TargetState: job.JobStateLoaded, UnitHash: uf.Hash()},
+		{Number: 5, Time: at, Event: job.RevisionEventSetTargetState, TargetState: job.JobStateLaunched, UnitHash: uf.Hash()},
+	})
+
+	e := &testEtcdClient{res: []*etcd.Result{{Node: &etcd.Node{Value: existing, ModifiedIndex: 7}}}}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+	r.recordRevision("foo.service", job.RevisionEventDestroy, "", uf.Hash(), 2)
+
+	if len(e.sets) != 1 || e.sets[0].key != "/fleet/history/foo.service" {
+		t.Fatalf("expected a single set of /fleet/history/foo.service, got %v", e.sets)
+	}
+	var got []revisionModel
+	if err := unmarshal(e.sets[0].val, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the oldest Revision makes way for the new one, which is numbered on
+	for i := range got {
+		got[i].Time = at
+	}
+	want := []revisionModel{
+		{Number: 5, Time: at, Event: job.RevisionEventSetTargetState, TargetState: job.JobStateLaunched, UnitHash: uf.Hash()},
+		{Number: 6, Time: at, Event: job.RevisionEventDestroy, UnitHash: uf.Hash()},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected history %v, got %v", want, got)
+	}
+
+	// nothing is recorded without a limit
+	e = &testEtcdClient{}
+	r = &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+	r.recordRevision("foo.service", job.RevisionEventDestroy, "", uf.Hash(), 0)
+	if len(e.gets) != 0 || len(e.sets) != 0 {
+		t.Fatalf("expected no requests, got gets %v and sets %v", e.gets, e.sets)
+	}
+}
+
+func TestRecordRevisionConcurrently(t *testing.T) {
+	uf, _ := unit.NewUnitFile("[Service]\nExecStart=/bin/true")
+	theirs, _ := marshal([]revisionModel{
+		{Number: 1, Event: job.RevisionEventCreate, UnitHash: uf.Hash()},
+	})
+
+	// another writer creates the history first, so the Revision is
+	// recorded again on top of theirs
+	e := &testEtcdClient{
+		res: []*etcd.Result{nil, nil, {Node: &etcd.Node{Value: theirs, ModifiedIndex: 3}}, nil},
+		err: []error{
+			etcd.Error{ErrorCode: etcd.ErrorKeyNotFound},
+			etcd.Error{ErrorCode: etcd.ErrorNodeExist},
+			nil,
+			nil,
+		},
+	}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+	r.recordRevision("foo.service", job.RevisionEventSetTargetState, job.JobStateLaunched, uf.Hash(), 10)
+
+	if len(e.gets) != 2 || len(e.creates) != 1 || len(e.sets) != 1 {
+		t.Fatalf("expected 2 gets, 1 create and 1 set, got gets %v, creates %v and sets %v", e.gets, e.creates, e.sets)
+	}
+	var got []revisionModel
+	if err := unmarshal(e.sets[0].val, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || got[0].Number != 1 || got[1].Number != 2 {
+		t.Fatalf("expected Revisions 1 and 2, got %v", got)
+	}
+}
+
+func TestUnitHistoryLimit(t *testing.T) {
+	e := &testEtcdClient{
+		res: []*etcd.Result{{Node: &etcd.Node{Value: "5"}}},
+	}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+	if limit, err := r.UnitHistoryLimit(); err != nil || limit != 5 {
+		t.Fatalf("expected limit 5, got %d, err %v", limit, err)
+	}
+
+	// a cluster without a limit records nothing
+	e = &testEtcdClient{err: []error{etcd.Error{ErrorCode: etcd.ErrorKeyNotFound}}}
+	r = &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+	if limit, err := r.UnitHistoryLimit(); err != nil || limit != 0 {
+		t.Fatalf("expected limit 0, got %d, err %v", limit, err)
+	}
+
+	e = &testEtcdClient{}
+	r = &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+	if err := r.SetUnitHistoryLimit(3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.SetUnitHistoryLimit(0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []action{{key: "/fleet/cluster/unit-history-limit", val: "3"}}
+	if !reflect.DeepEqual(want, e.sets) {
+		t.Errorf("expected sets %v, got %v", want, e.sets)
+	}
+	want = []action{{key: "/fleet/cluster/unit-history-limit"}}
+	if !reflect.DeepEqual(want, e.deletes) {
+		t.Errorf("expected deletes %v, got %v", want, e.deletes)
+	}
+}
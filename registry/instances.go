@@ -0,0 +1,72 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"path"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/job"
+)
+
+// the InstanceSchedule of a template is kept in the directory of its Job,
+// so it is removed along with it
+func (r *EtcdRegistry) instancesPath(name string) string {
+	return path.Join(r.keyPrefix, jobPrefix, name, "instances")
+}
+
+func (r *EtcdRegistry) ScheduleInstances(name string, s job.InstanceSchedule) error {
+	if s.Count() == 0 {
+		req := etcd.Delete{
+			Key: r.instancesPath(name),
+		}
+		_, err := r.etcd.Do(&req)
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return err
+	}
+
+	json, err := marshal(s)
+	if err != nil {
+		return err
+	}
+
+	req := etcd.Set{
+		Key:   r.instancesPath(name),
+		Value: json,
+	}
+	_, err = r.etcd.Do(&req)
+	return err
+}
+
+func (r *EtcdRegistry) UnitInstances(name string) (job.InstanceSchedule, error) {
+	req := etcd.Get{
+		Key: r.instancesPath(name),
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	var s job.InstanceSchedule
+	if err := unmarshal(res.Node.Value, &s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
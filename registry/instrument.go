@@ -0,0 +1,154 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/fleet/etcd"
+)
+
+// Operation describes a single request made to etcd on behalf of the
+// Registry
+type Operation struct {
+	// Action is the kind of request: get, set, create, update, delete
+	// or watch.
+	Action string
+
+	// Keyspace is the part of the Registry the request touched, e.g.
+	// "job", "machines" or "states", and Key the full etcd key.
+	Keyspace string
+	Key      string
+
+	// Duration is how long the request took. For watches, this includes
+	// the time spent waiting for a change.
+	Duration time.Duration
+
+	// Err is the error the request failed with, if any.
+	Err error
+
+	// RequestSize is the size in bytes of the value written, and
+	// ResponseSize that of the body of the response from etcd.
+	RequestSize  int
+	ResponseSize int
+}
+
+// Interceptor observes the requests made to etcd by the Registry, e.g. to
+// record them in a metrics system. Observe is called once each request
+// completes, from whichever goroutine made it, so it must be safe for
+// concurrent use and should return quickly. Each attempt made of a
+// request is observed separately, so retried transient errors are seen.
+type Interceptor interface {
+	Observe(op Operation)
+}
+
+// InterceptorFunc adapts a function to the Interceptor interface
+type InterceptorFunc func(op Operation)
+
+func (f InterceptorFunc) Observe(op Operation) {
+	f(op)
+}
+
+var (
+	interceptorsMu sync.Mutex
+	interceptors   []Interceptor
+)
+
+// RegisterInterceptor has every etcd Backend created from now on report
+// its requests to the given Interceptor.
+func RegisterInterceptor(ic Interceptor) {
+	interceptorsMu.Lock()
+	defer interceptorsMu.Unlock()
+	interceptors = append(interceptors, ic)
+}
+
+// registeredInterceptors returns the Interceptors registered so far
+func registeredInterceptors() []Interceptor {
+	interceptorsMu.Lock()
+	defer interceptorsMu.Unlock()
+	return append([]Interceptor(nil), interceptors...)
+}
+
+// NewInstrumentedClient wraps an etcd.Client so that each request made
+// through it is reported to the given Interceptors, attributed to the
+// keyspace of the Registry stored under keyPrefix.
+func NewInstrumentedClient(client etcd.Client, keyPrefix string, ics ...Interceptor) etcd.Client {
+	return &instrumentedClient{client: client, keyPrefix: keyPrefix, interceptors: ics}
+}
+
+type instrumentedClient struct {
+	client       etcd.Client
+	keyPrefix    string
+	interceptors []Interceptor
+}
+
+func (ic *instrumentedClient) Do(act etcd.Action) (*etcd.Result, error) {
+	start := time.Now()
+	res, err := ic.client.Do(act)
+	ic.observe(act, res, err, time.Since(start))
+	return res, err
+}
+
+func (ic *instrumentedClient) Wait(act etcd.Action, cancel <-chan struct{}) (*etcd.Result, error) {
+	start := time.Now()
+	res, err := ic.client.Wait(act, cancel)
+	ic.observe(act, res, err, time.Since(start))
+	return res, err
+}
+
+func (ic *instrumentedClient) observe(act etcd.Action, res *etcd.Result, err error, d time.Duration) {
+	op := describeAction(act)
+	op.Keyspace = keyspaceOf(op.Key, ic.keyPrefix)
+	op.Duration = d
+	op.Err = err
+	if res != nil {
+		op.ResponseSize = len(res.Raw)
+	}
+	for _, i := range ic.interceptors {
+		i.Observe(op)
+	}
+}
+
+// describeAction fills in the Action, Key and RequestSize of the
+// Operation performing the given etcd.Action
+func describeAction(act etcd.Action) Operation {
+	switch a := act.(type) {
+	case *etcd.Get:
+		return Operation{Action: "get", Key: a.Key}
+	case *etcd.Set:
+		return Operation{Action: "set", Key: a.Key, RequestSize: len(a.Value)}
+	case *etcd.Create:
+		return Operation{Action: "create", Key: a.Key, RequestSize: len(a.Value)}
+	case *etcd.Update:
+		return Operation{Action: "update", Key: a.Key, RequestSize: len(a.Value)}
+	case *etcd.Delete:
+		return Operation{Action: "delete", Key: a.Key}
+	case *etcd.Watch:
+		return Operation{Action: "watch", Key: a.Key}
+	}
+	return Operation{Action: "unknown"}
+}
+
+// keyspaceOf returns the first element of the given key below keyPrefix
+func keyspaceOf(key, keyPrefix string) string {
+	rel := strings.TrimPrefix(key, keyPrefix)
+	rel = strings.TrimPrefix(rel, "/")
+	if i := strings.Index(rel, "/"); i >= 0 {
+		rel = rel[:i]
+	}
+	return rel
+}
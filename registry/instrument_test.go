@@ -0,0 +1,106 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/coreos/fleet/etcd"
+)
+
+// cannedClient responds to every request with the same Result and error
+type cannedClient struct {
+	res *etcd.Result
+	err error
+}
+
+func (cc *cannedClient) Do(etcd.Action) (*etcd.Result, error) {
+	return cc.res, cc.err
+}
+
+func (cc *cannedClient) Wait(etcd.Action, <-chan struct{}) (*etcd.Result, error) {
+	return cc.res, cc.err
+}
+
+func TestInstrumentedClient(t *testing.T) {
+	fail := errors.New("timeout reached")
+	tests := []struct {
+		act    etcd.Action
+		client *cannedClient
+		wait   bool
+		want   Operation
+	}{
+		{
+			&etcd.Get{Key: "/fleet/job/foo.service/object"},
+			&cannedClient{res: &etcd.Result{Raw: []byte("12345")}},
+			false,
+			Operation{Action: "get", Keyspace: "job", Key: "/fleet/job/foo.service/object", ResponseSize: 5},
+		},
+		{
+			&etcd.Set{Key: "/fleet/states/foo.service/XXX", Value: "abc"},
+			&cannedClient{err: fail},
+			false,
+			Operation{Action: "set", Keyspace: "states", Key: "/fleet/states/foo.service/XXX", Err: fail, RequestSize: 3},
+		},
+		{
+			&etcd.Create{Key: "/fleet/machines/XXX/object", Value: "abcd"},
+			&cannedClient{res: &etcd.Result{Raw: []byte("12")}},
+			false,
+			Operation{Action: "create", Keyspace: "machines", Key: "/fleet/machines/XXX/object", RequestSize: 4, ResponseSize: 2},
+		},
+		{
+			&etcd.Watch{Key: "/fleet/job", Recursive: true},
+			&cannedClient{res: &etcd.Result{}},
+			true,
+			Operation{Action: "watch", Keyspace: "job", Key: "/fleet/job"},
+		},
+	}
+
+	for i, tt := range tests {
+		var mu sync.Mutex
+		var ops []Operation
+		rec := InterceptorFunc(func(op Operation) {
+			mu.Lock()
+			ops = append(ops, op)
+			mu.Unlock()
+		})
+
+		c := NewInstrumentedClient(tt.client, "/fleet/", rec)
+		var err error
+		if tt.wait {
+			_, err = c.Wait(tt.act, nil)
+		} else {
+			_, err = c.Do(tt.act)
+		}
+		if err != tt.client.err {
+			t.Errorf("case %d: expected error %v, got %v", i, tt.client.err, err)
+		}
+
+		if len(ops) != 1 {
+			t.Errorf("case %d: expected 1 Operation, got %d", i, len(ops))
+			continue
+		}
+		got := ops[0]
+		if got.Duration < 0 {
+			t.Errorf("case %d: negative duration %v", i, got.Duration)
+		}
+		got.Duration = 0
+		if got != tt.want {
+			t.Errorf("case %d: expected %#v, got %#v", i, tt.want, got)
+		}
+	}
+}
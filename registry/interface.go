@@ -21,6 +21,7 @@ import (
 
 	"github.com/coreos/fleet/job"
 	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/sign"
 	"github.com/coreos/fleet/unit"
 )
 
@@ -34,11 +35,48 @@ type Registry interface {
 	RemoveUnitState(jobName string) error
 	SaveUnitState(jobName string, unitState *unit.UnitState, ttl time.Duration)
 	ScheduleUnit(name, machID string) error
+
+	// RescheduleUnit moves the named Unit from one Machine to another
+	// in a single atomic operation, returning a ScheduleConflictError
+	// if the Unit is not scheduled to the Machine it is moved from.
+	RescheduleUnit(name, from, to string) error
+
 	SetUnitTargetState(name string, state job.JobState) error
 	SetMachineState(ms machine.MachineState, ttl time.Duration) (uint64, error)
+
+	// SetMachineSchedulability records whether the engine may place Units
+	// on the given Machine. It is kept apart from the MachineState, so a
+	// Machine rebooted for maintenance is still cordoned once it returns.
+	SetMachineSchedulability(machID string, s machine.Schedulability) error
+
+	// SetMachineMetadata sets a metadata key of the given Machine on top of
+	// the metadata the Machine publishes, or removes it if the value is
+	// empty. Like the schedulability, it is kept apart from the
+	// MachineState, and Machines returns the two merged.
+	SetMachineMetadata(machID, key, value string) error
+	// MachineMetadata returns the metadata set on the given Machine with
+	// SetMachineMetadata
+	MachineMetadata(machID string) (map[string]string, error)
+
 	UnscheduleUnit(name, machID string) error
+	UpdateUnitFile(name string, uf unit.UnitFile) error
+
+	// Watch emits the Changes made within the given keyspace of the
+	// Registry, from the given index onwards or, if zero, from now on.
+	// Watching resumes by itself after errors, and ChangesMissed is
+	// emitted should the changes since the index no longer be
+	// available. The channel is closed once stop is.
+	Watch(keyspace string, index uint64, stop chan struct{}) <-chan Change
 
 	UnitRegistry
+	RolloutRegistry
+	CompletionRegistry
+	DecisionRegistry
+	HistoryRegistry
+	EngineStatusRegistry
+	SignatureRegistry
+	InstanceRegistry
+	VersionGateRegistry
 }
 
 type UnitRegistry interface {
@@ -47,6 +85,152 @@ type UnitRegistry interface {
 	Unit(name string) (*job.Unit, error)
 	Units() ([]job.Unit, error)
 	UnitStates() ([]*unit.UnitState, error)
+	// UnitState returns the state of the given Unit published by the
+	// given Machine, or nil if there is none
+	UnitState(name, machID string) (*unit.UnitState, error)
+}
+
+type RolloutRegistry interface {
+	// CreateRollout stores a new Rollout, failing if a Rollout of the
+	// same template is already running.
+	CreateRollout(*job.Rollout) error
+
+	// Rollout returns the Rollout of the given template, or nil if
+	// none exists.
+	Rollout(template string) (*job.Rollout, error)
+
+	// Rollouts lists all Rollouts, whether running or finished.
+	Rollouts() ([]job.Rollout, error)
+
+	// SaveRollout persists the progress of a Rollout.
+	SaveRollout(*job.Rollout) error
+
+	// SetRolloutControl gives an operator's instruction to the running
+	// Rollout of the given template.
+	SetRolloutControl(template string, c job.RolloutControl) error
+}
+
+type CompletionRegistry interface {
+	// Completion returns the outcome of the most recent run of the named
+	// batch Job, or nil if it has not yet completed.
+	Completion(name string) (*job.Completion, error)
+
+	// Completions returns the Completion of every batch Job that has
+	// completed, indexed by Job name.
+	Completions() (map[string]*job.Completion, error)
+
+	// SaveCompletion records the outcome of a run of the named batch Job.
+	SaveCompletion(name string, c *job.Completion) error
+
+	// RunHistory returns the runs of the named Job with a cron Schedule,
+	// or nil if it has none yet.
+	RunHistory(name string) (*job.RunHistory, error)
+
+	// RunHistories returns the RunHistory of every Job that has one,
+	// indexed by Job name.
+	RunHistories() (map[string]*job.RunHistory, error)
+
+	// SaveRunHistory persists the runs of the named Job.
+	SaveRunHistory(name string, h *job.RunHistory) error
+}
+
+type DecisionRegistry interface {
+	// Decisions returns the scheduling decisions recorded for the named
+	// Job, oldest first.
+	Decisions(name string) ([]job.Decision, error)
+
+	// RecordDecision appends a scheduling decision to those recorded for
+	// the named Job, retaining no more than limit decisions.
+	RecordDecision(name string, d job.Decision, limit int) error
+
+	// Unschedulable returns why the engine has been unable to schedule
+	// the named Job, or nil if it has not failed to.
+	Unschedulable(name string) (*job.Unschedulable, error)
+
+	// SetUnschedulable records why the named Job could not be scheduled,
+	// or removes the record if u is nil.
+	SetUnschedulable(name string, u *job.Unschedulable) error
+}
+
+type HistoryRegistry interface {
+	// UnitHistory returns the Revisions retained of the named Job,
+	// oldest first, whether or not the Job still exists.
+	UnitHistory(name string) ([]job.Revision, error)
+
+	// UnitHistoryLimit returns the cluster-wide number of Revisions
+	// retained of each Job. No Revisions are recorded if it is zero.
+	UnitHistoryLimit() (int, error)
+
+	// SetUnitHistoryLimit sets the cluster-wide number of Revisions
+	// retained of each Job, or removes it if zero.
+	SetUnitHistoryLimit(limit int) error
+}
+
+type EngineStatusRegistry interface {
+	// EngineStatuses lists the published status of every engine holding
+	// a lease, ordered by Machine ID.
+	EngineStatuses() ([]machine.EngineStatus, error)
+
+	// SetEngineStatus publishes the status of the engine of a Machine,
+	// which expires after the given TTL unless published again.
+	SetEngineStatus(st machine.EngineStatus, ttl time.Duration) error
+
+	// RemoveEngineStatus removes the status of the engine of a Machine.
+	RemoveEngineStatus(machID string) error
+}
+
+type SignatureRegistry interface {
+	// SetUnitSignatures records the Signatures of the named Unit, which
+	// may be recorded before the Unit is created and are removed along
+	// with it.
+	SetUnitSignatures(name string, sigs []sign.Signature) error
+
+	// UnitSignatures returns the Signatures recorded for the named Unit,
+	// or none if it is unsigned.
+	UnitSignatures(name string) ([]sign.Signature, error)
+}
+
+type InstanceRegistry interface {
+	// ScheduleInstances assigns the instances of the named template Unit
+	// to Machines, replacing any earlier InstanceSchedule. An empty
+	// InstanceSchedule removes it.
+	ScheduleInstances(name string, s job.InstanceSchedule) error
+
+	// UnitInstances returns the InstanceSchedule of the named template
+	// Unit, or nil if it has none.
+	UnitInstances(name string) (job.InstanceSchedule, error)
+}
+
+// VersionGateRegistry holds the cluster-wide minimum version of fleetd,
+// which lets operators keep Units off Machines not yet upgraded
+type VersionGateRegistry interface {
+	// MinimumVersion returns the oldest version of fleetd to which the
+	// engine schedules new Units, or nil if there is no minimum.
+	MinimumVersion() (*semver.Version, error)
+
+	// SetMinimumVersion sets the minimum version of fleetd, or removes
+	// it if nil.
+	SetMinimumVersion(v *semver.Version) error
+}
+
+// AuditRegistry keeps an append-only log of the changes made to Jobs
+type AuditRegistry interface {
+	// AuditLog returns every AuditEntry recorded, oldest first.
+	AuditLog() ([]job.AuditEntry, error)
+
+	// RecordAudit appends an AuditEntry to the log.
+	RecordAudit(e job.AuditEntry) error
+}
+
+// GCRegistry finds and removes the state of a Registry that nothing refers
+// to any more
+type GCRegistry interface {
+	// Orphans lists the orphaned state of the Registry.
+	Orphans() ([]Orphan, error)
+
+	// RemoveOrphan removes orphaned state, unless it has been modified
+	// since it was listed.
+	RemoveOrphan(Orphan) error
 }
 
 type ClusterRegistry interface {
@@ -65,6 +249,19 @@ type ClusterRegistry interface {
 	UpdateEngineVersion(from, to int) error
 }
 
+// SchemaRegistry versions the key layout of a Registry, so that it can be
+// migrated when fleet is upgraded
+type SchemaRegistry interface {
+	// SchemaVersion returns the version of the key layout of the
+	// Registry, or zero if it has none yet.
+	SchemaVersion() (int, error)
+
+	// MigrateSchema upgrades the key layout of the Registry to the
+	// version written by this build of fleet, failing if the Registry
+	// is already newer.
+	MigrateSchema() error
+}
+
 type LeaseRegistry interface {
 	// GetLease fetches a Lease only if it exists. If it does not
 	// exist, a nil Lease will be returned. Any other failures
@@ -81,6 +278,11 @@ type LeaseRegistry interface {
 	// by the provided name and index with a new lessee. This function
 	// will fail if the named Lease has progressed past the given index.
 	StealLease(name, machID string, ver int, period time.Duration, idx uint64) (Lease, error)
+
+	// MarkResigned records that the given Machine has released the named
+	// Lease for good, emitting an Event that lets other candidates
+	// acquire it right away rather than on their next attempt.
+	MarkResigned(name, machID string) error
 }
 
 // Lease proxies to an auto-expiring lease stored in a LeaseRegistry.
@@ -102,9 +102,17 @@ func (r *EtcdRegistry) Units() ([]job.Unit, error) {
 		return nil, err
 	}
 
+	// The UnitFiles are read after the Jobs, as each is stored before the
+	// Jobs referencing it, so that all of them are fetched at once rather
+	// than one request per Job.
+	files, err := r.unitFiles()
+	if err != nil {
+		return nil, err
+	}
+
 	uMap := make(map[string]*job.Unit)
 	for _, dir := range res.Node.Nodes {
-		u, err := r.dirToUnit(&dir)
+		u, err := r.dirToUnit(&dir, files)
 		if err != nil {
 			log.Errorf("Failed to parse Unit from etcd: %v", err)
 			continue
@@ -145,12 +153,13 @@ func (r *EtcdRegistry) Unit(name string) (*job.Unit, error) {
 		return nil, err
 	}
 
-	return r.dirToUnit(res.Node)
+	return r.dirToUnit(res.Node, nil)
 }
 
 // dirToUnit takes a Node containing a Job's constituent objects (in child
-// nodes) and returns a *job.Unit, or any error encountered
-func (r *EtcdRegistry) dirToUnit(dir *etcd.Node) (*job.Unit, error) {
+// nodes) and returns a *job.Unit, or any error encountered. The UnitFile of
+// the Job is taken from files, if there, and otherwise fetched.
+func (r *EtcdRegistry) dirToUnit(dir *etcd.Node, files map[string]string) (*job.Unit, error) {
 	objKey := path.Join(dir.Key, "object")
 	var objNode *etcd.Node
 	for _, node := range dir.Nodes {
@@ -162,7 +171,7 @@ func (r *EtcdRegistry) dirToUnit(dir *etcd.Node) (*job.Unit, error) {
 	if objNode == nil {
 		return nil, nil
 	}
-	u, err := r.getUnitFromObjectNode(objNode)
+	u, err := r.getUnitFromObjectNode(objNode, files)
 	if err != nil {
 		return nil, err
 	}
@@ -215,6 +224,42 @@ func (r *EtcdRegistry) ScheduledUnit(name string) (*job.ScheduledUnit, error) {
 	return &su, nil
 }
 
+// ScheduleConflictError is returned when the schedule of a Unit could not
+// be changed as it is not what the caller expected, most likely having
+// been changed by another engine in the meantime
+type ScheduleConflictError struct {
+	Name      string
+	MachineID string
+	Schedule  bool
+}
+
+func (e ScheduleConflictError) Error() string {
+	if e.Schedule {
+		return fmt.Sprintf("unable to schedule Unit(%s) to Machine(%s): Unit already scheduled", e.Name, e.MachineID)
+	}
+	return fmt.Sprintf("unable to unschedule Unit(%s) from Machine(%s): Unit scheduled elsewhere", e.Name, e.MachineID)
+}
+
+// RescheduleUnit swaps the target of the named Unit from one Machine to
+// another, so that the Unit is never seen unscheduled in between as it
+// would be were it unscheduled and scheduled again
+func (r *EtcdRegistry) RescheduleUnit(name, from, to string) error {
+	req := etcd.Set{
+		Key:           r.jobTargetAgentPath(name),
+		Value:         to,
+		PreviousValue: from,
+	}
+
+	_, err := r.etcd.Do(&req)
+	if isKeyNotFound(err) || isCompareFailed(err) {
+		err = ScheduleConflictError{Name: name, MachineID: from}
+	}
+	return err
+}
+
+// UnscheduleUnit removes the target of the named Unit, only if it is the
+// given Machine. A ScheduleConflictError is returned if the Unit is
+// scheduled to another Machine instead.
 func (r *EtcdRegistry) UnscheduleUnit(name, machID string) error {
 	req := etcd.Delete{
 		Key:           r.jobTargetAgentPath(name),
@@ -224,6 +269,8 @@ func (r *EtcdRegistry) UnscheduleUnit(name, machID string) error {
 	_, err := r.etcd.Do(&req)
 	if isKeyNotFound(err) {
 		err = nil
+	} else if isCompareFailed(err) {
+		err = ScheduleConflictError{Name: name, MachineID: machID}
 	}
 
 	return err
@@ -258,7 +305,7 @@ func dirToHeartbeat(dir *etcd.Node) (heartbeat string) {
 // getUnitFromObject takes a *etcd.Node containing a Unit's jobModel, and
 // instantiates and returns a representative *job.Unit, transitively fetching the
 // associated UnitFile as necessary
-func (r *EtcdRegistry) getUnitFromObjectNode(node *etcd.Node) (*job.Unit, error) {
+func (r *EtcdRegistry) getUnitFromObjectNode(node *etcd.Node, files map[string]string) (*job.Unit, error) {
 	var err error
 	var jm jobModel
 	if err = unmarshal(node.Value, &jm); err != nil {
@@ -267,7 +314,17 @@ func (r *EtcdRegistry) getUnitFromObjectNode(node *etcd.Node) (*job.Unit, error)
 
 	var unit *unit.UnitFile
 
-	unit = r.getUnitByHash(jm.UnitHash)
+	found := false
+	for _, name := range r.unitFileNames(jm.UnitHash) {
+		if raw, ok := files[name]; ok {
+			unit = r.parseUnitModel(name, raw)
+			found = true
+			break
+		}
+	}
+	if !found {
+		unit = r.getUnitByHash(jm.UnitHash)
+	}
 	if unit == nil {
 		log.Warningf("No Unit found in Registry for Job(%s)", jm.Name)
 		return nil, nil
@@ -290,6 +347,16 @@ type jobModel struct {
 // DestroyUnit removes a Job object from the repository. It does not yet remove underlying
 // UnitFiles from the repository.
 func (r *EtcdRegistry) DestroyUnit(name string) error {
+	// the unit file of the Job is only known until it is destroyed
+	var hash unit.Hash
+	limit := r.historyLimit()
+	if limit > 0 {
+		var err error
+		if hash, _, err = r.jobUnitHash(name); err != nil {
+			return err
+		}
+	}
+
 	req := etcd.Delete{
 		Key:       path.Join(r.keyPrefix, jobPrefix, name),
 		Recursive: true,
@@ -304,7 +371,14 @@ func (r *EtcdRegistry) DestroyUnit(name string) error {
 		return err
 	}
 
-	// TODO(jonboulle): add unit reference counting and actually destroying Units
+	r.recordRevision(name, job.RevisionEventDestroy, "", hash, limit)
+
+	if err := r.destroyDecisions(name); err != nil {
+		return err
+	}
+
+	// the unit file may be shared with other Jobs, so it is left to the
+	// engine's garbage collection to remove once nothing refers to it
 	return nil
 }
 
@@ -336,10 +410,29 @@ func (r *EtcdRegistry) CreateUnit(u *job.Unit) (err error) {
 		return
 	}
 
-	return r.SetUnitTargetState(u.Name, u.TargetState)
+	if err = r.setUnitTargetState(u.Name, u.TargetState); err != nil {
+		return
+	}
+	r.recordRevision(u.Name, job.RevisionEventCreate, u.TargetState, u.Unit.Hash(), r.historyLimit())
+	return
 }
 
 func (r *EtcdRegistry) SetUnitTargetState(name string, state job.JobState) error {
+	if err := r.setUnitTargetState(name, state); err != nil {
+		return err
+	}
+	if limit := r.historyLimit(); limit > 0 {
+		hash, ok, err := r.jobUnitHash(name)
+		if err != nil {
+			log.Errorf("Failed recording %s revision of Job(%s): %v", job.RevisionEventSetTargetState, name, err)
+		} else if ok {
+			r.recordRevision(name, job.RevisionEventSetTargetState, state, hash, limit)
+		}
+	}
+	return nil
+}
+
+func (r *EtcdRegistry) setUnitTargetState(name string, state job.JobState) error {
 	req := etcd.Set{
 		Key:   r.jobTargetStatePath(name),
 		Value: string(state),
@@ -348,12 +441,18 @@ func (r *EtcdRegistry) SetUnitTargetState(name string, state job.JobState) error
 	return err
 }
 
+// ScheduleUnit sets the target of the named Unit to the given Machine, only
+// if the Unit is not scheduled yet. A ScheduleConflictError is returned if
+// it already is.
 func (r *EtcdRegistry) ScheduleUnit(name string, machID string) error {
 	req := etcd.Create{
 		Key:   r.jobTargetAgentPath(name),
 		Value: machID,
 	}
 	_, err := r.etcd.Do(&req)
+	if isNodeExist(err) {
+		err = ScheduleConflictError{Name: name, MachineID: machID, Schedule: true}
+	}
 	return err
 }
 
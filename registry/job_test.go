@@ -0,0 +1,227 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestUnitFileCompression(t *testing.T) {
+	uf, _ := unit.NewUnitFile("[Service]\nExecStart=/bin/bash -c \"" + strings.Repeat("echo hello; ", 100) + "\"")
+
+	for _, compress := range []bool{false, true} {
+		e := &testEtcdClient{}
+		r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/", compressUnits: compress}
+		if err := r.storeOrGetUnitFile(*uf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(e.creates) != 1 {
+			t.Fatalf("expected one unit file to be created, got %v", e.creates)
+		}
+		stored := e.creates[0].val
+		if compressed := strings.Contains(stored, unitEncodingGzip); compressed != compress || compress && len(stored) >= len(uf.String()) {
+			t.Errorf("compress=%t: unexpected stored unit file %s", compress, stored)
+		}
+
+		got := r.parseUnitModel(uf.Hash().String(), stored)
+		if got == nil || got.Hash() != uf.Hash() {
+			t.Errorf("compress=%t: expected to read back unit file %s, got %v", compress, uf, got)
+		}
+	}
+
+	// small unit files are not worth compressing
+	small, _ := unit.NewUnitFile("[Service]\nExecStart=/bin/true")
+	if um := compressUnitModel(unitModel{Raw: small.String()}); um.Encoding != "" {
+		t.Errorf("expected small unit file to be left uncompressed, got %v", um)
+	}
+}
+
+func TestUnitFileEncryption(t *testing.T) {
+	uf, _ := unit.NewUnitFile("[Service]\nEnvironment=PASSWORD=hunter2\nExecStart=/bin/bash -c \"" + strings.Repeat("echo hello; ", 100) + "\"")
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	for _, compress := range []bool{false, true} {
+		e := &testEtcdClient{}
+		r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/", compressUnits: compress}
+		if err := r.SetUnitEncryptionKey(key); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := r.storeOrGetUnitFile(*uf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		stored := e.creates[0].val
+		if !strings.Contains(stored, unitEncodingAESGCM) || strings.Contains(stored, "hunter2") {
+			t.Errorf("compress=%t: expected stored unit file to be encrypted, got %s", compress, stored)
+		}
+
+		// the name of the unit file does not give its hash away
+		name := path.Base(e.creates[0].key)
+		if name == uf.Hash().String() || !isKeyedUnitFileName(name) {
+			t.Errorf("compress=%t: expected unit file to be named by its HMAC, got %s", compress, name)
+		}
+
+		if got := r.parseUnitModel(name, stored); got == nil || got.Hash() != uf.Hash() {
+			t.Errorf("compress=%t: expected to read back unit file %s, got %v", compress, uf, got)
+		}
+
+		// moved under another name, the unit file is unreadable
+		if got := r.parseUnitModel(uf.Hash().String(), stored); got != nil {
+			t.Errorf("compress=%t: expected no unit file under another name, got %v", compress, got)
+		}
+
+		// without the key, or with another, the unit file is unreadable
+		other := &EtcdRegistry{}
+		if got := other.parseUnitModel(name, stored); got != nil {
+			t.Errorf("compress=%t: expected no unit file without a key, got %v", compress, got)
+		}
+		other.SetUnitEncryptionKey([]byte("fedcba9876543210fedcba9876543210"))
+		if got := other.parseUnitModel(name, stored); got != nil {
+			t.Errorf("compress=%t: expected no unit file with the wrong key, got %v", compress, got)
+		}
+	}
+
+	// unit files stored unencrypted are still read
+	r := &EtcdRegistry{}
+	r.SetUnitEncryptionKey(key)
+	plain, _ := marshal(unitModel{Raw: uf.String()})
+	if got := r.parseUnitModel(uf.Hash().String(), plain); got == nil || got.Hash() != uf.Hash() {
+		t.Errorf("expected to read back unencrypted unit file %s, got %v", uf, got)
+	}
+}
+
+func TestUnitsSingleUnitFileFetch(t *testing.T) {
+	uf, _ := unit.NewUnitFile("[Service]\nExecStart=/bin/true")
+	hash := uf.Hash()
+	um, _ := marshal(unitModel{Raw: uf.String()})
+
+	var jobs []etcd.Node
+	names := []string{"bar.service", "baz.service", "foo.service"}
+	for _, name := range names {
+		jm, _ := marshal(jobModel{Name: name, UnitHash: hash})
+		key := "/fleet/job/" + name
+		jobs = append(jobs, etcd.Node{
+			Key:   key,
+			Nodes: []etcd.Node{{Key: key + "/object", Value: jm}},
+		})
+	}
+
+	e := &testEtcdClient{
+		res: []*etcd.Result{
+			&etcd.Result{Node: &etcd.Node{Key: "/fleet/job", Nodes: jobs}},
+			&etcd.Result{Node: &etcd.Node{Key: "/fleet/unit", Nodes: []etcd.Node{
+				{Key: "/fleet/unit/" + hash.String(), Value: um},
+			}}},
+		},
+	}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+	units, err := r.Units()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(units) != len(names) {
+		t.Fatalf("expected %d Units, got %v", len(names), units)
+	}
+	for i, u := range units {
+		if u.Name != names[i] || u.Unit.Hash() != hash {
+			t.Errorf("unexpected Unit %d: %v", i, u)
+		}
+	}
+
+	want := []action{
+		action{key: "/fleet/job", rec: true},
+		action{key: "/fleet/unit", rec: true},
+	}
+	if !reflect.DeepEqual(want, e.gets) {
+		t.Fatalf("expected gets %v, got %v", want, e.gets)
+	}
+}
+
+func TestDestroyUnitDeletesDecisions(t *testing.T) {
+	e := &testEtcdClient{
+		// no history limit is set
+		err: []error{etcd.Error{ErrorCode: etcd.ErrorKeyNotFound}, nil, etcd.Error{ErrorCode: etcd.ErrorKeyNotFound}, etcd.Error{ErrorCode: etcd.ErrorKeyNotFound}},
+	}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+	if err := r.DestroyUnit("foo.service"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []action{
+		action{key: "/fleet/job/foo.service", rec: true},
+		action{key: "/fleet/decisions/foo.service"},
+		action{key: "/fleet/unschedulable/foo.service"},
+	}
+	if !reflect.DeepEqual(want, e.deletes) {
+		t.Fatalf("expected deletes %v, got %v", want, e.deletes)
+	}
+}
+
+func TestScheduleConflicts(t *testing.T) {
+	e := &testEtcdClient{
+		err: []error{etcd.Error{ErrorCode: etcd.ErrorNodeExist}, etcd.Error{ErrorCode: etcd.ErrorCompareFailed}, etcd.Error{ErrorCode: etcd.ErrorKeyNotFound}},
+	}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+
+	// a Unit already scheduled is not scheduled again
+	err := r.ScheduleUnit("foo.service", "XXX")
+	if want := (ScheduleConflictError{Name: "foo.service", MachineID: "XXX", Schedule: true}); err != want {
+		t.Fatalf("expected error %v, got %v", want, err)
+	}
+	if len(e.creates) != 1 || e.creates[0].key != "/fleet/job/foo.service/target" {
+		t.Fatalf("expected target to be created, got %v", e.creates)
+	}
+
+	// nor unscheduled from a Machine other than its own
+	err = r.UnscheduleUnit("foo.service", "XXX")
+	if want := (ScheduleConflictError{Name: "foo.service", MachineID: "XXX"}); err != want {
+		t.Fatalf("expected error %v, got %v", want, err)
+	}
+
+	// but a Unit not scheduled at all is unscheduled already
+	if err = r.UnscheduleUnit("foo.service", "XXX"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRescheduleUnit(t *testing.T) {
+	e := &testEtcdClient{
+		err: []error{nil, etcd.Error{ErrorCode: etcd.ErrorCompareFailed}, etcd.Error{ErrorCode: etcd.ErrorKeyNotFound}},
+	}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+
+	// the target is swapped in a single write
+	if err := r.RescheduleUnit("foo.service", "XXX", "YYY"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []action{action{key: "/fleet/job/foo.service/target", val: "YYY"}}
+	if !reflect.DeepEqual(want, e.sets) {
+		t.Fatalf("expected sets %v, got %v", want, e.sets)
+	}
+
+	// a Unit scheduled elsewhere, or not at all, is not moved
+	for i := 0; i < 2; i++ {
+		err := r.RescheduleUnit("foo.service", "XXX", "YYY")
+		if want := (ScheduleConflictError{Name: "foo.service", MachineID: "XXX"}); err != want {
+			t.Fatalf("expected error %v, got %v", want, err)
+		}
+	}
+}
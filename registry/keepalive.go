@@ -0,0 +1,182 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/log"
+)
+
+// ErrLeaseLost is returned by a LeaseKeeper once its Lease has been lost
+var ErrLeaseLost = errors.New("lease lost")
+
+// LeaseKeeper holds a Lease, renewing it on its own goroutine so that it
+// cannot lapse while its holder is busy. Failed renewals are retried
+// with backoff for as long as the Lease has not expired, after which its
+// loss is reported on the Lost channel. A LeaseKeeper is itself a Lease,
+// whose Renew only reports whether the Lease is still held.
+type LeaseKeeper struct {
+	ttl   time.Duration
+	ival  time.Duration
+	clock clockwork.Clock
+
+	mu    sync.Mutex
+	lease Lease
+
+	lost     chan struct{}
+	done     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// KeepLease renews the given Lease for ttl every ival until the returned
+// LeaseKeeper is released or the Lease is lost.
+func KeepLease(l Lease, ttl, ival time.Duration) *LeaseKeeper {
+	k := newLeaseKeeper(l, ttl, ival, clockwork.NewRealClock())
+	go k.run()
+	return k
+}
+
+func newLeaseKeeper(l Lease, ttl, ival time.Duration, clock clockwork.Clock) *LeaseKeeper {
+	return &LeaseKeeper{
+		ttl:   ttl,
+		ival:  ival,
+		clock: clock,
+		lease: l,
+		lost:  make(chan struct{}),
+		done:  make(chan struct{}),
+		stop:  make(chan struct{}),
+	}
+}
+
+// Lost returns a channel which is closed once the Lease has been lost
+func (k *LeaseKeeper) Lost() <-chan struct{} {
+	return k.lost
+}
+
+// Done returns a channel which is closed once the Lease is no longer
+// renewed, having been either lost or released
+func (k *LeaseKeeper) Done() <-chan struct{} {
+	return k.done
+}
+
+func (k *LeaseKeeper) run() {
+	defer close(k.done)
+	renewed := k.clock.Now()
+	wait := k.ival
+	backoff := time.Duration(0)
+	for {
+		select {
+		case <-k.stop:
+			return
+		case <-k.clock.After(wait):
+		}
+
+		k.mu.Lock()
+		err := k.lease.Renew(k.ttl)
+		k.mu.Unlock()
+
+		now := k.clock.Now()
+		if err == nil {
+			renewed, wait, backoff = now, k.ival, 0
+			continue
+		}
+
+		remaining := renewed.Add(k.ttl).Sub(now)
+		if isPermanentLeaseError(err) || remaining <= 0 {
+			log.Errorf("Lost lease held by %s: %v", k.MachineID(), err)
+			close(k.lost)
+			return
+		}
+
+		if backoff == 0 {
+			backoff = k.ival / 4
+		} else {
+			backoff *= 2
+		}
+		wait = backoff
+		if wait > remaining {
+			wait = remaining
+		}
+		log.Warningf("Failed renewing lease, retrying in %v before it expires in %v: %v", wait, remaining, err)
+	}
+}
+
+// isPermanentLeaseError reports whether the failure to renew a Lease
+// shows it can no longer be renewed, as etcd itself refused the renewal,
+// rather than that etcd could not be reached
+func isPermanentLeaseError(err error) bool {
+	_, ok := err.(etcd.Error)
+	return ok
+}
+
+func (k *LeaseKeeper) isLost() bool {
+	select {
+	case <-k.lost:
+		return true
+	default:
+		return false
+	}
+}
+
+// Renew returns ErrLeaseLost once the Lease has been lost. The Lease is
+// renewed in the background, so the given TTL is ignored.
+func (k *LeaseKeeper) Renew(time.Duration) error {
+	if k.isLost() {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Release stops renewing the Lease and releases it
+func (k *LeaseKeeper) Release() error {
+	k.stopOnce.Do(func() { close(k.stop) })
+	if k.isLost() {
+		return ErrLeaseLost
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.lease.Release()
+}
+
+func (k *LeaseKeeper) MachineID() string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.lease.MachineID()
+}
+
+func (k *LeaseKeeper) Version() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.lease.Version()
+}
+
+func (k *LeaseKeeper) Index() uint64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.lease.Index()
+}
+
+func (k *LeaseKeeper) TimeRemaining() time.Duration {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.lease.TimeRemaining()
+}
@@ -0,0 +1,149 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/jonboulle/clockwork"
+
+	"github.com/coreos/fleet/etcd"
+)
+
+// flakyLease fails its renewals with the queued errors
+type flakyLease struct {
+	fakeLease
+
+	mu       sync.Mutex
+	errs     []error
+	renewals int
+	released bool
+}
+
+func (l *flakyLease) Renew(ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.renewals++
+	if len(l.errs) == 0 {
+		return nil
+	}
+	err := l.errs[0]
+	l.errs = l.errs[1:]
+	return err
+}
+
+func (l *flakyLease) Release() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.released = true
+	return nil
+}
+
+func (l *flakyLease) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.renewals
+}
+
+// renewOnce advances the fake clock to the next renewal attempt of the
+// LeaseKeeper and waits for it to complete
+func renewOnce(t *testing.T, fc clockwork.FakeClock, l *flakyLease, d time.Duration) {
+	fc.BlockUntil(1)
+	before := l.count()
+	fc.Advance(d)
+	for i := 0; l.count() == before; i++ {
+		if i == 1000 {
+			t.Fatalf("timed out waiting for renewal")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func assertLost(t *testing.T, k *LeaseKeeper, lost bool) {
+	select {
+	case <-k.Lost():
+		if !lost {
+			t.Fatalf("expected lease to be held")
+		}
+	case <-time.After(100 * time.Millisecond):
+		if lost {
+			t.Fatalf("expected lease to be lost")
+		}
+	}
+}
+
+func TestLeaseKeeperRetries(t *testing.T) {
+	timeout := errors.New("timeout reached")
+	l := &flakyLease{fakeLease: fakeLease{machID: "XXX"}, errs: []error{timeout, timeout}}
+	fc := clockwork.NewFakeClock()
+	k := newLeaseKeeper(l, 10*time.Second, 4*time.Second, fc)
+	go k.run()
+
+	// failed renewals are retried sooner, backing off
+	renewOnce(t, fc, l, 4*time.Second)
+	renewOnce(t, fc, l, time.Second)
+	renewOnce(t, fc, l, 2*time.Second)
+	assertLost(t, k, false)
+
+	// before renewing at the usual interval again
+	renewOnce(t, fc, l, 4*time.Second)
+	assertLost(t, k, false)
+	if err := k.Renew(time.Second); err != nil {
+		t.Fatalf("unexpected error renewing lease: %v", err)
+	}
+
+	if err := k.Release(); err != nil {
+		t.Fatalf("unexpected error releasing lease: %v", err)
+	}
+	<-k.Done()
+	if !l.released {
+		t.Fatalf("expected lease to be released")
+	}
+	assertLost(t, k, false)
+}
+
+func TestLeaseKeeperExpiry(t *testing.T) {
+	timeout := errors.New("timeout reached")
+	l := &flakyLease{errs: []error{timeout, timeout, timeout, timeout}}
+	fc := clockwork.NewFakeClock()
+	k := newLeaseKeeper(l, 10*time.Second, 4*time.Second, fc)
+	go k.run()
+
+	renewOnce(t, fc, l, 4*time.Second)
+	renewOnce(t, fc, l, time.Second)
+	renewOnce(t, fc, l, 2*time.Second)
+	assertLost(t, k, false)
+
+	// the retries never go beyond the expiry of the lease
+	renewOnce(t, fc, l, 3*time.Second)
+	assertLost(t, k, true)
+	<-k.Done()
+	if err := k.Renew(time.Second); err != ErrLeaseLost {
+		t.Fatalf("expected ErrLeaseLost, got %v", err)
+	}
+}
+
+func TestLeaseKeeperPermanentError(t *testing.T) {
+	l := &flakyLease{errs: []error{etcd.Error{ErrorCode: 101}}}
+	fc := clockwork.NewFakeClock()
+	k := newLeaseKeeper(l, 10*time.Second, 4*time.Second, fc)
+	go k.run()
+
+	renewOnce(t, fc, l, 4*time.Second)
+	assertLost(t, k, true)
+}
@@ -23,13 +23,32 @@ import (
 )
 
 const (
-	leasePrefix = "lease"
+	leasePrefix    = "lease"
+	resignedPrefix = "resigned"
+
+	// resignedTTL is how long the record of a resignation is kept, which
+	// only needs to outlive its observation by the other candidates
+	resignedTTL = time.Minute
 )
 
 func (r *EtcdRegistry) leasePath(name string) string {
 	return path.Join(r.keyPrefix, leasePrefix, name)
 }
 
+func (r *EtcdRegistry) resignedPath(name string) string {
+	return path.Join(r.keyPrefix, resignedPrefix, name)
+}
+
+func (r *EtcdRegistry) MarkResigned(name, machID string) error {
+	req := etcd.Set{
+		Key:   r.resignedPath(name),
+		Value: machID,
+		TTL:   resignedTTL,
+	}
+	_, err := r.etcd.Do(&req)
+	return err
+}
+
 func (r *EtcdRegistry) GetLease(name string) (Lease, error) {
 	key := r.leasePath(name)
 	req := etcd.Get{
@@ -43,6 +43,17 @@ func (r *EtcdRegistry) Machines() (machines []machine.MachineState, err error) {
 	}
 
 	for _, node := range resp.Node.Nodes {
+		var sched machine.Schedulability
+		var md map[string]string
+		for _, obj := range node.Nodes {
+			if strings.HasSuffix(obj.Key, "/schedulability") {
+				sched = machine.Schedulability(obj.Value)
+			}
+			if strings.HasSuffix(obj.Key, "/metadata") {
+				md = metadataFromNodes(obj.Nodes)
+			}
+		}
+
 		for _, obj := range node.Nodes {
 			if !strings.HasSuffix(obj.Key, "/object") {
 				continue
@@ -54,6 +65,8 @@ func (r *EtcdRegistry) Machines() (machines []machine.MachineState, err error) {
 				return
 			}
 
+			mach = mach.WithMetadata(md)
+			mach.Schedulability = sched
 			machines = append(machines, mach)
 		}
 	}
@@ -104,3 +117,55 @@ func (r *EtcdRegistry) RemoveMachineState(machID string) error {
 	}
 	return err
 }
+
+func (r *EtcdRegistry) SetMachineSchedulability(machID string, s machine.Schedulability) error {
+	key := path.Join(r.keyPrefix, machinePrefix, machID, "schedulability")
+	if s == machine.Schedulable {
+		_, err := r.etcd.Do(&etcd.Delete{Key: key})
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return err
+	}
+
+	_, err := r.etcd.Do(&etcd.Set{Key: key, Value: string(s)})
+	return err
+}
+
+func (r *EtcdRegistry) SetMachineMetadata(machID, key, value string) error {
+	k := path.Join(r.keyPrefix, machinePrefix, machID, "metadata", key)
+	if value == "" {
+		_, err := r.etcd.Do(&etcd.Delete{Key: k})
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return err
+	}
+
+	_, err := r.etcd.Do(&etcd.Set{Key: k, Value: value})
+	return err
+}
+
+func (r *EtcdRegistry) MachineMetadata(machID string) (map[string]string, error) {
+	req := etcd.Get{
+		Key:       path.Join(r.keyPrefix, machinePrefix, machID, "metadata"),
+		Recursive: true,
+	}
+	resp, err := r.etcd.Do(&req)
+	if isKeyNotFound(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return metadataFromNodes(resp.Node.Nodes), nil
+}
+
+// metadataFromNodes returns the metadata held by the given nodes, each of
+// which is named after a metadata key
+func metadataFromNodes(nodes etcd.Nodes) map[string]string {
+	md := make(map[string]string, len(nodes))
+	for _, n := range nodes {
+		md[path.Base(n.Key)] = n.Value
+	}
+	return md
+}
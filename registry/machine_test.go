@@ -0,0 +1,141 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/machine"
+)
+
+func TestMachinesSchedulability(t *testing.T) {
+	res := &etcd.Result{
+		Node: &etcd.Node{
+			Key: "/fleet/machines",
+			Nodes: etcd.Nodes{
+				{
+					Key: "/fleet/machines/XXX",
+					Nodes: etcd.Nodes{
+						{Key: "/fleet/machines/XXX/object", Value: `{"ID":"XXX"}`},
+						{Key: "/fleet/machines/XXX/schedulability", Value: "draining"},
+					},
+				},
+				{
+					Key: "/fleet/machines/YYY",
+					Nodes: etcd.Nodes{
+						{Key: "/fleet/machines/YYY/object", Value: `{"ID":"YYY"}`},
+					},
+				},
+				{
+					// a cordoned Machine that has since gone away
+					Key: "/fleet/machines/ZZZ",
+					Nodes: etcd.Nodes{
+						{Key: "/fleet/machines/ZZZ/schedulability", Value: "cordoned"},
+					},
+				},
+			},
+		},
+	}
+	r := &EtcdRegistry{etcd: &testEtcdClient{res: []*etcd.Result{res}}, keyPrefix: "/fleet"}
+
+	machines, err := r.Machines()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []machine.MachineState{
+		{ID: "XXX", Schedulability: machine.Draining},
+		{ID: "YYY"},
+	}
+	if !reflect.DeepEqual(want, machines) {
+		t.Errorf("expected %#v, got %#v", want, machines)
+	}
+}
+
+func TestSetMachineSchedulability(t *testing.T) {
+	e := &testEtcdClient{}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet"}
+
+	if err := r.SetMachineSchedulability("XXX", machine.Cordoned); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.SetMachineSchedulability("XXX", machine.Schedulable); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	key := "/fleet/machines/XXX/schedulability"
+	if want := []action{{key: key, val: "cordoned"}}; !reflect.DeepEqual(want, e.sets) {
+		t.Errorf("expected sets %#v, got %#v", want, e.sets)
+	}
+	if want := []action{{key: key}}; !reflect.DeepEqual(want, e.deletes) {
+		t.Errorf("expected deletes %#v, got %#v", want, e.deletes)
+	}
+}
+
+func TestMachinesMetadata(t *testing.T) {
+	res := &etcd.Result{
+		Node: &etcd.Node{
+			Key: "/fleet/machines",
+			Nodes: etcd.Nodes{
+				{
+					Key: "/fleet/machines/XXX",
+					Nodes: etcd.Nodes{
+						{Key: "/fleet/machines/XXX/object", Value: `{"ID":"XXX","Metadata":{"region":"us-east","disk":"hdd"}}`},
+						{
+							Key: "/fleet/machines/XXX/metadata",
+							Nodes: etcd.Nodes{
+								{Key: "/fleet/machines/XXX/metadata/disk", Value: "ssd"},
+								{Key: "/fleet/machines/XXX/metadata/rack", Value: "12"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	r := &EtcdRegistry{etcd: &testEtcdClient{res: []*etcd.Result{res}}, keyPrefix: "/fleet"}
+
+	machines, err := r.Machines()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []machine.MachineState{
+		{ID: "XXX", Metadata: map[string]string{"region": "us-east", "disk": "ssd", "rack": "12"}},
+	}
+	if !reflect.DeepEqual(want, machines) {
+		t.Errorf("expected %#v, got %#v", want, machines)
+	}
+}
+
+func TestSetMachineMetadata(t *testing.T) {
+	e := &testEtcdClient{}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet"}
+
+	if err := r.SetMachineMetadata("XXX", "disk", "ssd"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.SetMachineMetadata("XXX", "disk", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	key := "/fleet/machines/XXX/metadata/disk"
+	if want := []action{{key: key, val: "ssd"}}; !reflect.DeepEqual(want, e.sets) {
+		t.Errorf("expected sets %#v, got %#v", want, e.sets)
+	}
+	if want := []action{{key: key}}; !reflect.DeepEqual(want, e.deletes) {
+		t.Errorf("expected deletes %#v, got %#v", want, e.deletes)
+	}
+}
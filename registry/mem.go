@@ -0,0 +1,192 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/pkg"
+)
+
+// memRegistry is the Registry of the mem backend: a FakeRegistry which
+// emits the same Events upon changes as the etcd backend would, so that
+// the agent and engine act upon them right away
+type memRegistry struct {
+	*FakeRegistry
+	events *memEvents
+}
+
+func newMemRegistry() *memRegistry {
+	return &memRegistry{FakeRegistry: NewFakeRegistry(), events: &memEvents{}}
+}
+
+// notify emits the given Event if err is nil, returning err
+func (m *memRegistry) notify(ev pkg.Event, err error) error {
+	if err == nil {
+		m.events.emit(ev)
+	}
+	return err
+}
+
+func (m *memRegistry) CreateUnit(u *job.Unit) error {
+	return m.notify(JobTargetStateChangeEvent, m.FakeRegistry.CreateUnit(u))
+}
+
+func (m *memRegistry) DestroyUnit(name string) error {
+	return m.notify(JobTargetChangeEvent, m.FakeRegistry.DestroyUnit(name))
+}
+
+func (m *memRegistry) ScheduleUnit(name, machID string) error {
+	return m.notify(JobTargetChangeEvent, m.FakeRegistry.ScheduleUnit(name, machID))
+}
+
+func (m *memRegistry) RescheduleUnit(name, from, to string) error {
+	return m.notify(JobTargetChangeEvent, m.FakeRegistry.RescheduleUnit(name, from, to))
+}
+
+func (m *memRegistry) UnscheduleUnit(name, machID string) error {
+	return m.notify(JobTargetChangeEvent, m.FakeRegistry.UnscheduleUnit(name, machID))
+}
+
+func (m *memRegistry) SetUnitTargetState(name string, state job.JobState) error {
+	return m.notify(JobTargetStateChangeEvent, m.FakeRegistry.SetUnitTargetState(name, state))
+}
+
+// SetMachineState only emits an Event if the state of the Machine has
+// changed, as heartbeats repeatedly publish the same state. Changes to the
+// free resources of the Machine alone, which vary with every heartbeat,
+// do not count.
+func (m *memRegistry) SetMachineState(ms machine.MachineState, ttl time.Duration) (uint64, error) {
+	machines, _ := m.FakeRegistry.Machines()
+	var changed = true
+	for _, prev := range machines {
+		if prev.ID == ms.ID {
+			changed = !reflect.DeepEqual(withoutFreeResources(prev), withoutFreeResources(ms))
+		}
+	}
+
+	idx, err := m.FakeRegistry.SetMachineState(ms, ttl)
+	if changed {
+		m.notify(MachineChangeEvent, err)
+	}
+	return idx, err
+}
+
+func withoutFreeResources(ms machine.MachineState) machine.MachineState {
+	if ms.Resources != nil {
+		ms.Resources = &machine.Resources{Total: ms.Resources.Total}
+	}
+	return ms
+}
+
+func (m *memRegistry) SetMachineSchedulability(machID string, s machine.Schedulability) error {
+	return m.notify(MachineChangeEvent, m.FakeRegistry.SetMachineSchedulability(machID, s))
+}
+
+func (m *memRegistry) SetMachineMetadata(machID, key, value string) error {
+	return m.notify(MachineChangeEvent, m.FakeRegistry.SetMachineMetadata(machID, key, value))
+}
+
+func (m *memRegistry) RemoveMachineState(machID string) error {
+	return m.notify(MachineChangeEvent, m.FakeRegistry.RemoveMachineState(machID))
+}
+
+func (m *memRegistry) CreateRollout(ro *job.Rollout) error {
+	return m.notify(RolloutChangeEvent, m.FakeRegistry.CreateRollout(ro))
+}
+
+func (m *memRegistry) SaveRollout(ro *job.Rollout) error {
+	return m.notify(RolloutChangeEvent, m.FakeRegistry.SaveRollout(ro))
+}
+
+func (m *memRegistry) SetRolloutControl(template string, c job.RolloutControl) error {
+	return m.notify(RolloutChangeEvent, m.FakeRegistry.SetRolloutControl(template, c))
+}
+
+// memEvents hands each Event emitted to the EventStreams waiting for one
+// of its kind. Events emitted while no EventStream is waiting are dropped,
+// which the periodic reconciliation of the agent and engine makes up for.
+type memEvents struct {
+	mu      sync.Mutex
+	waiters []*memWaiter
+}
+
+type memWaiter struct {
+	accept map[pkg.Event]bool
+	ch     chan pkg.Event
+	done   chan struct{}
+}
+
+// stream returns an EventStream emitting the given kinds of Events
+func (me *memEvents) stream(evs ...pkg.Event) pkg.EventStream {
+	accept := make(map[pkg.Event]bool, len(evs))
+	for _, ev := range evs {
+		accept[ev] = true
+	}
+	return &memEventStream{events: me, accept: accept}
+}
+
+func (me *memEvents) emit(ev pkg.Event) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	waiters := me.waiters[:0]
+	for _, w := range me.waiters {
+		if !w.accept[ev] {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- ev
+		close(w.done)
+	}
+	me.waiters = waiters
+}
+
+func (me *memEvents) remove(w *memWaiter) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	for i, o := range me.waiters {
+		if o == w {
+			me.waiters = append(me.waiters[:i], me.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type memEventStream struct {
+	events *memEvents
+	accept map[pkg.Event]bool
+}
+
+func (ms *memEventStream) Next(stop chan struct{}) chan pkg.Event {
+	w := &memWaiter{accept: ms.accept, ch: make(chan pkg.Event, 1), done: make(chan struct{})}
+	ms.events.mu.Lock()
+	ms.events.waiters = append(ms.events.waiters, w)
+	ms.events.mu.Unlock()
+
+	go func() {
+		select {
+		case <-stop:
+			ms.events.remove(w)
+		case <-w.done:
+		}
+	}()
+	return w.ch
+}
@@ -0,0 +1,149 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"errors"
+	"time"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-semver/semver"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/sign"
+	"github.com/coreos/fleet/unit"
+)
+
+// ErrReadOnly is returned by a ReadOnlyRegistry for every change attempted
+var ErrReadOnly = errors.New("registry is read-only")
+
+// ReadOnlyRegistry serves reads from the wrapped Registry, refusing every
+// change with ErrReadOnly. Changes which cannot report an error are
+// dropped.
+type ReadOnlyRegistry struct {
+	Registry
+}
+
+func NewReadOnlyRegistry(reg Registry) *ReadOnlyRegistry {
+	return &ReadOnlyRegistry{Registry: reg}
+}
+
+func (ReadOnlyRegistry) ClearUnitHeartbeat(name string) {}
+
+func (ReadOnlyRegistry) CreateUnit(*job.Unit) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) DestroyUnit(string) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) UnitHeartbeat(name, machID string, ttl time.Duration) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) RemoveMachineState(machID string) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) RemoveUnitState(jobName string) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) SaveUnitState(jobName string, unitState *unit.UnitState, ttl time.Duration) {}
+
+func (ReadOnlyRegistry) ScheduleUnit(name, machID string) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) SetUnitTargetState(name string, state job.JobState) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) SetMachineState(ms machine.MachineState, ttl time.Duration) (uint64, error) {
+	return 0, ErrReadOnly
+}
+
+func (ReadOnlyRegistry) SetMachineSchedulability(machID string, s machine.Schedulability) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) SetMachineMetadata(machID, key, value string) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) SetUnitSignatures(name string, sigs []sign.Signature) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) SetMinimumVersion(v *semver.Version) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) SetUnitHistoryLimit(limit int) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) ScheduleInstances(name string, s job.InstanceSchedule) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) RescheduleUnit(name, from, to string) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) UnscheduleUnit(name, machID string) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) UpdateUnitFile(name string, uf unit.UnitFile) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) CreateRollout(*job.Rollout) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) SaveRollout(*job.Rollout) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) SetRolloutControl(template string, c job.RolloutControl) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) SaveCompletion(name string, c *job.Completion) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) SaveRunHistory(name string, h *job.RunHistory) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) RecordDecision(name string, d job.Decision, limit int) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) SetUnschedulable(name string, u *job.Unschedulable) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) SetEngineStatus(st machine.EngineStatus, ttl time.Duration) error {
+	return ErrReadOnly
+}
+
+func (ReadOnlyRegistry) RemoveEngineStatus(machID string) error {
+	return ErrReadOnly
+}
@@ -15,22 +15,65 @@
 package registry
 
 import (
+	"crypto/cipher"
 	"encoding/json"
 	"fmt"
+	"path"
+	"regexp"
 
 	"github.com/coreos/fleet/etcd"
 )
 
-const DefaultKeyPrefix = "/_coreos.com/fleet/"
+const (
+	DefaultKeyPrefix = "/_coreos.com/fleet/"
+
+	// namespacePrefix holds the keyspaces of all namespaces, keeping
+	// them apart from the data of the default namespace
+	namespacePrefix = "namespaces"
+)
+
+var validNamespace = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// NamespaceKeyPrefix returns the keyspace of the given namespace within
+// the keyPrefix. Each namespace holds a cluster of its own, independent
+// of the others. The empty namespace is the default one, whose keyspace
+// is the keyPrefix itself.
+func NamespaceKeyPrefix(keyPrefix, namespace string) (string, error) {
+	if namespace == "" {
+		return keyPrefix, nil
+	}
+	if !validNamespace.MatchString(namespace) || namespace == "." || namespace == ".." {
+		return "", fmt.Errorf("invalid namespace %q: only alphanumerics, '_', '.' and '-' are allowed", namespace)
+	}
+	return path.Join(keyPrefix, namespacePrefix, namespace) + "/", nil
+}
 
 // EtcdRegistry fulfils the Registry interface and uses etcd as a backend
 type EtcdRegistry struct {
 	etcd      etcd.Client
 	keyPrefix string
+
+	// compressUnits has unit files stored gzipped
+	compressUnits bool
+
+	// unitCipher, if set, encrypts the unit files stored and decrypts
+	// those read
+	unitCipher cipher.AEAD
+
+	// unitNameKey, set along with unitCipher, keys the names of the
+	// unit files stored
+	unitNameKey []byte
 }
 
 func NewEtcdRegistry(client etcd.Client, keyPrefix string) *EtcdRegistry {
-	return &EtcdRegistry{client, keyPrefix}
+	return &EtcdRegistry{etcd: client, keyPrefix: keyPrefix}
+}
+
+// SetUnitCompression determines whether the unit files stored from now on
+// are gzipped. Compressed unit files are read regardless, but only by
+// versions of fleet which support them.
+func (r *EtcdRegistry) SetUnitCompression(compress bool) {
+	r.compressUnits = compress
 }
 
 func marshal(obj interface{}) (string, error) {
@@ -58,3 +101,8 @@ func isNodeExist(err error) bool {
 	e, ok := err.(etcd.Error)
 	return ok && e.ErrorCode == etcd.ErrorNodeExist
 }
+
+func isCompareFailed(err error) bool {
+	e, ok := err.(etcd.Error)
+	return ok && e.ErrorCode == etcd.ErrorCompareFailed
+}
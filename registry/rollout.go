@@ -0,0 +1,273 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	rolloutPrefix        = "rollout"
+	rolloutControlPrefix = "rollout-control"
+)
+
+type rolloutModel struct {
+	Template   string
+	UnitHash   unit.Hash
+	BatchSize  int
+	Timeout    time.Duration
+	Canaries   int
+	Soak       time.Duration
+	Soaking    time.Time
+	Promoted   bool
+	State      job.RolloutState
+	Reason     string
+	Total      int
+	Updated    []string
+	InProgress []string
+	Replaced   map[string]time.Time
+}
+
+func (r *EtcdRegistry) rolloutPath(template string) string {
+	return path.Join(r.keyPrefix, rolloutPrefix, template)
+}
+
+func (r *EtcdRegistry) rolloutControlPath(template string) string {
+	return path.Join(r.keyPrefix, rolloutControlPrefix, template)
+}
+
+// CreateRollout stores a new Rollout in the Registry, replacing any
+// finished Rollout of the same template. An error is returned if a
+// Rollout of the template is already running.
+func (r *EtcdRegistry) CreateRollout(ro *job.Rollout) error {
+	existing, err := r.Rollout(ro.Template)
+	if err != nil {
+		return err
+	}
+	if existing != nil && !existing.Done() {
+		return fmt.Errorf("rollout of %s already in progress", ro.Template)
+	}
+
+	// instructions given to a previous Rollout must not apply to this one
+	req := etcd.Delete{
+		Key: r.rolloutControlPath(ro.Template),
+	}
+	if _, err := r.etcd.Do(&req); err != nil && !isKeyNotFound(err) {
+		return err
+	}
+
+	return r.SaveRollout(ro)
+}
+
+// SaveRollout persists the given Rollout, including its progress. The
+// Control of the Rollout is not saved; see SetRolloutControl.
+func (r *EtcdRegistry) SaveRollout(ro *job.Rollout) error {
+	if err := r.storeOrGetUnitFile(ro.Unit); err != nil {
+		return err
+	}
+
+	rm := rolloutModel{
+		Template:   ro.Template,
+		UnitHash:   ro.Unit.Hash(),
+		BatchSize:  ro.BatchSize,
+		Timeout:    ro.Timeout,
+		Canaries:   ro.Canaries,
+		Soak:       ro.Soak,
+		Soaking:    ro.SoakingSince,
+		Promoted:   ro.Promoted,
+		State:      ro.State,
+		Reason:     ro.Reason,
+		Total:      ro.Total,
+		Updated:    ro.Updated,
+		InProgress: ro.InProgress,
+		Replaced:   ro.Replaced,
+	}
+	json, err := marshal(rm)
+	if err != nil {
+		return err
+	}
+
+	req := etcd.Set{
+		Key:   r.rolloutPath(ro.Template),
+		Value: json,
+	}
+	_, err = r.etcd.Do(&req)
+	return err
+}
+
+// Rollout retrieves the Rollout of the given template from the Registry.
+// Returns nil if no such Rollout exists, and any error encountered.
+func (r *EtcdRegistry) Rollout(template string) (*job.Rollout, error) {
+	req := etcd.Get{
+		Key: r.rolloutPath(template),
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	ro, err := r.nodeToRollout(res.Node)
+	if err != nil {
+		return nil, err
+	}
+
+	req = etcd.Get{
+		Key: r.rolloutControlPath(template),
+	}
+	res, err = r.etcd.Do(&req)
+	if err == nil {
+		ro.Control = job.RolloutControl(res.Node.Value)
+	} else if !isKeyNotFound(err) {
+		return nil, err
+	}
+	return ro, nil
+}
+
+// SetRolloutControl gives an instruction to the running Rollout of the
+// given template. It is stored apart from the Rollout itself, so that it
+// is not overwritten by the engine saving the progress of the Rollout.
+func (r *EtcdRegistry) SetRolloutControl(template string, c job.RolloutControl) error {
+	req := etcd.Set{
+		Key:   r.rolloutControlPath(template),
+		Value: string(c),
+	}
+	_, err := r.etcd.Do(&req)
+	return err
+}
+
+// rolloutControls returns the instructions given to Rollouts, by template
+func (r *EtcdRegistry) rolloutControls() (map[string]job.RolloutControl, error) {
+	req := etcd.Get{
+		Key:       path.Join(r.keyPrefix, rolloutControlPrefix),
+		Recursive: true,
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	controls := make(map[string]job.RolloutControl, len(res.Node.Nodes))
+	for _, node := range res.Node.Nodes {
+		controls[path.Base(node.Key)] = job.RolloutControl(node.Value)
+	}
+	return controls, nil
+}
+
+// Rollouts lists all Rollouts stored in the Registry
+func (r *EtcdRegistry) Rollouts() ([]job.Rollout, error) {
+	req := etcd.Get{
+		Key:       path.Join(r.keyPrefix, rolloutPrefix),
+		Sorted:    true,
+		Recursive: true,
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	controls, err := r.rolloutControls()
+	if err != nil {
+		return nil, err
+	}
+
+	var rollouts []job.Rollout
+	for _, node := range res.Node.Nodes {
+		node := node
+		ro, err := r.nodeToRollout(&node)
+		if err != nil {
+			log.Errorf("Failed parsing Rollout at key %s: %v", node.Key, err)
+			continue
+		}
+		ro.Control = controls[ro.Template]
+		rollouts = append(rollouts, *ro)
+	}
+
+	return rollouts, nil
+}
+
+func (r *EtcdRegistry) nodeToRollout(node *etcd.Node) (*job.Rollout, error) {
+	var rm rolloutModel
+	if err := unmarshal(node.Value, &rm); err != nil {
+		return nil, err
+	}
+
+	uf := r.getUnitByHash(rm.UnitHash)
+	if uf == nil {
+		return nil, errors.New("unable to retrieve unit file of rollout")
+	}
+
+	ro := job.Rollout{
+		Template:     rm.Template,
+		Unit:         *uf,
+		BatchSize:    rm.BatchSize,
+		Timeout:      rm.Timeout,
+		Canaries:     rm.Canaries,
+		Soak:         rm.Soak,
+		SoakingSince: rm.Soaking,
+		Promoted:     rm.Promoted,
+		State:        rm.State,
+		Reason:       rm.Reason,
+		Total:        rm.Total,
+		Updated:      rm.Updated,
+		InProgress:   rm.InProgress,
+		Replaced:     rm.Replaced,
+	}
+	return &ro, nil
+}
+
+// UpdateUnitFile replaces the unit file of an existing Unit, leaving its
+// target state and schedule untouched. Agents running the Unit will
+// notice the change and reload it.
+func (r *EtcdRegistry) UpdateUnitFile(name string, uf unit.UnitFile) error {
+	if err := r.storeOrGetUnitFile(uf); err != nil {
+		return err
+	}
+
+	jm := jobModel{
+		Name:     name,
+		UnitHash: uf.Hash(),
+	}
+	json, err := marshal(jm)
+	if err != nil {
+		return err
+	}
+
+	req := etcd.Update{
+		Key:   path.Join(r.keyPrefix, jobPrefix, name, "object"),
+		Value: json,
+	}
+	_, err = r.etcd.Do(&req)
+	if isKeyNotFound(err) {
+		err = errors.New("job does not exist")
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/log"
+)
+
+// migration upgrades the key layout of a Registry by one version. It must
+// be idempotent, as it is run again if interrupted, and may be run by
+// several fleetds at once.
+type migration struct {
+	// what the migration changes, for logging
+	description string
+	migrate     func(r *EtcdRegistry) error
+}
+
+// migrations upgrade the key layout, the migration at index i upgrading
+// it from version i to i+1. A migration must be appended whenever the
+// layout changes, the number of migrations being the version of the
+// layout written by this build of fleet.
+var migrations = []migration{
+	{
+		// registries written before the schema was versioned already
+		// follow the first layout
+		description: "record the schema version",
+		migrate:     func(*EtcdRegistry) error { return nil },
+	},
+}
+
+// SchemaTooNewError is returned when the Registry holds a key layout
+// newer than this build of fleet understands
+type SchemaTooNewError struct {
+	Version int
+}
+
+func (e SchemaTooNewError) Error() string {
+	return fmt.Sprintf("registry schema version %d is newer than the supported version %d, upgrade fleet", e.Version, len(migrations))
+}
+
+func (r *EtcdRegistry) schemaVersionPath() string {
+	return path.Join(r.keyPrefix, "/schema/version")
+}
+
+// SchemaVersion returns the version of the key layout of the Registry,
+// which is zero if it predates versioning or is empty
+func (r *EtcdRegistry) SchemaVersion() (int, error) {
+	req := etcd.Get{
+		Key: r.schemaVersionPath(),
+	}
+
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return 0, err
+	}
+
+	return strconv.Atoi(res.Node.Value)
+}
+
+// MigrateSchema upgrades the key layout of the Registry in place to the
+// version written by this build of fleet, a migration at a time,
+// recording the version reached after each one. A SchemaTooNewError is
+// returned if the Registry is already beyond that version.
+func (r *EtcdRegistry) MigrateSchema() error {
+	v, err := r.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if v > len(migrations) {
+		return SchemaTooNewError{Version: v}
+	}
+
+	for ; v < len(migrations); v++ {
+		m := migrations[v]
+		log.Infof("Migrating registry schema from version %d to %d: %s", v, v+1, m.description)
+		if err := m.migrate(r); err != nil {
+			return fmt.Errorf("failed migrating registry schema from version %d: %v", v, err)
+		}
+		if err := r.updateSchemaVersion(v, v+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateSchemaVersion compare-and-swaps the schema version from one value
+// to another. Another fleetd having already recorded the new version is
+// not an error.
+func (r *EtcdRegistry) updateSchemaVersion(from, to int) error {
+	var req etcd.Action
+	if from == 0 {
+		req = &etcd.Create{
+			Key:   r.schemaVersionPath(),
+			Value: strconv.Itoa(to),
+		}
+	} else {
+		req = &etcd.Set{
+			Key:           r.schemaVersionPath(),
+			Value:         strconv.Itoa(to),
+			PreviousValue: strconv.Itoa(from),
+		}
+	}
+
+	_, err := r.etcd.Do(req)
+	if err == nil {
+		return nil
+	}
+
+	cur, gerr := r.SchemaVersion()
+	if gerr == nil && cur >= to {
+		return nil
+	}
+	return err
+}
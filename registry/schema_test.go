@@ -0,0 +1,88 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/fleet/etcd"
+)
+
+func TestMigrateSchema(t *testing.T) {
+	var ran []string
+	defer func(orig []migration) { migrations = orig }(migrations)
+	migrations = []migration{
+		{"first", func(*EtcdRegistry) error { ran = append(ran, "first"); return nil }},
+		{"second", func(*EtcdRegistry) error { ran = append(ran, "second"); return nil }},
+	}
+
+	// a pre-versioned registry runs every migration
+	e := &testEtcdClient{
+		err: []error{etcd.Error{ErrorCode: etcd.ErrorKeyNotFound}},
+	}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+	if err := r.MigrateSchema(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"first", "second"}; !reflect.DeepEqual(want, ran) {
+		t.Fatalf("expected migrations %v to run, got %v", want, ran)
+	}
+	if want := []action{{key: "/fleet/schema/version", val: "2"}}; !reflect.DeepEqual(want, e.sets) {
+		t.Fatalf("expected sets %v, got %v", want, e.sets)
+	}
+
+	// while one partially migrated resumes where it stopped
+	ran = nil
+	e = &testEtcdClient{
+		res: []*etcd.Result{{Node: &etcd.Node{Value: "1"}}},
+	}
+	r = &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+	if err := r.MigrateSchema(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"second"}; !reflect.DeepEqual(want, ran) {
+		t.Fatalf("expected migrations %v to run, got %v", want, ran)
+	}
+}
+
+func TestMigrateSchemaFailure(t *testing.T) {
+	defer func(orig []migration) { migrations = orig }(migrations)
+	migrations = []migration{{"fails", func(*EtcdRegistry) error { return errors.New("interrupted") }}}
+
+	e := &testEtcdClient{
+		err: []error{etcd.Error{ErrorCode: etcd.ErrorKeyNotFound}},
+	}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+	if err := r.MigrateSchema(); err == nil {
+		t.Fatalf("expected error from failed migration")
+	}
+	// the version is not recorded, so the migration is run again
+	if len(e.gets) != 1 || len(e.sets) != 0 {
+		t.Fatalf("expected only the version to be read, got gets %v and sets %v", e.gets, e.sets)
+	}
+}
+
+func TestMigrateSchemaTooNew(t *testing.T) {
+	e := &testEtcdClient{
+		res: []*etcd.Result{{Node: &etcd.Node{Value: "99"}}},
+	}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
+	err := r.MigrateSchema()
+	if _, ok := err.(SchemaTooNewError); !ok {
+		t.Fatalf("expected SchemaTooNewError, got %v", err)
+	}
+}
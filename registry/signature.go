@@ -0,0 +1,61 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"path"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/sign"
+)
+
+// the Signatures of a Unit are kept in the directory of its Job, so they
+// are removed along with it
+func (r *EtcdRegistry) signaturesPath(name string) string {
+	return path.Join(r.keyPrefix, jobPrefix, name, "signatures")
+}
+
+func (r *EtcdRegistry) SetUnitSignatures(name string, sigs []sign.Signature) error {
+	json, err := marshal(sigs)
+	if err != nil {
+		return err
+	}
+
+	req := etcd.Set{
+		Key:   r.signaturesPath(name),
+		Value: json,
+	}
+	_, err = r.etcd.Do(&req)
+	return err
+}
+
+func (r *EtcdRegistry) UnitSignatures(name string) ([]sign.Signature, error) {
+	req := etcd.Get{
+		Key: r.signaturesPath(name),
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	var sigs []sign.Signature
+	if err := unmarshal(res.Node.Value, &sigs); err != nil {
+		return nil, err
+	}
+	return sigs, nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/fleet/etcd"
+	"github.com/coreos/fleet/sign"
+)
+
+func TestUnitSignatures(t *testing.T) {
+	sigs := []sign.Signature{{Key: []byte("key"), Format: "ecdsa-sha2-nistp256", Blob: []byte("blob")}}
+
+	e := &testEtcdClient{}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet"}
+	if err := r.SetUnitSignatures("foo.service", sigs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(e.sets) != 1 || e.sets[0].key != "/fleet/job/foo.service/signatures" {
+		t.Fatalf("expected signatures to be set in the Job's directory, got %#v", e.sets)
+	}
+
+	e = &testEtcdClient{res: []*etcd.Result{{Node: &etcd.Node{Value: e.sets[0].val}}}}
+	r.etcd = e
+	got, err := r.UnitSignatures("foo.service")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(sigs, got) {
+		t.Errorf("expected signatures %#v, got %#v", sigs, got)
+	}
+
+	r.etcd = &testEtcdClient{err: []error{etcd.Error{ErrorCode: etcd.ErrorKeyNotFound}}}
+	if got, err := r.UnitSignatures("bar.service"); err != nil || got != nil {
+		t.Errorf("expected no signatures and no error for an unsigned Unit, got %#v, %v", got, err)
+	}
+}
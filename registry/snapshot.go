@@ -0,0 +1,326 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	gsunit "github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-systemd/unit"
+
+	"github.com/coreos/fleet/job"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	// SnapshotVersion is the version of the Snapshot format, raised
+	// whenever a change would prevent older Snapshots being restored.
+	// Version 1 Snapshots hold Units only.
+	SnapshotVersion = 2
+
+	// Kinds of items restored from a Snapshot
+	SnapshotKindUnit    = "unit"
+	SnapshotKindRollout = "rollout"
+	SnapshotKindMachine = "machine"
+)
+
+// Snapshot is a portable representation of the state of a cluster which
+// operators set: the Units with their desired states, the running
+// Rollouts, and the metadata and schedulability set on Machines at
+// runtime. What the cluster derives by itself, such as the schedule and
+// the states of Units, is left out.
+type Snapshot struct {
+	Version  int               `json:"version"`
+	Created  time.Time         `json:"created"`
+	Units    []SnapshotUnit    `json:"units"`
+	Rollouts []SnapshotRollout `json:"rollouts,omitempty"`
+	Machines []SnapshotMachine `json:"machines,omitempty"`
+}
+
+type SnapshotOption struct {
+	Section string `json:"section"`
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+}
+
+type SnapshotUnit struct {
+	Name         string           `json:"name"`
+	Options      []SnapshotOption `json:"options"`
+	DesiredState string           `json:"desiredState"`
+}
+
+// SnapshotRollout holds the definition of a running Rollout. Its progress
+// is not kept, as the engine works it out again from the Units.
+type SnapshotRollout struct {
+	Template  string           `json:"template"`
+	Options   []SnapshotOption `json:"options"`
+	BatchSize int              `json:"batchSize"`
+	Timeout   string           `json:"timeout,omitempty"`
+	Canaries  int              `json:"canaries,omitempty"`
+	Soak      string           `json:"soak,omitempty"`
+	Promoted  bool             `json:"promoted,omitempty"`
+}
+
+// SnapshotMachine holds what was set on a Machine at runtime. The
+// metadata a Machine publishes from its own configuration is left out.
+type SnapshotMachine struct {
+	ID             string            `json:"id"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	Schedulability string            `json:"schedulability,omitempty"`
+}
+
+// RestoreOutcome describes what became of a single item of a Snapshot
+// when it was restored. Skipped explains why an item was left alone,
+// and Error why it could not be restored.
+type RestoreOutcome struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Detail  string `json:"detail,omitempty"`
+	Skipped string `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// TakeSnapshot reads a Snapshot of the cluster from the given Registry
+func TakeSnapshot(reg Registry) (*Snapshot, error) {
+	s := Snapshot{
+		Version: SnapshotVersion,
+		Created: time.Now().UTC(),
+		Units:   []SnapshotUnit{},
+	}
+
+	units, err := reg.Units()
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range units {
+		s.Units = append(s.Units, SnapshotUnit{
+			Name:         u.Name,
+			Options:      snapshotOptions(&u.Unit),
+			DesiredState: string(u.TargetState),
+		})
+	}
+
+	rollouts, err := reg.Rollouts()
+	if err != nil {
+		return nil, err
+	}
+	for _, ro := range rollouts {
+		if ro.Done() {
+			continue
+		}
+		sr := SnapshotRollout{
+			Template:  ro.Template,
+			Options:   snapshotOptions(&ro.Unit),
+			BatchSize: ro.BatchSize,
+			Canaries:  ro.Canaries,
+			Promoted:  ro.Promoted,
+		}
+		if ro.Timeout != 0 {
+			sr.Timeout = ro.Timeout.String()
+		}
+		if ro.Soak != 0 {
+			sr.Soak = ro.Soak.String()
+		}
+		s.Rollouts = append(s.Rollouts, sr)
+	}
+
+	machines, err := reg.Machines()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range machines {
+		md, err := reg.MachineMetadata(m.ID)
+		if err != nil {
+			return nil, err
+		}
+		// maintenance is set by the agent of the Machine itself
+		sched := m.Schedulability
+		if sched == machine.Maintenance {
+			sched = machine.Schedulable
+		}
+		if len(md) == 0 && sched == machine.Schedulable {
+			continue
+		}
+		sm := SnapshotMachine{
+			ID:             m.ID,
+			Schedulability: string(sched),
+		}
+		if len(md) != 0 {
+			sm.Metadata = md
+		}
+		s.Machines = append(s.Machines, sm)
+	}
+
+	return &s, nil
+}
+
+// RestoreSnapshot recreates in the given Registry what the Snapshot holds.
+// Units and Rollouts which already exist are left untouched, so that a
+// restore may be repeated after a failure; their Units are then scheduled
+// afresh. The metadata and schedulability of Machines are set whether or
+// not the Machines are part of the cluster, so that they apply when the
+// Machines rejoin it. An error is returned only if the Snapshot cannot be
+// restored at all; what became of each item is told by the outcomes.
+func RestoreSnapshot(reg Registry, s *Snapshot) ([]RestoreOutcome, error) {
+	if s.Version < 1 || s.Version > SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+
+	var outcomes []RestoreOutcome
+	for _, su := range s.Units {
+		outcomes = append(outcomes, restoreUnit(reg, su))
+	}
+
+	// the Machines of version 1 Snapshots mix runtime metadata with
+	// that of the Machines' configuration, and are not restored
+	if s.Version == 1 {
+		return outcomes, nil
+	}
+
+	for _, sr := range s.Rollouts {
+		outcomes = append(outcomes, restoreRollout(reg, sr))
+	}
+	for _, sm := range s.Machines {
+		outcomes = append(outcomes, restoreMachine(reg, sm))
+	}
+	return outcomes, nil
+}
+
+func restoreUnit(reg Registry, su SnapshotUnit) RestoreOutcome {
+	o := RestoreOutcome{Kind: SnapshotKindUnit, Name: su.Name, Detail: su.DesiredState}
+
+	ts, err := job.ParseJobState(su.DesiredState)
+	if err != nil {
+		o.Error = err.Error()
+		return o
+	}
+	eu, err := reg.Unit(su.Name)
+	if err != nil {
+		o.Error = err.Error()
+		return o
+	}
+	if eu != nil {
+		o.Skipped = "already exists"
+		return o
+	}
+
+	u := job.Unit{
+		Name:        su.Name,
+		Unit:        *unitFromOptions(su.Options),
+		TargetState: ts,
+	}
+	if err := reg.CreateUnit(&u); err != nil {
+		o.Error = err.Error()
+	}
+	return o
+}
+
+func restoreRollout(reg Registry, sr SnapshotRollout) RestoreOutcome {
+	o := RestoreOutcome{Kind: SnapshotKindRollout, Name: sr.Template}
+
+	ro := job.Rollout{
+		Template:  sr.Template,
+		Unit:      *unitFromOptions(sr.Options),
+		BatchSize: sr.BatchSize,
+		Canaries:  sr.Canaries,
+		Promoted:  sr.Promoted,
+		State:     job.RolloutStateRunning,
+	}
+	var err error
+	if sr.Timeout != "" {
+		if ro.Timeout, err = time.ParseDuration(sr.Timeout); err != nil {
+			o.Error = err.Error()
+			return o
+		}
+	}
+	if sr.Soak != "" {
+		if ro.Soak, err = time.ParseDuration(sr.Soak); err != nil {
+			o.Error = err.Error()
+			return o
+		}
+	}
+
+	existing, err := reg.Rollout(sr.Template)
+	if err != nil {
+		o.Error = err.Error()
+		return o
+	}
+	if existing != nil && !existing.Done() {
+		o.Skipped = "already in progress"
+		return o
+	}
+	if err := reg.CreateRollout(&ro); err != nil {
+		o.Error = err.Error()
+	}
+	return o
+}
+
+func restoreMachine(reg Registry, sm SnapshotMachine) RestoreOutcome {
+	o := RestoreOutcome{Kind: SnapshotKindMachine, Name: sm.ID}
+
+	s, ok := machine.ParseSchedulability(sm.Schedulability)
+	if !ok {
+		o.Error = fmt.Sprintf("invalid schedulability %q", sm.Schedulability)
+		return o
+	}
+
+	keys := make([]string, 0, len(sm.Metadata))
+	for k := range sm.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := reg.SetMachineMetadata(sm.ID, k, sm.Metadata[k]); err != nil {
+			o.Error = err.Error()
+			return o
+		}
+	}
+
+	if s != machine.Schedulable {
+		if err := reg.SetMachineSchedulability(sm.ID, s); err != nil {
+			o.Error = err.Error()
+			return o
+		}
+	}
+
+	var detail []string
+	if len(keys) != 0 {
+		detail = append(detail, "metadata "+strings.Join(keys, ","))
+	}
+	if s != machine.Schedulable {
+		detail = append(detail, string(s))
+	}
+	o.Detail = strings.Join(detail, "; ")
+	return o
+}
+
+func snapshotOptions(uf *unit.UnitFile) []SnapshotOption {
+	opts := make([]SnapshotOption, len(uf.Options))
+	for i, opt := range uf.Options {
+		opts[i] = SnapshotOption{Section: opt.Section, Name: opt.Name, Value: opt.Value}
+	}
+	return opts
+}
+
+func unitFromOptions(opts []SnapshotOption) *unit.UnitFile {
+	uopts := make([]*gsunit.UnitOption, len(opts))
+	for i, opt := range opts {
+		uopts[i] = &gsunit.UnitOption{Section: opt.Section, Name: opt.Name, Value: opt.Value}
+	}
+	return unit.NewUnitFromOptions(uopts)
+}
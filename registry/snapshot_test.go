@@ -0,0 +1,75 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/fleet/machine"
+)
+
+func TestTakeSnapshotMachines(t *testing.T) {
+	reg := NewFakeRegistry()
+	reg.SetMachines([]machine.MachineState{
+		{ID: "XXX", Metadata: map[string]string{"region": "us"}},
+		{ID: "YYY"},
+		{ID: "ZZZ", Metadata: map[string]string{"region": "eu"}},
+	})
+	reg.SetMachineMetadata("XXX", "rack", "2")
+	reg.SetMachineSchedulability("YYY", machine.Maintenance)
+	reg.SetMachineSchedulability("ZZZ", machine.Draining)
+
+	snap, err := TakeSnapshot(reg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// configured metadata and maintenance are left out, as the
+	// machines set them by themselves
+	want := []SnapshotMachine{
+		{ID: "XXX", Metadata: map[string]string{"rack": "2"}},
+		{ID: "ZZZ", Schedulability: "draining"},
+	}
+	if !reflect.DeepEqual(want, snap.Machines) {
+		t.Fatalf("expected machines %v, got %v", want, snap.Machines)
+	}
+}
+
+func TestRestoreSnapshotInvalid(t *testing.T) {
+	reg := NewFakeRegistry()
+	if _, err := RestoreSnapshot(reg, &Snapshot{Version: SnapshotVersion + 1}); err == nil {
+		t.Fatalf("expected an unknown version to be refused")
+	}
+
+	snap := Snapshot{
+		Version:  SnapshotVersion,
+		Units:    []SnapshotUnit{{Name: "foo.service", DesiredState: "running"}},
+		Rollouts: []SnapshotRollout{{Template: "bar@.service", BatchSize: 1, Timeout: "soon"}},
+		Machines: []SnapshotMachine{{ID: "XXX", Schedulability: "maintenance"}},
+	}
+	outcomes, err := RestoreSnapshot(reg, &snap)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, o := range outcomes {
+		if o.Error == "" {
+			t.Errorf("expected %s %s not to be restored, got %+v", o.Kind, o.Name, o)
+		}
+	}
+	if units, _ := reg.Units(); len(units) != 0 {
+		t.Errorf("expected no units to be created, got %v", units)
+	}
+}
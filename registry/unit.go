@@ -15,7 +15,21 @@
 package registry
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"path"
+	"strings"
 
 	"github.com/coreos/fleet/etcd"
 	"github.com/coreos/fleet/log"
@@ -24,12 +38,28 @@ import (
 
 const (
 	unitPrefix = "/unit/"
+
+	// unitEncodingGzip marks a unitModel holding its unit file gzipped
+	unitEncodingGzip = "gzip"
+
+	// unitEncodingAESGCM marks a unitModel holding another, serialized
+	// and encrypted with AES-GCM, preceded by the nonce used
+	unitEncodingAESGCM = "aes-gcm"
 )
 
 func (r *EtcdRegistry) storeOrGetUnitFile(u unit.UnitFile) (err error) {
+	name := r.unitFileNames(u.Hash())[0]
 	um := unitModel{
 		Raw: u.String(),
 	}
+	if r.compressUnits {
+		um = compressUnitModel(um)
+	}
+	if r.unitCipher != nil {
+		if um, err = encryptUnitModel(um, r.unitCipher, unitFileAD(name)); err != nil {
+			return err
+		}
+	}
 
 	json, err := marshal(um)
 	if err != nil {
@@ -37,7 +67,7 @@ func (r *EtcdRegistry) storeOrGetUnitFile(u unit.UnitFile) (err error) {
 	}
 
 	req := etcd.Create{
-		Key:   r.hashedUnitPath(u.Hash()),
+		Key:   r.unitFilePath(name),
 		Value: json,
 	}
 	_, err = r.etcd.Do(&req)
@@ -51,8 +81,29 @@ func (r *EtcdRegistry) storeOrGetUnitFile(u unit.UnitFile) (err error) {
 
 // getUnitByHash retrieves from the Registry the Unit associated with the given Hash
 func (r *EtcdRegistry) getUnitByHash(hash unit.Hash) *unit.UnitFile {
+	for _, name := range r.unitFileNames(hash) {
+		req := etcd.Get{
+			Key:       r.unitFilePath(name),
+			Recursive: true,
+		}
+		resp, err := r.etcd.Do(&req)
+		if err != nil {
+			if isKeyNotFound(err) {
+				continue
+			}
+			return nil
+		}
+		return r.parseUnitModel(name, resp.Node.Value)
+	}
+	return nil
+}
+
+// unitFiles retrieves all UnitFiles stored in the Registry in a single
+// request, returning the serialized unitModel of each by the name it is
+// stored under
+func (r *EtcdRegistry) unitFiles() (map[string]string, error) {
 	req := etcd.Get{
-		Key:       r.hashedUnitPath(hash),
+		Key:       path.Join(r.keyPrefix, unitPrefix),
 		Recursive: true,
 	}
 	resp, err := r.etcd.Do(&req)
@@ -60,27 +111,187 @@ func (r *EtcdRegistry) getUnitByHash(hash unit.Hash) *unit.UnitFile {
 		if isKeyNotFound(err) {
 			err = nil
 		}
-		return nil
+		return nil, err
+	}
+
+	files := make(map[string]string, len(resp.Node.Nodes))
+	for _, node := range resp.Node.Nodes {
+		files[path.Base(node.Key)] = node.Value
 	}
+	return files, nil
+}
+
+// parseUnitModel instantiates the UnitFile stored under the given name
+// from its serialized unitModel
+func (r *EtcdRegistry) parseUnitModel(name string, value string) *unit.UnitFile {
 	var um unitModel
-	if err := unmarshal(resp.Node.Value, &um); err != nil {
-		log.Errorf("error unmarshaling Unit(%s): %v", hash, err)
+	if err := unmarshal(value, &um); err != nil {
+		log.Errorf("error unmarshaling Unit(%s): %v", name, err)
+		return nil
+	}
+	raw, err := um.raw(r.unitCipher, unitFileAD(name))
+	if err != nil {
+		log.Errorf("error decoding Unit(%s): %v", name, err)
 		return nil
 	}
 
-	u, err := unit.NewUnitFile(um.Raw)
+	u, err := unit.NewUnitFile(raw)
 	if err != nil {
-		log.Errorf("error parsing Unit(%s): %v", hash, err)
+		log.Errorf("error parsing Unit(%s): %v", name, err)
 		return nil
 	}
 
 	return u
 }
 
-func (r *EtcdRegistry) hashedUnitPath(hash unit.Hash) string {
-	return path.Join(r.keyPrefix, unitPrefix, hash.String())
+// unitFileNames returns the names the unit file of the given Hash may be
+// stored under, the one it is stored under now first. Once a unit
+// encryption key is set, unit files are named by an HMAC of their Hash
+// under that key, so that their names do not tell which unit file they
+// hold; unit files stored before are still found by their Hash.
+func (r *EtcdRegistry) unitFileNames(hash unit.Hash) []string {
+	if r.unitNameKey == nil {
+		return []string{hash.String()}
+	}
+	mac := hmac.New(sha256.New, r.unitNameKey)
+	mac.Write(hash[:])
+	return []string{hex.EncodeToString(mac.Sum(nil)), hash.String()}
+}
+
+// isKeyedUnitFileName determines whether the named unit file was named
+// by unitFileNames using a unit encryption key, rather than by its Hash
+func isKeyedUnitFileName(name string) bool {
+	return len(name) == hex.EncodedLen(sha256.Size)
+}
+
+func (r *EtcdRegistry) unitFilePath(name string) string {
+	return path.Join(r.keyPrefix, unitPrefix, name)
+}
+
+// unitFileAD returns the associated data with which the unit file stored
+// under the given name is encrypted, binding it to that name so that it
+// cannot be passed off as another by moving it
+func unitFileAD(name string) []byte {
+	return []byte(path.Join(unitPrefix, name))
 }
 
 type unitModel struct {
 	Raw string
+
+	// Encoding names how the unit file is encoded in Data, if it is not
+	// held in Raw
+	Encoding string `json:",omitempty"`
+	Data     []byte `json:",omitempty"`
+}
+
+// compressUnitModel gzips the unit file held by the unitModel, unless
+// that would not make it any smaller once serialized
+func compressUnitModel(um unitModel) unitModel {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(um.Raw))
+	if err := w.Close(); err != nil {
+		return um
+	}
+	// Data is serialized in base64, taking 4 bytes for each 3
+	if (buf.Len()+2)/3*4 >= len(um.Raw) {
+		return um
+	}
+	return unitModel{Encoding: unitEncodingGzip, Data: buf.Bytes()}
+}
+
+// encryptUnitModel seals the serialized unitModel into another, so that
+// the unit file it holds cannot be read without the key of the cipher,
+// nor with other associated data than that given
+func encryptUnitModel(um unitModel, aead cipher.AEAD, ad []byte) (unitModel, error) {
+	plain, err := json.Marshal(um)
+	if err != nil {
+		return unitModel{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return unitModel{}, err
+	}
+	return unitModel{Encoding: unitEncodingAESGCM, Data: aead.Seal(nonce, nonce, plain, ad)}, nil
+}
+
+// raw returns the unit file held by the unitModel, decrypting it with the
+// given cipher and associated data if need be
+func (um *unitModel) raw(aead cipher.AEAD, ad []byte) (string, error) {
+	switch um.Encoding {
+	case "":
+		return um.Raw, nil
+	case unitEncodingAESGCM:
+		if aead == nil {
+			return "", errors.New("unit file is encrypted, but no unit encryption key is configured")
+		}
+		if len(um.Data) < aead.NonceSize() {
+			return "", errors.New("encrypted unit file truncated")
+		}
+		nonce, sealed := um.Data[:aead.NonceSize()], um.Data[aead.NonceSize():]
+		plain, err := aead.Open(nil, nonce, sealed, ad)
+		if err != nil {
+			return "", fmt.Errorf("unable to decrypt unit file: %v", err)
+		}
+		var inner unitModel
+		if err := json.Unmarshal(plain, &inner); err != nil {
+			return "", err
+		}
+		if inner.Encoding == unitEncodingAESGCM {
+			return "", errors.New("encrypted unit file nested within another")
+		}
+		return inner.raw(nil, nil)
+	case unitEncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(um.Data))
+		if err != nil {
+			return "", err
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("unknown unit file encoding %q", um.Encoding)
+	}
+}
+
+// SetUnitEncryptionKey has the unit files stored from now on encrypted
+// with AES-GCM using the given 16, 24 or 32 byte key, which is also used
+// to decrypt those read, and to name them. Unit files stored unencrypted
+// are read regardless.
+func (r *EtcdRegistry) SetUnitEncryptionKey(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	r.unitCipher = aead
+
+	// the names of unit files are keyed apart from their contents
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("fleet unit file names"))
+	r.unitNameKey = mac.Sum(nil)
+	return nil
+}
+
+// ReadUnitEncryptionKeyFile reads a unit encryption key from the named
+// file, holding it hex-encoded, e.g. as generated by openssl rand -hex 32
+func ReadUnitEncryptionKeyFile(file string) ([]byte, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid unit encryption key in %s: %v", file, err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("invalid unit encryption key in %s: must be 16, 24 or 32 bytes, not %d", file, len(key))
 }
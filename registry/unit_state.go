@@ -22,6 +22,7 @@ import (
 	"github.com/coreos/fleet/etcd"
 	"github.com/coreos/fleet/log"
 	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/resource"
 	"github.com/coreos/fleet/unit"
 )
 
@@ -122,6 +123,11 @@ func (r *EtcdRegistry) statesByMUSKey() (map[MUSKey]*unit.UnitState, error) {
 	return mus, nil
 }
 
+// UnitState implements the Registry interface
+func (r *EtcdRegistry) UnitState(name, machID string) (*unit.UnitState, error) {
+	return r.getUnitState(name, machID)
+}
+
 // getUnitState retrieves the current UnitState, if any exists, for the
 // given unit that originates from the indicated machine
 func (r *EtcdRegistry) getUnitState(uName, machID string) (*unit.UnitState, error) {
@@ -202,11 +208,17 @@ func (r *EtcdRegistry) RemoveUnitState(jobName string) error {
 }
 
 type unitStateModel struct {
-	LoadState    string                `json:"loadState"`
-	ActiveState  string                `json:"activeState"`
-	SubState     string                `json:"subState"`
-	MachineState *machine.MachineState `json:"machineState"`
-	UnitHash     string                `json:"unitHash"`
+	LoadState       string                  `json:"loadState"`
+	ActiveState     string                  `json:"activeState"`
+	SubState        string                  `json:"subState"`
+	MachineState    *machine.MachineState   `json:"machineState"`
+	UnitHash        string                  `json:"unitHash"`
+	ExitStatus      int                     `json:"exitStatus,omitempty"`
+	ExitTime        *time.Time              `json:"exitTime,omitempty"`
+	Result          string                  `json:"result,omitempty"`
+	StateChangeTime *time.Time              `json:"stateChangeTime,omitempty"`
+	Usage           *resource.ResourceTuple `json:"usage,omitempty"`
+	FailureReason   string                  `json:"failureReason,omitempty"`
 }
 
 func modelToUnitState(usm *unitStateModel, name string) *unit.UnitState {
@@ -215,11 +227,17 @@ func modelToUnitState(usm *unitStateModel, name string) *unit.UnitState {
 	}
 
 	us := unit.UnitState{
-		LoadState:   usm.LoadState,
-		ActiveState: usm.ActiveState,
-		SubState:    usm.SubState,
-		UnitHash:    usm.UnitHash,
-		UnitName:    name,
+		LoadState:       usm.LoadState,
+		ActiveState:     usm.ActiveState,
+		SubState:        usm.SubState,
+		UnitHash:        usm.UnitHash,
+		UnitName:        name,
+		ExitStatus:      usm.ExitStatus,
+		ExitTime:        usm.ExitTime,
+		Result:          usm.Result,
+		StateChangeTime: usm.StateChangeTime,
+		Usage:           usm.Usage,
+		FailureReason:   usm.FailureReason,
 	}
 
 	if usm.MachineState != nil {
@@ -241,10 +259,16 @@ func unitStateToModel(us *unit.UnitState) *unitStateModel {
 	//}
 
 	usm := unitStateModel{
-		LoadState:   us.LoadState,
-		ActiveState: us.ActiveState,
-		SubState:    us.SubState,
-		UnitHash:    us.UnitHash,
+		LoadState:       us.LoadState,
+		ActiveState:     us.ActiveState,
+		SubState:        us.SubState,
+		UnitHash:        us.UnitHash,
+		ExitStatus:      us.ExitStatus,
+		ExitTime:        us.ExitTime,
+		Result:          us.Result,
+		StateChangeTime: us.StateChangeTime,
+		Usage:           us.Usage,
+		FailureReason:   us.FailureReason,
 	}
 
 	if us.MachineID != "" {
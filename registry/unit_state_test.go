@@ -35,6 +35,7 @@ type action struct {
 type testEtcdClient struct {
 	gets    []action
 	sets    []action
+	creates []action
 	deletes []action
 	res     []*etcd.Result // errors returned from subsequent calls to etcd
 	ri      int
@@ -45,6 +46,8 @@ type testEtcdClient struct {
 func (t *testEtcdClient) Do(req etcd.Action) (r *etcd.Result, e error) {
 	if s, ok := req.(*etcd.Set); ok {
 		t.sets = append(t.sets, action{key: s.Key, val: s.Value})
+	} else if c, ok := req.(*etcd.Create); ok {
+		t.creates = append(t.creates, action{key: c.Key, val: c.Value})
 	} else if d, ok := req.(*etcd.Delete); ok {
 		t.deletes = append(t.deletes, action{key: d.Key, rec: d.Recursive})
 	} else if g, ok := req.(*etcd.Get); ok {
@@ -66,7 +69,7 @@ func (t *testEtcdClient) Wait(req etcd.Action, ch <-chan struct{}) (*etcd.Result
 }
 
 func TestUnitStatePaths(t *testing.T) {
-	r := &EtcdRegistry{nil, "/fleet/"}
+	r := &EtcdRegistry{etcd: nil, keyPrefix: "/fleet/"}
 	j := "foo.service"
 	want := "/fleet/state/foo.service"
 	got := r.legacyUnitStatePath(j)
@@ -83,7 +86,7 @@ func TestUnitStatePaths(t *testing.T) {
 
 func TestSaveUnitState(t *testing.T) {
 	e := &testEtcdClient{}
-	r := &EtcdRegistry{e, "/fleet/"}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
 	j := "foo.service"
 	mID := "mymachine"
 	us := unit.NewUnitState("abc", "def", "ghi", mID)
@@ -129,7 +132,7 @@ func TestSaveUnitState(t *testing.T) {
 
 func TestRemoveUnitState(t *testing.T) {
 	e := &testEtcdClient{}
-	r := &EtcdRegistry{e, "/fleet/"}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
 	j := "foo.service"
 	err := r.RemoveUnitState(j)
 	if err != nil {
@@ -162,7 +165,7 @@ func TestRemoveUnitState(t *testing.T) {
 		{[]error{nil, errors.New("ur registry don't work")}, true},
 	} {
 		e = &testEtcdClient{err: tt.errs}
-		r = &EtcdRegistry{e, "/fleet"}
+		r = &EtcdRegistry{etcd: e, keyPrefix: "/fleet"}
 		err = r.RemoveUnitState("foo.service")
 		if (err != nil) != tt.fail {
 			t.Errorf("case %d: unexpected error state calling UnitStates(): got %v, want %v", i, err, tt.fail)
@@ -171,6 +174,7 @@ func TestRemoveUnitState(t *testing.T) {
 }
 
 func TestUnitStateToModel(t *testing.T) {
+	exitTime := time.Unix(1414141414, 0)
 	for i, tt := range []struct {
 		in   *unit.UnitState
 		want *unitStateModel
@@ -233,6 +237,30 @@ func TestUnitStateToModel(t *testing.T) {
 				UnitHash:     "miaow",
 			},
 		},
+		{
+			// the exit of a finished service is retained
+			in: &unit.UnitState{
+				LoadState:       "loaded",
+				ActiveState:     "failed",
+				SubState:        "failed",
+				MachineID:       "woof",
+				UnitName:        "name",
+				ExitStatus:      3,
+				ExitTime:        &exitTime,
+				Result:          "exit-code",
+				StateChangeTime: &exitTime,
+			},
+			want: &unitStateModel{
+				LoadState:       "loaded",
+				ActiveState:     "failed",
+				SubState:        "failed",
+				MachineState:    &machine.MachineState{ID: "woof"},
+				ExitStatus:      3,
+				ExitTime:        &exitTime,
+				Result:          "exit-code",
+				StateChangeTime: &exitTime,
+			},
+		},
 	} {
 		got := unitStateToModel(tt.in)
 		if !reflect.DeepEqual(got, tt.want) {
@@ -242,6 +270,7 @@ func TestUnitStateToModel(t *testing.T) {
 }
 
 func TestModelToUnitState(t *testing.T) {
+	exitTime := time.Unix(1414141414, 0)
 	for i, tt := range []struct {
 		in   *unitStateModel
 		want *unit.UnitState
@@ -251,7 +280,7 @@ func TestModelToUnitState(t *testing.T) {
 			want: nil,
 		},
 		{
-			in: &unitStateModel{"foo", "bar", "baz", nil, ""},
+			in: &unitStateModel{LoadState: "foo", ActiveState: "bar", SubState: "baz"},
 			want: &unit.UnitState{
 				LoadState:   "foo",
 				ActiveState: "bar",
@@ -262,7 +291,7 @@ func TestModelToUnitState(t *testing.T) {
 			},
 		},
 		{
-			in: &unitStateModel{"z", "x", "y", &machine.MachineState{ID: "abcd"}, ""},
+			in: &unitStateModel{LoadState: "z", ActiveState: "x", SubState: "y", MachineState: &machine.MachineState{ID: "abcd"}},
 			want: &unit.UnitState{
 				LoadState:   "z",
 				ActiveState: "x",
@@ -272,6 +301,19 @@ func TestModelToUnitState(t *testing.T) {
 				UnitName:    "name",
 			},
 		},
+		{
+			in: &unitStateModel{LoadState: "z", ActiveState: "x", SubState: "y", ExitStatus: 1, ExitTime: &exitTime, Result: "timeout", StateChangeTime: &exitTime},
+			want: &unit.UnitState{
+				LoadState:       "z",
+				ActiveState:     "x",
+				SubState:        "y",
+				UnitName:        "name",
+				ExitStatus:      1,
+				ExitTime:        &exitTime,
+				Result:          "timeout",
+				StateChangeTime: &exitTime,
+			},
+		},
 	} {
 		got := modelToUnitState(tt.in, "name")
 		if !reflect.DeepEqual(got, tt.want) {
@@ -362,7 +404,7 @@ func TestGetUnitState(t *testing.T) {
 			res: []*etcd.Result{tt.res},
 			err: []error{tt.err},
 		}
-		r := &EtcdRegistry{e, "/fleet/"}
+		r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
 		j := "foo.service"
 		us, err := r.getUnitState(j, "XXX")
 		if tt.wantErr != (err != nil) {
@@ -442,7 +484,7 @@ func TestUnitStates(t *testing.T) {
 	e := &testEtcdClient{
 		res: []*etcd.Result{res2},
 	}
-	r := &EtcdRegistry{e, "/fleet/"}
+	r := &EtcdRegistry{etcd: e, keyPrefix: "/fleet/"}
 
 	got, err := r.UnitStates()
 	if err != nil {
@@ -477,7 +519,7 @@ func TestUnitStates(t *testing.T) {
 		{[]error{errors.New("ur registry don't work")}, true},
 	} {
 		e = &testEtcdClient{err: tt.errs}
-		r = &EtcdRegistry{e, "/fleet"}
+		r = &EtcdRegistry{etcd: e, keyPrefix: "/fleet"}
 		got, err = r.UnitStates()
 		if (err != nil) != tt.fail {
 			t.Errorf("case %d: unexpected error state calling UnitStates(): got %v, want %v", i, err, tt.fail)
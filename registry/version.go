@@ -98,3 +98,42 @@ func (r *EtcdRegistry) UpdateEngineVersion(from, to int) error {
 func (r *EtcdRegistry) engineVersionPath() string {
 	return path.Join(r.keyPrefix, "/engine/version")
 }
+
+// MinimumVersion implements the VersionGateRegistry interface
+func (r *EtcdRegistry) MinimumVersion() (*semver.Version, error) {
+	req := etcd.Get{
+		Key: r.minimumVersionPath(),
+	}
+	res, err := r.etcd.Do(&req)
+	if err != nil {
+		if isKeyNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+	return semver.NewVersion(res.Node.Value)
+}
+
+// SetMinimumVersion implements the VersionGateRegistry interface
+func (r *EtcdRegistry) SetMinimumVersion(v *semver.Version) error {
+	var req etcd.Action
+	if v == nil {
+		req = &etcd.Delete{
+			Key: r.minimumVersionPath(),
+		}
+	} else {
+		req = &etcd.Set{
+			Key:   r.minimumVersionPath(),
+			Value: v.String(),
+		}
+	}
+	_, err := r.etcd.Do(req)
+	if v == nil && isKeyNotFound(err) {
+		err = nil
+	}
+	return err
+}
+
+func (r *EtcdRegistry) minimumVersionPath() string {
+	return path.Join(r.keyPrefix, "/cluster/minimum-version")
+}
@@ -0,0 +1,161 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"path"
+	"strings"
+
+	"github.com/coreos/fleet/etcd"
+)
+
+// Keyspaces of the Registry which may be watched
+const (
+	JobsKeyspace         = jobPrefix
+	MachinesKeyspace     = machinePrefix
+	UnitStatesKeyspace   = "states"
+	RolloutsKeyspace     = rolloutPrefix
+	ResignationsKeyspace = resignedPrefix
+)
+
+// ChangeType identifies what a Change affected
+type ChangeType string
+
+const (
+	// the Machine a Job is scheduled to was set or cleared
+	JobTargetChanged = ChangeType("JobTargetChanged")
+	// the target state of a Job was set or cleared
+	JobTargetStateChanged = ChangeType("JobTargetStateChanged")
+	// a Machine joined the cluster or published different state
+	MachineChanged = ChangeType("MachineChanged")
+	// a Machine left the cluster or its state expired
+	MachineLost = ChangeType("MachineLost")
+	// the state of a Unit on a Machine was published or expired
+	UnitStateUpdated = ChangeType("UnitStateUpdated")
+	// a Rollout was created, progressed or was removed
+	RolloutChanged = ChangeType("RolloutChanged")
+	// an engine resigned a lease
+	EngineResigned = ChangeType("EngineResigned")
+	// changes could not be replayed after a gap in the history of the
+	// Registry, so anything in the keyspace may have changed
+	ChangesMissed = ChangeType("ChangesMissed")
+)
+
+// Change describes a change to the Registry observed by Watch
+type Change struct {
+	Type ChangeType
+	// Key is the key that changed, or the watched keyspace for
+	// ChangesMissed
+	Key string
+	// Name is the Job, Machine, Rollout or lease affected
+	Name string
+	// MachineID is the Machine whose UnitState was updated
+	MachineID string
+	// Index is the index of the change in the Registry
+	Index uint64
+}
+
+// Watch implements the Registry interface
+func (r *EtcdRegistry) Watch(keyspace string, index uint64, stop chan struct{}) <-chan Change {
+	key := path.Join(r.keyPrefix, keyspace)
+	changes := make(chan Change)
+	go func() {
+		defer close(changes)
+		for {
+			c, next, ok := nextChange(r.etcd, r.keyPrefix, key, index, stop)
+			if !ok {
+				return
+			}
+			index = next
+			select {
+			case changes <- c:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return changes
+}
+
+// parseChange returns the Change described by the given etcd Result, if
+// it is of interest. Heartbeats refreshing unchanged state are not.
+func parseChange(res *etcd.Result, prefix string) (c Change, ok bool) {
+	if res == nil || res.Node == nil {
+		return
+	}
+	key := res.Node.Key
+	c = Change{Key: key, Index: res.Node.ModifiedIndex}
+
+	if strings.HasPrefix(key, path.Join(prefix, machinePrefix)) {
+		base := path.Base(key)
+		dir := path.Dir(key)
+		if path.Base(dir) == "metadata" {
+			// metadata set at runtime is keyed by name beneath the
+			// Machine
+			base, dir = "metadata", path.Dir(dir)
+		}
+		if (base != "object" && base != "schedulability" && base != "metadata") || !changedValue(res) {
+			return
+		}
+		c.Name = path.Base(dir)
+		c.Type = MachineChanged
+		if base == "object" && (res.Action == "delete" || res.Action == "expire") {
+			c.Type = MachineLost
+		}
+		return c, true
+	}
+
+	if strings.HasPrefix(key, path.Join(prefix, resignedPrefix)+"/") {
+		// only the recording of a resignation is of interest, not its
+		// expiry
+		if res.Action == "set" || res.Action == "create" {
+			c.Type, c.Name = EngineResigned, path.Base(key)
+			return c, true
+		}
+		return
+	}
+
+	if strings.HasPrefix(key, path.Join(prefix, rolloutPrefix)+"/") {
+		if changedValue(res) {
+			c.Type, c.Name = RolloutChanged, path.Base(key)
+			return c, true
+		}
+		return
+	}
+
+	if rel := strings.TrimPrefix(key, path.Join(prefix, UnitStatesKeyspace)+"/"); rel != key {
+		parts := strings.Split(rel, "/")
+		if len(parts) == 2 && changedValue(res) {
+			c.Type, c.Name, c.MachineID = UnitStateUpdated, parts[0], parts[1]
+			return c, true
+		}
+		return
+	}
+
+	if !strings.HasPrefix(key, path.Join(prefix, jobPrefix)) {
+		return
+	}
+
+	switch path.Base(key) {
+	case "target-state":
+		c.Type = JobTargetStateChanged
+	case "target":
+		c.Type = JobTargetChanged
+	default:
+		return
+	}
+	c.Name = path.Base(path.Dir(key))
+	return c, true
+}
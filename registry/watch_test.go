@@ -0,0 +1,116 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/etcd"
+)
+
+func TestParseChange(t *testing.T) {
+	tests := []struct {
+		action string
+		key    string
+		change *Change
+	}{
+		{"set", "/fleet/job/foo.service/target", &Change{Type: JobTargetChanged, Name: "foo.service"}},
+		{"delete", "/fleet/job/foo.service/target-state", &Change{Type: JobTargetStateChanged, Name: "foo.service"}},
+		{"set", "/fleet/job/foo.service/object", nil},
+		{"create", "/fleet/machines/XXX/object", &Change{Type: MachineChanged, Name: "XXX"}},
+		{"expire", "/fleet/machines/XXX/object", &Change{Type: MachineLost, Name: "XXX"}},
+		{"set", "/fleet/machines/XXX/schedulability", &Change{Type: MachineChanged, Name: "XXX"}},
+		{"delete", "/fleet/machines/XXX/schedulability", &Change{Type: MachineChanged, Name: "XXX"}},
+		{"set", "/fleet/machines/XXX/metadata/region", &Change{Type: MachineChanged, Name: "XXX"}},
+		{"delete", "/fleet/machines/XXX/metadata/region", &Change{Type: MachineChanged, Name: "XXX"}},
+		{"set", "/fleet/machines/XXX/unknown", nil},
+		{"set", "/fleet/states/foo.service/XXX", &Change{Type: UnitStateUpdated, Name: "foo.service", MachineID: "XXX"}},
+		{"expire", "/fleet/states/foo.service/XXX", &Change{Type: UnitStateUpdated, Name: "foo.service", MachineID: "XXX"}},
+		{"set", "/fleet/states/foo.service", nil},
+		{"set", "/fleet/state/foo.service", nil},
+		{"set", "/fleet/rollout/foo@.service", &Change{Type: RolloutChanged, Name: "foo@.service"}},
+		{"set", "/fleet/resigned/engine-leader", &Change{Type: EngineResigned, Name: "engine-leader"}},
+	}
+
+	for i, tt := range tests {
+		res := &etcd.Result{
+			Action: tt.action,
+			Node:   &etcd.Node{Key: tt.key, Value: "A", ModifiedIndex: 3},
+		}
+		c, ok := parseChange(res, "/fleet")
+		if ok != (tt.change != nil) {
+			t.Errorf("case %d: expected ok=%t, got %t", i, tt.change != nil, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		tt.change.Key, tt.change.Index = tt.key, 3
+		if !reflect.DeepEqual(*tt.change, c) {
+			t.Errorf("case %d: expected %#v, got %#v", i, *tt.change, c)
+		}
+	}
+}
+
+func TestEtcdRegistryWatch(t *testing.T) {
+	state := func(idx uint64, value string) *etcd.Result {
+		return &etcd.Result{Action: "set", Node: &etcd.Node{Key: "/fleet/states/foo.service/XXX", Value: value, ModifiedIndex: idx}, PrevNode: &etcd.Node{Value: "A"}}
+	}
+	client := &watchRecorder{
+		// an unchanged state is skipped, and so is the gap in the history
+		results: []*etcd.Result{state(4, "A"), state(5, "B"), nil, state(9, "C")},
+		errs:    []error{nil, nil, etcd.Error{ErrorCode: etcd.ErrorEventIndexCleared}, nil},
+	}
+	r := &EtcdRegistry{etcd: client, keyPrefix: "/fleet"}
+
+	stop := make(chan struct{})
+	changes := r.Watch(UnitStatesKeyspace, 2, stop)
+
+	want := []Change{
+		{Type: UnitStateUpdated, Key: "/fleet/states/foo.service/XXX", Name: "foo.service", MachineID: "XXX", Index: 5},
+		{Type: ChangesMissed, Key: "/fleet/states"},
+		{Type: UnitStateUpdated, Key: "/fleet/states/foo.service/XXX", Name: "foo.service", MachineID: "XXX", Index: 9},
+	}
+	for i, w := range want {
+		select {
+		case c := <-changes:
+			if !reflect.DeepEqual(w, c) {
+				t.Fatalf("change %d: expected %#v, got %#v", i, w, c)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for change %d", i)
+		}
+	}
+
+	// the watch resumes after each result, and from the current index
+	// once the history has been cleared
+	client.Lock()
+	if want := []uint64{2, 5, 6, 0}; !reflect.DeepEqual(want, client.indexes[:4]) {
+		t.Errorf("expected watches from indexes %v, got %v", want, client.indexes)
+	}
+	client.Unlock()
+
+	close(stop)
+	select {
+	case _, ok := <-changes:
+		if ok {
+			t.Fatalf("expected no further changes")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the watch to stop")
+	}
+}
@@ -0,0 +1,461 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rkt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	DefaultStateDirectory = "/run/fleet/rkt/"
+
+	// uuidSuffix is appended to the name of a Unit to name the file
+	// holding the UUID of its pod
+	uuidSuffix = ".uuid"
+
+	// stopPollInterval is how often a stopping pod is checked on
+	stopPollInterval = 500 * time.Millisecond
+)
+
+// restarter is implemented by UnitManagers able to restart a unit in a
+// single operation
+type restarter interface {
+	TriggerRestart(string)
+}
+
+// UnitManager runs the Units declaring an [X-Rkt] section as rkt pods and
+// passes every other Unit on to the wrapped UnitManager. Pods run as child
+// processes of fleetd, and the UUID of the pod of each Unit is kept in a
+// state directory so that pods are tracked across restarts of fleetd.
+type UnitManager struct {
+	unit.UnitManager
+
+	stateDir string
+	// rkt runs rkt with the given arguments to completion, returning
+	// what it printed, and launch starts it without waiting for it
+	rkt    func(args ...string) ([]byte, error)
+	launch func(args ...string) error
+	sleep  func(time.Duration)
+	now    func() time.Time
+
+	mu   sync.Mutex
+	pods map[string]*pod
+}
+
+// pod tracks the pod of a single Unit
+type pod struct {
+	spec *PodSpec
+	hash string
+	// uuid identifies the current pod of the Unit, if any
+	uuid string
+	// exited is when the pod was first seen to have exited
+	exited *time.Time
+}
+
+// NewUnitManager returns a UnitManager running pods with the rkt binary at
+// the given path, picking up the pods of Units loaded before fleetd last
+// started from the state directory
+func NewUnitManager(rktPath, stateDir string, um unit.UnitManager) (*UnitManager, error) {
+	if err := os.MkdirAll(stateDir, os.FileMode(0755)); err != nil {
+		return nil, err
+	}
+
+	m := &UnitManager{
+		UnitManager: um,
+		stateDir:    stateDir,
+		rkt: func(args ...string) ([]byte, error) {
+			out, err := exec.Command(rktPath, args...).Output()
+			if ee, ok := err.(*exec.ExitError); ok {
+				err = fmt.Errorf("rkt %s: %v: %s", args[0], err, bytes.TrimSpace(ee.Stderr))
+			}
+			return out, err
+		},
+		launch: func(args ...string) error {
+			cmd := exec.Command(rktPath, args...)
+			if err := cmd.Start(); err != nil {
+				return err
+			}
+			// the pod is reaped once it exits, its exit status being
+			// read from rkt instead
+			go cmd.Wait()
+			return nil
+		},
+		sleep: time.Sleep,
+		now:   time.Now,
+		pods:  make(map[string]*pod),
+	}
+
+	if err := m.readState(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readState picks up the pods recorded in the state directory
+func (m *UnitManager) readState() error {
+	files, err := ioutil.ReadDir(m.stateDir)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range files {
+		name := fi.Name()
+		if strings.HasSuffix(name, uuidSuffix) {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(path.Join(m.stateDir, name))
+		if err != nil {
+			return err
+		}
+		uf, err := unit.NewUnitFile(string(b))
+		if err != nil {
+			return err
+		}
+		spec, err := ParsePodSpec(*uf)
+		if err != nil || spec == nil {
+			log.Warningf("Ignoring invalid pod of unit %s in %s: %v", name, m.stateDir, err)
+			continue
+		}
+
+		p := &pod{spec: spec, hash: uf.Hash().String()}
+		if b, err := ioutil.ReadFile(path.Join(m.stateDir, name+uuidSuffix)); err == nil {
+			p.uuid = strings.TrimSpace(string(b))
+		}
+		m.pods[name] = p
+	}
+	return nil
+}
+
+func (m *UnitManager) getPod(name string) *pod {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pods[name]
+}
+
+// Load records the pod a Unit runs as. Its pod is only prepared once the
+// Unit is started, so that it runs afresh every time.
+func (m *UnitManager) Load(name string, uf unit.UnitFile) error {
+	spec, err := ParsePodSpec(uf)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, isPod := m.pods[name]
+	if spec == nil {
+		if isPod {
+			m.remove(name)
+		}
+		return m.UnitManager.Load(name, uf)
+	}
+
+	hash := uf.Hash().String()
+	if !isPod {
+		// the Unit may have been handed to the wrapped UnitManager before
+		m.UnitManager.Unload(name)
+	} else if p.hash == hash {
+		p.spec = spec
+		return nil
+	} else {
+		m.remove(name)
+	}
+
+	log.Infof("Writing rkt pod of unit %s", name)
+	if err := ioutil.WriteFile(path.Join(m.stateDir, name), uf.Bytes(), os.FileMode(0644)); err != nil {
+		return err
+	}
+	m.pods[name] = &pod{spec: spec, hash: hash}
+	return nil
+}
+
+// Unload stops and removes the pod of a Unit, if any
+func (m *UnitManager) Unload(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pods[name]; !ok {
+		m.UnitManager.Unload(name)
+		return
+	}
+	m.remove(name)
+}
+
+// remove stops and removes the pod of the named Unit and forgets about
+// it; m.mu must be held
+func (m *UnitManager) remove(name string) {
+	log.Infof("Removing rkt pod of unit %s", name)
+	if p := m.pods[name]; p.uuid != "" {
+		m.stop(name, p)
+		m.gc(p)
+	}
+	os.Remove(path.Join(m.stateDir, name))
+	os.Remove(path.Join(m.stateDir, name+uuidSuffix))
+	delete(m.pods, name)
+}
+
+// gc removes the current pod of a Unit, which must no longer be running
+func (m *UnitManager) gc(p *pod) {
+	if _, err := m.rkt("rm", p.uuid); err != nil {
+		log.Debugf("Failed removing rkt pod %s: %v", p.uuid, err)
+	}
+	p.uuid, p.exited = "", nil
+}
+
+// TriggerStart prepares a new pod for the Unit and runs it, unless its
+// current pod is still running
+func (m *UnitManager) TriggerStart(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.pods[name]
+	if !ok {
+		m.UnitManager.TriggerStart(name)
+		return
+	}
+
+	if p.uuid != "" {
+		if st, err := m.status(p.uuid); err == nil && st.state != "exited" {
+			log.Debugf("rkt pod %s of unit %s already %s", p.uuid, name, st.state)
+			return
+		}
+		m.gc(p)
+	}
+
+	out, err := m.rkt(p.spec.prepareArgs()...)
+	if err != nil {
+		log.Errorf("Failed to prepare rkt pod of unit %s: %v", name, err)
+		return
+	}
+	uuid := lastLine(out)
+	if err := ioutil.WriteFile(path.Join(m.stateDir, name+uuidSuffix), []byte(uuid+"\n"), os.FileMode(0644)); err != nil {
+		log.Errorf("Failed recording rkt pod %s of unit %s: %v", uuid, name, err)
+	}
+	p.uuid, p.exited = uuid, nil
+
+	if err := m.launch(p.spec.runArgs(uuid)...); err != nil {
+		log.Errorf("Failed to run rkt pod %s of unit %s: %v", uuid, name, err)
+		return
+	}
+	log.Infof("Started rkt pod %s of unit %s", uuid, name)
+}
+
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+func (m *UnitManager) TriggerStop(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.pods[name]
+	if !ok {
+		m.UnitManager.TriggerStop(name)
+		return
+	}
+	if p.uuid != "" {
+		m.stop(name, p)
+	}
+}
+
+// stop asks the pod of a Unit to stop, killing it if it has not exited
+// once its StopTimeout has passed; m.mu must be held
+func (m *UnitManager) stop(name string, p *pod) {
+	if st, err := m.status(p.uuid); err != nil || st.state == "exited" {
+		return
+	}
+
+	if _, err := m.rkt("stop", p.uuid); err != nil {
+		log.Errorf("Failed to stop rkt pod %s of unit %s: %v", p.uuid, name, err)
+	}
+	for waited := time.Duration(0); waited < p.spec.StopTimeout; waited += stopPollInterval {
+		if st, err := m.status(p.uuid); err != nil || st.state == "exited" {
+			log.Infof("Stopped rkt pod %s of unit %s", p.uuid, name)
+			return
+		}
+		m.sleep(stopPollInterval)
+	}
+
+	log.Infof("Killing rkt pod %s of unit %s after %v", p.uuid, name, p.spec.StopTimeout)
+	if _, err := m.rkt("stop", "--force", p.uuid); err != nil {
+		log.Errorf("Failed to kill rkt pod %s of unit %s: %v", p.uuid, name, err)
+	}
+}
+
+// TriggerRestart stops the pod of a Unit and starts a new one. Other Units
+// are restarted by the wrapped UnitManager, if it is able to, or else
+// stopped and started again.
+func (m *UnitManager) TriggerRestart(name string) {
+	if m.getPod(name) == nil {
+		if r, ok := m.UnitManager.(restarter); ok {
+			r.TriggerRestart(name)
+			return
+		}
+	}
+	m.TriggerStop(name)
+	m.TriggerStart(name)
+}
+
+func (m *UnitManager) Units() ([]string, error) {
+	units, err := m.UnitManager.Units()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name := range m.pods {
+		units = append(units, name)
+	}
+	sort.Strings(units)
+	return units, nil
+}
+
+func (m *UnitManager) GetUnitState(name string) (*unit.UnitState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.pods[name]
+	if !ok {
+		return m.UnitManager.GetUnitState(name)
+	}
+	return m.podState(p), nil
+}
+
+func (m *UnitManager) GetUnitStates(filter pkg.Set) (map[string]*unit.UnitState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	others := pkg.NewUnsafeSet()
+	for _, name := range filter.Values() {
+		if _, ok := m.pods[name]; !ok {
+			others.Add(name)
+		}
+	}
+
+	states, err := m.UnitManager.GetUnitStates(others)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range filter.Values() {
+		if p, ok := m.pods[name]; ok {
+			states[name] = m.podState(p)
+		}
+	}
+	return states, nil
+}
+
+// podState maps the state of the pod of a Unit onto the states systemd
+// reports for its units; m.mu must be held
+func (m *UnitManager) podState(p *pod) *unit.UnitState {
+	us := unit.UnitState{LoadState: "loaded", ActiveState: "inactive", SubState: "dead", UnitHash: p.hash}
+	if p.uuid == "" {
+		return &us
+	}
+
+	st, err := m.status(p.uuid)
+	if err != nil {
+		log.Debugf("Failed fetching status of rkt pod %s: %v", p.uuid, err)
+		return &us
+	}
+
+	switch st.state {
+	case "running":
+		us.ActiveState, us.SubState = "active", "running"
+	case "exited":
+		if st.exitCode != 0 {
+			us.ActiveState, us.SubState = "failed", "failed"
+		}
+		if p.exited == nil {
+			now := m.now()
+			p.exited = &now
+		}
+		us.ExitStatus = st.exitCode
+		us.ExitTime = p.exited
+	default:
+		// the pod is still being prepared or set up
+		us.ActiveState, us.SubState = "activating", "start"
+	}
+	return &us
+}
+
+// podStatus is the status of a pod as reported by rkt
+type podStatus struct {
+	state string
+	// exitCode is that of the first app of the pod to exit unsuccessfully,
+	// or zero
+	exitCode int
+}
+
+func (m *UnitManager) status(uuid string) (*podStatus, error) {
+	out, err := m.rkt("status", uuid)
+	if err != nil {
+		return nil, err
+	}
+	return parseStatus(out)
+}
+
+// parseStatus parses the key=value lines printed by rkt status, in which
+// the exit code of each app is given by a line of the form app-NAME=CODE
+func parseStatus(out []byte) (*podStatus, error) {
+	var st podStatus
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(s.Text()), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, val := parts[0], parts[1]
+		switch {
+		case key == "state":
+			st.state = val
+		case strings.HasPrefix(key, "app-"):
+			code, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exit code %q of %s", val, key)
+			}
+			if st.exitCode == 0 {
+				st.exitCode = code
+			}
+		}
+	}
+	if st.state == "" {
+		return nil, fmt.Errorf("no state in rkt status output")
+	}
+
+	// pods marked for garbage collection have exited too
+	if st.state == "exited-garbage" || st.state == "garbage" {
+		st.state = "exited"
+	}
+	return &st, nil
+}
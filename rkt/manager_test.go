@@ -0,0 +1,182 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rkt
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/unit"
+)
+
+// fakeRkt stands in for the rkt binary, tracking the state of each pod
+type fakeRkt struct {
+	calls  []string
+	states map[string]string
+	next   int
+}
+
+func (fr *fakeRkt) rkt(args ...string) ([]byte, error) {
+	fr.calls = append(fr.calls, strings.Join(args, " "))
+	uuid := args[len(args)-1]
+	switch args[0] {
+	case "prepare":
+		fr.next++
+		uuid = "pod-" + strconv.Itoa(fr.next)
+		fr.states[uuid] = "state=prepared\n"
+		return []byte(uuid + "\n"), nil
+	case "status":
+		return []byte(fr.states[uuid]), nil
+	case "stop":
+		fr.states[uuid] = "state=exited\napp-redis=143\n"
+	case "rm":
+		delete(fr.states, uuid)
+	}
+	return nil, nil
+}
+
+func (fr *fakeRkt) launch(args ...string) error {
+	fr.calls = append(fr.calls, strings.Join(args, " "))
+	fr.states[args[len(args)-1]] = "state=running\n"
+	return nil
+}
+
+func newTestUnitManager(t *testing.T, dir string, fr *fakeRkt) *UnitManager {
+	m, err := NewUnitManager("/usr/bin/rkt", dir, unit.NewFakeUnitManager())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.rkt, m.launch = fr.rkt, fr.launch
+	m.sleep = func(time.Duration) {}
+	return m
+}
+
+func TestUnitManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fleet-rkt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fr := &fakeRkt{states: map[string]string{}}
+	m := newTestUnitManager(t, dir, fr)
+
+	uf, _ := unit.NewUnitFile("[X-Rkt]\nImage=quay.io/coreos/redis\nNet=host\nInsecure=true\n")
+	if err := m.Load("redis.service", *uf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.Load("plain.service", unit.UnitFile{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	units, _ := m.Units()
+	if want := []string{"plain.service", "redis.service"}; !reflect.DeepEqual(want, units) {
+		t.Errorf("expected units %v, got %v", want, units)
+	}
+
+	us, _ := m.GetUnitState("redis.service")
+	if us.ActiveState != "inactive" || us.UnitHash != uf.Hash().String() {
+		t.Errorf("expected loaded pod to be inactive, got %#v", us)
+	}
+
+	m.TriggerStart("redis.service")
+	states, err := m.GetUnitStates(pkg.NewUnsafeSet("redis.service", "plain.service"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if us := states["redis.service"]; us == nil || us.ActiveState != "active" {
+		t.Errorf("expected started pod to be active, got %#v", us)
+	}
+	if _, ok := states["plain.service"]; !ok {
+		t.Errorf("expected state of plain.service")
+	}
+
+	// starting a running pod does nothing
+	m.TriggerStart("redis.service")
+
+	m.TriggerStop("redis.service")
+	us, _ = m.GetUnitState("redis.service")
+	if us.ActiveState != "failed" || us.ExitStatus != 143 || us.ExitTime == nil {
+		t.Errorf("expected pod killed on stop to be failed, got %#v", us)
+	}
+
+	// a restarted fleetd picks up the pod
+	m2 := newTestUnitManager(t, dir, fr)
+	if p := m2.getPod("redis.service"); p == nil || p.uuid != "pod-1" || p.hash != uf.Hash().String() {
+		t.Errorf("expected pod to be picked up, got %#v", p)
+	}
+
+	// starting the Unit again prepares a new pod
+	m.TriggerStart("redis.service")
+	m.Unload("redis.service")
+
+	want := []string{
+		"prepare --quiet --insecure-options=image quay.io/coreos/redis",
+		"run-prepared --net=host pod-1",
+		"status pod-1",
+		"status pod-1",
+		"status pod-1",
+		"stop pod-1",
+		"status pod-1",
+		"status pod-1",
+		"status pod-1",
+		"rm pod-1",
+		"prepare --quiet --insecure-options=image quay.io/coreos/redis",
+		"run-prepared --net=host pod-2",
+		"status pod-2",
+		"stop pod-2",
+		"status pod-2",
+		"rm pod-2",
+	}
+	if !reflect.DeepEqual(want, fr.calls) {
+		t.Errorf("expected calls:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(fr.calls, "\n"))
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected state directory to be emptied, got %d files", len(files))
+	}
+	if _, err := os.Stat(path.Join(dir, "redis.service")); !os.IsNotExist(err) {
+		t.Errorf("expected unit file to be removed")
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	tests := []struct {
+		out  string
+		want *podStatus
+	}{
+		{"state=running\ncreated=2015-10-15 10:30:00\npid=123\nexited=false\n", &podStatus{state: "running"}},
+		{"state=exited\npid=-1\nexited=true\napp-etcd=0\napp-redis=2\n", &podStatus{state: "exited", exitCode: 2}},
+		{"state=exited-garbage\nexited=true\napp-etcd=0\n", &podStatus{state: "exited"}},
+		{"pid=123\n", nil},
+		{"state=exited\napp-etcd=lots\n", nil},
+	}
+
+	for i, tt := range tests {
+		got, err := parseStatus([]byte(tt.out))
+		if (err != nil) != (tt.want == nil) {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("case %d: expected %#v, got %#v", i, tt.want, got)
+		}
+	}
+}
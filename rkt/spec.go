@@ -0,0 +1,116 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rkt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	// sectionName is the section of a unit file describing the pod a
+	// Unit runs as
+	sectionName = "X-Rkt"
+
+	defaultStopTimeout = 10 * time.Second
+)
+
+// PodSpec describes the rkt pod a Unit runs as
+type PodSpec struct {
+	// Images are run as the apps of the pod, in order
+	Images []string
+	// Volumes are passed to rkt as --volume flags, such as
+	// "data,kind=host,source=/srv/data"
+	Volumes []string
+	Net     string
+	// Insecure skips verifying the signatures of the Images
+	Insecure    bool
+	StopTimeout time.Duration
+}
+
+// ParsePodSpec returns the PodSpec declared by the [X-Rkt] section of the
+// given unit file, or nil if it has no such section
+func ParsePodSpec(uf unit.UnitFile) (*PodSpec, error) {
+	section, ok := uf.Contents[sectionName]
+	if !ok {
+		return nil, nil
+	}
+
+	spec := PodSpec{StopTimeout: defaultStopTimeout}
+	for key, values := range section {
+		if len(values) == 0 {
+			continue
+		}
+		last := values[len(values)-1]
+		switch key {
+		case "Image":
+			spec.Images = values
+		case "Volume":
+			for _, v := range values {
+				if !strings.Contains(v, ",kind=") {
+					return nil, fmt.Errorf("invalid Volume %q, expected NAME,kind=KIND[,...]", v)
+				}
+			}
+			spec.Volumes = values
+		case "Net":
+			spec.Net = last
+		case "Insecure":
+			b, err := strconv.ParseBool(last)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Insecure %q", last)
+			}
+			spec.Insecure = b
+		case "StopTimeout":
+			secs, err := strconv.Atoi(last)
+			if err != nil || secs < 0 {
+				return nil, fmt.Errorf("invalid StopTimeout %q", last)
+			}
+			spec.StopTimeout = time.Duration(secs) * time.Second
+		default:
+			return nil, fmt.Errorf("unrecognized %s option %q", sectionName, key)
+		}
+	}
+
+	if len(spec.Images) == 0 {
+		return nil, errors.New("at least one Image must be set")
+	}
+	return &spec, nil
+}
+
+// prepareArgs returns the arguments to rkt preparing the pod
+func (spec *PodSpec) prepareArgs() []string {
+	args := []string{"prepare", "--quiet"}
+	if spec.Insecure {
+		args = append(args, "--insecure-options=image")
+	}
+	for _, v := range spec.Volumes {
+		args = append(args, "--volume="+v)
+	}
+	return append(args, spec.Images...)
+}
+
+// runArgs returns the arguments to rkt running the prepared pod
+func (spec *PodSpec) runArgs(uuid string) []string {
+	args := []string{"run-prepared"}
+	if spec.Net != "" {
+		args = append(args, "--net="+spec.Net)
+	}
+	return append(args, uuid)
+}
@@ -0,0 +1,64 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rkt
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/unit"
+)
+
+func TestParsePodSpec(t *testing.T) {
+	tests := []struct {
+		contents string
+		want     *PodSpec
+		err      bool
+	}{
+		{"[Service]\nExecStart=/bin/true\n", nil, false},
+		{
+			"[X-Rkt]\nImage=coreos.com/etcd:v2.2.0\nImage=quay.io/coreos/redis\nVolume=data,kind=host,source=/srv\nNet=host\nInsecure=true\nStopTimeout=0\n",
+			&PodSpec{
+				Images:   []string{"coreos.com/etcd:v2.2.0", "quay.io/coreos/redis"},
+				Volumes:  []string{"data,kind=host,source=/srv"},
+				Net:      "host",
+				Insecure: true,
+			},
+			false,
+		},
+		{"[X-Rkt]\nImage=coreos.com/etcd\n", &PodSpec{Images: []string{"coreos.com/etcd"}, StopTimeout: 10 * time.Second}, false},
+		{"[X-Rkt]\nNet=host\n", nil, true},
+		{"[X-Rkt]\nImage=coreos.com/etcd\nVolume=/srv\n", nil, true},
+		{"[X-Rkt]\nImage=coreos.com/etcd\nInsecure=maybe\n", nil, true},
+		{"[X-Rkt]\nImage=coreos.com/etcd\nStopTimeout=-1\n", nil, true},
+		{"[X-Rkt]\nImage=coreos.com/etcd\nExec=/bin/sh\n", nil, true},
+	}
+
+	for i, tt := range tests {
+		uf, err := unit.NewUnitFile(tt.contents)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		spec, err := ParsePodSpec(*uf)
+		if (err != nil) != tt.err {
+			t.Errorf("case %d: expected error %t, got %v", i, tt.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.want, spec) {
+			t.Errorf("case %d: expected %#v, got %#v", i, tt.want, spec)
+		}
+	}
+}
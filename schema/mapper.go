@@ -1,10 +1,13 @@
 package schema
 
 import (
+	"time"
+
 	gsunit "github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-systemd/unit"
 
 	"github.com/coreos/fleet/job"
 	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/resource"
 	"github.com/coreos/fleet/unit"
 )
 
@@ -73,6 +76,12 @@ func MapMachineStateToSchema(ms *machine.MachineState) *Machine {
 	sm := Machine{
 		Id:        ms.ID,
 		PrimaryIP: ms.PublicIP,
+		Version:   ms.Version,
+	}
+
+	if ms.Resources != nil {
+		sm.TotalResources = mapResourcesToSchema(&ms.Resources.Total)
+		sm.FreeResources = mapResourcesToSchema(&ms.Resources.Free)
 	}
 
 	sm.Metadata = make(map[string]string, len(ms.Metadata))
@@ -91,6 +100,14 @@ func MapSchemaToMachineStates(entities []*Machine) []machine.MachineState {
 		ms := machine.MachineState{
 			ID:       me.Id,
 			PublicIP: me.PrimaryIP,
+			Version:  me.Version,
+		}
+
+		if me.TotalResources != nil && me.FreeResources != nil {
+			ms.Resources = &machine.Resources{
+				Total: *mapSchemaToResources(me.TotalResources),
+				Free:  *mapSchemaToResources(me.FreeResources),
+			}
 		}
 
 		ms.Metadata = make(map[string]string, len(me.Metadata))
@@ -121,6 +138,12 @@ func MapUnitStateToSchemaUnitState(entity *unit.UnitState) *UnitState {
 		SystemdLoadState:   entity.LoadState,
 		SystemdActiveState: entity.ActiveState,
 		SystemdSubState:    entity.SubState,
+		FailureReason:      entity.FailureReason,
+		ExitStatus:         int64(entity.ExitStatus),
+		ExitTime:           mapTimeToSchemaTime(entity.ExitTime),
+		Result:             entity.Result,
+		StateChangeTime:    mapTimeToSchemaTime(entity.StateChangeTime),
+		Usage:              mapResourcesToSchema(entity.Usage),
 	}
 
 	return &us
@@ -130,18 +153,66 @@ func MapSchemaUnitStatesToUnitStates(entities []*UnitState) []*unit.UnitState {
 	us := make([]*unit.UnitState, len(entities))
 	for i, e := range entities {
 		us[i] = &unit.UnitState{
-			UnitName:    e.Name,
-			UnitHash:    e.Hash,
-			MachineID:   e.MachineID,
-			LoadState:   e.SystemdLoadState,
-			ActiveState: e.SystemdActiveState,
-			SubState:    e.SystemdSubState,
+			UnitName:        e.Name,
+			UnitHash:        e.Hash,
+			MachineID:       e.MachineID,
+			LoadState:       e.SystemdLoadState,
+			ActiveState:     e.SystemdActiveState,
+			SubState:        e.SystemdSubState,
+			FailureReason:   e.FailureReason,
+			ExitStatus:      int(e.ExitStatus),
+			ExitTime:        mapSchemaTimeToTime(e.ExitTime),
+			Result:          e.Result,
+			StateChangeTime: mapSchemaTimeToTime(e.StateChangeTime),
+			Usage:           mapSchemaToResources(e.Usage),
 		}
 	}
 
 	return us
 }
 
+// mapTimeToSchemaTime formats the given time as RFC 3339, the format of
+// date-time properties, leaving an unknown time empty
+func mapTimeToSchemaTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// mapSchemaTimeToTime parses a date-time property, returning nil if it is
+// empty or invalid
+func mapSchemaTimeToTime(s string) *time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// mapResourcesToSchema maps the given ResourceTuple, which may be nil
+func mapResourcesToSchema(rt *resource.ResourceTuple) *Resources {
+	if rt == nil {
+		return nil
+	}
+	return &Resources{
+		Cores:  int64(rt.Cores),
+		Memory: int64(rt.Memory),
+		Disk:   int64(rt.Disk),
+	}
+}
+
+func mapSchemaToResources(r *Resources) *resource.ResourceTuple {
+	if r == nil {
+		return nil
+	}
+	return &resource.ResourceTuple{
+		Cores:  int(r.Cores),
+		Memory: int(r.Memory),
+		Disk:   int(r.Disk),
+	}
+}
+
 func MapSchemaUnitToScheduledUnit(entity *Unit) *job.ScheduledUnit {
 	cs := job.JobState(entity.CurrentState)
 	return &job.ScheduledUnit{
@@ -90,11 +90,17 @@ type UnitsService struct {
 }
 
 type Machine struct {
+	FreeResources *Resources `json:"freeResources,omitempty"`
+
 	Id string `json:"id,omitempty"`
 
 	Metadata map[string]string `json:"metadata,omitempty"`
 
 	PrimaryIP string `json:"primaryIP,omitempty"`
+
+	TotalResources *Resources `json:"totalResources,omitempty"`
+
+	Version string `json:"version,omitempty"`
 }
 
 type MachinePage struct {
@@ -103,6 +109,14 @@ type MachinePage struct {
 	NextPageToken string `json:"nextPageToken,omitempty"`
 }
 
+type Resources struct {
+	Cores int64 `json:"cores,omitempty"`
+
+	Disk int64 `json:"disk,omitempty"`
+
+	Memory int64 `json:"memory,omitempty"`
+}
+
 type Unit struct {
 	CurrentState string `json:"currentState,omitempty"`
 
@@ -130,17 +144,29 @@ type UnitPage struct {
 }
 
 type UnitState struct {
+	ExitStatus int64 `json:"exitStatus,omitempty"`
+
+	ExitTime string `json:"exitTime,omitempty"`
+
+	FailureReason string `json:"failureReason,omitempty"`
+
 	Hash string `json:"hash,omitempty"`
 
 	MachineID string `json:"machineID,omitempty"`
 
 	Name string `json:"name,omitempty"`
 
+	Result string `json:"result,omitempty"`
+
+	StateChangeTime string `json:"stateChangeTime,omitempty"`
+
 	SystemdActiveState string `json:"systemdActiveState,omitempty"`
 
 	SystemdLoadState string `json:"systemdLoadState,omitempty"`
 
 	SystemdSubState string `json:"systemdSubState,omitempty"`
+
+	Usage *Resources `json:"usage,omitempty"`
 }
 
 type UnitStatePage struct {
@@ -43,6 +43,15 @@ const DiscoveryJSON = `{
           "additionalProperties": {
             "type": "string"
           }
+        },
+        "version": {
+          "type": "string"
+        },
+        "totalResources": {
+          "$ref": "Resources"
+        },
+        "freeResources": {
+          "$ref": "Resources"
         }
       }
     },
@@ -61,6 +70,24 @@ const DiscoveryJSON = `{
         }
       }
     },
+    "Resources": {
+      "id": "Resources",
+      "type": "object",
+      "properties": {
+        "cores": {
+          "type": "integer",
+          "format": "int32"
+        },
+        "memory": {
+          "type": "integer",
+          "format": "int32"
+        },
+        "disk": {
+          "type": "integer",
+          "format": "int32"
+        }
+      }
+    },
     "UnitOption": {
       "id": "UnitOption",
       "type": "object",
@@ -147,6 +174,27 @@ const DiscoveryJSON = `{
         },
         "systemdSubState": {
           "type": "string"
+        },
+        "failureReason": {
+          "type": "string"
+        },
+        "exitStatus": {
+          "type": "integer",
+          "format": "int32"
+        },
+        "exitTime": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "result": {
+          "type": "string"
+        },
+        "stateChangeTime": {
+          "type": "string",
+          "format": "date-time"
+        },
+        "usage": {
+          "$ref": "Resources"
         }
       }
     },
@@ -17,7 +17,10 @@ package server
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"path"
+	"reflect"
 	"time"
 
 	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-systemd/activation"
@@ -25,13 +28,15 @@ import (
 	"github.com/coreos/fleet/agent"
 	"github.com/coreos/fleet/api"
 	"github.com/coreos/fleet/config"
+	"github.com/coreos/fleet/docker"
 	"github.com/coreos/fleet/engine"
-	"github.com/coreos/fleet/etcd"
 	"github.com/coreos/fleet/heart"
 	"github.com/coreos/fleet/log"
 	"github.com/coreos/fleet/machine"
 	"github.com/coreos/fleet/pkg"
 	"github.com/coreos/fleet/registry"
+	"github.com/coreos/fleet/rkt"
+	"github.com/coreos/fleet/sign"
 	"github.com/coreos/fleet/systemd"
 	"github.com/coreos/fleet/unit"
 	"github.com/coreos/fleet/version"
@@ -41,64 +46,273 @@ const (
 	// machineStateRefreshInterval is the amount of time the server will
 	// wait before each attempt to refresh the local machine state
 	machineStateRefreshInterval = time.Minute
+
+	// ShutdownModeUnload has the agent stop and unload its units when
+	// fleetd shuts down, such as when decommissioning a machine
+	ShutdownModeUnload = "unload"
+	// ShutdownModeKeep leaves the units of the agent running when fleetd
+	// shuts down, such as when upgrading fleetd
+	ShutdownModeKeep = "keep"
 )
 
+// ErrRestartRequired is returned by Reconfigure when options have changed
+// which can only be applied by creating a new Server
+var ErrRestartRequired = errors.New("configuration change requires restarting server components")
+
 type Server struct {
 	agent       *agent.Agent
 	aReconciler *agent.AgentReconciler
 	usPub       *agent.UnitStatePublisher
 	usGen       *unit.UnitStateGenerator
+	health      *agent.HealthMonitor
+	retrier     *agent.StartRetrier
+	usage       *agent.UsageSampler
+	logs        *agent.LogForwarder
+	maint       *agent.MaintenanceMonitor
 	engine      *engine.Engine
 	mach        *machine.CoreOSMachine
 	hrt         heart.Heart
 	mon         *heart.Monitor
 	api         *api.Server
+	cache       *registry.CachedRegistry
+	schema      registry.SchemaRegistry
+	endpoints   registry.EndpointSetter
+	readOnly    bool
+
+	// cfg is the configuration last applied to the Server
+	cfg config.Config
+
+	shutdownMode    string
+	shutdownTimeout time.Duration
+	usageInterval   time.Duration
+
+	// watchdogInterval is the interval within which systemd expects a
+	// keepalive from fleetd, or zero if it does not watch it
+	watchdogInterval time.Duration
 
 	engineReconcileInterval time.Duration
+	engineReconcileJitter   time.Duration
+	engineLeaseTTL          time.Duration
+	engineLeaseRenewal      time.Duration
 
 	stop chan bool
 }
 
 func New(cfg config.Config) (*Server, error) {
-	etcdRequestTimeout := time.Duration(cfg.EtcdRequestTimeout*1000) * time.Millisecond
-	agentTTL, err := time.ParseDuration(cfg.AgentTTL)
+	agentTTL, agentIval, err := agentTimings(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	mgr, err := systemd.NewSystemdUnitManager(systemd.DefaultUnitsDirectory)
+	weights, err := engine.ParseScorerWeights(cfg.EngineScorerWeights)
 	if err != nil {
 		return nil, err
 	}
 
-	mach, err := newMachineFromConfig(cfg, mgr)
+	if cfg.ShutdownMode != ShutdownModeUnload && cfg.ShutdownMode != ShutdownModeKeep {
+		return nil, fmt.Errorf("invalid shutdown mode %q, must be %q or %q", cfg.ShutdownMode, ShutdownModeUnload, ShutdownModeKeep)
+	}
+
+	eIval := time.Duration(cfg.EngineReconcileInterval*1000) * time.Millisecond
+	eJitter := time.Duration(cfg.EngineReconcileJitter*1000) * time.Millisecond
+	eLeaseTTL := time.Duration(cfg.EngineLeaseTTL*1000) * time.Millisecond
+	eLeaseRenewal := time.Duration(cfg.EngineLeaseRenewal*1000) * time.Millisecond
+	if err := engine.ValidateLeaseTimings(eIval, eJitter, eLeaseTTL, eLeaseRenewal); err != nil {
+		return nil, err
+	}
+
+	if cfg.UnitSlice != "" {
+		if err := systemd.ValidSlice(cfg.UnitSlice); err != nil {
+			return nil, err
+		}
+	}
+
+	newManager, uDir := systemd.NewSystemdUnitManager, systemd.DefaultUnitsDirectory
+	if cfg.SystemdUser {
+		newManager, uDir = systemd.NewSystemdUserUnitManager, systemd.UserUnitsDirectory()
+	}
+	mgr, err := newManager(uDir)
 	if err != nil {
 		return nil, err
 	}
+	if err := mgr.SetSlice(cfg.UnitSlice); err != nil {
+		return nil, err
+	}
 
-	tlsConfig, err := pkg.ReadTLSConfigFiles(cfg.EtcdCAFile, cfg.EtcdCertFile, cfg.EtcdKeyFile)
+	mach, err := newMachineFromConfig(cfg, mgr)
 	if err != nil {
 		return nil, err
 	}
 
-	eTrans := &http.Transport{TLSClientConfig: tlsConfig}
-	eClient, err := etcd.NewClient(cfg.EtcdServers, eTrans, etcdRequestTimeout)
+	backend, err := registry.NewBackend(cfg.RegistryURL, cfg)
 	if err != nil {
 		return nil, err
 	}
+	reg := backend.Registry
+	schema, _ := reg.(registry.SchemaRegistry)
+
+	var audit registry.AuditRegistry
+	if cfg.AuditLog {
+		var ok bool
+		if audit, ok = reg.(registry.AuditRegistry); !ok {
+			return nil, fmt.Errorf("registry backend %q does not support the audit log", cfg.RegistryURL)
+		}
+	}
+	// a dry run observes the cluster just as a read-only fleetd does
+	readOnly := cfg.ReadOnly || cfg.DryRun
+	if readOnly {
+		reg = registry.NewReadOnlyRegistry(reg)
+	}
+
+	// a configured history limit is published to the cluster, so that
+	// every client changing Units records Revisions alike
+	if cfg.UnitHistoryLimit > 0 && !readOnly {
+		if err := reg.SetUnitHistoryLimit(cfg.UnitHistoryLimit); err != nil {
+			log.Errorf("Failed publishing unit history limit: %v", err)
+		}
+	}
+
+	// units declaring a container run directly through the Docker API,
+	// those declaring a pod through rkt, and all others through systemd
+	var um unit.UnitManager = mgr
+	if cfg.DockerEndpoint != "" {
+		if um, err = docker.NewUnitManager(cfg.DockerEndpoint, um); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.RktPath != "" {
+		if um, err = rkt.NewUnitManager(cfg.RktPath, rkt.DefaultStateDirectory, um); err != nil {
+			return nil, err
+		}
+	}
 
-	reg := registry.NewEtcdRegistry(eClient, cfg.EtcdKeyPrefix)
+	// units failing validation, lacking a trusted signature if required
+	// or violating the unit policy are reported failed rather than loaded
+	validator := agent.NewUnitValidator(um)
+	var sigVerifier *agent.SignatureVerifier
+	if cfg.VerifyUnits {
+		if cfg.AuthorizedKeysFile == "" {
+			return nil, errors.New("verify_units requires authorized_keys_file")
+		}
+		verifier, err := sign.NewVerifierFromAuthorizedKeysFile(cfg.AuthorizedKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		sigVerifier = agent.NewSignatureVerifier(verifier)
+		validator.SetVerifier(sigVerifier.Verify)
+	}
+	if cfg.UnitPolicyFile != "" {
+		policy, err := agent.NewUnitPolicyFromFile(cfg.UnitPolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		validator.SetPolicy(policy)
+	}
+	um = validator
+
+	// units failing once started are restarted with a backoff, and
+	// reported failed once they kept failing
+	if cfg.UnitStartRetries < 0 {
+		return nil, errors.New("unit_start_retries must not be negative")
+	}
+	if cfg.UnitStartRetryBackoff <= 0 {
+		return nil, errors.New("unit_start_retry_backoff must be positive")
+	}
+	retrier := agent.NewStartRetrier(um, cfg.UnitStartRetries, time.Duration(cfg.UnitStartRetryBackoff*float64(time.Second)))
+	um = retrier
+
+	// units are restarted, and eventually reported failed, by the agent
+	// when their health checks fail
+	hm := agent.NewHealthMonitor(um)
+
+	// operator-provided hooks run around the lifecycle operations the
+	// agent carries out, but not around restarts by the HealthMonitor
+	um = hm
+	if cfg.UnitHooksDir != "" {
+		um = agent.NewHookRunner(hm, cfg.UnitHooksDir, mach)
+	}
 
 	pub := agent.NewUnitStatePublisher(reg, mach, agentTTL)
-	gen := unit.NewUnitStateGenerator(mgr)
+	pub.SetPublishInterval(agentIval)
+	if cfg.UnitStateBatchWindow < 0 {
+		return nil, errors.New("unit_state_batch_window must not be negative")
+	}
+	pub.SetBatchWindow(time.Duration(cfg.UnitStateBatchWindow*1000) * time.Millisecond)
 
-	a := agent.New(mgr, gen, reg, mach, agentTTL)
+	var usage *agent.UsageSampler
+	if cfg.UsageInterval > 0 {
+		usage = agent.NewUsageSampler(um, agent.DefaultCgroupRoot)
+		pub.SetUsageSampler(usage)
+	}
+	gen := unit.NewUnitStateGenerator(hm)
 
-	rStream := registry.NewEtcdEventStream(eClient, cfg.EtcdKeyPrefix)
+	var logs *agent.LogForwarder
+	if cfg.UnitLogSink != "" {
+		sink, err := agent.NewLogSink(cfg.UnitLogSink)
+		if err != nil {
+			return nil, err
+		}
+		logs = agent.NewLogForwarder(um, sink, mach.State().ID)
+		logs.SetUserUnits(cfg.SystemdUser)
+	}
 
-	ar := agent.NewReconciler(reg, rStream)
+	var maint *agent.MaintenanceMonitor
+	if cfg.MaintenanceWindows != "" {
+		windows, err := machine.ParseMaintenanceWindows(cfg.MaintenanceWindows)
+		if err != nil {
+			return nil, err
+		}
+		maint = agent.NewMaintenanceMonitor(reg, mach, windows)
+	}
+
+	a := agent.New(um, gen, reg, mach, agentTTL)
+	a.SetHeartbeatInterval(agentIval)
+
+	ar := agent.NewReconciler(reg, backend.Events)
+	ar.SetObserveOnly(readOnly)
+	if cfg.DryRun {
+		ar.SetLoadCheck(validator.Check)
+	}
+	if cfg.AgentTaskWorkers < 1 {
+		return nil, errors.New("agent_task_workers must be at least 1")
+	}
+	ar.SetTaskWorkers(cfg.AgentTaskWorkers)
+	if sigVerifier != nil {
+		ar.SetSignatureVerifier(sigVerifier)
+	}
+	if cfg.AgentStateFile != "" && !cfg.DryRun {
+		// a dry run leaves the state file to the fleetd it stands in for
+		stateFile := cfg.AgentStateFile
+		if cfg.SystemdUser && stateFile == agent.DefaultStateFile {
+			// an unprivileged user cannot write to /run/fleet
+			stateFile = path.Join(systemd.UserRuntimeDirectory(), "fleet", path.Base(stateFile))
+		}
+		ar.SetStateFile(stateFile)
+	}
+	ar.OnResync(pub.Republish)
+
+	eBackend := backend
+	if audit != nil {
+		audited := *backend
+		audited.Registry = registry.NewAuditedRegistry(backend.Registry, audit, mach.State().ID, "engine")
+		eBackend = &audited
+	}
 
-	e := engine.New(reg, rStream, mach)
+	e := engine.New(eBackend, mach, engine.Config{
+		ReconcileDebounce: time.Duration(cfg.EngineReconcileDebounce*1000) * time.Millisecond,
+		RescheduleGrace:   time.Duration(cfg.EngineRescheduleGrace*1000) * time.Millisecond,
+		ResyncInterval:    time.Duration(cfg.EngineResyncInterval*1000) * time.Millisecond,
+		MaxSchedule:       cfg.EngineMaxSchedule,
+		Shards:            cfg.EngineShards,
+		MaxUnits:          cfg.MaxUnitsPerMachine,
+		RebalanceInterval: time.Duration(cfg.EngineRebalanceInterval*1000) * time.Millisecond,
+		RebalanceBy:       cfg.EngineRebalanceBy,
+		RebalanceMoves:    cfg.EngineRebalanceMoves,
+		ScorerWeights:     weights,
+		DecisionHistory:   cfg.EngineDecisionHistory,
+		GCInterval:        time.Duration(cfg.EngineGCInterval*1000) * time.Millisecond,
+		ReconcileWorkers:  cfg.EngineReconcileWorkers,
+	})
 
 	listeners, err := activation.Listeners(false)
 	if err != nil {
@@ -106,34 +320,88 @@ func New(cfg config.Config) (*Server, error) {
 	}
 
 	hrt := heart.New(reg, mach)
-	mon := heart.NewMonitor(agentTTL)
+	mon := heart.NewMonitor(agentTTL, agentIval)
+
+	// the API serves reads from a cache, if enabled, as clients may poll
+	// it for the whole cluster
+	apiReg := reg
+	var cache *registry.CachedRegistry
+	if cfg.RegistryCacheMaxAge > 0 {
+		cache = registry.NewCachedRegistry(reg, backend.CacheEvents, time.Duration(cfg.RegistryCacheMaxAge*1000)*time.Millisecond)
+		apiReg = cache
+	}
+	if audit != nil {
+		apiReg = registry.NewAuditedRegistry(apiReg, audit, mach.State().ID, "api")
+	}
 
-	apiServer := api.NewServer(listeners, api.NewServeMux(reg))
+	var hdlr http.Handler = api.NewServeMux(apiReg, cfg.MaxUnitsPerMachine, weights)
+	if readOnly {
+		hdlr = api.ReadOnly(hdlr)
+	}
+
+	apiServer := api.NewServer(listeners, hdlr)
 	apiServer.Serve()
 
-	eIval := time.Duration(cfg.EngineReconcileInterval*1000) * time.Millisecond
+	wdIval, err := pkg.SdWatchdogInterval()
+	if err != nil {
+		log.Warningf("Ignoring systemd watchdog: %v", err)
+	}
 
 	srv := Server{
 		agent:       a,
 		aReconciler: ar,
 		usGen:       gen,
 		usPub:       pub,
+		health:      hm,
+		retrier:     retrier,
+		usage:       usage,
+		logs:        logs,
+		maint:       maint,
 		engine:      e,
 		mach:        mach,
 		hrt:         hrt,
 		mon:         mon,
 		api:         apiServer,
+		cache:       cache,
+		schema:      schema,
+		endpoints:   backend.Endpoints,
+		readOnly:    readOnly,
+		cfg:         cfg,
 		stop:        nil,
+		shutdownMode:            cfg.ShutdownMode,
+		shutdownTimeout:         time.Duration(cfg.ShutdownTimeout*1000) * time.Millisecond,
+		usageInterval:           time.Duration(cfg.UsageInterval*1000) * time.Millisecond,
+		watchdogInterval:        wdIval,
 		engineReconcileInterval: eIval,
+		engineReconcileJitter:   eJitter,
+		engineLeaseTTL:          eLeaseTTL,
+		engineLeaseRenewal:      eLeaseRenewal,
 	}
 
 	return &srv, nil
 }
 
+// agentTimings returns the TTL and heartbeat interval of the agent given
+// by the configuration
+func agentTimings(cfg config.Config) (ttl, ival time.Duration, err error) {
+	if ttl, err = time.ParseDuration(cfg.AgentTTL); err != nil {
+		return
+	}
+	ival = ttl / 2
+	if cfg.AgentHeartbeatInterval != "" {
+		if ival, err = time.ParseDuration(cfg.AgentHeartbeatInterval); err != nil {
+			return
+		}
+	}
+	err = agent.ValidateHeartbeat(ttl, ival)
+	return
+}
+
 func newMachineFromConfig(cfg config.Config, mgr unit.UnitManager) (*machine.CoreOSMachine, error) {
 	state := machine.MachineState{
 		PublicIP: cfg.PublicIP,
 		Metadata: cfg.Metadata(),
+		Taints:   cfg.Taints(),
 		Version:  version.Version,
 	}
 
@@ -148,6 +416,11 @@ func newMachineFromConfig(cfg config.Config, mgr unit.UnitManager) (*machine.Cor
 }
 
 func (s *Server) Run() {
+	if s.readOnly {
+		s.runReadOnly()
+		return
+	}
+
 	log.Infof("Establishing etcd connectivity")
 
 	var err error
@@ -159,20 +432,73 @@ func (s *Server) Run() {
 		time.Sleep(sleep)
 	}
 
+	if s.schema != nil {
+		log.Infof("Checking registry schema")
+		for sleep := time.Second; ; sleep = pkg.ExpBackoff(sleep, time.Minute) {
+			err = s.schema.MigrateSchema()
+			if err == nil {
+				break
+			}
+			if _, ok := err.(registry.SchemaTooNewError); ok {
+				log.Fatalf("Unable to use registry: %v", err)
+			}
+			log.Errorf("Failed migrating registry schema: %v", err)
+			time.Sleep(sleep)
+		}
+	}
+
 	log.Infof("Starting server components")
 
 	s.stop = make(chan bool)
 
 	go s.Monitor()
+	if s.cache != nil {
+		go s.cache.Run(s.stop)
+	}
 	go s.api.Available(s.stop)
 	go s.mach.PeriodicRefresh(machineStateRefreshInterval, s.stop)
 	go s.agent.Heartbeat(s.stop)
 	go s.aReconciler.Run(s.agent, s.stop)
-	go s.engine.Run(s.engineReconcileInterval, s.stop)
+	go s.health.Run(s.stop)
+	go s.retrier.Run(s.stop)
+	if s.usage != nil {
+		go s.usage.Run(s.usageInterval, s.stop)
+	}
+	if s.logs != nil {
+		go s.logs.Run(s.stop)
+	}
+	if s.maint != nil {
+		go s.maint.Run(s.stop)
+	}
+	go s.engine.Run(s.engineReconcileInterval, s.engineReconcileJitter, s.engineLeaseTTL, s.engineLeaseRenewal, s.stop)
 
 	beatchan := make(chan *unit.UnitStateHeartbeat)
 	go s.usGen.Run(beatchan, s.stop)
 	go s.usPub.Run(beatchan, s.stop)
+
+	s.notifyReady()
+}
+
+// runReadOnly starts only those server components which do not change the
+// registry: the API, the cache backing it and an observing agent. The local
+// machine is never published, so it takes no part in scheduling.
+func (s *Server) runReadOnly() {
+	if s.cfg.DryRun {
+		log.Infof("Starting server components in dry-run mode")
+	} else {
+		log.Infof("Starting server components in read-only mode")
+	}
+
+	s.stop = make(chan bool)
+
+	if s.cache != nil {
+		go s.cache.Run(s.stop)
+	}
+	go s.api.Available(s.stop)
+	go s.mach.PeriodicRefresh(machineStateRefreshInterval, s.stop)
+	go s.aReconciler.Run(s.agent, s.stop)
+
+	s.notifyReady()
 }
 
 // Monitor tracks the health of the Server. If the Server is ever deemed
@@ -191,8 +517,95 @@ func (s *Server) Stop() {
 	close(s.stop)
 }
 
+// reloadable reports whether next differs from cur only in options which
+// Reconfigure applies to a running Server
+func reloadable(cur, next config.Config) bool {
+	next.Verbosity = cur.Verbosity
+	next.RawMetadata = cur.RawMetadata
+	next.RawTaints = cur.RawTaints
+	next.AgentTTL = cur.AgentTTL
+	next.AgentHeartbeatInterval = cur.AgentHeartbeatInterval
+	next.EtcdServers = cur.EtcdServers
+	return reflect.DeepEqual(cur, next)
+}
+
+// Reconfigure applies the given configuration to the running Server without
+// disturbing its units or the presence of its machine in the Registry. The
+// metadata, taints, agent TTL and heartbeat interval and etcd endpoints are
+// applied in place; log verbosity is left to the caller. If any other
+// option has changed, nothing is applied and ErrRestartRequired returned.
+func (s *Server) Reconfigure(cfg config.Config) error {
+	if !reloadable(s.cfg, cfg) {
+		return ErrRestartRequired
+	}
+
+	ttl, ival, err := agentTimings(cfg)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(s.cfg.EtcdServers, cfg.EtcdServers) {
+		if s.endpoints == nil {
+			return ErrRestartRequired
+		}
+		if err := s.endpoints.SetEndpoints(cfg.EtcdServers); err != nil {
+			return err
+		}
+		log.Infof("Using etcd endpoints %v", cfg.EtcdServers)
+	}
+
+	if s.cfg.RawMetadata != cfg.RawMetadata || s.cfg.RawTaints != cfg.RawTaints {
+		s.mach.SetMetadata(cfg.Metadata(), cfg.Taints())
+		log.Infof("Using machine metadata %v and taints %v", cfg.Metadata(), cfg.Taints())
+	}
+
+	prevTTL, prevIval, _ := agentTimings(s.cfg)
+	if ttl != prevTTL || ival != prevIval {
+		// the heartbeat loops only pick up new timings as they start,
+		// so the components are restarted around the same agent, whose
+		// units carry on untouched
+		log.Infof("Restarting heartbeats with agent TTL %v and interval %v", ttl, ival)
+		s.Stop()
+		s.agent.SetTTL(ttl)
+		s.agent.SetHeartbeatInterval(ival)
+		s.usPub.SetTTL(ttl)
+		s.usPub.SetPublishInterval(ival)
+		s.mon = heart.NewMonitor(ttl, ival)
+		s.cfg = cfg
+		s.Run()
+		return nil
+	}
+
+	s.cfg = cfg
+
+	// publish changes to the machine right away rather than waiting for
+	// the next heartbeat
+	if !s.readOnly {
+		if _, err := s.hrt.Beat(s.mon.TTL); err != nil {
+			log.Errorf("Failed publishing machine state: %v", err)
+		}
+	}
+	return nil
+}
+
+// Purge cleans up after the Server as fleetd shuts down. Depending on the
+// shutdown mode, the units of the agent are either stopped and unloaded, or
+// left running along with their state in the Registry, so that a restarted
+// fleetd picks them up again before the engine reschedules them.
 func (s *Server) Purge() {
-	s.aReconciler.Purge(s.agent)
+	if s.readOnly {
+		return
+	}
+
+	if s.shutdownMode == ShutdownModeKeep {
+		log.Infof("Leaving units running on shutdown")
+		s.engine.Purge()
+		return
+	}
+
+	if err := s.aReconciler.Purge(s.agent, s.shutdownTimeout); err != nil {
+		log.Errorf("Failed unloading all units on shutdown: %v", err)
+	}
 	s.usPub.Purge()
 	s.engine.Purge()
 	s.hrt.Clear()
@@ -0,0 +1,70 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/agent"
+	"github.com/coreos/fleet/config"
+)
+
+func TestReloadable(t *testing.T) {
+	cur := config.Config{
+		Verbosity:   0,
+		RawMetadata: "region=us-east",
+		AgentTTL:    "30s",
+		EtcdServers: []string{"http://192.0.2.3:4001"},
+		PublicIP:    "192.0.2.10",
+	}
+
+	tests := []struct {
+		change func(*config.Config)
+		want   bool
+	}{
+		{func(c *config.Config) {}, true},
+		{func(c *config.Config) { c.Verbosity = 1 }, true},
+		{func(c *config.Config) { c.RawMetadata = "region=us-west" }, true},
+		{func(c *config.Config) { c.RawTaints = "dedicated" }, true},
+		{func(c *config.Config) { c.AgentTTL = "60s" }, true},
+		{func(c *config.Config) { c.AgentHeartbeatInterval = "10s" }, true},
+		{func(c *config.Config) { c.EtcdServers = []string{"http://192.0.2.4:4001"} }, true},
+		{func(c *config.Config) { c.PublicIP = "192.0.2.11" }, false},
+		{func(c *config.Config) { c.EngineShards = 2 }, false},
+		{func(c *config.Config) { c.RawMetadata = "region=us-west"; c.ReadOnly = true }, false},
+	}
+
+	for i, tt := range tests {
+		next := cur
+		next.EtcdServers = append([]string(nil), cur.EtcdServers...)
+		tt.change(&next)
+		if got := reloadable(cur, next); got != tt.want {
+			t.Errorf("case %d: expected reloadable=%t, got %t", i, tt.want, got)
+		}
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	s := &Server{aReconciler: agent.NewReconciler(nil, nil)}
+
+	// a reconciler that has never run is stalled
+	if err := s.healthy(time.Now()); err == nil {
+		t.Errorf("expected stalled agent to be unhealthy")
+	}
+	if err := s.healthy(time.Time{}.Add(watchdogStallTimeout)); err != nil {
+		t.Errorf("unexpected error within the stall timeout: %v", err)
+	}
+}
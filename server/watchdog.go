@@ -0,0 +1,75 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/pkg"
+)
+
+const (
+	// watchdogStallTimeout is how long the agent may go without
+	// completing a reconciliation before fleetd is considered wedged
+	watchdogStallTimeout = 2 * time.Minute
+
+	// watchdogRegistryTimeout is how long the agent may be unable to
+	// reach the registry before fleetd is considered wedged
+	watchdogRegistryTimeout = 5 * time.Minute
+)
+
+// notifyReady tells systemd that fleetd is serving and, if systemd watches
+// fleetd, keeps sending it keepalives for as long as the Server is healthy
+func (s *Server) notifyReady() {
+	if _, err := pkg.SdNotify("READY=1"); err != nil {
+		log.Warningf("Failed notifying systemd of readiness: %v", err)
+	}
+	if s.watchdogInterval > 0 {
+		go s.watchdog(s.watchdogInterval/2, s.stop)
+	}
+}
+
+func (s *Server) watchdog(ival time.Duration, stop chan bool) {
+	ticker := time.NewTicker(ival)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := s.healthy(time.Now()); err != nil {
+				log.Errorf("Withholding systemd watchdog keepalive: %v", err)
+				continue
+			}
+			if _, err := pkg.SdNotify("WATCHDOG=1"); err != nil {
+				log.Warningf("Failed sending systemd watchdog keepalive: %v", err)
+			}
+		}
+	}
+}
+
+// healthy returns an error if the agent's reconciliation loop has stalled
+// or the registry has been unreachable for too long
+func (s *Server) healthy(now time.Time) error {
+	if last := s.aReconciler.LastReconciled(); now.Sub(last) > watchdogStallTimeout {
+		return fmt.Errorf("agent has not reconciled its units since %s", last.Format(time.RFC3339))
+	}
+	if since, ok := s.aReconciler.DegradedSince(); ok && now.Sub(since) > watchdogRegistryTimeout {
+		return fmt.Errorf("registry unreachable since %s", since.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sign signs Units with SSH keys and verifies those signatures
+// against a set of trusted public keys, such as an authorized_keys file.
+package sign
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	gossh "github.com/coreos/fleet/Godeps/_workspace/src/golang.org/x/crypto/ssh"
+
+	"github.com/coreos/fleet/unit"
+)
+
+// Signature is a signature of a Unit made with an SSH key
+type Signature struct {
+	// Key is the public key that made the signature, in the SSH wire
+	// format
+	Key    []byte `json:"key"`
+	Format string `json:"format"`
+	Blob   []byte `json:"blob"`
+}
+
+// payload returns what is signed for the Unit of the given name and
+// contents, binding the signature to both
+func payload(name string, uf unit.UnitFile) []byte {
+	return []byte(fmt.Sprintf("fleet-unit-signature-v1\n%s\n%s\n", name, uf.Hash()))
+}
+
+// SignUnit signs the Unit of the given name and contents with each of the
+// given Signers, such as the keys held by an ssh-agent
+func SignUnit(signers []gossh.Signer, name string, uf unit.UnitFile) ([]Signature, error) {
+	if len(signers) == 0 {
+		return nil, errors.New("no keys to sign with")
+	}
+
+	data := payload(name, uf)
+	sigs := make([]Signature, 0, len(signers))
+	for _, s := range signers {
+		sig, err := s.Sign(rand.Reader, data)
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, Signature{
+			Key:    s.PublicKey().Marshal(),
+			Format: sig.Format,
+			Blob:   sig.Blob,
+		})
+	}
+	return sigs, nil
+}
+
+// Verifier checks that Units were signed by a trusted key
+type Verifier struct {
+	keys []gossh.PublicKey
+}
+
+func NewVerifier(keys []gossh.PublicKey) *Verifier {
+	return &Verifier{keys: keys}
+}
+
+// NewVerifierFromAuthorizedKeysFile returns a Verifier trusting the keys
+// listed in the given file, in the format of an authorized_keys file
+func NewVerifierFromAuthorizedKeysFile(path string) (*Verifier, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseAuthorizedKeys(contents)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", path, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", path)
+	}
+	return NewVerifier(keys), nil
+}
+
+func parseAuthorizedKeys(in []byte) ([]gossh.PublicKey, error) {
+	var keys []gossh.PublicKey
+	for _, line := range bytes.Split(in, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, _, _, _, err := gossh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Verify returns an error unless one of the given Signatures of the Unit
+// of the given name and contents was made by a trusted key
+func (v *Verifier) Verify(name string, uf unit.UnitFile, sigs []Signature) error {
+	if len(sigs) == 0 {
+		return errors.New("unit is not signed")
+	}
+
+	data := payload(name, uf)
+	var mismatched bool
+	for _, sig := range sigs {
+		if !v.trusts(sig.Key) {
+			continue
+		}
+		key, err := gossh.ParsePublicKey(sig.Key)
+		if err != nil {
+			continue
+		}
+		if key.Verify(data, &gossh.Signature{Format: sig.Format, Blob: sig.Blob}) == nil {
+			return nil
+		}
+		mismatched = true
+	}
+	if mismatched {
+		return errors.New("signature does not match unit contents")
+	}
+	return errors.New("unit is not signed by a trusted key")
+}
+
+func (v *Verifier) trusts(key []byte) bool {
+	for _, k := range v.keys {
+		if bytes.Equal(k.Marshal(), key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sign
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gossh "github.com/coreos/fleet/Godeps/_workspace/src/golang.org/x/crypto/ssh"
+
+	"github.com/coreos/fleet/unit"
+)
+
+func newSigner(t *testing.T) gossh.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return s
+}
+
+func TestSignAndVerify(t *testing.T) {
+	trusted, untrusted := newSigner(t), newSigner(t)
+	v := NewVerifier([]gossh.PublicKey{trusted.PublicKey()})
+
+	uf, err := unit.NewUnitFile("[Service]\nExecStart=/bin/true")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tampered, err := unit.NewUnitFile("[Service]\nExecStart=/bin/false")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sigs, err := SignUnit([]gossh.Signer{untrusted, trusted}, "foo.service", *uf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	untrustedSigs, err := SignUnit([]gossh.Signer{untrusted}, "foo.service", *uf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		uf   unit.UnitFile
+		sigs []Signature
+		pass bool
+	}{
+		{"foo.service", *uf, sigs, true},
+		{"foo.service", *uf, nil, false},
+		{"foo.service", *uf, untrustedSigs, false},
+		{"foo.service", *tampered, sigs, false},
+		// a signature does not carry over to a Unit of another name
+		{"bar.service", *uf, sigs, false},
+	}
+	for i, tt := range tests {
+		if err := v.Verify(tt.name, tt.uf, tt.sigs); tt.pass != (err == nil) {
+			t.Errorf("case %d: expected to pass=%t, err=%v", i, tt.pass, err)
+		}
+	}
+
+	if _, err := SignUnit(nil, "foo.service", *uf); err == nil {
+		t.Errorf("expected error signing without keys")
+	}
+}
+
+func TestNewVerifierFromAuthorizedKeysFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fleet-sign")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := newSigner(t)
+	path := filepath.Join(dir, "authorized_keys")
+	contents := "# trusted signers\n\n" + string(gossh.MarshalAuthorizedKey(s.PublicKey()))
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	v, err := NewVerifierFromAuthorizedKeysFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	uf, err := unit.NewUnitFile("[Service]\nExecStart=/bin/true")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sigs, _ := SignUnit([]gossh.Signer{s}, "foo.service", *uf)
+	if err := v.Verify("foo.service", *uf, sigs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, bad := range []string{"", "# nothing\n", "ssh-rsa garbage\n"} {
+		if err := ioutil.WriteFile(path, []byte(bad), 0600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := NewVerifierFromAuthorizedKeysFile(path); err == nil {
+			t.Errorf("expected error reading %q", bad)
+		}
+	}
+}
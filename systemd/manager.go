@@ -20,9 +20,12 @@ import (
 	"os"
 	"path"
 	"sync"
+	"time"
 
 	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-systemd/dbus"
+	godbus "github.com/coreos/fleet/Godeps/_workspace/src/github.com/godbus/dbus"
 
+	"github.com/coreos/fleet/job"
 	"github.com/coreos/fleet/log"
 	"github.com/coreos/fleet/pkg"
 	"github.com/coreos/fleet/unit"
@@ -37,7 +40,28 @@ type systemdUnitManager struct {
 	unitsDir string
 
 	hashes map[string]unit.Hash
-	mutex  sync.RWMutex
+	// batch caches whether each Unit runs to completion, as only
+	// those Units need their exit status once inactive
+	batch map[string]bool
+	// changes caches when each Unit last changed state, so that it is
+	// only fetched from systemd when a different state is seen
+	changes map[string]stateChange
+	mutex   sync.RWMutex
+
+	// slice is the slice the Units are placed in through a drop-in
+	// written to dropInDir, if any
+	slice     string
+	dropInDir string
+
+	// user is set if the Units are managed by the user instance of
+	// systemd rather than the system instance
+	user bool
+}
+
+type stateChange struct {
+	activeState string
+	subState    string
+	at          time.Time
 }
 
 func NewSystemdUnitManager(uDir string) (*systemdUnitManager, error) {
@@ -45,7 +69,10 @@ func NewSystemdUnitManager(uDir string) (*systemdUnitManager, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newSystemdUnitManager(systemd, uDir, runtimeUnitsDirectory)
+}
 
+func newSystemdUnitManager(systemd *dbus.Conn, uDir, dropInDir string) (*systemdUnitManager, error) {
 	if err := os.MkdirAll(uDir, os.FileMode(0755)); err != nil {
 		return nil, err
 	}
@@ -56,10 +83,13 @@ func NewSystemdUnitManager(uDir string) (*systemdUnitManager, error) {
 	}
 
 	mgr := systemdUnitManager{
-		systemd:  systemd,
-		unitsDir: uDir,
-		hashes:   hashes,
-		mutex:    sync.RWMutex{},
+		systemd:   systemd,
+		unitsDir:  uDir,
+		hashes:    hashes,
+		batch:     make(map[string]bool),
+		changes:   make(map[string]stateChange),
+		mutex:     sync.RWMutex{},
+		dropInDir: dropInDir,
 	}
 	return &mgr, nil
 }
@@ -108,12 +138,62 @@ func (m *systemdUnitManager) Load(name string, u unit.UnitFile) error {
 		return err
 	}
 	m.hashes[name] = u.Hash()
+	m.batch[name] = isBatch(name, u)
+	if _, err := m.writeSliceDropIn(name, u); err != nil {
+		return err
+	}
 	if m.unitRequiresDaemonReload(name) {
-		return m.daemonReload()
+		if err := m.daemonReload(); err != nil {
+			return err
+		}
 	}
+	m.setResourceControls(name, u)
 	return nil
 }
 
+// setResourceControls enforces the resources reserved by the Cores and
+// Memory options of the given Unit through the CPUShares and MemoryLimit
+// properties of its systemd unit. These are set at runtime, leaving the
+// unit file and thus its Hash untouched.
+func (m *systemdUnitManager) setResourceControls(name string, u unit.UnitFile) {
+	props := resourceProperties(name, u)
+	if len(props) == 0 {
+		return
+	}
+	if err := m.systemd.SetUnitProperties(name, true, props...); err != nil {
+		log.Errorf("Failed setting resource controls of systemd unit %s: %v", name, err)
+	}
+}
+
+// resourceProperties translates the resources reserved by the given Unit
+// into systemd properties, with one core worth the default 1024 CPUShares.
+// Properties the unit file sets explicitly are left alone.
+func resourceProperties(name string, u unit.UnitFile) []dbus.Property {
+	j := job.Job{Name: name, Unit: u}
+	res, err := j.Resources()
+	if err != nil || res.Empty() {
+		return nil
+	}
+
+	explicit := func(key string) bool {
+		for section, options := range u.Contents {
+			if section != "X-Fleet" && len(options[key]) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	var props []dbus.Property
+	if res.Cores > 0 && !explicit("CPUShares") {
+		props = append(props, dbus.Property{Name: "CPUShares", Value: godbus.MakeVariant(uint64(res.Cores * 1024 / 100))})
+	}
+	if res.Memory > 0 && !explicit("MemoryLimit") {
+		props = append(props, dbus.Property{Name: "MemoryLimit", Value: godbus.MakeVariant(uint64(res.Memory) << 20)})
+	}
+	return props
+}
+
 // Unload removes the indicated unit from the filesystem, deletes its
 // associated Hash from the cache, clears its unit status in systemd, and
 // performs a systemd daemon-reload
@@ -121,7 +201,10 @@ func (m *systemdUnitManager) Unload(name string) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	delete(m.hashes, name)
+	delete(m.batch, name)
+	delete(m.changes, name)
 	m.removeUnit(name)
+	m.removeSliceDropIn(name)
 }
 
 // TriggerStart asynchronously starts the unit identified by the given name.
@@ -135,6 +218,17 @@ func (m *systemdUnitManager) TriggerStart(name string) {
 	}
 }
 
+// TriggerRestart asynchronously restarts the unit identified by the given
+// name. This function does not block for the underlying unit to restart.
+func (m *systemdUnitManager) TriggerRestart(name string) {
+	jobID, err := m.systemd.RestartUnit(name, "replace", nil)
+	if err == nil {
+		log.Infof("Triggered systemd unit %s restart: job=%d", name, jobID)
+	} else {
+		log.Errorf("Failed to trigger systemd unit %s restart: %v", name, err)
+	}
+}
+
 // TriggerStop asynchronously starts the unit identified by the given name.
 // This function does not block for the underlying unit to actually stop.
 func (m *systemdUnitManager) TriggerStop(name string) {
@@ -171,9 +265,98 @@ func (m *systemdUnitManager) getUnitState(name string) (*unit.UnitState, error)
 		ActiveState: info["ActiveState"].(string),
 		SubState:    info["SubState"].(string),
 	}
+	m.setExitStatus(name, &us)
+	m.setStateChangeTime(name, &us)
 	return &us, nil
 }
 
+// setExitStatus records the exit status and time of the main process of a
+// service that is no longer running, if it has exited since being loaded,
+// along with the Result of a failed service. Only failed services and
+// inactive batch services are looked at.
+func (m *systemdUnitManager) setExitStatus(name string, us *unit.UnitState) {
+	if path.Ext(name) != ".service" {
+		return
+	}
+	switch us.ActiveState {
+	case "failed":
+	case "inactive":
+		if !m.isBatchUnit(name) {
+			return
+		}
+	default:
+		return
+	}
+
+	props, err := m.systemd.GetUnitTypeProperties(name, "Service")
+	if err != nil {
+		log.Debugf("Failed fetching exit status of %s: %v", name, err)
+		return
+	}
+
+	if us.ActiveState == "failed" {
+		us.Result, _ = props["Result"].(string)
+	}
+
+	ts, _ := props["ExecMainExitTimestamp"].(uint64)
+	if ts == 0 {
+		return
+	}
+	status, _ := props["ExecMainStatus"].(int32)
+
+	exitTime := time.Unix(0, int64(ts)*int64(time.Microsecond))
+	us.ExitStatus = int(status)
+	us.ExitTime = &exitTime
+}
+
+// setStateChangeTime records when the given Unit entered its current
+// state, as last fetched from systemd
+func (m *systemdUnitManager) setStateChangeTime(name string, us *unit.UnitState) {
+	c, ok := m.changes[name]
+	if !ok || c.activeState != us.ActiveState || c.subState != us.SubState {
+		prop, err := m.systemd.GetUnitProperty(name, "StateChangeTimestamp")
+		if err != nil {
+			log.Debugf("Failed fetching state change time of %s: %v", name, err)
+			return
+		}
+		ts, _ := prop.Value.Value().(uint64)
+		if ts == 0 {
+			return
+		}
+		c = stateChange{
+			activeState: us.ActiveState,
+			subState:    us.SubState,
+			at:          time.Unix(0, int64(ts)*int64(time.Microsecond)),
+		}
+		m.changes[name] = c
+	}
+	at := c.at
+	us.StateChangeTime = &at
+}
+
+// isBatchUnit returns whether the named Unit runs to completion. Units
+// loaded before the manager was created are read from disk once.
+func (m *systemdUnitManager) isBatchUnit(name string) bool {
+	if b, ok := m.batch[name]; ok {
+		return b
+	}
+	contents, err := m.readUnit(name)
+	if err != nil {
+		return false
+	}
+	uf, err := unit.NewUnitFile(contents)
+	if err != nil {
+		return false
+	}
+	m.batch[name] = isBatch(name, *uf)
+	return m.batch[name]
+}
+
+func isBatch(name string, u unit.UnitFile) bool {
+	j := job.Job{Name: name, Unit: u}
+	return j.IsBatch()
+}
+
 func (m *systemdUnitManager) readUnit(name string) (string, error) {
 	path := m.getUnitFilePath(name)
 	contents, err := ioutil.ReadFile(path)
@@ -229,6 +412,8 @@ func (m *systemdUnitManager) GetUnitStates(filter pkg.Set) (map[string]*unit.Uni
 			ActiveState: dus.ActiveState,
 			SubState:    dus.SubState,
 		}
+		m.setExitStatus(dus.Name, us)
+		m.setStateChangeTime(dus.Name, us)
 		if h, ok := m.hashes[dus.Name]; ok {
 			us.UnitHash = h.String()
 		}
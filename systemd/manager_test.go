@@ -20,6 +20,8 @@ import (
 	"path"
 	"reflect"
 	"testing"
+
+	"github.com/coreos/fleet/unit"
 )
 
 func TestHashUnitFile(t *testing.T) {
@@ -111,3 +113,166 @@ func TestHashUnitFileDirectory(t *testing.T) {
 		t.Fatalf("hashUnitFileDirectory returned unexpected values: want=%v, got=%v", want, got)
 	}
 }
+
+func TestIsBatchUnit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fleet-testing-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	fixtures := map[string]string{
+		"batch.service": "[X-Fleet]\nBatch=true\n",
+		"cron.service":  "[X-Fleet]\nSchedule=@daily\n",
+		"long.service":  "[Service]\nExecStart=/usr/bin/sleep infinity\n",
+	}
+	for name, contents := range fixtures {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(contents), 0400); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	m := &systemdUnitManager{unitsDir: dir, batch: make(map[string]bool)}
+	want := map[string]bool{
+		"batch.service":   true,
+		"cron.service":    true,
+		"long.service":    false,
+		"missing.service": false,
+	}
+	for name, w := range want {
+		if got := m.isBatchUnit(name); got != w {
+			t.Errorf("isBatchUnit(%q): want=%t, got=%t", name, w, got)
+		}
+	}
+
+	// the result is cached rather than read from disk again
+	os.Remove(path.Join(dir, "batch.service"))
+	if !m.isBatchUnit("batch.service") {
+		t.Errorf("isBatchUnit(%q) was not cached", "batch.service")
+	}
+}
+
+func TestResourceProperties(t *testing.T) {
+	tests := []struct {
+		contents string
+		want     map[string]uint64
+	}{
+		{"[Service]\nExecStart=/usr/bin/sleep infinity\n", map[string]uint64{}},
+		{"[X-Fleet]\nCores=0.5\nMemory=1G\n", map[string]uint64{"CPUShares": 512, "MemoryLimit": 1 << 30}},
+		{"[X-Fleet]\nCores=2\n", map[string]uint64{"CPUShares": 2048}},
+
+		// settings in the unit file take precedence
+		{"[Service]\nMemoryLimit=2G\n[X-Fleet]\nCores=1\nMemory=1G\n", map[string]uint64{"CPUShares": 1024}},
+
+		// invalid reservations are not enforced
+		{"[X-Fleet]\nCores=none\n", map[string]uint64{}},
+	}
+
+	for i, tt := range tests {
+		u, err := unit.NewUnitFile(tt.contents)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		got := make(map[string]uint64)
+		for _, p := range resourceProperties("foo.service", *u) {
+			got[p.Name] = p.Value.Value().(uint64)
+		}
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("case %d: expected properties %v, got %v", i, tt.want, got)
+		}
+	}
+}
+
+func TestValidSlice(t *testing.T) {
+	for slice, valid := range map[string]bool{
+		"fleet.slice":      true,
+		"fleet-apps.slice": true,
+		"fleet":            false,
+		".slice":           false,
+		"foo/bar.slice":    false,
+		"fleet.service":    false,
+	} {
+		if err := ValidSlice(slice); (err == nil) != valid {
+			t.Errorf("slice %q: expected valid=%t, got error %v", slice, valid, err)
+		}
+	}
+}
+
+func TestSliceDropIn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fleet-testing-")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	m := &systemdUnitManager{dropInDir: dir, slice: "fleet.slice"}
+	file := path.Join(dir, "foo.service.d", sliceDropIn)
+
+	plain, _ := unit.NewUnitFile("[Service]\nExecStart=/usr/bin/sleep infinity\n")
+	if changed, err := m.writeSliceDropIn("foo.service", *plain); err != nil || !changed {
+		t.Fatalf("expected drop-in to be written, got changed=%t err=%v", changed, err)
+	}
+	if b, _ := ioutil.ReadFile(file); string(b) != "[Service]\nSlice=fleet.slice\n" {
+		t.Fatalf("unexpected drop-in contents %q", b)
+	}
+	if changed, _ := m.writeSliceDropIn("foo.service", *plain); changed {
+		t.Fatalf("expected unchanged drop-in not to be rewritten")
+	}
+
+	// a unit naming its own slice is left alone
+	own, _ := unit.NewUnitFile("[Service]\nSlice=other.slice\n")
+	if changed, _ := m.writeSliceDropIn("foo.service", *own); !changed {
+		t.Fatalf("expected drop-in to be removed")
+	}
+	if _, err := os.Stat(path.Join(dir, "foo.service.d")); !os.IsNotExist(err) {
+		t.Fatalf("expected drop-in directory to be removed, got %v", err)
+	}
+
+	// as are units that cannot be placed in a slice
+	if changed, _ := m.writeSliceDropIn("foo.timer", *plain); changed {
+		t.Fatalf("expected no drop-in for a timer")
+	}
+
+	// and all units once no slice is configured
+	m.writeSliceDropIn("foo.service", *plain)
+	m.slice = ""
+	if changed, _ := m.writeSliceDropIn("foo.service", *plain); !changed {
+		t.Fatalf("expected drop-in to be removed")
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("expected drop-in to be removed, got %v", err)
+	}
+}
+
+func TestUserBusAddress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fleet-testing-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	defer os.Setenv("DBUS_SESSION_BUS_ADDRESS", os.Getenv("DBUS_SESSION_BUS_ADDRESS"))
+	defer os.Setenv("XDG_RUNTIME_DIR", os.Getenv("XDG_RUNTIME_DIR"))
+
+	os.Setenv("XDG_RUNTIME_DIR", dir)
+	if got := UserUnitsDirectory(); got != path.Join(dir, "fleet", "units")+"/" {
+		t.Errorf("unexpected user units directory %q", got)
+	}
+
+	// autolaunching a session bus would not reach systemd
+	os.Setenv("DBUS_SESSION_BUS_ADDRESS", "autolaunch:")
+	if _, err := userBusAddress(dir); err == nil {
+		t.Errorf("expected error without a user bus")
+	}
+
+	if err := ioutil.WriteFile(path.Join(dir, "bus"), nil, 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if got, err := userBusAddress(dir); err != nil || got != "unix:path="+path.Join(dir, "bus") {
+		t.Errorf("unexpected address %q, error %v", got, err)
+	}
+
+	os.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path=/tmp/elsewhere")
+	if got, err := userBusAddress(dir); err != nil || got != "unix:path=/tmp/elsewhere" {
+		t.Errorf("unexpected address %q, error %v", got, err)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package systemd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/unit"
+)
+
+const (
+	// runtimeUnitsDirectory is where the Units are linked into systemd,
+	// and thus where their drop-ins are written
+	runtimeUnitsDirectory = "/run/systemd/system/"
+
+	// sliceDropIn is the name of the drop-in placing a Unit in the slice
+	sliceDropIn = "50-fleet-slice.conf"
+)
+
+// sliceSections maps the types of units that may be placed in a slice to
+// the section of their unit file holding the Slice option
+var sliceSections = map[string]string{
+	".service": "Service",
+	".socket":  "Socket",
+	".mount":   "Mount",
+	".swap":    "Swap",
+}
+
+// ValidSlice returns an error if the given name is not that of a slice
+func ValidSlice(slice string) error {
+	if !strings.HasSuffix(slice, ".slice") || len(slice) == len(".slice") || strings.Contains(slice, "/") {
+		return fmt.Errorf("invalid slice %q, must be a unit name ending in .slice", slice)
+	}
+	return nil
+}
+
+// SetSlice places the Units loaded from now on in the given slice, or in
+// the default slice of systemd if empty. Units loaded by a previous run of
+// fleetd are moved alike, which for those already running takes effect
+// once they are next restarted, as systemd does not move running units.
+func (m *systemdUnitManager) SetSlice(slice string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.slice = slice
+	var changed []string
+	for name := range m.hashes {
+		contents, err := m.readUnit(name)
+		if err != nil {
+			continue
+		}
+		uf, err := unit.NewUnitFile(contents)
+		if err != nil {
+			continue
+		}
+		ok, err := m.writeSliceDropIn(name, *uf)
+		if err != nil {
+			return err
+		}
+		if ok {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	if err := m.daemonReload(); err != nil {
+		return err
+	}
+	for _, name := range changed {
+		m.logOutsideSlice(name)
+	}
+	return nil
+}
+
+// sliceDropInContents returns the drop-in placing the given Unit in the
+// given slice, or false if the Unit cannot be placed in a slice or names
+// its own slice
+func sliceDropInContents(name string, u unit.UnitFile, slice string) (string, bool) {
+	section, ok := sliceSections[path.Ext(name)]
+	if !ok || slice == "" {
+		return "", false
+	}
+	if len(u.Contents[section]["Slice"]) > 0 {
+		return "", false
+	}
+	return fmt.Sprintf("[%s]\nSlice=%s\n", section, slice), true
+}
+
+// writeSliceDropIn writes, or removes, the drop-in placing the given Unit
+// in the slice of the manager, reporting whether it changed
+func (m *systemdUnitManager) writeSliceDropIn(name string, u unit.UnitFile) (bool, error) {
+	dir := path.Join(m.dropInDir, name+".d")
+	file := path.Join(dir, sliceDropIn)
+	old, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	contents, ok := sliceDropInContents(name, u, m.slice)
+	if !ok {
+		if old == nil {
+			return false, nil
+		}
+		m.removeSliceDropIn(name)
+		return true, nil
+	}
+	if string(old) == contents {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+		return false, err
+	}
+	if err := ioutil.WriteFile(file, []byte(contents), os.FileMode(0644)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// removeSliceDropIn removes the drop-in placing the given Unit in a slice,
+// along with its drop-in directory if that is left empty
+func (m *systemdUnitManager) removeSliceDropIn(name string) {
+	dir := path.Join(m.dropInDir, name+".d")
+	os.Remove(path.Join(dir, sliceDropIn))
+	os.Remove(dir)
+}
+
+// logOutsideSlice logs the given Unit if it runs outside of the slice it
+// was moved to, until it is restarted
+func (m *systemdUnitManager) logOutsideSlice(name string) {
+	prop, err := m.systemd.GetUnitProperty(name, "ControlGroup")
+	if err != nil {
+		return
+	}
+	cg, _ := prop.Value.Value().(string)
+	if cg == "" {
+		return
+	}
+	want := "system.slice"
+	if m.slice != "" {
+		want = m.slice
+	} else if m.user {
+		// the default slice of the user instance varies with the
+		// version of systemd
+		return
+	}
+	if !strings.Contains(cg, "/"+want+"/") {
+		log.Infof("Unit %s runs in cgroup %s, it moves to %s once restarted", name, cg, want)
+	}
+}
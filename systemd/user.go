@@ -0,0 +1,85 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package systemd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-systemd/dbus"
+)
+
+// NewSystemdUserUnitManager creates a UnitManager of the user instance of
+// systemd (systemd --user) of the user running fleetd, keeping the unit
+// files in uDir. The units are linked into, and their drop-ins written to,
+// the runtime unit directory of the user instance.
+func NewSystemdUserUnitManager(uDir string) (*systemdUnitManager, error) {
+	rDir := UserRuntimeDirectory()
+	addr, err := userBusAddress(rDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// the session bus is the only one go-systemd connects to other than
+	// the system bus, and it finds its address in the environment
+	if err := os.Setenv("DBUS_SESSION_BUS_ADDRESS", addr); err != nil {
+		return nil, err
+	}
+	systemd, err := dbus.NewUserConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	mgr, err := newSystemdUnitManager(systemd, uDir, path.Join(rDir, "systemd", "user")+"/")
+	if err != nil {
+		return nil, err
+	}
+	mgr.user = true
+	return mgr, nil
+}
+
+// UserRuntimeDirectory returns the runtime directory of the user running
+// fleetd, as given by XDG_RUNTIME_DIR or else the one systemd-logind
+// creates for the user
+func UserRuntimeDirectory() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir
+	}
+	return path.Join("/run/user", strconv.Itoa(os.Getuid()))
+}
+
+// UserUnitsDirectory returns the directory in which a UnitManager of the
+// user instance of systemd keeps unit files by default
+func UserUnitsDirectory() string {
+	return path.Join(UserRuntimeDirectory(), "fleet", "units") + "/"
+}
+
+// userBusAddress returns the D-Bus address of the user instance of
+// systemd. An address set in DBUS_SESSION_BUS_ADDRESS is used as is, as
+// autolaunching a session bus would not reach systemd; otherwise the user
+// bus in the given runtime directory must exist.
+func userBusAddress(rDir string) (string, error) {
+	if addr := os.Getenv("DBUS_SESSION_BUS_ADDRESS"); addr != "" && addr != "autolaunch:" {
+		return addr, nil
+	}
+
+	sock := path.Join(rDir, "bus")
+	if _, err := os.Stat(sock); err != nil {
+		return "", fmt.Errorf("unable to find user bus of systemd at %s: %v", sock, err)
+	}
+	return "unix:path=" + sock, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit
+
+import (
+	"strings"
+)
+
+// directiveSystemdVersions maps unit file directives introduced by systemd
+// since it was first supported by fleet to the version introducing them.
+// Older versions of systemd ignore unknown directives with a warning, so a
+// unit relying on one for sandboxing or resource control would silently
+// run without it.
+var directiveSystemdVersions = map[string]int{
+	"PrivateDevices":          209,
+	"SystemCallArchitectures": 209,
+	"RestrictAddressFamilies": 211,
+	"RuntimeDirectory":        211,
+	"CPUQuota":                213,
+	"ProtectSystem":           214,
+	"ProtectHome":             214,
+	"Delegate":                218,
+	"TasksMax":                227,
+	"AmbientCapabilities":     229,
+	"RuntimeMaxSec":           229,
+	"IOWeight":                230,
+	"IODeviceWeight":          230,
+	"IOReadBandwidthMax":      230,
+	"IOWriteBandwidthMax":     230,
+	"MemoryMax":               231,
+	"MemoryHigh":              231,
+	"MemoryLow":               231,
+	"MemoryDenyWriteExecute":  231,
+	"RestrictRealtime":        231,
+	"ReadWritePaths":          231,
+	"ReadOnlyPaths":           231,
+	"InaccessiblePaths":       231,
+	"ProtectKernelTunables":   232,
+	"ProtectKernelModules":    232,
+	"ProtectControlGroups":    232,
+	"DynamicUser":             232,
+	"PrivateUsers":            232,
+	"RemoveIPC":               232,
+	"RestrictNamespaces":      233,
+	"BindPaths":               233,
+	"BindReadOnlyPaths":       233,
+	"LockPersonality":         235,
+	"StateDirectory":          235,
+	"CacheDirectory":          235,
+	"LogsDirectory":           235,
+	"ConfigurationDirectory":  235,
+	"IPAddressAllow":          235,
+	"IPAddressDeny":           235,
+	"MemoryMin":               240,
+	"ProtectHostname":         242,
+	"ExecCondition":           243,
+	"OOMPolicy":               243,
+	"ProtectKernelLogs":       244,
+	"ProtectClock":            245,
+}
+
+// RequiredSystemdVersion returns the version of systemd required by the
+// given directive, or 0 if any version supported by fleet understands it
+func RequiredSystemdVersion(directive string) int {
+	return directiveSystemdVersions[directive]
+}
+
+// UnsupportedDirective returns the first directive of the UnitFile that the
+// given version of systemd does not understand, if any. The X-Fleet
+// section and other extension sections are not considered.
+func (u *UnitFile) UnsupportedDirective(systemdVersion int) (string, bool) {
+	for _, opt := range u.Options {
+		if strings.HasPrefix(opt.Section, "X-") {
+			continue
+		}
+		if v := directiveSystemdVersions[opt.Name]; v > systemdVersion {
+			return opt.Name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,47 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unit
+
+import (
+	"testing"
+)
+
+func TestUnsupportedDirective(t *testing.T) {
+	uf, err := NewUnitFile(`[Service]
+ExecStart=/bin/true
+ProtectSystem=full
+ProtectKernelTunables=true
+
+[X-Fleet]
+ProtectClock=true
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i, tt := range []struct {
+		version int
+		want    string
+	}{
+		{209, "ProtectSystem"},
+		{215, "ProtectKernelTunables"},
+		{232, ""},
+	} {
+		got, ok := uf.UnsupportedDirective(tt.version)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("case %d: expected %q, got %q (%t)", i, tt.want, got, ok)
+		}
+	}
+}
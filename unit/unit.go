@@ -20,8 +20,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"strings"
+	"time"
 
 	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-systemd/unit"
+
+	"github.com/coreos/fleet/resource"
 )
 
 func NewUnitFile(raw string) (*UnitFile, error) {
@@ -121,6 +124,19 @@ func (u *UnitFile) Description() string {
 	return ""
 }
 
+// Dependencies returns the names of the units listed by the After and
+// Requires options of the [Unit] section, each of which may list several
+// separated by spaces.
+func (u *UnitFile) Dependencies() []string {
+	var deps []string
+	for _, opt := range []string{"After", "Requires"} {
+		for _, v := range u.Contents["Unit"][opt] {
+			deps = append(deps, strings.Fields(v)...)
+		}
+	}
+	return deps
+}
+
 func (u *UnitFile) Bytes() []byte {
 	b, _ := ioutil.ReadAll(unit.Serialize(u.Options))
 	return b
@@ -177,6 +193,28 @@ type UnitState struct {
 	MachineID   string
 	UnitHash    string
 	UnitName    string
+
+	// ExitStatus and ExitTime describe the most recent exit of the main
+	// process of a service that is no longer running. ExitTime is nil
+	// if the process has not exited since the unit was loaded.
+	ExitStatus int        `json:",omitempty"`
+	ExitTime   *time.Time `json:",omitempty"`
+
+	// Result is the reason systemd gives for a failed service, such as
+	// exit-code, signal, timeout or core-dump
+	Result string `json:",omitempty"`
+
+	// StateChangeTime is when the Unit entered its current ActiveState
+	// and SubState
+	StateChangeTime *time.Time `json:",omitempty"`
+
+	// FailureReason explains why the Unit failed, when the agent rather
+	// than systemd found it unable to run
+	FailureReason string `json:",omitempty"`
+
+	// Usage is the CPU and memory the Unit was last found using, if
+	// the agent samples it
+	Usage *resource.ResourceTuple `json:",omitempty"`
 }
 
 func NewUnitState(loadState, activeState, subState, mID string) *UnitState {
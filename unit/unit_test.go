@@ -101,7 +101,7 @@ func TestNewUnitState(t *testing.T) {
 
 	got := NewUnitState("ls", "as", "ss", "id")
 	if !reflect.DeepEqual(got, want) {
-		t.Fatalf("NewUnitState did not create a correct UnitState: got %s, want %s", got, want)
+		t.Fatalf("NewUnitState did not create a correct UnitState: got %v, want %v", got, want)
 	}
 
 }
@@ -18,7 +18,7 @@ import (
 	"github.com/coreos/fleet/Godeps/_workspace/src/github.com/coreos/go-semver/semver"
 )
 
-const Version = "0.9.0+git"
+const Version = "0.10.0+git"
 
 var SemVersion semver.Version
 